@@ -6,6 +6,7 @@
 package adminport
 
 import "errors"
+import "net/http"
 import c "github.com/couchbase/indexing/secondary/common"
 
 // errors codes
@@ -72,6 +73,10 @@ type Server interface {
 	// Unregister a previously registered request message
 	Unregister(msg MessageMarshaller) error
 
+	// RegisterHTTPHandler to serve `path` outside the request/response
+	// protocol, like for streaming responses.
+	RegisterHTTPHandler(path string, handler http.HandlerFunc) error
+
 	// Start server routine and wait for incoming request, Register() and
 	// Unregister() APIs cannot be called after starting the server.
 	Start() error
@@ -23,9 +23,21 @@
 //      }
 //      server.Stop()
 // }
+//
+// JSON gateway:
+//
+// Requests posted with content-type `application/json` are decoded from,
+// and responded with, JSON mapping of the registered message, so that
+// adminport can be driven with curl and scripts, like,
+//
+//      curl -H "Content-Type: application/json" \
+//           -d '{"pool": "default", "bucket": "default",
+//                "kvaddrs": ["127.0.0.1:12000"]}' \
+//           http://localhost:9999/adminport/vbmapRequest
 
 package adminport
 
+import "encoding/json"
 import "fmt"
 import "expvar"
 import "runtime/debug"
@@ -33,6 +45,7 @@ import "io"
 import "net"
 import "net/http"
 import "reflect"
+import "strings"
 import "sync"
 import "time"
 
@@ -44,6 +57,7 @@ type httpServer struct {
 	mu       sync.Mutex   // handle concurrent updates to this object
 	lis      net.Listener // TCP listener
 	srv      *http.Server // http server
+	mux      *http.ServeMux
 	messages map[string]MessageMarshaller
 	conns    []net.Conn
 	reqch    chan<- Request // request channel back to application
@@ -83,12 +97,12 @@ func NewHTTPServer(config c.Config, reqch chan<- Request) Server {
 	}
 	s.logPrefix = fmt.Sprintf("%s[%s]", s.name, s.laddr)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc(s.urlPrefix, s.systemHandler)
-	mux.HandleFunc("/debug/vars", s.expvarHandler)
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc(s.urlPrefix, s.systemHandler)
+	s.mux.HandleFunc("/debug/vars", s.expvarHandler)
 	s.srv = &http.Server{
 		Addr:           s.laddr,
-		Handler:        mux,
+		Handler:        s.mux,
 		ConnState:      s.connState,
 		ReadTimeout:    s.rtimeout * time.Millisecond,
 		WriteTimeout:   s.wtimeout * time.Millisecond,
@@ -132,6 +146,22 @@ func (s *httpServer) Unregister(msg MessageMarshaller) (err error) {
 	return
 }
 
+// RegisterHTTPHandler is part of Server interface.
+func (s *httpServer) RegisterHTTPHandler(
+	path string, handler http.HandlerFunc) (err error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lis != nil {
+		c.Errorf("%v can't register, server already started\n", s.logPrefix)
+		return ErrorRegisteringRequest
+	}
+	s.mux.HandleFunc(path, handler)
+	c.Infof("%s registered handler %s\n", s.logPrefix, path)
+	return
+}
+
 // GetStatistics for adminport daemon
 func (s *httpServer) GetStatistics() c.Statistics {
 	s.mu.Lock()
@@ -250,7 +280,13 @@ func (s *httpServer) systemHandler(w http.ResponseWriter, r *http.Request) {
 	// Get an instance of request type and decode request into that.
 	typeOfMsg := reflect.ValueOf(msg).Elem().Type()
 	msg = reflect.New(typeOfMsg).Interface().(MessageMarshaller)
-	if err = msg.Decode(dataIn); err != nil {
+	isJSON := isJSONRequest(r)
+	if isJSON {
+		err = decodeJSON(dataIn, msg)
+	} else {
+		err = msg.Decode(dataIn)
+	}
+	if err != nil {
 		err = fmt.Errorf("%v, %v", ErrorDecodeRequest, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -263,7 +299,17 @@ func (s *httpServer) systemHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch v := (val).(type) {
 	case MessageMarshaller:
-		if dataOut, err = v.Encode(); err == nil {
+		if isJSON {
+			if dataOut, err = json.Marshal(v); err == nil {
+				header := w.Header()
+				header["Content-Type"] = []string{"application/json"}
+				w.Write(dataOut)
+			} else {
+				err = fmt.Errorf("%v, %v", ErrorEncodeResponse, err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+
+		} else if dataOut, err = v.Encode(); err == nil {
 			header := w.Header()
 			header["Content-Type"] = []string{v.ContentType()}
 			w.Write(dataOut)
@@ -310,6 +356,20 @@ func (s *httpServer) getURL(msg MessageMarshaller) string {
 	return s.urlPrefix + msg.Name()
 }
 
+// isJSONRequest returns whether request shall be handled by JSON gateway.
+func isJSONRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+}
+
+// decodeJSON decodes JSON mapping of a message, like protobuf messages
+// whose generated fields carry json tags, empty body is an empty message.
+func decodeJSON(data []byte, msg MessageMarshaller) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, msg)
+}
+
 func requestRead(r io.Reader, data []byte) (err error) {
 	var c int
 
@@ -1,7 +1,10 @@
 package adminport
 
+import "bytes"
 import "encoding/json"
+import "io/ioutil"
 import "log"
+import "net/http"
 import "reflect"
 import "testing"
 
@@ -60,6 +63,34 @@ func TestLoopback(t *testing.T) {
 	}
 }
 
+func TestJSONGateway(t *testing.T) {
+	common.LogIgnore()
+
+	urlPrefix := common.SystemConfig["projector.adminport.urlPrefix"].String()
+	url := "http://" + addr + urlPrefix + "testMessage"
+	body := []byte(`{"defnId": 10, "bucket": "default", "name": "idx"}`)
+	htresp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer htresp.Body.Close()
+	if ct := htresp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content-type %q", ct)
+	}
+	data, err := ioutil.ReadAll(htresp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &testMessage{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		t.Fatal(err)
+	}
+	ref := &testMessage{DefnID: 10, Bucket: "default", IName: "idx"}
+	if reflect.DeepEqual(ref, resp) == false {
+		t.Errorf("unexpected response %v", resp)
+	}
+}
+
 func BenchmarkClientRequest(b *testing.B) {
 	urlPrefix := common.SystemConfig["projector.adminport.urlPrefix"].String()
 	client := NewHTTPClient(addr, urlPrefix)
@@ -0,0 +1,345 @@
+// Package client provides a high level API for Go applications to manage
+// and scan secondary indexes, without assembling metadata-provider and
+// queryport clients themselves.
+//
+//	cluster, err := client.Connect("localhost:9000")
+//	idx, err := cluster.CreateIndex(client.IndexSpec{
+//		Name: "idx_age", Bucket: "default", SecExprs: []string{"`age`"},
+//	})
+//	err = idx.WaitOnline(time.Minute)
+//	err = idx.Scan(client.Range{Low: low, High: high, Inclusion: client.Both},
+//		client.AnyConsistency, func(entry client.Entry) bool {
+//			fmt.Println(entry.Docid, entry.Key)
+//			return true // continue scan
+//		})
+//	err = idx.Drop()
+//	cluster.Close()
+package client
+
+import "encoding/json"
+import "errors"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/common"
+import mclient "github.com/couchbase/indexing/secondary/manager/client"
+import qclient "github.com/couchbase/indexing/secondary/queryport/client"
+
+// ErrorTimeout is returned when an index does not come online in time.
+var ErrorTimeout = errors.New("client.timeout")
+
+// ErrorIndexNotFound is returned when looking up an index that does not
+// exist.
+var ErrorIndexNotFound = errors.New("client.indexNotFound")
+
+// ErrorInvalidSpec is returned for an index specification that lacks name,
+// bucket or keys.
+var ErrorInvalidSpec = errors.New("client.invalidSpec")
+
+// ErrorScanKilled is returned by a scan that was killed on the indexer.
+var ErrorScanKilled = qclient.ErrorScanKilled
+
+// Inclusion specifier for range scans.
+type Inclusion uint32
+
+const (
+	// Neither does not include low-key and high-key
+	Neither = Inclusion(qclient.Neither)
+	// Low includes low-key but does not include high-key
+	Low = Inclusion(qclient.Low)
+	// High includes high-key but does not include low-key
+	High = Inclusion(qclient.High)
+	// Both includes both low-key and high-key
+	Both = Inclusion(qclient.Both)
+)
+
+// Consistency of a scan with respect to the state of the index.
+type Consistency int
+
+const (
+	// AnyConsistency scans the latest snapshot available on the indexer,
+	// failing if the index is not online yet.
+	AnyConsistency Consistency = iota
+	// OnlineConsistency waits for the index to come online, upto the
+	// cluster's wait timeout, before scanning.
+	OnlineConsistency
+)
+
+// IndexSpec describes an index to be created.
+type IndexSpec struct {
+	Name   string
+	Bucket string
+	// SecExprs are N1QL expressions emitting the secondary keys, ignored
+	// for primary index.
+	SecExprs []string
+	// Where is an optional N1QL predicate on documents to index.
+	Where     string
+	IsPrimary bool
+	// Include lists document fields to be stored along with each entry.
+	Include []string
+	// Deferred indexes are created without being built, use Build().
+	Deferred bool
+	// Node optionally pins the index to an indexer node.
+	Node string
+	// Collation of string keys, common.CollationBinary by default.
+	Collation string
+}
+
+// with marshals the plan of index for CreateIndex(), nil if spec does
+// not need one.
+func (spec IndexSpec) with() ([]byte, error) {
+	plan := make(map[string]interface{})
+	if spec.Deferred {
+		plan["defer_build"] = true
+	}
+	if len(spec.Include) > 0 {
+		plan["include"] = spec.Include
+	}
+	if spec.Node != "" {
+		plan["nodes"] = []string{spec.Node}
+	}
+	if spec.Collation != "" {
+		plan["collation"] = spec.Collation
+	}
+	if len(plan) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(plan)
+}
+
+// Range of a scan. Zero value scans the whole index, Equals, if set, looks
+// up entries with any of the given keys, else the scan is bounded by Low
+// and High.
+type Range struct {
+	Low       common.SecondaryKey
+	High      common.SecondaryKey
+	Inclusion Inclusion
+	Equals    []common.SecondaryKey
+	Distinct  bool
+	Limit     int64 // 0 is no limit
+}
+
+func (r Range) isAll() bool {
+	return r.Low == nil && r.High == nil && r.Equals == nil
+}
+
+// Entry returned by a scan.
+type Entry struct {
+	Key       common.SecondaryKey
+	Docid     []byte
+	Projected common.SecondaryKey // included fields, if any
+}
+
+// ScanHandler is called for every entry returned by a scan, it shall
+// return false to stop the scan.
+type ScanHandler func(entry Entry) bool
+
+// Cluster is a connection to the indexers of a couchbase cluster.
+type Cluster struct {
+	client      *qclient.GsiClient
+	waitTimeout time.Duration
+}
+
+// Connect to the cluster at `cluster`, address of one of its nodes,
+// using default queryport client configuration.
+func Connect(cluster string) (*Cluster, error) {
+	config := common.SystemConfig.SectionConfig("queryport.client.", true)
+	return ConnectWithConfig(cluster, config)
+}
+
+// ConnectWithConfig connects to cluster with queryport client
+// configuration `config`.
+func ConnectWithConfig(cluster string, config common.Config) (*Cluster, error) {
+	c, err := qclient.NewGsiClient(cluster, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Cluster{
+		client:      c,
+		waitTimeout: 5 * time.Minute,
+	}, nil
+}
+
+// SetWaitTimeout sets how long scans with OnlineConsistency wait for the
+// index to come online.
+func (c *Cluster) SetWaitTimeout(timeout time.Duration) {
+	c.waitTimeout = timeout
+}
+
+// GsiClient returns the underlying queryport client, for operations not
+// covered by this package.
+func (c *Cluster) GsiClient() *qclient.GsiClient {
+	return c.client
+}
+
+// CreateIndex as per `spec`. Unless the index is deferred its build is
+// kicked off, use WaitOnline() to wait for it to complete.
+func (c *Cluster) CreateIndex(spec IndexSpec) (*Index, error) {
+	if spec.Name == "" || spec.Bucket == "" ||
+		(!spec.IsPrimary && len(spec.SecExprs) == 0) {
+		return nil, ErrorInvalidSpec
+	}
+	with, err := spec.with()
+	if err != nil {
+		return nil, err
+	}
+
+	var secExprs []string
+	if !spec.IsPrimary {
+		secExprs = spec.SecExprs
+	}
+	defnID, err := c.client.CreateIndex(
+		spec.Name, spec.Bucket, "gsi", "N1QL", "", spec.Where,
+		secExprs, spec.IsPrimary, with)
+	if err != nil {
+		return nil, err
+	}
+	return c.newIndex(defnID, spec.Name, spec.Bucket), nil
+}
+
+// Index returns index `name` defined on `bucket`.
+func (c *Cluster) Index(bucket, name string) (*Index, error) {
+	indexes, err := c.Indexes()
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		if index.Bucket == bucket && index.Name == name {
+			return index, nil
+		}
+	}
+	return nil, ErrorIndexNotFound
+}
+
+// Indexes returns all indexes in the cluster.
+func (c *Cluster) Indexes() ([]*Index, error) {
+	metas, err := c.client.Refresh()
+	if err != nil {
+		return nil, err
+	}
+	indexes := make([]*Index, 0, len(metas))
+	for _, meta := range metas {
+		defn := meta.Definition
+		index := c.newIndex(uint64(defn.DefnId), defn.Name, defn.Bucket)
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// Close the connection with cluster.
+func (c *Cluster) Close() {
+	c.client.Close()
+}
+
+func (c *Cluster) newIndex(defnID uint64, name, bucket string) *Index {
+	return &Index{cluster: c, DefnID: defnID, Name: name, Bucket: bucket}
+}
+
+// Index is a handle on an index defined in the cluster.
+type Index struct {
+	cluster *Cluster
+	DefnID  uint64
+	Name    string
+	Bucket  string
+}
+
+// State returns the current state of index.
+func (idx *Index) State() (common.IndexState, error) {
+	return idx.cluster.client.IndexState(idx.DefnID)
+}
+
+// Build a deferred index, use WaitOnline() to wait for it to complete.
+func (idx *Index) Build() error {
+	return idx.cluster.client.BuildIndexes([]uint64{idx.DefnID})
+}
+
+// WaitOnline waits for index to be built and ready for scans, woken up
+// by metadata changes received from indexers. Returns ErrorTimeout if
+// index is not online within `timeout`, zero waits forever.
+func (idx *Index) WaitOnline(timeout time.Duration) error {
+	if state, err := idx.State(); err == nil && state.IsScannable() {
+		return nil
+	}
+	err := idx.cluster.client.WaitForIndexOnline(
+		[]uint64{idx.DefnID}, timeout, nil)
+	if common.IsError(err, mclient.ErrIndexWaitTimeout) {
+		return ErrorTimeout
+	}
+	return err
+}
+
+// Scan index entries in range `r`, calling `callb` for every entry.
+// Returns when all entries are scanned, `callb` returns false or the
+// scan fails.
+func (idx *Index) Scan(r Range, cons Consistency, callb ScanHandler) error {
+	if cons == OnlineConsistency {
+		if err := idx.WaitOnline(idx.cluster.waitTimeout); err != nil {
+			return err
+		}
+	}
+
+	var scanErr error
+	handler := entryHandler(callb, &scanErr)
+
+	var err error
+	client := idx.cluster.client
+	switch {
+	case r.Equals != nil:
+		err = client.Lookup(idx.DefnID, r.Equals, r.Distinct, r.Limit, handler)
+	case r.isAll():
+		err = client.ScanAll(idx.DefnID, r.Limit, handler)
+	default:
+		incl := qclient.Inclusion(r.Inclusion)
+		err = client.Range(
+			idx.DefnID, r.Low, r.High, incl, r.Distinct, r.Limit, handler)
+	}
+	if err != nil {
+		return err
+	}
+	return scanErr
+}
+
+// entryHandler returns a queryport response handler calling `callb` for
+// every entry, error in response is recorded in `scanErr`.
+func entryHandler(
+	callb ScanHandler, scanErr *error) qclient.ResponseHandler {
+
+	return func(resp qclient.ResponseReader) bool {
+		if *scanErr = resp.Error(); *scanErr != nil {
+			return false
+		}
+		skeys, pkeys, err := resp.GetEntries()
+		if err != nil {
+			*scanErr = err
+			return false
+		}
+		projected, err := resp.GetProjectedValues()
+		if err != nil {
+			*scanErr = err
+			return false
+		}
+		for i, skey := range skeys {
+			entry := Entry{Key: skey, Docid: pkeys[i]}
+			if i < len(projected) {
+				entry.Projected = projected[i]
+			}
+			if !callb(entry) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Count entries in range `r`, Distinct and Limit are ignored.
+func (idx *Index) Count(r Range) (int64, error) {
+	if r.Equals != nil {
+		return idx.cluster.client.CountLookup(idx.DefnID, r.Equals)
+	}
+	incl := qclient.Inclusion(r.Inclusion)
+	return idx.cluster.client.CountRange(idx.DefnID, r.Low, r.High, incl)
+}
+
+// Drop index.
+func (idx *Index) Drop() error {
+	return idx.cluster.client.DropIndex(idx.DefnID)
+}
@@ -0,0 +1,123 @@
+package client
+
+import "encoding/json"
+import "errors"
+import "reflect"
+import "testing"
+
+import "github.com/couchbase/indexing/secondary/common"
+
+func TestIndexSpec(t *testing.T) {
+	cluster := &Cluster{}
+	specs := []IndexSpec{
+		{Bucket: "default", SecExprs: []string{"`age`"}},
+		{Name: "idx_age", SecExprs: []string{"`age`"}},
+		{Name: "idx_age", Bucket: "default"},
+	}
+	for _, spec := range specs {
+		if _, err := cluster.CreateIndex(spec); err != ErrorInvalidSpec {
+			t.Errorf("%+v: expected %v, got %v", spec, ErrorInvalidSpec, err)
+		}
+	}
+
+	spec := IndexSpec{Name: "idx_age", Bucket: "default", SecExprs: []string{"`age`"}}
+	if with, err := spec.with(); err != nil || with != nil {
+		t.Fatalf("expected no plan, got %s %v", with, err)
+	}
+	spec.Deferred, spec.Node = true, "node1:9100"
+	spec.Include, spec.Collation = []string{"`name`"}, common.CollationCaseInsensitive
+	with, err := spec.with()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := make(map[string]interface{})
+	if err := json.Unmarshal(with, &plan); err != nil {
+		t.Fatal(err)
+	}
+	ref := map[string]interface{}{
+		"defer_build": true,
+		"nodes":       []interface{}{"node1:9100"},
+		"include":     []interface{}{"`name`"},
+		"collation":   common.CollationCaseInsensitive,
+	}
+	if !reflect.DeepEqual(plan, ref) {
+		t.Fatalf("expected %v, got %v", ref, plan)
+	}
+}
+
+// fakeResponse returned by queryport for a scan.
+type fakeResponse struct {
+	skeys     []common.SecondaryKey
+	pkeys     [][]byte
+	projected []common.SecondaryKey
+	err       error
+}
+
+func (r *fakeResponse) GetEntries() ([]common.SecondaryKey, [][]byte, error) {
+	return r.skeys, r.pkeys, nil
+}
+
+func (r *fakeResponse) GetProjectedValues() ([]common.SecondaryKey, error) {
+	return r.projected, nil
+}
+
+func (r *fakeResponse) GetGroups() ([]common.SecondaryKey, []common.SecondaryKey, error) {
+	return nil, nil, nil
+}
+
+func (r *fakeResponse) GetCursor() []byte {
+	return nil
+}
+
+func (r *fakeResponse) GetSnapshotTs() *common.TsVbuuid {
+	return nil
+}
+
+func (r *fakeResponse) Error() error {
+	return r.err
+}
+
+func TestEntryHandler(t *testing.T) {
+	resp := &fakeResponse{
+		skeys:     []common.SecondaryKey{{10.0}, {20.0}},
+		pkeys:     [][]byte{[]byte("doc1"), []byte("doc2")},
+		projected: []common.SecondaryKey{{"x"}, {"y"}},
+	}
+
+	var scanErr error
+	entries := make([]Entry, 0)
+	handler := entryHandler(func(entry Entry) bool {
+		entries = append(entries, entry)
+		return true
+	}, &scanErr)
+	if !handler(resp) || scanErr != nil {
+		t.Fatalf("expected scan to continue, got %v", scanErr)
+	}
+	ref := []Entry{
+		{Key: common.SecondaryKey{10.0}, Docid: []byte("doc1"), Projected: common.SecondaryKey{"x"}},
+		{Key: common.SecondaryKey{20.0}, Docid: []byte("doc2"), Projected: common.SecondaryKey{"y"}},
+	}
+	if !reflect.DeepEqual(entries, ref) {
+		t.Fatalf("expected %v, got %v", ref, entries)
+	}
+
+	// callback stops the scan.
+	count := 0
+	handler = entryHandler(func(entry Entry) bool {
+		count++
+		return false
+	}, &scanErr)
+	if handler(resp) || count != 1 {
+		t.Fatalf("expected scan to stop after first entry, got %v", count)
+	}
+
+	// error in response fails the scan.
+	resp.err = errors.New("scan failed")
+	handler = entryHandler(func(entry Entry) bool {
+		t.Fatalf("unexpected entry %v", entry)
+		return true
+	}, &scanErr)
+	if handler(resp) || scanErr != resp.err {
+		t.Fatalf("expected %v, got %v", resp.err, scanErr)
+	}
+}
@@ -37,6 +37,9 @@ var ErrorNumberType = errors.New("collatejson.numberType")
 // ErrorOutputLen means output buffer has insufficient length.
 var ErrorOutputLen = errors.New("collatejson.outputLen")
 
+// ErrorMalformedCode means binary representation cannot be parsed.
+var ErrorMalformedCode = errors.New("collatejson.malformedCode")
+
 // Length is an internal type used for prefixing length
 // of arrays and properties.
 type Length int64
@@ -152,6 +155,89 @@ func (codec *Codec) Decode(code, text []byte) ([]byte, error) {
 	return text, err
 }
 
+// Explode an encoded array into the binary representation of each of its
+// elements, without decoding them. Elements are appended to `items` and
+// slice the input `code`. Encoded elements compare the same way as the
+// elements they encode.
+func (codec *Codec) Explode(code []byte, items [][]byte) ([][]byte, error) {
+	if len(code) == 0 || code[0] != TypeArray {
+		return nil, ErrorMalformedCode
+	}
+	code = code[1:]
+	if codec.arrayLenPrefix {
+		n, err := codec.datumLen(code)
+		if err != nil {
+			return nil, err
+		}
+		code = code[n:]
+	}
+	for len(code) > 0 && code[0] != Terminator {
+		n, err := codec.datumLen(code)
+		if err != nil {
+			return nil, err
+		}
+		items, code = append(items, code[:n]), code[n:]
+	}
+	if len(code) == 0 {
+		return nil, ErrorMalformedCode
+	}
+	return items, nil
+}
+
+// local function that returns the length of the binary representation
+// of the first datum in code, including its Terminator.
+func (codec *Codec) datumLen(code []byte) (int, error) {
+	if len(code) == 0 {
+		return 0, ErrorMalformedCode
+	}
+
+	switch code[0] {
+	case TypeMissing, TypeNull, TypeTrue, TypeFalse, TypeNumber, TypeLength:
+		if i := bytes.IndexByte(code, Terminator); i >= 0 {
+			return i + 1, nil
+		}
+
+	case TypeString:
+		// Terminator in the string is escaped as Terminator,1
+		for i := 1; i+1 < len(code); i++ {
+			if code[i] != Terminator {
+				continue
+			}
+			switch code[i+1] {
+			case Terminator:
+				return i + 2, nil
+			case 1:
+				i++
+			default:
+				return 0, ErrorMalformedCode
+			}
+		}
+
+	case TypeArray, TypeObj:
+		i := 1
+		if (code[0] == TypeArray && codec.arrayLenPrefix) ||
+			(code[0] == TypeObj && codec.propertyLenPrefix) {
+			n, err := codec.datumLen(code[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+		// property names and values are skipped alike
+		for i < len(code) && code[i] != Terminator {
+			n, err := codec.datumLen(code[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+		if i < len(code) {
+			return i + 1, nil
+		}
+	}
+	return 0, ErrorMalformedCode
+}
+
 // local function that encodes basic json types to binary representation.
 // composite types recursively call this function.
 func (codec *Codec) json2code(val interface{}, code []byte) ([]byte, error) {
@@ -170,6 +170,48 @@ func TestCodecNoLength(t *testing.T) {
 	}
 }
 
+func TestExplode(t *testing.T) {
+	elements := []string{
+		`null`, `true`, `false`, `-10.5`, `"a\u0000b"`, `""`,
+		`[1,["x"],{}]`, `{"key":[null],"z":{"k":"v"}}`, `"docid"`,
+	}
+	sample := "[" + strings.Join(elements, ",") + "]"
+	for _, arrayLen := range []bool{false, true} {
+		codec := NewCodec(128)
+		codec.SortbyArrayLen(arrayLen)
+
+		code, err := codec.Encode([]byte(sample), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, err := codec.Explode(code, nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(items) != len(elements) {
+			t.Fatalf("expected %v elements, got %v", len(elements), len(items))
+		}
+		for i, element := range elements {
+			ref, err := codec.Encode([]byte(element), make([]byte, 0, 1024))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Compare(items[i], ref) != 0 {
+				t.Errorf("element %v: expected %q, got %q", element, ref, items[i])
+			}
+		}
+
+		if _, err := codec.Explode(code[:len(code)-1], nil); err != ErrorMalformedCode {
+			t.Errorf("expected %v for truncated code, got %v", ErrorMalformedCode, err)
+		}
+	}
+
+	codec := NewCodec(128)
+	code, _ := codec.Encode([]byte(`{"key":1}`), make([]byte, 0, 1024))
+	if _, err := codec.Explode(code, nil); err != ErrorMalformedCode {
+		t.Errorf("expected %v for object, got %v", ErrorMalformedCode, err)
+	}
+}
+
 func TestCodecJSON(t *testing.T) {
 	codec := NewCodec(128)
 	codec.SortbyArrayLen(true)
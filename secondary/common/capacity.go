@@ -0,0 +1,45 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+// IndexerCapacity is the aggregate resource usage of an indexer node,
+// reported by indexer's http port under /stats/capacity and used to
+// place new indexes on the least loaded node.
+type IndexerCapacity struct {
+	MemoryQuota uint64 `json:"memoryQuota"` // 0 when not configured
+	MemoryUsed  uint64 `json:"memoryUsed"`
+	DiskUsed    uint64 `json:"diskUsed"`   // disk used by all indexes
+	NumIndexes  int    `json:"numIndexes"` // index instances on node
+	BuildSlots  int    `json:"buildSlots"` // index builds that can start
+}
+
+// MemoryLoad returns the fraction of memory quota in use, if memory
+// quota is not configured, memory used is returned as is.
+func (c *IndexerCapacity) MemoryLoad() float64 {
+	if c.MemoryQuota == 0 {
+		return float64(c.MemoryUsed)
+	}
+	return float64(c.MemoryUsed) / float64(c.MemoryQuota)
+}
+
+// LessLoaded tells whether a new index is better placed on node with
+// capacity `c` than on node with capacity `other`. Nodes with build slots
+// available are preferred, followed by memory and then disk usage.
+func (c *IndexerCapacity) LessLoaded(other *IndexerCapacity) bool {
+	if (c.BuildSlots > 0) != (other.BuildSlots > 0) {
+		return c.BuildSlots > 0
+	}
+	if (c.MemoryQuota == 0) == (other.MemoryQuota == 0) {
+		if cl, ol := c.MemoryLoad(), other.MemoryLoad(); cl != ol {
+			return cl < ol
+		}
+	}
+	return c.DiskUsed < other.DiskUsed
+}
@@ -0,0 +1,24 @@
+package common
+
+import "testing"
+
+func TestIndexerCapacityLessLoaded(t *testing.T) {
+	idle := &IndexerCapacity{MemoryQuota: 100, MemoryUsed: 10, BuildSlots: 2}
+	busy := &IndexerCapacity{MemoryQuota: 100, MemoryUsed: 90, BuildSlots: 2}
+	if !idle.LessLoaded(busy) || busy.LessLoaded(idle) {
+		t.Errorf("expected node with less memory used to be preferred")
+	}
+
+	// build slots take precedence over memory
+	noslots := &IndexerCapacity{MemoryQuota: 100, MemoryUsed: 1}
+	if !busy.LessLoaded(noslots) {
+		t.Errorf("expected node with build slots to be preferred")
+	}
+
+	// same memory load, compare disk
+	a := &IndexerCapacity{MemoryQuota: 100, MemoryUsed: 10, DiskUsed: 10}
+	b := &IndexerCapacity{MemoryQuota: 200, MemoryUsed: 20, DiskUsed: 20}
+	if !a.LessLoaded(b) {
+		t.Errorf("expected node with less disk used to be preferred")
+	}
+}
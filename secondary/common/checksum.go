@@ -0,0 +1,63 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import "bytes"
+import "errors"
+import "fmt"
+import "hash/crc32"
+
+// ErrChecksumMismatch is returned for a metadata payload that was corrupted
+// or truncated after it was marshalled.
+var ErrChecksumMismatch = errors.New("metadata checksum mismatch")
+
+// checksummed payloads are JSON objects whose first field is checksumField,
+// crc32 of the object without that field as 8 hex digits. Older versions
+// ignore the unknown field when unmarshalling, and since the checksum leads
+// the payload a truncated payload cannot lose it.
+const checksumField = `{"crc32":"`
+const checksumLen = len(checksumField) + 8 + 1
+
+// AddChecksum to a marshalled metadata object, payloads that are not JSON
+// objects are returned as is.
+func AddChecksum(payload []byte) []byte {
+	if len(payload) < 2 || payload[0] != '{' {
+		return payload
+	}
+	data := make([]byte, 0, len(payload)+checksumLen+1)
+	data = append(data, fmt.Sprintf("%s%08x\"", checksumField, crc32.ChecksumIEEE(payload))...)
+	if payload[1] != '}' {
+		data = append(data, ',')
+	}
+	return append(data, payload[1:]...)
+}
+
+// VerifyChecksum of a metadata payload and return the payload without its
+// checksum. Payloads marshalled without a checksum, by older versions, are
+// returned as is.
+func VerifyChecksum(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(checksumField)) {
+		return data, nil
+	}
+	if len(data) <= checksumLen || data[checksumLen-1] != '"' {
+		return nil, ErrChecksumMismatch
+	}
+
+	sum, rest := data[len(checksumField):checksumLen-1], data[checksumLen:]
+	if rest[0] == ',' {
+		rest = rest[1:]
+	}
+	payload := append([]byte{'{'}, rest...)
+	expected := fmt.Sprintf("%08x", crc32.ChecksumIEEE(payload))
+	if string(sum) != expected {
+		return nil, ErrChecksumMismatch
+	}
+	return payload, nil
+}
@@ -0,0 +1,58 @@
+package common
+
+import "encoding/json"
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	payload := []byte(`{"defnId":10,"name":"idx","bucket":"default"}`)
+	data := AddChecksum(append([]byte(nil), payload...))
+
+	if out, err := VerifyChecksum(data); err != nil {
+		t.Fatal(err)
+	} else if string(out) != string(payload) {
+		t.Errorf("expected %s, got %s", payload, out)
+	}
+
+	// payload without checksum
+	if out, err := VerifyChecksum(payload); err != nil {
+		t.Fatal(err)
+	} else if string(out) != string(payload) {
+		t.Errorf("expected %s, got %s", payload, out)
+	}
+
+	// empty object
+	if out, err := VerifyChecksum(AddChecksum([]byte(`{}`))); err != nil {
+		t.Fatal(err)
+	} else if string(out) != `{}` {
+		t.Errorf("expected {}, got %s", out)
+	}
+}
+
+func TestChecksumLegacyReader(t *testing.T) {
+	data := AddChecksum([]byte(`{"defnId":10,"name":"idx","bucket":"default"}`))
+
+	// older versions unmarshal the payload without verifying it.
+	defn := new(IndexDefn)
+	if err := json.Unmarshal(data, defn); err != nil {
+		t.Fatal(err)
+	} else if defn.DefnId != 10 || defn.Name != "idx" || defn.Bucket != "default" {
+		t.Errorf("unexpected definition %+v", defn)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	data := AddChecksum([]byte(`{"defnId":10,"name":"idx"}`))
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-3] = 'y'
+	if _, err := VerifyChecksum(corrupted); err != ErrChecksumMismatch {
+		t.Errorf("expected %v, got %v", ErrChecksumMismatch, err)
+	}
+
+	for _, n := range []int{1, 3, len(data) - checksumLen, len(data) - checksumLen + 4} {
+		truncated := data[:len(data)-n]
+		if _, err := VerifyChecksum(truncated); err != ErrChecksumMismatch {
+			t.Errorf("truncated by %v: expected %v, got %v", n, ErrChecksumMismatch, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import "encoding/hex"
+import "strings"
+import "sync"
+
+import "github.com/couchbase/indexing/secondary/collatejson"
+import "golang.org/x/text/collate"
+import "golang.org/x/text/language"
+
+// Collation of string components in secondary keys of an index.
+const (
+	// CollationBinary orders strings by their UTF-8 bytes, default.
+	CollationBinary = "binary"
+	// CollationCaseInsensitive orders strings by their lower case form,
+	// with ties broken by their UTF-8 bytes. Lower cased strings are
+	// still compared by their bytes, this is not a locale aware collation.
+	CollationCaseInsensitive = "caseinsensitive"
+	// CollationUnicode orders strings as per the root locale of the
+	// Unicode Collation Algorithm, with ties broken by their UTF-8 bytes.
+	CollationUnicode = "unicode"
+)
+
+// String components of an index with a collation other than binary are
+// stored as `key + collationSep + original`, where key is the collation
+// key of the string, so that binary order of stored strings follows the
+// collation. The separator sorts below every other character, hence all
+// strings sharing a collation key sort together, after `key + collationSep`
+// and before `key + collationNext`.
+const (
+	collationSep  = "\x00"
+	collationNext = "\x01"
+)
+
+// collators are not safe for concurrent use, pool them.
+var unicodeCollators = sync.Pool{
+	New: func() interface{} { return collate.New(language.Und) },
+}
+
+// IsValidCollation returns whether `collation` is supported, empty
+// string defaults to CollationBinary.
+func IsValidCollation(collation string) bool {
+	switch collation {
+	case "", CollationBinary, CollationCaseInsensitive, CollationUnicode:
+		return true
+	}
+	return false
+}
+
+// IsCollated returns whether string components of an index with
+// `collation` are stored encoded by CollateString().
+func IsCollated(collation string) bool {
+	return collation == CollationCaseInsensitive || collation == CollationUnicode
+}
+
+// CollationKey returns the key of `s` whose binary order follows
+// `collation`. Unicode collation keys are hex encoded, so that they
+// remain valid strings that never contain the separator.
+func CollationKey(collation, s string) string {
+	switch collation {
+	case CollationCaseInsensitive:
+		return strings.ToLower(s)
+	case CollationUnicode:
+		c := unicodeCollators.Get().(*collate.Collator)
+		defer unicodeCollators.Put(c)
+		var buf collate.Buffer
+		return hex.EncodeToString(c.KeyFromString(&buf, s))
+	}
+	return s
+}
+
+// CollateString returns `s` encoded such that its binary order follows
+// `collation`, the original string can be recovered by UncollateString().
+func CollateString(collation, s string) string {
+	if !IsCollated(collation) {
+		return s
+	}
+	return CollationKey(collation, s) + collationSep + s
+}
+
+// CollateBound returns the string to be used in place of `s` in a scan
+// bound, such that it sorts below every encoded string that has the same
+// collation key as `s`, or above all of them if `above` is true. No
+// encoded string is equal to the returned bound.
+func CollateBound(collation, s string, above bool) string {
+	if !IsCollated(collation) {
+		return s
+	}
+	if above {
+		return CollationKey(collation, s) + collationNext
+	}
+	return CollationKey(collation, s) + collationSep
+}
+
+// UncollateString reverses CollateString().
+func UncollateString(collation, s string) string {
+	switch collation {
+	case CollationUnicode:
+		if i := strings.Index(s, collationSep); i >= 0 {
+			return s[i+1:]
+		}
+	case CollationCaseInsensitive:
+		// original string can itself contain the separator, pick the
+		// split where collation key matches the remaining string.
+		for i := strings.Index(s, collationSep); i >= 0; {
+			if orig := s[i+1:]; strings.ToLower(orig) == s[:i] {
+				return orig
+			}
+			j := strings.Index(s[i+1:], collationSep)
+			if j < 0 {
+				break
+			}
+			i += j + 1
+		}
+	}
+	return s
+}
+
+// CollateValues encodes string components of `values`, a decoded JSON
+// secondary key, as per `collation`. Strings nested in arrays and
+// objects are encoded as well, missing values are left as is.
+func CollateValues(collation string, values []interface{}) []interface{} {
+	if !IsCollated(collation) {
+		return values
+	}
+	return transformStrings(values, func(s string) string {
+		return CollateString(collation, s)
+	}).([]interface{})
+}
+
+// UncollateValues reverses CollateValues().
+func UncollateValues(collation string, values []interface{}) []interface{} {
+	if !IsCollated(collation) {
+		return values
+	}
+	return transformStrings(values, func(s string) string {
+		return UncollateString(collation, s)
+	}).([]interface{})
+}
+
+// CollationKeyValues replaces string components of `values` by their
+// collation key, values that compare equal under `collation` become
+// equal.
+func CollationKeyValues(collation string, values []interface{}) []interface{} {
+	if !IsCollated(collation) {
+		return values
+	}
+	return transformStrings(values, func(s string) string {
+		return CollationKey(collation, s)
+	}).([]interface{})
+}
+
+// HasStrings returns whether `value`, a decoded JSON value, is or
+// contains a string other than missing.
+func HasStrings(value interface{}) bool {
+	found := false
+	transformStrings(value, func(s string) string {
+		found = true
+		return s
+	})
+	return found
+}
+
+func transformStrings(value interface{}, fn func(string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		if collatejson.MissingLiteral.Equal(v) {
+			return v
+		}
+		return fn(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = transformStrings(item, fn)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = transformStrings(item, fn)
+		}
+		return out
+	}
+	return value
+}
@@ -0,0 +1,103 @@
+package common
+
+import "reflect"
+import "sort"
+import "testing"
+
+import "github.com/couchbase/indexing/secondary/collatejson"
+
+func TestCollateString(t *testing.T) {
+	strs := []string{"b", "B", "a\x00b", "A", "ab", "Ä", "a"}
+	for _, s := range strs {
+		if CollateString(CollationBinary, s) != s {
+			t.Fatalf("binary collation shall not encode %q", s)
+		}
+		cs := CollateString(CollationCaseInsensitive, s)
+		if out := UncollateString(CollationCaseInsensitive, cs); out != s {
+			t.Fatalf("expected %q, got %q", s, out)
+		}
+	}
+
+	collated := make([]string, 0, len(strs))
+	for _, s := range strs {
+		collated = append(collated, CollateString(CollationCaseInsensitive, s))
+	}
+	sort.Strings(collated)
+	sorted := make([]string, 0, len(strs))
+	for _, cs := range collated {
+		sorted = append(sorted, UncollateString(CollationCaseInsensitive, cs))
+	}
+	ref := []string{"A", "a", "a\x00b", "ab", "B", "b", "Ä"}
+	if !reflect.DeepEqual(ref, sorted) {
+		t.Fatalf("expected %q, got %q", ref, sorted)
+	}
+}
+
+func TestCollateValues(t *testing.T) {
+	missing := string(collatejson.MissingLiteral)
+	values := []interface{}{
+		"Foo", 10.0, missing, []interface{}{"Bar"},
+		map[string]interface{}{"Key": "Value"},
+	}
+	collated := CollateValues(CollationCaseInsensitive, values)
+	if collated[0] != "foo\x00Foo" || collated[1] != 10.0 ||
+		collated[2] != missing {
+		t.Fatalf("unexpected collated values %v", collated)
+	}
+	if !reflect.DeepEqual(UncollateValues(CollationCaseInsensitive, collated), values) {
+		t.Fatalf("expected %v, got %v", values, collated)
+	}
+	if !IsValidCollation("") || !IsValidCollation("unicode") ||
+		IsValidCollation("french") {
+		t.Fatalf("unexpected validity of collation")
+	}
+}
+
+func TestCollateUnicode(t *testing.T) {
+	strs := []string{"b", "Ä", "B", "a", "ab", "A", "ä"}
+	collated := make([]string, 0, len(strs))
+	for _, s := range strs {
+		cs := CollateString(CollationUnicode, s)
+		if out := UncollateString(CollationUnicode, cs); out != s {
+			t.Fatalf("expected %q, got %q", s, out)
+		}
+		collated = append(collated, cs)
+	}
+	sort.Strings(collated)
+	sorted := make([]string, 0, len(strs))
+	for _, cs := range collated {
+		sorted = append(sorted, UncollateString(CollationUnicode, cs))
+	}
+	ref := []string{"a", "A", "ä", "Ä", "ab", "b", "B"}
+	if !reflect.DeepEqual(ref, sorted) {
+		t.Fatalf("expected %q, got %q", ref, sorted)
+	}
+}
+
+func TestCollateBound(t *testing.T) {
+	// "B" differs from "b" at tertiary level of unicode collation.
+	equal := map[string][]string{
+		CollationCaseInsensitive: []string{"b", "B"},
+		CollationUnicode:         []string{"b"},
+	}
+	others := []string{"a", "ab", "ba", "C"}
+	for collation, strs := range equal {
+		low := CollateBound(collation, "b", false)
+		high := CollateBound(collation, "b", true)
+		for _, s := range strs {
+			cs := CollateString(collation, s)
+			if !(low < cs && cs < high) {
+				t.Fatalf("%v: %q not within bounds of %q", collation, s, "b")
+			}
+		}
+		for _, s := range others {
+			cs := CollateString(collation, s)
+			if low < cs && cs < high {
+				t.Fatalf("%v: %q within bounds of %q", collation, s, "b")
+			}
+		}
+	}
+	if CollateBound(CollationBinary, "b", true) != "b" {
+		t.Fatalf("binary collation shall not encode bounds")
+	}
+}
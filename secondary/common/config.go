@@ -17,6 +17,8 @@ import "strings"
 import "fmt"
 import "reflect"
 import "errors"
+import "io/ioutil"
+import "os"
 
 // Config is a key, value map with key always being a string
 // represents a config-parameter.
@@ -29,6 +31,60 @@ type ConfigValue struct {
 	DefaultVal interface{}
 }
 
+// Secret is the type of config parameters holding credentials, like
+// passwords, private keys and auth tokens. Secrets are redacted when
+// formatted or marshalled to JSON, so that they do not leak into logs
+// and statistics, use ConfigValue.Secret() to read the actual value.
+//
+// Value of a secret parameter can refer to a file holding the secret,
+// as "file:<path>", or to an environment variable, as "env:<name>", it
+// is resolved when the parameter is set.
+type Secret string
+
+// Redacted replaces secrets when they are formatted.
+const Redacted = "xxxxx"
+
+// String implements fmt.Stringer{} interface.
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// GoString implements fmt.GoStringer{} interface.
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+// MarshalJSON implements json.Marshaler{} interface.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// resolve secret referring to a file or to an environment variable.
+func (s Secret) resolve() (Secret, error) {
+	str := string(s)
+	switch {
+	case strings.HasPrefix(str, "file:"):
+		filename := strings.TrimPrefix(str, "file:")
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", fmt.Errorf("unable to read secret from %q", filename)
+		}
+		return Secret(strings.TrimRight(string(data), "\r\n")), nil
+
+	case strings.HasPrefix(str, "env:"):
+		name := strings.TrimPrefix(str, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret environment %q not set", name)
+		}
+		return Secret(value), nil
+	}
+	return s, nil
+}
+
 // SystemConfig is default configuration for system and components.
 // configuration parameters follow flat namespacing like,
 //      "maxVbuckets"  for system-level config parameter
@@ -65,6 +121,18 @@ var SystemConfig = Config{
 		"KV cluster's address to be used by projector",
 		"localhost:9000",
 	},
+	"projector.username": ConfigValue{
+		"",
+		"username for cluster and bucket connections, if username and " +
+			"password are empty, credentials are obtained from cbauth",
+		"",
+	},
+	"projector.password": ConfigValue{
+		Secret(""),
+		"password for cluster and bucket connections, with an empty " +
+			"username this is used as SASL password for the bucket",
+		Secret(""),
+	},
 	"projector.kvAddrs": ConfigValue{
 		"127.0.0.1:9000",
 		"Comma separated list of KV-address to read mutations, this need to " +
@@ -77,6 +145,30 @@ var SystemConfig = Config{
 			"`kvaddrs` specified above will be discarded",
 		true,
 	},
+	"projector.dcp.tls.enabled": ConfigValue{
+		false,
+		"connect with KV over TLS, for memcached connections including " +
+			"DCP streams",
+		false,
+	},
+	"projector.dcp.tls.port": ConfigValue{
+		0,
+		"TLS port of memcached on every KV node, 0 to use the kvSSL port " +
+			"advertised by each node",
+		0,
+	},
+	"projector.dcp.tls.caFile": ConfigValue{
+		"",
+		"PEM encoded CA certificates to verify KV nodes with, if empty " +
+			"system's root CAs are used",
+		"",
+	},
+	"projector.dcp.tls.insecureSkipVerify": ConfigValue{
+		false,
+		"skip verification of KV node certificates, not to be used in " +
+			"production",
+		false,
+	},
 	"projector.routerEndpointFactory": ConfigValue{
 		RouterEndpointFactory(nil),
 		"RouterEndpointFactory callback to generate endpoint instances " +
@@ -93,21 +185,144 @@ var SystemConfig = Config{
 		"timeout, in milliseconds, to await a response for StreamEnd",
 		10 * 1000,
 	},
+	"projector.staleFeedbackTimeout": ConfigValue{
+		60 * 1000,
+		"timeout, in milliseconds, after which feedback from data-path " +
+			"that is not claimed by any request is purged",
+		60 * 1000,
+	},
+	"projector.feedRetryInterval": ConfigValue{
+		100,
+		"initial backoff, in milliseconds, before re-requesting vbuckets " +
+			"whose StreamRequest failed, doubled for every retry",
+		100,
+	},
+	"projector.feedRetryMaxInterval": ConfigValue{
+		5 * 1000,
+		"maximum backoff, in milliseconds, before re-requesting vbuckets " +
+			"whose StreamRequest failed",
+		5 * 1000,
+	},
+	"projector.feedRetryBudget": ConfigValue{
+		5 * 60 * 1000,
+		"time, in milliseconds, vbuckets whose StreamRequest failed are " +
+			"re-requested in background, each with its own backoff, " +
+			"0 disables retries",
+		5 * 60 * 1000,
+	},
+	"projector.feedStreamReqBatchSize": ConfigValue{
+		0,
+		"number of vbuckets for which StreamRequests are posted together, " +
+			"next batch is posted after the previous one is responded, " +
+			"0 posts StreamRequests for all vbuckets at once",
+		0,
+	},
+	"projector.feedStreamReqBatchInterval": ConfigValue{
+		100,
+		"time, in milliseconds, to wait before posting the next batch of " +
+			"StreamRequests, refer feedStreamReqBatchSize",
+		100,
+	},
 	"projector.mutationChanSize": ConfigValue{
 		10000,
-		"channel size of projector's data path routine",
+		"channel size of projector's data path routine, also the " +
+			"maximum no. of mutations buffered from upstream per bucket",
 		10000,
 	},
+	"projector.mutationChanMinSize": ConfigValue{
+		256,
+		"no. of mutations buffered from upstream per bucket when idle, " +
+			"buffer grows with load upto mutationChanSize, must be " +
+			"atleast 1 and not exceed mutationChanSize",
+		256,
+	},
 	"projector.feedChanSize": ConfigValue{
 		100,
-		"channel size for feed's control path and back path.",
+		"maximum channel size for feed's control path and back path.",
 		100,
 	},
+	"projector.feedChanMinSize": ConfigValue{
+		16,
+		"channel size for feed's control path and back path when idle, " +
+			"channels grow with load upto feedChanSize, must be " +
+			"atleast 1 and not exceed feedChanSize",
+		16,
+	},
 	"projector.vbucketSyncTimeout": ConfigValue{
 		500,
 		"timeout, in milliseconds, for sending periodic Sync messages.",
 		500,
 	},
+	"projector.feedRequestTimeout": ConfigValue{
+		300 * 1000,
+		"timeout, in milliseconds, to await feed's response for " +
+			"synchronous requests, 0 waits indefinitely",
+		300 * 1000,
+	},
+	"projector.routingAuditSamples": ConfigValue{
+		0,
+		"number of mutations, out of every routingAuditPeriod mutations " +
+			"on a vbucket, for which endpoints chosen by each engine are " +
+			"recorded in feed statistics, 0 disables routing audit",
+		0,
+	},
+	"projector.routingAuditPeriod": ConfigValue{
+		1000,
+		"number of mutations on a vbucket over which routingAuditSamples " +
+			"mutations are audited",
+		1000,
+	},
+	"projector.mutationSampleEvery": ConfigValue{
+		0,
+		"every Nth mutation on a bucket is sampled, along with keys " +
+			"evaluated by each engine, retrievable from adminport's " +
+			"/mutationsamples by administrators, 0 disables sampling",
+		0,
+	},
+	"projector.mutationSampleSize": ConfigValue{
+		100,
+		"number of most recent mutation samples retained per bucket",
+		100,
+	},
+	"projector.maxTopics": ConfigValue{
+		64,
+		"maximum number of topics, a new topic exceeding this limit " +
+			"is rejected, 0 for no limit",
+		64,
+	},
+	"projector.maxBucketsPerTopic": ConfigValue{
+		64,
+		"maximum number of buckets per topic, requests adding buckets " +
+			"beyond this limit are rejected, 0 for no limit",
+		64,
+	},
+	"projector.maxEnginesPerBucket": ConfigValue{
+		1024,
+		"maximum number of engines, one per index instance, per bucket " +
+			"on a topic, requests adding engines beyond this limit are " +
+			"rejected, 0 for no limit",
+		1024,
+	},
+	"projector.resourceSampleInterval": ConfigValue{
+		5000,
+		"interval, in milliseconds, to sample process cpu and memory and " +
+			"attribute cpu used to topics in proportion to the time their " +
+			"data path was busy, 0 disables sampling",
+		5000,
+	},
+	"projector.feedEventLogSize": ConfigValue{
+		256,
+		"number of recent control path events, like stream begin, " +
+			"stream end, rollback and errors, retained per topic for " +
+			"adminport's /events, 0 disables event log",
+		256,
+	},
+	"projector.logTailSize": ConfigValue{
+		1000,
+		"number of recent log messages retained for streaming from " +
+			"adminport's /logtail, 0 disables log tail",
+		1000,
+	},
 	// projector adminport parameters
 	"projector.adminport.name": ConfigValue{
 		"projector.adminport",
@@ -215,6 +430,12 @@ var SystemConfig = Config{
 		"timeout, in milliseconds, while reading from socket",
 		10 * 1000, // 10s
 	},
+	"projector.dataport.indexer.tcpReadBuffer": ConfigValue{
+		0,
+		"size, in bytes, of receive buffer for each connection from " +
+			"projector, if 0 the operating system default is used",
+		0,
+	},
 	// indexer queryport configuration
 	"queryport.indexer.maxPayload": ConfigValue{
 		1000 * 1024,
@@ -241,6 +462,43 @@ var SystemConfig = Config{
 		"size of the buffered channels used to stream request and response.",
 		16,
 	},
+	"queryport.indexer.transport": ConfigValue{
+		"native",
+		"transport for queryport, `native` for the custom framing over " +
+			"tcp or `grpc` for gRPC streams of protobuf payloads, " +
+			"available only if indexer is built with `grpc` build tag",
+		"native",
+	},
+	"queryport.indexer.compression": ConfigValue{
+		true,
+		"compress scan response batches for clients that accept " +
+			"compression, applicable only to native transport",
+		true,
+	},
+	"queryport.indexer.compressionThreshold": ConfigValue{
+		4096,
+		"only response batches larger than this size, in bytes, " +
+			"shall be compressed",
+		4096,
+	},
+	"queryport.indexer.authorization": ConfigValue{
+		false,
+		"authorize scans by read permission of the bucket indexed, " +
+			"clients shall authenticate their connections with cbauth " +
+			"credentials, over TLS",
+		false,
+	},
+	"queryport.indexer.tls.certFile": ConfigValue{
+		"",
+		"PEM encoded certificate to serve queryport over TLS, connections " +
+			"are in plain text if empty",
+		"",
+	},
+	"queryport.indexer.tls.keyFile": ConfigValue{
+		"",
+		"PEM encoded private key of queryport.indexer.tls.certFile",
+		"",
+	},
 	// queryport client configuration
 	"queryport.client.maxPayload": ConfigValue{
 		1000 * 1024,
@@ -279,11 +537,244 @@ var SystemConfig = Config{
 			"from the pool before considering the creation of a new one",
 		1,
 	},
+	"queryport.client.transport": ConfigValue{
+		"native",
+		"transport for connecting to queryport, `native` or `grpc`, " +
+			"shall match indexer's queryport.indexer.transport, `grpc` is " +
+			"available only if client is built with `grpc` build tag",
+		"native",
+	},
+	"queryport.client.compression": ConfigValue{
+		"none",
+		"compression accepted for scan responses, `none` or `gzip`, " +
+			"applicable only to native transport",
+		"none",
+	},
+	"queryport.client.username": ConfigValue{
+		"",
+		"username to authenticate connections to queryport, connections " +
+			"are not authenticated if empty, credentials are sent only " +
+			"over TLS",
+		"",
+	},
+	"queryport.client.password": ConfigValue{
+		Secret(""),
+		"password to authenticate connections to queryport",
+		Secret(""),
+	},
+	"queryport.client.tls.enabled": ConfigValue{
+		false,
+		"connect to queryport over TLS, required to authenticate " +
+			"connections",
+		false,
+	},
+	"queryport.client.tls.caFile": ConfigValue{
+		"",
+		"PEM encoded CA certificates to verify indexer nodes with, if " +
+			"empty system's root CAs are used",
+		"",
+	},
+	"queryport.client.tls.insecureSkipVerify": ConfigValue{
+		false,
+		"skip verification of indexer node certificates, not to be used " +
+			"in production",
+		false,
+	},
+	"queryport.client.retry.maxRetries": ConfigValue{
+		3,
+		"number of times a request failing on a transient error, like " +
+			"connection reset, indexer busy or snapshot not ready, " +
+			"shall be re-issued, 0 disables retry",
+		3,
+	},
+	"queryport.client.retry.interval": ConfigValue{
+		100,
+		"time, in milliseconds, to wait before the first retry, " +
+			"doubled on every subsequent retry",
+		100,
+	},
+	"queryport.client.retry.maxInterval": ConfigValue{
+		2000,
+		"time, in milliseconds, is the maximum wait between retries",
+		2000,
+	},
+	"queryport.client.retry.maxRefreshes": ConfigValue{
+		2,
+		"number of times a scan failing for index not found on, or " +
+			"moved away from, an indexer shall be re-issued after " +
+			"refreshing index metadata, 0 disables refresh",
+		2,
+	},
+	"queryport.client.requestWindow": ConfigValue{
+		8,
+		"maximum number of DDL requests in flight to an indexer node, " +
+			"further requests wait for outstanding ones to complete",
+		8,
+	},
+	"queryport.client.placementPolicy": ConfigValue{
+		"least_loaded",
+		"policy to select indexer node for indexes created without " +
+			"nodes in their plan, one of round_robin, least_indexes, " +
+			"least_disk, least_loaded",
+		"least_loaded",
+	},
 	"indexer.scanTimeout": ConfigValue{
 		120000,
 		"timeout, in milliseconds, timeout for index scan processing",
 		120000,
 	},
+	"indexer.scanDrainTimeout": ConfigValue{
+		5000,
+		"timeout, in milliseconds, for in-flight scans of a dropped index " +
+			"to finish before they are cancelled",
+		5000,
+	},
+	"indexer.scanMaxConcurrent": ConfigValue{
+		0,
+		"maximum number of scans in-flight at a time, further scans are " +
+			"rejected as server busy for clients to retry, 0 disables the limit",
+		0,
+	},
+	"indexer.scanSlowThreshold": ConfigValue{
+		5000,
+		"time, in milliseconds, beyond which a finished scan is logged " +
+			"as slow and retained for /scans/slow, 0 disables the slow " +
+			"scan log",
+		5000,
+	},
+	"indexer.scanSlowLogSize": ConfigValue{
+		100,
+		"number of most recent slow scans retained for /scans/slow",
+		100,
+	},
+	"indexer.scanChecksum": ConfigValue{
+		false,
+		"add a checksum of index entries to every scan response batch, " +
+			"verified by clients to detect corrupted responses",
+		false,
+	},
+	"indexer.scanParallelism": ConfigValue{
+		0,
+		"number of slices a full table scan reads in parallel, " +
+			"0 reads all slices of the index in parallel",
+		0,
+	},
+	"indexer.scanReadAhead.depth": ConfigValue{
+		0,
+		"number of batches of index entries a range scan reads ahead " +
+			"of sending them, 0 disables read-ahead",
+		0,
+	},
+	"indexer.scanReadAhead.batchSize": ConfigValue{
+		256,
+		"number of index entries in a batch read ahead by range scans",
+		256,
+	},
+	"indexer.scanCache.size": ConfigValue{
+		0,
+		"number of scan results to cache for repeated identical scans, " +
+			"0 disables the cache",
+		0,
+	},
+	"indexer.scanCache.maxRows": ConfigValue{
+		1000,
+		"scan results with more rows than this are not cached",
+		1000,
+	},
+	"indexer.memory.storageCachePercent": ConfigValue{
+		60,
+		"percentage of memory quota budgeted for storage buffer cache, " +
+			"sized when indexes are opened",
+		60,
+	},
+	"indexer.memory.mutationQueuePercent": ConfigValue{
+		30,
+		"percentage of memory quota initially budgeted for mutation " +
+			"queues of all streams",
+		30,
+	},
+	"indexer.memory.scanCachePercent": ConfigValue{
+		10,
+		"percentage of memory quota initially budgeted for cached scan " +
+			"results",
+		10,
+	},
+	"indexer.memory.minPercent": ConfigValue{
+		5,
+		"percentage of memory quota below which the budget of mutation " +
+			"queues or scan cache is not lowered when rebalancing",
+		5,
+	},
+	"indexer.memory.pressurePercent": ConfigValue{
+		90,
+		"percentage of its budget beyond which a component is given memory " +
+			"from the component using the least of its budget",
+		90,
+	},
+	"indexer.memory.rebalanceInterval": ConfigValue{
+		1000,
+		"time, in milliseconds, between rebalancing budgets of mutation " +
+			"queues and scan cache, 0 disables rebalancing",
+		1000,
+	},
+	"indexer.memory.throttleInterval": ConfigValue{
+		10,
+		"time, in milliseconds, a stream reader waits for mutation " +
+			"queues to release memory before processing a batch of " +
+			"mutations, while queues are beyond their budget, 0 disables " +
+			"throttling",
+		10,
+	},
+	"indexer.capacity.buildSlots": ConfigValue{
+		4,
+		"number of index builds a node is sized to run concurrently, " +
+			"reported in capacity stats for placing new indexes",
+		4,
+	},
+	"indexer.build.maxConcurrent": ConfigValue{
+		0,
+		"number of buckets that can run initial index builds " +
+			"concurrently, further builds are queued in the order " +
+			"requested, 0 for no limit",
+		0,
+	},
+	"indexer.build.retry.maxAttempts": ConfigValue{
+		5,
+		"number of attempts at an initial index build, that fails say " +
+			"due to KV, projector or storage errors, before the index is " +
+			"moved to error state, 0 disables retries",
+		5,
+	},
+	"indexer.build.retry.interval": ConfigValue{
+		5000,
+		"time, in milliseconds, before a failed index build or build " +
+			"stream request is retried, doubled for every further attempt",
+		5000,
+	},
+	"indexer.build.retry.maxInterval": ConfigValue{
+		300000,
+		"maximum time, in milliseconds, between retries of a failed " +
+			"index build or build stream request",
+		300000,
+	},
+	"indexer.scanCursor.ttl": ConfigValue{
+		60 * 1000,
+		"time, in milliseconds, a paginated scan can be resumed " +
+			"from its cursor before the cursor expires",
+		60 * 1000,
+	},
+	"indexer.snapshotRetention.count": ConfigValue{
+		0,
+		"number of recent persisted snapshots retained per index, that " +
+			"can be listed and scanned by handle, 0 disables retention",
+		0,
+	},
+	"indexer.snapshotRetention.maxAge": ConfigValue{
+		10 * 60 * 1000,
+		"time, in milliseconds, after which a retained snapshot is " +
+			"released, 0 for no age limit",
+		10 * 60 * 1000,
+	},
 	"indexer.adminPort": ConfigValue{
 		"9100",
 		"port for index ddl and status operations",
@@ -314,6 +805,13 @@ var SystemConfig = Config{
 		"port for maintenance stream",
 		"9105",
 	},
+	"indexer.streamPortRange": ConfigValue{
+		"",
+		"range of ports, as <first>-<last>, to allocate a dedicated " +
+			"port for each stream when it is opened, empty to listen on " +
+			"the port configured for each stream",
+		"",
+	},
 	"indexer.clusterAddr": ConfigValue{
 		"127.0.0.1:8091",
 		"Local cluster manager address",
@@ -339,6 +837,114 @@ var SystemConfig = Config{
 		"Number of Writer Threads for a Slice",
 		1,
 	},
+	"indexer.streamReader.numWorkers": ConfigValue{
+		8,
+		"Number of workers per stream reader, mutations are sharded " +
+			"across workers by vbucket",
+		8,
+	},
+	"indexer.bulkLoad.batchSize": ConfigValue{
+		1000,
+		"Number of entries a slice writes as one sorted run during bulk load",
+		1000,
+	},
+	"indexer.bulkLoad.throttleInterval": ConfigValue{
+		0,
+		"Pause, in milliseconds, between sorted runs during bulk load",
+		0,
+	},
+	"indexer.expiration.delayPurge": ConfigValue{
+		false,
+		"Leave index entries of documents expired in KV to be reclaimed " +
+			"by purge, instead of deleting them as expirations arrive, " +
+			"ignored unless indexer.purge.interval is set",
+		false,
+	},
+	"indexer.histogram.numBins": ConfigValue{
+		64,
+		"Maximum number of bins in the approximate key histogram kept " +
+			"for each slice and returned by statistics scans, 0 disables " +
+			"the histogram",
+		64,
+	},
+	"indexer.metadata.compaction.interval": ConfigValue{
+		3600,
+		"Interval, in seconds, between checks whether the metadata " +
+			"repository needs compaction, 0 disables scheduled compaction",
+		3600,
+	},
+	"indexer.metadata.compaction.minFrag": ConfigValue{
+		50,
+		"Metadata repository is compacted when the percentage of its " +
+			"file not used by live metadata reaches this threshold",
+		50,
+	},
+	"indexer.metadata.compaction.minSize": ConfigValue{
+		uint64(1024 * 1024),
+		"Metadata repository smaller than this is not compacted",
+		uint64(1024 * 1024),
+	},
+	"indexer.purge.interval": ConfigValue{
+		0,
+		"Interval, in seconds, between passes that purge index entries " +
+			"of documents purged from KV, 0 disables purge",
+		0,
+	},
+	"indexer.purge.batchSize": ConfigValue{
+		256,
+		"Number of documents verified with KV in one request by purge",
+		256,
+	},
+	"indexer.scrub.interval": ConfigValue{
+		0,
+		"Interval, in seconds, between passes that verify the storage of " +
+			"every index for corruption, 0 disables scrub",
+		0,
+	},
+	"indexer.scrub.batchSize": ConfigValue{
+		1000,
+		"Number of index entries verified by scrub between pauses",
+		1000,
+	},
+	"indexer.scrub.throttle": ConfigValue{
+		10,
+		"Pause, in milliseconds, after every batch of entries verified " +
+			"by scrub, 0 to verify without pause",
+		10,
+	},
+	"indexer.scrub.maxSamples": ConfigValue{
+		10,
+		"Number of corrupted entries retained in the scrub report of an index",
+		10,
+	},
+	"indexer.scrub.markRebuild": ConfigValue{
+		false,
+		"Move indexes found corrupted by scrub to error state, so that " +
+			"they are rebuilt instead of failing scans",
+		false,
+	},
+	"indexer.verify.interval": ConfigValue{
+		0,
+		"Interval, in seconds, between passes that verify every index " +
+			"against documents sampled from KV, 0 disables verification",
+		0,
+	},
+	"indexer.verify.sampleSize": ConfigValue{
+		100,
+		"Number of documents sampled from KV to verify an index",
+		100,
+	},
+	"indexer.verify.maxSamples": ConfigValue{
+		10,
+		"Number of divergent documents retained in the verify report of an index",
+		10,
+	},
+	"indexer.wal.enable": ConfigValue{
+		false,
+		"Log index entries flushed since the last persisted snapshot, " +
+			"so that recovery can resume from the last in-memory snapshot",
+		false,
+	},
 
 	"indexer.sync_period": ConfigValue{
 		uint64(100),
@@ -377,6 +983,18 @@ var SystemConfig = Config{
 		"InMemory snapshotting interval in milliseconds",
 		uint64(200),
 	},
+	"indexer.settings.persisted_snapshot.init_build.interval": ConfigValue{
+		uint64(0),
+		"Persisted snapshotting interval in milliseconds for initial build " +
+			"and catchup streams, 0 uses persisted_snapshot.interval",
+		uint64(0),
+	},
+	"indexer.settings.inmemory_snapshot.init_build.interval": ConfigValue{
+		uint64(0),
+		"InMemory snapshotting interval in milliseconds for initial build " +
+			"and catchup streams, 0 uses inmemory_snapshot.interval",
+		uint64(0),
+	},
 	"indexer.settings.recovery.max_rollbacks": ConfigValue{
 		5,
 		"Maximum number of committed rollback points",
@@ -384,7 +1002,8 @@ var SystemConfig = Config{
 	},
 	"indexer.settings.memory_quota": ConfigValue{
 		uint64(0),
-		"Maximum memory used by the indexer buffercache",
+		"Maximum memory used by the indexer, shared by storage buffercache, " +
+			"mutation queues and scan cache, 0 for no limit",
 		uint64(0),
 	},
 	"indexer.settings.max_cpu_percent": ConfigValue{
@@ -505,12 +1124,20 @@ func (config Config) SetValue(key string, value interface{}) error {
 		return fmt.Errorf("%v: Value type mismatch, %v != %v (%v)",
 			key, valType, defType, value)
 	}
+	if secret, ok := value.(Secret); ok {
+		var err error
+		if value, err = secret.resolve(); err != nil {
+			return fmt.Errorf("%v: %v", key, err)
+		}
+	}
 	cv.Value = value
 	config[key] = cv
 
 	return nil
 }
 
+// Json encodes config parameters and their values, secrets are
+// redacted.
 func (config Config) Json() []byte {
 	kvs := make(map[string]interface{})
 	for key, value := range config {
@@ -536,6 +1163,11 @@ func (cv ConfigValue) String() string {
 	return cv.Value.(string)
 }
 
+// Secret assumes config value is a Secret and returns its actual value.
+func (cv ConfigValue) Secret() string {
+	return string(cv.Value.(Secret))
+}
+
 // Strings assumes config value is comma separated string items.
 func (cv ConfigValue) Strings() []string {
 	ss := make([]string, 0)
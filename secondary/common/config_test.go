@@ -0,0 +1,62 @@
+package common
+
+import "fmt"
+import "io/ioutil"
+import "os"
+import "strings"
+import "testing"
+
+func TestConfigSecret(t *testing.T) {
+	config := SystemConfig.SectionConfig("queryport.client.", true)
+	if err := config.SetValue("password", "pa55word"); err != nil {
+		t.Fatal(err)
+	}
+	if s := config["password"].Secret(); s != "pa55word" {
+		t.Fatalf("expected %q, got %q", "pa55word", s)
+	}
+
+	dumps := []string{
+		string(config.Json()),
+		fmt.Sprintf("%v", config["password"].Value),
+		fmt.Sprintf("%+v", config["password"]),
+		fmt.Sprintf("%#v", config["password"]),
+	}
+	for _, dump := range dumps {
+		if strings.Contains(dump, "pa55word") {
+			t.Errorf("secret leaked in %s", dump)
+		}
+	}
+}
+
+func TestConfigSecretFrom(t *testing.T) {
+	f, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("fromfile\n")
+	f.Close()
+
+	os.Setenv("TEST_CONFIG_SECRET", "fromenv")
+	defer os.Unsetenv("TEST_CONFIG_SECRET")
+
+	config := SystemConfig.SectionConfig("queryport.client.", true)
+	testcases := map[string]string{
+		"file:" + f.Name():       "fromfile",
+		"env:TEST_CONFIG_SECRET": "fromenv",
+	}
+	for value, expected := range testcases {
+		if err := config.SetValue("password", value); err != nil {
+			t.Fatal(err)
+		}
+		if s := config["password"].Secret(); s != expected {
+			t.Errorf("%v: expected %q, got %q", value, expected, s)
+		}
+	}
+
+	for _, value := range []string{"file:/nonexistent", "env:TEST_CONFIG_NOTSET"} {
+		if err := config.SetValue("password", value); err == nil {
+			t.Errorf("%v: expected error", value)
+		}
+	}
+}
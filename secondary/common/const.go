@@ -2,32 +2,69 @@
 
 package common
 
-import (
-	"errors"
-)
-
-// error codes
+// error codes, refer to errors.go
 
 // ErrorEmptyN1QLExpression
-var ErrorEmptyN1QLExpression = errors.New("secondary.emptyN1QLExpression")
+var ErrorEmptyN1QLExpression = NewError(1, "secondary.emptyN1QLExpression", false)
 
 // ErrorUnexpectedPayload
-var ErrorUnexpectedPayload = errors.New("secondary.unexpectedPayload")
+var ErrorUnexpectedPayload = NewError(2, "secondary.unexpectedPayload", false)
 
 // ErrorClosed
-var ErrorClosed = errors.New("secondary.closed")
+var ErrorClosed = NewError(3, "secondary.closed", false)
 
 // ErrorChannelFull
-var ErrorChannelFull = errors.New("secondary.channelFull")
+var ErrorChannelFull = NewError(4, "secondary.channelFull", true)
 
 // ErrorNotMyVbucket
-var ErrorNotMyVbucket = errors.New("secondary.notMyVbucket")
+var ErrorNotMyVbucket = NewError(5, "secondary.notMyVbucket", false)
 
 // ErrorInvalidRequest
-var ErrorInvalidRequest = errors.New("secondary.invalidRequest")
+var ErrorInvalidRequest = NewError(6, "secondary.invalidRequest", false)
 
 // ErrorNotFound
-var ErrorNotFound = errors.New("secondary.notFound")
+var ErrorNotFound = NewError(7, "secondary.notFound", false)
+
+// ErrorScanKilled is returned to the client of a scan that was killed
+// through indexer's admin API.
+var ErrorScanKilled = NewError(8, "secondary.scanKilled", false)
+
+// ErrorSnapshotNotReady is returned to the client of a scan when no index
+// snapshot could serve the scan in time, the scan can be retried. Its
+// message is the one indexer returned for such scans before errors were
+// typed.
+var ErrorSnapshotNotReady = NewError(9, "Index scan timed out", true)
+
+// ErrorServerBusy is returned to the client of a scan that the indexer
+// could not take up, for having as many scans in-flight as configured by
+// indexer.scanMaxConcurrent, the scan can be retried.
+var ErrorServerBusy = NewError(10, "secondary.serverBusy", true)
+
+// ErrorInvalidCACert is returned when the CA file configured to verify
+// TLS connections has no PEM encoded certificates.
+var ErrorInvalidCACert = NewError(11, "secondary.invalidCACert", false)
+
+// ErrorBucketUUIDChanged is returned when a bucket was flushed or
+// recreated after its indexes were defined, indexes on the bucket have to
+// be rebuilt.
+var ErrorBucketUUIDChanged = NewError(12, "secondary.bucketUUIDChanged", false)
+
+// ErrorIndexCorrupted is set on an index whose storage was found corrupted
+// by the scrubber, the index has to be rebuilt.
+var ErrorIndexCorrupted = NewError(13, "secondary.indexCorrupted", false)
+
+// ErrorAuthFailed is returned to a client whose credentials were rejected,
+// and to a client that did not authenticate its connection when indexer
+// authorizes scans.
+var ErrorAuthFailed = NewError(14, "secondary.authFailed", false)
+
+// ErrorUnauthorized is returned to the client of a scan on an index over a
+// bucket that the client's credentials do not permit to read.
+var ErrorUnauthorized = NewError(15, "secondary.unauthorized", false)
+
+// ErrorInsecureAuth is returned when credentials are to be exchanged on a
+// connection that is not over TLS.
+var ErrorInsecureAuth = NewError(16, "secondary.insecureAuth", false)
 
 // ProtobufDataPathMajorNum major version number for mutation data path.
 var ProtobufDataPathMajorNum byte // = 0
@@ -20,6 +20,7 @@ const (
 
 var logLevel int
 var logFile io.Writer = os.Stdout
+var logTail io.Writer
 var logger *log.Logger
 
 // Logger interface for sub-components to do logging.
@@ -62,7 +63,7 @@ func LogIgnore() {
 
 // LogEnable to enable / re-enable log output.
 func LogEnable() {
-	logger = log.New(logFile, "", log.Lmicroseconds)
+	logger = log.New(logWriter(), "", log.Lmicroseconds)
 }
 
 // Is log enabled
@@ -77,8 +78,22 @@ func SetLogLevel(level int) {
 
 // SetLogWriter sets output file for log messages
 func SetLogWriter(w io.Writer) {
-	logger = log.New(w, "", log.Lmicroseconds)
 	logFile = w
+	logger = log.New(logWriter(), "", log.Lmicroseconds)
+}
+
+// SetLogTail copies log messages to `w` in addition to the output file,
+// like a LogRing to tail recent log messages. Pass nil to stop copying.
+func SetLogTail(w io.Writer) {
+	logTail = w
+	logger = log.New(logWriter(), "", log.Lmicroseconds)
+}
+
+func logWriter() io.Writer {
+	if logTail != nil {
+		return io.MultiWriter(logFile, logTail)
+	}
+	return logFile
 }
 
 //-------------------------------
@@ -0,0 +1,247 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import "errors"
+import "fmt"
+import "strings"
+import "sync"
+import "sync/atomic"
+
+// ErrorCode identifies an error across processes, it is sent along with
+// the error message in protobuf responses. Codes are never reused, and are
+// allocated in ranges per component:
+//
+//	1   - 99,  common errors, "secondary.xxx"
+//	100 - 199, projector errors, "projector.xxx" and "feed.xxx"
+//	200 - 299, queryport errors, "queryport.xxx"
+//	300 - 399, manager client errors
+type ErrorCode uint32
+
+// ErrorCodeUnknown for errors that are not typed.
+const ErrorCodeUnknown ErrorCode = 0
+
+// Error is a sentinel error of secondary indexing, identified by its code
+// and classified by whether the failed operation can be retried as is.
+// Its message doubles as its name and does not change, so that older
+// components matching on error messages continue to work.
+type Error struct {
+	code      ErrorCode
+	msg       string
+	retryable bool
+	count     uint64 // occurrences, refer CountError()
+}
+
+var errorRegistry struct {
+	mu     sync.RWMutex
+	byCode map[ErrorCode]*Error
+	byMsg  map[string]*Error
+}
+
+// NewError defines a sentinel error with `code` and `msg`, meant to be
+// assigned to a package level variable. Panics if code or message is
+// already defined.
+func NewError(code ErrorCode, msg string, retryable bool) *Error {
+	errorRegistry.mu.Lock()
+	defer errorRegistry.mu.Unlock()
+
+	if errorRegistry.byCode == nil {
+		errorRegistry.byCode = make(map[ErrorCode]*Error)
+		errorRegistry.byMsg = make(map[string]*Error)
+	}
+	if code == ErrorCodeUnknown {
+		panic(fmt.Errorf("error %q: code %v is reserved", msg, code))
+	} else if e, ok := errorRegistry.byCode[code]; ok {
+		panic(fmt.Errorf("error %q: code %v used by %q", msg, code, e.msg))
+	} else if _, ok := errorRegistry.byMsg[msg]; ok {
+		panic(fmt.Errorf("error %q: already defined", msg))
+	}
+	e := &Error{code: code, msg: msg, retryable: retryable}
+	errorRegistry.byCode[code] = e
+	errorRegistry.byMsg[msg] = e
+	return e
+}
+
+// Error implements error{} interface.
+func (e *Error) Error() string {
+	return e.msg
+}
+
+// Code of the error.
+func (e *Error) Code() ErrorCode {
+	return e.code
+}
+
+// Retryable returns whether the failed operation can be retried as is.
+func (e *Error) Retryable() bool {
+	return e.retryable
+}
+
+// Count returns the number of occurrences of the error in this process,
+// counted by CountError().
+func (e *Error) Count() uint64 {
+	return atomic.LoadUint64(&e.count)
+}
+
+// ErrorByCode returns the sentinel error defined with `code`, nil if
+// there is none.
+func ErrorByCode(code ErrorCode) *Error {
+	errorRegistry.mu.RLock()
+	defer errorRegistry.mu.RUnlock()
+	return errorRegistry.byCode[code]
+}
+
+// WrappedError annotates an error with context of the failed operation,
+// like the topic, bucket or vbucket it was applied on.
+type WrappedError struct {
+	cause   error
+	context string
+}
+
+// WrapError annotates `err` with context, supplied as key and value
+// pairs, like:
+//
+//	WrapError(err, "topic", topic, "bucket", bucketn, "vbucket", vbno)
+//
+// returns nil if `err` is nil.
+func WrapError(err error, kvs ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	ss := make([]string, 0, len(kvs)/2+1)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		ss = append(ss, fmt.Sprintf("%v=%v", kvs[i], kvs[i+1]))
+	}
+	if len(kvs)%2 == 1 {
+		ss = append(ss, fmt.Sprintf("%v", kvs[len(kvs)-1]))
+	}
+	if we, ok := err.(*WrappedError); ok {
+		ss = append(ss, we.context) // inner context goes last.
+		err = we.cause
+	}
+	return &WrappedError{cause: err, context: strings.Join(ss, " ")}
+}
+
+// Error implements error{} interface, message of the cause is retained as
+// prefix.
+func (e *WrappedError) Error() string {
+	return e.cause.Error() + ": " + e.context
+}
+
+// Cause returns the annotated error.
+func (e *WrappedError) Cause() error {
+	return e.cause
+}
+
+// Unwrap returns the annotated error.
+func (e *WrappedError) Unwrap() error {
+	return e.cause
+}
+
+// ErrorContext returns the context annotated by WrapError, empty string
+// if `err` is not annotated.
+func ErrorContext(err error) string {
+	if we, ok := err.(*WrappedError); ok {
+		return we.context
+	}
+	return ""
+}
+
+// ErrorCause returns the error annotated by WrapError, `err` otherwise.
+func ErrorCause(err error) error {
+	if we, ok := err.(*WrappedError); ok {
+		return we.cause
+	}
+	return err
+}
+
+// IsError returns whether `err`, or the error it annotates, is `target`.
+func IsError(err error, target *Error) bool {
+	if e, ok := ErrorCause(err).(*Error); ok {
+		return e == target
+	}
+	return false
+}
+
+// ErrorCodeOf returns the code of a typed error, ErrorCodeUnknown for
+// errors that are not.
+func ErrorCodeOf(err error) ErrorCode {
+	if e, ok := ErrorCause(err).(*Error); ok {
+		return e.code
+	}
+	return ErrorCodeUnknown
+}
+
+// CountError counts an occurrence of `err`, or of the error it annotates,
+// if it is a typed error, and returns `err` as is. Meant to be applied
+// where an error occurs, like:
+//
+//	return CountError(WrapError(err, "bucket", bucketn))
+//
+// so that operators can watch for spikes of specific errors.
+func CountError(err error) error {
+	if e, ok := ErrorCause(err).(*Error); ok {
+		atomic.AddUint64(&e.count, 1)
+	}
+	return err
+}
+
+// ErrorCounts returns the number of occurrences of typed errors by their
+// message, errors that did not occur are skipped.
+func ErrorCounts() map[string]uint64 {
+	errorRegistry.mu.RLock()
+	defer errorRegistry.mu.RUnlock()
+
+	counts := make(map[string]uint64)
+	for _, e := range errorRegistry.byCode {
+		if n := e.Count(); n > 0 {
+			counts[e.msg] = n
+		}
+	}
+	return counts
+}
+
+// IsRetryable returns whether the operation that failed with `err` can
+// be retried as is. Errors that are not typed are not retryable.
+func IsRetryable(err error) bool {
+	if e, ok := ErrorCause(err).(*Error); ok {
+		return e.retryable
+	}
+	return false
+}
+
+// DecodeError reconstructs an error received from another process with
+// its `code` and message, sentinel errors are restored along with their
+// context so that they can be matched with IsError. Components that do
+// not send codes are matched on message. Returns nil for empty message.
+func DecodeError(code ErrorCode, msg string) error {
+	if msg == "" {
+		return nil
+	}
+	errorRegistry.mu.RLock()
+	e, ok := errorRegistry.byCode[code]
+	if !ok {
+		name := msg
+		if i := strings.Index(msg, ": "); i >= 0 {
+			name = msg[:i]
+		}
+		e, ok = errorRegistry.byMsg[name]
+	}
+	errorRegistry.mu.RUnlock()
+
+	if !ok {
+		return errors.New(msg)
+	} else if msg == e.msg {
+		return e
+	} else if strings.HasPrefix(msg, e.msg+": ") {
+		return &WrappedError{cause: e, context: msg[len(e.msg)+2:]}
+	}
+	return errors.New(msg)
+}
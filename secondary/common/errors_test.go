@@ -0,0 +1,70 @@
+package common
+
+import "errors"
+import "testing"
+
+func TestWrapError(t *testing.T) {
+	if WrapError(nil, "bucket", "default") != nil {
+		t.Fatal("expected nil error")
+	}
+	err := WrapError(ErrorServerBusy, "bucket", "default", "vbucket", 10)
+	ref := "secondary.serverBusy: bucket=default vbucket=10"
+	if err.Error() != ref {
+		t.Fatalf("expected %q, got %q", ref, err.Error())
+	}
+	err = WrapError(err, "topic", "maint")
+	ref = "secondary.serverBusy: topic=maint bucket=default vbucket=10"
+	if err.Error() != ref {
+		t.Fatalf("expected %q, got %q", ref, err.Error())
+	}
+	if !IsError(err, ErrorServerBusy) || IsError(err, ErrorClosed) {
+		t.Fatalf("unexpected cause for %v", err)
+	}
+	if ErrorCodeOf(err) != ErrorServerBusy.Code() || !IsRetryable(err) {
+		t.Fatalf("unexpected classification for %v", err)
+	}
+	err = WrapError(errors.New("plain"), "bucket", "default")
+	if ErrorCodeOf(err) != ErrorCodeUnknown || IsRetryable(err) {
+		t.Fatalf("unexpected classification for %v", err)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	if DecodeError(ErrorCodeUnknown, "") != nil {
+		t.Fatal("expected nil error for empty message")
+	}
+	err := WrapError(ErrorScanKilled, "scan", 1)
+	for _, code := range []ErrorCode{ErrorCodeOf(err), ErrorCodeUnknown} {
+		e := DecodeError(code, err.Error())
+		if !IsError(e, ErrorScanKilled) || e.Error() != err.Error() {
+			t.Fatalf("code %v: expected %v, got %v", code, err, e)
+		}
+	}
+	if e := DecodeError(ErrorCodeUnknown, "secondary.closed"); e != ErrorClosed {
+		t.Fatalf("expected %v, got %v", ErrorClosed, e)
+	}
+	if e := DecodeError(ErrorCodeUnknown, "unknown"); ErrorCodeOf(e) != ErrorCodeUnknown {
+		t.Fatalf("unexpected code for %v", e)
+	}
+}
+
+func TestCountError(t *testing.T) {
+	n := ErrorNotMyVbucket.Count()
+	err := WrapError(ErrorNotMyVbucket, "vbucket", 10)
+	if CountError(err) != err {
+		t.Fatalf("expected %v as is", err)
+	}
+	CountError(ErrorNotMyVbucket)
+	CountError(errors.New("plain"))
+	CountError(nil)
+	if ErrorNotMyVbucket.Count() != n+2 {
+		t.Fatalf("expected %v, got %v", n+2, ErrorNotMyVbucket.Count())
+	}
+	counts := ErrorCounts()
+	if counts["secondary.notMyVbucket"] != n+2 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+	if _, ok := counts["secondary.closed"]; ok && ErrorClosed.Count() == 0 {
+		t.Fatalf("unexpected count for %v", ErrorClosed)
+	}
+}
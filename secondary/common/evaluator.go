@@ -8,6 +8,15 @@ type Evaluator interface {
 	// Return the bucket name for which this evaluator is applicable.
 	Bucket() string
 
+	// Return the uuid of bucket when evaluator was defined, empty string
+	// if not known.
+	BucketUUID() string
+
+	// Return the key prefix of documents in evaluator's namespace, only
+	// documents within the namespace are to be evaluated. Empty string
+	// for all documents of the bucket.
+	Namespace() string
+
 	// StreamBeginData is generated for downstream.
 	StreamBeginData(vbno uint16, vbuuid, seqno uint64) (data interface{})
 
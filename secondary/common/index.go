@@ -117,8 +117,18 @@ type IndexDefn struct {
 	WhereExpr       string          `json:"where,omitempty"`
 	Deferred        bool            `json:"deferred,omitempty"`
 	Nodes           []string        `json:"nodes,omitempty"`
+	Include         []string        `json:"include,omitempty"`    // fields stored with each entry
+	BucketUUID      string          `json:"bucketUUID,omitempty"` // uuid of bucket when index was defined
+	Collation       string          `json:"collation,omitempty"`  // collation of string keys, binary by default
+	SchemaVersion   uint32          `json:"schemaVersion,omitempty"`
 }
 
+// IndexDefnSchemaVersion of index definitions marshalled by this version,
+// definitions persisted without a schema version are of version 0. Bump
+// it when the meaning of a persisted field changes, along with a
+// migration in the index manager.
+const IndexDefnSchemaVersion = 1
+
 //IndexInst is an instance of an Index(aka replica)
 type IndexInst struct {
 	InstId IndexInstId
@@ -143,6 +153,15 @@ func (idx IndexDefn) String() string {
 	str += fmt.Sprintf("\n\t\tPartitionScheme: %v ", idx.PartitionScheme)
 	str += fmt.Sprintf("PartitionKey: %v ", idx.PartitionKey)
 	str += fmt.Sprintf("WhereExpr: %v ", idx.WhereExpr)
+	if len(idx.Include) > 0 {
+		str += fmt.Sprintf("\n\t\tInclude: %v ", idx.Include)
+	}
+	if idx.BucketUUID != "" {
+		str += fmt.Sprintf("BucketUUID: %v ", idx.BucketUUID)
+	}
+	if idx.Collation != "" {
+		str += fmt.Sprintf("Collation: %v ", idx.Collation)
+	}
 	return str
 
 }
@@ -209,16 +228,23 @@ func CopyIndexInstMap(inMap IndexInstMap) IndexInstMap {
 
 func MarshallIndexDefn(defn *IndexDefn) ([]byte, error) {
 
-	buf, err := json.Marshal(&defn)
+	versioned := *defn
+	versioned.SchemaVersion = IndexDefnSchemaVersion
+	buf, err := json.Marshal(&versioned)
 	if err != nil {
 		return nil, err
 	}
 
-	return buf, nil
+	return AddChecksum(buf), nil
 }
 
 func UnmarshallIndexDefn(data []byte) (*IndexDefn, error) {
 
+	data, err := VerifyChecksum(data)
+	if err != nil {
+		return nil, err
+	}
+
 	defn := new(IndexDefn)
 	if err := json.Unmarshal(data, defn); err != nil {
 		return nil, err
@@ -0,0 +1,138 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import "errors"
+import "sync"
+
+// ErrIllegalStateTransition is returned when an index instance is moved to
+// a state that is not reachable from its current state.
+var ErrIllegalStateTransition = errors.New("illegal index state transition")
+
+// indexStateTransitions lists the legal next states for every index state.
+// An index instance is CREATED by the manager, READY once it is deployed on
+// the indexer, INITIAL while the initial build is in progress, CATCHUP when
+// the init stream is catching up with maintenance stream and ACTIVE once it
+// can be scanned. Indexer skips INITIAL and CATCHUP when there is nothing to
+// build, and skips READY for instances it has learnt about from manager.
+// INITIAL and CATCHUP move back to READY when the build fails and is to be
+// retried. Any state can move to DELETED or ERROR, DELETED is terminal.
+var indexStateTransitions = map[IndexState][]IndexState{
+	INDEX_STATE_CREATED: {
+		INDEX_STATE_READY, INDEX_STATE_INITIAL, INDEX_STATE_ACTIVE,
+		INDEX_STATE_DELETED, INDEX_STATE_ERROR,
+	},
+	INDEX_STATE_READY: {
+		INDEX_STATE_INITIAL, INDEX_STATE_ACTIVE,
+		INDEX_STATE_DELETED, INDEX_STATE_ERROR,
+	},
+	INDEX_STATE_INITIAL: {
+		INDEX_STATE_READY, INDEX_STATE_CATCHUP, INDEX_STATE_ACTIVE,
+		INDEX_STATE_DELETED, INDEX_STATE_ERROR,
+	},
+	INDEX_STATE_CATCHUP: {
+		INDEX_STATE_READY, INDEX_STATE_ACTIVE,
+		INDEX_STATE_DELETED, INDEX_STATE_ERROR,
+	},
+	INDEX_STATE_ACTIVE: {
+		INDEX_STATE_DELETED, INDEX_STATE_ERROR,
+	},
+	INDEX_STATE_ERROR: {
+		INDEX_STATE_DELETED,
+	},
+	INDEX_STATE_DELETED: {},
+}
+
+// CanTransitionTo returns whether an index instance in state `s` can move
+// to state `next`. Staying in the same state is always legal.
+func (s IndexState) CanTransitionTo(next IndexState) bool {
+	if s == next {
+		return true
+	}
+	for _, state := range indexStateTransitions[s] {
+		if state == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDeployed returns whether index instance is deployed on the indexer and
+// is visible to clients, that is, it is neither CREATED nor DELETED.
+func (s IndexState) IsDeployed() bool {
+	return s != INDEX_STATE_CREATED && s != INDEX_STATE_DELETED
+}
+
+// IsBuildable returns whether index instance is deployed and waiting for
+// its build to be kicked off.
+func (s IndexState) IsBuildable() bool {
+	return s == INDEX_STATE_READY
+}
+
+// IsBuilding returns whether index instance is being built from an init
+// stream or catching up with maintenance stream.
+func (s IndexState) IsBuilding() bool {
+	return s == INDEX_STATE_INITIAL || s == INDEX_STATE_CATCHUP
+}
+
+// IsStreaming returns whether index instance receives mutations from a
+// stream, that is, it is being built or is ACTIVE.
+func (s IndexState) IsStreaming() bool {
+	return s.IsBuilding() || s == INDEX_STATE_ACTIVE
+}
+
+// IsScannable returns whether index instance can serve scan requests.
+func (s IndexState) IsScannable() bool {
+	return s == INDEX_STATE_ACTIVE
+}
+
+// IndexStateHook is called after an index instance has moved from one
+// state to another.
+type IndexStateHook func(instId IndexInstId, from, to IndexState)
+
+// IndexStateMachine enforces legal transitions for index instances and
+// notifies registered hooks about every transition.
+type IndexStateMachine struct {
+	mu    sync.RWMutex
+	hooks []IndexStateHook
+}
+
+// NewIndexStateMachine returns a state machine without any hooks.
+func NewIndexStateMachine() *IndexStateMachine {
+	return &IndexStateMachine{}
+}
+
+// AddHook registers a hook to be called on every transition.
+func (m *IndexStateMachine) AddHook(hook IndexStateHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook)
+}
+
+// Transition validates the move of index instance `instId` from state
+// `from` to state `to` and calls hooks on success. Hooks are not called
+// when the state is unchanged. Caller is responsible for updating the
+// instance state once Transition returns without error.
+func (m *IndexStateMachine) Transition(instId IndexInstId, from, to IndexState) error {
+	if !from.CanTransitionTo(to) {
+		return ErrIllegalStateTransition
+	}
+	if from == to {
+		return nil
+	}
+
+	m.mu.RLock()
+	hooks := m.hooks
+	m.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(instId, from, to)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package common
+
+import "testing"
+
+func TestIndexStateTransitions(t *testing.T) {
+	legal := [][2]IndexState{
+		{INDEX_STATE_CREATED, INDEX_STATE_READY},
+		{INDEX_STATE_READY, INDEX_STATE_INITIAL},
+		{INDEX_STATE_READY, INDEX_STATE_ACTIVE},
+		{INDEX_STATE_INITIAL, INDEX_STATE_CATCHUP},
+		{INDEX_STATE_CATCHUP, INDEX_STATE_ACTIVE},
+		{INDEX_STATE_INITIAL, INDEX_STATE_READY},
+		{INDEX_STATE_CATCHUP, INDEX_STATE_READY},
+		{INDEX_STATE_ACTIVE, INDEX_STATE_DELETED},
+		{INDEX_STATE_ACTIVE, INDEX_STATE_ACTIVE},
+		{INDEX_STATE_ERROR, INDEX_STATE_DELETED},
+	}
+	for _, tc := range legal {
+		if !tc[0].CanTransitionTo(tc[1]) {
+			t.Errorf("expected %v -> %v to be legal", tc[0], tc[1])
+		}
+	}
+
+	illegal := [][2]IndexState{
+		{INDEX_STATE_ACTIVE, INDEX_STATE_INITIAL},
+		{INDEX_STATE_ACTIVE, INDEX_STATE_READY},
+		{INDEX_STATE_DELETED, INDEX_STATE_ACTIVE},
+		{INDEX_STATE_ERROR, INDEX_STATE_ACTIVE},
+		{INDEX_STATE_NIL, INDEX_STATE_ACTIVE},
+	}
+	for _, tc := range illegal {
+		if tc[0].CanTransitionTo(tc[1]) {
+			t.Errorf("expected %v -> %v to be illegal", tc[0], tc[1])
+		}
+	}
+}
+
+func TestIndexStateMachineHooks(t *testing.T) {
+	var transitions []IndexState
+	m := NewIndexStateMachine()
+	m.AddHook(func(instId IndexInstId, from, to IndexState) {
+		if instId != 10 {
+			t.Errorf("unexpected inst %v", instId)
+		}
+		transitions = append(transitions, from, to)
+	})
+
+	if err := m.Transition(10, INDEX_STATE_READY, INDEX_STATE_INITIAL); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Transition(10, INDEX_STATE_INITIAL, INDEX_STATE_INITIAL); err != nil {
+		t.Fatal(err)
+	}
+	err := m.Transition(10, INDEX_STATE_DELETED, INDEX_STATE_ACTIVE)
+	if err != ErrIllegalStateTransition {
+		t.Errorf("expected %v, got %v", ErrIllegalStateTransition, err)
+	}
+	if len(transitions) != 2 ||
+		transitions[0] != INDEX_STATE_READY ||
+		transitions[1] != INDEX_STATE_INITIAL {
+		t.Errorf("unexpected transitions %v", transitions)
+	}
+}
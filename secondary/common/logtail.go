@@ -0,0 +1,105 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import "strings"
+import "sync"
+
+// LogRing retains the most recent log messages in a ring buffer and
+// copies new messages to subscribers tailing the log. Install it with
+// SetLogTail().
+type LogRing struct {
+	mu          sync.Mutex
+	lines       []string
+	next        int // position for the next line
+	full        bool
+	subscribers map[chan string]bool
+}
+
+// NewLogRing returns a ring buffer retaining upto `size` log messages.
+func NewLogRing(size int) *LogRing {
+	return &LogRing{
+		lines:       make([]string, size),
+		subscribers: make(map[chan string]bool),
+	}
+}
+
+// Write implements io.Writer{} interface, every call to Write is a
+// single log message.
+func (r *LogRing) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.lines) > 0 {
+		r.lines[r.next] = line
+		r.next = (r.next + 1) % len(r.lines)
+		r.full = r.full || r.next == 0
+	}
+	for ch := range r.subscribers {
+		// logging shall not block on a slow subscriber, lines that
+		// the subscriber cannot keep up with are skipped.
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Recent returns retained log messages, oldest first.
+func (r *LogRing) Recent() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.recent()
+}
+
+// Subscribe returns retained log messages and a channel on which
+// subsequent messages are received, channel buffers upto `buflen`
+// messages. Caller must Unsubscribe the channel once done.
+func (r *LogRing) Subscribe(buflen int) ([]string, chan string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan string, buflen)
+	r.subscribers[ch] = true
+	return r.recent(), ch
+}
+
+// Unsubscribe a channel returned by Subscribe.
+func (r *LogRing) Unsubscribe(ch chan string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.subscribers, ch)
+}
+
+func (r *LogRing) recent() []string {
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	lines := make([]string, 0, len(r.lines))
+	lines = append(lines, r.lines[r.next:]...)
+	return append(lines, r.lines[:r.next]...)
+}
+
+// LogLineLevel returns the log level of a message logged by this package,
+// 0 for warning, error and fatal messages, which are always logged.
+func LogLineLevel(line string) int {
+	switch {
+	case strings.Contains(line, "[INFO ]"):
+		return LogLevelInfo
+	case strings.Contains(line, "[DEBUG]"):
+		return LogLevelDebug
+	case strings.Contains(line, "[TRACE]"):
+		return LogLevelTrace
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package common
+
+import "reflect"
+import "testing"
+
+func TestLogRingRecent(t *testing.T) {
+	r := NewLogRing(3)
+	r.Write([]byte("one\n"))
+	r.Write([]byte("two\n"))
+	if lines := r.Recent(); !reflect.DeepEqual(lines, []string{"one", "two"}) {
+		t.Errorf("unexpected %v", lines)
+	}
+
+	r.Write([]byte("three\n"))
+	r.Write([]byte("four\n"))
+	expected := []string{"two", "three", "four"}
+	if lines := r.Recent(); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %v, got %v", expected, lines)
+	}
+}
+
+func TestLogRingSubscribe(t *testing.T) {
+	r := NewLogRing(2)
+	r.Write([]byte("one\n"))
+
+	recent, ch := r.Subscribe(1)
+	if !reflect.DeepEqual(recent, []string{"one"}) {
+		t.Errorf("unexpected %v", recent)
+	}
+	r.Write([]byte("two\n"))
+	r.Write([]byte("three\n")) // skipped, subscriber is not reading
+	if line := <-ch; line != "two" {
+		t.Errorf("expected two, got %v", line)
+	}
+
+	r.Unsubscribe(ch)
+	r.Write([]byte("four\n"))
+	select {
+	case line := <-ch:
+		t.Errorf("unexpected %v after unsubscribe", line)
+	default:
+	}
+}
+
+func TestLogLineLevel(t *testing.T) {
+	testcases := map[string]int{
+		"12:00:00.000000 [INFO ] PROJ[:9999] started ...": LogLevelInfo,
+		"12:00:00.000000 [DEBUG] FEED[<=>x(y)] start":     LogLevelDebug,
+		"12:00:00.000000 [TRACE] VBRT[<-1<-b<-c #x] sync": LogLevelTrace,
+		"12:00:00.000000 [ERROR] KVDT[<-b<-c #x] failed":  0,
+	}
+	for line, level := range testcases {
+		if got := LogLineLevel(line); got != level {
+			t.Errorf("%q: expected %v, got %v", line, level, got)
+		}
+	}
+}
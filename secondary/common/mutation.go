@@ -29,8 +29,19 @@ const (
 	StreamBegin                    // control command
 	StreamEnd                      // control command
 	Snapshot                       // control command
+	Expiration                     // data command
 )
 
+// Versions of mutation stream, each version adds commands to the previous
+// one. Commands are downgraded for endpoints of an older version.
+const (
+	StreamVersion1 uint32 = iota + 1 // Upsert upto Snapshot
+	StreamVersion2                   // adds Expiration
+)
+
+// StreamVersion is the latest version of mutation stream.
+const StreamVersion = StreamVersion2
+
 // Payload either carries `vbmap` or `vbs`.
 type Payload struct {
 	Payltyp byte
@@ -119,6 +130,14 @@ func (vbmap *VbConnectionMap) GetVbuuid(vbno uint16) (uint64, error) {
 	return 0, ErrorNotMyVbucket
 }
 
+// ConnectionFrame describes the topic and the set of vbuckets, for each
+// bucket, carried by a downstream connection.
+type ConnectionFrame struct {
+	Topic  string
+	Vbmaps []*VbConnectionMap // one per bucket
+	Token  string             // registration token issued for the topic
+}
+
 // VbKeyVersions carries per vbucket key-versions for one or more mutations.
 type VbKeyVersions struct {
 	Bucket  string
@@ -185,6 +204,7 @@ type KeyVersions struct {
 	Keys      [][]byte // list of key-versions for each index
 	Oldkeys   [][]byte // previous key-versions, if available
 	Partnkeys [][]byte // partition key for each key-version
+	Values    [][]byte // projected fields for each key-version, if any
 }
 
 // NewKeyVersions return a reference KeyVersions for a single mutation.
@@ -194,15 +214,37 @@ func NewKeyVersions(seqno uint64, docid []byte, maxCount int) *KeyVersions {
 	kv.Commands = make([]byte, 0, maxCount)
 	kv.Keys = make([][]byte, 0, maxCount)
 	kv.Oldkeys = make([][]byte, 0, maxCount)
+	kv.Values = make([][]byte, 0, maxCount)
 	return kv
 }
 
 // addKey will add key-version for a single index.
-func (kv *KeyVersions) addKey(uuid uint64, command byte, key, oldkey []byte) {
+func (kv *KeyVersions) addKey(uuid uint64, command byte, key, oldkey, value []byte) {
 	kv.Uuids = append(kv.Uuids, uuid)
 	kv.Commands = append(kv.Commands, command)
 	kv.Keys = append(kv.Keys, key)
 	kv.Oldkeys = append(kv.Oldkeys, oldkey)
+	kv.Values = append(kv.Values, value)
+}
+
+// HasValues return true if any of the key-version carries projected
+// fields.
+func (kv *KeyVersions) HasValues() bool {
+	for _, value := range kv.Values {
+		if len(value) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Value return projected fields for i-th key-version, nil if not
+// available.
+func (kv *KeyVersions) Value(i int) []byte {
+	if i < len(kv.Values) && len(kv.Values[i]) > 0 {
+		return kv.Values[i]
+	}
+	return nil
 }
 
 // Equal compares for equality of two KeyVersions object.
@@ -217,7 +259,8 @@ func (kv *KeyVersions) Equal(other *KeyVersions) bool {
 		if uuid != other.Uuids[i] ||
 			kv.Commands[i] != other.Commands[i] ||
 			bytes.Compare(kv.Keys[i], other.Keys[i]) != 0 ||
-			bytes.Compare(kv.Oldkeys[i], other.Oldkeys[i]) != 0 {
+			bytes.Compare(kv.Oldkeys[i], other.Oldkeys[i]) != 0 ||
+			bytes.Compare(kv.Value(i), other.Value(i)) != 0 {
 			return false
 		}
 	}
@@ -236,37 +279,49 @@ func (kv *KeyVersions) Length() int {
 
 // AddUpsert add a new keyversion for same OpMutation.
 func (kv *KeyVersions) AddUpsert(uuid uint64, key, oldkey []byte) {
-	kv.addKey(uuid, Upsert, key, oldkey)
+	kv.addKey(uuid, Upsert, key, oldkey, nil)
+}
+
+// AddUpsertWithValue add a new keyversion for same OpMutation, along
+// with fields projected from the document.
+func (kv *KeyVersions) AddUpsertWithValue(uuid uint64, key, oldkey, value []byte) {
+	kv.addKey(uuid, Upsert, key, oldkey, value)
 }
 
 // AddDeletion add a new keyversion for same OpDeletion.
 func (kv *KeyVersions) AddDeletion(uuid uint64, oldkey []byte) {
-	kv.addKey(uuid, Deletion, nil, oldkey)
+	kv.addKey(uuid, Deletion, nil, oldkey, nil)
+}
+
+// AddExpiration add a new keyversion for same OpExpiration, deletion of
+// a document expired in KV.
+func (kv *KeyVersions) AddExpiration(uuid uint64, oldkey []byte) {
+	kv.addKey(uuid, Expiration, nil, oldkey, nil)
 }
 
 // AddUpsertDeletion add a keyversion command to delete old entry.
 func (kv *KeyVersions) AddUpsertDeletion(uuid uint64, oldkey []byte) {
-	kv.addKey(uuid, UpsertDeletion, nil, oldkey)
+	kv.addKey(uuid, UpsertDeletion, nil, oldkey, nil)
 }
 
 // AddSync add Sync command for vbucket heartbeat.
 func (kv *KeyVersions) AddSync() {
-	kv.addKey(0, Sync, nil, nil)
+	kv.addKey(0, Sync, nil, nil, nil)
 }
 
 // AddDropData add DropData command for trigger downstream catchup.
 func (kv *KeyVersions) AddDropData() {
-	kv.addKey(0, DropData, nil, nil)
+	kv.addKey(0, DropData, nil, nil, nil)
 }
 
 // AddStreamBegin add StreamBegin command for a new vbucket.
 func (kv *KeyVersions) AddStreamBegin() {
-	kv.addKey(0, StreamBegin, nil, nil)
+	kv.addKey(0, StreamBegin, nil, nil, nil)
 }
 
 // AddStreamEnd add StreamEnd command for a vbucket shutdown.
 func (kv *KeyVersions) AddStreamEnd() {
-	kv.addKey(0, StreamEnd, nil, nil)
+	kv.addKey(0, StreamEnd, nil, nil, nil)
 }
 
 // AddSnapshot add Snapshot command for a vbucket shutdown.
@@ -276,7 +331,7 @@ func (kv *KeyVersions) AddSnapshot(typ uint32, start, end uint64) {
 	var key, okey [8]byte
 	binary.BigEndian.PutUint64(key[:8], start)
 	binary.BigEndian.PutUint64(okey[:8], end)
-	kv.addKey(uint64(typ), Snapshot, key[:8], okey[:8])
+	kv.addKey(uint64(typ), Snapshot, key[:8], okey[:8], nil)
 }
 
 func (kv *KeyVersions) String() string {
@@ -1,5 +1,7 @@
 package common
 
+import "context"
+
 import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
 
 // Router definition for each instance (aka engine),
@@ -13,6 +15,11 @@ type Router interface {
 	// that are listening for this instance.
 	Endpoints() []string
 
+	// VbucketEndpoints return the subset of Endpoints() that own
+	// vbucket `vbno`, mutations from a vbucket are routed only to
+	// endpoints owning it.
+	VbucketEndpoints(vbno uint16) []string
+
 	// UpsertEndpoints return a list of endpoints <host:port>
 	// to which Upsert message will be published.
 	//   * `key` == nil, implies missing secondary key
@@ -43,9 +50,10 @@ type Router interface {
 }
 
 // RouterEndpointFactory will create a new endpoint instance for
-// {topic, remote-address}
+// {topic, remote-address}, `token` is the registration token issued
+// by the downstream for the topic.
 type RouterEndpointFactory func(
-	topic, endpointType, raddr string) (RouterEndpoint, error)
+	topic, endpointType, raddr, token string) (RouterEndpoint, error)
 
 // RouterEndpoint abstracts downstream for feed.
 type RouterEndpoint interface {
@@ -59,9 +67,14 @@ type RouterEndpoint interface {
 	// Send will post data to endpoint client, asynchronous call.
 	Send(data interface{}) error
 
-	// GetStatistics to gather statistics information from endpoint,
+	// SendVbmaps will post the set of vbuckets, for each bucket, that
+	// will be carried by this endpoint, replacing the set posted earlier,
 	// synchronous call.
-	GetStatistics() map[string]interface{}
+	SendVbmaps(vbmaps []*VbConnectionMap) error
+
+	// GetStatistics to gather statistics information from endpoint,
+	// nil if `ctx` expires before endpoint responds, synchronous call.
+	GetStatistics(ctx context.Context) map[string]interface{}
 
 	// Close will shutdown this endpoint and release its resources,
 	// synchronous call.
@@ -0,0 +1,261 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import "encoding/json"
+import "errors"
+import "math"
+import "sort"
+import "strconv"
+import "sync/atomic"
+
+// ErrorHistogramBounds is returned when merging histograms having
+// different bucket bounds.
+var ErrorHistogramBounds = errors.New("secondary.histogramBounds")
+
+// Counter is a statistic that only moves forward, like no. of mutations
+// received. Zero value is ready to use and safe for concurrent updates.
+type Counter struct {
+	value int64
+}
+
+// Add `delta` to counter.
+func (c *Counter) Add(delta int64) {
+	atomic.AddInt64(&c.value, delta)
+}
+
+// Value of counter.
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+// Merge adds `other` counter's value to this counter.
+func (c *Counter) Merge(other *Counter) {
+	c.Add(other.Value())
+}
+
+// MarshalJSON implements json.Marshaler interface.
+func (c *Counter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Value())
+}
+
+// Gauge is a statistic that can go up and down, like no. of active
+// connections. Zero value is ready to use and safe for concurrent updates.
+type Gauge struct {
+	value int64
+}
+
+// Set gauge to `value`.
+func (g *Gauge) Set(value int64) {
+	atomic.StoreInt64(&g.value, value)
+}
+
+// Add `delta` to gauge, which can be negative.
+func (g *Gauge) Add(delta int64) {
+	atomic.AddInt64(&g.value, delta)
+}
+
+// Value of gauge.
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+// Merge adds `other` gauge's value to this gauge, to aggregate gauges of
+// several components.
+func (g *Gauge) Merge(other *Gauge) {
+	g.Add(other.Value())
+}
+
+// MarshalJSON implements json.Marshaler interface.
+func (g *Gauge) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.Value())
+}
+
+// Histogram counts samples, like latencies, into buckets so that their
+// distribution can be reported as percentiles. Each bucket counts samples
+// upto its bound, samples beyond the highest bound are counted in an
+// overflow bucket. Safe for concurrent updates.
+type Histogram struct {
+	bounds []int64 // upper bound of each bucket, in ascending order
+	counts []int64 // len(bounds)+1, last one is the overflow bucket
+	count  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+// NewHistogram returns a histogram with buckets upto `bounds`.
+func NewHistogram(bounds []int64) *Histogram {
+	h := &Histogram{
+		bounds: make([]int64, len(bounds)),
+		counts: make([]int64, len(bounds)+1),
+		min:    math.MaxInt64,
+		max:    math.MinInt64,
+	}
+	copy(h.bounds, bounds)
+	sort.Sort(int64Slice(h.bounds))
+	return h
+}
+
+// NewLatencyHistogram returns a histogram for latencies in nanoseconds,
+// with buckets doubling from 1 microsecond upto a minute.
+func NewLatencyHistogram() *Histogram {
+	bounds := make([]int64, 0)
+	for bound := int64(1000); bound <= 60*1000*1000*1000; bound *= 2 {
+		bounds = append(bounds, bound)
+	}
+	return NewHistogram(bounds)
+}
+
+// Add a sample to histogram.
+func (h *Histogram) Add(val int64) {
+	i := sort.Search(len(h.bounds), func(i int) bool {
+		return val <= h.bounds[i]
+	})
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, val)
+	for min := atomic.LoadInt64(&h.min); val < min; {
+		if atomic.CompareAndSwapInt64(&h.min, min, val) {
+			break
+		}
+		min = atomic.LoadInt64(&h.min)
+	}
+	for max := atomic.LoadInt64(&h.max); val > max; {
+		if atomic.CompareAndSwapInt64(&h.max, max, val) {
+			break
+		}
+		max = atomic.LoadInt64(&h.max)
+	}
+}
+
+// Count of samples added.
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// Sum of samples added.
+func (h *Histogram) Sum() int64 {
+	return atomic.LoadInt64(&h.sum)
+}
+
+// Min is the smallest sample added, 0 if histogram is empty.
+func (h *Histogram) Min() int64 {
+	if h.Count() == 0 {
+		return 0
+	}
+	return atomic.LoadInt64(&h.min)
+}
+
+// Max is the largest sample added, 0 if histogram is empty.
+func (h *Histogram) Max() int64 {
+	if h.Count() == 0 {
+		return 0
+	}
+	return atomic.LoadInt64(&h.max)
+}
+
+// Mean of samples added, 0 if histogram is empty.
+func (h *Histogram) Mean() int64 {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	return h.Sum() / count
+}
+
+// Percentile returns the bound of the bucket where `p` percent of samples
+// are accounted for, capped to the largest sample. 0 if histogram is empty.
+func (h *Histogram) Percentile(p float64) int64 {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(float64(count) * p / 100))
+	if target < 1 {
+		target = 1
+	}
+	max, cumulative := h.Max(), int64(0)
+	for i := range h.bounds {
+		cumulative += atomic.LoadInt64(&h.counts[i])
+		if cumulative >= target {
+			if h.bounds[i] < max {
+				return h.bounds[i]
+			}
+			return max
+		}
+	}
+	return max
+}
+
+// Merge samples of `other` histogram into this histogram, both shall have
+// the same bounds.
+func (h *Histogram) Merge(other *Histogram) error {
+	if len(h.bounds) != len(other.bounds) {
+		return ErrorHistogramBounds
+	}
+	for i, bound := range h.bounds {
+		if other.bounds[i] != bound {
+			return ErrorHistogramBounds
+		}
+	}
+	if other.Count() == 0 {
+		return nil
+	}
+	for i := range h.counts {
+		atomic.AddInt64(&h.counts[i], atomic.LoadInt64(&other.counts[i]))
+	}
+	atomic.AddInt64(&h.count, other.Count())
+	atomic.AddInt64(&h.sum, other.Sum())
+	for min, omin := atomic.LoadInt64(&h.min), other.Min(); omin < min; {
+		if atomic.CompareAndSwapInt64(&h.min, min, omin) {
+			break
+		}
+		min = atomic.LoadInt64(&h.min)
+	}
+	for max, omax := atomic.LoadInt64(&h.max), other.Max(); omax > max; {
+		if atomic.CompareAndSwapInt64(&h.max, max, omax) {
+			break
+		}
+		max = atomic.LoadInt64(&h.max)
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface. Buckets are keyed by
+// their bound, overflow bucket as "+Inf", empty buckets are skipped.
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	buckets := make(map[string]int64)
+	for i, bound := range h.bounds {
+		if n := atomic.LoadInt64(&h.counts[i]); n > 0 {
+			buckets[strconv.FormatInt(bound, 10)] = n
+		}
+	}
+	if n := atomic.LoadInt64(&h.counts[len(h.bounds)]); n > 0 {
+		buckets["+Inf"] = n
+	}
+	return json.Marshal(map[string]interface{}{
+		"count":   h.Count(),
+		"sum":     h.Sum(),
+		"min":     h.Min(),
+		"max":     h.Max(),
+		"mean":    h.Mean(),
+		"p50":     h.Percentile(50),
+		"p90":     h.Percentile(90),
+		"p99":     h.Percentile(99),
+		"buckets": buckets,
+	})
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,101 @@
+package common
+
+import "encoding/json"
+import "sync"
+import "testing"
+
+func TestCounter(t *testing.T) {
+	var c Counter
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if c.Value() != 1000 {
+		t.Fatalf("expected 1000, got %v", c.Value())
+	}
+
+	var other Counter
+	other.Add(24)
+	c.Merge(&other)
+	if c.Value() != 1024 {
+		t.Fatalf("expected 1024 after merge, got %v", c.Value())
+	}
+	if data, err := json.Marshal(&c); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "1024" {
+		t.Fatalf("unexpected JSON %s", data)
+	}
+}
+
+func TestGauge(t *testing.T) {
+	var g Gauge
+	g.Set(10)
+	g.Add(-3)
+	if g.Value() != 7 {
+		t.Fatalf("expected 7, got %v", g.Value())
+	}
+	stats := Statistics{"connections": &g}
+	if data, err := stats.Encode(); err != nil {
+		t.Fatal(err)
+	} else if string(data) != `{"connections":7}` {
+		t.Fatalf("unexpected JSON %s", data)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]int64{100, 10, 1000})
+	if h.Percentile(50) != 0 || h.Min() != 0 || h.Max() != 0 {
+		t.Fatalf("expected zero values for empty histogram")
+	}
+	for i := int64(1); i <= 100; i++ {
+		h.Add(i)
+	}
+	if h.Count() != 100 || h.Sum() != 5050 {
+		t.Fatalf("unexpected count %v, sum %v", h.Count(), h.Sum())
+	}
+	if h.Min() != 1 || h.Max() != 100 || h.Mean() != 50 {
+		t.Fatalf("unexpected min %v, max %v, mean %v", h.Min(), h.Max(), h.Mean())
+	}
+	if p := h.Percentile(10); p != 10 {
+		t.Fatalf("expected p10 as 10, got %v", p)
+	}
+	if p := h.Percentile(50); p != 100 {
+		t.Fatalf("expected p50 as 100, got %v", p)
+	}
+
+	// overflow bucket is reported as the largest sample
+	h.Add(5000)
+	if p := h.Percentile(100); p != 5000 {
+		t.Fatalf("expected p100 as 5000, got %v", p)
+	}
+
+	other := NewHistogram([]int64{10, 100, 1000})
+	other.Add(0)
+	if err := h.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	if h.Count() != 102 || h.Min() != 0 {
+		t.Fatalf("unexpected count %v, min %v after merge", h.Count(), h.Min())
+	}
+	if err := h.Merge(NewLatencyHistogram()); err != ErrorHistogramBounds {
+		t.Fatalf("expected %v, got %v", ErrorHistogramBounds, err)
+	}
+
+	var m map[string]interface{}
+	if data, err := json.Marshal(h); err != nil {
+		t.Fatal(err)
+	} else if err = json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	buckets := m["buckets"].(map[string]interface{})
+	if m["count"].(float64) != 102 || buckets["+Inf"].(float64) != 1 {
+		t.Fatalf("unexpected JSON %v", m)
+	}
+}
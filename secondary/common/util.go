@@ -1,9 +1,14 @@
 package common
 
+import "context"
+import "crypto/tls"
+import "crypto/x509"
 import "errors"
 import "fmt"
 import "io"
+import "io/ioutil"
 import "net"
+import "net/http"
 import "net/url"
 import "os"
 import "strings"
@@ -138,6 +143,20 @@ func FailsafeOp(
 	cmd []interface{},
 	finch chan bool) ([]interface{}, error) {
 
+	return FailsafeOpContext(context.Background(), reqch, respch, cmd, finch)
+}
+
+// FailsafeOpContext is same as FailsafeOp, in addition the caller can give
+// up waiting on the gen-server when `ctx` is cancelled or its deadline
+// expires, in which case ctx.Err() is returned. Since gen-server might
+// still respond after the caller has given up, `respch` should be
+// buffered.
+func FailsafeOpContext(
+	ctx context.Context,
+	reqch, respch chan []interface{},
+	cmd []interface{},
+	finch chan bool) ([]interface{}, error) {
+
 	select {
 	case reqch <- cmd:
 		if respch != nil {
@@ -146,10 +165,14 @@ func FailsafeOp(
 				return resp, nil
 			case <-finch:
 				return nil, ErrorClosed
+			case <-ctx.Done():
+				return nil, ctx.Err()
 			}
 		}
 	case <-finch:
 		return nil, ErrorClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 	return nil, nil
 }
@@ -192,6 +215,16 @@ func OpError(err error, vals []interface{}, idx int) error {
 	return vals[idx].(error)
 }
 
+// IsAdminRequest returns whether the http request carries credentials
+// of a cluster administrator, authenticated with cbauth.
+func IsAdminRequest(r *http.Request) (bool, error) {
+	creds, err := cbauth.AuthWebCreds(r)
+	if err != nil {
+		return false, err
+	}
+	return creds.IsAdmin()
+}
+
 // cbauth admin authentication helper
 // Uses default cbauth env variables internally to provide auth creds
 type cbAuthHandler struct {
@@ -218,6 +251,103 @@ func (ah *cbAuthHandler) AuthenticateMemcachedConn(host string, conn *memcached.
 	return err
 }
 
+// Authentication helper with explicit credentials from configuration.
+// With an empty username, connections are authenticated as a SASL bucket
+// using bucket name and password.
+type credsAuthHandler struct {
+	username string
+	password string
+	bucket   string
+}
+
+func (ah *credsAuthHandler) GetCredentials() (string, string) {
+	if ah.username == "" {
+		return ah.bucket, ah.password
+	}
+	return ah.username, ah.password
+}
+
+func (ah *credsAuthHandler) AuthenticateMemcachedConn(host string, conn *memcached.Client) error {
+	u, p := ah.GetCredentials()
+	if ah.username == "" && u == "default" && p == "" {
+		return nil // default bucket needs no authentication
+	}
+	if _, err := conn.Auth(u, p); err != nil {
+		return err
+	}
+	if ah.username != "" {
+		_, err := conn.SelectBucket(ah.bucket)
+		return err
+	}
+	return nil
+}
+
+// NewAuthHandler returns the authentication handler for REST and
+// memcached connections to `bucketn`. If neither username nor password
+// is supplied credentials are obtained from cbauth.
+func NewAuthHandler(
+	cluster, bucketn, username, password string) couchbase.AuthHandler {
+
+	if username == "" && password == "" {
+		return &cbAuthHandler{hostport: cluster, bucket: bucketn}
+	}
+	return &credsAuthHandler{
+		username: username,
+		password: password,
+		bucket:   bucketn,
+	}
+}
+
+// NewKVTLSConfig returns TLS configuration and port for memcached
+// connections from `dcp.tls.*` parameters in `config`, nil if TLS is not
+// enabled.
+func NewKVTLSConfig(config Config) (*tls.Config, int, error) {
+	tlsConfig, err := NewClientTLSConfig(config.SectionConfig("dcp.", true))
+	if err != nil || tlsConfig == nil {
+		return nil, 0, err
+	}
+	return tlsConfig, config["dcp.tls.port"].Int(), nil
+}
+
+// NewServerTLSConfig returns TLS configuration to serve connections with
+// from `tls.certFile` and `tls.keyFile` parameters in `config`, nil if
+// no certificate is configured.
+func NewServerTLSConfig(config Config) (*tls.Config, error) {
+	certFile := config["tls.certFile"].String()
+	if certFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, config["tls.keyFile"].String())
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// NewClientTLSConfig returns TLS configuration to connect with from
+// `tls.enabled`, `tls.caFile` and `tls.insecureSkipVerify` parameters in
+// `config`, nil if TLS is not enabled. Shared by queryport clients and,
+// through NewKVTLSConfig, by DCP connections to KV.
+func NewClientTLSConfig(config Config) (*tls.Config, error) {
+	if !config["tls.enabled"].Bool() {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config["tls.insecureSkipVerify"].Bool(),
+	}
+	if caFile := config["tls.caFile"].String(); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, ErrorInvalidCACert
+		}
+	}
+	return tlsConfig, nil
+}
+
 // ConnectBucket will instantiate a couchbase-bucket instance with cluster.
 // caller's responsibility to close the bucket.
 func ConnectBucket(cluster, pooln, bucketn string) (*couchbase.Bucket, error) {
@@ -225,7 +355,29 @@ func ConnectBucket(cluster, pooln, bucketn string) (*couchbase.Bucket, error) {
 		hostport: cluster,
 		bucket:   bucketn,
 	}
-	couch, err := couchbase.ConnectWithAuth("http://"+cluster, ah)
+	return ConnectBucketWithAuth(cluster, pooln, bucketn, ah)
+}
+
+// ConnectBucketWithAuth will instantiate a couchbase-bucket instance with
+// cluster, authenticating REST calls and memcached connections, including
+// DCP streams, with `ah`. caller's responsibility to close the bucket.
+func ConnectBucketWithAuth(
+	cluster, pooln, bucketn string,
+	ah couchbase.AuthHandler) (*couchbase.Bucket, error) {
+
+	return ConnectBucketWithTLS(cluster, pooln, bucketn, ah, nil, 0)
+}
+
+// ConnectBucketWithTLS is same as ConnectBucketWithAuth, with memcached
+// connections made over TLS using `config` to `port` on every node, or
+// to its advertised kvSSL port if `port` is 0. A nil `config` connects
+// in plain text.
+func ConnectBucketWithTLS(
+	cluster, pooln, bucketn string,
+	ah couchbase.AuthHandler,
+	config *tls.Config, port int) (*couchbase.Bucket, error) {
+
+	couch, err := couchbase.ConnectWithAuthTLS("http://"+cluster, ah, config, port)
 	if err != nil {
 		return nil, err
 	}
@@ -387,6 +539,25 @@ func ClusterAuthUrl(cluster string) (string, error) {
 	return clusterUrl.String(), nil
 }
 
+// ClusterAuthUrlWithAuth is like ClusterAuthUrl, but takes credentials
+// from the authentication handler returned by NewAuthHandler.
+func ClusterAuthUrlWithAuth(
+	cluster string, ah couchbase.AuthHandler) (string, error) {
+
+	if _, ok := ah.(*cbAuthHandler); ok {
+		return ClusterAuthUrl(cluster)
+	}
+
+	user, passwd := ah.GetCredentials()
+	clusterUrl := url.URL{
+		Scheme: "http",
+		Host:   cluster,
+		User:   url.UserPassword(user, passwd),
+	}
+
+	return clusterUrl.String(), nil
+}
+
 func ClusterUrl(cluster string) string {
 	host := cluster
 	if strings.HasPrefix(cluster, "http") {
@@ -404,6 +575,20 @@ func ClusterUrl(cluster string) string {
 	return clusterUrl.String()
 }
 
+// RedactURL masks the password in userinfo of a URL, to log or report
+// cluster addresses that may embed credentials. Addresses that are not
+// URLs, like host:port, are returned as is.
+func RedactURL(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || u.User == nil {
+		return addr
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), Redacted)
+	}
+	return u.String()
+}
+
 func MaybeSetEnv(key, value string) string {
 	if s := os.Getenv(key); s != "" {
 		return s
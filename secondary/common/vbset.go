@@ -0,0 +1,245 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import "encoding/binary"
+import "errors"
+import "fmt"
+import "math/bits"
+import "strings"
+
+// ErrInvalidVbSet is returned when decoding a malformed vbucket set.
+var ErrInvalidVbSet = errors.New("invalid vbucket set encoding")
+
+// VbSet is a set of vbucket numbers, stored as a bitset. Membership,
+// insert and delete are constant time and set operations work a word of
+// 64 vbuckets at a time, which is cheaper than searching and filtering
+// []uint16 lists of 1024 vbuckets. A nil VbSet is an empty set for all
+// read-only operations.
+type VbSet struct {
+	words []uint64
+}
+
+// NewVbSet creates a set of vbuckets.
+func NewVbSet(vbnos ...uint16) *VbSet {
+	s := &VbSet{}
+	for _, vbno := range vbnos {
+		s.Add(vbno)
+	}
+	return s
+}
+
+// NewVbSet32 creates a set of vbuckets from 32-bit vbucket values,
+// normally used for protobuf.
+func NewVbSet32(vbnos []uint32) *VbSet {
+	s := &VbSet{}
+	for _, vbno := range vbnos {
+		s.Add(uint16(vbno))
+	}
+	return s
+}
+
+// NewVbSetFromBytes decodes a set encoded by Bytes().
+func NewVbSetFromBytes(data []byte) (*VbSet, error) {
+	if len(data)%8 != 0 {
+		return nil, ErrInvalidVbSet
+	}
+	s := &VbSet{words: make([]uint64, len(data)/8)}
+	for i := range s.words {
+		s.words[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return s, nil
+}
+
+// Add vbucket to the set.
+func (s *VbSet) Add(vbno uint16) *VbSet {
+	i := int(vbno >> 6)
+	if i >= len(s.words) {
+		words := make([]uint64, i+1)
+		copy(words, s.words)
+		s.words = words
+	}
+	s.words[i] |= 1 << (vbno & 63)
+	return s
+}
+
+// Remove vbucket from the set.
+func (s *VbSet) Remove(vbno uint16) *VbSet {
+	if i := int(vbno >> 6); s != nil && i < len(s.words) {
+		s.words[i] &^= 1 << (vbno & 63)
+	}
+	return s
+}
+
+// Has does membership check for vbucket.
+func (s *VbSet) Has(vbno uint16) bool {
+	if i := int(vbno >> 6); s != nil && i < len(s.words) {
+		return s.words[i]&(1<<(vbno&63)) != 0
+	}
+	return false
+}
+
+// Len returns the number of vbuckets in the set.
+func (s *VbSet) Len() int {
+	n := 0
+	if s != nil {
+		for _, word := range s.words {
+			n += bits.OnesCount64(word)
+		}
+	}
+	return n
+}
+
+// IsEmpty returns true if the set has no vbuckets.
+func (s *VbSet) IsEmpty() bool {
+	if s != nil {
+		for _, word := range s.words {
+			if word != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Clone creates a new copy of the set.
+func (s *VbSet) Clone() *VbSet {
+	newset := &VbSet{}
+	if s != nil {
+		newset.words = append(newset.words, s.words...)
+	}
+	return newset
+}
+
+// Equal returns true if both sets have the same vbuckets.
+func (s *VbSet) Equal(other *VbSet) bool {
+	return s.Diff(other).IsEmpty() && other.Diff(s).IsEmpty()
+}
+
+// Union returns a new set of vbuckets present in atleast one set.
+func (s *VbSet) Union(other *VbSet) *VbSet {
+	newset, o := s.Clone(), other.wordsOf()
+	if len(o) > len(newset.words) {
+		words := make([]uint64, len(o))
+		copy(words, newset.words)
+		newset.words = words
+	}
+	for i, word := range o {
+		newset.words[i] |= word
+	}
+	return newset
+}
+
+// Intersect returns a new set of vbuckets present in both sets.
+func (s *VbSet) Intersect(other *VbSet) *VbSet {
+	newset, o := s.Clone(), other.wordsOf()
+	for i := range newset.words {
+		if i < len(o) {
+			newset.words[i] &= o[i]
+		} else {
+			newset.words[i] = 0
+		}
+	}
+	return newset
+}
+
+// Diff returns a new set of vbuckets present in this set and not in
+// `other`.
+func (s *VbSet) Diff(other *VbSet) *VbSet {
+	newset, o := s.Clone(), other.wordsOf()
+	for i := range newset.words {
+		if i < len(o) {
+			newset.words[i] &^= o[i]
+		}
+	}
+	return newset
+}
+
+// Range calls `callb` for each vbucket in the set in ascending order,
+// until callb returns false.
+func (s *VbSet) Range(callb func(vbno uint16) bool) {
+	for i, word := range s.wordsOf() {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			if !callb(uint16(i<<6 + bit)) {
+				return
+			}
+			word &= word - 1
+		}
+	}
+}
+
+// Vbnos returns a sorted list of vbuckets in the set.
+func (s *VbSet) Vbnos() []uint16 {
+	vbnos := make([]uint16, 0, s.Len())
+	s.Range(func(vbno uint16) bool {
+		vbnos = append(vbnos, vbno)
+		return true
+	})
+	return vbnos
+}
+
+// Vbnos32 returns a sorted list of vbuckets in the set as 32-bit values,
+// normally used for protobuf.
+func (s *VbSet) Vbnos32() []uint32 {
+	vbnos := make([]uint32, 0, s.Len())
+	s.Range(func(vbno uint16) bool {
+		vbnos = append(vbnos, uint32(vbno))
+		return true
+	})
+	return vbnos
+}
+
+// Bytes encodes the set as little-endian 64-bit words, trailing empty
+// words are not encoded. A set of 1024 vbuckets encodes to atmost
+// 128 bytes.
+func (s *VbSet) Bytes() []byte {
+	words := s.wordsOf()
+	for len(words) > 0 && words[len(words)-1] == 0 {
+		words = words[:len(words)-1]
+	}
+	data := make([]byte, len(words)*8)
+	for i, word := range words {
+		binary.LittleEndian.PutUint64(data[i*8:], word)
+	}
+	return data
+}
+
+// String returns the set as a list of vbucket ranges, like "[0-511 600]".
+func (s *VbSet) String() string {
+	ranges := make([]string, 0)
+	first, last := -1, -1
+	flush := func() {
+		if first < 0 {
+			return
+		} else if first == last {
+			ranges = append(ranges, fmt.Sprintf("%v", first))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%v-%v", first, last))
+		}
+	}
+	s.Range(func(vbno uint16) bool {
+		if first < 0 || int(vbno) != last+1 {
+			flush()
+			first = int(vbno)
+		}
+		last = int(vbno)
+		return true
+	})
+	flush()
+	return "[" + strings.Join(ranges, " ") + "]"
+}
+
+func (s *VbSet) wordsOf() []uint64 {
+	if s == nil {
+		return nil
+	}
+	return s.words
+}
@@ -0,0 +1,81 @@
+package common
+
+import "reflect"
+import "testing"
+
+func TestVbSetOperations(t *testing.T) {
+	s := NewVbSet(1, 5, 64, 1023)
+	if s.Len() != 4 || !s.Has(64) || s.Has(2) || s.Has(2000) {
+		t.Fatalf("unexpected membership %v", s)
+	}
+	s.Remove(5).Remove(2000)
+	if ref := []uint16{1, 64, 1023}; !reflect.DeepEqual(s.Vbnos(), ref) {
+		t.Fatalf("expected %v, got %v", ref, s.Vbnos())
+	}
+
+	other := NewVbSet32([]uint32{64, 100})
+	if ref := []uint16{1, 64, 100, 1023}; !reflect.DeepEqual(s.Union(other).Vbnos(), ref) {
+		t.Fatalf("union expected %v, got %v", ref, s.Union(other).Vbnos())
+	}
+	if ref := []uint16{64}; !reflect.DeepEqual(s.Intersect(other).Vbnos(), ref) {
+		t.Fatalf("intersect expected %v, got %v", ref, s.Intersect(other).Vbnos())
+	}
+	if ref := []uint16{1, 1023}; !reflect.DeepEqual(s.Diff(other).Vbnos(), ref) {
+		t.Fatalf("diff expected %v, got %v", ref, s.Diff(other).Vbnos())
+	}
+	if ref := []uint32{100}; !reflect.DeepEqual(other.Diff(s).Vbnos32(), ref) {
+		t.Fatalf("diff expected %v, got %v", ref, other.Diff(s).Vbnos32())
+	}
+	if s.Len() != 3 || other.Len() != 2 {
+		t.Fatalf("set operations shall not modify operands")
+	}
+
+	var empty *VbSet
+	if !empty.IsEmpty() || empty.Has(1) || empty.Len() != 0 {
+		t.Fatalf("expected nil set to be empty")
+	}
+	if !empty.Union(s).Equal(s) || !s.Intersect(empty).IsEmpty() {
+		t.Fatalf("unexpected set operations with nil set")
+	}
+}
+
+func TestVbSetEncoding(t *testing.T) {
+	s := NewVbSet()
+	for i := 0; i < 512; i++ {
+		s.Add(uint16(i))
+	}
+	s.Add(600).Add(1023).Remove(1023)
+	if str := s.String(); str != "[0-511 600]" {
+		t.Fatalf("unexpected string %q", str)
+	}
+	data := s.Bytes()
+	if len(data) != 80 {
+		t.Fatalf("expected trailing empty words to be trimmed, got %v bytes", len(data))
+	}
+	t2, err := NewVbSetFromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if !t2.Equal(s) {
+		t.Fatalf("expected %v, got %v", s, t2)
+	}
+	if _, err := NewVbSetFromBytes(data[:7]); err != ErrInvalidVbSet {
+		t.Fatalf("expected %v, got %v", ErrInvalidVbSet, err)
+	}
+	if str := NewVbSet().String(); str != "[]" {
+		t.Fatalf("unexpected string %q", str)
+	}
+}
+
+func BenchmarkVbSetDiff(b *testing.B) {
+	s1, s2 := NewVbSet(), NewVbSet()
+	for i := 0; i < 1024; i++ {
+		s1.Add(uint16(i))
+		if i%2 == 0 {
+			s2.Add(uint16(i))
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s1.Diff(s2)
+	}
+}
@@ -21,6 +21,7 @@ var commandNames = map[byte]string{
 	c.StreamBegin:    "StreamBegin",
 	c.StreamEnd:      "StreamEnd",
 	c.Snapshot:       "Snapshot",
+	c.Expiration:     "Expiration",
 }
 
 // Application starts a new dataport application to receive mutations from the
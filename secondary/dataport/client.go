@@ -98,7 +98,7 @@ func NewClient(
 		bufferTimeout: time.Duration(config["bufferTimeout"].Int()),
 		logPrefixes:   make(map[int]string),
 	}
-	c.logPrefix = fmt.Sprintf("ENDC[%v<-%v #%v]", raddr, cluster, topic)
+	c.logPrefix = fmt.Sprintf("ENDC[%v<-%v #%v]", raddr, common.RedactURL(cluster), topic)
 	// open connections with remote
 	for i := 0; i < parConns; i++ {
 		if conn, err = net.Dial("tcp", raddr); err != nil {
@@ -110,7 +110,7 @@ func NewClient(
 		c.connChans[i] = make(chan interface{}, mutChanSize)
 		c.conn2Vbs[i] = make([]string, 0, c.maxVbuckets/10)
 		c.logPrefixes[i] = fmt.Sprintf(
-			"ENDC[%v<-%v<-%v #%v]", raddr, conn.LocalAddr(), cluster, topic)
+			"ENDC[%v<-%v<-%v #%v]", raddr, conn.LocalAddr(), common.RedactURL(cluster), topic)
 	}
 	// spawn routines per connection.
 	quitch := make(chan []string, len(c.conns)*2)
@@ -365,9 +365,7 @@ func (c *Client) runTransmitter(
 		quitch <- []string{"quit", laddr}
 	}()
 
-	pkt := transport.NewTransportPacket(c.maxPayload, flags)
-	pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
-	pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	pkt := newProtobufPacket(c.maxPayload, flags)
 
 	transmit := func(payload interface{}) bool {
 		if err := pkt.Send(conn, payload); err != nil {
@@ -0,0 +1,382 @@
+// Conformance of dataport framing against golden frames in testdata/.
+//
+// Each golden frame is the exact bytes that an endpoint puts on the wire
+// for a payload, refer docs/dataport.rst, stored as hex. Projector
+// (endpoint) and indexer (server) shall agree on these bytes, a change in
+// encoding or framing that breaks them is a protocol change and needs a
+// new protobuf version.
+//
+// To regenerate golden frames after an intended protocol change,
+//
+//      go test -run Conformance -update
+
+package dataport
+
+import "bytes"
+import "compress/gzip"
+import "encoding/binary"
+import "encoding/hex"
+import "flag"
+import "fmt"
+import "io/ioutil"
+import "math"
+import "path/filepath"
+import "strings"
+import "testing"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
+import "github.com/couchbase/indexing/secondary/transport"
+
+var updateGolden = flag.Bool("update", false, "rewrite golden frames in testdata/")
+
+const confMaxPayload = 1000 * 1024
+
+type conformanceCase struct {
+	name    string
+	desc    string
+	payload interface{} // nil if the frame cannot be encoded by endpoint.
+	err     error       // expected while decoding the frame.
+}
+
+func conformanceCases() []conformanceCase {
+	upsert := c.NewKeyVersions(10, []byte("doc1"), 2)
+	upsert.AddUpsert(100, []byte(`["city"]`), []byte(`["town"]`))
+	upsert.AddUpsert(200, []byte(`["a"]`), nil)
+
+	upsertValue := c.NewKeyVersions(11, []byte("doc1"), 2)
+	upsertValue.AddUpsertWithValue(100, []byte(`["city"]`), nil, []byte(`{"age":30}`))
+	upsertValue.AddUpsert(200, []byte(`["b"]`), nil)
+
+	deletion := c.NewKeyVersions(12, []byte("doc2"), 2)
+	deletion.AddDeletion(100, []byte(`["city"]`))
+	deletion.AddUpsertDeletion(200, []byte(`["a"]`))
+	expiration := c.NewKeyVersions(13, []byte("doc3"), 1)
+	expiration.AddExpiration(100, []byte(`["town"]`))
+
+	begin := c.NewKeyVersions(0, nil, 1)
+	begin.AddStreamBegin()
+	snapshot := c.NewKeyVersions(0, nil, 1)
+	snapshot.AddSnapshot(1, 10, 20)
+	sync := c.NewKeyVersions(20, nil, 1)
+	sync.AddSync()
+	dropData := c.NewKeyVersions(21, nil, 1)
+	dropData.AddDropData()
+	end := c.NewKeyVersions(21, nil, 1)
+	end.AddStreamEnd()
+
+	vbs1 := c.NewKeyVersions(1, []byte("a"), 1)
+	vbs1.AddUpsert(100, []byte(`["x"]`), nil)
+	vbs2 := c.NewKeyVersions(1, []byte("b"), 1)
+	vbs2.AddUpsert(300, []byte(`["y"]`), nil)
+
+	largeKey := c.NewKeyVersions(1<<40, []byte("doc-large"), 1)
+	key := `["` + strings.Repeat("k", 300) + `"]`
+	largeKey.AddUpsert(math.MaxUint64, []byte(key), nil)
+
+	return []conformanceCase{
+		{
+			name: "vbmap",
+			desc: "VbConnectionMap, vbuckets and vbuuids at varint boundaries",
+			payload: &c.VbConnectionMap{
+				Bucket:   "default",
+				Vbuckets: []uint16{0, 1, 2, 1023},
+				Vbuuids:  []uint64{0, 127, 128, math.MaxUint64},
+			},
+		},
+		{
+			name: "frame",
+			desc: "ConnectionFrame for two buckets",
+			payload: &c.ConnectionFrame{
+				Topic: "maintenance",
+				Vbmaps: []*c.VbConnectionMap{
+					{Bucket: "default", Vbuckets: []uint16{1, 2}, Vbuuids: []uint64{10, 20}},
+					{Bucket: "beer-sample", Vbuckets: []uint16{5}, Vbuuids: []uint64{50}},
+				},
+			},
+		},
+		{
+			name:    "upsert",
+			desc:    "Upsert for two indexes, one without old key",
+			payload: confVbKeyVersions("default", 5, 1234, upsert),
+		},
+		{
+			name:    "upsert_value",
+			desc:    "Upsert with projected values for one of two indexes",
+			payload: confVbKeyVersions("default", 5, 1234, upsertValue),
+		},
+		{
+			name:    "deletion",
+			desc:    "Deletion, UpsertDeletion and Expiration",
+			payload: confVbKeyVersions("default", 6, 5678, deletion, expiration),
+		},
+		{
+			name:    "control",
+			desc:    "StreamBegin, Snapshot, Sync, DropData and StreamEnd",
+			payload: confVbKeyVersions("default", 7, 9012, begin, snapshot, sync, dropData, end),
+		},
+		{
+			name: "multi_vbucket",
+			desc: "mutations for vbuckets of two buckets",
+			payload: append(
+				confVbKeyVersions("default", 1, 100, vbs1),
+				confVbKeyVersions("beer-sample", 1, 200, vbs2)...),
+		},
+		{
+			name:    "large_key",
+			desc:    "key longer than 127 bytes, maximum vbucket, vbuuid and uuid",
+			payload: confVbKeyVersions("default", 1023, math.MaxUint64, largeKey),
+		},
+		{
+			name:    "empty",
+			desc:    "no vbuckets, decodes to a missing payload",
+			payload: []*c.VbKeyVersions{},
+			err:     ErrorMissingPayload,
+		},
+		{
+			name: "future_version",
+			desc: "payload of a newer protocol version",
+			err:  ErrorTransportVersion,
+		},
+	}
+}
+
+func TestConformanceEncode(t *testing.T) {
+	c.LogIgnore()
+
+	for _, tcase := range conformanceCases() {
+		if tcase.payload == nil {
+			continue
+		}
+		frame, err := confSend(confPacket(confMaxPayload), tcase.payload)
+		if err != nil {
+			t.Fatalf("%v: %v", tcase.name, err)
+		}
+		if *updateGolden {
+			writeGolden(t, tcase, frame)
+			continue
+		}
+		if golden := readGolden(t, tcase.name); !bytes.Equal(frame, golden) {
+			t.Errorf("%v: encoded frame\n%x\ndoes not match golden frame\n%x",
+				tcase.name, frame, golden)
+		}
+	}
+}
+
+func TestConformanceDecode(t *testing.T) {
+	c.LogIgnore()
+
+	for _, tcase := range conformanceCases() {
+		golden := readGolden(t, tcase.name)
+		pkt := confPacket(confMaxPayload)
+		payload, err := confReceive(pkt, golden)
+		if err != tcase.err {
+			t.Errorf("%v: expected error %v, got %v", tcase.name, tcase.err, err)
+		} else if err == nil && !confEqual(tcase.payload, payload) {
+			t.Errorf("%v: decoded payload does not match", tcase.name)
+		} else if flags := pkt.Flags(); flags != confFlags() {
+			t.Errorf("%v: unexpected flags %x", tcase.name, flags)
+		}
+	}
+}
+
+// Golden frames sent with gzip compression shall have the same payload
+// once decompressed, payloads upto the threshold are sent as is.
+func TestConformanceCompression(t *testing.T) {
+	c.LogIgnore()
+
+	for _, tcase := range conformanceCases() {
+		if tcase.payload == nil || tcase.err != nil {
+			continue
+		}
+		golden := readGolden(t, tcase.name)
+		size := len(golden) - 6
+
+		pkt := confPacket(confMaxPayload)
+		pkt.SetFlags(confFlags().SetGzip())
+		pkt.SetCompressionThreshold(size - 1)
+		frame, err := confSend(pkt, tcase.payload)
+		if err != nil {
+			t.Fatalf("%v: %v", tcase.name, err)
+		}
+		flags := transport.TransportFlag(binary.BigEndian.Uint16(frame[4:6]))
+		if flags != confFlags().SetGzip() {
+			t.Errorf("%v: unexpected flags %x", tcase.name, flags)
+		} else if l := binary.BigEndian.Uint32(frame[:4]); int(l) != len(frame)-6 {
+			t.Errorf("%v: packet length %v for %v bytes", tcase.name, l, len(frame)-6)
+		} else if data := gunzip(t, frame[6:]); !bytes.Equal(data, golden[6:]) {
+			t.Errorf("%v: decompressed payload does not match golden frame", tcase.name)
+		}
+		if payload, err := confReceive(pkt, frame); err != nil {
+			t.Errorf("%v: %v", tcase.name, err)
+		} else if !confEqual(tcase.payload, payload) {
+			t.Errorf("%v: decompressed payload does not match", tcase.name)
+		}
+
+		pkt.SetCompressionThreshold(size)
+		if frame, err := confSend(pkt, tcase.payload); err != nil {
+			t.Fatalf("%v: %v", tcase.name, err)
+		} else if !bytes.Equal(frame, golden) {
+			t.Errorf("%v: payload upto threshold is not sent as is", tcase.name)
+		}
+	}
+}
+
+// Frames are sent and received upto the maximum packet size, including
+// packet header, and overflow beyond it.
+func TestConformanceFrameSize(t *testing.T) {
+	c.LogIgnore()
+
+	for _, tcase := range conformanceCases() {
+		if tcase.payload == nil || tcase.err != nil {
+			continue
+		}
+		golden := readGolden(t, tcase.name)
+
+		pkt := confPacket(len(golden))
+		if frame, err := confSend(pkt, tcase.payload); err != nil {
+			t.Errorf("%v: %v", tcase.name, err)
+		} else if !bytes.Equal(frame, golden) {
+			t.Errorf("%v: encoded frame does not match", tcase.name)
+		}
+		if _, err := confReceive(pkt, golden); err != nil {
+			t.Errorf("%v: %v", tcase.name, err)
+		}
+
+		pkt = confPacket(len(golden) - 1)
+		if _, err := confSend(pkt, tcase.payload); err != transport.ErrorPacketOverflow {
+			t.Errorf("%v: expected %v, got %v",
+				tcase.name, transport.ErrorPacketOverflow, err)
+		}
+		// receiver checks the payload length against its buffer.
+		pkt = confPacket(len(golden) - 7)
+		if _, err := confReceive(pkt, golden); err != transport.ErrorPacketOverflow {
+			t.Errorf("%v: expected %v, got %v",
+				tcase.name, transport.ErrorPacketOverflow, err)
+		}
+	}
+}
+
+func confFlags() transport.TransportFlag {
+	return transport.TransportFlag(0).SetProtobuf()
+}
+
+func confPacket(maxPayload int) *transport.TransportPacket {
+	return newProtobufPacket(maxPayload, confFlags())
+}
+
+func confSend(pkt *transport.TransportPacket, payload interface{}) ([]byte, error) {
+	tc := newTestConnection()
+	if err := pkt.Send(tc, payload); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), tc.buf[:tc.woff]...), nil
+}
+
+func confReceive(pkt *transport.TransportPacket, frame []byte) (interface{}, error) {
+	tc := newTestConnection()
+	tc.Write(frame)
+	return pkt.Receive(tc)
+}
+
+func confVbKeyVersions(
+	bucket string, vbno uint16, vbuuid uint64,
+	kvs ...*c.KeyVersions) []*c.VbKeyVersions {
+
+	vb := c.NewVbKeyVersions(bucket, vbno, vbuuid, len(kvs))
+	for _, kv := range kvs {
+		vb.AddKeyVersions(kv)
+	}
+	return []*c.VbKeyVersions{vb}
+}
+
+// confEqual compares reference payload sent by endpoint with the payload
+// decoded by server.
+func confEqual(ref, payload interface{}) bool {
+	switch val := ref.(type) {
+	case *c.VbConnectionMap:
+		vbmap, ok := payload.(*protobuf.VbConnectionMap)
+		return ok && val.Equal(protobuf2Vbmap(vbmap))
+
+	case *c.ConnectionFrame:
+		pframe, ok := payload.(*protobuf.ConnectionFrame)
+		if !ok {
+			return false
+		}
+		frame := protobuf2Frame(pframe)
+		if frame.Topic != val.Topic || len(frame.Vbmaps) != len(val.Vbmaps) {
+			return false
+		}
+		for i, vbmap := range val.Vbmaps {
+			if !vbmap.Equal(frame.Vbmaps[i]) {
+				return false
+			}
+		}
+		return true
+
+	case []*c.VbKeyVersions:
+		pvbs, ok := payload.([]*protobuf.VbKeyVersions)
+		if !ok {
+			return false
+		}
+		vbs := protobuf2VbKeyVersions(pvbs)
+		if len(vbs) != len(val) {
+			return false
+		}
+		for i, vb := range val {
+			if vb.Bucket != vbs[i].Bucket || !vb.Equal(vbs[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func goldenFile(name string) string {
+	return filepath.Join("testdata", name+".hex")
+}
+
+// readGolden frame from testdata, lines starting with `#` are comments.
+func readGolden(t *testing.T, name string) []byte {
+	text, err := ioutil.ReadFile(goldenFile(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	for _, line := range strings.Split(string(text), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			s += strings.TrimSpace(line)
+		}
+	}
+	frame, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("%v: %v", goldenFile(name), err)
+	}
+	return frame
+}
+
+func writeGolden(t *testing.T, tcase conformanceCase, frame []byte) {
+	s := hex.EncodeToString(frame)
+	text := fmt.Sprintf("# %v\n", tcase.desc)
+	for len(s) > 64 {
+		text, s = text+s[:64]+"\n", s[64:]
+	}
+	text += s + "\n"
+	if err := ioutil.WriteFile(goldenFile(tcase.name), []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -10,8 +10,9 @@
 //                    |       ^
 //        Send() -----*       | endpoint routine buffers messages,
 //                    |       | batches them based on timeout and
-//       Close() -----*       | message-count and periodically flushes
-//                            | them out via dataport-client.
+//  SendVbmaps() -----*       | message-count and periodically flushes
+//                    |       | them out via dataport-client.
+//       Close() -----*       |
 //                            |
 //                            V
 //                          buffers
@@ -19,6 +20,7 @@
 package dataport
 
 import "fmt"
+import "context"
 import "net"
 import "time"
 import "runtime/debug"
@@ -31,6 +33,7 @@ import "github.com/couchbase/indexing/secondary/transport"
 // specific node.
 type RouterEndpoint struct {
 	topic     string
+	token     string // registration token for topic, immutable
 	timestamp int64  // immutable
 	raddr     string // immutable
 	// config params
@@ -47,12 +50,17 @@ type RouterEndpoint struct {
 	// downstream
 	pkt  *transport.TransportPacket
 	conn net.Conn
+	// statistics
+	messageCount c.Counter    // cummulative mutations
+	flushCount   c.Counter    // buffer flushes
+	frameCount   c.Counter    // vbmap frames
+	flushLatency *c.Histogram // time taken to flush buffers downstream
 }
 
 // NewRouterEndpoint instantiate a new RouterEndpoint
 // routine and return its reference.
 func NewRouterEndpoint(
-	cluster, topic, raddr string, maxvbs int,
+	cluster, topic, raddr, token string, maxvbs int,
 	config c.Config) (*RouterEndpoint, error) {
 
 	conn, err := net.Dial("tcp", raddr)
@@ -62,6 +70,7 @@ func NewRouterEndpoint(
 
 	endpoint := &RouterEndpoint{
 		topic:      topic,
+		token:      token,
 		raddr:      raddr,
 		finch:      make(chan bool),
 		timestamp:  time.Now().UnixNano(),
@@ -70,19 +79,19 @@ func NewRouterEndpoint(
 		bufferSize: config["bufferSize"].Int(),
 		bufferTm:   time.Duration(config["bufferTimeout"].Int()),
 		harakiriTm: time.Duration(config["harakiriTimeout"].Int()),
+
+		flushLatency: c.NewLatencyHistogram(),
 	}
 	endpoint.ch = make(chan []interface{}, endpoint.keyChSize)
 	endpoint.conn = conn
 	// TODO: add configuration params for transport flags.
 	flags := transport.TransportFlag(0).SetProtobuf()
 	maxPayload := config["maxPayload"].Int()
-	endpoint.pkt = transport.NewTransportPacket(maxPayload, flags)
-	endpoint.pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
-	endpoint.pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	endpoint.pkt = newProtobufPacket(maxPayload, flags)
 
 	endpoint.logPrefix = fmt.Sprintf(
 		"ENDP[<-(%v,%4x)<-%v #%v]",
-		endpoint.raddr, uint16(endpoint.timestamp), cluster, topic)
+		endpoint.raddr, uint16(endpoint.timestamp), c.RedactURL(cluster), topic)
 
 	go endpoint.run(endpoint.ch)
 	c.Infof("%v started ...\n", endpoint.logPrefix)
@@ -93,6 +102,7 @@ func NewRouterEndpoint(
 const (
 	endpCmdPing byte = iota + 1
 	endpCmdSend
+	endpCmdSendVbmaps
 	endpCmdSetConfig
 	endpCmdGetStatistics
 	endpCmdClose
@@ -127,11 +137,27 @@ func (endpoint *RouterEndpoint) Send(data interface{}) error {
 	return c.FailsafeOpNoblock(endpoint.ch, cmd, endpoint.finch)
 }
 
-// GetStatistics for this endpoint, synchronous call.
-func (endpoint *RouterEndpoint) GetStatistics() map[string]interface{} {
+// SendVbmaps frames the set of vbuckets, for each bucket, that will
+// be carried by this endpoint and sends it to the other end, after
+// flushing mutations buffered so far. Synchronous call.
+func (endpoint *RouterEndpoint) SendVbmaps(vbmaps []*c.VbConnectionMap) error {
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{endpCmdSendVbmaps, vbmaps, respch}
+	resp, err := c.FailsafeOp(endpoint.ch, respch, cmd, endpoint.finch)
+	return c.OpError(err, resp, 0)
+}
+
+// GetStatistics for this endpoint, nil if endpoint is closed or `ctx`
+// expires before endpoint responds, synchronous call.
+func (endpoint *RouterEndpoint) GetStatistics(
+	ctx context.Context) map[string]interface{} {
+
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{endpCmdGetStatistics, respch}
-	resp, _ := c.FailsafeOp(endpoint.ch, respch, cmd, endpoint.finch)
+	resp, err := c.FailsafeOpContext(ctx, endpoint.ch, respch, cmd, endpoint.finch)
+	if err != nil {
+		return nil
+	}
 	return resp[0].(map[string]interface{})
 }
 
@@ -163,16 +189,16 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 	harakiri := time.After(endpoint.harakiriTm * time.Millisecond)
 	buffers := newEndpointBuffers(raddr)
 
-	messageCount := int64(0)
-	flushCount := int64(0)
 	mutationCount := int64(0)
 
 	flushBuffers := func() (err error) {
 		c.Tracef("%v sent %v mutations to %q\n",
 			endpoint.logPrefix, mutationCount, raddr)
 		if mutationCount > 0 {
-			flushCount++
+			endpoint.flushCount.Add(1)
+			start := time.Now()
 			err = buffers.flushBuffers(endpoint.conn, endpoint.pkt)
+			endpoint.flushLatency.Add(int64(time.Since(start)))
 			if err != nil {
 				c.Errorf("%v flushBuffers() %v\n", endpoint.logPrefix, err)
 			}
@@ -201,7 +227,7 @@ loop:
 				c.Tracef("%v added %v keyversions <%v:%v:%v> to %q\n",
 					endpoint.logPrefix, kv.Length(), data.Vbno, kv.Seqno,
 					kv.Commands, buffers.raddr)
-				messageCount++ // count cummulative mutations
+				endpoint.messageCount.Add(1)
 				// reload harakiri
 				harakiri = time.After(endpoint.harakiriTm * time.Millisecond)
 				mutationCount++ // count queued up mutations.
@@ -211,6 +237,28 @@ loop:
 					}
 				}
 
+			case endpCmdSendVbmaps:
+				vbmaps := msg[1].([]*c.VbConnectionMap)
+				respch := msg[2].(chan []interface{})
+				// mutations buffered so far belong to previous frame.
+				err := flushBuffers()
+				if err == nil {
+					frame := &c.ConnectionFrame{
+						Topic: endpoint.topic, Vbmaps: vbmaps,
+						Token: endpoint.token,
+					}
+					err = endpoint.pkt.Send(endpoint.conn, frame)
+				}
+				if err != nil {
+					c.Errorf("%v SendVbmaps() %v\n", endpoint.logPrefix, err)
+					respch <- []interface{}{err}
+					break loop
+				}
+				endpoint.frameCount.Add(1)
+				c.Infof("%v framed %v buckets to %q\n",
+					endpoint.logPrefix, len(vbmaps), raddr)
+				respch <- []interface{}{nil}
+
 			case endpCmdSetConfig:
 				config := msg[1].(c.Config)
 				endpoint.block = config["remoteBlock"].Bool()
@@ -227,8 +275,6 @@ loop:
 			case endpCmdGetStatistics:
 				respch := msg[1].(chan []interface{})
 				stats := endpoint.newStats()
-				stats.Set("messageCount", float64(messageCount))
-				stats.Set("flushCount", float64(flushCount))
 				respch <- []interface{}{map[string]interface{}(stats)}
 
 			case endpCmdClose:
@@ -253,8 +299,10 @@ loop:
 
 func (endpoint *RouterEndpoint) newStats() c.Statistics {
 	m := map[string]interface{}{
-		"messageCount": float64(0),
-		"flushCount":   float64(0),
+		"messageCount": &endpoint.messageCount,
+		"flushCount":   &endpoint.flushCount,
+		"frameCount":   &endpoint.frameCount,
+		"flushLatency": endpoint.flushLatency,
 	}
 	stats, _ := c.NewStatistics(m)
 	return stats
@@ -6,6 +6,7 @@ import "errors"
 
 import c "github.com/couchbase/indexing/secondary/common"
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
+import "github.com/couchbase/indexing/secondary/transport"
 import "github.com/couchbaselabs/goprotobuf/proto"
 
 // ErrorTransportVersion
@@ -14,6 +15,17 @@ var ErrorTransportVersion = errors.New("dataport.transportVersion")
 // ErrorMissingPayload
 var ErrorMissingPayload = errors.New("dataport.missingPlayload")
 
+// newProtobufPacket return a transport packet that encodes and decodes
+// dataport payloads using protobuf, both ends of a dataport connection
+// shall frame their packets with it. Refer docs/dataport.rst for the
+// wire format.
+func newProtobufPacket(maxPayload int, flags transport.TransportFlag) *transport.TransportPacket {
+	pkt := transport.NewTransportPacket(maxPayload, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
+	pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	return pkt
+}
+
 // protobufEncode encode payload message into protobuf array of bytes. Return
 // `data` can be transported to the other end and decoded back to Payload
 // message.
@@ -47,11 +59,18 @@ func protobufEncode(payload interface{}) (data []byte, err error) {
 				pkv.Commands = make([]uint32, 0, l)
 				pkv.Keys = make([][]byte, 0, l)
 				pkv.Oldkeys = make([][]byte, 0, l)
+				hasValues := kv.HasValues()
+				if hasValues {
+					pkv.Values = make([][]byte, 0, l)
+				}
 				for i, uuid := range kv.Uuids { // for each key-version
 					pkv.Uuids = append(pkv.Uuids, uuid)
 					pkv.Commands = append(pkv.Commands, uint32(kv.Commands[i]))
 					pkv.Keys = append(pkv.Keys, kv.Keys[i])
 					pkv.Oldkeys = append(pkv.Oldkeys, kv.Oldkeys[i])
+					if hasValues {
+						pkv.Values = append(pkv.Values, kv.Value(i))
+					}
 				}
 				pvb.Kvs = append(pvb.Kvs, pkv)
 			}
@@ -64,6 +83,23 @@ func protobufEncode(payload interface{}) (data []byte, err error) {
 			Vbuuids:  val.Vbuuids,
 			Vbuckets: c.Vbno16to32(val.Vbuckets),
 		}
+
+	case *c.ConnectionFrame:
+		pl.Frame = &protobuf.ConnectionFrame{
+			Topic:  proto.String(val.Topic),
+			Vbmaps: make([]*protobuf.VbConnectionMap, 0, len(val.Vbmaps)),
+		}
+		if val.Token != "" {
+			pl.Frame.Token = proto.String(val.Token)
+		}
+		for _, vbmap := range val.Vbmaps {
+			pvbmap := &protobuf.VbConnectionMap{
+				Bucket:   proto.String(vbmap.Bucket),
+				Vbuuids:  vbmap.Vbuuids,
+				Vbuckets: c.Vbno16to32(vbmap.Vbuckets),
+			}
+			pl.Frame.Vbmaps = append(pl.Frame.Vbmaps, pvbmap)
+		}
 	}
 
 	if err == nil {
@@ -73,8 +109,8 @@ func protobufEncode(payload interface{}) (data []byte, err error) {
 }
 
 // protobufDecode complements protobufEncode() API. `data` returned by encode
-// is converted back to *protobuf.VbConnectionMap, or []*protobuf.VbKeyVersions
-// and returns back the value inside the payload
+// is converted back to *protobuf.VbConnectionMap, *protobuf.ConnectionFrame
+// or []*protobuf.VbKeyVersions and returns back the value inside the payload
 func protobufDecode(data []byte) (value interface{}, err error) {
 	pl := &protobuf.Payload{}
 	if err = proto.Unmarshal(data, pl); err != nil {
@@ -103,6 +139,16 @@ func protobuf2Vbmap(vbmap *protobuf.VbConnectionMap) *c.VbConnectionMap {
 	}
 }
 
+func protobuf2Frame(frame *protobuf.ConnectionFrame) *c.ConnectionFrame {
+	vbmaps := make([]*c.VbConnectionMap, 0, len(frame.GetVbmaps()))
+	for _, vbmap := range frame.GetVbmaps() {
+		vbmaps = append(vbmaps, protobuf2Vbmap(vbmap))
+	}
+	return &c.ConnectionFrame{
+		Topic: frame.GetTopic(), Vbmaps: vbmaps, Token: frame.GetToken(),
+	}
+}
+
 func protobuf2KeyVersions(keys []*protobuf.KeyVersions) []*c.KeyVersions {
 	kvs := make([]*c.KeyVersions, 0, len(keys))
 	size := 4 // To avoid reallocs
@@ -118,11 +164,18 @@ func protobuf2KeyVersions(keys []*protobuf.KeyVersions) []*c.KeyVersions {
 		commands := key.GetCommands()
 		newkeys := key.GetKeys()
 		oldkeys := key.GetOldkeys()
+		values := key.GetValues()
+		if len(values) > 0 {
+			kv.Values = make([][]byte, 0, size)
+		}
 		for i, uuid := range key.GetUuids() {
 			kv.Uuids = append(kv.Uuids, uuid)
 			kv.Commands = append(kv.Commands, byte(commands[i]))
 			kv.Keys = append(kv.Keys, newkeys[i])
 			kv.Oldkeys = append(kv.Oldkeys, oldkeys[i])
+			if len(values) > 0 {
+				kv.Values = append(kv.Values, values[i])
+			}
 		}
 		kvs = append(kvs, kv)
 	}
@@ -35,6 +35,45 @@ func TestVbConnectionMap(t *testing.T) {
 	}
 }
 
+func TestConnectionFrame(t *testing.T) {
+	common.LogIgnore()
+
+	frame := &common.ConnectionFrame{
+		Topic: "maintenance",
+		Vbmaps: []*common.VbConnectionMap{
+			&common.VbConnectionMap{
+				Bucket:   "default",
+				Vbuckets: []uint16{1, 2, 3, 4},
+			},
+			&common.VbConnectionMap{
+				Bucket:   "beer-sample",
+				Vbuckets: []uint16{5, 6},
+			},
+		},
+	}
+	data, err := protobufEncode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := protobufDecode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pframe, ok := payload.(*protobuf.ConnectionFrame)
+	if ok == false {
+		t.Fatal("expected reference ConnectionFrame object")
+	}
+	frame1 := protobuf2Frame(pframe)
+	if frame1.Topic != frame.Topic || len(frame1.Vbmaps) != len(frame.Vbmaps) {
+		t.Fatal("failed ConnectionFrame")
+	}
+	for i, vbmap := range frame.Vbmaps {
+		if vbmap.Equal(frame1.Vbmaps[i]) == false {
+			t.Fatal("failed ConnectionFrame")
+		}
+	}
+}
+
 func TestAddUpsert(t *testing.T) {
 	common.LogIgnore()
 
@@ -45,6 +84,14 @@ func TestAddUpsert(t *testing.T) {
 	testKeyVersions(t, vb)
 }
 
+func TestAddUpsertWithValue(t *testing.T) {
+	kv := kvUpsertsWithValue()
+	vbno, vbuuid, nMuts := uint16(10), uint64(1000), 10
+	vb := common.NewVbKeyVersions("default", vbno, vbuuid, nMuts)
+	addKeyVersions(vb, []*common.KeyVersions{kv}, 1, nMuts)
+	testKeyVersions(t, vb)
+}
+
 func TestAddUpsertDeletion(t *testing.T) {
 	kv := kvUpsertDeletions()
 	vbno, vbuuid, nMuts := uint16(10), uint64(1000), 10
@@ -61,6 +108,14 @@ func TestAddDeletion(t *testing.T) {
 	testKeyVersions(t, vb)
 }
 
+func TestAddExpiration(t *testing.T) {
+	kv := kvExpirations()
+	vbno, vbuuid, nMuts := uint16(10), uint64(1000), 10
+	vb := common.NewVbKeyVersions("default", vbno, vbuuid, nMuts)
+	addKeyVersions(vb, []*common.KeyVersions{kv}, 1, nMuts)
+	testKeyVersions(t, vb)
+}
+
 func TestAddSync(t *testing.T) {
 	seqno, docid, maxCount := uint64(10), []byte(nil), 1
 	kv := common.NewKeyVersions(seqno, docid, maxCount)
@@ -333,6 +388,15 @@ func kvUpserts() *common.KeyVersions {
 	return kv
 }
 
+func kvUpsertsWithValue() *common.KeyVersions {
+	seqno, docid, maxCount := uint64(10), []byte("document-name"), 10
+	kv := common.NewKeyVersions(seqno, docid, maxCount)
+	kv.AddUpsertWithValue(1, []byte("bangalore"), []byte("varanasi"), []byte(`["karnataka"]`))
+	kv.AddUpsert(2, []byte("delhi"), []byte("pune"))
+	kv.AddUpsertWithValue(3, []byte("jaipur"), []byte("mahe"), []byte(`["rajasthan"]`))
+	return kv
+}
+
 func kvUpsertDeletions() *common.KeyVersions {
 	seqno, docid, maxCount := uint64(10), []byte("document-name"), 10
 	kv := common.NewKeyVersions(seqno, docid, maxCount)
@@ -351,6 +415,14 @@ func kvDeletions() *common.KeyVersions {
 	return kv
 }
 
+func kvExpirations() *common.KeyVersions {
+	seqno, docid, maxCount := uint64(10), []byte("document-name"), 10
+	kv := common.NewKeyVersions(seqno, docid, maxCount)
+	kv.AddExpiration(1, []byte("varanasi"))
+	kv.AddExpiration(2, []byte("pune"))
+	return kv
+}
+
 func addKeyVersions(vb *common.VbKeyVersions, kvs []*common.KeyVersions, seqno uint64, nMuts int) uint64 {
 	ln := len(kvs)
 	for i := 0; i < nMuts; i++ {
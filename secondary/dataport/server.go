@@ -21,8 +21,13 @@
 //                 V         |    |                     |
 //  Close() -------*------->gen-server()-----*---- doReceive()----*
 //          serverCmdClose       ^           |                    |
+//  SetToken() ----*             |           |                    |
+//          serverCmdSetToken    |           |                    |
+//  RequireToken() *             |           |                    |
+//      serverCmdRequireToken    |           |                    |
 //                               |           *---- doReceive()----*
 //                serverCmdVbmap |           |                    |
+//                serverCmdFrame |           |                    |
 //            serverCmdVbcontrol |           *---- doReceive()----*
 //                serverCmdError |                                |
 //                               *--------------------------------*
@@ -39,7 +44,21 @@
 //    be intimated to application for catchup connection, using
 //    ConnectionError message.
 //
-// 3. StreamEnd, ConnectionError can be seen by serve due to,
+// 3. router can describe, and later renegotiate, the topic and vbuckets
+//    carried by a connection with a ConnectionFrame. A frame with a
+//    different topic or with vbuckets beyond the maximum, and, once
+//    framed, a StreamBegin for vbucket outside the frame are treated as
+//    routing error and all connections with that router will be closed.
+//
+// 4. once application sets a registration token, issued for the topic,
+//    a connection framed with a different token is treated as a stale
+//    router, from an older topic, and all connections with that router
+//    will be closed. Connections without token are accepted, so that
+//    older routers can stream, until application learns that remote
+//    host supports tokens and requires it, after which such connections
+//    must frame themselves with the token before sending mutations.
+//
+// 5. StreamEnd, ConnectionError can be seen by serve due to,
 //    a. rebalance
 //    b. failover
 //    c. projector crash
@@ -83,6 +102,12 @@ var ErrorDuplicateClient = errors.New("dataport.duplicateClient")
 // ErrorWorkerKilled
 var ErrorWorkerKilled = errors.New("dataport.workerKilled")
 
+// ErrorFrameRouting
+var ErrorFrameRouting = errors.New("dataport.frameRouting")
+
+// ErrorFrameToken
+var ErrorFrameToken = errors.New("dataport.frameToken")
+
 type activeVb struct {
 	raddr  string // remote connection carrying this vbucket.
 	bucket string
@@ -117,6 +142,19 @@ type netConn struct {
 	conn   net.Conn
 	worker chan interface{}
 	active bool
+	// bucket -> vbuckets, from last ConnectionFrame, nil if not framed.
+	vbmaps map[string]map[uint16]bool
+	// framed with server's registration token, or token is not required
+	// from remote host.
+	registered bool
+}
+
+// ConnectionInfo is posted to application whenever a remote connection
+// describes, or renegotiates, the topic and vbuckets it carries.
+type ConnectionInfo struct {
+	Raddr    string
+	Topic    string
+	Vbuckets map[string][]uint16 // bucket -> []vbuckets
 }
 
 // Server handles an active dataport server of mutation for all vbuckets.
@@ -126,15 +164,19 @@ type Server struct {
 	appch chan<- interface{} // backchannel to application
 
 	// gen-server management
-	conns map[string]*netConn // resolve <host:port> to conn. obj
-	reqch chan []interface{}
-	finch chan bool
+	topic      string              // topic framed by remote connections
+	token      string              // registration token issued for topic
+	tokenHosts map[string]bool     // remote hosts required to frame token
+	conns      map[string]*netConn // resolve <host:port> to conn. obj
+	reqch      chan []interface{}
+	finch      chan bool
 
 	// config parameters
 	maxVbuckets  int
 	genChSize    int           // channel size for genServer routine
 	maxPayload   int           // maximum payload length from router
 	readDeadline time.Duration // timeout, in millisecond, reading from socket
+	readBuffer   int           // socket receive buffer, 0 for OS default
 	logPrefix    string
 }
 
@@ -151,14 +193,16 @@ func NewServer(
 		laddr: laddr,
 		appch: appch,
 		// Managing vbuckets and connections for all routers
-		reqch: make(chan []interface{}, genChSize),
-		finch: make(chan bool),
-		conns: make(map[string]*netConn),
+		reqch:      make(chan []interface{}, genChSize),
+		finch:      make(chan bool),
+		tokenHosts: make(map[string]bool),
+		conns:      make(map[string]*netConn),
 		// config parameters
 		maxVbuckets:  maxvbs,
 		genChSize:    genChSize,
 		maxPayload:   config["maxPayload"].Int(),
 		readDeadline: time.Duration(config["tcpReadDeadline"].Int()),
+		readBuffer:   config["tcpReadBuffer"].Int(),
 	}
 	s.logPrefix = fmt.Sprintf("DATP[->dataport %q]", laddr)
 	if s.lis, err = net.Listen("tcp", laddr); err != nil {
@@ -203,12 +247,50 @@ func (s *Server) Close() (err error) {
 	return c.OpError(err, resp, 0)
 }
 
+// SetToken sets the registration token issued for the topic, remote
+// connections framed with a different token are rejected. Connections
+// opened before the token was set are closed, and remote hosts shall
+// be required to frame the new token all over again.
+// Synchronous call.
+func (s *Server) SetToken(token string) (err error) {
+	respch := make(chan []interface{}, 1)
+	msg := serverMessage{cmd: serverCmdSetToken, args: []interface{}{token}}
+	cmd := []interface{}{msg, respch}
+	resp, err := c.FailsafeOp(s.reqch, respch, cmd, s.finch)
+	return c.OpError(err, resp, 0)
+}
+
+// RequireToken from remote `hosts`, once they have acknowledged the
+// registration token, connections accepted hereafter from these hosts
+// must frame themselves with the token before streaming mutations.
+// Synchronous call.
+func (s *Server) RequireToken(hosts ...string) (err error) {
+	respch := make(chan []interface{}, 1)
+	msg := serverMessage{cmd: serverCmdRequireToken, args: []interface{}{hosts}}
+	cmd := []interface{}{msg, respch}
+	resp, err := c.FailsafeOp(s.reqch, respch, cmd, s.finch)
+	return c.OpError(err, resp, 0)
+}
+
+// RejectRouting closes all connections with the router at `raddr`, once
+// application finds the vbuckets it framed invalid, ConnectionError is
+// posted for vbuckets streaming from it. Application receiving from the
+// server shall not block on it, asynchronous call that fails if the
+// server is busy.
+func (s *Server) RejectRouting(raddr string) (err error) {
+	msg := serverMessage{cmd: serverCmdError, raddr: raddr, err: ErrorFrameRouting}
+	return c.FailsafeOpNoblock(s.reqch, []interface{}{msg}, s.finch)
+}
+
 // gen-server commands
 const (
 	serverCmdNewConnection byte = iota + 1
 	serverCmdVbmap
+	serverCmdFrame
 	serverCmdVbcontrol
 	serverCmdError
+	serverCmdSetToken
+	serverCmdRequireToken
 	serverCmdClose
 )
 
@@ -238,8 +320,12 @@ loop:
 					c.Errorf("%v %q already active\n", s.logPrefix, raddr)
 					conn.Close()
 				} else { // connection accepted
+					s.tuneConnection(conn, raddr)
 					worker := make(chan interface{}, s.maxVbuckets)
-					s.conns[raddr] = &netConn{conn: conn, worker: worker}
+					s.conns[raddr] = &netConn{
+						conn: conn, worker: worker,
+						registered: !s.tokenRequired(raddr),
+					}
 					n := len(s.conns)
 					c.Infof("%v new connection %q +%d\n", s.logPrefix, raddr, n)
 					s.startWorker(raddr)
@@ -254,6 +340,16 @@ loop:
 				}
 				s.startWorker(msg.raddr)
 
+			case serverCmdFrame:
+				frame := msg.args[0].(*protobuf.ConnectionFrame)
+				if err := s.handleFrame(msg.raddr, frame); err != nil {
+					hostUuids, appmsg =
+						s.jumboErrorHandler(msg.raddr, hostUuids, err)
+				} else {
+					appmsg = newConnectionInfo(msg.raddr, frame)
+					s.startWorker(msg.raddr)
+				}
+
 			case serverCmdVbcontrol:
 				started := msg.args[0].(keeper)
 				finished := msg.args[1].(keeper)
@@ -265,6 +361,31 @@ loop:
 				}
 				s.startWorker(msg.raddr)
 
+			case serverCmdSetToken:
+				respch := cmd[1].(chan []interface{})
+				if token := msg.args[0].(string); token != s.token {
+					s.token, s.tokenHosts = token, make(map[string]bool)
+					for _, raddr := range s.connections() {
+						if _, ok := s.conns[raddr]; !ok {
+							continue // closed along with other remote conns.
+						}
+						hostUuids, appmsg =
+							s.jumboErrorHandler(raddr, hostUuids, ErrorFrameToken)
+						if appmsg != nil {
+							s.appch <- appmsg
+						}
+					}
+					appmsg = nil
+				}
+				respch <- []interface{}{nil}
+
+			case serverCmdRequireToken:
+				respch := cmd[1].(chan []interface{})
+				for _, host := range msg.args[0].([]string) {
+					s.tokenHosts[host] = true
+				}
+				respch <- []interface{}{nil}
+
 			case serverCmdClose:
 				// This execution path never panics !!
 				respch := cmd[1].(chan []interface{})
@@ -299,6 +420,83 @@ func (s *Server) handleNewConnection(conn net.Conn, raddr string) error {
 	return nil
 }
 
+// tune socket options for a new connection.
+func (s *Server) tuneConnection(conn net.Conn, raddr string) {
+	if s.readBuffer <= 0 {
+		return
+	}
+	if tcpconn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpconn.SetReadBuffer(s.readBuffer); err != nil {
+			c.Errorf("%v %q SetReadBuffer(%v): %v\n",
+				s.logPrefix, raddr, s.readBuffer, err)
+		}
+	}
+}
+
+// whether connection from `raddr` must frame the registration token.
+func (s *Server) tokenRequired(raddr string) bool {
+	host, _, err := net.SplitHostPort(raddr)
+	if err != nil {
+		host = raddr
+	}
+	return s.token != "" && s.tokenHosts[host]
+}
+
+// remote addresses of all active connections.
+func (s *Server) connections() []string {
+	raddrs := make([]string, 0, len(s.conns))
+	for raddr := range s.conns {
+		raddrs = append(raddrs, raddr)
+	}
+	return raddrs
+}
+
+// handle connection frame, vbuckets framed for the connection replace the
+// earlier set. All connections are expected to frame the same topic, with
+// the registration token if one is issued and remote host supports it.
+func (s *Server) handleFrame(
+	raddr string, frame *protobuf.ConnectionFrame) error {
+
+	nc, ok := s.conns[raddr]
+	if !ok { // jumboErrorHandler() will log and ignore this.
+		return ErrorWorkerKilled
+	}
+	topic := frame.GetTopic()
+	if s.topic == "" {
+		s.topic = topic
+	} else if s.topic != topic {
+		c.Errorf("%v remote %q framed topic %q, expected %q\n",
+			s.logPrefix, raddr, topic, s.topic)
+		return ErrorFrameRouting
+	}
+	if token := frame.GetToken(); token != "" && token != s.token {
+		c.Errorf("%v remote %q framed topic %q with stale token\n",
+			s.logPrefix, raddr, topic)
+		return ErrorFrameToken
+	} else if token == "" && s.tokenRequired(raddr) {
+		c.Errorf("%v remote %q framed topic %q without token\n",
+			s.logPrefix, raddr, topic)
+		return ErrorFrameToken
+	}
+	vbmaps := make(map[string]map[uint16]bool)
+	for _, vbmap := range frame.GetVbmaps() {
+		vbnos := make(map[uint16]bool)
+		for _, vbno := range vbmap.GetVbuckets() {
+			if int(vbno) >= s.maxVbuckets {
+				c.Errorf("%v remote %q framed vbucket {%v, %v} beyond %v\n",
+					s.logPrefix, raddr, vbmap.GetBucket(), vbno, s.maxVbuckets)
+				return ErrorFrameRouting
+			}
+			vbnos[uint16(vbno)] = true
+		}
+		vbmaps[vbmap.GetBucket()] = vbnos
+	}
+	nc.vbmaps, nc.registered = vbmaps, true
+	c.Infof("%v remote %q framed %v buckets for %q\n",
+		s.logPrefix, raddr, len(vbmaps), topic)
+	return nil
+}
+
 // shutdown this gen server and all its routines.
 func (s *Server) handleClose() {
 	defer func() {
@@ -350,6 +548,14 @@ func (s *Server) jumboErrorHandler(
 		c.Errorf("%v remote %q timeout: %v\n", s.logPrefix, raddr, err)
 		whatJumbo = "closeremote"
 
+	} else if err == ErrorFrameRouting {
+		c.Errorf("%v remote %q routing error\n", s.logPrefix, raddr)
+		whatJumbo = "closeremote"
+
+	} else if err == ErrorFrameToken {
+		c.Errorf("%v remote %q not registered\n", s.logPrefix, raddr)
+		whatJumbo = "closeremote"
+
 	} else if err != nil {
 		c.Errorf("%v remote %q unknown error: %v\n", s.logPrefix, raddr, err)
 		whatJumbo = "closeall"
@@ -452,9 +658,7 @@ func doReceive(
 
 	// TODO: make it configurable
 	flags := transport.TransportFlag(0).SetProtobuf()
-	pkt := transport.NewTransportPacket(maxPayload, flags)
-	pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
-	pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	pkt := newProtobufPacket(maxPayload, flags)
 
 	msg := serverMessage{raddr: conn.RemoteAddr().String()}
 
@@ -462,7 +666,16 @@ func doReceive(
 	started := make(keeper)  // id() -> activeVb
 	finished := make(keeper) // id() -> activeVb
 
-	beginsAndEnds := func(vbs []*protobuf.VbKeyVersions) {
+	// vbuckets outside connection's frame shall not begin on it.
+	framed := func(bucket string, vbno uint16) bool {
+		if nc.vbmaps == nil {
+			return true
+		}
+		vbnos, ok := nc.vbmaps[bucket]
+		return ok && vbnos[vbno]
+	}
+
+	beginsAndEnds := func(vbs []*protobuf.VbKeyVersions) error {
 		for _, vb := range vbs { // for each vbucket
 			bucket, vbno := vb.GetBucketname(), uint16(vb.GetVbucket())
 			avb := &activeVb{msg.raddr, bucket, vbno}
@@ -475,6 +688,11 @@ func doReceive(
 				}
 				switch byte(kv.GetCommands()[0]) {
 				case c.StreamBegin:
+					if !framed(bucket, vbno) {
+						c.Errorf("%v {%v, %v} not framed for %q\n",
+							prefix, bucket, vbno, msg.raddr)
+						return ErrorFrameRouting
+					}
 					started[id] = avb
 				case c.StreamEnd:
 					finished[id] = avb
@@ -482,6 +700,7 @@ func doReceive(
 			}
 			c.Tracef("%v {%v, %v}\n", prefix, bucket, vbno)
 		}
+		return nil
 	}
 
 loop:
@@ -495,6 +714,12 @@ loop:
 			c.Errorf("%v worker %q exit: %v\n", prefix, msg.raddr, err)
 			break loop
 
+		} else if _, ok := payload.(*protobuf.ConnectionFrame); !ok && !nc.registered {
+			msg.cmd, msg.err = serverCmdError, ErrorFrameToken
+			reqch <- []interface{}{msg}
+			c.Errorf("%v worker %q exit: %v\n", prefix, msg.raddr, msg.err)
+			break loop
+
 		} else if vbmap, ok := payload.(*protobuf.VbConnectionMap); ok {
 			msg.cmd, msg.args = serverCmdVbmap, []interface{}{vbmap}
 			reqch <- []interface{}{msg}
@@ -502,8 +727,20 @@ loop:
 			c.Tracef(format, prefix, msg.raddr)
 			break loop
 
+		} else if frame, ok := payload.(*protobuf.ConnectionFrame); ok {
+			msg.cmd, msg.args = serverCmdFrame, []interface{}{frame}
+			reqch <- []interface{}{msg}
+			format := "%v worker %q exit: `serverCmdFrame`\n"
+			c.Tracef(format, prefix, msg.raddr)
+			break loop
+
 		} else if vbs, ok := payload.([]*protobuf.VbKeyVersions); ok {
-			beginsAndEnds(vbs)
+			if err := beginsAndEnds(vbs); err != nil {
+				msg.cmd, msg.err = serverCmdError, err
+				reqch <- []interface{}{msg}
+				c.Errorf("%v worker %q exit: %v\n", prefix, msg.raddr, err)
+				break loop
+			}
 			select {
 			case appch <- vbs:
 				if len(started) > 0 || len(finished) > 0 {
@@ -550,6 +787,20 @@ func vbucketSchedule(vb *protobuf.VbKeyVersions) (s, e *protobuf.KeyVersions) {
 // ConnectionError to application
 type ConnectionError map[string][]uint16 // bucket -> []vbuckets
 
+func newConnectionInfo(
+	raddr string, frame *protobuf.ConnectionFrame) *ConnectionInfo {
+
+	info := &ConnectionInfo{
+		Raddr:    raddr,
+		Topic:    frame.GetTopic(),
+		Vbuckets: make(map[string][]uint16),
+	}
+	for _, vbmap := range frame.GetVbmaps() {
+		info.Vbuckets[vbmap.GetBucket()] = c.Vbno32to16(vbmap.GetVbuckets())
+	}
+	return info
+}
+
 // NewConnectionError makes a new connection-error map.
 func NewConnectionError() ConnectionError {
 	return make(ConnectionError)
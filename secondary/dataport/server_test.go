@@ -25,7 +25,7 @@ func TestTimeout(t *testing.T) {
 
 	// start endpoint
 	config := c.SystemConfig.SectionConfig("endpoint.dataport.", true /*trim*/)
-	endp, err := NewRouterEndpoint("clust", "topic", raddr, maxvbuckets, config)
+	endp, err := NewRouterEndpoint("clust", "topic", raddr, "", maxvbuckets, config)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -121,7 +121,7 @@ func TestLoopback(t *testing.T) {
 
 	// start endpoint
 	config = c.SystemConfig.SectionConfig("endpoint.dataport.", true /*trim*/)
-	endp, err := NewRouterEndpoint("clust", "topic", raddr, maxvbuckets, config)
+	endp, err := NewRouterEndpoint("clust", "topic", raddr, "", maxvbuckets, config)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -194,6 +194,195 @@ func TestLoopback(t *testing.T) {
 	daemon.Close()
 }
 
+func TestRegistrationToken(t *testing.T) {
+	c.LogIgnore()
+
+	raddr := "localhost:8888"
+	maxBuckets, maxvbuckets, mutChanSize := 1, 4, 100
+
+	// start server
+	appch := make(chan interface{}, mutChanSize)
+	prefix := "projector.dataport.indexer."
+	config := c.SystemConfig.SectionConfig(prefix, true /*trim*/)
+	daemon, err := NewServer(raddr, maxvbuckets, config, appch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.Close()
+	if err := daemon.SetToken("token"); err != nil {
+		t.Fatal(err)
+	}
+
+	vbmaps := makeVbmaps(maxvbuckets, maxBuckets)
+	streamBegin := func(endp *RouterEndpoint) {
+		vbmap := vbmaps[0]
+		kv := c.NewKeyVersions(uint64(0), []byte("Bourne"), 1)
+		kv.AddStreamBegin()
+		dkv := &c.DataportKeyVersions{
+			Bucket: vbmap.Bucket, Vbno: vbmap.Vbuckets[0],
+			Vbuuid: vbmap.Vbuuids[0], Kv: kv,
+		}
+		if err := endp.Send(dkv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(fn func(msg interface{}) bool) {
+		for {
+			select {
+			case msg := <-appch:
+				if fn(msg) {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for dataport")
+			}
+		}
+	}
+	rejected := func(msg interface{}) bool {
+		if _, ok := msg.([]*protobuf.VbKeyVersions); ok {
+			t.Fatalf("unexpected mutations from unregistered endpoint")
+		}
+		_, ok := msg.(ConnectionError)
+		return ok
+	}
+	accepted := func(msg interface{}) bool {
+		if _, ok := msg.(ConnectionError); ok {
+			t.Fatalf("unexpected connection error for registered endpoint")
+		}
+		_, ok := msg.([]*protobuf.VbKeyVersions)
+		return ok
+	}
+	closed := func(msg interface{}) bool {
+		_, ok := msg.(ConnectionError)
+		return ok
+	}
+
+	econfig := c.SystemConfig.SectionConfig("endpoint.dataport.", true /*trim*/)
+	newEndpoint := func(token string) *RouterEndpoint {
+		endp, err := NewRouterEndpoint("clust", "topic", raddr, token, maxvbuckets, econfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return endp
+	}
+
+	// endpoint without token is accepted until host requires one.
+	endp := newEndpoint("")
+	if err := endp.SendVbmaps(vbmaps); err != nil {
+		t.Fatal(err)
+	}
+	streamBegin(endp)
+	expect(accepted)
+	endp.Close()
+	expect(closed)
+
+	// stale endpoint is rejected.
+	endp = newEndpoint("stale")
+	if err := endp.SendVbmaps(vbmaps); err != nil {
+		t.Fatal(err)
+	}
+	expect(rejected)
+	endp.Close()
+
+	// once host requires token, unframed endpoints and endpoints framed
+	// without token are rejected.
+	if err := daemon.RequireToken("127.0.0.1", "::1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, framed := range []bool{false, true} {
+		endp = newEndpoint("")
+		if !framed {
+			streamBegin(endp)
+		} else if err := endp.SendVbmaps(vbmaps); err != nil {
+			t.Fatal(err)
+		}
+		expect(rejected)
+		endp.Close()
+	}
+
+	// endpoint framed with token is accepted.
+	endp = newEndpoint("token")
+	defer endp.Close()
+	if err := endp.SendVbmaps(vbmaps); err != nil {
+		t.Fatal(err)
+	}
+	streamBegin(endp)
+	expect(accepted)
+}
+
+func TestFrameRouting(t *testing.T) {
+	c.LogIgnore()
+
+	raddr := "localhost:8888"
+	maxvbuckets, mutChanSize := 4, 100
+
+	// start server
+	appch := make(chan interface{}, mutChanSize)
+	prefix := "projector.dataport.indexer."
+	config := c.SystemConfig.SectionConfig(prefix, true /*trim*/)
+	daemon, err := NewServer(raddr, maxvbuckets, config, appch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.Close()
+
+	expect := func(fn func(msg interface{}) bool) {
+		for {
+			select {
+			case msg := <-appch:
+				if fn(msg) {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for dataport")
+			}
+		}
+	}
+	var framed string
+	info := func(msg interface{}) bool {
+		if info, ok := msg.(*ConnectionInfo); ok {
+			framed = info.Raddr
+			return true
+		}
+		return false
+	}
+	closed := func(msg interface{}) bool {
+		_, ok := msg.(ConnectionError)
+		return ok
+	}
+
+	econfig := c.SystemConfig.SectionConfig("endpoint.dataport.", true /*trim*/)
+	newEndpoint := func() *RouterEndpoint {
+		endp, err := NewRouterEndpoint("clust", "topic", raddr, "", maxvbuckets, econfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return endp
+	}
+
+	// vbuckets beyond maximum are rejected.
+	endp := newEndpoint()
+	vbmap := &c.VbConnectionMap{Bucket: "default", Vbuckets: []uint16{0, uint16(maxvbuckets)}}
+	if err := endp.SendVbmaps([]*c.VbConnectionMap{vbmap}); err != nil {
+		t.Fatal(err)
+	}
+	expect(closed)
+	endp.Close()
+
+	// application can reject routing of a framed connection.
+	endp = newEndpoint()
+	defer endp.Close()
+	vbmap = &c.VbConnectionMap{Bucket: "default", Vbuckets: []uint16{0, 1}}
+	if err := endp.SendVbmaps([]*c.VbConnectionMap{vbmap}); err != nil {
+		t.Fatal(err)
+	}
+	expect(info)
+	if err := daemon.RejectRouting(framed); err != nil {
+		t.Fatal(err)
+	}
+	expect(closed)
+}
+
 func BenchmarkLoopback(b *testing.B) {
 	//c.LogIgnore()
 	c.SetLogLevel(c.LogLevelDebug)
@@ -212,7 +401,7 @@ func BenchmarkLoopback(b *testing.B) {
 
 	// start endpoint
 	config = c.SystemConfig.SectionConfig("endpoint.dataport.", true /*trim*/)
-	endp, err := NewRouterEndpoint("clust", "topic", raddr, maxvbuckets, config)
+	endp, err := NewRouterEndpoint("clust", "topic", raddr, "", maxvbuckets, config)
 	if err != nil {
 		b.Fatal(err)
 	}
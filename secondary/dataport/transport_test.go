@@ -61,6 +61,88 @@ func TestPktVbmap(t *testing.T) {
 	}
 }
 
+func TestPktCompression(t *testing.T) {
+	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
+	vbsRef := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
+	vbmapRef := &c.VbConnectionMap{
+		Bucket:   "default",
+		Vbuckets: []uint16{1, 2, 3, 4},
+		Vbuuids:  []uint64{10, 20, 30, 40},
+	}
+	tc := newTestConnection()
+	tc.reset()
+	stats := &transport.CompressionStats{}
+	flags := transport.TransportFlag(0).SetProtobuf().SetGzip()
+	pkt := transport.NewTransportPacket(1000*1024, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
+	pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	pkt.SetCompressionThreshold(1024).SetCompressionStats(stats)
+
+	// larger than threshold, sent compressed
+	if err := pkt.Send(tc, vbsRef); err != nil {
+		t.Fatal(err)
+	}
+	if payload, err := pkt.Receive(tc); err != nil {
+		t.Fatal(err)
+	} else {
+		comp := pkt.Flags().GetCompression()
+		if comp != transport.CompressionGzip {
+			t.Fatalf("expected gzip compression, got %v", comp)
+		}
+		vbs := protobuf2VbKeyVersions(payload.([]*protobuf.VbKeyVersions))
+		if len(vbsRef) != len(vbs) {
+			t.Fatal("Mismatch in length")
+		}
+		for i, vb := range vbs {
+			if vb.Equal(vbsRef[i]) == false {
+				t.Fatal("Mismatch in VbKeyVersions")
+			}
+		}
+	}
+	if stats.Packets != 1 || stats.CompressedBytes >= stats.RawBytes {
+		t.Fatalf("unexpected compression stats %+v", stats)
+	} else if ratio := stats.Ratio(); ratio >= 1.0 {
+		t.Fatalf("unexpected compression ratio %v", ratio)
+	}
+
+	// upto threshold, sent uncompressed
+	tc.reset()
+	if err := pkt.Send(tc, vbmapRef); err != nil {
+		t.Fatal(err)
+	}
+	if payload, err := pkt.Receive(tc); err != nil {
+		t.Fatal(err)
+	} else {
+		comp := pkt.Flags().GetCompression()
+		if comp != transport.CompressionNone {
+			t.Fatalf("expected no compression, got %v", comp)
+		}
+		vbmap := protobuf2Vbmap(payload.(*protobuf.VbConnectionMap))
+		if vbmap.Equal(vbmapRef) == false {
+			t.Fatal("Mismatch in VbConnectionMap")
+		}
+	}
+	if stats.Packets != 1 {
+		t.Fatalf("unexpected compression stats %+v", stats)
+	}
+}
+
+func TestFlagsAcceptCompression(t *testing.T) {
+	flags := transport.TransportFlag(0).SetProtobuf().SetGzip()
+	flags = flags.SetAcceptCompression(transport.CompressionGzip)
+	if flags.GetAcceptCompression() != transport.CompressionGzip {
+		t.Fatalf("unexpected accepted compression %v", flags)
+	}
+	if flags.GetCompression() != transport.CompressionGzip ||
+		flags.GetEncoding() != transport.EncodingProtobuf {
+		t.Fatalf("unexpected flags %v", flags)
+	}
+	flags = flags.SetAcceptCompression(transport.CompressionNone)
+	if flags.GetAcceptCompression() != transport.CompressionNone {
+		t.Fatalf("unexpected accepted compression %v", flags)
+	}
+}
+
 func BenchmarkSendVbKeyVersions(b *testing.B) {
 	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
 	vbs := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
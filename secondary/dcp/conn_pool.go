@@ -52,7 +52,16 @@ func defaultMkConn(host string, ah AuthHandler) (*memcached.Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	return authenticateConn(host, conn, ah)
+}
+
+// authenticateConn with `ah`, closing the connection if authentication
+// fails.
+func authenticateConn(
+	host string, conn *memcached.Client,
+	ah AuthHandler) (*memcached.Client, error) {
 
+	var err error
 	if gah, ok := ah.(GenericMcdAuthHandler); ok {
 		err = gah.AuthenticateMemcachedConn(host, conn)
 		if err != nil {
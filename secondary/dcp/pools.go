@@ -240,6 +240,7 @@ type Client struct {
 	BaseURL *url.URL
 	ah      AuthHandler
 	Info    Pools
+	tls     *kvTLS // memcached connections over TLS, if not nil
 }
 
 func maybeAddAuth(req *http.Request, ah AuthHandler) {
@@ -385,6 +386,24 @@ func (b *Bucket) parseURLResponse(path string, out interface{}) error {
 	return errors.New("all nodes failed to respond")
 }
 
+// GetRandomKey returns the key of a document picked at random from
+// this bucket.
+func (b *Bucket) GetRandomKey() (string, error) {
+	if b.LocalRandomKeyURI == "" {
+		return "", errors.New("bucket does not support random keys")
+	}
+	var res struct {
+		Ok  bool   `json:"ok"`
+		Key string `json:"key"`
+	}
+	if err := b.pool.client.parseURLResponse(b.LocalRandomKeyURI, &res); err != nil {
+		return "", err
+	} else if !res.Ok {
+		return "", errors.New("no random key in bucket")
+	}
+	return res.Key, nil
+}
+
 type basicAuth struct {
 	u, p string
 }
@@ -475,6 +494,9 @@ func (b *Bucket) init(nb *Bucket) {
 		newcps[i] = newConnectionPool(
 			nb.VBSMJson.ServerList[i],
 			b.authHandler(), PoolSize, PoolOverflow)
+		if b.pool.client.tls != nil {
+			newcps[i].mkConn = b.pool.client.tls.mkConn
+		}
 	}
 	b.replaceConnPools(newcps)
 	atomic.StorePointer(&b.vBucketServerMap, unsafe.Pointer(&nb.VBSMJson))
@@ -0,0 +1,99 @@
+package couchbase
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/couchbase/indexing/secondary/dcp/transport/client"
+)
+
+// kvTLS settings to connect with memcached over TLS. Connection pools
+// continue to be identified by the plain address of the node, as
+// published by the vbucket map, while connections are made to its TLS
+// port.
+type kvTLS struct {
+	config *tls.Config
+	port   int    // TLS port of every node, 0 to use advertised kvSSL
+	client Client // to look up ports advertised by nodeServices
+
+	mu       sync.Mutex
+	sslPorts map[string]string // plain address -> TLS address
+}
+
+// ConnectWithAuthTLS connects to a couchbase cluster with the given
+// authentication handler, memcached connections to nodes are made over
+// TLS using `config`. If `port` is 0, TLS port advertised by each node
+// as kvSSL service is used. A nil `config` connects in plain text, same
+// as ConnectWithAuth.
+func ConnectWithAuthTLS(
+	baseU string, ah AuthHandler,
+	config *tls.Config, port int) (c Client, err error) {
+
+	if c, err = ConnectWithAuth(baseU, ah); err != nil || config == nil {
+		return c, err
+	}
+	c.tls = &kvTLS{
+		config:   config,
+		port:     port,
+		client:   c,
+		sslPorts: make(map[string]string),
+	}
+	return c, nil
+}
+
+func (t *kvTLS) mkConn(host string, ah AuthHandler) (*memcached.Client, error) {
+	addr, err := t.tlsAddress(host)
+	if err != nil {
+		return nil, err
+	}
+	// unless configured, certificate is verified against the hostname
+	// of the node being connected.
+	conn, err := memcached.ConnectTLS("tcp", addr, t.config)
+	if err != nil {
+		return nil, err
+	}
+	return authenticateConn(host, conn, ah)
+}
+
+// tlsAddress of the node serving memcached on plain address `host`.
+func (t *kvTLS) tlsAddress(host string) (string, error) {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return "", err
+	}
+	if t.port > 0 {
+		return net.JoinHostPort(h, strconv.Itoa(t.port)), nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if addr, ok := t.sslPorts[host]; ok {
+		return addr, nil
+	}
+	// topology could have changed since the last look up.
+	ps, err := t.client.GetPoolServices("default")
+	if err != nil {
+		return "", err
+	}
+	connHost, _, _ := net.SplitHostPort(t.client.BaseURL.Host)
+	for _, ns := range ps.NodesExt {
+		hostname := ns.Hostname
+		if hostname == "" {
+			hostname = connHost
+		}
+		kv, ok1 := ns.Services["kv"]
+		kvSSL, ok2 := ns.Services["kvSSL"]
+		if ok1 && ok2 {
+			plain := net.JoinHostPort(hostname, strconv.Itoa(kv))
+			t.sslPorts[plain] = net.JoinHostPort(hostname, strconv.Itoa(kvSSL))
+		}
+	}
+	if addr, ok := t.sslPorts[host]; ok {
+		return addr, nil
+	}
+	return "", fmt.Errorf("no kvSSL port advertised for %v", host)
+}
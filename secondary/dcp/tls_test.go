@@ -0,0 +1,51 @@
+package couchbase
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestTLSAddress(t *testing.T) {
+	nodeServices := `{"rev": 1, "nodesExt": [` +
+		`{"services": {"kv": 11210, "kvSSL": 11207}, "thisNode": true},` +
+		`{"services": {"kv": 11210, "kvSSL": 11208}, "hostname": "n2"},` +
+		`{"services": {"kv": 11210}, "hostname": "n3"}]}`
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, nodeServices)
+		}))
+	defer srv.Close()
+
+	baseURL, _ := url.Parse(srv.URL)
+	connHost, _, _ := net.SplitHostPort(baseURL.Host)
+	c := Client{
+		BaseURL: baseURL,
+		Info:    Pools{Pools: []RestPool{{Name: "default"}}},
+	}
+
+	kt := &kvTLS{client: c, sslPorts: make(map[string]string)}
+	ref := map[string]string{
+		net.JoinHostPort(connHost, "11210"): net.JoinHostPort(connHost, "11207"),
+		"n2:11210":                          "n2:11208",
+	}
+	for host, refAddr := range ref {
+		if addr, err := kt.tlsAddress(host); err != nil {
+			t.Fatal(err)
+		} else if addr != refAddr {
+			t.Fatalf("expected %v for %v, got %v", refAddr, host, addr)
+		}
+	}
+	if _, err := kt.tlsAddress("n3:11210"); err == nil {
+		t.Fatalf("expected error for node without kvSSL")
+	}
+
+	// configured port applies to every node.
+	kt = &kvTLS{client: c, port: 12000}
+	if addr, _ := kt.tlsAddress("n3:11210"); addr != "n3:12000" {
+		t.Fatalf("expected n3:12000, got %v", addr)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"log"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -67,8 +68,9 @@ type UprFeed struct {
 	transmitCl  chan bool                 //  closer channel for transmit go-routine
 }
 
+// UprStats for an UPR connection, updated atomically.
 type UprStats struct {
-	TotalBytes         uint64
+	TotalBytes         uint64 // bytes received
 	TotalMutation      uint64
 	TotalBufferAckSent uint64
 	TotalSnapShot      uint64
@@ -365,6 +367,7 @@ func (feed *UprFeed) doStreamClose(ch chan *UprEvent) {
 			VBucket: vb,
 			VBuuid:  stream.Vbuuid,
 			Opcode:  transport.UPR_STREAMEND,
+			Error:   feed.Error,
 		}
 		ch <- uprEvent
 	}
@@ -403,7 +406,7 @@ loop:
 			}
 
 			vb := vbOpaque(pkt.Opaque)
-			uprStats.TotalBytes = uint64(bytes)
+			atomic.AddUint64(&uprStats.TotalBytes, uint64(bytes))
 
 			feed.mu.RLock()
 			stream := feed.vbstreams[vb]
@@ -441,6 +444,7 @@ loop:
 						Opcode:  transport.UPR_STREAMREQ,
 						Status:  status,
 						VBucket: vb,
+						Opaque:  appOpaque(pkt.Opaque),
 						Error:   err,
 					}
 					// delete the stream
@@ -457,7 +461,7 @@ loop:
 					break loop
 				}
 				event = makeUprEvent(pkt, stream)
-				uprStats.TotalMutation++
+				atomic.AddUint64(&uprStats.TotalMutation, 1)
 				sendAck = true
 
 			case transport.UPR_STREAMEND:
@@ -484,7 +488,7 @@ loop:
 				event.SnapstartSeq = binary.BigEndian.Uint64(pkt.Extras[0:8])
 				event.SnapendSeq = binary.BigEndian.Uint64(pkt.Extras[8:16])
 				event.SnapshotType = binary.BigEndian.Uint32(pkt.Extras[16:20])
-				uprStats.TotalSnapShot++
+				atomic.AddUint64(&uprStats.TotalSnapShot, 1)
 				sendAck = true
 
 			case transport.UPR_FLUSH:
@@ -570,7 +574,7 @@ loop:
 			log.Printf("Buffer-ack %v\n", sendSize)
 			binary.BigEndian.PutUint32(bufferAck.Extras[:4], uint32(sendSize))
 			feed.transmitCh <- bufferAck
-			uprStats.TotalBufferAckSent++
+			atomic.AddUint64(&uprStats.TotalBufferAckSent, 1)
 		}
 	}
 
@@ -590,8 +594,14 @@ func (feed *UprFeed) SendBufferAck(sendAck bool, bytes uint32) (bool, uint32) {
 	return false, 0
 }
 
+// GetUprStats returns a snapshot of statistics for this connection.
 func (feed *UprFeed) GetUprStats() *UprStats {
-	return &feed.stats
+	return &UprStats{
+		TotalBytes:         atomic.LoadUint64(&feed.stats.TotalBytes),
+		TotalMutation:      atomic.LoadUint64(&feed.stats.TotalMutation),
+		TotalBufferAckSent: atomic.LoadUint64(&feed.stats.TotalBufferAckSent),
+		TotalSnapShot:      atomic.LoadUint64(&feed.stats.TotalSnapShot),
+	}
 }
 
 func composeOpaque(vbno, opaqueMSB uint16) uint32 {
@@ -0,0 +1,73 @@
+package memcached
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/dcp/transport"
+)
+
+// uprConn replays packets and fails with io.EOF once they are read.
+type uprConn struct {
+	*bytes.Buffer
+}
+
+func (c uprConn) Close() error {
+	return nil
+}
+
+func TestUprFeedStats(t *testing.T) {
+	var buf bytes.Buffer
+	extras := make([]byte, 20)
+	binary.BigEndian.PutUint64(extras[0:8], 10)
+	binary.BigEndian.PutUint64(extras[8:16], 20)
+	pkts := []*transport.MCRequest{
+		{Opcode: transport.UPR_SNAPSHOT, Opaque: composeOpaque(3, 0), Extras: extras},
+		{Opcode: transport.UPR_MUTATION, Opaque: composeOpaque(3, 0),
+			Extras: extras, Key: []byte("doc1"), Body: []byte("value")},
+		{Opcode: transport.UPR_MUTATION, Opaque: composeOpaque(3, 0),
+			Extras: extras, Key: []byte("doc2")},
+	}
+	for _, pkt := range pkts {
+		buf.Write(pkt.Bytes())
+	}
+	total := uint64(buf.Len())
+
+	conn, _ := Wrap(uprConn{&buf})
+	feed := &UprFeed{
+		vbstreams:   map[uint16]*UprStream{3: {Vbucket: 3, Vbuuid: 7}},
+		conn:        conn,
+		closer:      make(chan bool),
+		maxAckBytes: 1 << 20,
+		transmitCl:  make(chan bool, 1),
+	}
+	ch := make(chan *UprEvent, 10)
+	feed.runFeed(ch)
+
+	stats := feed.GetUprStats()
+	if stats.TotalBytes != total {
+		t.Errorf("expected %v bytes, got %v", total, stats.TotalBytes)
+	} else if stats.TotalMutation != 2 || stats.TotalSnapShot != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	} else if stats.TotalBufferAckSent != 0 {
+		t.Errorf("expected no buffer-acks, got %v", stats.TotalBufferAckSent)
+	}
+
+	events := make([]*UprEvent, 0)
+	for event := range ch {
+		events = append(events, event)
+	}
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %v", events)
+	} else if e := events[0]; e.SnapstartSeq != 10 || e.SnapendSeq != 20 {
+		t.Errorf("unexpected snapshot %+v", e)
+	}
+	// connection failure ends the stream with the error.
+	if e := events[3]; e.Opcode != transport.UPR_STREAMEND {
+		t.Errorf("expected stream-end, got %v", e)
+	} else if e.VBucket != 3 || e.VBuuid != 7 || e.Error != io.EOF {
+		t.Errorf("unexpected stream-end %+v", e)
+	}
+}
@@ -151,6 +151,9 @@ func TestMust(t *testing.T) {
 		defer func() { _, errored = recover().(error) }()
 		must(&transport.MCResponse{})
 	}()
+	if !errored {
+		t.Fatalf("expected must to panic on error")
+	}
 }
 
 func TestFuncHandler(t *testing.T) {
@@ -0,0 +1,501 @@
+package memcached
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/couchbase/indexing/secondary/dcp/transport"
+)
+
+const uprSnapshotMemory = 1
+
+// ErrorNotMyVbucket is returned when mutating a vbucket that is not
+// hosted by UprServer.
+var ErrorNotMyVbucket = errors.New("uprServer.notMyVbucket")
+
+// UprResponse programs the response of UprServer to a StreamRequest.
+type UprResponse struct {
+	Status transport.Status
+	Seqno  uint64 // rollback seqno, for ROLLBACK status
+	Drop   bool   // don't respond, to simulate timeouts
+}
+
+// UprServer is a lightweight, in-process UPR producer to test UPR
+// consumers without a KV node. It hosts a set of vbuckets, streams
+// mutations and snapshots for them, and responds to StreamRequests with
+// ROLLBACK or NOT_MY_VBUCKET as a KV node would, or as programmed by
+// RespondStreamRequest().
+type UprServer struct {
+	lis net.Listener
+
+	mu        sync.Mutex
+	vbuckets  map[uint16]*uprVbucket
+	conns     map[*uprConn]bool
+	reqResps  map[uint16][]UprResponse // vbno -> StreamRequest responses
+	nRequests map[uint16]int           // vbno -> StreamRequests received
+	closed    bool
+}
+
+type uprVbucket struct {
+	flog  [][2]uint64 // {vbuuid, seqno}, latest first
+	seqno uint64      // high seqno
+	items []*transport.MCRequest
+}
+
+type uprConn struct {
+	conn    net.Conn
+	name    string
+	streams map[uint16]*uprStream // vbno -> active stream
+}
+
+type uprStream struct {
+	opaque uint32
+	end    uint64
+}
+
+// NewUprServer starts an UPR producer listening on `laddr`, pass
+// "127.0.0.1:0" to pick a free port.
+func NewUprServer(laddr string) (*UprServer, error) {
+	lis, err := net.Listen("tcp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	s := &UprServer{
+		lis:       lis,
+		vbuckets:  make(map[uint16]*uprVbucket),
+		conns:     make(map[*uprConn]bool),
+		reqResps:  make(map[uint16][]UprResponse),
+		nRequests: make(map[uint16]int),
+	}
+	go s.listen()
+	return s, nil
+}
+
+// Addr returns the address server is listening on.
+func (s *UprServer) Addr() string {
+	return s.lis.Addr().String()
+}
+
+// SetVbuckets changes vbuckets hosted by server, like after a rebalance.
+// Newly hosted vbuckets start empty with vbuuid as vbno+1000, streams
+// of vbuckets no more hosted are ended.
+func (s *UprServer) SetVbuckets(vbnos []uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vbuckets := make(map[uint16]*uprVbucket)
+	for _, vbno := range vbnos {
+		if vb, ok := s.vbuckets[vbno]; ok {
+			vbuckets[vbno] = vb
+			continue
+		}
+		vbuckets[vbno] = &uprVbucket{
+			flog:  [][2]uint64{{uint64(vbno) + 1000, 0}},
+			items: make([]*transport.MCRequest, 0),
+		}
+	}
+	for vbno := range s.vbuckets {
+		if _, ok := vbuckets[vbno]; !ok {
+			s.endStream(vbno)
+		}
+	}
+	s.vbuckets = vbuckets
+}
+
+// Vbuckets returns vbuckets hosted by server.
+func (s *UprServer) Vbuckets() []uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vbnos := make([]uint16, 0, len(s.vbuckets))
+	for vbno := range s.vbuckets {
+		vbnos = append(vbnos, vbno)
+	}
+	return vbnos
+}
+
+// FailoverLog returns the failover log of a hosted vbucket, latest entry
+// first, nil if vbucket is not hosted.
+func (s *UprServer) FailoverLog(vbno uint16) [][2]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vb, ok := s.vbuckets[vbno]
+	if !ok {
+		return nil
+	}
+	flog := make([][2]uint64, len(vb.flog))
+	copy(flog, vb.flog)
+	return flog
+}
+
+// Failover starts a new branch of history for vbucket, with `vbuuid` at
+// its current high seqno. Consumers requesting with the older vbuuid
+// beyond this seqno will be asked to rollback.
+func (s *UprServer) Failover(vbno uint16, vbuuid uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vb, ok := s.vbuckets[vbno]
+	if !ok {
+		return ErrorNotMyVbucket
+	}
+	vb.flog = append([][2]uint64{{vbuuid, vb.seqno}}, vb.flog...)
+	return nil
+}
+
+// Mutation appends a mutation for `key` to a hosted vbucket and sends it
+// to active streams, returns its seqno.
+func (s *UprServer) Mutation(vbno uint16, key, value []byte) (uint64, error) {
+	return s.appendItem(transport.UPR_MUTATION, vbno, key, value)
+}
+
+// Deletion appends a deletion for `key` to a hosted vbucket and sends it
+// to active streams, returns its seqno.
+func (s *UprServer) Deletion(vbno uint16, key []byte) (uint64, error) {
+	return s.appendItem(transport.UPR_DELETION, vbno, key, nil)
+}
+
+// RespondStreamRequest queues responses for the next StreamRequests on
+// vbucket, once they are used up server responds as a KV node would.
+func (s *UprServer) RespondStreamRequest(vbno uint16, resps ...UprResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reqResps[vbno] = append(s.reqResps[vbno], resps...)
+}
+
+// StreamRequests returns the number of StreamRequests received for
+// vbucket.
+func (s *UprServer) StreamRequests(vbno uint16) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nRequests[vbno]
+}
+
+// EndStream sends STREAMEND to consumers streaming vbucket.
+func (s *UprServer) EndStream(vbno uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endStream(vbno)
+}
+
+// DropConnections closes all consumer connections, to simulate a failed
+// KV node. Server continues to accept new connections.
+func (s *UprServer) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for uc := range s.conns {
+		uc.conn.Close()
+		delete(s.conns, uc)
+	}
+}
+
+// Close server and all its consumer connections.
+func (s *UprServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for uc := range s.conns {
+		uc.conn.Close()
+		delete(s.conns, uc)
+	}
+	return s.lis.Close()
+}
+
+func (s *UprServer) listen() {
+	for {
+		conn, err := s.lis.Accept()
+		if err != nil {
+			return
+		}
+		uc := &uprConn{conn: conn, streams: make(map[uint16]*uprStream)}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[uc] = true
+		s.mu.Unlock()
+		go s.handleConn(uc)
+	}
+}
+
+func (s *UprServer) handleConn(uc *uprConn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, uc)
+		s.mu.Unlock()
+		uc.conn.Close()
+	}()
+
+	for {
+		req, err := ReadPacket(uc.conn)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("UprServer %q: %v\n", uc.name, err)
+			}
+			return
+		}
+		s.mu.Lock()
+		err = s.handleRequest(uc, &req)
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleRequest shall be called with server locked, so that responses
+// are ordered with mutations streamed by other callers.
+func (s *UprServer) handleRequest(uc *uprConn, req *transport.MCRequest) error {
+	res := &transport.MCResponse{
+		Opcode: req.Opcode,
+		Opaque: req.Opaque,
+		Status: transport.SUCCESS,
+	}
+
+	switch req.Opcode {
+	case transport.UPR_OPEN:
+		uc.name = string(req.Key)
+
+	case transport.UPR_CONTROL:
+
+	case transport.UPR_FAILOVERLOG:
+		vb, ok := s.vbuckets[req.VBucket]
+		if !ok {
+			res.Status = transport.NOT_MY_VBUCKET
+			break
+		}
+		res.Body = encodeFailoverLog(vb.flog)
+
+	case transport.UPR_STREAMREQ:
+		return s.streamRequest(uc, req)
+
+	case transport.UPR_CLOSESTREAM:
+		if _, ok := uc.streams[req.VBucket]; !ok {
+			res.Status = transport.KEY_ENOENT
+			break
+		}
+		delete(uc.streams, req.VBucket)
+
+	case transport.UPR_BUFFERACK, transport.UPR_NOOP:
+		return nil // no response
+
+	default:
+		res.Status = transport.UNKNOWN_COMMAND
+	}
+	_, err := res.Transmit(uc.conn)
+	return err
+}
+
+func (s *UprServer) streamRequest(
+	uc *uprConn, req *transport.MCRequest) (err error) {
+
+	vbno := req.VBucket
+	s.nRequests[vbno]++
+
+	res := &transport.MCResponse{
+		Opcode: req.Opcode,
+		Opaque: req.Opaque,
+		Status: transport.SUCCESS,
+	}
+	if len(req.Extras) != 48 {
+		res.Status = transport.EINVAL
+		_, err = res.Transmit(uc.conn)
+		return err
+	}
+	start := binary.BigEndian.Uint64(req.Extras[8:16])
+	end := binary.BigEndian.Uint64(req.Extras[16:24])
+	vbuuid := binary.BigEndian.Uint64(req.Extras[24:32])
+
+	vb, ok := s.vbuckets[vbno]
+	resp := UprResponse{Status: transport.SUCCESS}
+	if resps := s.reqResps[vbno]; len(resps) > 0 {
+		resp, s.reqResps[vbno] = resps[0], resps[1:]
+	} else if !ok {
+		resp.Status = transport.NOT_MY_VBUCKET
+	} else if rollback, yes := vb.rollbackSeqno(vbuuid, start); yes {
+		resp = UprResponse{Status: transport.ROLLBACK, Seqno: rollback}
+	} else if _, active := uc.streams[vbno]; active {
+		resp.Status = transport.KEY_EEXISTS
+	}
+	if resp.Status == transport.SUCCESS && !ok {
+		resp.Status = transport.NOT_MY_VBUCKET
+	}
+
+	switch {
+	case resp.Drop:
+		return nil
+
+	case resp.Status == transport.ROLLBACK:
+		res.Status = resp.Status
+		res.Extras = make([]byte, 8)
+		binary.BigEndian.PutUint64(res.Extras, resp.Seqno)
+		_, err = res.Transmit(uc.conn)
+		return err
+
+	case resp.Status != transport.SUCCESS:
+		res.Status = resp.Status
+		_, err = res.Transmit(uc.conn)
+		return err
+	}
+
+	res.Body = encodeFailoverLog(vb.flog)
+	if _, err = res.Transmit(uc.conn); err != nil {
+		return err
+	}
+	stream := &uprStream{opaque: req.Opaque, end: end}
+	uc.streams[vbno] = stream
+
+	// backfill items after start seqno as a single snapshot.
+	items := make([]*transport.MCRequest, 0)
+	for _, item := range vb.items {
+		if seqno := itemSeqno(item); seqno > start && seqno <= end {
+			items = append(items, item)
+		}
+	}
+	if len(items) > 0 {
+		snapEnd := itemSeqno(items[len(items)-1])
+		if err = uc.sendSnapshot(vbno, stream, start, snapEnd); err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err = uc.sendItem(stream, item); err != nil {
+				return err
+			}
+		}
+	}
+	if vb.seqno >= end {
+		return uc.sendStreamEnd(vbno, stream)
+	}
+	return nil
+}
+
+func (s *UprServer) appendItem(
+	opcode transport.CommandCode, vbno uint16, key, value []byte) (uint64, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vb, ok := s.vbuckets[vbno]
+	if !ok {
+		return 0, ErrorNotMyVbucket
+	}
+	vb.seqno++
+	item := &transport.MCRequest{
+		Opcode:  opcode,
+		VBucket: vbno,
+		Key:     key,
+		Body:    value,
+		Cas:     vb.seqno,
+		Extras:  make([]byte, 16),
+	}
+	binary.BigEndian.PutUint64(item.Extras[:8], vb.seqno)
+	vb.items = append(vb.items, item)
+
+	for uc := range s.conns {
+		stream, ok := uc.streams[vbno]
+		if !ok {
+			continue
+		}
+		err := uc.sendSnapshot(vbno, stream, vb.seqno, vb.seqno)
+		if err == nil {
+			err = uc.sendItem(stream, item)
+		}
+		if err == nil && vb.seqno >= stream.end {
+			err = uc.sendStreamEnd(vbno, stream)
+		}
+		if err != nil {
+			log.Printf("UprServer %q: %v\n", uc.name, err)
+		}
+	}
+	return vb.seqno, nil
+}
+
+func (s *UprServer) endStream(vbno uint16) {
+	for uc := range s.conns {
+		if stream, ok := uc.streams[vbno]; ok {
+			if err := uc.sendStreamEnd(vbno, stream); err != nil {
+				log.Printf("UprServer %q: %v\n", uc.name, err)
+			}
+		}
+	}
+}
+
+// rollbackSeqno returns the seqno to which a consumer, at `seqno` on the
+// history branch `vbuuid`, shall rollback, false if not required.
+func (vb *uprVbucket) rollbackSeqno(vbuuid, seqno uint64) (uint64, bool) {
+	if seqno == 0 {
+		return 0, false
+	}
+	branchEnd := vb.seqno
+	for _, entry := range vb.flog {
+		if entry[0] == vbuuid {
+			if seqno > branchEnd {
+				return branchEnd, true
+			}
+			return 0, false
+		}
+		branchEnd = entry[1]
+	}
+	return 0, true
+}
+
+func (uc *uprConn) sendSnapshot(
+	vbno uint16, stream *uprStream, start, end uint64) error {
+
+	pkt := &transport.MCRequest{
+		Opcode:  transport.UPR_SNAPSHOT,
+		VBucket: vbno,
+		Opaque:  stream.opaque,
+		Extras:  make([]byte, 20),
+	}
+	binary.BigEndian.PutUint64(pkt.Extras[:8], start)
+	binary.BigEndian.PutUint64(pkt.Extras[8:16], end)
+	binary.BigEndian.PutUint32(pkt.Extras[16:20], uprSnapshotMemory)
+	_, err := pkt.Transmit(uc.conn)
+	return err
+}
+
+func (uc *uprConn) sendItem(
+	stream *uprStream, item *transport.MCRequest) error {
+
+	pkt := *item
+	pkt.Opaque = stream.opaque
+	_, err := pkt.Transmit(uc.conn)
+	return err
+}
+
+func (uc *uprConn) sendStreamEnd(vbno uint16, stream *uprStream) error {
+	delete(uc.streams, vbno)
+	pkt := &transport.MCRequest{
+		Opcode:  transport.UPR_STREAMEND,
+		VBucket: vbno,
+		Opaque:  stream.opaque,
+		Extras:  make([]byte, 4),
+	}
+	_, err := pkt.Transmit(uc.conn)
+	return err
+}
+
+func itemSeqno(item *transport.MCRequest) uint64 {
+	return binary.BigEndian.Uint64(item.Extras[:8])
+}
+
+func encodeFailoverLog(flog [][2]uint64) []byte {
+	body := make([]byte, 16*len(flog))
+	for i, entry := range flog {
+		binary.BigEndian.PutUint64(body[i*16:], entry[0])
+		binary.BigEndian.PutUint64(body[i*16+8:], entry[1])
+	}
+	return body
+}
@@ -0,0 +1,179 @@
+package memcached
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+)
+
+const maxSeqno = 0xFFFFFFFFFFFFFFFF
+
+func startUprServer(t *testing.T, vbnos []uint16) (*UprServer, *mc.UprFeed) {
+	s, err := NewUprServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetVbuckets(vbnos)
+
+	conn, err := mc.Connect("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed, err := conn.NewUprFeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := feed.UprOpen("test", 0, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	feed.StartFeed()
+	return s, feed
+}
+
+func expectEvent(
+	t *testing.T, feed *mc.UprFeed,
+	opcode transport.CommandCode, status transport.Status) *mc.UprEvent {
+
+	select {
+	case event, ok := <-feed.C:
+		if !ok {
+			t.Fatalf("feed closed while expecting %v", opcode)
+		}
+		if event.Opcode != opcode || event.Status != status {
+			t.Fatalf("expected %v %v, got %v %v",
+				opcode, status, event.Opcode, event.Status)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout expecting %v", opcode)
+	}
+	return nil
+}
+
+func TestUprServerStream(t *testing.T) {
+	s, feed := startUprServer(t, []uint16{0, 1})
+	defer s.Close()
+	defer feed.Close()
+
+	s.Mutation(0, []byte("key1"), []byte("value1"))
+	s.Deletion(0, []byte("key1"))
+
+	feed.UprRequestStream(0, 0x10, 0, 1000, 0, maxSeqno, 0, 0)
+	event := expectEvent(t, feed, transport.UPR_STREAMREQ, transport.SUCCESS)
+	if vbuuid, _, _ := event.FailoverLog.Latest(); vbuuid != 1000 {
+		t.Fatalf("expected vbuuid 1000, got %v", vbuuid)
+	}
+	event = expectEvent(t, feed, transport.UPR_SNAPSHOT, transport.SUCCESS)
+	if event.SnapstartSeq != 0 || event.SnapendSeq != 2 {
+		t.Fatalf("unexpected snapshot %v-%v",
+			event.SnapstartSeq, event.SnapendSeq)
+	}
+	event = expectEvent(t, feed, transport.UPR_MUTATION, transport.SUCCESS)
+	if event.Seqno != 1 || string(event.Key) != "key1" {
+		t.Fatalf("unexpected mutation %v %s", event.Seqno, event.Key)
+	}
+	event = expectEvent(t, feed, transport.UPR_DELETION, transport.SUCCESS)
+	if event.Seqno != 2 || event.Opaque != 0x10 {
+		t.Fatalf("unexpected deletion %v %v", event.Seqno, event.Opaque)
+	}
+
+	s.Mutation(0, []byte("key2"), []byte("value2"))
+	expectEvent(t, feed, transport.UPR_SNAPSHOT, transport.SUCCESS)
+	event = expectEvent(t, feed, transport.UPR_MUTATION, transport.SUCCESS)
+	if event.Seqno != 3 || string(event.Value) != "value2" {
+		t.Fatalf("unexpected mutation %v %s", event.Seqno, event.Value)
+	}
+
+	feed.CloseStream(0, 0x10)
+	expectEvent(t, feed, transport.UPR_STREAMEND, transport.SUCCESS)
+	if n := s.StreamRequests(0); n != 1 {
+		t.Fatalf("expected 1 stream request, got %v", n)
+	}
+}
+
+func TestUprServerNotMyVbucket(t *testing.T) {
+	s, feed := startUprServer(t, []uint16{0})
+	defer s.Close()
+	defer feed.Close()
+
+	feed.UprRequestStream(1, 0x10, 0, 1001, 0, maxSeqno, 0, 0)
+	event := expectEvent(
+		t, feed, transport.UPR_STREAMREQ, transport.NOT_MY_VBUCKET)
+	if event.VBucket != 1 || event.Opaque != 0x10 {
+		t.Fatalf("unexpected event for vb %v opaque %v",
+			event.VBucket, event.Opaque)
+	}
+}
+
+func TestUprServerNotHosted(t *testing.T) {
+	s, err := NewUprServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetVbuckets([]uint16{0})
+
+	if _, err := s.Mutation(1, []byte("key"), []byte("value")); err != ErrorNotMyVbucket {
+		t.Fatalf("expected %v, got %v", ErrorNotMyVbucket, err)
+	}
+	if _, err := s.Deletion(1, []byte("key")); err != ErrorNotMyVbucket {
+		t.Fatalf("expected %v, got %v", ErrorNotMyVbucket, err)
+	}
+	if err := s.Failover(1, 2000); err != ErrorNotMyVbucket {
+		t.Fatalf("expected %v, got %v", ErrorNotMyVbucket, err)
+	}
+	if seqno, err := s.Mutation(0, []byte("key"), []byte("value")); err != nil || seqno != 1 {
+		t.Fatalf("unexpected mutation %v %v", seqno, err)
+	}
+	if err := s.Failover(0, 2000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUprServerRollback(t *testing.T) {
+	s, feed := startUprServer(t, []uint16{0, 1})
+	defer s.Close()
+	defer feed.Close()
+
+	// programmed rollback.
+	s.RespondStreamRequest(
+		1, UprResponse{Status: transport.ROLLBACK, Seqno: 10})
+	feed.UprRequestStream(1, 0x10, 0, 1001, 0, maxSeqno, 0, 0)
+	event := expectEvent(t, feed, transport.UPR_STREAMREQ, transport.ROLLBACK)
+	if event.Seqno != 10 {
+		t.Fatalf("expected rollback to 10, got %v", event.Seqno)
+	}
+
+	// rollback after failover.
+	for i := 0; i < 5; i++ {
+		s.Mutation(0, []byte("key"), []byte("value"))
+	}
+	s.Failover(0, 2000)
+	feed.UprRequestStream(0, 0x10, 0, 1000, 8, maxSeqno, 8, 8)
+	event = expectEvent(t, feed, transport.UPR_STREAMREQ, transport.ROLLBACK)
+	if event.Seqno != 5 {
+		t.Fatalf("expected rollback to 5, got %v", event.Seqno)
+	}
+
+	feed.UprRequestStream(0, 0x10, 0, 1000, 5, maxSeqno, 5, 5)
+	expectEvent(t, feed, transport.UPR_STREAMREQ, transport.SUCCESS)
+	if flog := s.FailoverLog(0); len(flog) != 2 || flog[0][0] != 2000 {
+		t.Fatalf("unexpected failover log %v", flog)
+	}
+}
+
+func TestUprServerDropConnections(t *testing.T) {
+	s, feed := startUprServer(t, []uint16{0})
+	defer s.Close()
+	defer feed.Close()
+
+	feed.UprRequestStream(0, 0x10, 0, 1000, 0, maxSeqno, 0, 0)
+	expectEvent(t, feed, transport.UPR_STREAMREQ, transport.SUCCESS)
+	s.DropConnections()
+	event := expectEvent(t, feed, transport.UPR_STREAMEND, transport.SUCCESS)
+	if event.Error == nil {
+		t.Fatalf("expected stream to end with error")
+	}
+}
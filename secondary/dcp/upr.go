@@ -150,6 +150,7 @@ func (b *Bucket) StartUprFeedOver(
 const (
 	ufCmdRequestStream byte = iota + 1
 	ufCmdCloseStream
+	ufCmdGetStats
 	ufCmdClose
 )
 
@@ -178,6 +179,18 @@ func (feed *UprFeed) UprCloseStream(vb, opaqueMSB uint16) error {
 	return opError(err, resp, 0)
 }
 
+// GetUprStats returns statistics aggregated across UPR connections to all
+// kv nodes. Synchronous call.
+func (feed *UprFeed) GetUprStats() (*memcached.UprStats, error) {
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{ufCmdGetStats, respch}
+	resp, err := failsafeOp(feed.reqch, respch, cmd, feed.finch)
+	if err != nil {
+		return nil, err
+	}
+	return resp[0].(*memcached.UprStats), nil
+}
+
 // Close UprFeed. Synchronous call.
 func (feed *UprFeed) Close() error {
 	respch := make(chan []interface{}, 1)
@@ -217,6 +230,10 @@ loop:
 				respch := msg[3].(chan []interface{})
 				respch <- []interface{}{err}
 
+			case ufCmdGetStats:
+				respch := msg[1].(chan []interface{})
+				respch <- []interface{}{feed.getUprStats()}
+
 			case ufCmdClose:
 				respch := msg[1].(chan []interface{})
 				respch <- []interface{}{nil}
@@ -330,6 +347,18 @@ func (feed *UprFeed) uprCloseStream(vb, opaqueMSB uint16) error {
 	return nil
 }
 
+func (feed *UprFeed) getUprStats() *memcached.UprStats {
+	stats := &memcached.UprStats{}
+	for _, nodeFeed := range feed.nodeFeeds {
+		s := nodeFeed.uprFeed.GetUprStats()
+		stats.TotalBytes += s.TotalBytes
+		stats.TotalMutation += s.TotalMutation
+		stats.TotalBufferAckSent += s.TotalBufferAckSent
+		stats.TotalSnapShot += s.TotalSnapShot
+	}
+	return stats
+}
+
 // go routine
 func (feed *UprFeed) forwardUprEvents(nodeFeed *FeedInfo, finch chan bool) {
 	singleFeed := nodeFeed.uprFeed
@@ -0,0 +1,120 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// Initial build of indexes on a bucket, waiting for a build slot.
+type buildRequest struct {
+	bucket     string
+	instIdList []common.IndexInstId
+	clientCh   MsgChannel //only for cbq bridge, responded once build is done
+	retryAt    time.Time  //for a failed build, not started before this time
+}
+
+// Build requests that could not be started as the limit on concurrent
+// builds was reached, or that failed to start and are waiting to be
+// retried, kept in the order they were made. Queue is owned
+// by the indexer's main loop and is not persisted, indexes queued at the
+// time of a restart stay in Created state till built again.
+type buildQueue struct {
+	reqs []*buildRequest
+}
+
+func newBuildQueue() *buildQueue {
+	return &buildQueue{reqs: make([]*buildRequest, 0)}
+}
+
+// Push a build request to the end of the queue, index instances which
+// are already queued are skipped. Returns false if nothing was queued.
+func (q *buildQueue) Push(req *buildRequest) bool {
+	instIdList := make([]common.IndexInstId, 0, len(req.instIdList))
+	for _, instId := range req.instIdList {
+		if q.Position(instId) == 0 {
+			instIdList = append(instIdList, instId)
+		}
+	}
+	if len(instIdList) == 0 {
+		return false
+	}
+	req.instIdList = instIdList
+	q.reqs = append(q.reqs, req)
+	return true
+}
+
+// Pop removes and returns the first request whose bucket is not to be
+// skipped and which is not waiting to be retried, nil if there is no
+// such request.
+func (q *buildQueue) Pop(skip func(bucket string) bool) *buildRequest {
+	now := time.Now()
+	for i, req := range q.reqs {
+		if req.retryAt.After(now) || skip(req.bucket) {
+			continue
+		}
+		q.reqs = append(q.reqs[:i], q.reqs[i+1:]...)
+		return req
+	}
+	return nil
+}
+
+// Remove an index instance from the queue. If that leaves its request
+// empty, the request is removed as well and returned.
+func (q *buildQueue) Remove(instId common.IndexInstId) *buildRequest {
+	for i, req := range q.reqs {
+		for j, id := range req.instIdList {
+			if id != instId {
+				continue
+			}
+			req.instIdList = append(req.instIdList[:j], req.instIdList[j+1:]...)
+			if len(req.instIdList) == 0 {
+				q.reqs = append(q.reqs[:i], q.reqs[i+1:]...)
+				return req
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// Position of the request that queued the index instance, starting
+// from 1 for the request to be started next. 0 if not queued.
+func (q *buildQueue) Position(instId common.IndexInstId) int {
+	for i, req := range q.reqs {
+		for _, id := range req.instIdList {
+			if id == instId {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// Len returns the number of queued requests.
+func (q *buildQueue) Len() int {
+	return len(q.reqs)
+}
+
+// buildRetryBackoff returns the time to wait before retrying a build
+// after `attempt` attempts have failed, doubling from interval upto
+// maxInterval.
+func buildRetryBackoff(attempt int, interval, maxInterval time.Duration) time.Duration {
+	backoff := interval
+	for i := 1; i < attempt && backoff < maxInterval; i++ {
+		backoff *= 2
+	}
+	if backoff > maxInterval {
+		backoff = maxInterval
+	}
+	return backoff
+}
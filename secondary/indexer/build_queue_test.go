@@ -0,0 +1,94 @@
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+	"time"
+)
+
+func TestBuildQueueOrder(t *testing.T) {
+	q := newBuildQueue()
+
+	q.Push(&buildRequest{bucket: "a", instIdList: []common.IndexInstId{1, 2}})
+	q.Push(&buildRequest{bucket: "b", instIdList: []common.IndexInstId{3}})
+	q.Push(&buildRequest{bucket: "c", instIdList: []common.IndexInstId{4}})
+	if ok := q.Push(&buildRequest{bucket: "a", instIdList: []common.IndexInstId{2}}); ok {
+		t.Errorf("expected already queued instance to be skipped")
+	}
+	if q.Len() != 3 {
+		t.Errorf("expected 3 requests, got %v", q.Len())
+	}
+	if p := q.Position(3); p != 2 {
+		t.Errorf("expected position 2, got %v", p)
+	}
+
+	// bucket a has a build running, b goes first
+	req := q.Pop(func(bucket string) bool { return bucket == "a" })
+	if req == nil || req.bucket != "b" {
+		t.Fatalf("expected request for bucket b, got %v", req)
+	}
+	if p := q.Position(4); p != 2 {
+		t.Errorf("expected position 2, got %v", p)
+	}
+
+	req = q.Pop(func(bucket string) bool { return false })
+	if req == nil || req.bucket != "a" || len(req.instIdList) != 2 {
+		t.Fatalf("expected request for bucket a, got %v", req)
+	}
+	if q.Pop(func(bucket string) bool { return true }) != nil {
+		t.Errorf("expected no request when all buckets are skipped")
+	}
+}
+
+func TestBuildQueueRemove(t *testing.T) {
+	q := newBuildQueue()
+
+	q.Push(&buildRequest{bucket: "a", instIdList: []common.IndexInstId{1, 2}})
+	q.Push(&buildRequest{bucket: "b", instIdList: []common.IndexInstId{3}})
+
+	if req := q.Remove(1); req != nil {
+		t.Errorf("expected request to remain queued")
+	}
+	if p := q.Position(1); p != 0 {
+		t.Errorf("expected removed instance not to be queued, got %v", p)
+	}
+	if req := q.Remove(2); req == nil || req.bucket != "a" {
+		t.Errorf("expected emptied request to be removed, got %v", req)
+	}
+	if p := q.Position(3); p != 1 {
+		t.Errorf("expected position 1, got %v", p)
+	}
+	if req := q.Remove(5); req != nil {
+		t.Errorf("expected nil for unknown instance")
+	}
+}
+
+func TestBuildQueueRetry(t *testing.T) {
+	q := newBuildQueue()
+
+	q.Push(&buildRequest{bucket: "a", instIdList: []common.IndexInstId{1},
+		retryAt: time.Now().Add(time.Hour)})
+	q.Push(&buildRequest{bucket: "b", instIdList: []common.IndexInstId{2}})
+
+	// retry of bucket a is not due yet, b goes first
+	req := q.Pop(func(bucket string) bool { return false })
+	if req == nil || req.bucket != "b" {
+		t.Fatalf("expected request for bucket b, got %v", req)
+	}
+	if req := q.Pop(func(bucket string) bool { return false }); req != nil {
+		t.Fatalf("expected retry not to be popped before it is due, got %v", req)
+	}
+	if p := q.Position(1); p != 1 {
+		t.Errorf("expected position 1, got %v", p)
+	}
+}
+
+func TestBuildRetryBackoff(t *testing.T) {
+	interval, maxInterval := time.Second, 5*time.Second
+	refs := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, ref := range refs {
+		if backoff := buildRetryBackoff(i+1, interval, maxInterval); backoff != ref {
+			t.Errorf("attempt %v: expected %v, got %v", i+1, ref, backoff)
+		}
+	}
+}
@@ -156,6 +156,7 @@ func (cbq *cbqBridge) handleCreate(w http.ResponseWriter, r *http.Request) {
 		cbq.indexMap[idxInst.InstId] = indexinfo
 	} else {
 		err := msg.(*MsgError).GetError()
+		countError(err)
 
 		common.Debugf("CbqBridge::handleCreate Received Error %s", err.cause)
 
@@ -194,6 +195,7 @@ func (cbq *cbqBridge) handleDrop(w http.ResponseWriter, r *http.Request) {
 		delete(cbq.indexMap, common.IndexInstId(defnID))
 	} else {
 		err := msg.(*MsgError).GetError()
+		countError(err)
 
 		common.Debugf("CbqBridge: DropIndex Received Error %s", err.cause)
 
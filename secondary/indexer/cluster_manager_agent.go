@@ -381,6 +381,7 @@ func (meta *metaNotifier) OnIndexCreate(indexDefn *common.IndexDefn) error {
 			common.Debugf("clustMgrAgent::OnIndexCreate Error "+
 				"for Create Index %v. Error %v.", indexDefn, res)
 			err := res.(*MsgError).GetError()
+			countError(err)
 			return err.cause
 
 		default:
@@ -428,6 +429,7 @@ func (meta *metaNotifier) OnIndexBuild(indexDefnList []common.IndexDefnId) error
 			common.Debugf("clustMgrAgent::OnIndexBuild Error "+
 				"for Build Index %v. Error %v.", indexDefnList, res)
 			err := res.(*MsgError).GetError()
+			countError(err)
 			return err.cause
 
 		default:
@@ -467,12 +469,19 @@ func (meta *metaNotifier) OnIndexDelete(defnId common.IndexDefnId) error {
 		case MSG_SUCCESS:
 			common.Debugf("clustMgrAgent::OnIndexDelete Success "+
 				"for Drop IndexId %v", defnId)
+			//outcome of draining scans is also reported in scan stats
+			if res, ok := res.(*MsgDropIndexResponse); ok {
+				common.Infof("clustMgrAgent::OnIndexDelete Drop IndexId %v "+
+					"Drained %v Scans, Cancelled %v Scans", defnId,
+					res.GetDrainedScans(), res.GetCancelledScans())
+			}
 			return nil
 
 		case MSG_ERROR:
 			common.Debugf("clustMgrAgent::OnIndexDelete Error "+
 				"for Drop IndexId %v. Error %v", defnId, res)
 			err := res.(*MsgError).GetError()
+			countError(err)
 			return err.cause
 
 		default:
@@ -104,6 +104,24 @@ type MutationKeys struct {
 	keys      [][]byte             // list of key-versions for each index
 	oldkeys   [][]byte             // previous key-versions, if available
 	partnkeys [][]byte             // list of partition keys
+	values    [][]byte             // projected fields, if any
+}
+
+//Size is an estimate of the memory held by the mutation, partition
+//keys share their bytes with keys and are not counted
+func (mut *MutationKeys) Size() int64 {
+
+	size := int64(len(mut.docid) + len(mut.commands) + 8*len(mut.uuids))
+	for i := range mut.keys {
+		size += int64(len(mut.keys[i]))
+	}
+	for i := range mut.oldkeys {
+		size += int64(len(mut.oldkeys[i]))
+	}
+	for i := range mut.values {
+		size += int64(len(mut.values[i]))
+	}
+	return size
 }
 
 //MutationSnapshot represents snapshot information of KV
@@ -139,6 +139,12 @@ func NewCompactionManager(supvCmdCh MsgChannel, supvMsgCh MsgChannel,
 func (cm *compactionManager) run() {
 	cd := cm.newCompactionDaemon()
 	cd.Start()
+	pd := cm.newPurgeDaemon()
+	pd.Start()
+	sd := cm.newScrubDaemon()
+	sd.Start()
+	vd := cm.newVerifyDaemon()
+	vd.Start()
 loop:
 	for {
 		select {
@@ -155,6 +161,15 @@ loop:
 					cd.Stop()
 					cd = cm.newCompactionDaemon()
 					cd.Start()
+					pd.Stop()
+					pd = cm.newPurgeDaemon()
+					pd.Start()
+					sd.Stop()
+					sd = cm.newScrubDaemon()
+					sd.Start()
+					vd.Stop()
+					vd = cm.newVerifyDaemon()
+					vd.Start()
 					cm.supvCmdCh <- &MsgSuccess{}
 				}
 			} else {
@@ -164,6 +179,9 @@ loop:
 	}
 
 	cd.Stop()
+	pd.Stop()
+	sd.Stop()
+	vd.Stop()
 }
 
 func (cm *compactionManager) newCompactionDaemon() *compactionDaemon {
@@ -176,3 +194,42 @@ func (cm *compactionManager) newCompactionDaemon() *compactionDaemon {
 	}
 	return cd
 }
+
+// Purge daemon is run along with compaction, so that space of purged
+// entries is reclaimed by subsequent compactions.
+func (cm *compactionManager) newPurgeDaemon() *purgeDaemon {
+	cfg := cm.config.SectionConfig("purge.", true)
+	pd := &purgeDaemon{
+		quitch:  make(chan bool),
+		config:  cfg,
+		started: false,
+		msgch:   cm.supvMsgCh,
+	}
+	return pd
+}
+
+// Scrub daemon is run along with compaction, which rewrites the storage
+// and is the other background consumer of disk bandwidth.
+func (cm *compactionManager) newScrubDaemon() *scrubDaemon {
+	cfg := cm.config.SectionConfig("scrub.", true)
+	sd := &scrubDaemon{
+		quitch:  make(chan bool),
+		config:  cfg,
+		started: false,
+		msgch:   cm.supvMsgCh,
+	}
+	return sd
+}
+
+// Verify daemon is run along with compaction, as it competes with scans
+// and mutations only for a few reads of storage and KV.
+func (cm *compactionManager) newVerifyDaemon() *verifyDaemon {
+	cfg := cm.config.SectionConfig("verify.", true)
+	vd := &verifyDaemon{
+		quitch:  make(chan bool),
+		config:  cfg,
+		started: false,
+		msgch:   cm.supvMsgCh,
+	}
+	return vd
+}
@@ -9,6 +9,12 @@
 
 package indexer
 
+import (
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"sync/atomic"
+)
+
 type errCode int16
 
 const (
@@ -49,6 +55,7 @@ const (
 	ERROR_KV_SENDER_UNKNOWN_STREAM
 	ERROR_KV_SENDER_UNKNOWN_BUCKET
 	ERROR_KVSENDER_STREAM_ALREADY_CLOSED
+	ERROR_KVSENDER_BUCKET_UUID_CHANGED
 
 	//ScanCoordinator
 	ERROR_SCAN_COORD_UNKNOWN_COMMAND
@@ -62,6 +69,7 @@ const (
 	ERROR_INDEXER_UNKNOWN_INDEX
 	ERROR_INDEXER_UNKNOWN_BUCKET
 	ERROR_INDEXER_IN_RECOVERY
+	ERROR_INDEXER_INVALID_STATE
 
 	//STORAGE_MGR
 	ERROR_STORAGE_MGR_ROLLBACK_FAIL
@@ -108,3 +116,83 @@ type Error struct {
 	cause    error
 	msg      string
 }
+
+// errCodeNames are the names error codes are counted by in stats,
+// refer errorStats()
+var errCodeNames = map[errCode]string{
+	ERROR_PANIC:                          "panic",
+	ERROR_SLAB_INIT:                      "slab_init",
+	ERROR_SLAB_BAD_ALLOC_REQUEST:         "slab_bad_alloc_request",
+	ERROR_SLAB_INTERNAL_ALLOC_ERROR:      "slab_internal_alloc_error",
+	ERROR_SLAB_MEM_LIMIT_EXCEED:          "slab_mem_limit_exceed",
+	ERROR_SLAB_INTERNAL_ERROR:            "slab_internal_error",
+	ERROR_STREAM_INIT:                    "stream_init",
+	ERROR_STREAM_READER_UNKNOWN_COMMAND:  "stream_reader_unknown_command",
+	ERROR_STREAM_READER_UNKNOWN_ERROR:    "stream_reader_unknown_error",
+	ERROR_STREAM_READER_PANIC:            "stream_reader_panic",
+	ERROR_STREAM_READER_STREAM_SHUTDOWN:  "stream_reader_stream_shutdown",
+	ERROR_MUT_MGR_INTERNAL_ERROR:         "mut_mgr_internal_error",
+	ERROR_MUT_MGR_STREAM_ALREADY_OPEN:    "mut_mgr_stream_already_open",
+	ERROR_MUT_MGR_STREAM_ALREADY_CLOSED:  "mut_mgr_stream_already_closed",
+	ERROR_MUT_MGR_UNKNOWN_COMMAND:        "mut_mgr_unknown_command",
+	ERROR_MUT_MGR_UNCLEAN_SHUTDOWN:       "mut_mgr_unclean_shutdown",
+	ERROR_MUT_MGR_PANIC:                  "mut_mgr_panic",
+	ERROR_MUTATION_QUEUE_INIT:            "mutation_queue_init",
+	ERROR_TK_UNKNOWN_STREAM:              "tk_unknown_stream",
+	ERROR_KVSENDER_UNKNOWN_INDEX:         "kvsender_unknown_index",
+	ERROR_KVSENDER_STREAM_ALREADY_OPEN:   "kvsender_stream_already_open",
+	ERROR_KVSENDER_STREAM_REQUEST_ERROR:  "kvsender_stream_request_error",
+	ERROR_KV_SENDER_UNKNOWN_STREAM:       "kv_sender_unknown_stream",
+	ERROR_KV_SENDER_UNKNOWN_BUCKET:       "kv_sender_unknown_bucket",
+	ERROR_KVSENDER_STREAM_ALREADY_CLOSED: "kvsender_stream_already_closed",
+	ERROR_KVSENDER_BUCKET_UUID_CHANGED:   "kvsender_bucket_uuid_changed",
+	ERROR_SCAN_COORD_UNKNOWN_COMMAND:     "scan_coord_unknown_command",
+	ERROR_SCAN_COORD_INTERNAL_ERROR:      "scan_coord_internal_error",
+	ERROR_INDEX_ALREADY_EXISTS:           "index_already_exists",
+	ERROR_INDEXER_INTERNAL_ERROR:         "indexer_internal_error",
+	ERROR_INDEX_BUILD_IN_PROGRESS:        "index_build_in_progress",
+	ERROR_INDEX_DROP_IN_PROGRESS:         "index_drop_in_progress",
+	ERROR_INDEXER_UNKNOWN_INDEX:          "indexer_unknown_index",
+	ERROR_INDEXER_UNKNOWN_BUCKET:         "indexer_unknown_bucket",
+	ERROR_INDEXER_IN_RECOVERY:            "indexer_in_recovery",
+	ERROR_INDEXER_INVALID_STATE:          "indexer_invalid_state",
+	ERROR_STORAGE_MGR_ROLLBACK_FAIL:      "storage_mgr_rollback_fail",
+	ERROR_CLUSTER_MGR_AGENT_INIT:         "cluster_mgr_agent_init",
+	ERROR_CLUSTER_MGR_CREATE_FAIL:        "cluster_mgr_create_fail",
+	ERROR_CLUSTER_MGR_DROP_FAIL:          "cluster_mgr_drop_fail",
+	ERROR_INDEX_MANAGER_PANIC:            "index_manager_panic",
+	ERROR_INDEX_MANAGER_CHANNEL_CLOSE:    "index_manager_channel_close",
+	ERROR_SCAN_COORD_QUERYPORT_FAIL:      "scan_coord_queryport_fail",
+}
+
+// errCounts counts errors reported by indexer components, by their code
+var errCounts = func() map[errCode]*uint64 {
+	counts := make(map[errCode]*uint64)
+	for code := range errCodeNames {
+		counts[code] = new(uint64)
+	}
+	return counts
+}()
+
+// countError counts an occurrence of err where it is reported
+func countError(err Error) {
+	if n, ok := errCounts[err.code]; ok {
+		atomic.AddUint64(n, 1)
+	}
+}
+
+// errorStats returns, as num_errors_<name> stats, how many times errors
+// were reported by indexer components, along with the typed errors of
+// secondary indexing. Errors that did not occur are skipped.
+func errorStats() map[string]string {
+	stats := make(map[string]string)
+	for code, n := range errCounts {
+		if v := atomic.LoadUint64(n); v > 0 {
+			stats["num_errors_"+errCodeNames[code]] = fmt.Sprint(v)
+		}
+	}
+	for name, v := range common.ErrorCounts() {
+		stats["num_errors_"+name] = fmt.Sprint(v)
+	}
+	return stats
+}
@@ -0,0 +1,30 @@
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+)
+
+func TestErrorStats(t *testing.T) {
+	for code, name := range errCodeNames {
+		if name == "" || errCounts[code] == nil {
+			t.Fatalf("missing name or counter for error code %v", code)
+		}
+	}
+
+	err := Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR, category: INDEXER}
+	countError(err)
+	countError(err)
+	common.CountError(common.WrapError(common.ErrorScanKilled, "scan", 1))
+
+	stats := errorStats()
+	if stats["num_errors_kvsender_stream_request_error"] != "2" {
+		t.Errorf("unexpected stats %v", stats)
+	}
+	if stats["num_errors_secondary.scanKilled"] == "" {
+		t.Errorf("missing typed error in stats %v", stats)
+	}
+	if _, ok := stats["num_errors_panic"]; ok {
+		t.Errorf("unexpected stat for error that did not occur %v", stats)
+	}
+}
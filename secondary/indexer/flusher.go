@@ -69,11 +69,15 @@ type Flusher interface {
 type flusher struct {
 	indexInstMap  common.IndexInstMap
 	indexPartnMap IndexPartnMap
+
+	bulkBatchSize int //entries buffered per slice for bulk load
 }
 
-//NewFlusher returns new instance of flusher
-func NewFlusher() *flusher {
-	return &flusher{}
+//NewFlusher returns new instance of flusher. During initial build
+//upserts are handed over to slices in batches of bulkBatchSize,
+//if bulkBatchSize is 0 they are handed over once flush is done.
+func NewFlusher(bulkBatchSize int) *flusher {
+	return &flusher{bulkBatchSize: bulkBatchSize}
 }
 
 //PersistUptoTS will flush the mutation queue upto the
@@ -83,7 +87,9 @@ func NewFlusher() *flusher {
 //3. Flushing Backfill Queue
 //
 //Can be stopped anytime by closing StopChannel.
-//Sends SUCCESS on the MsgChannel when its done flushing till timestamp.
+//Sends SUCCESS on the MsgChannel when its done flushing till timestamp,
+//as a MsgTimestamp carrying the timestamp each vbucket got flushed to.
+//Mutations beyond the timestamp are left in the queue.
 //Any error condition is reported back on the MsgChannel.
 //Caller can wait on MsgChannel after closing StopChannel to get notified
 //about shutdown completion.
@@ -171,10 +177,19 @@ func (f *flusher) flushQueue(q MutationQueue, streamId common.StreamId,
 
 	//create stop channel for each worker, to propagate the stop signal
 	workerStopChannels := make([]StopChannel, numVbuckets)
+	for i = 0; i < numVbuckets; i++ {
+		workerStopChannels[i] = make(StopChannel)
+	}
 
 	//create msg channel for workers to provide messages
 	workerMsgCh := make(MsgChannel)
 
+	//seqno each worker got its vbucket flushed to, when flushing upto ts
+	var flushedTs Timestamp
+	if ts != nil {
+		flushedTs = NewTimestamp(int(numVbuckets))
+	}
+
 	for i = 0; i < numVbuckets; i++ {
 		wg.Add(1)
 		if ts == nil {
@@ -182,7 +197,8 @@ func (f *flusher) flushQueue(q MutationQueue, streamId common.StreamId,
 				persist, workerStopChannels[i], workerMsgCh, &wg)
 		} else {
 			go f.flushSingleVbucketUptoSeqno(q, streamId, Vbucket(i),
-				ts[i], persist, workerStopChannels[i], workerMsgCh, &wg)
+				ts[i], &flushedTs[i], persist, workerStopChannels[i],
+				workerMsgCh, &wg)
 		}
 	}
 
@@ -215,6 +231,12 @@ func (f *flusher) flushQueue(q MutationQueue, streamId common.StreamId,
 
 		//handle any message from workers
 	case m, ok := <-workerMsgCh:
+		//stop all workers, those with a pending message
+		//give up on sending it
+		for _, ch := range workerStopChannels {
+			close(ch)
+		}
+		<-allWorkersDoneCh
 		if ok {
 			//TODO identify the messages and handle
 			//For now, just relay back the message
@@ -223,6 +245,10 @@ func (f *flusher) flushQueue(q MutationQueue, streamId common.StreamId,
 		return
 	}
 
+	if persist && ts != nil {
+		msgch <- &MsgTimestamp{mType: MSG_SUCCESS, ts: flushedTs}
+		return
+	}
 	msgch <- &MsgSuccess{}
 }
 
@@ -254,7 +280,8 @@ func (f *flusher) flushSingleVbucket(q MutationQueue, streamId common.StreamId,
 					//No persistence is required. Just skip this mutation.
 					continue
 				}
-				f.flushSingleMutation(mut, streamId)
+				//without a bulk loader, persistence errors are only logged
+				f.flushSingleMutation(mut, streamId, nil)
 			}
 		case <-stopch:
 			qstopch <- true
@@ -265,8 +292,11 @@ func (f *flusher) flushSingleVbucket(q MutationQueue, streamId common.StreamId,
 
 //flushSingleVbucket is the actual implementation which flushes the given queue
 //for a single vbucket till the given seqno or till the stop signal(whichever is earlier)
+//Seqno the vbucket got flushed to is recorded in flushed, which is the given
+//seqno if the queue got drained upto it, else the seqno of the last mutation
+//flushed before stop(0 if none).
 func (f *flusher) flushSingleVbucketUptoSeqno(q MutationQueue, streamId common.StreamId,
-	vbucket Vbucket, seqno Seqno, persist bool, stopch StopChannel,
+	vbucket Vbucket, seqno Seqno, flushed *Seqno, persist bool, stopch StopChannel,
 	workerMsgCh MsgChannel, wg *sync.WaitGroup) {
 
 	defer wg.Done()
@@ -274,45 +304,94 @@ func (f *flusher) flushSingleVbucketUptoSeqno(q MutationQueue, streamId common.S
 	common.Tracef("Flusher::flushSingleVbucketUptoSeqno Started worker to flush vbucket: "+
 		"%v till Seqno: %v for Stream: %v", vbucket, seqno, streamId)
 
-	mutch, err := q.DequeueUptoSeqno(vbucket, seqno)
+	mutch, err := q.DequeueUptoSeqnoOrStop(vbucket, seqno, stopch)
 	if err != nil {
 		//TODO
 	}
 
-	ok := true
-	var mut *MutationKeys
+	//initial build loads entries in sorted runs instead of
+	//inserting one entry at a time
+	var loader *bulkLoader
+	if persist && streamId == common.INIT_STREAM {
+		loader = newBulkLoader(f.bulkBatchSize)
+	}
 
 	//Read till the channel is closed by queue indicating it has sent all the
-	//sequence numbers requested
-	for ok {
-		select {
-		case mut, ok = <-mutch:
-			if ok {
-				if !persist {
-					//No persistence is required. Just skip this mutation.
-					continue
-				}
-				f.flushSingleMutation(mut, streamId)
+	//sequence numbers requested or it got stopped. Mutations received are
+	//flushed even after stop, those not received are left in the queue.
+	//Once persistence fails, the remaining mutations are drained
+	//without persisting them and the error is sent on workerMsgCh.
+	//Mutations buffered in the loader are flushed only once the batches
+	//holding them are written.
+	var flushErr error
+	var received Seqno
+	for mut := range mutch {
+		if persist && flushErr == nil {
+			if flushErr = f.flushSingleMutation(mut, streamId, loader); flushErr != nil {
+				f.sendError(flushErr, workerMsgCh, stopch)
+			}
+		}
+		if flushErr == nil {
+			received = mut.meta.seqno
+			if loader == nil || loader.empty() {
+				*flushed = received
 			}
 		}
 	}
+
+	if loader != nil && flushErr == nil {
+		if flushErr = loader.flushAll(); flushErr != nil {
+			f.sendError(flushErr, workerMsgCh, stopch)
+		} else {
+			*flushed = received
+		}
+	}
+
+	select {
+	case <-stopch:
+		//stopped, the vbucket may not be flushed upto seqno
+	default:
+		if flushErr == nil {
+			*flushed = seqno
+		}
+	}
+}
+
+//sendError reports a persistence error on workerMsgCh, unless
+//the worker gets stopped first.
+func (f *flusher) sendError(err error, workerMsgCh MsgChannel, stopch StopChannel) {
+
+	msg := &MsgError{
+		err: Error{code: ERROR_MUT_MGR_INTERNAL_ERROR,
+			severity: FATAL,
+			category: STORAGE,
+			cause:    err}}
+
+	select {
+	case workerMsgCh <- msg:
+	case <-stopch:
+	}
 }
 
 //flushSingleMutation talks to persistence layer to store the mutations
-//Any error from persistence layer is sent back on workerMsgCh
-func (f *flusher) flushSingleMutation(mut *MutationKeys, streamId common.StreamId) {
+//If loader is not nil, upserts are buffered in it for bulk insert and
+//any error from bulk insert is returned.
+func (f *flusher) flushSingleMutation(mut *MutationKeys, streamId common.StreamId,
+	loader *bulkLoader) error {
 
 	switch streamId {
 
 	case common.MAINT_STREAM, common.INIT_STREAM, common.CATCHUP_STREAM:
-		f.flush(mut, streamId)
+		return f.flush(mut, streamId, loader)
 
 	default:
 		common.Errorf("Flusher::flushSingleMutation Invalid StreamId: %v", streamId)
 	}
+	return nil
 }
 
-func (f *flusher) flush(mut *MutationKeys, streamId common.StreamId) {
+func (f *flusher) flush(mut *MutationKeys, streamId common.StreamId,
+	loader *bulkLoader) error {
 
 	common.Tracef("Flusher::flush Flushing Stream %v Mutations %v", streamId, mut)
 
@@ -348,10 +427,19 @@ func (f *flusher) flush(mut *MutationKeys, streamId common.StreamId) {
 		case common.Upsert:
 			processedUpserts = append(processedUpserts, mut.uuids[i])
 
-			f.processUpsert(mut, i)
+			if err := f.processUpsert(mut, i, loader); err != nil {
+				return err
+			}
 
 		case common.Deletion:
-			f.processDelete(mut, i)
+			if err := f.processDelete(mut, i, loader); err != nil {
+				return err
+			}
+
+		case common.Expiration:
+			if err := f.processExpiration(mut, i, loader); err != nil {
+				return err
+			}
 
 		case common.UpsertDeletion:
 
@@ -366,8 +454,8 @@ func (f *flusher) flush(mut *MutationKeys, streamId common.StreamId) {
 
 			if skipUpsertDeletion {
 				continue
-			} else {
-				f.processDelete(mut, i)
+			} else if err := f.processDelete(mut, i, loader); err != nil {
+				return err
 			}
 
 		default:
@@ -375,9 +463,10 @@ func (f *flusher) flush(mut *MutationKeys, streamId common.StreamId) {
 				mut.keys[i])
 		}
 	}
+	return nil
 }
 
-func (f *flusher) processUpsert(mut *MutationKeys, i int) {
+func (f *flusher) processUpsert(mut *MutationKeys, i int, loader *bulkLoader) error {
 
 	var key Key
 	var value Value
@@ -387,15 +476,20 @@ func (f *flusher) processUpsert(mut *MutationKeys, i int) {
 
 		common.Errorf("Flusher::processUpsert Error Generating Key"+
 			"From Mutation: %v. Skipped. Error: %v", mut.keys[i], err)
-		return
+		return nil
+	}
+
+	var projected []byte
+	if i < len(mut.values) {
+		projected = mut.values[i]
 	}
 
 	if value, err = NewValue(mut.docid, mut.meta.vbucket,
-		mut.meta.seqno); err != nil {
+		mut.meta.seqno, projected); err != nil {
 
 		common.Errorf("Flusher::processUpsert Error Generating Value"+
 			"From Mutation: %v. Skipped. Error: %v", mut.keys[i], err)
-		return
+		return nil
 	}
 
 	idxInst, _ := f.indexInstMap[mut.uuids[i]]
@@ -407,11 +501,14 @@ func (f *flusher) processUpsert(mut *MutationKeys, i int) {
 	if partnInstMap, ok = f.indexPartnMap[mut.uuids[i]]; !ok {
 		common.Errorf("Flusher::processUpsert Missing Partition Instance Map"+
 			"for IndexInstId: %v. Skipped Mutation Key: %v", mut.uuids[i], mut.keys[i])
-		return
+		return nil
 	}
 
 	if partnInst := partnInstMap[partnId]; ok {
 		slice := partnInst.Sc.GetSliceByIndexKey(common.IndexKey(mut.keys[i]))
+		if loader != nil {
+			return loader.add(slice, key, value)
+		}
 		if err := slice.Insert(key, value); err != nil {
 			common.Errorf("Flusher::processUpsert Error Inserting Key: %v "+
 				"Value: %v in Slice: %v. Error: %v", key, value, slice.Id(), err)
@@ -420,10 +517,10 @@ func (f *flusher) processUpsert(mut *MutationKeys, i int) {
 		common.Errorf("Flusher::processUpsert Partition Instance not found "+
 			"for Id: %v Skipped Mutation Key: %v", partnId, mut.keys[i])
 	}
-
+	return nil
 }
 
-func (f *flusher) processDelete(mut *MutationKeys, i int) {
+func (f *flusher) processDelete(mut *MutationKeys, i int, loader *bulkLoader) error {
 
 	idxInst, _ := f.indexInstMap[mut.uuids[i]]
 
@@ -434,11 +531,17 @@ func (f *flusher) processDelete(mut *MutationKeys, i int) {
 	if partnInstMap, ok = f.indexPartnMap[mut.uuids[i]]; !ok {
 		common.Errorf("Flusher:processDelete Missing Partition Instance Map"+
 			"for IndexInstId: %v. Skipped Mutation Key: %v", mut.uuids[i], mut.keys[i])
-		return
+		return nil
 	}
 
 	if partnInst := partnInstMap[partnId]; ok {
 		slice := partnInst.Sc.GetSliceByIndexKey(common.IndexKey(mut.keys[i]))
+		//buffered upserts need to be applied before the delete
+		if loader != nil {
+			if err := loader.flush(slice); err != nil {
+				return err
+			}
+		}
 		if err := slice.Delete(mut.docid); err != nil {
 			common.Errorf("Flusher::processDelete Error Deleting DocId: %v "+
 				"from Slice: %v", mut.docid, slice.Id())
@@ -447,6 +550,107 @@ func (f *flusher) processDelete(mut *MutationKeys, i int) {
 		common.Errorf("Flusher::processDelete Partition Instance not found "+
 			"for Id: %v. Skipped Mutation Key: %v", partnId, mut.keys[i])
 	}
+	return nil
+}
+
+func (f *flusher) processExpiration(mut *MutationKeys, i int, loader *bulkLoader) error {
+
+	idxInst, _ := f.indexInstMap[mut.uuids[i]]
+
+	partnId := idxInst.Pc.GetPartitionIdByPartitionKey(mut.partnkeys[i])
+
+	var partnInstMap PartitionInstMap
+	var ok bool
+	if partnInstMap, ok = f.indexPartnMap[mut.uuids[i]]; !ok {
+		common.Errorf("Flusher:processExpiration Missing Partition Instance Map"+
+			"for IndexInstId: %v. Skipped Mutation Key: %v", mut.uuids[i], mut.keys[i])
+		return nil
+	}
+
+	if partnInst := partnInstMap[partnId]; ok {
+		slice := partnInst.Sc.GetSliceByIndexKey(common.IndexKey(mut.keys[i]))
+		//buffered upserts need to be applied before the expiration
+		if loader != nil {
+			if err := loader.flush(slice); err != nil {
+				return err
+			}
+		}
+		if err := slice.Expire(mut.docid); err != nil {
+			common.Errorf("Flusher::processExpiration Error Expiring DocId: %v "+
+				"from Slice: %v", mut.docid, slice.Id())
+		}
+	} else {
+		common.Errorf("Flusher::processExpiration Partition Instance not found "+
+			"for Id: %v. Skipped Mutation Key: %v", partnId, mut.keys[i])
+	}
+	return nil
+}
+
+//bulkLoader buffers upserts per slice and hands them over to the
+//slice as a batch once batchSize entries accumulate, which the slice
+//then writes as a single sorted run. If batchSize is 0 upserts are
+//buffered till flushAll. It is owned by a single flusher worker and
+//is not thread-safe.
+type bulkLoader struct {
+	batchSize int
+	batches   map[Slice]*bulkBatch
+}
+
+type bulkBatch struct {
+	keys   []Key
+	values []Value
+}
+
+func newBulkLoader(batchSize int) *bulkLoader {
+	return &bulkLoader{
+		batchSize: batchSize,
+		batches:   make(map[Slice]*bulkBatch),
+	}
+}
+
+func (b *bulkLoader) add(slice Slice, key Key, value Value) error {
+	batch, ok := b.batches[slice]
+	if !ok {
+		batch = &bulkBatch{}
+		b.batches[slice] = batch
+	}
+	batch.keys = append(batch.keys, key)
+	batch.values = append(batch.values, value)
+	if b.batchSize > 0 && len(batch.keys) >= b.batchSize {
+		return b.flush(slice)
+	}
+	return nil
+}
+
+func (b *bulkLoader) flush(slice Slice) error {
+	batch, ok := b.batches[slice]
+	if !ok {
+		return nil
+	}
+	delete(b.batches, slice)
+	if err := slice.BulkInsert(batch.keys, batch.values); err != nil {
+		common.Errorf("Flusher::bulkLoader Error Inserting %v Entries "+
+			"in Slice: %v. Error: %v", len(batch.keys), slice.Id(), err)
+		return err
+	}
+	return nil
+}
+
+//empty returns true if no entry is waiting to be handed over.
+func (b *bulkLoader) empty() bool {
+	return len(b.batches) == 0
+}
+
+//flushAll hands over batches of all slices, returning the first
+//error encountered.
+func (b *bulkLoader) flushAll() error {
+	var firstErr error
+	for slice := range b.batches {
+		if err := b.flush(slice); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 //IsTimestampGreaterThanQueueLWT checks if each Vbucket in the Queue has
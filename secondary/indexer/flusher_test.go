@@ -0,0 +1,224 @@
+package indexer
+
+import (
+	"errors"
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+	"time"
+)
+
+func TestFlushQueueUptoTs(t *testing.T) {
+	q := NewAtomicMutationQueue(2, nil)
+	q.Enqueue(&MutationKeys{meta: &MutationMeta{vbucket: 0, seqno: 1}}, 0)
+	q.Enqueue(&MutationKeys{meta: &MutationMeta{vbucket: 0, seqno: 2}}, 0)
+	q.Enqueue(&MutationKeys{meta: &MutationMeta{vbucket: 0, seqno: 3}}, 0)
+
+	f := NewFlusher(0)
+	msgch := make(MsgChannel)
+	go f.flushQueue(q, common.MAINT_STREAM, Timestamp{2, 4}, true,
+		make(StopChannel), msgch)
+
+	flushed := receiveFlushedTs(t, msgch)
+	// vbucket 1 has nothing queued and is already upto ts.
+	if flushed[0] != 2 || flushed[1] != 4 {
+		t.Errorf("expected flushed ts [2 4], got %v", flushed)
+	}
+	// mutation beyond ts is left in the queue.
+	checkSizeA(t, q, 0, 1)
+}
+
+func TestFlushQueueStopped(t *testing.T) {
+	q := NewAtomicMutationQueue(2, nil)
+	q.Enqueue(&MutationKeys{meta: &MutationMeta{vbucket: 0, seqno: 1}}, 0)
+	q.Enqueue(&MutationKeys{meta: &MutationMeta{vbucket: 0, seqno: 2}}, 0)
+
+	f := NewFlusher(0)
+	stopch, msgch := make(StopChannel), make(MsgChannel)
+	// vbucket 0 waits for seqno 5, which never gets queued.
+	go f.flushQueue(q, common.MAINT_STREAM, Timestamp{5, 3}, true, stopch, msgch)
+
+	time.Sleep(100 * time.Millisecond)
+	close(stopch)
+
+	flushed := receiveFlushedTs(t, msgch)
+	if flushed[0] != 2 || flushed[1] != 3 {
+		t.Errorf("expected flushed ts [2 3], got %v", flushed)
+	}
+	checkSizeA(t, q, 0, 0)
+
+	ts := common.NewTsVbuuid("default", 2)
+	ts.Seqnos[0], ts.Seqnos[1] = 5, 3
+	ts.Vbuuids[0], ts.Vbuuids[1] = 100, 200
+	flushedTs := getFlushedTsVbuuid(ts, flushed)
+	if flushedTs.Seqnos[0] != 2 || flushedTs.Seqnos[1] != 3 {
+		t.Errorf("expected flushed seqnos [2 3], got %v", flushedTs.Seqnos)
+	} else if flushedTs.Vbuuids[0] != 100 || flushedTs.Vbuuids[1] != 200 {
+		t.Errorf("expected vbuuids of ts, got %v", flushedTs.Vbuuids)
+	} else if ts.Seqnos[0] != 5 {
+		t.Errorf("expected ts to be left as is, got %v", ts.Seqnos)
+	}
+}
+
+// bulkRecorder records the size of batches handed over for bulk insert.
+type bulkRecorder struct {
+	*mockSlice
+	batches []int
+}
+
+func (s *bulkRecorder) BulkInsert(keys []Key, values []Value) error {
+	s.batches = append(s.batches, len(keys))
+	return s.err
+}
+
+func TestBulkLoader(t *testing.T) {
+	key, err := NewKey([]byte(`["city"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := NewValue([]byte("doc"), 0, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slice1 := &bulkRecorder{mockSlice: &mockSlice{id: 1}}
+	slice2 := &bulkRecorder{mockSlice: &mockSlice{id: 2}}
+	loader := newBulkLoader(2)
+	for i := 0; i < 3; i++ {
+		if err := loader.add(slice1, key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := loader.add(slice2, key, value); err != nil {
+		t.Fatal(err)
+	}
+	// batches are handed over once they reach batch size.
+	if len(slice1.batches) != 1 || slice1.batches[0] != 2 || len(slice2.batches) != 0 {
+		t.Fatalf("unexpected batches %v %v", slice1.batches, slice2.batches)
+	}
+	if err := loader.flushAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(slice1.batches) != 2 || slice1.batches[1] != 1 ||
+		len(slice2.batches) != 1 || slice2.batches[0] != 1 {
+		t.Fatalf("unexpected batches %v %v", slice1.batches, slice2.batches)
+	}
+
+	// without batch size, entries are buffered till flushAll.
+	slice1.batches = nil
+	loader = newBulkLoader(0)
+	for i := 0; i < 3; i++ {
+		loader.add(slice1, key, value)
+	}
+	if len(slice1.batches) != 0 {
+		t.Fatalf("unexpected batches %v", slice1.batches)
+	}
+	loader.flushAll()
+	if len(slice1.batches) != 1 || slice1.batches[0] != 3 {
+		t.Fatalf("unexpected batches %v", slice1.batches)
+	}
+
+	// errors from slice are returned.
+	slice1.err = errors.New("fdb error")
+	loader = newBulkLoader(2)
+	if err := loader.add(slice1, key, value); err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.add(slice1, key, value); err != slice1.err {
+		t.Fatalf("expected %v, got %v", slice1.err, err)
+	}
+	loader.add(slice1, key, value)
+	loader.add(slice2, key, value)
+	if err := loader.flushAll(); err != slice1.err {
+		t.Fatalf("expected %v, got %v", slice1.err, err)
+	} else if len(slice2.batches) != 2 {
+		t.Fatalf("expected other slices to be flushed, got %v", slice2.batches)
+	}
+}
+
+func TestFlushQueueStoppedBulkError(t *testing.T) {
+	key := []byte(`["city"]`)
+	slice := &bulkRecorder{mockSlice: &mockSlice{id: 1}}
+	sc := NewHashedSliceContainer()
+	sc.AddSlice(SliceId(0), slice)
+	pc := common.NewKeyPartitionContainer()
+	pc.AddPartition(common.PartitionId(0), common.KeyPartitionDefn{Id: 0})
+
+	f := NewFlusher(0)
+	f.indexInstMap = common.IndexInstMap{
+		1: common.IndexInst{InstId: 1, Stream: common.INIT_STREAM, Pc: pc},
+	}
+	f.indexPartnMap = IndexPartnMap{1: PartitionInstMap{0: PartitionInst{Sc: sc}}}
+
+	for _, err := range []error{nil, errors.New("fdb error")} {
+		slice.err = err
+		q := NewAtomicMutationQueue(2, nil)
+		q.Enqueue(&MutationKeys{
+			meta:      &MutationMeta{bucket: "default", vbucket: 0, seqno: 1},
+			docid:     []byte("doc1"),
+			uuids:     []common.IndexInstId{1},
+			keys:      [][]byte{key},
+			oldkeys:   [][]byte{nil},
+			partnkeys: [][]byte{key},
+			commands:  []byte{common.Upsert},
+		}, 0)
+
+		stopch, msgch := make(StopChannel), make(MsgChannel)
+		// vbucket 0 waits for seqno 5, upsert is buffered till stop.
+		go f.flushQueue(q, common.INIT_STREAM, Timestamp{5, 3}, true, stopch, msgch)
+		time.Sleep(100 * time.Millisecond)
+		close(stopch)
+
+		// mutation is flushed only if its batch got written.
+		ref := Seqno(1)
+		if err != nil {
+			ref = 0
+		}
+		if flushed := receiveFlushedTs(t, msgch); flushed[0] != ref {
+			t.Errorf("bulk insert error %v: expected vbucket flushed to %v, got %v",
+				err, ref, flushed)
+		}
+	}
+}
+
+func TestFlusherSendError(t *testing.T) {
+	f := NewFlusher(0)
+	workerMsgCh, stopch := make(MsgChannel), make(StopChannel)
+	cause := errors.New("fdb error")
+	go f.sendError(cause, workerMsgCh, stopch)
+	select {
+	case msg := <-workerMsgCh:
+		errMsg, ok := msg.(*MsgError)
+		if !ok || errMsg.GetError().cause != cause {
+			t.Fatalf("expected error %v, got %v", cause, msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("error was not sent")
+	}
+
+	// a stopped worker gives up on sending.
+	close(stopch)
+	donech := make(chan bool)
+	go func() {
+		f.sendError(cause, workerMsgCh, stopch)
+		close(donech)
+	}()
+	select {
+	case <-donech:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("sendError blocked after stop")
+	}
+}
+
+func receiveFlushedTs(t *testing.T, msgch MsgChannel) Timestamp {
+	select {
+	case msg := <-msgch:
+		tsMsg, ok := msg.(*MsgTimestamp)
+		if !ok || tsMsg.GetMsgType() != MSG_SUCCESS {
+			t.Fatalf("expected flushed timestamp, got %v", msg)
+		}
+		return tsMsg.GetTimestamp()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("flush did not complete")
+	}
+	return nil
+}
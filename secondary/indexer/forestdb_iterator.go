@@ -13,6 +13,7 @@ import (
 	"errors"
 	"github.com/couchbaselabs/goforestdb"
 	"sync/atomic"
+	"time"
 )
 
 //ForestDBIterator taken from
@@ -72,6 +73,9 @@ func (f *ForestDBIterator) Seek(key []byte) {
 		f.iter.Close()
 		f.iter = nil
 	}
+	start := time.Now()
+	defer f.slice.recordRead(start)
+
 	var err error
 	f.iter, err = f.db.IteratorInit(key, nil, forestdb.ITR_NONE|forestdb.ITR_NO_DELETES)
 	if err != nil {
@@ -83,6 +87,9 @@ func (f *ForestDBIterator) Seek(key []byte) {
 }
 
 func (f *ForestDBIterator) Next() {
+	start := time.Now()
+	defer f.slice.recordRead(start)
+
 	var err error
 	err = f.iter.Next()
 	if err != nil {
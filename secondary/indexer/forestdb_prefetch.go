@@ -0,0 +1,161 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+// storageIterator iterates over key, value entries of a slice in sorted
+// order, implemented by ForestDBIterator and prefetchIterator.
+type storageIterator interface {
+	SeekFirst()
+	Seek(key []byte)
+	Valid() bool
+	Next()
+	Key() []byte
+	Value() []byte
+	Close() error
+}
+
+// prefetchEntry is a copy of an entry read ahead from storage, storage
+// iterators reuse the memory of an entry once moved past it.
+type prefetchEntry struct {
+	key, value []byte
+}
+
+// prefetchIterator reads entries of a storage iterator ahead of its
+// consumer. After a seek, a reader goroutine keeps up to `depth` batches
+// of `batchSize` entries buffered, so that reading the next blocks from
+// disk overlaps with encoding and sending of entries already read.
+type prefetchIterator struct {
+	it        storageIterator
+	depth     int
+	batchSize int
+
+	batchch chan []prefetchEntry // batches read ahead of consumer
+	stopch  chan bool            // stop reader
+	donech  chan bool            // reader has returned
+
+	batch []prefetchEntry // current batch
+	pos   int             // current entry in batch
+}
+
+func newPrefetchIterator(it storageIterator,
+	depth, batchSize int) *prefetchIterator {
+
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	return &prefetchIterator{it: it, depth: depth, batchSize: batchSize}
+}
+
+// newFDBScanIterator returns an iterator over the main index of
+// snapshot, reading ahead if configured for the slice.
+func newFDBScanIterator(s Snapshot) (storageIterator, error) {
+	it, err := newFDBSnapshotIterator(s)
+	if err != nil {
+		return it, err
+	}
+	slice := s.(*fdbSnapshot).slice.(*fdbSlice)
+	if slice.readAhead <= 0 {
+		return it, nil
+	}
+	return newPrefetchIterator(it, slice.readAhead, slice.readAheadBatch), nil
+}
+
+func (p *prefetchIterator) SeekFirst() {
+	p.stop()
+	p.it.SeekFirst()
+	p.start()
+}
+
+func (p *prefetchIterator) Seek(key []byte) {
+	p.stop()
+	p.it.Seek(key)
+	p.start()
+}
+
+func (p *prefetchIterator) Valid() bool {
+	return p.pos < len(p.batch)
+}
+
+func (p *prefetchIterator) Next() {
+	if !p.Valid() {
+		return
+	}
+	p.pos++
+	if p.pos < len(p.batch) {
+		return
+	}
+	p.batch, p.pos = <-p.batchch, 0
+}
+
+func (p *prefetchIterator) Key() []byte {
+	if p.Valid() {
+		return p.batch[p.pos].key
+	}
+	return nil
+}
+
+func (p *prefetchIterator) Value() []byte {
+	if p.Valid() {
+		return p.batch[p.pos].value
+	}
+	return nil
+}
+
+func (p *prefetchIterator) Close() error {
+	p.stop()
+	return p.it.Close()
+}
+
+// start the reader from current position of the storage iterator and
+// wait for the first batch.
+func (p *prefetchIterator) start() {
+	p.batchch = make(chan []prefetchEntry, p.depth)
+	p.stopch = make(chan bool)
+	p.donech = make(chan bool)
+	go p.reader(p.it, p.batchch, p.stopch, p.donech)
+
+	p.batch, p.pos = <-p.batchch, 0
+}
+
+// stop the reader, if running, and discard entries read ahead.
+func (p *prefetchIterator) stop() {
+	if p.stopch == nil {
+		return
+	}
+	close(p.stopch)
+	<-p.donech
+	p.batchch, p.stopch, p.donech = nil, nil, nil
+	p.batch, p.pos = nil, 0
+}
+
+// reader sends batches read from storage, the channel is closed after
+// the last entry, or on stop, and consumer sees it as end of iteration.
+func (p *prefetchIterator) reader(it storageIterator,
+	batchch chan []prefetchEntry, stopch, donech chan bool) {
+
+	defer close(donech)
+	defer close(batchch)
+
+	for it.Valid() {
+		batch := make([]prefetchEntry, 0, p.batchSize)
+		for ; it.Valid() && len(batch) < p.batchSize; it.Next() {
+			batch = append(batch, prefetchEntry{
+				key:   append([]byte(nil), it.Key()...),
+				value: append([]byte(nil), it.Value()...),
+			})
+		}
+
+		select {
+		case batchch <- batch:
+		case <-stopch:
+			return
+		}
+	}
+}
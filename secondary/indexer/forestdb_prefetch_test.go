@@ -0,0 +1,145 @@
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+// memIterator is a storageIterator over sorted in-memory entries, delay
+// simulates disk latency of reading a block of `block` entries.
+type memIterator struct {
+	keys   [][]byte
+	pos    int
+	block  int
+	delay  time.Duration
+	closed bool
+}
+
+func newMemIterator(n, block int, delay time.Duration) *memIterator {
+	it := &memIterator{block: block, delay: delay}
+	for i := 0; i < n; i++ {
+		it.keys = append(it.keys, []byte(fmt.Sprintf("key%08d", i)))
+	}
+	return it
+}
+
+func (it *memIterator) SeekFirst() {
+	it.pos = 0
+}
+
+func (it *memIterator) Seek(key []byte) {
+	it.pos = sort.Search(len(it.keys), func(i int) bool {
+		return bytes.Compare(it.keys[i], key) >= 0
+	})
+}
+
+func (it *memIterator) Valid() bool {
+	return it.pos < len(it.keys)
+}
+
+func (it *memIterator) Next() {
+	it.pos++
+	if it.delay > 0 && it.pos%it.block == 0 {
+		time.Sleep(it.delay)
+	}
+}
+
+func (it *memIterator) Key() []byte {
+	if it.Valid() {
+		return it.keys[it.pos]
+	}
+	return nil
+}
+
+func (it *memIterator) Value() []byte {
+	return it.Key()
+}
+
+func (it *memIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+func TestPrefetchIterator(t *testing.T) {
+	mit := newMemIterator(1000, 1, 0)
+	it := newPrefetchIterator(mit, 2, 64)
+
+	count := 0
+	for it.SeekFirst(); it.Valid(); it.Next() {
+		if expected := mit.keys[count]; !bytes.Equal(it.Key(), expected) {
+			t.Fatalf("expected %s, got %s", expected, it.Key())
+		}
+		count++
+	}
+	if count != len(mit.keys) {
+		t.Errorf("expected %v entries, got %v", len(mit.keys), count)
+	}
+	if it.Key() != nil || it.Value() != nil {
+		t.Errorf("expected no entry past the end")
+	}
+
+	// seek midway, abandoning entries read ahead
+	it.Seek([]byte("key00000500"))
+	if !it.Valid() || string(it.Key()) != "key00000500" {
+		t.Fatalf("expected key00000500, got %s", it.Key())
+	}
+	it.Next()
+	it.Seek([]byte("key00000998"))
+	count = 0
+	for ; it.Valid(); it.Next() {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("expected 2 entries after seek, got %v", count)
+	}
+
+	// close while reader is blocked on a full channel
+	it.SeekFirst()
+	if err := it.Close(); err != nil || !mit.closed {
+		t.Errorf("expected storage iterator to be closed (%v)", err)
+	}
+}
+
+func TestPrefetchIteratorEmpty(t *testing.T) {
+	it := newPrefetchIterator(newMemIterator(0, 1, 0), 4, 16)
+	if it.SeekFirst(); it.Valid() {
+		t.Errorf("expected empty iteration")
+	}
+	it.Close()
+}
+
+// benchmarkRangeScan reads 10000 entries, storage pauses for every block
+// of 100 entries and consumer pauses alike for every 100 entries sent.
+func benchmarkRangeScan(b *testing.B, depth int) {
+	const n, block = 10000, 100
+	const latency = 200 * time.Microsecond
+
+	for i := 0; i < b.N; i++ {
+		var it storageIterator = newMemIterator(n, block, latency)
+		if depth > 0 {
+			it = newPrefetchIterator(it, depth, block)
+		}
+		count := 0
+		for it.SeekFirst(); it.Valid(); it.Next() {
+			if count++; count%block == 0 {
+				time.Sleep(latency)
+			}
+		}
+		it.Close()
+	}
+}
+
+func BenchmarkRangeScan(b *testing.B) {
+	benchmarkRangeScan(b, 0)
+}
+
+func BenchmarkRangeScanReadAhead1(b *testing.B) {
+	benchmarkRangeScan(b, 1)
+}
+
+func BenchmarkRangeScanReadAhead4(b *testing.B) {
+	benchmarkRangeScan(b, 4)
+}
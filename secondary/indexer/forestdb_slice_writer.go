@@ -10,6 +10,7 @@
 package indexer
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -47,8 +48,7 @@ func NewForestDBSlice(path string, sliceId SliceId, idxDefnId common.IndexDefnId
 	config := forestdb.DefaultConfig()
 	config.SetDurabilityOpt(forestdb.DRB_ASYNC)
 
-	memQuota := sysconf["settings.memory_quota"].Uint64()
-	config.SetBufferCacheSize(memQuota)
+	config.SetBufferCacheSize(storageCacheSize(sysconf))
 
 	kvconfig := forestdb.DefaultKVStoreConfig()
 
@@ -57,6 +57,20 @@ func NewForestDBSlice(path string, sliceId SliceId, idxDefnId common.IndexDefnId
 	}
 
 	slice.numWriters = sysconf["numSliceWriters"].Int()
+	slice.bulkBatchSize = sysconf["bulkLoad.batchSize"].Int()
+	slice.bulkThrottle = time.Duration(sysconf["bulkLoad.throttleInterval"].Int()) *
+		time.Millisecond
+	//entries of expired documents are left to purge only if purge runs,
+	//otherwise they would never be reclaimed.
+	slice.delayExpiry = sysconf["expiration.delayPurge"].Bool()
+	if slice.delayExpiry && sysconf["purge.interval"].Int() <= 0 {
+		common.Warnf("ForestDBSlice::NewForestDBSlice expiration.delayPurge "+
+			"ignored for %v, purge is disabled", path)
+		slice.delayExpiry = false
+	}
+	slice.hist = newKeyHistogram(sysconf["histogram.numBins"].Int())
+	slice.readAhead = sysconf["scanReadAhead.depth"].Int()
+	slice.readAheadBatch = sysconf["scanReadAhead.batchSize"].Int()
 	slice.main = make([]*forestdb.KVStore, slice.numWriters)
 	for i := 0; i < slice.numWriters; i++ {
 		if slice.main[i], err = slice.dbfile.OpenKVStore("main", kvconfig); err != nil {
@@ -94,6 +108,12 @@ func NewForestDBSlice(path string, sliceId SliceId, idxDefnId common.IndexDefnId
 		go slice.handleCommandsWorker(i)
 	}
 
+	if sysconf["wal.enable"].Bool() {
+		if err = slice.openWal(); err != nil {
+			return nil, err
+		}
+	}
+
 	common.Debugf("ForestDBSlice:NewForestDBSlice \n\t Created New Slice Id %v IndexInstId %v "+
 		"WriterThreads %v", sliceId, idxInstId, slice.numWriters)
 
@@ -106,6 +126,23 @@ type kv struct {
 	v Value
 }
 
+//kvRun is a batch of key/value pairs, sorted by encoded key,
+//which is written by a single worker in one go
+type kvRun []kv
+
+func (r kvRun) Len() int      { return len(r) }
+func (r kvRun) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r kvRun) Less(i, j int) bool {
+	return bytes.Compare(r[i].k.Encoded(), r[j].k.Encoded()) < 0
+}
+
+//purge is a delete of a document's entry, applied only if the entry
+//was last updated by mutation seqno
+type purge struct {
+	docid []byte
+	seqno Seqno
+}
+
 //fdbSlice represents a forestdb slice
 type fdbSlice struct {
 	path     string
@@ -137,8 +174,13 @@ type fdbSlice struct {
 
 	fatalDbErr error //store any fatal DB error
 
+	wal *sliceWal //log of entries since last persisted snapshot, if enabled
+
 	numWriters int //number of writer threads
 
+	bulkBatchSize int           //max entries in a sorted run for bulk load
+	bulkThrottle  time.Duration //pause between sorted runs for bulk load
+
 	//TODO: Remove this once these stats are
 	//captured by the stats library
 	totalFlushTime  time.Duration
@@ -146,6 +188,15 @@ type fdbSlice struct {
 
 	// Statistics
 	get_bytes, insert_bytes, delete_bytes int64
+	num_reads, read_time                  int64
+	purged_items, num_expirations         int64
+
+	delayExpiry bool //leave entries of expired documents to purge
+
+	hist *keyHistogram //approximate distribution of keys, nil if disabled
+
+	readAhead      int //batches of entries scans read ahead, 0 disables
+	readAheadBatch int //entries in a batch read ahead
 }
 
 func (fdb *fdbSlice) IncrRef() {
@@ -176,22 +227,128 @@ func (fdb *fdbSlice) DecrRef() {
 //it will be returned as error.
 func (fdb *fdbSlice) Insert(k Key, v Value) error {
 
+	if fdb.wal != nil {
+		if err := fdb.wal.AppendInsert(k.Encoded(), v.Encoded()); err != nil {
+			fdb.walError(err)
+			return err
+		}
+	}
+
 	fdb.cmdCh <- kv{k: k, v: v}
 	return fdb.fatalDbErr
 
 }
 
+//BulkInsert will insert the given key/value pairs into slice
+//as sorted runs of configured batch size. If a docid appears more
+//than once, only its last entry is applied. Runs are queued behind
+//any outstanding writes, and consecutive runs are throttled by the
+//configured interval. If forestdb has encountered any fatal error
+//condition, it will be returned as error.
+func (fdb *fdbSlice) BulkInsert(keys []Key, values []Value) error {
+
+	if len(keys) != len(values) {
+		return errors.New("ForestDBSlice::BulkInsert mismatch in number of " +
+			"keys and values")
+	}
+
+	//keep only the latest entry for each docid, so that sorting
+	//by key doesn't reorder updates to the same document
+	latest := make(map[string]int, len(values))
+	for i := range values {
+		latest[string(values[i].Docid())] = i
+	}
+
+	entries := make(kvRun, 0, len(latest))
+	for i := range keys {
+		if latest[string(values[i].Docid())] == i {
+			entries = append(entries, kv{k: keys[i], v: values[i]})
+		}
+	}
+	sort.Sort(entries)
+
+	if fdb.wal != nil {
+		for _, e := range entries {
+			if err := fdb.wal.AppendInsert(e.k.Encoded(), e.v.Encoded()); err != nil {
+				fdb.walError(err)
+				return err
+			}
+		}
+	}
+
+	batchSize := fdb.bulkBatchSize
+	if batchSize <= 0 {
+		batchSize = len(entries)
+	}
+
+	for start := 0; start < len(entries); start += batchSize {
+		end := start + batchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		if start > 0 && fdb.bulkThrottle > 0 {
+			time.Sleep(fdb.bulkThrottle)
+		}
+		fdb.cmdCh <- entries[start:end]
+		if fdb.fatalDbErr != nil {
+			break
+		}
+	}
+
+	common.Debugf("ForestDBSlice::BulkInsert \n\tSliceId %v IndexInstId %v Queued "+
+		"%v Entries (%v Received)", fdb.id, fdb.idxInstId, len(entries), len(keys))
+
+	return fdb.fatalDbErr
+}
+
 //Delete will delete the given document from slice.
 //Internally the request is buffered and executed async.
 //If forestdb has encountered any fatal error condition,
 //it will be returned as error.
 func (fdb *fdbSlice) Delete(docid []byte) error {
 
+	if fdb.wal != nil {
+		if err := fdb.wal.AppendDelete(docid); err != nil {
+			fdb.walError(err)
+			return err
+		}
+	}
+
 	fdb.cmdCh <- docid
 	return fdb.fatalDbErr
 
 }
 
+//Expire will delete the given document, expired in KV, from slice.
+//Expirations are counted apart from deletes, and if delayed purge of
+//expired documents is configured, their entries are left in the slice
+//to be reclaimed by the background purge once KV purges their tombstones.
+//If forestdb has encountered any fatal error condition, it will be
+//returned as error.
+func (fdb *fdbSlice) Expire(docid []byte) error {
+
+	atomic.AddInt64(&fdb.num_expirations, 1)
+	if fdb.delayExpiry {
+		return fdb.fatalDbErr
+	}
+	return fdb.Delete(docid)
+
+}
+
+//Purge will delete the entry of given document from slice, if it was
+//last updated by mutation seqno, so that a document updated after it
+//was found missing in KV is not purged. Internally the request is
+//buffered and executed async. Purges are not logged to the WAL, an
+//entry purged before a crash is purged again by the next purge pass.
+//If forestdb has encountered any fatal error condition, it will be
+//returned as error.
+func (fdb *fdbSlice) Purge(docid []byte, seqno Seqno) error {
+
+	fdb.cmdCh <- purge{docid: docid, seqno: seqno}
+	return fdb.fatalDbErr
+
+}
+
 //handleCommands keep listening to any buffered
 //write requests for the slice and processes
 //those. This will shut itself down internal
@@ -209,12 +366,26 @@ loop:
 				fdb.insert(cmd.k, cmd.v, workerId)
 				elapsed := time.Since(start)
 				fdb.totalFlushTime += elapsed
+			case kvRun:
+				cmd := c.(kvRun)
+				start := time.Now()
+				for _, e := range cmd {
+					fdb.insert(e.k, e.v, workerId)
+				}
+				elapsed := time.Since(start)
+				fdb.totalFlushTime += elapsed
 			case []byte:
 				cmd := c.([]byte)
 				start := time.Now()
 				fdb.delete(cmd, workerId)
 				elapsed := time.Since(start)
 				fdb.totalFlushTime += elapsed
+			case purge:
+				cmd := c.(purge)
+				start := time.Now()
+				fdb.purge(cmd.docid, cmd.seqno, workerId)
+				elapsed := time.Since(start)
+				fdb.totalFlushTime += elapsed
 			default:
 				common.Errorf("ForestDBSlice::handleCommandsWorker \n\tSliceId %v IndexInstId %v Received "+
 					"Unknown Command %v", fdb.id, fdb.idxInstId, c)
@@ -262,6 +433,7 @@ func (fdb *fdbSlice) insert(k Key, v Value, workerId int) {
 			return
 		}
 		atomic.AddInt64(&fdb.delete_bytes, int64(len(oldkey.Encoded())))
+		fdb.hist.remove(oldkey.Encoded())
 
 		//delete from back index
 		if err = fdb.back[workerId].DeleteKV(v.Docid()); err != nil {
@@ -297,6 +469,7 @@ func (fdb *fdbSlice) insert(k Key, v Value, workerId int) {
 		return
 	}
 	atomic.AddInt64(&fdb.insert_bytes, int64(len(k.Encoded())+len(v.Encoded())))
+	fdb.hist.add(k.Encoded())
 }
 
 //delete does the actual delete in forestdb
@@ -332,6 +505,7 @@ func (fdb *fdbSlice) delete(docid []byte, workerId int) {
 		return
 	}
 	atomic.AddInt64(&fdb.delete_bytes, int64(len(oldkey.Encoded())))
+	fdb.hist.remove(oldkey.Encoded())
 
 	//delete from the back index
 	if err = fdb.back[workerId].DeleteKV(docid); err != nil {
@@ -344,6 +518,42 @@ func (fdb *fdbSlice) delete(docid []byte, workerId int) {
 
 }
 
+//purge does the actual conditional delete in forestdb
+func (fdb *fdbSlice) purge(docid []byte, seqno Seqno, workerId int) {
+
+	common.Tracef("ForestDBSlice::purge \n\tSliceId %v IndexInstId %v. Purge Key - %s "+
+		"Seqno - %v", fdb.id, fdb.idxInstId, docid, seqno)
+
+	oldkey, err := fdb.getBackIndexEntry(docid, workerId)
+	if err != nil {
+		fdb.checkFatalDbError(err)
+		common.Errorf("ForestDBSlice::purge \n\tSliceId %v IndexInstId %v. Error locating "+
+			"backindex entry for Doc %s. Error %v", fdb.id, fdb.idxInstId, docid, err)
+		return
+	} else if oldkey.Encoded() == nil {
+		//entry is already deleted
+		return
+	}
+
+	data, err := fdb.main[workerId].GetKV(oldkey.Encoded())
+	if err != nil {
+		if err != forestdb.RESULT_KEY_NOT_FOUND {
+			fdb.checkFatalDbError(err)
+			common.Errorf("ForestDBSlice::purge \n\tSliceId %v IndexInstId %v. Error locating "+
+				"main index entry for Doc %s. Error %v", fdb.id, fdb.idxInstId, docid, err)
+		}
+		return
+	}
+
+	//skip documents updated after they were found missing in KV
+	if val, err := NewValueFromEncodedBytes(data); err != nil || val.Raw().Seqno != seqno {
+		return
+	}
+
+	fdb.delete(docid, workerId)
+	atomic.AddInt64(&fdb.purged_items, 1)
+}
+
 //getBackIndexEntry returns an existing back index entry
 //given the docid
 func (fdb *fdbSlice) getBackIndexEntry(docid []byte, workerId int) (Key, error) {
@@ -355,7 +565,9 @@ func (fdb *fdbSlice) getBackIndexEntry(docid []byte, workerId int) (Key, error)
 	var kbyte []byte
 	var err error
 
+	start := time.Now()
 	kbyte, err = fdb.back[workerId].GetKV([]byte(docid))
+	fdb.recordRead(start)
 	atomic.AddInt64(&fdb.get_bytes, int64(len(kbyte)))
 
 	//forestdb reports get in a non-existent key as an
@@ -422,6 +634,9 @@ func (fdb *fdbSlice) Rollback(info SnapshotInfo) error {
 	sic := NewSnapshotInfoContainer(infos)
 	sic.RemoveRecentThanTS(info.Timestamp())
 
+	//histogram counts entries beyond the snapshot, rebuilt on next read
+	fdb.hist.reset()
+
 	//call forestdb to rollback
 	err = fdb.main[0].Rollback(mainSeqNum)
 	if err != nil {
@@ -436,7 +651,12 @@ func (fdb *fdbSlice) Rollback(info SnapshotInfo) error {
 		return err
 	}
 
-	return fdb.dbfile.Commit(forestdb.COMMIT_MANUAL_WAL_FLUSH)
+	err = fdb.dbfile.Commit(forestdb.COMMIT_MANUAL_WAL_FLUSH)
+	if err != nil {
+		return err
+	}
+
+	return fdb.truncateWal()
 }
 
 //RollbackToZero rollbacks the slice to initial state. Return error if
@@ -445,6 +665,12 @@ func (fdb *fdbSlice) RollbackToZero() error {
 	//get the seqnum from snapshot
 	mainSeqNum := forestdb.SeqNum(0)
 
+	//entries logged so far are not valid anymore
+	if err := fdb.truncateWal(); err != nil {
+		return err
+	}
+	fdb.hist.reset()
+
 	//HACK: This doesn't work till MB-13239 gets fixed
 	common.Errorf("ForestDBSlice::RollbackToZero MB-13239 Needs to be Fixed")
 	return nil
@@ -523,6 +749,18 @@ func (fdb *fdbSlice) NewSnapshot(ts *common.TsVbuuid, commit bool) (SnapshotInfo
 				"Index Commit %v", fdb.id, fdb.idxInstId, err)
 			return nil, err
 		}
+
+		//logged entries are persisted now
+		if err = fdb.truncateWal(); err != nil {
+			return nil, err
+		}
+
+	} else if fdb.wal != nil {
+		//mark entries logged so far as flushed upto ts
+		if err = fdb.wal.Snapshot(ts); err != nil {
+			fdb.walError(err)
+			return nil, err
+		}
 	}
 
 	return newSnapshotInfo, nil
@@ -645,9 +883,28 @@ func (fdb *fdbSlice) Compact() error {
 	}
 
 	fdb.currfile = newpath
+	if err == nil {
+		fdb.rebuildHistogram()
+	}
 	return err
 }
 
+//rebuildHistogram recomputes bins of the key histogram from a full pass
+//over the main index, refer keyHistogram.
+func (fdb *fdbSlice) rebuildHistogram() {
+	if fdb.hist == nil {
+		return
+	}
+	it, err := newForestDBIterator(fdb, fdb.main[0], FORESTDB_INMEMSEQ)
+	if err != nil {
+		common.Errorf("ForestDBSlice::rebuildHistogram \n\tSliceId %v IndexInstId %v "+
+			"Error %v", fdb.id, fdb.idxInstId, err)
+		return
+	}
+	defer closeIterator(it)
+	fdb.hist.rebuild(it, nil)
+}
+
 func (fdb *fdbSlice) Statistics() (StorageStatistics, error) {
 	var sts StorageStatistics
 	f, err := os.Open(fdb.currfile)
@@ -664,10 +921,23 @@ func (fdb *fdbSlice) Statistics() (StorageStatistics, error) {
 	sts.GetBytes = atomic.LoadInt64(&fdb.get_bytes)
 	sts.InsertBytes = atomic.LoadInt64(&fdb.insert_bytes)
 	sts.DeleteBytes = atomic.LoadInt64(&fdb.delete_bytes)
+	sts.NumReads = atomic.LoadInt64(&fdb.num_reads)
+	sts.ReadTime = atomic.LoadInt64(&fdb.read_time)
+	if fdb.wal != nil {
+		sts.WalSize = fdb.wal.Size()
+	}
+	sts.PurgedItems = atomic.LoadInt64(&fdb.purged_items)
+	sts.Expirations = atomic.LoadInt64(&fdb.num_expirations)
 
 	return sts, nil
 }
 
+//recordRead accounts a read from forestdb that started at given time.
+func (fdb *fdbSlice) recordRead(start time.Time) {
+	atomic.AddInt64(&fdb.num_reads, 1)
+	atomic.AddInt64(&fdb.read_time, int64(time.Since(start)))
+}
+
 func (fdb *fdbSlice) String() string {
 
 	str := fmt.Sprintf("SliceId: %v ", fdb.id)
@@ -731,6 +1001,10 @@ func tryDeleteFdbSlice(fdb *fdbSlice) {
 	common.Infof("ForestDBSlice::Destroy \n\tDestroying Slice Id %v, IndexInstId %v, "+
 		"IndexDefnId %v", fdb.id, fdb.idxInstId, fdb.idxDefnId)
 
+	if fdb.wal != nil {
+		fdb.wal.Destroy()
+	}
+
 	if err := forestdb.Destroy(fdb.currfile, fdb.config); err != nil {
 		common.Errorf("ForestDBSlice::Destroy \n\t Error Destroying  Slice Id %v, "+
 			"IndexInstId %v, IndexDefnId %v. Error %v", fdb.id, fdb.idxInstId, fdb.idxDefnId, err)
@@ -757,9 +1031,80 @@ func tryCloseFdbSlice(fdb *fdbSlice) {
 		fdb.meta.Close()
 	}
 
+	if fdb.wal != nil {
+		fdb.wal.Close()
+	}
+
 	fdb.dbfile.Close()
 }
 
+//openWal opens the write-ahead log of slice and re-applies entries
+//logged upto its last in-memory snapshot, which are then persisted as
+//a new snapshot so that recovery can resume from there.
+func (fdb *fdbSlice) openWal() error {
+
+	wal, err := openSliceWal(fdb.path)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	ts, err := wal.Replay(func(rec *walRecord) error {
+		count++
+		switch rec.typ {
+		case WAL_RECORD_INSERT:
+			k, err := NewKeyFromEncodedBytes(rec.key)
+			if err != nil {
+				return err
+			}
+			v, err := NewValueFromEncodedBytes(rec.value)
+			if err != nil {
+				return err
+			}
+			fdb.insert(k, v, 0)
+		case WAL_RECORD_DELETE:
+			fdb.delete(rec.key, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		wal.Close()
+		return err
+	}
+
+	fdb.wal = wal
+	if ts != nil {
+		common.Infof("ForestDBSlice::openWal \n\tSliceId %v IndexInstId %v Replayed "+
+			"%v Entries From WAL Upto %v", fdb.id, fdb.idxInstId, count, ts)
+		if _, err = fdb.NewSnapshot(ts, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//truncateWal discards the entries logged so far
+func (fdb *fdbSlice) truncateWal() error {
+
+	if fdb.wal == nil {
+		return nil
+	}
+	if err := fdb.wal.Truncate(); err != nil {
+		fdb.walError(err)
+		return err
+	}
+	return nil
+}
+
+//walError stores failure to write the log as a fatal error, as entries
+//written to slice without being logged cannot be recovered
+func (fdb *fdbSlice) walError(err error) {
+
+	common.Errorf("ForestDBSlice::walError \n\tSliceId %v IndexInstId %v Error "+
+		"Writing WAL %v", fdb.id, fdb.idxInstId, err)
+	fdb.fatalDbErr = err
+}
+
 func newFdbFile(dirpath string, newVersion bool) string {
 	var version int = 0
 
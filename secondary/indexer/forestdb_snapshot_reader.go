@@ -15,6 +15,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbaselabs/goforestdb"
+	"sort"
+	"time"
 )
 
 var (
@@ -99,6 +102,77 @@ func (s *fdbSnapshot) ValueRange(low, high Key, inclusion Inclusion,
 	return chval, cherr, Asc
 }
 
+//KeyLooker
+func (s *fdbSnapshot) LookupKeys(keys []Key,
+	stopch StopChannel) (chan Key, chan error) {
+
+	chkey := make(chan Key)
+	cherr := make(chan error)
+
+	go s.GetKeySetForKeys(keys, chkey, cherr, stopch)
+	return chkey, cherr
+}
+
+// GetKeySetForKeys reads the entries equal to each of the keys, a single
+// iterator is positioned on every key in turn without read ahead.
+func (s *fdbSnapshot) GetKeySetForKeys(keys []Key, chkey chan Key,
+	cherr chan error, stopch StopChannel) {
+
+	defer close(chkey)
+
+	it, err := newFDBSnapshotIterator(s)
+	if err != nil {
+		cherr <- err
+		return
+	}
+	defer closeIterator(it)
+
+	lookupEqualKeys(keys, it, chkey, cherr, stopch)
+}
+
+// lookupEqualKeys seeks `it` to each key in ascending order of their
+// encoding and reads the entries equal to it, duplicate keys are looked
+// up once. Entries are encoded as [key..., docid] so equal entries share
+// the encoded key without its array terminator as a prefix.
+func lookupEqualKeys(keys []Key, it storageIterator, chkey chan Key,
+	cherr chan error, stopch StopChannel) {
+
+	sorted := make([]Key, 0, len(keys))
+	for _, key := range keys {
+		if key.Encoded() != nil {
+			sorted = append(sorted, key)
+		}
+	}
+	sort.Sort(keysByEncoding(sorted))
+
+	var prev []byte
+	for _, key := range sorted {
+		select {
+		case <-stopch:
+			return
+		default:
+		}
+
+		encoded := key.Encoded()
+		if prev != nil && bytes.Equal(prev, encoded) {
+			continue
+		}
+		prev = encoded
+
+		prefix := encoded[:len(encoded)-1]
+		it.Seek(prefix)
+		readEqualKeys(key, prefix, it, chkey, cherr, stopch, false)
+	}
+}
+
+type keysByEncoding []Key
+
+func (ks keysByEncoding) Len() int      { return len(ks) }
+func (ks keysByEncoding) Swap(i, j int) { ks[i], ks[j] = ks[j], ks[i] }
+func (ks keysByEncoding) Less(i, j int) bool {
+	return bytes.Compare(ks[i].Encoded(), ks[j].Encoded()) < 0
+}
+
 // TODO: Refactor db scan to support inclusion options
 // Currently, for the given low, high predicates, it will return rows
 // for which row >= low and row < high
@@ -110,7 +184,7 @@ func (s *fdbSnapshot) GetKeySetForKeyRange(low Key, high Key,
 	common.Debugf("ForestDB Received Key Low - %s High - %s for Scan",
 		low.String(), high.String())
 
-	it, err := newFDBSnapshotIterator(s)
+	it, err := newFDBScanIterator(s)
 	if err != nil {
 		cherr <- err
 		return
@@ -163,6 +237,7 @@ loop:
 					it.Key(), err)
 				panic(err)
 			}
+			key.SetValue(it.Value())
 
 			// if we have reached past the high key, no need to scan further
 			if highcmp > 0 {
@@ -189,7 +264,7 @@ func (s *fdbSnapshot) GetValueSetForKeyRange(low Key, high Key,
 	common.Debugf("ForestDB Received Key Low - %s High - %s Inclusion - %v for Scan",
 		low.String(), high.String(), inclusion)
 
-	it, err := newFDBSnapshotIterator(s)
+	it, err := newFDBScanIterator(s)
 	if err != nil {
 		cherr <- err
 		return
@@ -295,6 +370,28 @@ loop:
 	return count, nil
 }
 
+//Histogrammer interface
+func (s *fdbSnapshot) KeyHistogram(low, high Key,
+	stopch StopChannel) ([]KeyBin, error) {
+
+	slice := s.slice.(*fdbSlice)
+	if slice.hist == nil {
+		return nil, nil
+	}
+	if !slice.hist.isBuilt() {
+		//first read after the slice was opened or rolled back
+		it, err := newFDBSnapshotIterator(s)
+		if err != nil {
+			return nil, err
+		}
+		defer closeIterator(it)
+		if !slice.hist.rebuild(it, stopch) {
+			return nil, nil
+		}
+	}
+	return slice.hist.histogram(low, high), nil
+}
+
 // Keys are encoded in the form of an array [..., primaryKey]
 // Scannable key is the subarray with [0:l] where l is the max prefix fields
 // This method is used to transform key bytes received from index storage
@@ -323,7 +420,7 @@ func ReadScanKey(kbytes []byte, nfields int) (k []byte, err error) {
 // For checking equality, its a two step operation:
 // 1. Compare jsoncollate encoded prefix
 // 2. If encoded prefix is equal, compare decoded full keys
-func readEqualKeys(k Key, kPrefix []byte, it *ForestDBIterator,
+func readEqualKeys(k Key, kPrefix []byte, it storageIterator,
 	chkey chan Key, cherr chan error, stopch StopChannel, discard bool) {
 
 	var err error
@@ -361,8 +458,9 @@ loop:
 					cherr <- err1
 					return
 				}
+				key.SetValue(it.Value())
 				jsonCurrKey, err2 := ReadScanKey(key.Raw(), nfields)
-				if err != nil {
+				if err2 != nil {
 					cherr <- err2
 					return
 				}
@@ -383,9 +481,71 @@ loop:
 	}
 }
 
-func closeIterator(it *ForestDBIterator) {
+func closeIterator(it storageIterator) {
 	err := it.Close()
 	if err != nil {
 		common.Errorf("ForestDB iterator: dealloc failed (%v)", err)
 	}
 }
+
+//Scrub verifies the main and the back index of the snapshot against each
+//other, refer scrubChecker. Rows that scans skip as undecodable are
+//reported here.
+func (s *fdbSnapshot) Scrub(opts scrubOptions) (scrubResult, error) {
+
+	sc := &scrubChecker{opts: opts}
+	slice := s.slice.(*fdbSlice)
+
+	it, err := newFDBSnapshotIterator(s)
+	if err != nil {
+		return sc.result, err
+	}
+	defer closeIterator(it)
+
+	for it.SeekFirst(); it.Valid(); it.Next() {
+		sc.check(it.Key(), it.Value(), s.BackIndexKey)
+		sc.throttle(sc.result.Entries)
+	}
+
+	//every back index entry shall be found in the main index, this also
+	//catches main index entries that iteration could not reach
+	backSeq := FORESTDB_INMEMSEQ
+	if s.committed {
+		backSeq = s.backSeqNum
+	}
+	bit, err := newForestDBIterator(slice, s.back, backSeq)
+	if err != nil {
+		return sc.result, err
+	}
+	defer closeIterator(bit)
+
+	var n int64
+	for bit.SeekFirst(); bit.Valid(); bit.Next() {
+		docid, kbytes := bit.Key(), bit.Value()
+		start := time.Now()
+		_, err := s.main.GetKV(kbytes)
+		slice.recordRead(start)
+		if err == forestdb.RESULT_KEY_NOT_FOUND {
+			sc.missing(docid, kbytes, nil)
+		} else if err != nil {
+			sc.missing(docid, kbytes, err)
+		}
+		n++
+		sc.throttle(n)
+	}
+
+	return sc.result, nil
+}
+
+//BackIndexKey returns the encoded key indexed for document `docid` in
+//the snapshot, nil if the document is not indexed.
+func (s *fdbSnapshot) BackIndexKey(docid []byte) ([]byte, error) {
+
+	start := time.Now()
+	kbytes, err := s.back.GetKV(docid)
+	s.slice.(*fdbSlice).recordRead(start)
+	if err == forestdb.RESULT_KEY_NOT_FOUND || len(kbytes) == 0 {
+		return nil, nil
+	}
+	return kbytes, err
+}
@@ -0,0 +1,87 @@
+package indexer
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func newLookupIterator(t *testing.T, entries ...string) *memIterator {
+	it := &memIterator{block: 1}
+	for _, entry := range entries {
+		key, err := NewKey([]byte(entry))
+		if err != nil {
+			t.Fatal(err)
+		}
+		it.keys = append(it.keys, key.Encoded())
+	}
+	sort.Sort(byteSlices(it.keys))
+	return it
+}
+
+type byteSlices [][]byte
+
+func (b byteSlices) Len() int           { return len(b) }
+func (b byteSlices) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byteSlices) Less(i, j int) bool { return string(b[i]) < string(b[j]) }
+
+func lookupTestKeys(t *testing.T, it storageIterator, stopch StopChannel,
+	keys ...string) []interface{} {
+
+	lookupKeys := make([]Key, 0, len(keys))
+	for _, k := range keys {
+		key, err := NewKey([]byte(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lookupKeys = append(lookupKeys, key)
+	}
+
+	chkey, cherr := make(chan Key), make(chan error, 1)
+	go func() {
+		defer close(chkey)
+		lookupEqualKeys(lookupKeys, it, chkey, cherr, stopch)
+	}()
+
+	// entries are identified by their docid, the last field of the key.
+	var docids []interface{}
+	for key := range chkey {
+		var fields []interface{}
+		if err := json.Unmarshal(key.Raw(), &fields); err != nil {
+			t.Fatal(err)
+		}
+		docids = append(docids, fields[len(fields)-1])
+	}
+	select {
+	case err := <-cherr:
+		t.Fatal(err)
+	default:
+	}
+	return docids
+}
+
+func TestLookupEqualKeys(t *testing.T) {
+	it := newLookupIterator(t,
+		`["a","doc1"]`, `["a","doc2"]`, `["ab","doc3"]`, `["b","doc4"]`,
+		`[10,"doc5"]`)
+
+	// keys are looked up in index order, once each.
+	docids := lookupTestKeys(t, it, make(StopChannel),
+		`["b"]`, `["a"]`, `["x"]`, `["a"]`, `[10]`)
+	expected := []interface{}{"doc5", "doc1", "doc2", "doc4"}
+	if !reflect.DeepEqual(docids, expected) {
+		t.Fatalf("expected %v, got %v", expected, docids)
+	}
+
+	docids = lookupTestKeys(t, it, make(StopChannel), `["c"]`, `[]`)
+	if docids != nil {
+		t.Fatalf("expected no entries, got %v", docids)
+	}
+
+	stopch := make(StopChannel)
+	close(stopch)
+	if docids = lookupTestKeys(t, it, stopch, `["a"]`); docids != nil {
+		t.Fatalf("expected stopped lookup to return nothing, got %v", docids)
+	}
+}
@@ -60,14 +60,29 @@ type Ranger interface {
 		chan Value, chan error, SortOrder)
 }
 
+// KeyLooker is a class of algorithms that can fetch the entries whose
+// secondary key equals one of a batch of keys, with a point read per key
+// instead of a range scan.
+type KeyLooker interface {
+	LookupKeys(keys []Key, stopch StopChannel) (chan Key, chan error)
+}
+
 // RangeCounter is a class of algorithms that can count a range efficiently
 type RangeCounter interface {
 	CountRange(low Key, high Key, inclusion Inclusion, stopch StopChannel) (
 		uint64, error)
 }
 
+// Histogrammer is a class of algorithms that maintain an approximate
+// distribution of keys, as bins of roughly equal number of entries,
+// returning bins that overlap a range.
+type Histogrammer interface {
+	KeyHistogram(low, high Key, stopch StopChannel) ([]KeyBin, error)
+}
+
 type IndexReader interface {
 	Counter
 	Ranger
 	RangeCounter
+	KeyLooker
 }
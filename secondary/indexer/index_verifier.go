@@ -0,0 +1,338 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+	"sort"
+	"sync"
+	"time"
+)
+
+// verifyResult of comparing documents sampled from KV with their entries
+// in an index snapshot.
+type verifyResult struct {
+	Sampled    int64    // documents sampled from KV
+	Skipped    int64    // documents that could not be verified
+	Missing    int64    // indexed documents without entry
+	Stale      int64    // documents whose entry has a different key
+	Unexpected int64    // entries of documents that are not indexed
+	Samples    []string // first few divergent documents, with reason
+}
+
+// Divergence is the fraction of verified documents whose entries differ
+// from KV, 0 for an index consistent with KV.
+func (r *verifyResult) Divergence() float64 {
+	verified := r.Sampled - r.Skipped
+	if verified <= 0 {
+		return 0
+	}
+	return float64(r.Missing+r.Stale+r.Unexpected) / float64(verified)
+}
+
+// backIndexReader is implemented by snapshots that can look up the entry
+// of a document.
+type backIndexReader interface {
+	BackIndexKey(docid []byte) ([]byte, error)
+}
+
+// verifyChecker compares the key a document evaluates to with the key
+// indexed for it.
+type verifyChecker struct {
+	maxSamples int
+	result     verifyResult
+}
+
+// check document `docid`, `expected` is the encoded key the document
+// evaluates to and `actual` the encoded key found in the index, either
+// is nil if there is no entry.
+func (vc *verifyChecker) check(docid, expected, actual []byte) {
+	vc.result.Sampled++
+
+	switch {
+	case expected == nil && actual == nil:
+	case expected == nil:
+		vc.diverged(&vc.result.Unexpected, docid, "entry for document not indexed")
+	case actual == nil:
+		vc.diverged(&vc.result.Missing, docid, "no entry for document")
+	case !bytes.Equal(expected, actual):
+		vc.diverged(&vc.result.Stale, docid, "entry differs from document")
+	}
+}
+
+// skip a document that cannot be verified against the snapshot.
+func (vc *verifyChecker) skip() {
+	vc.result.Sampled++
+	vc.result.Skipped++
+}
+
+func (vc *verifyChecker) diverged(count *int64, docid []byte, reason string) {
+	*count++
+	if len(vc.result.Samples) >= vc.maxSamples {
+		return
+	}
+	if len(docid) > scrubSampleKeyLen {
+		docid = docid[:scrubSampleKeyLen]
+	}
+	vc.result.Samples = append(vc.result.Samples, fmt.Sprintf("%s: docid %q", reason, docid))
+}
+
+// indexVerifier detects drift of an index from KV, entries missing, stale
+// or left behind, by sampling documents from KV, evaluating the index
+// expressions on them and looking up their entries in a snapshot of the
+// index. Scrub verifies the storage against itself, verifier verifies the
+// index against its source.
+//
+// Documents of a vbucket with mutations not yet in the snapshot cannot be
+// verified and are skipped, so that a busy bucket yields fewer verified
+// documents but no false divergence.
+type indexVerifier struct {
+	cluster    string
+	numVbs     int
+	sampleSize int
+	maxSamples int
+}
+
+func newIndexVerifier(config common.Config) *indexVerifier {
+	return &indexVerifier{
+		cluster:    config["clusterAddr"].String(),
+		numVbs:     config["numVbuckets"].Int(),
+		sampleSize: config["verify.sampleSize"].Int(),
+		maxSamples: config["verify.maxSamples"].Int(),
+	}
+}
+
+// Verify index snapshot `is` of index instance `inst`.
+func (iv *indexVerifier) Verify(inst common.IndexInst,
+	is IndexSnapshot) (verifyResult, error) {
+
+	vc := &verifyChecker{maxSamples: iv.maxSamples}
+
+	ie, err := protobuf.NewIndexEvaluator(&protobuf.IndexInst{
+		Definition: convertIndexDefnToProtobuf(inst.Defn),
+	})
+	if err != nil {
+		return vc.result, err
+	}
+
+	bucket := inst.Defn.Bucket
+	b, err := common.ConnectBucket(iv.cluster, DEFAULT_POOL, bucket)
+	if err != nil {
+		return vc.result, err
+	}
+	defer b.Close()
+
+	docids := make([]string, 0, iv.sampleSize)
+	sampled := make(map[string]bool)
+	for i := 0; i < iv.sampleSize; i++ {
+		docid, err := b.GetRandomKey()
+		if err != nil {
+			return vc.result, err
+		}
+		if !sampled[docid] {
+			sampled[docid] = true
+			docids = append(docids, docid)
+		}
+	}
+
+	docs, err := b.GetBulk(docids)
+	if err != nil {
+		return vc.result, err
+	}
+
+	// seqnos are read after the documents, a vbucket whose seqno is
+	// covered by the snapshot has every document read reflected in it.
+	kvTs, err := GetCurrentKVTs(iv.cluster, bucket, iv.numVbs)
+	if err != nil {
+		return vc.result, err
+	}
+	var snapTs Timestamp
+	if ts := is.Timestamp(); ts != nil {
+		snapTs = getTSFromTsVbuuid(ts)
+	}
+
+	for _, docid := range docids {
+		vbno := int(b.VBHash(docid))
+		if vbno >= len(snapTs) || vbno >= len(kvTs) || snapTs[vbno] < kvTs[vbno] {
+			vc.skip()
+			continue
+		}
+
+		var expected []byte
+		if doc, ok := docs[docid]; ok { // else deleted, expect no entry
+			raw, err := ie.SecondaryKey([]byte(docid), doc.Body)
+			if err != nil {
+				vc.skip()
+				continue
+			}
+			key, err := NewKey(raw)
+			if err != nil {
+				vc.skip()
+				continue
+			}
+			expected = key.Encoded()
+		}
+
+		actual, err := backIndexKey(is, []byte(docid))
+		if err != nil {
+			return vc.result, err
+		}
+		vc.check([]byte(docid), expected, actual)
+	}
+	return vc.result, nil
+}
+
+// backIndexKey looks up the entry of `docid` in every slice snapshot of
+// index snapshot `is`, nil if there is none.
+func backIndexKey(is IndexSnapshot, docid []byte) ([]byte, error) {
+	for _, ps := range is.Partitions() {
+		for _, ss := range ps.Slices() {
+			snap, ok := ss.Snapshot().(backIndexReader)
+			if !ok {
+				continue
+			}
+			if kbytes, err := snap.BackIndexKey(docid); err != nil || kbytes != nil {
+				return kbytes, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// verifyReport of the last verification of an index instance.
+type verifyReport struct {
+	InstId     common.IndexInstId `json:"instId"`
+	Bucket     string             `json:"bucket"`
+	Index      string             `json:"index"`
+	Time       time.Time          `json:"time"`
+	Duration   int64              `json:"durationMs"`
+	Sampled    int64              `json:"sampled"`
+	Skipped    int64              `json:"skipped"`
+	Missing    int64              `json:"missing"`
+	Stale      int64              `json:"stale"`
+	Unexpected int64              `json:"unexpected"`
+	Divergence float64            `json:"divergence"`
+	Samples    []string           `json:"samples,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// verifyReports of index instances, read by stats and admin requests.
+type verifyReports struct {
+	mu      sync.Mutex
+	reports map[common.IndexInstId]verifyReport
+}
+
+func newVerifyReports() *verifyReports {
+	return &verifyReports{reports: make(map[common.IndexInstId]verifyReport)}
+}
+
+func (vr *verifyReports) Set(report verifyReport) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	vr.reports[report.InstId] = report
+}
+
+func (vr *verifyReports) Get(instId common.IndexInstId) (verifyReport, bool) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	report, ok := vr.reports[instId]
+	return report, ok
+}
+
+// Retain reports only of instances in `indexInstMap`.
+func (vr *verifyReports) Retain(indexInstMap common.IndexInstMap) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	for instId := range vr.reports {
+		if inst, ok := indexInstMap[instId]; !ok ||
+			inst.State == common.INDEX_STATE_DELETED {
+			delete(vr.reports, instId)
+		}
+	}
+}
+
+// List reports, most divergent instances first.
+func (vr *verifyReports) List() []verifyReport {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	list := make([]verifyReport, 0, len(vr.reports))
+	for _, report := range vr.reports {
+		list = append(list, report)
+	}
+	sort.Sort(verifyReportsByDivergence(list))
+	return list
+}
+
+type verifyReportsByDivergence []verifyReport
+
+func (s verifyReportsByDivergence) Len() int      { return len(s) }
+func (s verifyReportsByDivergence) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s verifyReportsByDivergence) Less(i, j int) bool {
+	if s[i].Divergence != s[j].Divergence {
+		return s[i].Divergence > s[j].Divergence
+	}
+	return s[i].InstId < s[j].InstId
+}
+
+// verifyDaemon periodically verifies every index instance against KV,
+// one instance at a time.
+type verifyDaemon struct {
+	quitch  chan bool
+	started bool
+	ticker  *time.Ticker
+	msgch   MsgChannel
+	config  common.Config
+}
+
+func (vd *verifyDaemon) Start() {
+	interval := vd.config["interval"].Int()
+	if !vd.started && interval > 0 {
+		vd.ticker = time.NewTicker(time.Second * time.Duration(interval))
+		vd.started = true
+		go vd.loop()
+	}
+}
+
+func (vd *verifyDaemon) Stop() {
+	if vd.started {
+		vd.ticker.Stop()
+		vd.quitch <- true
+		<-vd.quitch
+	}
+}
+
+func (vd *verifyDaemon) loop() {
+loop:
+	for {
+		select {
+		case _, ok := <-vd.ticker.C:
+			if ok {
+				replych := make(chan []IndexStorageStats)
+				vd.msgch <- &MsgIndexStorageStats{respch: replych}
+				stats := <-replych
+
+				for _, is := range stats {
+					errch := make(chan error)
+					vd.msgch <- &MsgIndexVerify{instId: is.InstId, errch: errch}
+					if err := <-errch; err != nil {
+						common.Errorf("VerifyDaemon: Index instance:%v Verify failed with reason - %v", is.InstId, err)
+					}
+				}
+			}
+
+		case <-vd.quitch:
+			vd.quitch <- true
+			break loop
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package indexer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyChecker(t *testing.T) {
+	vc := &verifyChecker{maxSamples: 2}
+	vc.check([]byte("doc1"), []byte(`["a"]`), []byte(`["a"]`))
+	vc.check([]byte("doc2"), nil, nil) // not indexed, no entry
+	if vc.result.Sampled != 2 || vc.result.Divergence() != 0 {
+		t.Fatalf("unexpected result %+v", vc.result)
+	}
+
+	vc.check([]byte("doc3"), []byte(`["b"]`), nil)
+	vc.check([]byte("doc4"), []byte(`["c"]`), []byte(`["x"]`))
+	vc.check([]byte("doc5"), nil, []byte(`["d"]`))
+	vc.skip()
+	r := vc.result
+	if r.Sampled != 6 || r.Skipped != 1 ||
+		r.Missing != 1 || r.Stale != 1 || r.Unexpected != 1 {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	if d := r.Divergence(); d != 0.6 {
+		t.Errorf("expected divergence 0.6, got %v", d)
+	}
+	if len(r.Samples) != 2 {
+		t.Fatalf("expected 2 samples, got %v", r.Samples)
+	}
+	if !strings.HasPrefix(r.Samples[0], "no entry for document") {
+		t.Errorf("unexpected sample %v", r.Samples[0])
+	}
+
+	skipped := &verifyChecker{}
+	skipped.skip()
+	if d := skipped.result.Divergence(); d != 0 {
+		t.Errorf("expected no divergence without verified documents, got %v", d)
+	}
+}
+
+func TestVerifyReports(t *testing.T) {
+	vr := newVerifyReports()
+	vr.Set(verifyReport{InstId: 1})
+	vr.Set(verifyReport{InstId: 2, Divergence: 0.01})
+	vr.Set(verifyReport{InstId: 3, Divergence: 0.2})
+
+	list := vr.List()
+	if len(list) != 3 || list[0].InstId != 3 || list[1].InstId != 2 {
+		t.Fatalf("unexpected order %+v", list)
+	}
+
+	vr.Retain(nil)
+	if _, ok := vr.Get(3); ok {
+		t.Errorf("expected reports of dropped indexes to be removed")
+	}
+}
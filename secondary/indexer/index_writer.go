@@ -11,6 +11,7 @@ package indexer
 
 import (
 	"github.com/couchbase/indexing/secondary/common"
+	"time"
 )
 
 type StorageStatistics struct {
@@ -20,6 +21,23 @@ type StorageStatistics struct {
 	GetBytes    int64
 	InsertBytes int64
 	DeleteBytes int64
+
+	NumReads int64
+	ReadTime int64 //cumulative latency of reads, in nanoseconds
+
+	WalSize int64 //bytes in write-ahead log since last persisted snapshot
+
+	PurgedItems int64 //entries reclaimed for documents purged from KV
+	Expirations int64 //documents expired in KV, counted apart from deletes
+}
+
+// AvgReadLatency returns the average latency of reads from storage, 0 if
+// there are no reads yet.
+func (s StorageStatistics) AvgReadLatency() time.Duration {
+	if s.NumReads == 0 {
+		return 0
+	}
+	return time.Duration(s.ReadTime / s.NumReads)
 }
 
 type IndexWriter interface {
@@ -27,9 +45,20 @@ type IndexWriter interface {
 	//Persist a key/value pair
 	Insert(key Key, value Value) error
 
+	//Persist a batch of key/value pairs as sorted runs, bypassing
+	//per-entry buffering. Used by initial build and backfill loads.
+	BulkInsert(keys []Key, values []Value) error
+
 	//Delete a key/value pair by docId
 	Delete(docid []byte) error
 
+	//Delete a key/value pair by docId of a document expired in KV
+	Expire(docid []byte) error
+
+	//Purge the key/value pair of docId if it was last updated by
+	//mutation seqno, used to reclaim entries of documents purged from KV
+	Purge(docid []byte, seqno Seqno) error
+
 	// Create commited commited snapshot or inmemory snapshot
 	NewSnapshot(*common.TsVbuuid, bool) (SnapshotInfo, error)
 
@@ -21,9 +21,11 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Indexer interface {
@@ -44,11 +46,14 @@ var (
 	ErrFatalComm                = errors.New("Fatal Internal Communication Error")
 	ErrInconsistentState        = errors.New("Inconsistent Internal State")
 	ErrKVRollbackForInitRequest = errors.New("KV Rollback Received For Initial Build Request")
+	ErrIndexDroppedWhileQueued  = errors.New("Index Dropped While Waiting For Build")
 	ErrMaintStreamMissingBucket = errors.New("Bucket Missing in Maint Stream")
 	ErrInvalidStream            = errors.New("Invalid Stream")
 	ErrIndexerInRecovery        = errors.New("Indexer In Recovery")
 	ErrKVConnect                = errors.New("Error Connecting KV")
 	ErrUnknownBucket            = errors.New("Unknown Bucket")
+	ErrInvalidPortRange         = errors.New("Invalid Stream Port Range")
+	ErrNoStreamPort             = errors.New("No Free Port In Stream Port Range")
 )
 
 type indexer struct {
@@ -71,6 +76,9 @@ type indexer struct {
 	//TODO Remove this once cbq bridge support goes away
 	bucketCreateClientChMap map[string]MsgChannel
 
+	buildQueue    *buildQueue                //initial builds waiting for a build slot
+	buildAttempts map[common.IndexInstId]int //failed attempts to start initial build
+
 	wrkrRecvCh         MsgChannel //channel to receive messages from workers
 	internalRecvCh     MsgChannel //buffered channel to queue worker requests
 	adminRecvCh        MsgChannel //channel to receive admin messages
@@ -102,8 +110,11 @@ type indexer struct {
 	settingsMgr   settingsManager
 	statsMgr      statsManager
 	scanCoord     ScanCoordinator //handle to ScanCoordinator
+	memMgr        *memoryManager  //budgets memory quota among components
 	config        common.Config
 
+	stateMachine *common.IndexStateMachine //enforces index state transitions
+
 	kvlock sync.Mutex //fine-grain lock for KVSender
 
 	enableManager bool
@@ -143,9 +154,18 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 		streamBucketRollbackTs:       make(map[common.StreamId]BucketRollbackTs),
 		bucketBuildTs:                make(map[string]Timestamp),
 		bucketCreateClientChMap:      make(map[string]MsgChannel),
+		buildQueue:                   newBuildQueue(),
+		buildAttempts:                make(map[common.IndexInstId]int),
 		config:                       config,
+		stateMachine:                 common.NewIndexStateMachine(),
 	}
 
+	idx.stateMachine.AddHook(func(instId common.IndexInstId,
+		from, to common.IndexState) {
+		common.Infof("Indexer::stateMachine Index Inst %v State %v -> %v",
+			instId, from, to)
+	})
+
 	common.Infof("Indexer::NewIndexer Status INIT")
 
 	common.Infof("Indexer::NewIndexer Starting with Vbuckets %v", idx.config["numVbuckets"].Int())
@@ -160,8 +180,11 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 	idx.initStreamAddressMap()
 	idx.initStreamFlushMap()
 
+	idx.memMgr = newMemoryManager(idx.config)
+	go idx.memMgr.run()
+
 	//Start Mutation Manager
-	idx.mutMgr, res = NewMutationManager(idx.mutMgrCmdCh, idx.wrkrRecvCh, idx.config)
+	idx.mutMgr, res = NewMutationManager(idx.mutMgrCmdCh, idx.wrkrRecvCh, idx.config, idx.memMgr)
 	if res.GetMsgType() != MSG_SUCCESS {
 		common.Errorf("Indexer::NewIndexer Mutation Manager Init Error", res)
 		return nil, res
@@ -182,7 +205,7 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 	}
 
 	//Start Scan Coordinator
-	idx.scanCoord, res = NewScanCoordinator(idx.scanCoordCmdCh, idx.wrkrRecvCh, idx.config)
+	idx.scanCoord, res = NewScanCoordinator(idx.scanCoordCmdCh, idx.wrkrRecvCh, idx.config, idx.memMgr)
 	if res.GetMsgType() != MSG_SUCCESS {
 		common.Errorf("Indexer::NewIndexer Scan Coordinator Init Error", res)
 		return nil, res
@@ -313,6 +336,9 @@ func (idx *indexer) run() {
 
 		}
 
+		//a build may have finished or an index dropped, start
+		//queued builds if a build slot is available
+		idx.processBuildQueue()
 	}
 
 }
@@ -330,6 +356,7 @@ func (idx *indexer) listenWorkerMsgs() {
 				switch msg.GetMsgType() {
 				case MSG_ERROR:
 					err := msg.(*MsgError).GetError()
+					countError(err)
 					if err.code == ERROR_MUT_MGR_PANIC {
 						close(idx.mutMgrExitCh)
 					}
@@ -441,10 +468,17 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 
 	case STORAGE_INDEX_SNAP_REQUEST,
 		STORAGE_INDEX_STORAGE_STATS,
-		STORAGE_INDEX_COMPACT:
+		STORAGE_INDEX_SNAP_LIST,
+		STORAGE_INDEX_COMPACT,
+		STORAGE_INDEX_PURGE,
+		STORAGE_INDEX_SCRUB,
+		STORAGE_INDEX_VERIFY:
 		idx.storageMgrCmdCh <- msg
 		<-idx.storageMgrCmdCh
 
+	case STORAGE_INDEX_CORRUPTED:
+		idx.handleIndexCorrupted(msg)
+
 	case INDEXER_ROLLBACK:
 		idx.handleRollback(msg)
 
@@ -502,9 +536,22 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 	case INDEXER_BUCKET_NOT_FOUND:
 		idx.handleBucketNotFound(msg)
 
+	case INDEXER_BUCKET_UUID_CHANGED:
+		idx.handleBucketUUIDChanged(msg)
+
 	case INDEXER_STATS:
 		idx.handleStats(msg)
 
+	case INDEXER_CAPACITY_STATS:
+		idx.handleCapacityStats(msg)
+
+	case INDEXER_BUILD_RETRY:
+		//a failed build is due for retry, it is started from the build
+		//queue once this message is handled
+
+	case INDEXER_BUILD_FAILED:
+		idx.handleBuildFailed(msg)
+
 	case MSG_ERROR:
 		//crash for all errors by default
 		common.Fatalf("Indexer::handleWorkerMsgs Fatal Error On Worker Channel %+v", msg)
@@ -644,6 +691,7 @@ func (idx *indexer) handleBuildIndex(msg Message) {
 	bucketIndexList := idx.groupIndexListByBucket(instIdList)
 
 	initialBuildReqd := true
+	var buildErr error //build of some bucket was rejected
 	for bucket, instIdList := range bucketIndexList {
 
 		if ok := idx.checkValidIndexInst(bucket, instIdList, clientCh); !ok {
@@ -691,6 +739,18 @@ func (idx *indexer) handleBuildIndex(msg Message) {
 			}
 		}
 
+		//if all build slots are in use, queue the build to be started
+		//once a running build is done
+		if !idx.checkBuildSlotAvailable() {
+			idx.queueBuildRequest(bucket, instIdList, clientCh)
+			if idx.enableManager {
+				delete(bucketIndexList, bucket)
+				continue
+			} else {
+				return
+			}
+		}
+
 		//get current timestamp from KV and set it as Initial Build Timestamp
 		buildTs, err := GetCurrentKVTs(idx.config["clusterAddr"].String(),
 			bucket,
@@ -701,10 +761,7 @@ func (idx *indexer) handleBuildIndex(msg Message) {
 				idx.config["clusterAddr"].String(), err)
 			common.Errorf("Indexer::handleBuildIndex %v", errStr)
 			if idx.enableManager {
-				idx.bulkUpdateError(instIdList, errStr)
-				if err := idx.updateMetaInfoForIndexList(instIdList, false, false, true); err != nil {
-					common.CrashOnError(err)
-				}
+				idx.retryBuild(bucket, instIdList, errStr)
 				delete(bucketIndexList, bucket)
 				continue
 			} else if clientCh != nil {
@@ -728,19 +785,43 @@ func (idx *indexer) handleBuildIndex(msg Message) {
 			buildStream = common.MAINT_STREAM
 		}
 
-		idx.bulkUpdateStream(instIdList, buildStream)
-
 		//if initial build TS is zero and index belongs to MAINT_STREAM
 		//initial build is not required.
 		var buildState common.IndexState
 		if buildTs.IsZeroTs() && buildStream == common.MAINT_STREAM {
 			buildState = common.INDEX_STATE_ACTIVE
-			initialBuildReqd = false
 		} else {
 			buildState = common.INDEX_STATE_INITIAL
 		}
 
-		idx.bulkUpdateState(instIdList, buildState)
+		//instances that can't be built, like those in ERROR state, fail
+		//the build of the bucket before any of them is streamed
+		if err := idx.checkStateTransition(instIdList, buildState); err != nil {
+			common.Errorf("Indexer::handleBuildIndex \n\tCannot Build Index List %v "+
+				"Bucket %v. %v", instIdList, bucket, err)
+			if idx.enableManager {
+				buildErr = err
+				delete(bucketIndexList, bucket)
+				continue
+			} else {
+				if clientCh != nil {
+					clientCh <- &MsgError{
+						err: Error{code: ERROR_INDEXER_INVALID_STATE,
+							severity: FATAL,
+							cause:    err,
+							category: INDEXER}}
+				}
+				return
+			}
+		}
+		if buildState == common.INDEX_STATE_ACTIVE {
+			initialBuildReqd = false
+		}
+
+		idx.bulkUpdateStream(instIdList, buildStream)
+		if err := idx.bulkUpdateState(instIdList, buildState); err != nil {
+			common.CrashOnError(err)
+		}
 
 		common.Debugf("Indexer::handleBuildIndex \n\tAdded Index: %v to Stream: %v State: %v",
 			instIdList, buildStream, buildState)
@@ -784,7 +865,18 @@ func (idx *indexer) handleBuildIndex(msg Message) {
 		}
 	}
 
-	clientCh <- &MsgSuccess{}
+	//builds started from the build queue have no client waiting
+	if clientCh == nil {
+		return
+	} else if buildErr != nil {
+		clientCh <- &MsgError{
+			err: Error{code: ERROR_INDEXER_INVALID_STATE,
+				severity: FATAL,
+				cause:    buildErr,
+				category: INDEXER}}
+	} else {
+		clientCh <- &MsgSuccess{}
+	}
 
 }
 
@@ -815,14 +907,12 @@ func (idx *indexer) handleDropIndex(msg Message) {
 
 	//if the index state is Created/Ready/Deleted, only data cleanup is
 	//required. No stream updates are required.
-	if indexInst.State == common.INDEX_STATE_CREATED ||
-		indexInst.State == common.INDEX_STATE_READY ||
-		indexInst.State == common.INDEX_STATE_DELETED {
+	if !indexInst.State.IsStreaming() {
 
-		idx.cleanupIndexData(indexInst, clientCh)
+		drainch := idx.cleanupIndexData(indexInst, clientCh)
 		common.Debugf("Indexer::handleDropIndex Cleanup Successful for "+
 			"Index Data %v", indexInst)
-		clientCh <- &MsgSuccess{}
+		go sendDropIndexResponse(drainch, clientCh)
 		return
 	}
 
@@ -832,8 +922,8 @@ func (idx *indexer) handleDropIndex(msg Message) {
 	//Second step, is the actual cleanup of index instance from internal maps
 	//and purging of physical slice files.
 
-	indexInst.State = common.INDEX_STATE_DELETED
-	idx.indexInstMap[indexInst.InstId] = indexInst
+	idx.bulkUpdateState([]common.IndexInstId{indexInst.InstId},
+		common.INDEX_STATE_DELETED)
 
 	msgUpdateIndexInstMap := &MsgUpdateInstMap{indexInstMap: idx.indexInstMap}
 
@@ -984,7 +1074,16 @@ func (idx *indexer) handleInitRecovery(msg Message) {
 	//if there is a rollbackTs, process rollback
 	if ts, ok := idx.streamBucketRollbackTs[streamId][bucket]; ok && ts != nil {
 		restartTs, err := idx.processRollback(streamId, bucket, ts)
-		if err != nil {
+		if err != nil && streamId == common.INIT_STREAM {
+			//indexes being built can't be rolled back, their build
+			//is started afresh. Stream is already stopped.
+			common.Errorf("Indexer::handleInitRecovery StreamId %v Bucket %v "+
+				"Initial Build Failed. Rollback Error %v", streamId, bucket, err)
+			delete(idx.streamBucketRollbackTs[streamId], bucket)
+			idx.streamBucketStatus[streamId][bucket] = STREAM_INACTIVE
+			idx.abortBuild(streamId, bucket, fmt.Sprintf("Rollback Failed. %v", err))
+			return
+		} else if err != nil {
 			common.CrashOnError(err)
 		}
 		idx.startBucketStream(streamId, bucket, restartTs)
@@ -1158,12 +1257,109 @@ func (idx *indexer) handleBucketNotFound(msg Message) {
 
 }
 
+//handleBucketUUIDChanged moves indexes of a bucket, that was flushed or
+//recreated after they were defined, to error state and marks them as
+//needing rebuild. Streams are not restarted for such a bucket.
+func (idx *indexer) handleBucketUUIDChanged(msg Message) {
+
+	streamId := msg.(*MsgRecovery).GetStreamId()
+	bucket := msg.(*MsgRecovery).GetBucket()
+
+	common.Errorf("Indexer::handleBucketUUIDChanged StreamId %v Bucket %v "+
+		"Flushed Or Recreated. Indexes Need Rebuild.", streamId, bucket)
+
+	var instIdList []common.IndexInstId
+	for _, index := range idx.indexInstMap {
+		if index.Stream == streamId &&
+			index.Defn.Bucket == bucket {
+			instIdList = append(instIdList, index.InstId)
+		}
+	}
+
+	idx.bulkUpdateState(instIdList, common.INDEX_STATE_ERROR)
+	idx.bulkUpdateError(instIdList, common.ErrorBucketUUIDChanged.Error())
+
+	msgUpdateIndexInstMap := &MsgUpdateInstMap{indexInstMap: idx.indexInstMap}
+
+	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
+		common.CrashOnError(err)
+	}
+
+	if idx.enableManager {
+		if err := idx.updateMetaInfoForIndexList(instIdList, true, false, true); err != nil {
+			common.CrashOnError(err)
+		}
+	}
+
+	idx.stopBucketStream(streamId, bucket)
+
+	idx.streamBucketStatus[streamId][bucket] = STREAM_INACTIVE
+
+}
+
+//handleIndexCorrupted moves an index found corrupted by the scrubber to
+//error state, so that it is no longer scanned and can be rebuilt.
+func (idx *indexer) handleIndexCorrupted(msg Message) {
+
+	instId := msg.(*MsgIndexCorrupted).GetInstId()
+	err := msg.(*MsgIndexCorrupted).GetError()
+
+	index, ok := idx.indexInstMap[instId]
+	if !ok || index.State == common.INDEX_STATE_ERROR {
+		return
+	}
+
+	//storage of an index being built is corrupted, build is started
+	//afresh unless its stream is being recovered
+	if index.State.IsBuilding() &&
+		idx.streamBucketStatus[index.Stream][index.Defn.Bucket] == STREAM_ACTIVE {
+		idx.handleBuildFailed(&MsgBuildFailed{streamId: index.Stream,
+			bucket: index.Defn.Bucket,
+			err:    fmt.Errorf("Index %v Storage Corrupted. %v", instId, err)})
+		return
+	}
+
+	common.Errorf("Indexer::handleIndexCorrupted Index %v Bucket %v "+
+		"Storage Corrupted. Index Needs Rebuild. %v", instId,
+		index.Defn.Bucket, err)
+
+	instIdList := []common.IndexInstId{instId}
+	idx.bulkUpdateState(instIdList, common.INDEX_STATE_ERROR)
+	idx.bulkUpdateError(instIdList, err.Error())
+
+	msgUpdateIndexInstMap := &MsgUpdateInstMap{indexInstMap: idx.indexInstMap}
+
+	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
+		common.CrashOnError(err)
+	}
+
+	if idx.enableManager {
+		if err := idx.updateMetaInfoForIndexList(instIdList, true, false, true); err != nil {
+			common.CrashOnError(err)
+		}
+	}
+}
+
+//cleanupIndexData removes the index instance from internal maps, so that
+//no new scan gets admitted, and destroys its storage in the background once
+//in-flight scans are drained. Outcome of draining is sent on the returned
+//channel after the storage is destroyed.
 func (idx *indexer) cleanupIndexData(indexInst common.IndexInst,
-	clientCh MsgChannel) {
+	clientCh MsgChannel) <-chan scanDrainStats {
 
 	indexInstId := indexInst.InstId
 	idxPartnInfo := idx.indexPartnMap[indexInstId]
 
+	//index may be waiting for a build slot, or for its build to be retried
+	delete(idx.buildAttempts, indexInstId)
+	if req := idx.buildQueue.Remove(indexInstId); req != nil && req.clientCh != nil {
+		req.clientCh <- &MsgError{
+			err: Error{code: ERROR_INDEXER_UNKNOWN_INDEX,
+				severity: FATAL,
+				cause:    ErrIndexDroppedWhileQueued,
+				category: INDEXER}}
+	}
+
 	//update internal maps
 	delete(idx.indexInstMap, indexInstId)
 	delete(idx.indexPartnMap, indexInstId)
@@ -1180,30 +1376,67 @@ func (idx *indexer) cleanupIndexData(indexInst common.IndexInst,
 		common.CrashOnError(err)
 	}
 
-	//for all partitions managed by this indexer
-	for _, partnInst := range idxPartnInfo {
-		sc := partnInst.Sc
-		//close all the slices
-		for _, slice := range sc.GetAllSlices() {
-			slice.Close()
-			//wipe the physical files
-			slice.Destroy()
+	//let in-flight scans finish before the storage goes away
+	return idx.drainScans(indexInst, idxPartnInfo)
+
+}
+
+//drainScans waits in the background for in-flight scans of a dropped
+//index instance to finish, scans still running past
+//indexer.scanDrainTimeout are cancelled. Slices of the instance are
+//destroyed after that.
+func (idx *indexer) drainScans(indexInst common.IndexInst,
+	idxPartnInfo PartitionInstMap) <-chan scanDrainStats {
+
+	respch := make(chan scanDrainStats)
+	idx.scanCoordCmdCh <- &MsgDrainScans{instId: indexInst.InstId,
+		index:  fmt.Sprintf("%s:%s", indexInst.Defn.Bucket, indexInst.Defn.Name),
+		respch: respch}
+	<-idx.scanCoordCmdCh
+
+	drainch := make(chan scanDrainStats, 1)
+	go func() {
+		stats := <-respch
+		common.Infof("Indexer::drainScans Index Instance %v Drained %v Scans, "+
+			"Cancelled %v Scans", indexInst.InstId, stats.Drained, stats.Cancelled)
+
+		//for all partitions managed by this indexer
+		for _, partnInst := range idxPartnInfo {
+			sc := partnInst.Sc
+			//close all the slices
+			for _, slice := range sc.GetAllSlices() {
+				slice.Close()
+				//wipe the physical files
+				slice.Destroy()
+			}
 		}
-	}
+		drainch <- stats
+	}()
+	return drainch
+}
+
+//sendDropIndexResponse waits for the scans of a dropped index to be drained
+//and responds with their outcome.
+func sendDropIndexResponse(drainch <-chan scanDrainStats, clientCh MsgChannel) {
 
+	stats := <-drainch
+	if clientCh != nil {
+		clientCh <- &MsgDropIndexResponse{drained: stats.Drained,
+			cancelled: stats.Cancelled}
+	}
 }
 
 func (idx *indexer) cleanupIndex(indexInst common.IndexInst,
 	clientCh MsgChannel) {
 
-	idx.cleanupIndexData(indexInst, clientCh)
+	drainch := idx.cleanupIndexData(indexInst, clientCh)
 
 	//send Stream update to workers
 	if ok := idx.sendStreamUpdateForDropIndex(indexInst, clientCh); !ok {
 		return
 	}
 
-	clientCh <- &MsgSuccess{}
+	go sendDropIndexResponse(drainch, clientCh)
 }
 
 func (idx *indexer) shutdownWorkers() {
@@ -1237,6 +1470,8 @@ func (idx *indexer) shutdownWorkers() {
 	//shutdown kv sender
 	idx.kvSenderCmdCh <- &MsgGeneral{mType: KV_SENDER_SHUTDOWN}
 	<-idx.kvSenderCmdCh
+
+	idx.memMgr.stop()
 }
 
 func (idx *indexer) Shutdown() Message {
@@ -1296,7 +1531,15 @@ func (idx *indexer) sendStreamUpdateForBuildIndex(instIdList []common.IndexInstI
 	}
 	idx.streamBucketRequestStopCh[buildStream][bucket] = stopCh
 
+	interval := time.Duration(idx.config["build.retry.interval"].Int()) * time.Millisecond
+	maxInterval := time.Duration(idx.config["build.retry.maxInterval"].Int()) * time.Millisecond
+
+	//indexes with nothing to build are active already, stream request
+	//for them is retried till it succeeds
+	initialBuild := len(indexList) > 0 && indexList[0].State.IsBuilding()
+
 	go func() {
+		attempt := 0
 	retryloop:
 		for {
 			if !ValidateBucket(idx.config["clusterAddr"].String(), bucket) {
@@ -1331,8 +1574,28 @@ func (idx *indexer) sendStreamUpdateForBuildIndex(instIdList []common.IndexInstI
 					common.CrashOnError(ErrKVRollbackForInitRequest)
 
 				default:
-					//log and retry for all other responses
-					common.Errorf("Indexer::sendStreamUpdateForBuildIndex - Error from Projector %v", resp)
+					if isBucketUUIDChangedMsg(resp) {
+						idx.internalRecvCh <- &MsgRecovery{mType: INDEXER_BUCKET_UUID_CHANGED,
+							streamId: buildStream,
+							bucket:   bucket}
+						break retryloop
+					}
+					//initial build fails for all other responses, to be
+					//retried by indexer from the start
+					if initialBuild {
+						common.Errorf("Indexer::sendStreamUpdateForBuildIndex - Error from Projector %v. "+
+							"Initial Build Failed For Stream %v Bucket %v", resp, buildStream, bucket)
+						idx.internalRecvCh <- &MsgBuildFailed{streamId: buildStream,
+							bucket: bucket,
+							err:    projectorError(resp)}
+						break retryloop
+					}
+					//log and retry for all other responses, with backoff
+					attempt++
+					backoff := buildRetryBackoff(attempt, interval, maxInterval)
+					common.Errorf("Indexer::sendStreamUpdateForBuildIndex - Error from Projector %v. "+
+						"Retry %v In %v", resp, attempt, backoff)
+					time.Sleep(backoff)
 
 				}
 			}
@@ -1532,8 +1795,8 @@ func (idx *indexer) initPartnInstance(indexInst common.IndexInst,
 						severity: FATAL,
 						cause:    errors.New("Indexer Internal Error"),
 						category: INDEXER}}
-				return nil, err
 			}
+			return nil, err
 		}
 	}
 
@@ -1684,9 +1947,7 @@ func (idx *indexer) checkDuplicateInitialBuildRequest(bucket string,
 	//cannot start another one
 	for _, index := range idx.indexInstMap {
 
-		if ((index.State == common.INDEX_STATE_INITIAL ||
-			index.State == common.INDEX_STATE_CATCHUP) &&
-			index.Defn.Bucket == bucket) ||
+		if (index.State.IsBuilding() && index.Defn.Bucket == bucket) ||
 			idx.checkStreamRequestPending(index.Stream, bucket) {
 
 			errStr := fmt.Sprintf("Build Already In Progress. Bucket %v", bucket)
@@ -1709,6 +1970,257 @@ func (idx *indexer) checkDuplicateInitialBuildRequest(bucket string,
 	return true
 }
 
+//getBuildingBuckets returns the buckets which have an initial build
+//running or a stream request pending
+func (idx *indexer) getBuildingBuckets() map[string]bool {
+
+	building := make(map[string]bool)
+	for _, index := range idx.indexInstMap {
+		bucket := index.Defn.Bucket
+		if index.State.IsBuilding() || idx.checkStreamRequestPending(index.Stream, bucket) {
+			building[bucket] = true
+		}
+	}
+	return building
+}
+
+//checkBuildSlotAvailable checks if another initial build can be started
+//without exceeding the limit on concurrent builds
+func (idx *indexer) checkBuildSlotAvailable() bool {
+
+	maxBuilds := idx.config["build.maxConcurrent"].Int()
+	if maxBuilds <= 0 {
+		return true
+	}
+	return len(idx.getBuildingBuckets()) < maxBuilds
+}
+
+//queueBuildRequest queues the build of index list on bucket till
+//a build slot is available
+func (idx *indexer) queueBuildRequest(bucket string,
+	instIdList []common.IndexInstId, clientCh MsgChannel) {
+
+	req := &buildRequest{bucket: bucket, instIdList: instIdList}
+	//with index manager, build request is responded once queued
+	if !idx.enableManager {
+		req.clientCh = clientCh
+	}
+	if idx.buildQueue.Push(req) {
+		common.Infof("Indexer::queueBuildRequest Build Queued. Bucket %v "+
+			"IndexList %v Position %v", bucket, req.instIdList, idx.buildQueue.Len())
+	}
+}
+
+//retryBuild handles an initial build of index list on bucket that
+//failed, either to start or after it was started and aborted, refer
+//abortBuild(). The build is queued to be retried, with exponential
+//backoff, till build.retry.maxAttempts attempts have failed, after which
+//the indexes are moved to error state. Error of every attempt is
+//recorded in index metadata. If retries are disabled, error is recorded
+//and the indexes are left to be built again.
+func (idx *indexer) retryBuild(bucket string,
+	instIdList []common.IndexInstId, errStr string) {
+
+	maxAttempts := idx.config["build.retry.maxAttempts"].Int()
+	if maxAttempts <= 0 {
+		idx.bulkUpdateError(instIdList, errStr)
+		if err := idx.updateMetaInfoForIndexList(instIdList, false, false, true); err != nil {
+			common.CrashOnError(err)
+		}
+		return
+	}
+
+	attempt := 0
+	for _, instId := range instIdList {
+		idx.buildAttempts[instId]++
+		if idx.buildAttempts[instId] > attempt {
+			attempt = idx.buildAttempts[instId]
+		}
+	}
+	errStr = fmt.Sprintf("Build Attempt %v of %v Failed. %v", attempt, maxAttempts, errStr)
+
+	if attempt >= maxAttempts {
+		common.Errorf("Indexer::retryBuild Giving Up Build. Bucket %v "+
+			"IndexList %v. %v", bucket, instIdList, errStr)
+		for _, instId := range instIdList {
+			delete(idx.buildAttempts, instId)
+		}
+		idx.bulkUpdateState(instIdList, common.INDEX_STATE_ERROR)
+		idx.bulkUpdateError(instIdList, errStr)
+
+		msgUpdateIndexInstMap := &MsgUpdateInstMap{indexInstMap: idx.indexInstMap}
+		if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
+			common.CrashOnError(err)
+		}
+		if err := idx.updateMetaInfoForIndexList(instIdList, true, false, true); err != nil {
+			common.CrashOnError(err)
+		}
+		return
+	}
+
+	interval := time.Duration(idx.config["build.retry.interval"].Int()) * time.Millisecond
+	maxInterval := time.Duration(idx.config["build.retry.maxInterval"].Int()) * time.Millisecond
+	backoff := buildRetryBackoff(attempt, interval, maxInterval)
+
+	common.Warnf("Indexer::retryBuild Build Retry In %v. Bucket %v "+
+		"IndexList %v. %v", backoff, bucket, instIdList, errStr)
+	idx.bulkUpdateError(instIdList, errStr)
+	if err := idx.updateMetaInfoForIndexList(instIdList, false, false, true); err != nil {
+		common.CrashOnError(err)
+	}
+
+	idx.buildQueue.Push(&buildRequest{bucket: bucket, instIdList: instIdList,
+		retryAt: time.Now().Add(backoff)})
+	time.AfterFunc(backoff, func() {
+		idx.internalRecvCh <- &MsgGeneral{mType: INDEXER_BUILD_RETRY}
+	})
+}
+
+//handleBuildFailed stops the stream of an initial build that failed
+//after it was started, by a stream request error from projector or by
+//an error in storage of an index being built, and aborts the build.
+func (idx *indexer) handleBuildFailed(msg Message) {
+
+	streamId := msg.(*MsgBuildFailed).GetStreamId()
+	bucket := msg.(*MsgBuildFailed).GetBucket()
+	err := msg.(*MsgBuildFailed).GetError()
+
+	common.Errorf("Indexer::handleBuildFailed StreamId %v Bucket %v "+
+		"Initial Build Failed. %v", streamId, bucket, err)
+
+	//indexes may have been dropped meanwhile
+	if !idx.checkBucketExistsInStream(bucket, streamId) {
+		common.Warnf("Indexer::handleBuildFailed StreamId %v Bucket %v "+
+			"Nothing To Abort", streamId, bucket)
+		return
+	}
+
+	idx.stopBucketStream(streamId, bucket)
+
+	idx.streamBucketStatus[streamId][bucket] = STREAM_INACTIVE
+
+	idx.abortBuild(streamId, bucket, err.Error())
+}
+
+//abortBuild moves indexes being built in the stream for bucket back to
+//ready state, with their storage emptied, and hands their build to
+//retryBuild(). Stream must already be stopped for bucket. Without index
+//manager, the client waiting for the build is sent the error instead.
+func (idx *indexer) abortBuild(streamId common.StreamId, bucket string,
+	errStr string) {
+
+	var instIdList []common.IndexInstId
+	for _, index := range idx.indexInstMap {
+		if index.Stream == streamId && index.Defn.Bucket == bucket &&
+			index.State.IsBuilding() {
+			instIdList = append(instIdList, index.InstId)
+		}
+	}
+
+	common.Infof("Indexer::abortBuild StreamId %v Bucket %v "+
+		"IndexList %v. %v", streamId, bucket, instIdList, errStr)
+
+	idx.bulkUpdateStream(instIdList, common.NIL_STREAM)
+	if err := idx.bulkUpdateState(instIdList, common.INDEX_STATE_READY); err != nil {
+		common.CrashOnError(err)
+	}
+
+	//workers let go of snapshots of indexes back in ready state,
+	//before their slices are replaced
+	msgUpdateIndexInstMap := &MsgUpdateInstMap{indexInstMap: idx.indexInstMap}
+
+	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
+		common.CrashOnError(err)
+	}
+
+	for _, instId := range instIdList {
+		if err := idx.resetIndexData(instId); err != nil {
+			common.CrashOnError(err)
+		}
+	}
+
+	msgUpdateIndexPartnMap := &MsgUpdatePartnMap{indexPartnMap: idx.indexPartnMap}
+
+	if err := idx.distributeIndexMapsToWorkers(nil, msgUpdateIndexPartnMap); err != nil {
+		common.CrashOnError(err)
+	}
+
+	delete(idx.bucketBuildTs, bucket)
+
+	if idx.enableManager {
+		if err := idx.updateMetaInfoForIndexList(instIdList, true, true, false); err != nil {
+			common.CrashOnError(err)
+		}
+		idx.retryBuild(bucket, instIdList, errStr)
+	} else if clientCh, ok := idx.bucketCreateClientChMap[bucket]; ok {
+		if clientCh != nil {
+			clientCh <- &MsgError{
+				err: Error{code: ERROR_INDEXER_INTERNAL_ERROR,
+					severity: FATAL,
+					cause:    errors.New(errStr),
+					category: INDEXER}}
+		}
+		delete(idx.bucketCreateClientChMap, bucket)
+	}
+}
+
+//resetIndexData replaces the slices of an index instance, whose build
+//was aborted, with empty ones for the build to start afresh. Index is
+//not scanned while being built, so slices are destroyed right away.
+//Workers shall already have let go of its snapshots.
+func (idx *indexer) resetIndexData(instId common.IndexInstId) error {
+
+	for _, partnInst := range idx.indexPartnMap[instId] {
+		for _, slice := range partnInst.Sc.GetAllSlices() {
+			slice.Close()
+			slice.Destroy()
+		}
+	}
+
+	partnInstMap, err := idx.initPartnInstance(idx.indexInstMap[instId], nil)
+	if err != nil {
+		return err
+	}
+	idx.indexPartnMap[instId] = partnInstMap
+	return nil
+}
+
+//projectorError returns the error of a failed stream request response
+func projectorError(resp Message) error {
+
+	if msg, ok := resp.(*MsgError); ok && msg.GetError().cause != nil {
+		return msg.GetError().cause
+	}
+	return fmt.Errorf("Stream Request Failed %v", resp)
+}
+
+//processBuildQueue starts queued builds, in the order they were queued,
+//while build slots are available. Builds for buckets which already have
+//a build running, are in recovery or have a stream request pending, like
+//that of a failed build being stopped, are left in the queue.
+func (idx *indexer) processBuildQueue() {
+
+	for idx.buildQueue.Len() > 0 && idx.checkBuildSlotAvailable() {
+
+		building := idx.getBuildingBuckets()
+		req := idx.buildQueue.Pop(func(bucket string) bool {
+			return building[bucket] ||
+				idx.streamBucketStatus[common.MAINT_STREAM][bucket] == STREAM_RECOVERY ||
+				idx.streamBucketStatus[common.INIT_STREAM][bucket] == STREAM_RECOVERY ||
+				idx.checkStreamRequestPending(common.MAINT_STREAM, bucket) ||
+				idx.checkStreamRequestPending(common.INIT_STREAM, bucket)
+		})
+		if req == nil {
+			return
+		}
+
+		common.Infof("Indexer::processBuildQueue Starting Queued Build. "+
+			"Bucket %v IndexList %v", req.bucket, req.instIdList)
+		idx.handleBuildIndex(&MsgBuildIndex{indexInstList: req.instIdList,
+			respCh: req.clientCh})
+	}
+}
+
 //TODO If this function gets error before its finished, the state
 //can be inconsistent. This needs to be fixed.
 func (idx *indexer) handleInitialBuildDone(msg Message) {
@@ -1734,10 +2246,11 @@ func (idx *indexer) handleInitialBuildDone(msg Message) {
 			index.State == common.INDEX_STATE_INITIAL {
 			//index in INIT_STREAM move to Catchup state
 			if streamId == common.INIT_STREAM {
-				index.State = common.INDEX_STATE_CATCHUP
+				idx.transitionState(&index, common.INDEX_STATE_CATCHUP)
 			} else {
-				index.State = common.INDEX_STATE_ACTIVE
+				idx.transitionState(&index, common.INDEX_STATE_ACTIVE)
 			}
+			delete(idx.buildAttempts, index.InstId)
 			indexList = append(indexList, index)
 			instIdList = append(instIdList, index.InstId)
 		}
@@ -1875,7 +2388,7 @@ func (idx *indexer) handleMergeInitStream(msg Message) {
 		if index.Defn.Bucket == bucket && index.Stream == streamId &&
 			index.State == common.INDEX_STATE_CATCHUP {
 
-			index.State = common.INDEX_STATE_ACTIVE
+			idx.transitionState(&index, common.INDEX_STATE_ACTIVE)
 			index.Stream = common.MAINT_STREAM
 			indexList = append(indexList, index)
 		}
@@ -1972,9 +2485,7 @@ func (idx *indexer) checkBucketExistsInStream(bucket string, streamId common.Str
 	for _, index := range idx.indexInstMap {
 
 		if index.Defn.Bucket == bucket && index.Stream == streamId &&
-			(index.State == common.INDEX_STATE_ACTIVE ||
-				index.State == common.INDEX_STATE_CATCHUP ||
-				index.State == common.INDEX_STATE_INITIAL) {
+			index.State.IsStreaming() {
 			return true
 		}
 	}
@@ -1990,9 +2501,7 @@ func (idx *indexer) checkLastBucketInStream(bucket string, streamId common.Strea
 	for _, index := range idx.indexInstMap {
 
 		if index.Defn.Bucket != bucket && index.Stream == streamId &&
-			(index.State == common.INDEX_STATE_ACTIVE ||
-				index.State == common.INDEX_STATE_CATCHUP ||
-				index.State == common.INDEX_STATE_INITIAL) {
+			index.State.IsStreaming() {
 			return false
 		}
 	}
@@ -2127,8 +2636,7 @@ func (idx *indexer) startBucketStream(streamId common.StreamId, bucket string,
 
 		for _, indexInst := range idx.indexInstMap {
 			if indexInst.Defn.Bucket == bucket &&
-				indexInst.State == common.INDEX_STATE_INITIAL ||
-				indexInst.State == common.INDEX_STATE_CATCHUP {
+				indexInst.State.IsBuilding() {
 				indexList = append(indexList, indexInst)
 			}
 		}
@@ -2214,6 +2722,22 @@ func (idx *indexer) startBucketStream(streamId common.StreamId, bucket string,
 					break retryloop
 
 				default:
+					if isBucketUUIDChangedMsg(resp) {
+						idx.internalRecvCh <- &MsgRecovery{mType: INDEXER_BUCKET_UUID_CHANGED,
+							streamId: streamId,
+							bucket:   bucket}
+						break retryloop
+					}
+					//indexes in INIT_STREAM are being built, their build
+					//fails, to be retried by indexer from the start
+					if streamId == common.INIT_STREAM {
+						common.Errorf("Indexer::startBucketStream Stream %v Bucket %v \n\t"+
+							"Error from Projector %v. Initial Build Failed.", streamId, bucket, resp)
+						idx.internalRecvCh <- &MsgBuildFailed{streamId: streamId,
+							bucket: bucket,
+							err:    projectorError(resp)}
+						break retryloop
+					}
 					//log and retry for all other responses
 					common.Errorf("Indexer::startBucketStream Stream %v Bucket %v \n\t"+
 						"Error from Projector %v. Retrying.", resp)
@@ -2801,14 +3325,53 @@ func (idx *indexer) bulkUpdateError(instIdList []common.IndexInstId,
 
 }
 
+//bulkUpdateState moves every index instance in the list that can legally
+//move to the given state, and returns the error of the first one that
+//can't. Callers that need all or none of the instances moved shall use
+//checkStateTransition first.
 func (idx *indexer) bulkUpdateState(instIdList []common.IndexInstId,
-	state common.IndexState) {
+	state common.IndexState) error {
 
+	var firstErr error
 	for _, instId := range instIdList {
 		idxInst := idx.indexInstMap[instId]
-		idxInst.State = state
+		if err := idx.transitionState(&idxInst, state); err != nil && firstErr == nil {
+			firstErr = err
+		}
 		idx.indexInstMap[instId] = idxInst
 	}
+	return firstErr
+}
+
+//checkStateTransition returns an error if any index instance in the list
+//can't legally move to the given state.
+func (idx *indexer) checkStateTransition(instIdList []common.IndexInstId,
+	state common.IndexState) error {
+
+	for _, instId := range instIdList {
+		idxInst := idx.indexInstMap[instId]
+		if !idxInst.State.CanTransitionTo(state) {
+			return fmt.Errorf("%v Index Inst %v %v -> %v",
+				common.ErrIllegalStateTransition, instId, idxInst.State, state)
+		}
+	}
+	return nil
+}
+
+//transitionState moves the index instance to the given state if the
+//transition is legal. Illegal transitions are logged, the instance is
+//left in its current state and the error is returned.
+func (idx *indexer) transitionState(idxInst *common.IndexInst,
+	state common.IndexState) error {
+
+	if err := idx.stateMachine.Transition(idxInst.InstId,
+		idxInst.State, state); err != nil {
+		common.Errorf("Indexer::transitionState Index Inst %v %v %v -> %v",
+			idxInst.InstId, err, idxInst.State, state)
+		return err
+	}
+	idxInst.State = state
+	return nil
 }
 
 func (idx *indexer) bulkUpdateStream(instIdList []common.IndexInstId,
@@ -2915,5 +3478,47 @@ func (idx *indexer) handleStats(cmd Message) {
 	req := cmd.(*MsgStatsRequest)
 	replych := req.GetReplyChannel()
 	statsMap["needs_restart"] = fmt.Sprint(idx.needsRestart)
+	statsMap["build_queue_length"] = fmt.Sprint(idx.buildQueue.Len())
+	for instId, inst := range idx.indexInstMap {
+		if pos := idx.buildQueue.Position(instId); pos > 0 {
+			k := fmt.Sprintf("%s:%s:build_queue_position", inst.Defn.Bucket, inst.Defn.Name)
+			statsMap[k] = fmt.Sprint(pos)
+		}
+	}
+	for k, v := range errorStats() {
+		statsMap[k] = v
+	}
+	for k, v := range idx.memMgr.stats() {
+		statsMap[k] = v
+	}
 	replych <- statsMap
 }
+
+//handleCapacityStats reports memory usage and index builds of this node,
+//disk usage is filled in by the caller from storage stats.
+func (idx *indexer) handleCapacityStats(cmd Message) {
+	req := cmd.(*MsgCapacityRequest)
+	replych := req.GetReplyChannel()
+
+	var building int
+	for _, inst := range idx.indexInstMap {
+		if inst.State.IsBuilding() {
+			building++
+		}
+	}
+
+	slots := idx.config["capacity.buildSlots"].Int() - building
+	if slots < 0 {
+		slots = 0
+	}
+
+	memStats := new(runtime.MemStats)
+	runtime.ReadMemStats(memStats)
+
+	replych <- &common.IndexerCapacity{
+		MemoryQuota: idx.config["settings.memory_quota"].Uint64(),
+		MemoryUsed:  memStats.Sys,
+		NumIndexes:  len(idx.indexInstMap),
+		BuildSlots:  slots,
+	}
+}
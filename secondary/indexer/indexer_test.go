@@ -0,0 +1,67 @@
+package indexer
+
+import (
+	"errors"
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+)
+
+func TestBulkUpdateStateIllegal(t *testing.T) {
+	idx := &indexer{
+		indexInstMap: common.IndexInstMap{
+			1: common.IndexInst{InstId: 1, State: common.INDEX_STATE_READY},
+			2: common.IndexInst{InstId: 2, State: common.INDEX_STATE_ERROR},
+		},
+		stateMachine: common.NewIndexStateMachine(),
+	}
+	instIdList := []common.IndexInstId{1, 2}
+
+	if err := idx.checkStateTransition(instIdList, common.INDEX_STATE_INITIAL); err == nil {
+		t.Errorf("expected build of ERROR instance to be rejected")
+	}
+	if err := idx.checkStateTransition(instIdList[:1], common.INDEX_STATE_INITIAL); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := idx.bulkUpdateState(instIdList, common.INDEX_STATE_INITIAL); err != common.ErrIllegalStateTransition {
+		t.Errorf("expected %v, got %v", common.ErrIllegalStateTransition, err)
+	}
+	if s := idx.indexInstMap[1].State; s != common.INDEX_STATE_INITIAL {
+		t.Errorf("expected %v, got %v", common.INDEX_STATE_INITIAL, s)
+	}
+	if s := idx.indexInstMap[2].State; s != common.INDEX_STATE_ERROR {
+		t.Errorf("expected %v, got %v", common.INDEX_STATE_ERROR, s)
+	}
+
+	if err := idx.bulkUpdateState(instIdList, common.INDEX_STATE_DELETED); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestProjectorError(t *testing.T) {
+	cause := errors.New("vbucket not found")
+	resp := &MsgError{err: Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR, cause: cause}}
+	if err := projectorError(resp); err != cause {
+		t.Errorf("expected %v, got %v", cause, err)
+	}
+	if err := projectorError(&MsgError{}); err == nil {
+		t.Errorf("expected error for response without cause")
+	}
+}
+
+func TestBuildFailedDropped(t *testing.T) {
+	idx := &indexer{
+		indexInstMap: common.IndexInstMap{
+			1: common.IndexInst{InstId: 1, State: common.INDEX_STATE_ACTIVE,
+				Stream: common.MAINT_STREAM, Defn: common.IndexDefn{Bucket: "default"}},
+		},
+		stateMachine: common.NewIndexStateMachine(),
+	}
+
+	// indexes of the failed build were dropped, nothing is aborted.
+	idx.handleBuildFailed(&MsgBuildFailed{streamId: common.INIT_STREAM,
+		bucket: "default", err: errors.New("projector error")})
+	if s := idx.indexInstMap[1].State; s != common.INDEX_STATE_ACTIVE {
+		t.Errorf("expected %v, got %v", common.INDEX_STATE_ACTIVE, s)
+	}
+}
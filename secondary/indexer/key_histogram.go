@@ -0,0 +1,263 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// KeyBin is a bin of the key histogram of an index, keys between Low and
+// High, both inclusive, are counted in the bin.
+type KeyBin struct {
+	Low, High Key
+	Count     uint64 // number of index entries in the bin
+	Distinct  uint64 // number of distinct secondary keys in the bin
+}
+
+// keyIterator iterates over encoded keys of a slice in sorted order,
+// implemented by ForestDBIterator.
+type keyIterator interface {
+	SeekFirst()
+	Valid() bool
+	Next()
+	Key() []byte
+}
+
+// keyBin is a bin of keyHistogram, bounds are encoded index keys.
+type keyBin struct {
+	low, high []byte
+	count     int64
+	distinct  int64
+}
+
+// keyHistogram is an approximate equi-depth histogram of keys in a slice.
+// Bin boundaries are computed by a full pass over the keys, when the
+// slice is compacted or the histogram is first read, while counts of the
+// bins are kept current with inserts and deletes on flush. Bins drift
+// away from equal depth as keys are updated, until the next rebuild.
+// Updates applied while a rebuild is in progress are not accounted.
+type keyHistogram struct {
+	maxBins int
+
+	mu    sync.RWMutex
+	bins  []*keyBin
+	built bool
+}
+
+// newKeyHistogram returns a histogram of upto maxBins bins, nil if
+// maxBins is not positive, which disables the histogram. Methods of a
+// nil histogram are no-ops.
+func newKeyHistogram(maxBins int) *keyHistogram {
+	if maxBins <= 0 {
+		return nil
+	}
+	return &keyHistogram{maxBins: maxBins}
+}
+
+// isBuilt returns whether bin boundaries were computed since the
+// histogram was created or last reset.
+func (h *keyHistogram) isBuilt() bool {
+	if h == nil {
+		return false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.built
+}
+
+// reset discards bins, say after a rollback, till the next rebuild.
+func (h *keyHistogram) reset() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bins, h.built = nil, false
+}
+
+// add an encoded key inserted into the slice.
+func (h *keyHistogram) add(key []byte) {
+	if h == nil || key == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if bin := h.locate(key); bin != nil {
+		bin.count++
+		if bytes.Compare(key, bin.low) < 0 {
+			bin.low = append([]byte(nil), key...)
+		} else if bytes.Compare(key, bin.high) > 0 {
+			bin.high = append([]byte(nil), key...)
+		}
+	}
+}
+
+// remove an encoded key deleted from the slice.
+func (h *keyHistogram) remove(key []byte) {
+	if h == nil || key == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if bin := h.locate(key); bin != nil && bin.count > 0 {
+		bin.count--
+	}
+}
+
+// locate the bin that shall count key, the first or the last bin for keys
+// outside all bins. Shall be called with the lock held.
+func (h *keyHistogram) locate(key []byte) *keyBin {
+	if len(h.bins) == 0 {
+		return nil
+	}
+	i := sort.Search(len(h.bins), func(i int) bool {
+		return bytes.Compare(h.bins[i].low, key) > 0
+	})
+	if i > 0 {
+		i--
+	}
+	return h.bins[i]
+}
+
+// rebuild bins from a full pass over keys, replacing the current bins
+// once done. Returns false if stopped before all keys were read.
+func (h *keyHistogram) rebuild(it keyIterator, stopch StopChannel) bool {
+	if h == nil {
+		return true
+	}
+
+	// bins are filled upto depth entries, and adjacent bins are merged,
+	// doubling the depth, whenever there are twice as many bins as
+	// allowed, so that the number of keys need not be known upfront.
+	var bins []*keyBin
+	var prev []byte
+	depth := int64(1)
+	for it.SeekFirst(); it.Valid(); it.Next() {
+		select {
+		case <-stopch:
+			return false
+		default:
+		}
+		key := append([]byte(nil), it.Key()...)
+		secKey := secondaryKeyOf(key)
+		// entries of a secondary key are not split across bins.
+		same := secKey != nil && bytes.Equal(secKey, prev)
+		if n := len(bins); n == 0 || bins[n-1].count >= depth && !same {
+			if n == 2*h.maxBins {
+				bins = mergeKeyBins(bins)
+				depth *= 2
+			}
+			bins = append(bins, &keyBin{low: key})
+		}
+		bin := bins[len(bins)-1]
+		bin.high = key
+		bin.count++
+		if bin.count == 1 || !same {
+			bin.distinct++
+		}
+		prev = secKey
+	}
+	for len(bins) > h.maxBins {
+		bins = mergeKeyBins(bins)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bins, h.built = bins, true
+	return true
+}
+
+// mergeKeyBins merges pairs of adjacent bins.
+func mergeKeyBins(bins []*keyBin) []*keyBin {
+	merged := make([]*keyBin, 0, (len(bins)+1)/2)
+	for i := 0; i < len(bins); i += 2 {
+		bin := *bins[i]
+		if i+1 < len(bins) {
+			next := bins[i+1]
+			bin.high = next.high
+			bin.count += next.count
+			bin.distinct += next.distinct
+		}
+		merged = append(merged, &bin)
+	}
+	return merged
+}
+
+// histogram returns bins that overlap the range between low and high,
+// all bins if low and high are nil. Bins are approximate, hence range
+// inclusion is not considered.
+func (h *keyHistogram) histogram(low, high Key) []KeyBin {
+	if h == nil {
+		return nil
+	}
+
+	// compare with key prefix, without array terminator, as scans do.
+	var lowkey, highkey []byte
+	if lowkey = low.Encoded(); lowkey != nil {
+		lowkey = lowkey[:len(lowkey)-1]
+	}
+	if highkey = high.Encoded(); highkey != nil {
+		highkey = highkey[:len(highkey)-1]
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	bins := make([]KeyBin, 0, len(h.bins))
+	for _, bin := range h.bins {
+		if lowkey != nil && bytes.Compare(bin.high, lowkey) < 0 {
+			continue
+		}
+		if highkey != nil && bytes.Compare(bin.low, highkey) > 0 &&
+			!bytes.HasPrefix(bin.low, highkey) {
+			break
+		}
+		binLow, _ := NewKeyFromEncodedBytes(bin.low)
+		binHigh, _ := NewKeyFromEncodedBytes(bin.high)
+		bins = append(bins, KeyBin{
+			Low:      binLow,
+			High:     binHigh,
+			Count:    uint64(bin.count),
+			Distinct: uint64(bin.distinct),
+		})
+	}
+	return bins
+}
+
+// secondaryKeyOf returns the secondary key of an encoded index key, that
+// is the JSON array of the key without its trailing docid. Returns nil
+// for keys of primary index, which have docid alone, and for keys that
+// cannot be decoded.
+func secondaryKeyOf(encoded []byte) []byte {
+	k, err := NewKeyFromEncodedBytes(encoded)
+	if err != nil {
+		return nil
+	}
+	secKey, err := trimDocid(k.Raw())
+	if err != nil || bytes.Equal(secKey, []byte("[]")) {
+		return nil
+	}
+	return secKey
+}
+
+// trimDocid removes the trailing docid from the JSON array of an index
+// key.
+func trimDocid(raw []byte) ([]byte, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	if len(fields) > 0 {
+		fields = fields[:len(fields)-1]
+	}
+	return json.Marshal(fields)
+}
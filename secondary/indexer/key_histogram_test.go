@@ -0,0 +1,110 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"fmt"
+	"testing"
+)
+
+type sliceKeyIterator struct {
+	keys [][]byte
+	pos  int
+}
+
+func (it *sliceKeyIterator) SeekFirst()  { it.pos = 0 }
+func (it *sliceKeyIterator) Valid() bool { return it.pos < len(it.keys) }
+func (it *sliceKeyIterator) Next()       { it.pos++ }
+func (it *sliceKeyIterator) Key() []byte { return it.keys[it.pos] }
+
+func encodeKey(t *testing.T, raw string) []byte {
+	k, err := NewKey([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k.Encoded()
+}
+
+// index entries of 100 documents over 10 secondary keys, in index order.
+func histogramTestKeys(t *testing.T) [][]byte {
+	keys := make([][]byte, 0, 100)
+	for i := 0; i < 100; i++ {
+		keys = append(keys, encodeKey(t, fmt.Sprintf(`[%d,"doc%03d"]`, i/10, i)))
+	}
+	return keys
+}
+
+func TestKeyHistogramRebuild(t *testing.T) {
+	h := newKeyHistogram(4)
+	if h.isBuilt() {
+		t.Fatalf("expected histogram not to be built")
+	}
+	if !h.rebuild(&sliceKeyIterator{keys: histogramTestKeys(t)}, nil) {
+		t.Fatalf("expected rebuild to complete")
+	}
+
+	var nilKey Key
+	bins := h.histogram(nilKey, nilKey)
+	if len(bins) == 0 || len(bins) > 4 {
+		t.Fatalf("expected upto 4 bins, got %v", len(bins))
+	}
+	var count, distinct uint64
+	for _, bin := range bins {
+		// entries of a secondary key are never split across bins.
+		if bin.Count%10 != 0 {
+			t.Errorf("expected whole secondary keys in bin, got %v entries", bin.Count)
+		}
+		count, distinct = count+bin.Count, distinct+bin.Distinct
+	}
+	if count != 100 || distinct != 10 {
+		t.Fatalf("expected 100 entries of 10 keys, got %v of %v", count, distinct)
+	}
+
+	low, _ := NewKey([]byte(`[9]`))
+	if bins := h.histogram(low, nilKey); len(bins) != 1 {
+		t.Fatalf("expected 1 bin overlapping [9], got %v", len(bins))
+	}
+}
+
+func TestKeyHistogramUpdate(t *testing.T) {
+	h := newKeyHistogram(4)
+	h.add(encodeKey(t, `[1,"doc1"]`)) // ignored till built
+	h.rebuild(&sliceKeyIterator{keys: histogramTestKeys(t)}, nil)
+
+	first := encodeKey(t, `[0,"doc000"]`)
+	h.remove(first)
+	h.add(encodeKey(t, `[-1,"docx"]`))
+	h.add(encodeKey(t, `[10,"docy"]`))
+
+	var nilKey Key
+	bins := h.histogram(nilKey, nilKey)
+	var count uint64
+	for _, bin := range bins {
+		count += bin.Count
+	}
+	if count != 101 {
+		t.Fatalf("expected 101 entries, got %v", count)
+	}
+	low, _ := NewKey([]byte(`[10]`))
+	if bins := h.histogram(low, nilKey); len(bins) != 1 {
+		t.Fatalf("expected last bin to be extended to [10], got %v bins", len(bins))
+	}
+
+	h.reset()
+	if h.isBuilt() || len(h.histogram(nilKey, nilKey)) != 0 {
+		t.Fatalf("expected no bins after reset")
+	}
+
+	var disabled *keyHistogram
+	disabled.add(first)
+	if disabled.rebuild(&sliceKeyIterator{}, nil) != true || disabled.histogram(nilKey, nilKey) != nil {
+		t.Fatalf("expected disabled histogram to be a no-op")
+	}
+}
@@ -21,6 +21,7 @@ import (
 type Key struct {
 	raw     []byte //raw key received from KV
 	encoded []byte //collatejson byte representation of the key
+	value   []byte //encoded value read along with the key from storage, if any
 }
 
 // Value is the primary key of the relavent document
@@ -30,9 +31,10 @@ type Value struct {
 }
 
 type Valuedata struct {
-	Docid   []byte
-	Vbucket Vbucket //useful for debugging, can be removed to optimize space
-	Seqno   Seqno   //useful for debugging, can be removed to optimize space
+	Docid     []byte
+	Vbucket   Vbucket //useful for debugging, can be removed to optimize space
+	Seqno     Seqno   //useful for debugging, can be removed to optimize space
+	Projected []byte  `json:",omitempty"` //fields stored for covering index
 }
 
 var KEY_SEPARATOR []byte = []byte{0xff, 0xff, 0xff, 0xff}
@@ -59,13 +61,15 @@ func NewKey(data []byte) (Key, error) {
 	return key, nil
 }
 
-func NewValue(docid []byte, vbucket Vbucket, seqno Seqno) (Value, error) {
+func NewValue(docid []byte, vbucket Vbucket, seqno Seqno,
+	projected []byte) (Value, error) {
 
 	var val Value
 
 	val.raw.Docid = docid
 	val.raw.Vbucket = vbucket
 	val.raw.Seqno = seqno
+	val.raw.Projected = projected
 
 	var err error
 	if val.encoded, err = json.Marshal(val.raw); err != nil {
@@ -124,6 +128,25 @@ func (k *Key) Raw() []byte {
 	return k.raw
 }
 
+// SetValue attaches the encoded value stored with this key, so that
+// projected fields can be returned for a covering index scan.
+func (k *Key) SetValue(value []byte) {
+	k.value = value
+}
+
+// Projected returns the fields stored along with this key, nil if
+// index does not include any field.
+func (k *Key) Projected() ([]byte, error) {
+	if k.value == nil {
+		return nil, nil
+	}
+	val, err := NewValueFromEncodedBytes(k.value)
+	if err != nil {
+		return nil, err
+	}
+	return val.Projected(), nil
+}
+
 func (k *Key) String() string {
 	var buf bytes.Buffer
 	buf.WriteString(fmt.Sprintf("%v", string(k.raw)))
@@ -142,6 +165,10 @@ func (v *Value) Docid() []byte {
 	return v.raw.Docid
 }
 
+func (v *Value) Projected() []byte {
+	return v.raw.Projected
+}
+
 func (v *Value) String() string {
 	var buf bytes.Buffer
 	buf.WriteString(fmt.Sprintf("Docid:%v ", v.raw.Docid))
@@ -19,6 +19,7 @@ import (
 	projClient "github.com/couchbase/indexing/secondary/projector/client"
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
 	"github.com/couchbaselabs/goprotobuf/proto"
+	"net"
 	"time"
 )
 
@@ -254,6 +255,7 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.Ind
 	var rollbackTs *protobuf.TsVbuuid
 	var activeTs *protobuf.TsVbuuid
 	topic := getTopicForStreamId(streamId)
+	uuidChanged := false
 
 	fn := func(r int, err error) error {
 
@@ -261,18 +263,25 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.Ind
 
 			execWithStopCh(func() {
 				ap := newProjClient(addr)
-				if res, ret := sendMutationTopicRequest(ap, topic, restartTsList, protoInstList); ret != nil {
+				token := getStreamToken(streamId)
+				if res, ret := sendMutationTopicRequest(ap, topic, token,
+					restartTsList, protoInstList); ret != nil {
 					//for all errors, retry
 					c.Errorf("KVSender::openMutationStream \n\t Error Received %v from %v", ret, addr)
 					err = ret
+					uuidChanged = uuidChanged || isBucketUUIDChanged(ret)
 				} else {
+					ackStreamToken(streamId, addr, res.GetToken())
 					activeTs = updateActiveTsFromResponse(bucket, activeTs, res)
 					rollbackTs = updateRollbackTsFromResponse(bucket, rollbackTs, res)
 				}
 			}, stopCh)
 		}
 
-		if rollbackTs != nil {
+		if uuidChanged {
+			//no retry required, bucket has to be re-indexed
+			return nil
+		} else if rollbackTs != nil {
 			//no retry required for rollback
 			return nil
 		} else if err != nil {
@@ -297,7 +306,10 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.Ind
 	rh := c.NewRetryHelper(MAX_KV_REQUEST_RETRY, time.Second, BACKOFF_FACTOR, fn)
 	err = rh.Run()
 
-	if rollbackTs != nil {
+	if uuidChanged {
+		c.Errorf("KVSender::openMutationStream \n\t Bucket %v Flushed Or Recreated", bucket)
+		respCh <- bucketUUIDChangedError()
+	} else if rollbackTs != nil {
 		c.Infof("KVSender::openMutationStream \n\t Rollback Received %v", rollbackTs)
 		//convert from protobuf to native format
 		numVbuckets := k.config["numVbuckets"].Int()
@@ -339,22 +351,29 @@ func (k *kvSender) restartVbuckets(streamId c.StreamId, restartTs *c.TsVbuuid,
 	var rollbackTs *protobuf.TsVbuuid
 	topic := getTopicForStreamId(streamId)
 	rollback := false
+	uuidChanged := false
 
 	fn := func(r int, err error) error {
 
 		for _, addr := range addrs {
 			ap := newProjClient(addr)
 
-			if res, ret := sendRestartVbuckets(ap, topic, protoRestartTs); ret != nil {
+			token := getStreamToken(streamId)
+			if res, ret := sendRestartVbuckets(ap, topic, token, protoRestartTs); ret != nil {
 				//retry for all errors
 				c.Errorf("KVSender::restartVbuckets \n\t Error Received %v from %v", ret, addr)
 				err = ret
+				uuidChanged = uuidChanged || isBucketUUIDChanged(ret)
 			} else {
+				ackStreamToken(streamId, addr, res.GetToken())
 				rollbackTs = updateRollbackTsFromResponse(restartTs.Bucket, rollbackTs, res)
 			}
 		}
 
-		if rollbackTs != nil && checkVbListInTS(protoRestartTs.GetVbnos(), rollbackTs) {
+		if uuidChanged {
+			//no retry required, bucket has to be re-indexed
+			return nil
+		} else if rollbackTs != nil && checkVbListInTS(protoRestartTs.GetVbnos(), rollbackTs) {
 			//if rollback, no need to retry
 			rollback = true
 			return nil
@@ -368,7 +387,10 @@ func (k *kvSender) restartVbuckets(streamId c.StreamId, restartTs *c.TsVbuuid,
 
 	//if any of the requested vb is in rollback ts, send rollback
 	//msg to caller
-	if rollback {
+	if uuidChanged {
+		c.Errorf("KVSender::restartVbuckets \n\t Bucket %v Flushed Or Recreated", restartTs.Bucket)
+		respCh <- bucketUUIDChangedError()
+	} else if rollback {
 		//convert from protobuf to native format
 		nativeTs := rollbackTs.ToTsVbuuid(numVbuckets)
 
@@ -377,7 +399,7 @@ func (k *kvSender) restartVbuckets(streamId c.StreamId, restartTs *c.TsVbuuid,
 	} else if err != nil {
 		//if there is a topicMissing error, a fresh
 		//MutationTopicRequest is required.
-		if err.Error() == projClient.ErrorTopicMissing.Error() {
+		if c.IsError(err, projClient.ErrorTopicMissing) {
 			respCh <- &MsgKVStreamRepair{
 				streamId: streamId,
 				bucket:   restartTs.Bucket,
@@ -465,7 +487,7 @@ func (k *kvSender) deleteIndexesFromStream(streamId c.StreamId, indexInstList []
 				ap := newProjClient(addr)
 				if ret := sendDelInstancesRequest(ap, topic, uuids); ret != nil {
 					c.Errorf("KVSender::deleteIndexesFromStream \n\t Error Received %v from %v", ret, addr)
-					if ret.Error() == projClient.ErrorTopicMissing.Error() {
+					if c.IsError(ret, projClient.ErrorTopicMissing) {
 						c.Infof("KVSender::deleteIndexesFromStream Treating TopicMissing As Success")
 					} else {
 						err = ret
@@ -512,7 +534,7 @@ func (k *kvSender) deleteBucketsFromStream(streamId c.StreamId, buckets []string
 				ap := newProjClient(addr)
 				if ret := sendDelBucketsRequest(ap, topic, buckets); ret != nil {
 					c.Errorf("KVSender::deleteBucketsFromStream \n\t Error Received %v from %v", ret, addr)
-					if ret.Error() == projClient.ErrorTopicMissing.Error() {
+					if c.IsError(ret, projClient.ErrorTopicMissing) {
 						c.Infof("KVSender::deleteBucketsFromStream Treating TopicMissing As Success")
 					} else {
 						err = ret
@@ -559,7 +581,7 @@ func (k *kvSender) closeMutationStream(streamId c.StreamId,
 				ap := newProjClient(addr)
 				if ret := sendShutdownTopic(ap, topic); ret != nil {
 					c.Errorf("KVSender::closeMutationStream \n\t Error Received %v from %v", ret, addr)
-					if ret.Error() == projClient.ErrorTopicMissing.Error() {
+					if c.IsError(ret, projClient.ErrorTopicMissing) {
 						c.Infof("KVSender::closeMutationStream Treating TopicMissing As Success")
 					} else {
 						err = ret
@@ -586,7 +608,7 @@ func (k *kvSender) closeMutationStream(streamId c.StreamId,
 }
 
 //send the actual MutationStreamRequest on adminport
-func sendMutationTopicRequest(ap *projClient.Client, topic string,
+func sendMutationTopicRequest(ap *projClient.Client, topic, token string,
 	reqTimestamps *protobuf.TsVbuuid,
 	instances []*protobuf.Instance) (*protobuf.TopicResponse, error) {
 
@@ -595,20 +617,22 @@ func sendMutationTopicRequest(ap *projClient.Client, topic string,
 
 	endpointType := "dataport"
 
-	if res, err := ap.MutationTopicRequest(topic, endpointType,
+	if res, err := ap.MutationTopicRequestWithToken(topic, endpointType, token,
 		[]*protobuf.TsVbuuid{reqTimestamps}, instances); err != nil {
 		c.Fatalf("KVSender::sendMutationTopicRequest \n\tUnexpected Error %v During Mutation Stream "+
 			"Request %v for IndexInst %v", err, topic, instances)
 
+		logStartupTimings(ap, topic, res)
 		return res, err
 	} else {
 		c.Debugf("KVSender::sendMutationTopicRequest \n\tMutationStream Response %v", res)
+		logStartupTimings(ap, topic, res)
 		return res, nil
 	}
 }
 
 func sendRestartVbuckets(ap *projClient.Client,
-	topic string,
+	topic, token string,
 	restartTs *protobuf.TsVbuuid) (*protobuf.TopicResponse, error) {
 
 	c.Debugf("KVSender::sendRestartVbuckets Projector %v Topic %v RestartTs %v",
@@ -625,18 +649,37 @@ func sendRestartVbuckets(ap *projClient.Client,
 		//RestartVbuckets errors will be acted upon.
 	}
 
-	if res, err := ap.RestartVbuckets(topic, []*protobuf.TsVbuuid{restartTs}); err != nil {
+	if res, err := ap.RestartVbucketsWithToken(topic, token,
+		[]*protobuf.TsVbuuid{restartTs}); err != nil {
 		c.Fatalf("KVSender::sendRestartVbuckets \n\tUnexpected Error During "+
 			"Restart Vbuckets Request for Topic %v. Err %v.",
 			topic, err)
 
+		logStartupTimings(ap, topic, res)
 		return res, err
 	} else {
 		c.Debugf("KVSender::sendRestartVbuckets \n\tRestartVbuckets Response %v", res)
+		logStartupTimings(ap, topic, res)
 		return res, nil
 	}
 }
 
+//log time spent by projector in each phase of starting streams, so that
+//slow stream startup can be attributed to cluster-info, KV or projector.
+func logStartupTimings(ap *projClient.Client, topic string,
+	res *protobuf.TopicResponse) {
+
+	for _, t := range res.GetTimings() {
+		c.Infof("KVSender::logStartupTimings Projector %v Topic %v Bucket %v "+
+			"Vbmap %v FailoverLog %v StreamRequest %v Feedback %v",
+			ap, topic, t.GetBucket(),
+			time.Duration(t.GetVbmapTime()),
+			time.Duration(t.GetFailoverLogTime()),
+			time.Duration(t.GetStreamRequestTime()),
+			time.Duration(t.GetFeedbackTime()))
+	}
+}
+
 //send the actual AddInstances request on adminport
 func sendAddInstancesRequest(ap *projClient.Client,
 	topic string,
@@ -738,6 +781,19 @@ func (k *kvSender) makeRestartTsForVbs(bucket string, tsVbuuid *c.TsVbuuid,
 	return ts, nil
 }
 
+//isBucketUUIDChanged returns true if projector failed the request as
+//bucket was flushed or recreated after its indexes were defined.
+func isBucketUUIDChanged(err error) bool {
+	return c.IsError(err, projClient.ErrorBucketUUIDChanged)
+}
+
+func bucketUUIDChangedError() *MsgError {
+	return &MsgError{
+		err: Error{code: ERROR_KVSENDER_BUCKET_UUID_CHANGED,
+			severity: FATAL,
+			cause:    c.ErrorBucketUUIDChanged}}
+}
+
 func updateActiveTsFromResponse(bucket string,
 	activeTs *protobuf.TsVbuuid, res *protobuf.TopicResponse) *protobuf.TsVbuuid {
 
@@ -975,16 +1031,19 @@ func convertIndexDefnToProtobuf(indexDefn c.IndexDefn) *protobuf.IndexDefn {
 		protobuf.PartitionScheme_value[string(indexDefn.PartitionScheme)]).Enum()
 
 	defn := &protobuf.IndexDefn{
-		DefnID:          proto.Uint64(uint64(indexDefn.DefnId)),
-		Bucket:          proto.String(indexDefn.Bucket),
-		IsPrimary:       proto.Bool(indexDefn.IsPrimary),
-		Name:            proto.String(indexDefn.Name),
-		Using:           using,
-		ExprType:        exprType,
-		SecExpressions:  indexDefn.SecExprs,
-		PartitionScheme: partnScheme,
-		PartnExpression: proto.String(indexDefn.PartitionKey),
-		WhereExpression: proto.String(indexDefn.WhereExpr),
+		DefnID:             proto.Uint64(uint64(indexDefn.DefnId)),
+		Bucket:             proto.String(indexDefn.Bucket),
+		IsPrimary:          proto.Bool(indexDefn.IsPrimary),
+		Name:               proto.String(indexDefn.Name),
+		Using:              using,
+		ExprType:           exprType,
+		SecExpressions:     indexDefn.SecExprs,
+		PartitionScheme:    partnScheme,
+		PartnExpression:    proto.String(indexDefn.PartitionKey),
+		WhereExpression:    proto.String(indexDefn.WhereExpr),
+		IncludeExpressions: indexDefn.Include,
+		BucketUUID:         proto.String(indexDefn.BucketUUID),
+		Collation:          proto.String(indexDefn.Collation),
 	}
 
 	return defn
@@ -1041,6 +1100,11 @@ func addPartnInfoToProtoInst(cfg c.Config, cinfo *c.ClusterInfoCache,
 				case c.INIT_STREAM:
 					e = c.Endpoint(streamInitAddr)
 				}
+				//stream listens on a port allocated when it was opened
+				if port := getStreamPort(streamId); port != "" {
+					host, _, _ := net.SplitHostPort(string(e))
+					e = c.Endpoint(net.JoinHostPort(host, port))
+				}
 				endpoints = append(endpoints, string(e))
 			}
 		}
@@ -1155,7 +1219,8 @@ func sendRepairEndpoints(ap *projClient.Client,
 	c.Debugf("KVSender::sendRepairEndpoints Projector %v Topic %v Endpoints %v",
 		ap, topic, endpoints)
 
-	if err := ap.RepairEndpoints(topic, endpoints); err != nil {
+	response, err := ap.RepairEndpoints(topic, endpoints)
+	if err != nil {
 		c.Errorf("KVSender::sendRepairEndpoints \n\tUnexpected Error During "+
 			"Repair Endpoints Request Topic %v Endpoints %v. Err %v",
 			topic, endpoints, err)
@@ -1164,9 +1229,20 @@ func sendRepairEndpoints(ap *projClient.Client,
 			err: Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR,
 				severity: FATAL,
 				cause:    err}}
-	} else {
-		return &MsgSuccess{}
 	}
+
+	//projector could not reconnect to some of the endpoints, the stream
+	//has to be restarted for those as repair cannot be retried.
+	for raddr, reason := range response.Failed() {
+		c.Errorf("KVSender::sendRepairEndpoints \n\tProjector Failed To "+
+			"Repair Endpoint %v Topic %v. Err %v", raddr, topic, reason)
+
+		return &MsgError{
+			err: Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR,
+				severity: FATAL,
+				cause:    reason}}
+	}
+	return &MsgSuccess{}
 }
 
 func (k *kvSender) addIndexForNewBucket(streamId c.StreamId, indexInst c.IndexInst) Message {
@@ -1202,10 +1278,13 @@ func (k *kvSender) addIndexForNewBucket(streamId c.StreamId, indexInst c.IndexIn
 		restartTs := []*protobuf.TsVbuuid{ts}
 		instances := []*protobuf.Instance{protoInst}
 
-		if _, errMsg := sendAddBucketsRequest(ap, topic, restartTs, instances); errMsg.GetMsgType() != MSG_SUCCESS {
+		res, errMsg := sendAddBucketsRequest(ap, topic, getStreamToken(streamId),
+			restartTs, instances)
+		if errMsg.GetMsgType() != MSG_SUCCESS {
 			//TODO send message to all KVs to revert the previous requests sent
 			return errMsg
 		}
+		ackStreamToken(streamId, addr, res.GetToken())
 	}
 
 	return &MsgSuccess{}
@@ -1213,14 +1292,14 @@ func (k *kvSender) addIndexForNewBucket(streamId c.StreamId, indexInst c.IndexIn
 
 //send the actual UpdateMutationStreamRequest on adminport
 func sendAddBucketsRequest(ap *projClient.Client,
-	topic string,
+	topic, token string,
 	restartTs []*protobuf.TsVbuuid,
 	instances []*protobuf.Instance) (*protobuf.TopicResponse, Message) {
 
 	c.Debugf("KVSender::sendAddBucketsRequest Projector %v Topic %v Instances %v",
 		ap, topic, instances)
 
-	if res, err := ap.AddBuckets(topic, restartTs, instances); err != nil {
+	if res, err := ap.AddBucketsWithToken(topic, token, restartTs, instances); err != nil {
 		c.Errorf("KVSender::sendAddBucketsRequest \n\tUnexpected Error During "+
 			"Mutation Stream Request %v for IndexInst %v. Err %v.",
 			topic, instances, err)
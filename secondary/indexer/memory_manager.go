@@ -0,0 +1,295 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Components of indexer sharing the memory quota of the node.
+const (
+	MEM_STORAGE_CACHE  = "storage_cache"
+	MEM_MUTATION_QUEUE = "mutation_queue"
+	MEM_SCAN_CACHE     = "scan_cache"
+)
+
+// memoryConsumer is a component of indexer whose memory is budgeted by
+// the memoryManager.
+type memoryConsumer interface {
+	// MemoryUsed returns the bytes used by the component.
+	MemoryUsed() int64
+	// SetMemoryBudget bounds the bytes the component shall use, 0 for no
+	// bound.
+	SetMemoryBudget(budget int64)
+}
+
+// memoryManager splits the memory quota of indexer, "settings.memory_quota",
+// into budgets for storage cache, mutation queues and scan cache. Storage
+// cache is sized once when slices are opened, budgets of the other
+// components are rebalanced periodically, moving memory from the component
+// under least pressure to the one running out of its budget. A zero quota
+// disables the manager, leaving every component unbounded.
+type memoryManager struct {
+	quota    int64
+	minimum  int64   // least budget of a rebalanced component
+	pressure float64 // fraction of budget used beyond which budget is raised
+	interval time.Duration
+
+	mu         sync.Mutex
+	budgets    map[string]int64
+	consumers  map[string]memoryConsumer
+	rebalances uint64
+
+	stopch StopChannel
+}
+
+func newMemoryManager(config common.Config) *memoryManager {
+	quota := int64(config["settings.memory_quota"].Uint64())
+	return &memoryManager{
+		quota:     quota,
+		minimum:   quota * int64(config["memory.minPercent"].Int()) / 100,
+		pressure:  float64(config["memory.pressurePercent"].Int()) / 100,
+		interval:  time.Duration(config["memory.rebalanceInterval"].Int()) * time.Millisecond,
+		budgets:   memoryBudgets(config),
+		consumers: make(map[string]memoryConsumer),
+		stopch:    make(StopChannel),
+	}
+}
+
+// memoryBudgets splits memory quota among components by their configured
+// share, scaled down if the shares add up to more than the quota. No
+// budgets are returned for a zero quota.
+func memoryBudgets(config common.Config) map[string]int64 {
+	budgets := make(map[string]int64)
+	quota := int64(config["settings.memory_quota"].Uint64())
+	if quota == 0 {
+		return budgets
+	}
+
+	percents := map[string]int64{
+		MEM_STORAGE_CACHE:  int64(config["memory.storageCachePercent"].Int()),
+		MEM_MUTATION_QUEUE: int64(config["memory.mutationQueuePercent"].Int()),
+		MEM_SCAN_CACHE:     int64(config["memory.scanCachePercent"].Int()),
+	}
+	var total int64
+	for _, percent := range percents {
+		total += percent
+	}
+	if total < 100 {
+		total = 100
+	}
+	for name, percent := range percents {
+		budgets[name] = quota * percent / total
+	}
+	return budgets
+}
+
+// storageCacheSize is the budget of the storage buffer cache, the whole
+// memory quota if the quota is not shared.
+func storageCacheSize(config common.Config) uint64 {
+	if size, ok := memoryBudgets(config)[MEM_STORAGE_CACHE]; ok {
+		return uint64(size)
+	}
+	return config["settings.memory_quota"].Uint64()
+}
+
+// register a component, its budget is applied immediately.
+func (m *memoryManager) register(name string, consumer memoryConsumer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.consumers[name] = consumer
+	consumer.SetMemoryBudget(m.budgets[name])
+}
+
+// run rebalances budgets every "memory.rebalanceInterval" until stopped.
+func (m *memoryManager) run() {
+	if m.quota == 0 || m.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.rebalance()
+		case <-m.stopch:
+			return
+		}
+	}
+}
+
+func (m *memoryManager) stop() {
+	close(m.stopch)
+}
+
+func (m *memoryManager) rebalance() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	budgets := make(map[string]int64)
+	used := make(map[string]int64)
+	for name, consumer := range m.consumers {
+		budgets[name] = m.budgets[name]
+		used[name] = consumer.MemoryUsed()
+	}
+	from, to, moved := rebalanceBudgets(budgets, used, m.minimum, m.pressure)
+	if moved == 0 {
+		return
+	}
+	m.budgets[from] -= moved
+	m.budgets[to] += moved
+	m.consumers[from].SetMemoryBudget(m.budgets[from])
+	m.consumers[to].SetMemoryBudget(m.budgets[to])
+	m.rebalances++
+	common.Infof("MemoryManager: moved %v bytes from %v to %v, budgets %v",
+		moved, from, to, m.budgets)
+}
+
+// rebalanceBudgets picks the component using the largest fraction of its
+// budget, if beyond `pressure`, and the one using the smallest fraction,
+// and returns half the spare budget of the latter to be moved to the
+// former. A component keeps no less than `minimum` or what it uses.
+func rebalanceBudgets(budgets, used map[string]int64,
+	minimum int64, pressure float64) (from, to string, moved int64) {
+
+	for name, budget := range budgets {
+		if budget <= 0 {
+			continue
+		}
+		if from == "" || usage(budgets, used, name) < usage(budgets, used, from) {
+			from = name
+		}
+		if to == "" || usage(budgets, used, name) > usage(budgets, used, to) {
+			to = name
+		}
+	}
+	if from == to || usage(budgets, used, to) < pressure {
+		return "", "", 0
+	}
+	keep := used[from]
+	if keep < minimum {
+		keep = minimum
+	}
+	if spare := budgets[from] - keep; spare > 1 {
+		return from, to, spare / 2
+	}
+	return "", "", 0
+}
+
+func usage(budgets, used map[string]int64, name string) float64 {
+	return float64(used[name]) / float64(budgets[name])
+}
+
+// stats returns quota, and budget and usage of every component.
+func (m *memoryManager) stats() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make(map[string]string)
+	stats["memory_quota"] = fmt.Sprint(m.quota)
+	stats["memory_rebalances"] = fmt.Sprint(m.rebalances)
+	for name, budget := range m.budgets {
+		stats["memory_budget_"+name] = fmt.Sprint(budget)
+	}
+	for name, consumer := range m.consumers {
+		stats["memory_used_"+name] = fmt.Sprint(consumer.MemoryUsed())
+		if a, ok := consumer.(*memoryAccount); ok {
+			n := atomic.LoadUint64(&a.throttles)
+			stats["memory_throttles_"+name] = fmt.Sprint(n)
+		}
+	}
+	return stats
+}
+
+// memoryAccount counts bytes used by a component against its budget,
+// safe for concurrent use from the data path.
+type memoryAccount struct {
+	used      int64
+	budget    int64
+	throttles uint64
+	waiters   int32 // callers blocked in throttle
+
+	mu     sync.Mutex
+	waitch chan struct{} // closed once bytes used are within budget
+}
+
+func (a *memoryAccount) add(n int64) {
+	atomic.AddInt64(&a.used, n)
+	if n < 0 && atomic.LoadInt32(&a.waiters) > 0 {
+		a.notify()
+	}
+}
+
+// notify callers blocked in throttle, if bytes used are within budget.
+func (a *memoryAccount) notify() {
+	if a.exceeded() {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.waitch != nil {
+		close(a.waitch)
+		a.waitch = nil
+	}
+}
+
+// exceeded returns true if bytes used are beyond a non-zero budget.
+func (a *memoryAccount) exceeded() bool {
+	budget := atomic.LoadInt64(&a.budget)
+	return budget > 0 && atomic.LoadInt64(&a.used) > budget
+}
+
+// throttle blocks the caller while bytes used are beyond budget, until
+// enough bytes are released, or upto timeout, or till stopch is closed.
+func (a *memoryAccount) throttle(timeout time.Duration, stopch StopChannel) {
+	if timeout <= 0 || !a.exceeded() {
+		return
+	}
+	atomic.AddUint64(&a.throttles, 1)
+
+	a.mu.Lock()
+	atomic.AddInt32(&a.waiters, 1)
+	if a.waitch == nil {
+		a.waitch = make(chan struct{})
+	}
+	waitch := a.waitch
+	a.mu.Unlock()
+	defer atomic.AddInt32(&a.waiters, -1)
+
+	// bytes released before registering as a waiter are not notified.
+	if !a.exceeded() {
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-waitch:
+	case <-timer.C:
+	case <-stopch:
+	}
+}
+
+// MemoryUsed implements memoryConsumer{} interface.
+func (a *memoryAccount) MemoryUsed() int64 {
+	return atomic.LoadInt64(&a.used)
+}
+
+// SetMemoryBudget implements memoryConsumer{} interface.
+func (a *memoryAccount) SetMemoryBudget(budget int64) {
+	atomic.StoreInt64(&a.budget, budget)
+	if atomic.LoadInt32(&a.waiters) > 0 {
+		a.notify()
+	}
+}
@@ -0,0 +1,93 @@
+package indexer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRebalanceBudgets(t *testing.T) {
+	budgets := map[string]int64{MEM_MUTATION_QUEUE: 300, MEM_SCAN_CACHE: 100}
+
+	used := map[string]int64{MEM_MUTATION_QUEUE: 100, MEM_SCAN_CACHE: 50}
+	if _, _, moved := rebalanceBudgets(budgets, used, 20, 0.9); moved != 0 {
+		t.Errorf("expected no rebalance without pressure, moved %v", moved)
+	}
+
+	used = map[string]int64{MEM_MUTATION_QUEUE: 100, MEM_SCAN_CACHE: 95}
+	from, to, moved := rebalanceBudgets(budgets, used, 20, 0.9)
+	if from != MEM_MUTATION_QUEUE || to != MEM_SCAN_CACHE || moved != 100 {
+		t.Errorf("unexpected rebalance %v -> %v, %v bytes", from, to, moved)
+	}
+
+	// donor keeps the minimum budget
+	used = map[string]int64{MEM_MUTATION_QUEUE: 0, MEM_SCAN_CACHE: 100}
+	if _, _, moved := rebalanceBudgets(budgets, used, 200, 0.9); moved != 50 {
+		t.Errorf("expected 50 bytes moved, got %v", moved)
+	}
+
+	// every component under pressure
+	used = map[string]int64{MEM_MUTATION_QUEUE: 300, MEM_SCAN_CACHE: 100}
+	if _, _, moved := rebalanceBudgets(budgets, used, 20, 0.9); moved != 0 {
+		t.Errorf("expected no rebalance without spare budget, moved %v", moved)
+	}
+}
+
+func TestMemoryAccount(t *testing.T) {
+	a := &memoryAccount{}
+	a.add(100)
+	if a.exceeded() {
+		t.Errorf("expected account without budget not to be exceeded")
+	}
+	a.SetMemoryBudget(50)
+	if !a.exceeded() {
+		t.Errorf("expected account to be exceeded")
+	}
+	a.add(-60)
+	if a.exceeded() || a.MemoryUsed() != 40 {
+		t.Errorf("expected 40 bytes within budget, got %v", a.MemoryUsed())
+	}
+}
+
+func TestMemoryAccountThrottle(t *testing.T) {
+	a := &memoryAccount{}
+	a.SetMemoryBudget(50)
+	a.add(100)
+
+	donech := make(chan bool)
+	go func() {
+		a.throttle(10*time.Second, nil)
+		close(donech)
+	}()
+	select {
+	case <-donech:
+		t.Fatalf("expected throttle to block while beyond budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+	a.add(-10) // still beyond budget
+	select {
+	case <-donech:
+		t.Fatalf("expected throttle to block while beyond budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+	a.add(-50)
+	select {
+	case <-donech:
+	case <-time.After(time.Second):
+		t.Fatalf("expected throttle to return once within budget")
+	}
+
+	// stop and timeout unblock callers beyond budget.
+	a.add(60)
+	stopch := make(StopChannel)
+	close(stopch)
+	a.throttle(10*time.Second, stopch)
+	start := time.Now()
+	a.throttle(10*time.Millisecond, nil)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected throttle to time out, took %v", elapsed)
+	}
+	if n := atomic.LoadUint64(&a.throttles); n != 3 {
+		t.Errorf("expected 3 throttles, got %v", n)
+	}
+}
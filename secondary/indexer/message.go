@@ -62,6 +62,11 @@ const (
 	STORAGE_INDEX_SNAP_REQUEST
 	STORAGE_INDEX_STORAGE_STATS
 	STORAGE_INDEX_COMPACT
+	STORAGE_INDEX_SNAP_LIST
+	STORAGE_INDEX_PURGE
+	STORAGE_INDEX_SCRUB
+	STORAGE_INDEX_CORRUPTED
+	STORAGE_INDEX_VERIFY
 
 	//KVSender
 	KV_SENDER_SHUTDOWN
@@ -95,11 +100,15 @@ const (
 	INDEXER_INITIATE_RECOVERY
 	INDEXER_RECOVERY_DONE
 	INDEXER_BUCKET_NOT_FOUND
+	INDEXER_BUCKET_UUID_CHANGED
 	INDEXER_ROLLBACK
 	STREAM_REQUEST_DONE
+	INDEXER_BUILD_RETRY
+	INDEXER_BUILD_FAILED
 
 	//SCAN COORDINATOR
 	SCAN_COORD_SHUTDOWN
+	SCAN_COORD_DRAIN_INDEX
 
 	COMPACTION_MGR_SHUTDOWN
 
@@ -120,6 +129,7 @@ const (
 	SCAN_STATS
 	INDEX_PROGRESS_STATS
 	INDEXER_STATS
+	INDEXER_CAPACITY_STATS
 )
 
 type Message interface {
@@ -444,6 +454,8 @@ type MsgMutMgrFlushDone struct {
 	ts       *common.TsVbuuid
 	streamId common.StreamId
 	bucket   string
+
+	flushedTs *common.TsVbuuid //timestamp the queue got flushed to
 }
 
 func (m *MsgMutMgrFlushDone) GetMsgType() MsgType {
@@ -454,6 +466,15 @@ func (m *MsgMutMgrFlushDone) GetTS() *common.TsVbuuid {
 	return m.ts
 }
 
+//GetFlushedTS returns the timestamp the mutation queue got flushed to,
+//falls back to the requested timestamp if not known.
+func (m *MsgMutMgrFlushDone) GetFlushedTS() *common.TsVbuuid {
+	if m.flushedTs == nil {
+		return m.ts
+	}
+	return m.flushedTs
+}
+
 func (m *MsgMutMgrFlushDone) GetStreamId() common.StreamId {
 	return m.streamId
 }
@@ -468,6 +489,9 @@ func (m *MsgMutMgrFlushDone) String() string {
 	str += fmt.Sprintf("\n\tStream: %v", m.streamId)
 	str += fmt.Sprintf("\n\tBucket: %v", m.bucket)
 	str += fmt.Sprintf("\n\tTS: %v", m.ts)
+	if m.flushedTs != nil {
+		str += fmt.Sprintf("\n\tFlushedTS: %v", m.flushedTs)
+	}
 	return str
 
 }
@@ -657,6 +681,27 @@ func (m *MsgDropIndex) GetString() string {
 	return str
 }
 
+//MSG_SUCCESS response to MsgDropIndex, with the outcome of draining
+//in-flight scans of the dropped index
+type MsgDropIndexResponse struct {
+	drained   int
+	cancelled int
+}
+
+func (m *MsgDropIndexResponse) GetMsgType() MsgType {
+	return MSG_SUCCESS
+}
+
+//GetDrainedScans returns the number of scans that finished in time
+func (m *MsgDropIndexResponse) GetDrainedScans() int {
+	return m.drained
+}
+
+//GetCancelledScans returns the number of scans killed at the deadline
+func (m *MsgDropIndexResponse) GetCancelledScans() int {
+	return m.cancelled
+}
+
 //TK_GET_BUCKET_HWT
 type MsgTKGetBucketHWT struct {
 	streamId common.StreamId
@@ -765,6 +810,7 @@ func (m *MsgRepairEndpoints) String() string {
 //INDEXER_INITIATE_RECOVERY
 //INDEXER_RECOVERY_DONE
 //INDEXER_BUCKET_NOT_FOUND
+//INDEXER_BUCKET_UUID_CHANGED
 type MsgRecovery struct {
 	mType     MsgType
 	streamId  common.StreamId
@@ -813,6 +859,7 @@ func (m *MsgRollback) GetRollbackTs() *common.TsVbuuid {
 type MsgIndexSnapRequest struct {
 	ts        *common.TsVbuuid
 	idxInstId common.IndexInstId
+	handle    uint64 // retained snapshot to scan, 0 for latest snapshot
 
 	// Send error or index snapshot
 	respch chan interface{}
@@ -834,6 +881,22 @@ func (m *MsgIndexSnapRequest) GetIndexId() common.IndexInstId {
 	return m.idxInstId
 }
 
+func (m *MsgIndexSnapRequest) GetHandle() uint64 {
+	return m.handle
+}
+
+type MsgIndexSnapList struct {
+	respch chan []RetainedSnapshotInfo
+}
+
+func (m *MsgIndexSnapList) GetMsgType() MsgType {
+	return STORAGE_INDEX_SNAP_LIST
+}
+
+func (m *MsgIndexSnapList) GetReplyChannel() chan []RetainedSnapshotInfo {
+	return m.respch
+}
+
 type MsgIndexStorageStats struct {
 	respch chan []IndexStorageStats
 }
@@ -859,6 +922,18 @@ func (m *MsgStatsRequest) GetReplyChannel() chan map[string]string {
 	return m.respch
 }
 
+type MsgCapacityRequest struct {
+	respch chan *common.IndexerCapacity
+}
+
+func (m *MsgCapacityRequest) GetMsgType() MsgType {
+	return INDEXER_CAPACITY_STATS
+}
+
+func (m *MsgCapacityRequest) GetReplyChannel() chan *common.IndexerCapacity {
+	return m.respch
+}
+
 type MsgIndexCompact struct {
 	instId common.IndexInstId
 	errch  chan error
@@ -876,6 +951,122 @@ func (m *MsgIndexCompact) GetErrorChannel() chan error {
 	return m.errch
 }
 
+type MsgIndexPurge struct {
+	instId common.IndexInstId
+	errch  chan error
+}
+
+func (m *MsgIndexPurge) GetMsgType() MsgType {
+	return STORAGE_INDEX_PURGE
+}
+
+func (m *MsgIndexPurge) GetInstId() common.IndexInstId {
+	return m.instId
+}
+
+func (m *MsgIndexPurge) GetErrorChannel() chan error {
+	return m.errch
+}
+
+type MsgIndexScrub struct {
+	instId common.IndexInstId
+	errch  chan error
+}
+
+func (m *MsgIndexScrub) GetMsgType() MsgType {
+	return STORAGE_INDEX_SCRUB
+}
+
+func (m *MsgIndexScrub) GetInstId() common.IndexInstId {
+	return m.instId
+}
+
+func (m *MsgIndexScrub) GetErrorChannel() chan error {
+	return m.errch
+}
+
+//STORAGE_INDEX_VERIFY
+type MsgIndexVerify struct {
+	instId common.IndexInstId
+	errch  chan error
+}
+
+func (m *MsgIndexVerify) GetMsgType() MsgType {
+	return STORAGE_INDEX_VERIFY
+}
+
+func (m *MsgIndexVerify) GetInstId() common.IndexInstId {
+	return m.instId
+}
+
+func (m *MsgIndexVerify) GetErrorChannel() chan error {
+	return m.errch
+}
+
+//STORAGE_INDEX_CORRUPTED
+type MsgIndexCorrupted struct {
+	instId common.IndexInstId
+	err    error
+}
+
+func (m *MsgIndexCorrupted) GetMsgType() MsgType {
+	return STORAGE_INDEX_CORRUPTED
+}
+
+func (m *MsgIndexCorrupted) GetInstId() common.IndexInstId {
+	return m.instId
+}
+
+func (m *MsgIndexCorrupted) GetError() error {
+	return m.err
+}
+
+//INDEXER_BUILD_FAILED
+type MsgBuildFailed struct {
+	streamId common.StreamId
+	bucket   string
+	err      error
+}
+
+func (m *MsgBuildFailed) GetMsgType() MsgType {
+	return INDEXER_BUILD_FAILED
+}
+
+func (m *MsgBuildFailed) GetStreamId() common.StreamId {
+	return m.streamId
+}
+
+func (m *MsgBuildFailed) GetBucket() string {
+	return m.bucket
+}
+
+func (m *MsgBuildFailed) GetError() error {
+	return m.err
+}
+
+//SCAN_COORD_DRAIN_INDEX
+type MsgDrainScans struct {
+	instId common.IndexInstId
+	index  string //<bucket>:<name> of the dropped index
+	respch chan scanDrainStats
+}
+
+func (m *MsgDrainScans) GetMsgType() MsgType {
+	return SCAN_COORD_DRAIN_INDEX
+}
+
+func (m *MsgDrainScans) GetInstId() common.IndexInstId {
+	return m.instId
+}
+
+func (m *MsgDrainScans) GetIndex() string {
+	return m.index
+}
+
+func (m *MsgDrainScans) GetReplyChannel() chan scanDrainStats {
+	return m.respch
+}
+
 //KV_STREAM_REPAIR
 type MsgKVStreamRepair struct {
 	streamId  common.StreamId
@@ -1063,6 +1254,8 @@ func (m MsgType) String() string {
 		return "INDEXER_RECOVERY_DONE"
 	case INDEXER_BUCKET_NOT_FOUND:
 		return "INDEXER_BUCKET_NOT_FOUND"
+	case INDEXER_BUCKET_UUID_CHANGED:
+		return "INDEXER_BUCKET_UUID_CHANGED"
 	case INDEXER_ROLLBACK:
 		return "INDEXER_ROLLBACK"
 	case STREAM_REQUEST_DONE:
@@ -1070,6 +1263,8 @@ func (m MsgType) String() string {
 
 	case SCAN_COORD_SHUTDOWN:
 		return "SCAN_COORD_SHUTDOWN"
+	case SCAN_COORD_DRAIN_INDEX:
+		return "SCAN_COORD_DRAIN_INDEX"
 
 	case UPDATE_INDEX_INSTANCE_MAP:
 		return "UPDATE_INDEX_INSTANCE_MAP"
@@ -1120,12 +1315,31 @@ func (m MsgType) String() string {
 		return "STORAGE_INDEX_SNAP_REQUEST"
 	case STORAGE_INDEX_STORAGE_STATS:
 		return "STORAGE_INDEX_STORAGE_STATS"
+	case STORAGE_INDEX_SNAP_LIST:
+		return "STORAGE_INDEX_SNAP_LIST"
 	case STORAGE_INDEX_COMPACT:
 		return "STORAGE_INDEX_COMPACT"
+	case STORAGE_INDEX_PURGE:
+		return "STORAGE_INDEX_PURGE"
+	case STORAGE_INDEX_SCRUB:
+		return "STORAGE_INDEX_SCRUB"
+	case STORAGE_INDEX_CORRUPTED:
+		return "STORAGE_INDEX_CORRUPTED"
+	case STORAGE_INDEX_VERIFY:
+		return "STORAGE_INDEX_VERIFY"
 
 	case CONFIG_SETTINGS_UPDATE:
 		return "CONFIG_SETTINGS_UPDATE"
 
+	case INDEXER_CAPACITY_STATS:
+		return "INDEXER_CAPACITY_STATS"
+
+	case INDEXER_BUILD_RETRY:
+		return "INDEXER_BUILD_RETRY"
+
+	case INDEXER_BUILD_FAILED:
+		return "INDEXER_BUILD_FAILED"
+
 	default:
 		return "UNKNOWN_MSG_TYPE"
 	}
@@ -14,6 +14,7 @@ import (
 
 	"errors"
 	"sync"
+	"time"
 )
 
 //MutationManager handles messages from Indexer to manage Mutation Streams
@@ -47,7 +48,15 @@ type mutationMgr struct {
 	indexInstMap  common.IndexInstMap
 	indexPartnMap IndexPartnMap
 
-	numVbuckets uint16 //number of vbuckets
+	numVbuckets      uint16 //number of vbuckets
+	numStreamWorkers int    //number of workers per stream reader
+
+	queueMem         *memoryAccount //memory of all mutation queues
+	throttleInterval time.Duration  //pause of stream readers beyond memory budget
+
+	bulkBatchSize int //entries per sorted run when flushing initial build
+
+	streamAddrs []string //addresses to allocate a dedicated port per stream
 
 	flusherWaitGroup sync.WaitGroup
 
@@ -67,7 +76,7 @@ type mutationMgr struct {
 //supvRespch to indicate its completion or any error that may have happened.
 //If supvRespch or supvCmdch is closed, mutation manager will termiate its loop.
 func NewMutationManager(supvCmdch MsgChannel, supvRespch MsgChannel,
-	config common.Config) (MutationManager, Message) {
+	config common.Config, memMgr *memoryManager) (MutationManager, Message) {
 
 	//Init the mutationMgr struct
 	m := &mutationMgr{
@@ -83,8 +92,25 @@ func NewMutationManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 		supvCmdch:              supvCmdch,
 		supvRespch:             supvRespch,
 		numVbuckets:            uint16(config["numVbuckets"].Int()),
+		numStreamWorkers:       config["streamReader.numWorkers"].Int(),
+		queueMem:               &memoryAccount{},
+		throttleInterval: time.Millisecond *
+			time.Duration(config["memory.throttleInterval"].Int()),
+		bulkBatchSize: config["bulkLoad.batchSize"].Int(),
 	}
 
+	if m.numStreamWorkers <= 0 {
+		m.numStreamWorkers = DEFAULT_NUM_STREAM_READER_WORKERS
+	}
+	portRange := config["streamPortRange"].String()
+	if addrs, err := streamPortRange(portRange); err != nil {
+		common.Errorf("MutationMgr: Invalid streamPortRange %q, streams "+
+			"will listen on their configured ports", portRange)
+	} else {
+		m.streamAddrs = addrs
+	}
+	memMgr.register(MEM_MUTATION_QUEUE, m.queueMem)
+
 	//start Mutation Manager loop which listens to commands from its supervisor
 	go m.run()
 
@@ -318,7 +344,7 @@ func (m *mutationMgr) handleOpenStream(cmd Message) {
 		if _, ok := bucketQueueMap[i.Defn.Bucket]; !ok {
 			//init mutation queue
 			var queue MutationQueue
-			if queue = NewAtomicMutationQueue(m.numVbuckets); queue == nil {
+			if queue = NewAtomicMutationQueue(m.numVbuckets, m.queueMem); queue == nil {
 				m.supvCmdch <- &MsgError{
 					err: Error{code: ERROR_MUTATION_QUEUE_INIT,
 						severity: FATAL,
@@ -344,7 +370,8 @@ func (m *mutationMgr) handleOpenStream(cmd Message) {
 	cmdCh := make(MsgChannel)
 
 	reader, errMsg := CreateMutationStreamReader(streamId, bucketQueueMap,
-		cmdCh, m.mutMgrRecvCh, DEFAULT_NUM_STREAM_READER_WORKERS)
+		cmdCh, m.mutMgrRecvCh, m.numStreamWorkers, m.queueMem, m.throttleInterval,
+		m.streamAddrs)
 
 	if reader == nil {
 		//send the error back on supv channel
@@ -414,7 +441,7 @@ func (m *mutationMgr) addIndexListToExistingStream(streamId common.StreamId,
 		if _, ok := bucketQueueMap[i.Defn.Bucket]; !ok {
 			//init mutation queue
 			var queue MutationQueue
-			if queue = NewAtomicMutationQueue(m.numVbuckets); queue == nil {
+			if queue = NewAtomicMutationQueue(m.numVbuckets, m.queueMem); queue == nil {
 				return &MsgError{
 					err: Error{code: ERROR_MUTATION_QUEUE_INIT,
 						severity: FATAL,
@@ -500,6 +527,7 @@ func (m *mutationMgr) handleRemoveIndexListFromStream(cmd Message) {
 			}
 		}
 		if dropBucket == true {
+			m.releaseQueueMem(bq)
 			delete(bucketQueueMap, b)
 			bucketMapDirty = true
 		}
@@ -559,8 +587,9 @@ func (m *mutationMgr) handleRemoveBucketFromStream(cmd Message) {
 		}
 	}
 
-	if _, ok := bucketQueueMap[bucket]; ok {
+	if bq, ok := bucketQueueMap[bucket]; ok {
 		bucketMapDirty = true
+		m.releaseQueueMem(bq)
 		delete(bucketQueueMap, bucket)
 	}
 
@@ -758,9 +787,19 @@ func (m *mutationMgr) sendMsgToStreamReader(streamId common.StreamId, msg Messag
 
 }
 
+//releaseQueueMem stops counting mutations left in a dropped queue
+//against the memory of mutation queues
+func (m *mutationMgr) releaseQueueMem(q IndexerMutationQueue) {
+	q.queue.ReleaseMem()
+}
+
 //cleanupStream cleans up internal structs for the given stream
 func (m *mutationMgr) cleanupStream(streamId common.StreamId) {
 
+	for _, bq := range m.streamBucketQueueMap[streamId] {
+		m.releaseQueueMem(bq)
+	}
+
 	//cleanup internal maps for this stream
 	delete(m.streamReaderMap, streamId)
 	delete(m.streamBucketQueueMap, streamId)
@@ -778,6 +817,9 @@ func (m *mutationMgr) cleanupStream(streamId common.StreamId) {
 //Indexer. Success is sent on the supervisor Cmd channel
 //if the flush can be processed. Once the queue gets persisted,
 //status is sent on the supervisor Response channel.
+//Queue is persisted only upto the stability timestamp of the bucket,
+//later mutations remain queued, and MUT_MGR_FLUSH_DONE carries the
+//timestamp the queue got flushed to.
 func (m *mutationMgr) handlePersistMutationQueue(cmd Message) {
 
 	common.Tracef("MutationMgr::handlePersistMutationQueue %v", cmd)
@@ -809,7 +851,7 @@ func (m *mutationMgr) persistMutationQueue(q IndexerMutationQueue,
 	go func() {
 		defer m.flusherWaitGroup.Done()
 
-		flusher := NewFlusher()
+		flusher := NewFlusher(m.bulkBatchSize)
 		sts := getStabilityTSFromTsVbuuid(ts)
 		msgch := flusher.PersistUptoTS(q.queue,
 			streamId, m.indexInstMap, m.indexPartnMap, sts, stopch)
@@ -827,10 +869,15 @@ func (m *mutationMgr) persistMutationQueue(q IndexerMutationQueue,
 
 		//send the response to supervisor
 		if msg.GetMsgType() == MSG_SUCCESS {
+			var flushedTs *common.TsVbuuid
+			if tsMsg, ok := msg.(*MsgTimestamp); ok {
+				flushedTs = getFlushedTsVbuuid(ts, tsMsg.GetTimestamp())
+			}
 			m.supvRespch <- &MsgMutMgrFlushDone{mType: MUT_MGR_FLUSH_DONE,
-				streamId: streamId,
-				bucket:   bucket,
-				ts:       ts}
+				streamId:  streamId,
+				bucket:    bucket,
+				ts:        ts,
+				flushedTs: flushedTs}
 		} else {
 			m.supvRespch <- msg
 		}
@@ -838,6 +885,21 @@ func (m *mutationMgr) persistMutationQueue(q IndexerMutationQueue,
 
 }
 
+//getFlushedTsVbuuid returns a copy of the stability timestamp `ts` with
+//seqnos set to what each vbucket actually got flushed to, which lags `ts`
+//for vbuckets whose flush was stopped before reaching it.
+func getFlushedTsVbuuid(ts *common.TsVbuuid,
+	flushed Timestamp) *common.TsVbuuid {
+
+	flushedTs := ts.Copy()
+	for i, seqno := range flushed {
+		if i < len(flushedTs.Seqnos) && uint64(seqno) < flushedTs.Seqnos[i] {
+			flushedTs.Seqnos[i] = uint64(seqno)
+		}
+	}
+	return flushedTs
+}
+
 //handleDrainMutationQueue handles drain queue message from
 //supervisor. Success is sent on the supervisor Cmd channel
 //if the flush can be processed. Once the queue gets drained,
@@ -872,7 +934,7 @@ func (m *mutationMgr) drainMutationQueue(q IndexerMutationQueue,
 	go func() {
 		defer m.flusherWaitGroup.Done()
 
-		flusher := NewFlusher()
+		flusher := NewFlusher(m.bulkBatchSize)
 		sts := getStabilityTSFromTsVbuuid(ts)
 		msgch := flusher.DrainUptoTS(q.queue, streamId,
 			sts, stopch)
@@ -909,7 +971,7 @@ func (m *mutationMgr) handleGetMutationQueueHWT(cmd Message) {
 	q := m.streamBucketQueueMap[streamId][bucket]
 
 	go func() {
-		flusher := NewFlusher()
+		flusher := NewFlusher(m.bulkBatchSize)
 		ts := flusher.GetQueueHWT(q.queue)
 		m.supvCmdch <- &MsgTimestamp{ts: ts}
 	}()
@@ -929,7 +991,7 @@ func (m *mutationMgr) handleGetMutationQueueLWT(cmd Message) {
 	q := m.streamBucketQueueMap[streamId][bucket]
 
 	go func() {
-		flusher := NewFlusher()
+		flusher := NewFlusher(m.bulkBatchSize)
 		ts := flusher.GetQueueLWT(q.queue)
 		m.supvCmdch <- &MsgTimestamp{ts: ts}
 	}()
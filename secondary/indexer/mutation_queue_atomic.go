@@ -11,6 +11,7 @@ package indexer
 
 import (
 	"errors"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -27,6 +28,10 @@ type MutationQueue interface {
 	Dequeue(vbucket Vbucket) (<-chan *MutationKeys, chan<- bool, error)
 	//dequeue a vbucket's mutation upto seqno(wait if not available)
 	DequeueUptoSeqno(vbucket Vbucket, seqno Seqno) (<-chan *MutationKeys, error)
+	//dequeue a vbucket's mutation upto seqno(wait if not available) or
+	//till stopch is closed, mutations not yet sent stay in queue
+	DequeueUptoSeqnoOrStop(vbucket Vbucket, seqno Seqno,
+		stopch StopChannel) (<-chan *MutationKeys, error)
 	//dequeue single element for a vbucket and return
 	DequeueSingleElement(vbucket Vbucket) *MutationKeys
 
@@ -38,6 +43,11 @@ type MutationQueue interface {
 	//return size of queue per vbucket
 	GetSize(vbucket Vbucket) int64
 
+	//return bytes held by mutations in the queue
+	GetMemUsed() int64
+	//stop counting bytes held by mutations in shared memory
+	ReleaseMem()
+
 	//returns the numbers of vbuckets for the queue
 	GetNumVbuckets() uint16
 }
@@ -62,16 +72,21 @@ type atomicMutationQueue struct {
 	free        []*node          //free pointer per vbucket queue
 	numVbuckets uint16           //num vbuckets for the queue
 	size        []int64          //size of queue per vbucket
+	memUsed     int64            //bytes held by mutations in queue
+	mem         *memoryAccount   //memory shared with other queues, if not nil
+	memMu       sync.RWMutex     //orders ReleaseMem with accounting
 }
 
-//NewAtomicMutationQueue allocates a new Atomic Mutation Queue and initializes it
-func NewAtomicMutationQueue(numVbuckets uint16) *atomicMutationQueue {
+//NewAtomicMutationQueue allocates a new Atomic Mutation Queue and initializes it.
+//Bytes held by mutations are also counted in mem, if not nil.
+func NewAtomicMutationQueue(numVbuckets uint16, mem *memoryAccount) *atomicMutationQueue {
 
 	q := &atomicMutationQueue{head: make([]unsafe.Pointer, numVbuckets),
 		tail:        make([]unsafe.Pointer, numVbuckets),
 		free:        make([]*node, numVbuckets),
 		size:        make([]int64, numVbuckets),
 		numVbuckets: numVbuckets,
+		mem:         mem,
 	}
 
 	var x uint16
@@ -116,6 +131,7 @@ func (q *atomicMutationQueue) Enqueue(mutation *MutationKeys, vbucket Vbucket) e
 	atomic.StorePointer(&q.tail[vbucket], unsafe.Pointer(tail.next))
 
 	atomic.AddInt64(&q.size[vbucket], 1)
+	q.addMemUsed(mutation.Size())
 
 	return nil
 
@@ -131,23 +147,41 @@ func (q *atomicMutationQueue) Enqueue(mutation *MutationKeys, vbucket Vbucket) e
 func (q *atomicMutationQueue) DequeueUptoSeqno(vbucket Vbucket, seqno Seqno) (
 	<-chan *MutationKeys, error) {
 
+	return q.DequeueUptoSeqnoOrStop(vbucket, seqno, nil)
+
+}
+
+//DequeueUptoSeqnoOrStop is same as DequeueUptoSeqno, except that it also
+//closes the mutation channel once stopch is closed. A mutation is removed
+//from the queue only after it has been received by the caller.
+func (q *atomicMutationQueue) DequeueUptoSeqnoOrStop(vbucket Vbucket,
+	seqno Seqno, stopch StopChannel) (<-chan *MutationKeys, error) {
+
 	datach := make(chan *MutationKeys)
 
-	go q.dequeueUptoSeqno(vbucket, seqno, datach)
+	go q.dequeueUptoSeqno(vbucket, seqno, datach, stopch)
 
 	return datach, nil
 
 }
 
 func (q *atomicMutationQueue) dequeueUptoSeqno(vbucket Vbucket, seqno Seqno,
-	datach chan *MutationKeys) {
+	datach chan *MutationKeys, stopch StopChannel) {
 
 	//every DEQUEUE_POLL_INTERVAL milliseconds, check for new mutations
 	ticker := time.NewTicker(time.Millisecond * DEQUEUE_POLL_INTERVAL)
+	defer ticker.Stop()
+	defer close(datach)
 
 	dequeueCount := 0
 
-	for _ = range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopch:
+			return
+		}
+
 		for atomic.LoadPointer(&q.head[vbucket]) !=
 			atomic.LoadPointer(&q.tail[vbucket]) { //if queue is nonempty
 
@@ -155,18 +189,21 @@ func (q *atomicMutationQueue) dequeueUptoSeqno(vbucket Vbucket, seqno Seqno,
 			//copy the mutation pointer
 			m := head.next.mutation
 			if seqno >= m.meta.seqno {
+				//send mutation to caller
+				select {
+				case datach <- m:
+				case <-stopch:
+					return
+				}
 				//move head to next
 				atomic.StorePointer(&q.head[vbucket], unsafe.Pointer(head.next))
 				atomic.AddInt64(&q.size[vbucket], -1)
-				//send mutation to caller
-				datach <- m
+				q.addMemUsed(-m.Size())
 				dequeueCount++
 			}
 
 			//once the seqno is reached, close the channel
 			if seqno <= m.meta.seqno {
-				ticker.Stop()
-				close(datach)
 				return
 			}
 		}
@@ -176,8 +213,6 @@ func (q *atomicMutationQueue) dequeueUptoSeqno(vbucket Vbucket, seqno Seqno,
 		//TODO: make this an input parameter if this call should block and wait in case
 		//of empty queue or not
 		if dequeueCount == 0 {
-			ticker.Stop()
-			close(datach)
 			return
 		}
 	}
@@ -239,6 +274,7 @@ func (q *atomicMutationQueue) DequeueSingleElement(vbucket Vbucket) *MutationKey
 		//move head to next
 		atomic.StorePointer(&q.head[vbucket], unsafe.Pointer(head.next))
 		atomic.AddInt64(&q.size[vbucket], -1)
+		q.addMemUsed(-m.Size())
 		return m
 	}
 	return nil
@@ -269,6 +305,32 @@ func (q *atomicMutationQueue) GetSize(vbucket Vbucket) int64 {
 	return atomic.LoadInt64(&q.size[vbucket])
 }
 
+//GetMemUsed returns the bytes held by mutations in the queue
+func (q *atomicMutationQueue) GetMemUsed() int64 {
+	return atomic.LoadInt64(&q.memUsed)
+}
+
+func (q *atomicMutationQueue) addMemUsed(n int64) {
+	q.memMu.RLock()
+	defer q.memMu.RUnlock()
+	atomic.AddInt64(&q.memUsed, n)
+	if q.mem != nil {
+		q.mem.add(n)
+	}
+}
+
+//ReleaseMem releases bytes held by mutations in the queue from the memory
+//shared with other queues, and stops counting them there from now on.
+//Used when a queue is dropped with mutations still in it.
+func (q *atomicMutationQueue) ReleaseMem() {
+	q.memMu.Lock()
+	defer q.memMu.Unlock()
+	if q.mem != nil {
+		q.mem.add(-atomic.LoadInt64(&q.memUsed))
+		q.mem = nil
+	}
+}
+
 //GetNumVbuckets returns the numbers of vbuckets for the queue
 func (q *atomicMutationQueue) GetNumVbuckets() uint16 {
 	return q.numVbuckets
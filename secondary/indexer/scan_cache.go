@@ -0,0 +1,217 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// A small LRU cache of scan results. Entries are keyed by the scan
+// parameters along with the version of the snapshot used to serve the
+// scan, so a cached entry becomes unreachable as soon as a newer snapshot
+// is available for the index.
+type scanCache struct {
+	mu      sync.Mutex
+	size    int
+	maxRows uint64
+	entries map[string]*list.Element
+	lru     *list.List
+
+	bytes    int64 // bytes of cached responses
+	maxBytes int64 // budget from memory manager, 0 for no bound
+
+	hits   uint64
+	misses uint64
+}
+
+// Cached response messages of a single scan
+type scanCacheEntry struct {
+	key    string
+	instId common.IndexInstId
+	msgs   []interface{}
+	rows   uint64
+	bytes  uint64
+}
+
+// newScanCache returns a cache holding at most `size` scan results, each
+// result having no more than `maxRows` rows. A size of 0 disables caching.
+func newScanCache(size int, maxRows uint64) *scanCache {
+	return &scanCache{
+		size:    size,
+		maxRows: maxRows,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *scanCache) Enabled() bool {
+	return c.size > 0
+}
+
+// Get returns the cached entry for key and marks it as most recently used.
+func (c *scanCache) Get(key string) (*scanCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+
+	atomic.AddUint64(&c.hits, 1)
+	c.lru.MoveToFront(e)
+	return e.Value.(*scanCacheEntry), true
+}
+
+// Put adds an entry to the cache, evicting the least recently used
+// entries when the cache is full or beyond its memory budget.
+func (c *scanCache) Put(entry *scanCacheEntry) {
+	if !c.Enabled() || entry.rows > c.maxRows {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxBytes > 0 && int64(entry.bytes) > c.maxBytes {
+		return
+	}
+
+	if e, ok := c.entries[entry.key]; ok {
+		c.bytes -= int64(e.Value.(*scanCacheEntry).bytes)
+		e.Value = entry
+		c.lru.MoveToFront(e)
+	} else {
+		c.entries[entry.key] = c.lru.PushFront(entry)
+	}
+	c.bytes += int64(entry.bytes)
+	c.evict()
+}
+
+// evict least recently used entries beyond cache size or memory budget.
+func (c *scanCache) evict() {
+	for c.lru.Len() > 0 {
+		if c.lru.Len() <= c.size && (c.maxBytes == 0 || c.bytes <= c.maxBytes) {
+			return
+		}
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// MemoryUsed implements memoryConsumer{} interface.
+func (c *scanCache) MemoryUsed() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
+
+// SetMemoryBudget implements memoryConsumer{} interface, entries beyond
+// the budget are evicted.
+func (c *scanCache) SetMemoryBudget(budget int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxBytes = budget
+	c.evict()
+}
+
+// Purge removes all entries belonging to index instances that are
+// not present in indexInstMap.
+func (c *scanCache) Purge(indexInstMap common.IndexInstMap) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if _, ok := indexInstMap[e.Value.(*scanCacheEntry).instId]; !ok {
+			c.removeElement(e)
+		}
+		e = next
+	}
+}
+
+func (c *scanCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Stats returns the number of cache hits and misses so far.
+func (c *scanCache) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+func (c *scanCache) removeElement(e *list.Element) {
+	c.lru.Remove(e)
+	c.bytes -= int64(e.Value.(*scanCacheEntry).bytes)
+	delete(c.entries, e.Value.(*scanCacheEntry).key)
+}
+
+// scanCacheKey identifies a scan by index instance, scan parameters,
+// consistency timestamp and the version of the snapshot being scanned.
+// Keys, filter and group are length prefixed so that no two scans
+// share a key.
+func scanCacheKey(instId common.IndexInstId, p *scanParams,
+	snapTs *common.TsVbuuid) string {
+
+	key := []byte(fmt.Sprintf("%v:%v:%v:%v:%v:%v:%v", instId, p.scanType,
+		p.incl, p.limit, p.pageSize, len(p.keys), p.ordered))
+	key = appendCacheKeyPart(key, p.low.Raw())
+	key = appendCacheKeyPart(key, p.high.Raw())
+	for _, k := range p.keys {
+		key = appendCacheKeyPart(key, k.Raw())
+	}
+	var filter, group string
+	if p.filter != nil {
+		filter = p.filter.String()
+	}
+	if p.group != nil {
+		group = p.group.String()
+	}
+	key = appendCacheKeyPart(key, []byte(filter))
+	key = appendCacheKeyPart(key, []byte(group))
+
+	return fmt.Sprintf("%s@%x@%x", key, tsVersion(p.ts), tsVersion(snapTs))
+}
+
+// appendCacheKeyPart appends `part` to `key` prefixed with its length.
+func appendCacheKeyPart(key, part []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	key = append(key, n[:binary.PutUvarint(n[:], uint64(len(part)))]...)
+	return append(key, part...)
+}
+
+// tsVersion hashes the seqnos and vbuuids of a timestamp.
+func tsVersion(ts *common.TsVbuuid) uint64 {
+	h := fnv.New64a()
+	if ts == nil {
+		return h.Sum64()
+	}
+
+	buf := make([]byte, 8)
+	for i, seqno := range ts.Seqnos {
+		binary.BigEndian.PutUint64(buf, seqno)
+		h.Write(buf)
+		if i < len(ts.Vbuuids) {
+			binary.BigEndian.PutUint64(buf, ts.Vbuuids[i])
+			h.Write(buf)
+		}
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,109 @@
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+)
+
+func TestScanCacheEviction(t *testing.T) {
+	c := newScanCache(2, 10)
+
+	c.Put(&scanCacheEntry{key: "a", instId: 1})
+	c.Put(&scanCacheEntry{key: "b", instId: 1})
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("expected entry a to be cached")
+	}
+
+	// b is now least recently used
+	c.Put(&scanCacheEntry{key: "c", instId: 1})
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expected entry b to be evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %v", c.Len())
+	}
+
+	hits, misses := c.Stats()
+	if hits != 1 || misses != 1 {
+		t.Errorf("unexpected stats hits:%v misses:%v", hits, misses)
+	}
+}
+
+func TestScanCacheLimits(t *testing.T) {
+	c := newScanCache(0, 10)
+	c.Put(&scanCacheEntry{key: "a"})
+	if c.Len() != 0 {
+		t.Errorf("expected disabled cache to be empty")
+	}
+
+	c = newScanCache(2, 10)
+	c.Put(&scanCacheEntry{key: "a", rows: 11})
+	if c.Len() != 0 {
+		t.Errorf("expected large result not to be cached")
+	}
+}
+
+func TestScanCachePurge(t *testing.T) {
+	c := newScanCache(4, 10)
+	c.Put(&scanCacheEntry{key: "a", instId: 1})
+	c.Put(&scanCacheEntry{key: "b", instId: 2})
+
+	c.Purge(common.IndexInstMap{2: common.IndexInst{InstId: 2}})
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("expected entries of dropped index to be purged")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Errorf("expected entry b to be cached")
+	}
+}
+
+func TestScanCacheKeySnapshot(t *testing.T) {
+	p := &scanParams{scanType: queryScanAll, limit: 10}
+	ts1 := common.NewTsVbuuid("default", 4)
+	ts2 := common.NewTsVbuuid("default", 4)
+
+	if scanCacheKey(1, p, ts1) != scanCacheKey(1, p, ts2) {
+		t.Errorf("expected same key for identical snapshots")
+	}
+
+	ts2.Seqnos[0] = 100
+	if scanCacheKey(1, p, ts1) == scanCacheKey(1, p, ts2) {
+		t.Errorf("expected different key for a newer snapshot")
+	}
+}
+
+func TestScanCacheKeyRanges(t *testing.T) {
+	// joined by ":" both ranges read "a:b:c".
+	p1 := &scanParams{scanType: queryScan,
+		low: Key{raw: []byte("a:b")}, high: Key{raw: []byte("c")}}
+	p2 := &scanParams{scanType: queryScan,
+		low: Key{raw: []byte("a")}, high: Key{raw: []byte("b:c")}}
+	if scanCacheKey(1, p1, nil) == scanCacheKey(1, p2, nil) {
+		t.Errorf("expected different key for different ranges")
+	}
+}
+
+func TestScanCacheMemoryBudget(t *testing.T) {
+	c := newScanCache(4, 10)
+	c.SetMemoryBudget(100)
+	c.Put(&scanCacheEntry{key: "a", bytes: 40})
+	c.Put(&scanCacheEntry{key: "b", bytes: 40})
+	c.Put(&scanCacheEntry{key: "c", bytes: 101})
+	if c.Len() != 2 || c.MemoryUsed() != 80 {
+		t.Errorf("expected entry beyond budget not to be cached")
+	}
+
+	// a is evicted to make room for d
+	c.Put(&scanCacheEntry{key: "d", bytes: 40})
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("expected entry a to be evicted")
+	}
+	if c.MemoryUsed() != 80 {
+		t.Errorf("expected 80 bytes cached, got %v", c.MemoryUsed())
+	}
+
+	c.SetMemoryBudget(50)
+	if c.Len() != 1 || c.MemoryUsed() != 40 {
+		t.Errorf("expected lowered budget to evict entries, got %v bytes", c.MemoryUsed())
+	}
+}
@@ -13,11 +13,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/couchbase/cbauth"
 	"github.com/couchbase/indexing/secondary/common"
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 	"github.com/couchbase/indexing/secondary/queryport"
 	"github.com/couchbaselabs/goprotobuf/proto"
 	"net"
+	"net/http"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -34,8 +37,9 @@ var (
 	ErrNotMyIndex         = errors.New("Not my index")
 	ErrIndexNotReady      = errors.New("Index not ready")
 	ErrInternal           = errors.New("Internal server error occured")
-	ErrSnapNotAvailable   = errors.New("No snapshot available for scan")
+	ErrSnapNotAvailable   = common.ErrorSnapshotNotReady
 	ErrScanTimedOut       = errors.New("Index scan timed out")
+	ErrScanKilled         = common.ErrorScanKilled
 )
 
 type scanType string
@@ -45,15 +49,33 @@ const (
 	queryCount   scanType = "count"
 	queryScan    scanType = "scan"
 	queryScanAll scanType = "scanall"
+	queryLookup  scanType = "lookup"
+	// group entries by a prefix of secondary key and aggregate groups
+	queryGroupAggr scanType = "groupaggr"
 )
 
 // Internal scan handle for a request
 type scanDescriptor struct {
-	scanId    uint64
-	p         *scanParams
-	isPrimary bool
-	stopch    StopChannel
-	timeoutch <-chan time.Time
+	// accounting, updated atomically by the response reader
+	rows          int64
+	bytesRead     int64
+	bytesBuffered int64 // bytes read from snapshot, not yet sent to client
+
+	scanId     uint64
+	instId     common.IndexInstId
+	p          *scanParams
+	isPrimary  bool
+	isCovering bool
+	stopch     StopChannel
+	timeoutch  <-chan time.Time
+	startTime  time.Time
+	client     string // remote address of the queryport connection
+
+	// closed when the scan is killed through the admin API
+	killch chan struct{}
+	killed bool // protected by activeScans
+	// closed when the scan is finished and removed from activeScans
+	donech chan struct{}
 
 	respch chan interface{}
 }
@@ -73,7 +95,8 @@ func (sd scanDescriptor) String() string {
 	}
 
 	if len(sd.p.keys) == 0 {
-		if sd.p.scanType == queryStats || sd.p.scanType == queryScan {
+		if sd.p.scanType == queryStats || sd.p.scanType == queryScan ||
+			sd.p.scanType == queryGroupAggr {
 			span = fmt.Sprintf("range (%s,%s %s)", string(sd.p.low.Raw()),
 				string(sd.p.high.Raw()), incl)
 		} else {
@@ -90,6 +113,14 @@ func (sd scanDescriptor) String() string {
 	str := fmt.Sprintf("scan id: %v, index: %v/%v, type: %v, span: %s", sd.scanId,
 		sd.p.bucket, sd.p.indexName, sd.p.scanType, span)
 
+	if sd.p.filter != nil {
+		str += fmt.Sprintf(" filter: %v", sd.p.filter)
+	}
+
+	if sd.p.group != nil {
+		str += fmt.Sprintf(" group: %v", sd.p.group)
+	}
+
 	if sd.p.pageSize > 0 {
 		str += fmt.Sprintf(" pagesize: %d", sd.p.pageSize)
 	}
@@ -98,6 +129,10 @@ func (sd scanDescriptor) String() string {
 		str += fmt.Sprintf(" limit: %d", sd.p.limit)
 	}
 
+	if sd.p.snapshot > 0 {
+		str += fmt.Sprintf(" snapshot: %d", sd.p.snapshot)
+	}
+
 	return str
 }
 
@@ -114,12 +149,20 @@ type scanParams struct {
 	incl      Inclusion
 	limit     int64
 	pageSize  int64
+	filter    *scanFilter // return only entries matching the filter
+	group     *scanGroup  // aggregate entries by groups of leading keys
+
+	withCursor bool   // return a cursor if the scan stops at limit
+	ordered    bool   // merge entries of all slices in index order
+	cursor     string // resume the scan from this cursor
+	snapshot   uint64 // scan the retained snapshot with this handle
 }
 
 type statsResponse struct {
 	min, max Key
 	unique   uint64
 	count    uint64
+	bins     []KeyBin
 }
 
 type countResponse struct {
@@ -138,6 +181,11 @@ type scanStreamReader struct {
 	count     int64
 	bytesRead int64
 	hasNext   bool
+	truncated bool // scan was stopped by the limit
+	lastKey   Key
+
+	groups  *groupAggregator // folds entries of a group-by scan
+	rowsBuf []*groupRow
 }
 
 func newResponseReader(sd *scanDescriptor) *scanStreamReader {
@@ -146,6 +194,9 @@ func newResponseReader(sd *scanDescriptor) *scanStreamReader {
 	r.keysBuf = new([]Key)
 	r.hasNext = true
 	r.bufSize = 0
+	if sd.p.group != nil {
+		r.groups = newGroupAggregator(sd.p.group)
+	}
 	return r
 }
 
@@ -160,19 +211,35 @@ loop:
 		case resp, r.hasNext = <-r.sd.respch:
 		case <-r.sd.timeoutch:
 			resp = ErrScanTimedOut
+		case <-r.sd.killch:
+			resp = common.CountError(ErrScanKilled)
 		}
 		if r.hasNext {
 			switch resp.(type) {
 			case Key:
+				k := resp.(Key)
+				// Filter constraint, filtered entries do not count
+				// against limit
+				if r.sd.p.filter != nil {
+					var ok bool
+					if ok, err = r.sd.p.filter.Match(k); err != nil {
+						r.Done()
+						return
+					} else if !ok {
+						continue
+					}
+				}
+
 				// Limit constraint
 				if r.sd.p.limit > 0 && r.sd.p.limit == r.count {
+					r.truncated = true
 					r.Done()
 					break loop
 				}
 
-				k := resp.(Key)
 				sz := int64(len(k.Raw()))
 				r.bytesRead += sz
+				r.lastKey = k
 				// Page size constraint
 				if r.bufSize > 0 && r.bufSize+sz > r.sd.p.pageSize {
 					keys = r.keysBuf
@@ -180,12 +247,14 @@ loop:
 					r.keysBuf = new([]Key)
 					*r.keysBuf = append(*r.keysBuf, k)
 					r.count++
+					r.account()
 					return
 				}
 
 				r.bufSize += sz
 				*r.keysBuf = append(*r.keysBuf, k)
 				r.count++
+				r.account()
 			case error:
 				err = resp.(error)
 				r.Done()
@@ -201,12 +270,116 @@ loop:
 
 	keys = r.keysBuf
 	r.keysBuf = new([]Key)
+	r.bufSize = 0
+	r.account()
 
 	return
 }
 
+// Read a chunk of group rows from scan results, entries are folded into
+// groups as they are read and a group is returned once complete. Limit
+// applies to the number of groups, page size to the bytes of rows.
+func (r *scanStreamReader) ReadGroupBatch() (rows []*groupRow, done bool, err error) {
+	var resp interface{}
+
+	for r.hasNext {
+		select {
+		case resp, r.hasNext = <-r.sd.respch:
+		case <-r.sd.timeoutch:
+			resp = ErrScanTimedOut
+		case <-r.sd.killch:
+			resp = common.CountError(ErrScanKilled)
+		}
+
+		var row *groupRow
+		if !r.hasNext {
+			// All entries are read, last group is complete
+			if row, err = r.groups.Flush(); err != nil {
+				return
+			}
+		} else {
+			switch val := resp.(type) {
+			case Key:
+				// Filter constraint, filtered entries are not aggregated
+				if r.sd.p.filter != nil {
+					var ok bool
+					if ok, err = r.sd.p.filter.Match(val); err != nil {
+						r.Done()
+						return
+					} else if !ok {
+						continue
+					}
+				}
+				r.bytesRead += int64(len(val.Raw()))
+				if row, err = r.groups.Add(val); err != nil {
+					r.Done()
+					return
+				}
+			case error:
+				err = val
+				r.Done()
+				return
+			}
+		}
+		if row == nil {
+			continue
+		}
+
+		// Limit constraint
+		if r.sd.p.limit > 0 && r.sd.p.limit == r.count {
+			r.truncated = true
+			r.Done()
+			break
+		}
+
+		sz := row.size()
+		r.count++
+		// Page size constraint
+		if r.bufSize > 0 && r.bufSize+sz > r.sd.p.pageSize {
+			rows = r.rowsBuf
+			r.rowsBuf = []*groupRow{row}
+			r.bufSize = sz
+			r.account()
+			return
+		}
+		r.bufSize += sz
+		r.rowsBuf = append(r.rowsBuf, row)
+		r.account()
+	}
+
+	// No more rows left to be read from buffer
+	if len(r.rowsBuf) == 0 {
+		done = true
+	}
+
+	rows = r.rowsBuf
+	r.rowsBuf = nil
+	r.bufSize = 0
+	r.account()
+
+	return
+}
+
+// account for entries read and buffered so far, for the admin API
+func (r *scanStreamReader) account() {
+	atomic.StoreInt64(&r.sd.rows, r.count)
+	atomic.StoreInt64(&r.sd.bytesRead, r.bytesRead)
+	atomic.StoreInt64(&r.sd.bytesBuffered, r.bufSize)
+}
+
+// read a single response, the scan is stopped if it is killed meanwhile
+func (r *scanStreamReader) readResponse() interface{} {
+	select {
+	case resp := <-r.sd.respch:
+		return resp
+	case <-r.sd.killch:
+		r.Done()
+		return common.CountError(ErrScanKilled)
+	}
+}
+
 func (r *scanStreamReader) ReadStat() (stat statsResponse, err error) {
-	resp := <-r.sd.respch
+	resp := r.readResponse()
 	switch resp.(type) {
 	case statsResponse:
 		stat = resp.(statsResponse)
@@ -217,7 +390,7 @@ func (r *scanStreamReader) ReadStat() (stat statsResponse, err error) {
 }
 
 func (r *scanStreamReader) ReadCount() (count countResponse, err error) {
-	resp := <-r.sd.respch
+	resp := r.readResponse()
 	switch val := resp.(type) {
 	case countResponse:
 		return val, nil
@@ -254,6 +427,17 @@ func (r *scanStreamReader) ReturnedBytes() uint64 {
 	return uint64(r.bytesRead)
 }
 
+// Truncated tells whether more entries were left to be read when the
+// limit was reached.
+func (r *scanStreamReader) Truncated() bool {
+	return r.truncated
+}
+
+// LastKey returns the last entry read from scan results.
+func (r *scanStreamReader) LastKey() Key {
+	return r.lastKey
+}
+
 //TODO
 //For any query request, check if the replica is available. And use replica in case
 //its more recent or serving less queries.
@@ -265,17 +449,18 @@ type ScanCoordinator interface {
 }
 
 type indexScanStats struct {
-	Requests  *uint64
-	Rows      *uint64
-	BytesRead *uint64
-	ScanTime  *int64
-	WaitTime  *int64
+	Requests  *common.Counter
+	Rows      *common.Counter
+	BytesRead *common.Counter
+	ScanTime  *common.Counter
+	WaitTime  *common.Counter
+	Latency   *common.Histogram // scan time of each request
 }
 
 type scanCoordinator struct {
 	supvCmdch  MsgChannel //supervisor sends commands on this channel
 	supvMsgch  MsgChannel //channel to send any async message to supervisor
-	serv       *queryport.Server
+	serv       queryport.Queryport
 	logPrefix  string
 	reqCounter uint64
 
@@ -283,9 +468,15 @@ type scanCoordinator struct {
 	indexInstMap  common.IndexInstMap
 	indexPartnMap IndexPartnMap
 
-	config common.Config
+	config   common.Config
+	checksum bool // checksum index entries of scan responses
 
 	scanStatsMap map[common.IndexInstId]indexScanStats
+	scanCache    *scanCache
+	cursors      *scanCursors
+	scans        *activeScans
+	scanLog      *scanLog
+	handlers     *queryport.Handlers
 }
 
 // NewScanCoordinator returns an instance of scanCoordinator or err message
@@ -294,7 +485,7 @@ type scanCoordinator struct {
 // Any async message to supervisor is sent to supvMsgch.
 // If supvCmdch get closed, ScanCoordinator will shut itself down.
 func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
-	config common.Config) (ScanCoordinator, Message) {
+	config common.Config, memMgr *memoryManager) (ScanCoordinator, Message) {
 	var err error
 
 	s := &scanCoordinator{
@@ -302,13 +493,39 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 		supvMsgch:    supvMsgch,
 		logPrefix:    "ScanCoordinator",
 		config:       config,
+		checksum:     config["scanChecksum"].Bool(),
 		scanStatsMap: make(map[common.IndexInstId]indexScanStats),
+		scanCache: newScanCache(config["scanCache.size"].Int(),
+			uint64(config["scanCache.maxRows"].Int())),
+		cursors: newScanCursors(time.Millisecond *
+			time.Duration(config["scanCursor.ttl"].Int())),
+		scans: newActiveScans(config["scanMaxConcurrent"].Int()),
+		scanLog: newScanLog(time.Millisecond*
+			time.Duration(config["scanSlowThreshold"].Int()),
+			config["scanSlowLogSize"].Int()),
 	}
+	memMgr.register(MEM_SCAN_CACHE, s.scanCache)
 
 	addr := net.JoinHostPort("", config["scanPort"].String())
 	// TODO: Move queryport config to indexer.queryport base
 	queryportCfg := common.SystemConfig.SectionConfig("queryport.indexer.", true)
-	s.serv, err = queryport.NewServer(addr, s.requestHandler, queryportCfg)
+	handlers := queryport.NewHandlers()
+	handlers.RegisterPeer(&protobuf.StatisticsRequest{}, s.scanHandler(parseStatisticsRequest))
+	handlers.RegisterPeer(&protobuf.CountRequest{}, s.scanHandler(parseCountRequest))
+	handlers.RegisterPeer(&protobuf.ScanRequest{}, s.scanHandler(parseScanRequest))
+	handlers.RegisterPeer(&protobuf.ScanAllRequest{}, s.scanHandler(parseScanAllRequest))
+	handlers.RegisterPeer(&protobuf.ScanCursorRequest{}, s.scanHandler(parseScanCursorRequest))
+	handlers.RegisterPeer(&protobuf.LookupRequest{}, s.scanHandler(parseLookupRequest))
+	handlers.RegisterPeer(&protobuf.GroupAggregateRequest{}, s.scanHandler(parseGroupAggregateRequest))
+	if queryportCfg["authorization"].Bool() {
+		if queryportCfg["tls.certFile"].String() == "" {
+			common.Warnf("ScanCoordinator: Queryport Authorization Enabled " +
+				"Without TLS, Clients Cannot Authenticate")
+		}
+		handlers.Authorize(authenticate, s.requestBucket)
+	}
+	s.handlers = handlers
+	s.serv, err = queryport.NewQueryport(addr, handlers, queryportCfg)
 
 	if err != nil {
 		errMsg := &MsgError{err: Error{code: ERROR_SCAN_COORD_QUERYPORT_FAIL,
@@ -320,6 +537,10 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 		return nil, errMsg
 	}
 
+	http.HandleFunc("/scans", s.handleListScans)
+	http.HandleFunc("/scans/kill", s.handleKillScan)
+	http.HandleFunc("/scans/slow", s.handleSlowScans)
+
 	// main loop
 	go s.run()
 
@@ -336,26 +557,59 @@ func (s *scanCoordinator) handleStats(cmd Message) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	// node level stats, reported even without indexes
+	st := s.serv.Statistics()
+	statsMap["num_connections"] = fmt.Sprint(st.Connections)
+	statsMap["num_compressed_batches"] = fmt.Sprint(st.CompressedBatches)
+	statsMap["compression_ratio"] = fmt.Sprintf("%.2f", st.CompressionRatio())
+	statsMap["num_active_scans"] = fmt.Sprint(s.scans.Len())
+	drained, cancelled := s.scans.DrainStats()
+	statsMap["num_scans_drained"] = fmt.Sprint(drained)
+	statsMap["num_scans_cancelled"] = fmt.Sprint(cancelled)
+	if drop := s.scans.LastDrop(); drop != nil {
+		statsMap["last_drop_index"] = drop.Index
+		statsMap["last_drop_scans_drained"] = fmt.Sprint(drop.Drained)
+		statsMap["last_drop_scans_cancelled"] = fmt.Sprint(drop.Cancelled)
+	}
+	auth := s.handlers.AuthStatistics()
+	statsMap["num_auth_failures"] = fmt.Sprint(auth.AuthFailures)
+	statsMap["num_unauthenticated_requests"] = fmt.Sprint(auth.Unauthenticated)
+	for bucket, n := range auth.Denials {
+		statsMap[fmt.Sprintf("%s:num_requests_denied", bucket)] = fmt.Sprint(n)
+	}
+
+	if s.scanCache.Enabled() {
+		hits, misses := s.scanCache.Stats()
+		statsMap["scan_cache_hits"] = fmt.Sprint(hits)
+		statsMap["scan_cache_misses"] = fmt.Sprint(misses)
+		if hits+misses > 0 {
+			statsMap["scan_cache_hit_rate"] = fmt.Sprintf("%.2f",
+				float64(hits)/float64(hits+misses))
+		}
+	}
+
 	for instId, stat := range s.scanStatsMap {
 		inst := s.indexInstMap[instId]
 		k := fmt.Sprintf("%s:%s:num_requests", inst.Defn.Bucket, inst.Defn.Name)
-		v := fmt.Sprint(*stat.Requests)
+		v := fmt.Sprint(stat.Requests.Value())
 		statsMap[k] = v
 		k = fmt.Sprintf("%s:%s:num_rows_returned", inst.Defn.Bucket, inst.Defn.Name)
-		v = fmt.Sprint(*stat.Rows)
+		v = fmt.Sprint(stat.Rows.Value())
 		statsMap[k] = v
 		k = fmt.Sprintf("%s:%s:scan_bytes_read", inst.Defn.Bucket, inst.Defn.Name)
-		v = fmt.Sprint(*stat.BytesRead)
+		v = fmt.Sprint(stat.BytesRead.Value())
 		statsMap[k] = v
 		k = fmt.Sprintf("%s:%s:total_scan_duration", inst.Defn.Bucket, inst.Defn.Name)
-		v = fmt.Sprint(*stat.ScanTime)
+		v = fmt.Sprint(stat.ScanTime.Value())
 		statsMap[k] = v
 		k = fmt.Sprintf("%s:%s:scan_wait_duration", inst.Defn.Bucket, inst.Defn.Name)
-		v = fmt.Sprint(*stat.WaitTime)
+		v = fmt.Sprint(stat.WaitTime.Value())
 		statsMap[k] = v
-
-		st := s.serv.Statistics()
-		statsMap["num_connections"] = fmt.Sprint(st.Connections)
+		for _, p := range []int{50, 90, 99} {
+			k = fmt.Sprintf("%s:%s:scan_latency_p%d", inst.Defn.Bucket, inst.Defn.Name, p)
+			v = fmt.Sprint(stat.Latency.Percentile(float64(p)))
+			statsMap[k] = v
+		}
 
 		c, err := s.getItemsCount(instId)
 		if err == nil {
@@ -368,13 +622,96 @@ func (s *scanCoordinator) handleStats(cmd Message) {
 		}
 	}
 
+	for k, v := range s.scanLog.Stats() {
+		statsMap[k] = fmt.Sprint(v)
+	}
+
 	replych <- statsMap
 }
 
+// handleListScans reports in-flight scans, optionally only those running
+// for at least `minDuration` milliseconds.
+func (s *scanCoordinator) handleListScans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" && r.Method != "GET" {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	var minDuration time.Duration
+	if v := r.FormValue("minDuration"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil {
+			w.WriteHeader(400)
+			w.Write([]byte("Invalid minDuration " + v))
+			return
+		}
+		minDuration = time.Duration(ms) * time.Millisecond
+	}
+
+	bytes, _ := json.Marshal(s.scans.List(minDuration))
+	w.WriteHeader(200)
+	w.Write(bytes)
+}
+
+// handleSlowScans reports the most recent scans that took longer than
+// "scanSlowThreshold", most recent first.
+func (s *scanCoordinator) handleSlowScans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" && r.Method != "GET" {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	bytes, _ := json.Marshal(s.scanLog.Slow())
+	w.WriteHeader(200)
+	w.Write(bytes)
+}
+
+// handleKillScan stops the in-flight scan identified by `scanId`, the scan
+// returns ErrScanKilled to its client. The request must be made by a
+// cluster administrator.
+func (s *scanCoordinator) handleKillScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+	if ok, err := common.IsAdminRequest(r); err != nil || !ok {
+		common.Errorf("%v: scans/kill: unauthorized request, %v", s.logPrefix, err)
+		w.WriteHeader(401)
+		w.Write([]byte("Unauthorized"))
+		return
+	}
+
+	v := r.FormValue("scanId")
+	scanId, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid scanId " + v))
+		return
+	}
+	if err = s.scans.Kill(scanId); err != nil {
+		w.WriteHeader(404)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	common.Infof("%v: SCAN_ID: %v killed through admin API", s.logPrefix, scanId)
+	w.WriteHeader(200)
+	w.Write([]byte("OK"))
+}
+
 func (s *scanCoordinator) run() {
+	ticker := time.NewTicker(s.cursors.ttl)
+	defer ticker.Stop()
+
 loop:
 	for {
 		select {
+		case <-ticker.C:
+			s.cursors.Expire()
+
 		case cmd, ok := <-s.supvCmdch:
 			if ok {
 				if cmd.GetMsgType() == SCAN_COORD_SHUTDOWN {
@@ -403,6 +740,9 @@ func (s *scanCoordinator) handleSupvervisorCommands(cmd Message) {
 	case SCAN_STATS:
 		s.handleStats(cmd)
 
+	case SCAN_COORD_DRAIN_INDEX:
+		s.handleDrainScans(cmd)
+
 	default:
 		common.Errorf("ScanCoordinator: Received Unknown Command %v", cmd)
 		s.supvCmdch <- &MsgError{
@@ -413,93 +753,173 @@ func (s *scanCoordinator) handleSupvervisorCommands(cmd Message) {
 
 }
 
-// Parse scan params from queryport request
-func (s *scanCoordinator) parseScanParams(
-	req interface{}) (p *scanParams, err error) {
+// fillRanges of scan params from the low and high keys of a range and
+// from equal keys.
+func (p *scanParams) fillRanges(low, high []byte, keys [][]byte) error {
+	var err error
+	var key Key
 
-	p = new(scanParams)
-	p.partnKey = []byte("default")
+	// range
+	if p.low, err = NewKey(low); err != nil {
+		msg := fmt.Sprintf("Invalid low key %s (%s)", string(low), err.Error())
+		return errors.New(msg)
+	}
 
-	fillRanges := func(low, high []byte, keys [][]byte) error {
-		var err error
-		var key Key
+	if p.high, err = NewKey(high); err != nil {
+		msg := fmt.Sprintf("Invalid high key %s (%s)", string(high), err.Error())
+		return errors.New(msg)
+	}
 
-		// range
-		if p.low, err = NewKey(low); err != nil {
-			msg := fmt.Sprintf("Invalid low key %s (%s)", string(low), err.Error())
+	// point query for keys
+	for _, k := range keys {
+		if key, err = NewKey(k); err != nil {
+			msg := fmt.Sprintf("Invalid equal key %s (%s)", string(k), err.Error())
 			return errors.New(msg)
 		}
+		p.keys = append(p.keys, key)
+	}
 
-		if p.high, err = NewKey(high); err != nil {
-			msg := fmt.Sprintf("Invalid high key %s (%s)", string(high), err.Error())
-			return errors.New(msg)
-		}
+	return nil
+}
 
-		// point query for keys
-		for _, k := range keys {
-			if key, err = NewKey(k); err != nil {
-				msg := fmt.Sprintf("Invalid equal key %s (%s)", string(k), err.Error())
-				return errors.New(msg)
-			}
-			p.keys = append(p.keys, key)
-		}
+func newScanParams() *scanParams {
+	return &scanParams{partnKey: []byte("default")}
+}
 
-		return nil
+// scanParamsParser parses scan params from a queryport request of the
+// type it is registered for.
+type scanParamsParser func(req interface{}) (*scanParams, error)
+
+func parseStatisticsRequest(req interface{}) (*scanParams, error) {
+	r := req.(*protobuf.StatisticsRequest)
+	p := newScanParams()
+	p.scanType = queryStats
+	p.incl = Inclusion(r.GetSpan().GetRange().GetInclusion())
+	err := p.fillRanges(
+		r.GetSpan().GetRange().GetLow(),
+		r.GetSpan().GetRange().GetHigh(),
+		r.GetSpan().GetEquals())
+	p.defnID = r.GetDefnID()
+	return p, err
+}
+
+func parseCountRequest(req interface{}) (*scanParams, error) {
+	r := req.(*protobuf.CountRequest)
+	p := newScanParams()
+	p.scanType = queryCount
+	p.incl = Inclusion(r.GetSpan().GetRange().GetInclusion())
+	p.defnID = r.GetDefnID()
+	err := p.fillRanges(
+		r.GetSpan().GetRange().GetLow(),
+		r.GetSpan().GetRange().GetHigh(),
+		r.GetSpan().GetEquals())
+	return p, err
+}
+
+func parseScanRequest(req interface{}) (*scanParams, error) {
+	r := req.(*protobuf.ScanRequest)
+	p := newScanParams()
+	p.scanType = queryScan
+	p.incl = Inclusion(r.GetSpan().GetRange().GetInclusion())
+	err := p.fillRanges(
+		r.GetSpan().GetRange().GetLow(),
+		r.GetSpan().GetRange().GetHigh(),
+		r.GetSpan().GetEquals())
+	p.limit = r.GetLimit()
+	p.defnID = r.GetDefnID()
+	p.pageSize = r.GetPageSize()
+	// Scans on equal keys cannot be resumed from a cursor
+	p.withCursor = r.GetWithCursor() && len(p.keys) == 0
+	p.snapshot = r.GetSnapshot()
+	if err == nil {
+		p.filter, err = newScanFilter(r.GetFilter())
 	}
+	return p, err
+}
 
-	switch r := req.(type) {
-	case *protobuf.StatisticsRequest:
-		p.scanType = queryStats
-		p.incl = Inclusion(r.GetSpan().GetRange().GetInclusion())
-		err = fillRanges(
-			r.GetSpan().GetRange().GetLow(),
-			r.GetSpan().GetRange().GetHigh(),
-			r.GetSpan().GetEquals())
-		p.defnID = r.GetDefnID()
-	case *protobuf.CountRequest:
-		p.scanType = queryCount
-		p.incl = Inclusion(r.GetSpan().GetRange().GetInclusion())
-		p.defnID = r.GetDefnID()
-		err = fillRanges(
-			r.GetSpan().GetRange().GetLow(),
-			r.GetSpan().GetRange().GetHigh(),
-			r.GetSpan().GetEquals())
-	case *protobuf.ScanRequest:
-		p.scanType = queryScan
-		p.incl = Inclusion(r.GetSpan().GetRange().GetInclusion())
-		err = fillRanges(
-			r.GetSpan().GetRange().GetLow(),
-			r.GetSpan().GetRange().GetHigh(),
-			r.GetSpan().GetEquals())
-		p.limit = r.GetLimit()
-		p.defnID = r.GetDefnID()
-		p.pageSize = r.GetPageSize()
-	case *protobuf.ScanAllRequest:
-		p.scanType = queryScanAll
-		p.limit = r.GetLimit()
-		p.defnID = r.GetDefnID()
-		p.pageSize = r.GetPageSize()
-	default:
-		err = ErrUnsupportedRequest
+func parseScanAllRequest(req interface{}) (*scanParams, error) {
+	r := req.(*protobuf.ScanAllRequest)
+	p := newScanParams()
+	p.scanType = queryScanAll
+	p.limit = r.GetLimit()
+	p.defnID = r.GetDefnID()
+	p.pageSize = r.GetPageSize()
+	p.withCursor = r.GetWithCursor()
+	// A cursor resumes from the last key returned, which is
+	// meaningful only if entries are returned in index order
+	p.ordered = r.GetOrdered() || p.withCursor
+	return p, nil
+}
+
+func parseScanCursorRequest(req interface{}) (*scanParams, error) {
+	r := req.(*protobuf.ScanCursorRequest)
+	p := newScanParams()
+	// Range and bounds are restored from the cursor
+	p.scanType = queryScan
+	p.cursor = string(r.GetCursor())
+	p.limit = r.GetLimit()
+	p.pageSize = r.GetPageSize()
+	return p, nil
+}
+
+func parseLookupRequest(req interface{}) (*scanParams, error) {
+	r := req.(*protobuf.LookupRequest)
+	p := newScanParams()
+	p.scanType = queryLookup
+	p.defnID = r.GetDefnID()
+	err := p.fillRanges(nil, nil, r.GetKeys())
+	p.pageSize = r.GetPageSize()
+	return p, err
+}
+
+func parseGroupAggregateRequest(req interface{}) (*scanParams, error) {
+	r := req.(*protobuf.GroupAggregateRequest)
+	p := newScanParams()
+	p.scanType = queryGroupAggr
+	p.incl = Inclusion(r.GetSpan().GetRange().GetInclusion())
+	err := p.fillRanges(
+		r.GetSpan().GetRange().GetLow(),
+		r.GetSpan().GetRange().GetHigh(),
+		r.GetSpan().GetEquals())
+	// Groups are folded over a single range read in index order
+	if err == nil && len(p.keys) > 0 {
+		err = ErrInvalidGroup
 	}
+	p.limit = r.GetLimit()
+	p.defnID = r.GetDefnID()
+	p.pageSize = r.GetPageSize()
+	if err == nil {
+		p.filter, err = newScanFilter(r.GetFilter())
+	}
+	if err == nil {
+		p.group, err = newScanGroup(r)
+	}
+	return p, err
+}
 
-	return
+// scanHandler handles queryport requests parsed by `parse`.
+func (s *scanCoordinator) scanHandler(
+	parse scanParamsParser) queryport.PeerRequestHandler {
+
+	return func(peer queryport.Peer,
+		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+
+		p, err := parse(req)
+		s.requestHandler(peer, p, err, respch, quitch)
+	}
 }
 
-// Handle query requests arriving through queryport
+// Handle query requests arriving through queryport, `err` is set if
+// scan params could not be parsed from the request.
 func (s *scanCoordinator) requestHandler(
-	req interface{},
+	peer queryport.Peer,
+	p *scanParams,
+	err error,
 	respch chan<- interface{},
 	quitch <-chan interface{}) {
 
 	var indexInst *common.IndexInst
 
-	p, err := s.parseScanParams(req)
-	if err == ErrUnsupportedRequest {
-		// TODO: Add error response for invalid queryport reqs
-		panic(err)
-	}
-
 	scanId := atomic.AddUint64(&s.reqCounter, 1)
 	timeout := time.Millisecond * time.Duration(s.config["scanTimeout"].Int())
 	startTime := time.Now()
@@ -509,6 +929,20 @@ func (s *scanCoordinator) requestHandler(
 		stopch:    make(StopChannel),
 		respch:    make(chan interface{}),
 		timeoutch: time.After(timeout),
+		startTime: time.Now(),
+		client:    peer.Addr,
+		killch:    make(chan struct{}),
+	}
+
+	// Continue a paginated scan on the snapshot held by its cursor
+	var cursor *scanCursor
+	if err == nil && p.cursor != "" {
+		if cursor, err = s.cursors.Take(p.cursor); err == nil {
+			p.defnID = cursor.defnID
+			p.low, p.high, p.incl = cursor.low, cursor.high, cursor.incl
+			p.filter = cursor.filter
+			p.withCursor = true
+		}
 	}
 
 	if err == nil {
@@ -516,14 +950,26 @@ func (s *scanCoordinator) requestHandler(
 	}
 
 	// Update statistics
-	s.mu.RLock()
-	(*s.scanStatsMap[indexInst.InstId].Requests)++
-	s.mu.RUnlock()
+	if indexInst != nil {
+		s.mu.RLock()
+		s.scanStatsMap[indexInst.InstId].Requests.Add(1)
+		s.mu.RUnlock()
+	}
 
-	if err == nil && indexInst.State != common.INDEX_STATE_ACTIVE {
+	if err == nil && !indexInst.State.IsScannable() {
 		err = ErrIndexNotReady
 	}
+	if err == nil && p.group != nil {
+		nsec := len(indexInst.Defn.SecExprs)
+		if indexInst.Defn.IsPrimary {
+			nsec = 0
+		}
+		err = p.group.Validate(nsec)
+	}
 	if err != nil {
+		if cursor != nil {
+			DestroyIndexSnapshot(cursor.snap)
+		}
 		common.Infof("%v: SCAN_REQ: %v, Error (%v)", s.logPrefix, sd, err)
 		respch <- s.makeResponseMessage(sd, err)
 		close(respch)
@@ -531,9 +977,36 @@ func (s *scanCoordinator) requestHandler(
 	}
 
 	p.indexName, p.bucket = indexInst.Defn.Name, indexInst.Defn.Bucket
+	sd.instId = indexInst.InstId
+
+	// Scan can be listed and killed through the admin API from now on
+	if err := s.scans.Add(sd); err != nil {
+		common.Infof("%v: SCAN_REQ: %v, Error (%v)", s.logPrefix, sd, err)
+		respch <- s.makeResponseMessage(sd, common.CountError(err))
+		close(respch)
+		return
+	}
+	defer s.scans.Remove(sd.scanId)
+
+	// Index may have been dropped before the scan got added, in which case
+	// draining scans of the index would have missed it
+	if !s.hasIndexInstance(sd.instId) {
+		common.Infof("%v: SCAN_REQ: %v, Error (%v)", s.logPrefix, sd, ErrIndexNotFound)
+		respch <- s.makeResponseMessage(sd, ErrIndexNotFound)
+		close(respch)
+		return
+	}
+
+	// Scan is counted by its latency, and logged if slow, once finished
+	var scanErr error
+	defer func() {
+		s.scanLog.Record(sd, scanErr, time.Since(startTime))
+	}()
 
 	// Its a primary index scan
 	sd.isPrimary = indexInst.Defn.IsPrimary
+	// Index stores projected fields along with entries
+	sd.isCovering = len(indexInst.Defn.Include) > 0
 
 	common.Infof("%v: SCAN_REQ %v", s.logPrefix, sd)
 	// Before starting the index scan, we have to find out the snapshot timestamp
@@ -545,20 +1018,28 @@ func (s *scanCoordinator) requestHandler(
 	// will block wait.
 	// This mechanism can be used to implement RYOW.
 
-	snapResch := make(chan interface{}, 1)
-	snapReqMsg := &MsgIndexSnapRequest{
-		ts:        sd.p.ts,
-		respch:    snapResch,
-		idxInstId: indexInst.InstId,
-	}
-
-	// Block wait until a ts is available for fullfilling the request
-	s.supvMsgch <- snapReqMsg
 	var msg interface{}
-	select {
-	case msg = <-snapResch:
-	case <-sd.timeoutch:
-		msg = ErrScanTimedOut
+	if cursor != nil {
+		msg = cursor.snap
+	} else {
+		snapResch := make(chan interface{}, 1)
+		snapReqMsg := &MsgIndexSnapRequest{
+			ts:        sd.p.ts,
+			respch:    snapResch,
+			idxInstId: indexInst.InstId,
+			handle:    sd.p.snapshot,
+		}
+
+		// Block wait until a ts is available for fullfilling the request
+		s.supvMsgch <- snapReqMsg
+		select {
+		case msg = <-snapResch:
+		case <-sd.timeoutch:
+			// Nothing was scanned yet, client can retry the scan
+			msg = common.CountError(ErrSnapNotAvailable)
+		case <-sd.killch:
+			msg = common.CountError(ErrScanKilled)
+		}
 	}
 
 	var snap IndexSnapshot
@@ -572,6 +1053,7 @@ func (s *scanCoordinator) requestHandler(
 		}
 	case error:
 		err := msg.(error)
+		scanErr = err
 		respch <- s.makeResponseMessage(sd, err)
 		common.Infof("%v: SCAN_REQ: %v, Error (%v)", s.logPrefix, sd, err)
 		close(respch)
@@ -588,6 +1070,24 @@ func (s *scanCoordinator) requestHandler(
 		return
 	}
 
+	// Serve repeated identical scans on an unchanged snapshot from cache
+	var cacheKey string
+	if s.scanCache.Enabled() && !sd.p.withCursor {
+		cacheKey = scanCacheKey(indexInst.InstId, sd.p, ts)
+		if entry, ok := s.scanCache.Get(cacheKey); ok {
+			DestroyIndexSnapshot(snap)
+			s.serveCachedScan(sd, indexInst, entry, respch, quitch,
+				startTime, waitDuration)
+			return
+		}
+	}
+
+	// Keep the snapshot around in case a cursor is left at the end of the
+	// scan, the scan itself releases the snapshot once done.
+	if sd.p.withCursor {
+		CloneIndexSnapshot(snap)
+	}
+
 	go s.scanIndexSnapshot(sd, snap)
 
 	rdr := newResponseReader(sd)
@@ -596,9 +1096,11 @@ func (s *scanCoordinator) requestHandler(
 		var msg interface{}
 		stat, err := rdr.ReadStat()
 		if err != nil {
+			scanErr = err
 			msg = s.makeResponseMessage(sd, err)
 		} else {
 			msg = s.makeResponseMessage(sd, stat)
+			s.cacheScanResult(cacheKey, indexInst.InstId, []interface{}{msg}, 0, 0)
 		}
 
 		respch <- msg
@@ -608,28 +1110,34 @@ func (s *scanCoordinator) requestHandler(
 		var msg interface{}
 		count, err := rdr.ReadCount()
 		if err != nil {
+			scanErr = err
 			msg = s.makeResponseMessage(sd, err)
 		} else {
 			msg = s.makeResponseMessage(sd, count)
+			s.cacheScanResult(cacheKey, indexInst.InstId, []interface{}{msg}, 0, 0)
 		}
 
 		respch <- msg
 		close(respch)
 
-	case queryScan:
-		fallthrough
-	case queryScanAll:
-		var keys *[]Key
+	case queryScan, queryScanAll, queryLookup, queryGroupAggr:
+		var batch interface{}
 		var msg interface{}
 		var done bool
 		var reqquit bool = false
 		var status string
+		var cached []interface{}
+		cacheable := cacheKey != ""
 
 		// Read scan entries and send it to the client
 		// Closing respch indicates that we have no more messages to be sent
 	loop:
 		for {
-			keys, done, err = rdr.ReadKeyBatch()
+			if sd.p.scanType == queryGroupAggr {
+				batch, done, err = rdr.ReadGroupBatch()
+			} else {
+				batch, done, err = rdr.ReadKeyBatch()
+			}
 			// We have already finished reading from response stream
 			if done {
 				break loop
@@ -637,8 +1145,22 @@ func (s *scanCoordinator) requestHandler(
 
 			if err != nil {
 				msg = s.makeResponseMessage(sd, err)
+			} else if keys, ok := batch.(*[]Key); ok {
+				// entries that can't be served fail the scan, not the indexer
+				if msg, err = s.makeKeysResponse(sd, keys); err != nil {
+					msg = s.makeResponseMessage(sd, err)
+					rdr.Done()
+				}
 			} else {
-				msg = s.makeResponseMessage(sd, keys)
+				msg = s.makeResponseMessage(sd, batch)
+			}
+
+			if cacheable {
+				if rdr.ReturnedRows() > s.scanCache.maxRows {
+					cacheable, cached = false, nil
+				} else {
+					cached = append(cached, msg)
+				}
 			}
 
 			// Send protobuf message response to queryport
@@ -650,31 +1172,158 @@ func (s *scanCoordinator) requestHandler(
 					break loop
 				}
 			case respch <- msg:
+			case <-sd.killch:
+				// client is not draining responses fast enough, stop the
+				// scan and let the client know once it catches up.
+				err = common.CountError(ErrScanKilled)
+				rdr.Done()
+				select {
+				case <-quitch:
+					reqquit = true
+				case respch <- s.makeResponseMessage(sd, err):
+				}
 			}
 
 			if err != nil {
 				break loop
 			}
 		}
+
+		if ts != nil && err == nil && !reqquit {
+			msg = &protobuf.ResponseStream{
+				Timestamp: protobuf.NewTsConsistency(ts),
+			}
+			if cacheable {
+				cached = append(cached, msg)
+			}
+			select {
+			case <-quitch:
+				reqquit = true
+			case respch <- msg:
+			}
+		}
+
+		if sd.p.withCursor {
+			token := s.saveCursor(sd, indexInst, snap, rdr, err == nil && !reqquit)
+			if token != "" {
+				msg = &protobuf.ResponseStream{Cursor: []byte(token)}
+				select {
+				case <-quitch:
+					reqquit = true
+				case respch <- msg:
+				}
+			}
+		}
 		close(respch)
 		if reqquit {
 			status = "client requested quit"
 		} else if err != nil {
+			scanErr = err
 			status = "error occured " + err.Error()
 		} else {
 			status = "successful"
+			if cacheable {
+				s.cacheScanResult(cacheKey, indexInst.InstId, cached,
+					rdr.ReturnedRows(), rdr.ReturnedBytes())
+			}
 		}
 
 		s.mu.RLock()
-		(*s.scanStatsMap[indexInst.InstId].Rows) += rdr.ReturnedRows()
-		(*s.scanStatsMap[indexInst.InstId].BytesRead) += rdr.ReturnedBytes()
-		(*s.scanStatsMap[indexInst.InstId].ScanTime) += time.Now().Sub(startTime).Nanoseconds()
-		(*s.scanStatsMap[indexInst.InstId].WaitTime) += waitDuration.Nanoseconds()
+		stat := s.scanStatsMap[indexInst.InstId]
+		scanTime := time.Now().Sub(startTime).Nanoseconds()
+		stat.Rows.Add(int64(rdr.ReturnedRows()))
+		stat.BytesRead.Add(int64(rdr.ReturnedBytes()))
+		stat.ScanTime.Add(scanTime)
+		stat.WaitTime.Add(waitDuration.Nanoseconds())
+		stat.Latency.Add(scanTime)
 		s.mu.RUnlock()
 		common.Infof("%v: SCAN_ID: %v finished scan (%s)", s.logPrefix, sd.scanId, status)
 	}
 }
 
+// Send response messages of a previously cached scan to the client
+func (s *scanCoordinator) serveCachedScan(sd *scanDescriptor,
+	indexInst *common.IndexInst, entry *scanCacheEntry,
+	respch chan<- interface{}, quitch <-chan interface{},
+	startTime time.Time, waitDuration time.Duration) {
+
+	status := "successful"
+	atomic.StoreInt64(&sd.rows, int64(entry.rows))
+loop:
+	for _, msg := range entry.msgs {
+		select {
+		case _, ok := <-quitch:
+			if !ok {
+				status = "client requested quit"
+				break loop
+			}
+		case respch <- msg:
+		}
+	}
+	close(respch)
+
+	if sd.p.scanType == queryScan || sd.p.scanType == queryScanAll ||
+		sd.p.scanType == queryLookup || sd.p.scanType == queryGroupAggr {
+		s.mu.RLock()
+		stat := s.scanStatsMap[indexInst.InstId]
+		scanTime := time.Now().Sub(startTime).Nanoseconds()
+		stat.Rows.Add(int64(entry.rows))
+		stat.BytesRead.Add(int64(entry.bytes))
+		stat.ScanTime.Add(scanTime)
+		stat.WaitTime.Add(waitDuration.Nanoseconds())
+		stat.Latency.Add(scanTime)
+		s.mu.RUnlock()
+	}
+	common.Infof("%v: SCAN_ID: %v finished scan from cache (%s)",
+		s.logPrefix, sd.scanId, status)
+}
+
+// saveCursor registers a cursor for resuming a scan that was stopped by its
+// limit and returns its token. The reference held on snap for the cursor
+// is released if no cursor is needed.
+func (s *scanCoordinator) saveCursor(sd *scanDescriptor,
+	indexInst *common.IndexInst, snap IndexSnapshot,
+	rdr *scanStreamReader, ok bool) string {
+
+	if !ok || !rdr.Truncated() {
+		DestroyIndexSnapshot(snap)
+		return ""
+	}
+
+	cursor := &scanCursor{
+		instId: indexInst.InstId,
+		defnID: sd.p.defnID,
+		snap:   snap,
+		low:    rdr.LastKey(),
+		high:   sd.p.high,
+		incl:   resumeInclusion(sd.p.incl),
+		filter: sd.p.filter,
+	}
+	token, err := s.cursors.Add(cursor)
+	if err != nil {
+		common.Errorf("%v: SCAN_ID: %v unable to save cursor (%v)",
+			s.logPrefix, sd.scanId, err)
+		DestroyIndexSnapshot(snap)
+		return ""
+	}
+	return token
+}
+
+func (s *scanCoordinator) cacheScanResult(key string, instId common.IndexInstId,
+	msgs []interface{}, rows, bytes uint64) {
+
+	if key == "" {
+		return
+	}
+	s.scanCache.Put(&scanCacheEntry{
+		key:    key,
+		instId: instId,
+		msgs:   msgs,
+		rows:   rows,
+		bytes:  bytes,
+	})
+}
+
 func ProtoIndexEntryFromKey(k Key, isPrimary bool) *protobuf.IndexEntry {
 	// TODO: Return error instead of panic
 	var tmp []interface{}
@@ -711,6 +1360,34 @@ func ProtoIndexEntryFromKey(k Key, isPrimary bool) *protobuf.IndexEntry {
 	return entry
 }
 
+// Create a queryport response message for a batch of index entries.
+// Projected fields of covering index that can't be decoded are reported
+// as index corruption for the scan to fail.
+func (s *scanCoordinator) makeKeysResponse(sd *scanDescriptor,
+	keys *[]Key) (*protobuf.ResponseStream, error) {
+
+	var entries []*protobuf.IndexEntry
+	for _, k := range *keys {
+		entry := ProtoIndexEntryFromKey(k, sd.isPrimary)
+		if sd.isCovering {
+			projected, err := k.Projected()
+			if err != nil {
+				common.Errorf("%v: SCAN_ID: %v corrupted entry %s (%v)",
+					s.logPrefix, sd.scanId, k.Raw(), err)
+				return nil, common.CountError(common.WrapError(
+					common.ErrorIndexCorrupted, "scan", sd.scanId))
+			}
+			entry.ProjectedValue = projected
+		}
+		entries = append(entries, entry)
+	}
+	resp := &protobuf.ResponseStream{IndexEntries: entries}
+	if s.checksum {
+		resp.SetChecksum()
+	}
+	return resp, nil
+}
+
 // Create a queryport response message
 // Response message can be StreamResponse or StatisticsResponse
 func (s *scanCoordinator) makeResponseMessage(sd *scanDescriptor,
@@ -719,7 +1396,7 @@ func (s *scanCoordinator) makeResponseMessage(sd *scanDescriptor,
 	switch payload.(type) {
 	case error:
 		err := payload.(error)
-		protoErr := &protobuf.Error{Error: proto.String(err.Error())}
+		protoErr := protobuf.NewError(err)
 		switch sd.p.scanType {
 		case queryStats:
 			r = &protobuf.StatisticsResponse{
@@ -735,21 +1412,29 @@ func (s *scanCoordinator) makeResponseMessage(sd *scanDescriptor,
 			r = &protobuf.CountResponse{
 				Count: proto.Int64(0), Err: protoErr,
 			}
-		case queryScan:
-			fallthrough
-		case queryScanAll:
+		case queryScan, queryScanAll, queryLookup, queryGroupAggr:
 			r = &protobuf.ResponseStream{
 				Err: protoErr,
 			}
 		}
 	case *[]Key:
-		var entries []*protobuf.IndexEntry
-		keys := *payload.(*[]Key)
-		for _, k := range keys {
-			entry := ProtoIndexEntryFromKey(k, sd.isPrimary)
-			entries = append(entries, entry)
+		resp, err := s.makeKeysResponse(sd, payload.(*[]Key))
+		if err != nil {
+			return s.makeResponseMessage(sd, err)
+		}
+		r = resp
+	case []*groupRow:
+		var rows []*protobuf.GroupRow
+		for _, row := range payload.([]*groupRow) {
+			rows = append(rows, &protobuf.GroupRow{
+				GroupKey: row.key, Aggregates: row.aggregates,
+			})
 		}
-		r = &protobuf.ResponseStream{IndexEntries: entries}
+		resp := &protobuf.ResponseStream{GroupRows: rows}
+		if s.checksum {
+			resp.SetChecksum()
+		}
+		r = resp
 	case statsResponse:
 		stats := payload.(statsResponse)
 		r = &protobuf.StatisticsResponse{
@@ -758,6 +1443,7 @@ func (s *scanCoordinator) makeResponseMessage(sd *scanDescriptor,
 				UniqueKeysCount: proto.Uint64(stats.unique),
 				KeyMin:          stats.min.Raw(),
 				KeyMax:          stats.max.Raw(),
+				Histogram:       protoKeyBins(stats.bins),
 			},
 		}
 	case countResponse:
@@ -767,7 +1453,80 @@ func (s *scanCoordinator) makeResponseMessage(sd *scanDescriptor,
 	return
 }
 
+// protoKeyBins converts bins of key histogram to statistics of each bin,
+// with bounds of a bin being its secondary keys.
+func protoKeyBins(bins []KeyBin) []*protobuf.IndexStatistics {
+	if len(bins) == 0 {
+		return nil
+	}
+	pbins := make([]*protobuf.IndexStatistics, 0, len(bins))
+	for _, bin := range bins {
+		low, err := trimDocid(bin.Low.Raw())
+		if err != nil {
+			continue
+		}
+		high, err := trimDocid(bin.High.Raw())
+		if err != nil {
+			continue
+		}
+		pbins = append(pbins, &protobuf.IndexStatistics{
+			KeysCount:       proto.Uint64(bin.Count),
+			UniqueKeysCount: proto.Uint64(bin.Distinct),
+			KeyMin:          low,
+			KeyMax:          high,
+		})
+	}
+	return pbins
+}
+
+// requestBucket returns the bucket read by a queryport request, to
+// authorize the request.
+func (s *scanCoordinator) requestBucket(req interface{}) (string, error) {
+	var defnID uint64
+	switch r := req.(type) {
+	case *protobuf.ScanCursorRequest:
+		id, err := s.cursors.DefnID(string(r.GetCursor()))
+		if err != nil {
+			return "", err
+		}
+		defnID = id
+	case interface {
+		GetDefnID() uint64
+	}:
+		defnID = r.GetDefnID()
+	default:
+		return "", ErrUnsupportedRequest
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, inst := range s.indexInstMap {
+		if inst.Defn.DefnId == common.IndexDefnId(defnID) {
+			return inst.Defn.Bucket, nil
+		}
+	}
+	return "", ErrIndexNotFound
+}
+
+// authenticate queryport connections with cbauth.
+func authenticate(user, password string) (queryport.Credentials, error) {
+	creds, err := cbauth.Auth(user, password)
+	if err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
 // Find and return data structures for the specified index
+// Check whether the index instance is still in the index instance map
+func (s *scanCoordinator) hasIndexInstance(instId common.IndexInstId) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.indexInstMap[instId]
+	return ok
+}
+
 func (s *scanCoordinator) findIndexInstance(
 	defnID uint64) (*common.IndexInst, error) {
 
@@ -794,6 +1553,16 @@ func (s *scanCoordinator) scanIndexSnapshot(sd *scanDescriptor, snap IndexSnapsh
 	common.Debugf("%v: scanIndexSnapshot: SCAN_ID: %v instance_id: %v",
 		s.logPrefix, sd.scanId, snap.IndexInstId())
 
+	if sd.p.scanType == queryScanAll {
+		s.scanAllSlices(sd, snap)
+		close(sd.respch)
+		return
+	} else if sd.p.scanType == queryGroupAggr {
+		s.scanRangeOrdered(sd, snap)
+		close(sd.respch)
+		return
+	}
+
 	var wg sync.WaitGroup
 	var workerStopChannels []StopChannel
 
@@ -882,6 +1651,8 @@ func (s *scanCoordinator) scanSliceSnapshot(sd *scanDescriptor,
 		s.queryScan(sd, ss.Snapshot(), stopch)
 	case queryScanAll:
 		s.queryScanAll(sd, ss.Snapshot(), stopch)
+	case queryLookup:
+		s.queryLookup(sd, ss.Snapshot(), stopch)
 	}
 
 	ss.Snapshot().Close()
@@ -895,7 +1666,16 @@ func (s *scanCoordinator) queryStats(sd *scanDescriptor, snap Snapshot, stopch S
 	} else {
 		min, _ := NewKey([]byte("min"))
 		max, _ := NewKey([]byte("max"))
-		sd.respch <- statsResponse{count: totalRows, min: min, max: max}
+		stats := statsResponse{count: totalRows, min: min, max: max}
+		if h, ok := snap.(Histogrammer); ok {
+			bins, err := h.KeyHistogram(sd.p.low, sd.p.high, stopch)
+			if err != nil {
+				common.Errorf("%v: SCAN_ID: %v key histogram failed %v",
+					s.logPrefix, sd.scanId, err)
+			}
+			stats.bins = bins
+		}
+		sd.respch <- stats
 	}
 }
 
@@ -950,6 +1730,11 @@ func (s *scanCoordinator) queryScanAll(sd *scanDescriptor, snap Snapshot, stopch
 	s.receiveKeys(sd, ch, cherr)
 }
 
+func (s *scanCoordinator) queryLookup(sd *scanDescriptor, snap Snapshot, stopch StopChannel) {
+	ch, cherr := snap.LookupKeys(sd.p.keys, stopch)
+	s.receiveKeys(sd, ch, cherr)
+}
+
 // receiveKeys receives results/errors from snapshot reader and forwards it to
 // the caller till the result channel is closed by the snapshot reader
 func (s *scanCoordinator) receiveKeys(sd *scanDescriptor, chkey chan Key, cherr chan error) {
@@ -980,6 +1765,8 @@ func (s *scanCoordinator) handleUpdateIndexInstMap(cmd Message) {
 	common.Infof("ScanCoordinator::handleUpdateIndexInstMap %v", cmd)
 	indexInstMap := cmd.(*MsgUpdateInstMap).GetIndexInstMap()
 	s.indexInstMap = common.CopyIndexInstMap(indexInstMap)
+	s.scanCache.Purge(s.indexInstMap)
+	s.cursors.Purge(s.indexInstMap)
 
 	// Remove invalid indexes
 	for instId, _ := range s.scanStatsMap {
@@ -992,11 +1779,12 @@ func (s *scanCoordinator) handleUpdateIndexInstMap(cmd Message) {
 	for instId, _ := range s.indexInstMap {
 		if _, ok := s.scanStatsMap[instId]; !ok {
 			s.scanStatsMap[instId] = indexScanStats{
-				Requests:  new(uint64),
-				Rows:      new(uint64),
-				BytesRead: new(uint64),
-				ScanTime:  new(int64),
-				WaitTime:  new(int64),
+				Requests:  new(common.Counter),
+				Rows:      new(common.Counter),
+				BytesRead: new(common.Counter),
+				ScanTime:  new(common.Counter),
+				WaitTime:  new(common.Counter),
+				Latency:   common.NewLatencyHistogram(),
 			}
 		}
 	}
@@ -1004,6 +1792,22 @@ func (s *scanCoordinator) handleUpdateIndexInstMap(cmd Message) {
 	s.supvCmdch <- &MsgSuccess{}
 }
 
+// Wait for in-flight scans of an index instance being dropped to finish,
+// the instance is expected to be removed from the index instance map by
+// now, so that new scans on it are refused. Outcome is reported in stats
+// and sent on the reply channel once draining is done.
+func (s *scanCoordinator) handleDrainScans(cmd Message) {
+	s.supvCmdch <- &MsgSuccess{}
+
+	req := cmd.(*MsgDrainScans)
+	timeout := time.Millisecond * time.Duration(s.config["scanDrainTimeout"].Int())
+	go func() {
+		stats := s.scans.Drain(req.GetInstId(), timeout)
+		s.scans.RecordDrop(req.GetIndex(), stats)
+		req.GetReplyChannel() <- stats
+	}()
+}
+
 func (s *scanCoordinator) handleUpdateIndexPartnMap(cmd Message) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -60,7 +60,8 @@ func newScannerTestHarness() (*scannerTestHarness, error) {
 	h := new(scannerTestHarness)
 	h.cmdch = make(chan Message)
 	h.msgch = make(chan Message)
-	si, errMsg := NewScanCoordinator(h.cmdch, h.msgch, c.SystemConfig.SectionConfig("indexer.", true))
+	config := c.SystemConfig.SectionConfig("indexer.", true)
+	si, errMsg := NewScanCoordinator(h.cmdch, h.msgch, config, newMemoryManager(config))
 	h.scanner = si.(*scanCoordinator)
 	if errMsg.GetMsgType() != MSG_SUCCESS {
 		return nil, (errMsg.(*MsgError)).GetError().cause
@@ -160,10 +161,22 @@ func (s *mockSlice) Insert(k Key, v Value) error {
 	return s.err
 }
 
+func (s *mockSlice) BulkInsert(keys []Key, values []Value) error {
+	return s.err
+}
+
 func (s *mockSlice) Delete(d []byte) error {
 	return s.err
 }
 
+func (s *mockSlice) Expire(d []byte) error {
+	return s.err
+}
+
+func (s *mockSlice) Purge(d []byte, seqno Seqno) error {
+	return s.err
+}
+
 func (s *mockSlice) NewSnapshot(ts *c.TsVbuuid, commit bool) (SnapshotInfo, error) {
 	return &mockSnapshotInfo{}, s.err
 }
@@ -270,6 +283,15 @@ func (s *mockSnapshot) ValueRange(low, high Key, inclusion Inclusion,
 	return s.valch, s.errch, s.order
 }
 
+func (s *mockSnapshot) LookupKeys(keys []Key,
+	stopch StopChannel) (chan Key, chan error) {
+
+	s.keych = make(chan Key)
+	s.errch = make(chan error)
+	go s.feeder(s.keych, nil, s.errch)
+	return s.keych, s.errch
+}
+
 func (s *mockSnapshot) GetKeySetForKeyRange(low Key, high Key,
 	inclusion Inclusion, chkey chan Key, cherr chan error, stopch StopChannel) {
 	panic("not implemented")
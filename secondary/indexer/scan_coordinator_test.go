@@ -5,10 +5,12 @@ import (
 	"fmt"
 	c "github.com/couchbase/indexing/secondary/common"
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/couchbase/indexing/secondary/queryport"
 	queryclient "github.com/couchbase/indexing/secondary/queryport/client"
 	"github.com/couchbaselabs/goprotobuf/proto"
 	"reflect"
 	"testing"
+	"time"
 )
 
 const QUERY_PORT_ADDR = ":7000"
@@ -355,3 +357,161 @@ func TestStatisticsError(t *testing.T) {
 
 	client.Close()
 }
+
+func TestResponseChecksum(t *testing.T) {
+	keys := make([]Key, 0, 3)
+	for i := 0; i < 3; i++ {
+		b, _ := json.Marshal(append(testSK(i), testPK(i)))
+		k, err := NewKey(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	sd := &scanDescriptor{p: &scanParams{scanType: queryScan}}
+
+	s := &scanCoordinator{}
+	resp := s.makeResponseMessage(sd, &keys).(*protobuf.ResponseStream)
+	if resp.Checksum != nil {
+		t.Fatal("unexpected checksum when disabled")
+	}
+
+	s.checksum = true
+	resp = s.makeResponseMessage(sd, &keys).(*protobuf.ResponseStream)
+	if resp.Checksum == nil || !resp.VerifyChecksum() {
+		t.Fatal("expected a valid checksum")
+	}
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &protobuf.ResponseStream{}
+	if err := proto.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	} else if !decoded.VerifyChecksum() {
+		t.Fatal("expected checksum to survive encoding")
+	}
+
+	resp.IndexEntries[1].PrimaryKey[0] ^= 0xff
+	if resp.VerifyChecksum() {
+		t.Fatal("expected checksum mismatch for corrupted entry")
+	}
+}
+
+func TestResponseCorruptedEntry(t *testing.T) {
+	b, _ := json.Marshal(append(testSK(0), testPK(0)))
+	k, err := NewKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.SetValue([]byte("{corrupted"))
+	keys := []Key{k}
+	sd := &scanDescriptor{p: &scanParams{scanType: queryScan}, isCovering: true}
+
+	// corrupted projected fields fail the scan instead of the indexer.
+	s := &scanCoordinator{logPrefix: "ScanCoordinator"}
+	if _, err := s.makeKeysResponse(sd, &keys); c.ErrorCause(err) != c.ErrorIndexCorrupted {
+		t.Fatalf("expected %v, got %v", c.ErrorIndexCorrupted, err)
+	}
+	resp := s.makeResponseMessage(sd, &keys).(*protobuf.ResponseStream)
+	if resp.Error() == nil || len(resp.IndexEntries) != 0 {
+		t.Fatalf("expected an error response, got %v", resp)
+	}
+
+	sd.isCovering = false
+	if resp, err := s.makeKeysResponse(sd, &keys); err != nil || len(resp.IndexEntries) != 1 {
+		t.Fatalf("unexpected response %v %v", resp, err)
+	}
+}
+
+type statsQueryport struct {
+	stats queryport.ServerStats
+}
+
+func (q *statsQueryport) Statistics() queryport.ServerStats {
+	return q.stats
+}
+
+func (q *statsQueryport) Close() error {
+	return nil
+}
+
+func TestScanCoordinatorNodeStats(t *testing.T) {
+	s := &scanCoordinator{
+		supvCmdch:    make(MsgChannel, 1),
+		serv:         &statsQueryport{queryport.ServerStats{Connections: 2}},
+		handlers:     &queryport.Handlers{},
+		indexInstMap: make(c.IndexInstMap),
+		scanStatsMap: make(map[c.IndexInstId]indexScanStats),
+		scanCache:    newScanCache(1024, 10),
+		scans:        newActiveScans(0),
+		scanLog:      newScanLog(time.Second, 10),
+	}
+
+	// node level stats are reported on a node without indexes.
+	respch := make(chan map[string]string, 1)
+	s.handleStats(&MsgStatsRequest{respch: respch})
+	<-s.supvCmdch
+	stats := <-respch
+	ref := map[string]string{
+		"num_connections":              "2",
+		"num_compressed_batches":       "0",
+		"compression_ratio":            "1.00",
+		"num_active_scans":             "0",
+		"num_scans_drained":            "0",
+		"num_scans_cancelled":          "0",
+		"num_auth_failures":            "0",
+		"num_unauthenticated_requests": "0",
+		"scan_cache_hits":              "0",
+		"scan_cache_misses":            "0",
+	}
+	for k, v := range ref {
+		if stats[k] != v {
+			t.Errorf("expected %v for %v, got %q", v, k, stats[k])
+		}
+	}
+	if _, ok := stats["last_drop_index"]; ok {
+		t.Errorf("unexpected last drop without a dropped index")
+	}
+
+	s.scans.RecordDrop("default:idx", scanDrainStats{Drained: 2, Cancelled: 1})
+	s.handleStats(&MsgStatsRequest{respch: respch})
+	<-s.supvCmdch
+	stats = <-respch
+	ref = map[string]string{
+		"last_drop_index":           "default:idx",
+		"last_drop_scans_drained":   "2",
+		"last_drop_scans_cancelled": "1",
+	}
+	for k, v := range ref {
+		if stats[k] != v {
+			t.Errorf("expected %v for %v, got %q", v, k, stats[k])
+		}
+	}
+}
+
+func TestLookupScanParams(t *testing.T) {
+	req := &protobuf.LookupRequest{
+		DefnID:   proto.Uint64(1),
+		Keys:     [][]byte{[]byte(`["a"]`), []byte(`[10]`)},
+		PageSize: proto.Int64(1),
+	}
+	p, err := parseLookupRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	} else if p.scanType != queryLookup || p.defnID != 1 {
+		t.Fatalf("unexpected scan params %+v", p)
+	} else if len(p.keys) != 2 || scanSpans(p) != 2 {
+		t.Fatalf("expected 2 lookup keys, got %v", len(p.keys))
+	}
+	for i, key := range p.keys {
+		if raw := string(key.Raw()); raw != string(req.Keys[i]) {
+			t.Fatalf("expected key %s, got %s", req.Keys[i], raw)
+		}
+	}
+
+	req.Keys = [][]byte{[]byte(`["a"`)}
+	if _, err := parseLookupRequest(req); err == nil {
+		t.Fatal("expected invalid lookup key to fail")
+	}
+}
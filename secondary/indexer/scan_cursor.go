@@ -0,0 +1,143 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCursorNotFound = errors.New("Scan cursor not found or expired")
+)
+
+// A scanCursor remembers where a paginated scan stopped, so that it can be
+// resumed on the same index snapshot. The cursor holds a reference on the
+// snapshot until it is resumed or expires.
+type scanCursor struct {
+	id      string
+	instId  common.IndexInstId
+	defnID  uint64
+	snap    IndexSnapshot
+	low     Key // last key returned, resume after it
+	high    Key
+	incl    Inclusion
+	filter  *scanFilter
+	expires time.Time
+}
+
+// Paginated scans waiting to be resumed, indexed by cursor id
+type scanCursors struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	cursors map[string]*scanCursor
+}
+
+func newScanCursors(ttl time.Duration) *scanCursors {
+	return &scanCursors{
+		ttl:     ttl,
+		cursors: make(map[string]*scanCursor),
+	}
+}
+
+// Add registers a cursor and returns its id, the cursor expires after ttl.
+func (cs *scanCursors) Add(cur *scanCursor) (string, error) {
+	uuid, err := common.NewUUID()
+	if err != nil {
+		return "", err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cur.id = fmt.Sprintf("%x", uuid.Uint64())
+	cur.expires = time.Now().Add(cs.ttl)
+	cs.cursors[cur.id] = cur
+	return cur.id, nil
+}
+
+// Take removes and returns the cursor for id. Ownership of the cursor's
+// snapshot passes to the caller.
+func (cs *scanCursors) Take(id string) (*scanCursor, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cur, ok := cs.cursors[id]
+	if !ok {
+		return nil, ErrCursorNotFound
+	}
+	delete(cs.cursors, id)
+
+	if time.Now().After(cur.expires) {
+		DestroyIndexSnapshot(cur.snap)
+		return nil, ErrCursorNotFound
+	}
+	return cur, nil
+}
+
+// DefnID returns the index definition scanned by the cursor for id,
+// the cursor is left in place.
+func (cs *scanCursors) DefnID(id string) (uint64, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cur, ok := cs.cursors[id]
+	if !ok {
+		return 0, ErrCursorNotFound
+	}
+	return cur.defnID, nil
+}
+
+// Expire releases the snapshots of cursors that were not resumed in time.
+func (cs *scanCursors) Expire() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	now := time.Now()
+	for id, cur := range cs.cursors {
+		if now.After(cur.expires) {
+			DestroyIndexSnapshot(cur.snap)
+			delete(cs.cursors, id)
+		}
+	}
+}
+
+// Purge releases cursors on index instances that are not present in
+// indexInstMap.
+func (cs *scanCursors) Purge(indexInstMap common.IndexInstMap) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for id, cur := range cs.cursors {
+		if _, ok := indexInstMap[cur.instId]; !ok {
+			DestroyIndexSnapshot(cur.snap)
+			delete(cs.cursors, id)
+		}
+	}
+}
+
+func (cs *scanCursors) Len() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return len(cs.cursors)
+}
+
+// resumeInclusion returns the inclusion for resuming a range scan after
+// the last returned key, which itself must be excluded.
+func resumeInclusion(incl Inclusion) Inclusion {
+	if incl == High || incl == Both {
+		return High
+	}
+	return Neither
+}
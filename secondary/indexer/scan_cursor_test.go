@@ -0,0 +1,64 @@
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+	"time"
+)
+
+func TestScanCursorTake(t *testing.T) {
+	cs := newScanCursors(time.Minute)
+
+	id, err := cs.Add(&scanCursor{instId: 1, defnID: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := cs.Take(id)
+	if err != nil || cur.defnID != 10 {
+		t.Errorf("expected cursor %v, got %v (%v)", id, cur, err)
+	}
+
+	// a cursor can be resumed only once
+	if _, err := cs.Take(id); err != ErrCursorNotFound {
+		t.Errorf("expected %v, got %v", ErrCursorNotFound, err)
+	}
+}
+
+func TestScanCursorExpire(t *testing.T) {
+	cs := newScanCursors(0)
+
+	id, _ := cs.Add(&scanCursor{instId: 1})
+	if _, err := cs.Take(id); err != ErrCursorNotFound {
+		t.Errorf("expected expired cursor, got %v", err)
+	}
+
+	cs.Add(&scanCursor{instId: 1})
+	cs.Expire()
+	if cs.Len() != 0 {
+		t.Errorf("expected expired cursors to be removed")
+	}
+}
+
+func TestScanCursorPurge(t *testing.T) {
+	cs := newScanCursors(time.Minute)
+	cs.Add(&scanCursor{instId: 1})
+	id, _ := cs.Add(&scanCursor{instId: 2})
+
+	cs.Purge(common.IndexInstMap{2: common.IndexInst{InstId: 2}})
+	if cs.Len() != 1 {
+		t.Errorf("expected cursors of dropped index to be purged")
+	}
+	if _, err := cs.Take(id); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestResumeInclusion(t *testing.T) {
+	for incl, expected := range map[Inclusion]Inclusion{
+		Neither: Neither, Low: Neither, High: High, Both: High,
+	} {
+		if got := resumeInclusion(incl); got != expected {
+			t.Errorf("inclusion %v: expected %v, got %v", incl, expected, got)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/collatejson"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+)
+
+var (
+	ErrInvalidFilter = errors.New("Invalid scan filter")
+)
+
+// Comparison operator of a filter predicate
+type Comparison uint32
+
+const (
+	Eq Comparison = iota
+	Ne
+	Lt
+	Le
+	Gt
+	Ge
+)
+
+func (op Comparison) String() string {
+	switch op {
+	case Eq:
+		return "=="
+	case Ne:
+		return "!="
+	case Lt:
+		return "<"
+	case Le:
+		return "<="
+	case Gt:
+		return ">"
+	case Ge:
+		return ">="
+	}
+	return "invalid"
+}
+
+// holds compares its result, as returned by bytes.Compare, against op.
+func (op Comparison) holds(cmp int) bool {
+	switch op {
+	case Eq:
+		return cmp == 0
+	case Ne:
+		return cmp != 0
+	case Lt:
+		return cmp < 0
+	case Le:
+		return cmp <= 0
+	case Gt:
+		return cmp > 0
+	case Ge:
+		return cmp >= 0
+	}
+	return false
+}
+
+type predicate struct {
+	position int
+	op       Comparison
+	value    []byte // JSON value as received in request
+	encoded  []byte // collatejson encoding of value
+}
+
+// A scanFilter selects index entries by comparing components of their
+// secondary key, entries are selected if they satisfy all predicates.
+// Components are compared in their collatejson encoding, as read from
+// storage, without decoding the entry.
+type scanFilter struct {
+	predicates []predicate
+	codec      *collatejson.Codec
+}
+
+// newScanFilter compiles filter received in a scan request, returns nil
+// if there is nothing to filter.
+func newScanFilter(f *protobuf.Filter) (*scanFilter, error) {
+	if f == nil || len(f.GetPredicates()) == 0 {
+		return nil, nil
+	}
+
+	sf := &scanFilter{codec: collatejson.NewCodec(16)}
+	for _, p := range f.GetPredicates() {
+		op := Comparison(p.GetOp())
+		if op > Ge {
+			return nil, ErrInvalidFilter
+		}
+		encoded, err := encodeComponent(p.GetValue())
+		if err != nil {
+			return nil, ErrInvalidFilter
+		}
+		sf.predicates = append(sf.predicates, predicate{
+			position: int(p.GetPosition()),
+			op:       op,
+			value:    p.GetValue(),
+			encoded:  encoded,
+		})
+	}
+	return sf, nil
+}
+
+// Match returns true if the secondary key of k satisfies the filter,
+// entries missing a compared component do not match.
+func (sf *scanFilter) Match(k Key) (bool, error) {
+	if len(k.Encoded()) == 0 {
+		return false, nil
+	}
+	components, err := sf.codec.Explode(k.Encoded(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	// Last component of an index entry is the docid
+	nsec := len(components) - 1
+	for _, p := range sf.predicates {
+		if p.position >= nsec {
+			return false, nil
+		}
+		if !p.op.holds(bytes.Compare(components[p.position], p.encoded)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (sf *scanFilter) String() string {
+	str := ""
+	for i, p := range sf.predicates {
+		if i > 0 {
+			str += " and "
+		}
+		str += fmt.Sprintf("[%d] %v %s", p.position, p.op, p.value)
+	}
+	return str
+}
+
+// encodeComponent encodes a JSON value the way it is encoded as a
+// component of an index entry, so that it collates the same way.
+func encodeComponent(value []byte) ([]byte, error) {
+	jsoncodec := collatejson.NewCodec(16)
+	buf := make([]byte, 0, MAX_SEC_KEY_LEN)
+	return jsoncodec.Encode(value, buf)
+}
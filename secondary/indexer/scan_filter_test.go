@@ -0,0 +1,74 @@
+package indexer
+
+import (
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/couchbaselabs/goprotobuf/proto"
+	"testing"
+)
+
+func makeFilter(t *testing.T, preds ...*protobuf.Predicate) *scanFilter {
+	sf, err := newScanFilter(&protobuf.Filter{Predicates: preds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sf
+}
+
+func makePredicate(position int, op Comparison, value string) *protobuf.Predicate {
+	return &protobuf.Predicate{
+		Position: proto.Uint32(uint32(position)),
+		Op:       proto.Uint32(uint32(op)),
+		Value:    []byte(value),
+	}
+}
+
+func TestScanFilterMatch(t *testing.T) {
+	// age > 20 and city != "sfo"
+	sf := makeFilter(t,
+		makePredicate(0, Gt, `20`),
+		makePredicate(1, Ne, `"sfo"`))
+
+	testcases := map[string]bool{
+		`[21,"nyc","doc1"]`:                     true,
+		`[20,"nyc","doc2"]`:                     false,
+		`[30,"sfo","doc3"]`:                     false,
+		`[20.5,"blr","doc4"]`:                   true,
+		`["21","nyc","doc5"]`:                   true, // strings collate after numbers
+		`[null,"nyc","doc6"]`:                   false,
+		`[40,"doc7"]`:                           false, // missing component
+		`[21,"a\u0000b",{"city":"sfo"},"doc8"]`: true,
+	}
+	for raw, expected := range testcases {
+		key, err := NewKey([]byte(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// entries are matched as read from storage, without decoding
+		k, _ := NewKeyFromEncodedBytes(key.Encoded())
+		if ok, err := sf.Match(k); err != nil {
+			t.Fatal(err)
+		} else if ok != expected {
+			t.Errorf("%v: expected %v, got %v", raw, expected, ok)
+		}
+	}
+}
+
+func TestScanFilterInvalid(t *testing.T) {
+	if sf, err := newScanFilter(nil); sf != nil || err != nil {
+		t.Errorf("expected no filter, got %v (%v)", sf, err)
+	}
+
+	f := &protobuf.Filter{
+		Predicates: []*protobuf.Predicate{makePredicate(0, Ge+1, `1`)},
+	}
+	if _, err := newScanFilter(f); err != ErrInvalidFilter {
+		t.Errorf("expected %v, got %v", ErrInvalidFilter, err)
+	}
+
+	f = &protobuf.Filter{
+		Predicates: []*protobuf.Predicate{makePredicate(0, Eq, `{bad`)},
+	}
+	if _, err := newScanFilter(f); err != ErrInvalidFilter {
+		t.Errorf("expected %v, got %v", ErrInvalidFilter, err)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/collatejson"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+)
+
+var (
+	ErrInvalidGroup = errors.New("Invalid group aggregate")
+)
+
+// Aggregate function computed for each group of a group-by scan
+type AggregateFunc uint32
+
+const (
+	AggrCount AggregateFunc = iota
+	AggrSum
+	AggrMin
+	AggrMax
+)
+
+func (fn AggregateFunc) String() string {
+	switch fn {
+	case AggrCount:
+		return "count"
+	case AggrSum:
+		return "sum"
+	case AggrMin:
+		return "min"
+	case AggrMax:
+		return "max"
+	}
+	return "invalid"
+}
+
+type aggregate struct {
+	fn       AggregateFunc
+	position int
+}
+
+// A scanGroup groups index entries by the leading `length` components
+// of their secondary key, and computes aggregates for every group.
+type scanGroup struct {
+	length int
+	aggrs  []aggregate
+}
+
+// newScanGroup compiles the grouping received in a group-by request.
+func newScanGroup(r *protobuf.GroupAggregateRequest) (*scanGroup, error) {
+	sg := &scanGroup{length: int(r.GetGroupLength())}
+	for _, a := range r.GetAggregates() {
+		fn := AggregateFunc(a.GetFunction())
+		if fn > AggrMax {
+			return nil, ErrInvalidGroup
+		}
+		sg.aggrs = append(sg.aggrs, aggregate{
+			fn:       fn,
+			position: int(a.GetPosition()),
+		})
+	}
+	return sg, nil
+}
+
+// Validate grouping against the number of components in secondary key
+// of the index, primary index entries have no secondary components and
+// can only be counted as a single group.
+func (sg *scanGroup) Validate(nsec int) error {
+	if sg.length > nsec {
+		return ErrInvalidGroup
+	}
+	for _, a := range sg.aggrs {
+		if a.fn != AggrCount && a.position >= nsec {
+			return ErrInvalidGroup
+		}
+	}
+	return nil
+}
+
+func (sg *scanGroup) String() string {
+	str := fmt.Sprintf("[:%d]", sg.length)
+	for _, a := range sg.aggrs {
+		if a.fn == AggrCount {
+			str += " count"
+		} else {
+			str += fmt.Sprintf(" %v[%d]", a.fn, a.position)
+		}
+	}
+	return str
+}
+
+// Result of a group, key and aggregates are JSON arrays.
+type groupRow struct {
+	key        []byte
+	aggregates []byte
+}
+
+func (row *groupRow) size() int64 {
+	return int64(len(row.key) + len(row.aggregates))
+}
+
+// groupAggregator folds index entries, read in index order, into groups.
+// Entries of a group are adjacent in index order, hence a group is
+// complete as soon as an entry of the next group is read.
+type groupAggregator struct {
+	group   *scanGroup
+	key     []json.RawMessage // group key of current group
+	encoded []byte            // collatejson encoding of group key
+	count   int64
+	sums    []float64
+	summed  []bool
+	values  [][]byte // raw value of min/max aggregates
+	ordinal [][]byte // collatejson encoding of min/max values
+}
+
+func newGroupAggregator(group *scanGroup) *groupAggregator {
+	return &groupAggregator{group: group}
+}
+
+// Add an index entry to its group, returns the previous group if the
+// entry starts a new one.
+func (ga *groupAggregator) Add(k Key) (*groupRow, error) {
+	var components []json.RawMessage
+	if err := json.Unmarshal(k.Raw(), &components); err != nil {
+		return nil, err
+	}
+	// Last component of an index entry is the docid
+	nsec := len(components) - 1
+	if nsec < ga.group.length {
+		return nil, ErrInvalidGroup
+	}
+
+	key := components[:ga.group.length]
+	encoded, err := encodeGroupKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var row *groupRow
+	if ga.count > 0 && !bytes.Equal(encoded, ga.encoded) {
+		if row, err = ga.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	if ga.count == 0 {
+		ga.reset(key, encoded)
+	}
+
+	ga.count++
+	for i, a := range ga.group.aggrs {
+		if a.fn == AggrCount || a.position >= nsec {
+			continue
+		}
+		if err := ga.accumulate(i, a.fn, components[a.position]); err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+// Flush returns the current group, if any, and starts afresh.
+func (ga *groupAggregator) Flush() (*groupRow, error) {
+	if ga.count == 0 {
+		return nil, nil
+	}
+
+	aggrs := make([]interface{}, 0, len(ga.group.aggrs))
+	for i, a := range ga.group.aggrs {
+		switch a.fn {
+		case AggrCount:
+			aggrs = append(aggrs, ga.count)
+		case AggrSum:
+			if ga.summed[i] {
+				aggrs = append(aggrs, ga.sums[i])
+			} else {
+				aggrs = append(aggrs, nil)
+			}
+		case AggrMin, AggrMax:
+			if ga.values[i] != nil {
+				aggrs = append(aggrs, json.RawMessage(ga.values[i]))
+			} else {
+				aggrs = append(aggrs, nil)
+			}
+		}
+	}
+
+	key, err := json.Marshal(ga.key)
+	if err != nil {
+		return nil, err
+	}
+	aggregates, err := json.Marshal(aggrs)
+	if err != nil {
+		return nil, err
+	}
+	ga.count = 0
+	return &groupRow{key: key, aggregates: aggregates}, nil
+}
+
+func (ga *groupAggregator) reset(key []json.RawMessage, encoded []byte) {
+	n := len(ga.group.aggrs)
+	ga.key, ga.encoded = key, encoded
+	ga.sums, ga.summed = make([]float64, n), make([]bool, n)
+	ga.values, ga.ordinal = make([][]byte, n), make([][]byte, n)
+}
+
+// accumulate value into i-th aggregate of current group. Sum adds
+// numbers only, min and max compare values in index collation order,
+// null and missing values are ignored.
+func (ga *groupAggregator) accumulate(
+	i int, fn AggregateFunc, value json.RawMessage) error {
+
+	var v interface{}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	} else if s, ok := v.(string); ok && collatejson.MissingLiteral.Equal(s) {
+		return nil
+	}
+
+	switch fn {
+	case AggrSum:
+		if n, ok := v.(float64); ok {
+			ga.sums[i] += n
+			ga.summed[i] = true
+		}
+	case AggrMin, AggrMax:
+		encoded, err := encodeComponent(value)
+		if err != nil {
+			return err
+		}
+		if ga.ordinal[i] != nil {
+			cmp := bytes.Compare(encoded, ga.ordinal[i])
+			if (fn == AggrMin && cmp >= 0) || (fn == AggrMax && cmp <= 0) {
+				return nil
+			}
+		}
+		ga.values[i], ga.ordinal[i] = value, encoded
+	}
+	return nil
+}
+
+// encodeGroupKey encodes components of a group key, so that keys
+// comparing equal in index collation belong to the same group.
+func encodeGroupKey(key []json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	jsoncodec := collatejson.NewCodec(16)
+	buf := make([]byte, 0, MAX_SEC_KEY_LEN)
+	return jsoncodec.Encode(data, buf)
+}
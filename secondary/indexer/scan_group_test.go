@@ -0,0 +1,108 @@
+package indexer
+
+import (
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/couchbaselabs/goprotobuf/proto"
+	"testing"
+)
+
+func makeGroup(t *testing.T, length int, aggrs ...*protobuf.Aggregate) *scanGroup {
+	sg, err := newScanGroup(&protobuf.GroupAggregateRequest{
+		GroupLength: proto.Uint32(uint32(length)),
+		Aggregates:  aggrs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sg
+}
+
+func makeAggregate(fn AggregateFunc, position int) *protobuf.Aggregate {
+	return &protobuf.Aggregate{
+		Function: proto.Uint32(uint32(fn)),
+		Position: proto.Uint32(uint32(position)),
+	}
+}
+
+func TestGroupAggregator(t *testing.T) {
+	// group by city, count(*), sum(age), min(age), max(name)
+	sg := makeGroup(t, 1,
+		makeAggregate(AggrCount, 0),
+		makeAggregate(AggrSum, 1),
+		makeAggregate(AggrMin, 1),
+		makeAggregate(AggrMax, 2))
+
+	entries := []string{
+		`["blr",30,"bob","doc1"]`,
+		`["blr",20,"alice","doc2"]`,
+		`["blr",null,"carol","doc3"]`,
+		`["nyc","x","dave","doc4"]`,
+		`["sfo",40,"eve","doc5"]`,
+		`["sfo",41.5,"frank","doc6"]`,
+	}
+	expected := [][2]string{
+		{`["blr"]`, `[3,50,20,"carol"]`},
+		{`["nyc"]`, `[1,null,"x","dave"]`},
+		{`["sfo"]`, `[2,81.5,40,"frank"]`},
+	}
+
+	ga := newGroupAggregator(sg)
+	var rows []*groupRow
+	for _, raw := range entries {
+		k, err := NewKey([]byte(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		row, err := ga.Add(k)
+		if err != nil {
+			t.Fatal(err)
+		} else if row != nil {
+			rows = append(rows, row)
+		}
+	}
+	if row, err := ga.Flush(); err != nil {
+		t.Fatal(err)
+	} else if row != nil {
+		rows = append(rows, row)
+	}
+
+	if len(rows) != len(expected) {
+		t.Fatalf("expected %v groups, got %v", len(expected), len(rows))
+	}
+	for i, row := range rows {
+		if string(row.key) != expected[i][0] {
+			t.Errorf("expected group %s, got %s", expected[i][0], row.key)
+		}
+		if string(row.aggregates) != expected[i][1] {
+			t.Errorf("%s: expected %s, got %s",
+				row.key, expected[i][1], row.aggregates)
+		}
+	}
+
+	if row, err := ga.Flush(); row != nil || err != nil {
+		t.Errorf("expected no group after flush, got %v (%v)", row, err)
+	}
+}
+
+func TestScanGroupValidate(t *testing.T) {
+	if _, err := newScanGroup(&protobuf.GroupAggregateRequest{
+		GroupLength: proto.Uint32(1),
+		Aggregates:  []*protobuf.Aggregate{makeAggregate(AggrMax+1, 0)},
+	}); err != ErrInvalidGroup {
+		t.Errorf("expected %v, got %v", ErrInvalidGroup, err)
+	}
+
+	sg := makeGroup(t, 2, makeAggregate(AggrSum, 2))
+	if err := sg.Validate(3); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := sg.Validate(2); err != ErrInvalidGroup {
+		t.Errorf("expected %v, got %v", ErrInvalidGroup, err)
+	}
+
+	// primary index can only be counted
+	sg = makeGroup(t, 0, makeAggregate(AggrCount, 5))
+	if err := sg.Validate(0); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
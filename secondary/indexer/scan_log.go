@@ -0,0 +1,146 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds, in milliseconds, of the latency buckets scans are counted
+// in, scans beyond the last bound are counted in an overflow bucket.
+var scanLatencyBounds = []int64{10, 100, 1000, 10000}
+
+// A scan that took longer than the slow scan threshold, as reported by
+// the admin API
+type slowScanInfo struct {
+	ScanId   uint64    `json:"scanId"`
+	Time     time.Time `json:"time"`
+	Bucket   string    `json:"bucket"`
+	Index    string    `json:"index"`
+	Type     string    `json:"type"`
+	Spans    int       `json:"spans"` // ranges or keys scanned
+	Duration int64     `json:"durationMs"`
+	Rows     int64     `json:"rows"`
+	Client   string    `json:"client"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Latencies of finished scans, counted by latency bucket, and the most
+// recent slow scans.
+type scanLog struct {
+	threshold time.Duration // 0 disables logging slow scans
+
+	// counters, updated atomically
+	latencies []int64 // no. of scans by latency bucket
+	timeouts  int64   // scans that timed out
+	slowScans int64   // scans slower than threshold
+
+	mu   sync.Mutex
+	slow []slowScanInfo // ring buffer
+	next int            // index of the next slow scan in ring buffer
+}
+
+func newScanLog(threshold time.Duration, size int) *scanLog {
+	if size < 1 {
+		size = 1
+	}
+	return &scanLog{
+		threshold: threshold,
+		latencies: make([]int64, len(scanLatencyBounds)+1),
+		slow:      make([]slowScanInfo, 0, size),
+	}
+}
+
+// Record a finished scan that took `elapsed` and failed with `err`, if
+// not nil.
+func (l *scanLog) Record(sd *scanDescriptor, err error, elapsed time.Duration) {
+	ms := int64(elapsed / time.Millisecond)
+	i := 0
+	for i < len(scanLatencyBounds) && ms > scanLatencyBounds[i] {
+		i++
+	}
+	atomic.AddInt64(&l.latencies[i], 1)
+
+	if err == ErrScanTimedOut || err == ErrSnapNotAvailable {
+		atomic.AddInt64(&l.timeouts, 1)
+	}
+
+	if l.threshold <= 0 || elapsed < l.threshold {
+		return
+	}
+	atomic.AddInt64(&l.slowScans, 1)
+
+	info := slowScanInfo{
+		ScanId:   sd.scanId,
+		Time:     time.Now(),
+		Bucket:   sd.p.bucket,
+		Index:    sd.p.indexName,
+		Type:     string(sd.p.scanType),
+		Spans:    scanSpans(sd.p),
+		Duration: ms,
+		Rows:     atomic.LoadInt64(&sd.rows),
+		Client:   sd.client,
+	}
+	if err != nil {
+		info.Error = err.Error()
+	}
+	common.Warnf("ScanCoordinator: SLOW_SCAN: %v, client: %v, spans: %v, "+
+		"rows: %v, took %v (%v)", sd, info.Client, info.Spans, info.Rows,
+		elapsed, err)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.slow) < cap(l.slow) {
+		l.slow = append(l.slow, info)
+	} else {
+		l.slow[l.next] = info
+	}
+	l.next = (l.next + 1) % cap(l.slow)
+}
+
+// Slow scans recently logged, most recent first.
+func (l *scanLog) Slow() []slowScanInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slow := make([]slowScanInfo, 0, len(l.slow))
+	for i := 1; i <= len(l.slow); i++ {
+		slow = append(slow, l.slow[(l.next-i+len(l.slow))%len(l.slow)])
+	}
+	return slow
+}
+
+// Stats returns the counters by their stat name.
+func (l *scanLog) Stats() map[string]int64 {
+	stats := make(map[string]int64)
+	for i, bound := range scanLatencyBounds {
+		name := fmt.Sprintf("num_scans_latency_le_%dms", bound)
+		stats[name] = atomic.LoadInt64(&l.latencies[i])
+	}
+	last := scanLatencyBounds[len(scanLatencyBounds)-1]
+	name := fmt.Sprintf("num_scans_latency_gt_%dms", last)
+	stats[name] = atomic.LoadInt64(&l.latencies[len(scanLatencyBounds)])
+	stats["num_scan_timeouts"] = atomic.LoadInt64(&l.timeouts)
+	stats["num_slow_scans"] = atomic.LoadInt64(&l.slowScans)
+	return stats
+}
+
+// scanSpans is the range cardinality of a scan, the number of ranges or
+// keys it scans. Full scans are a single range.
+func scanSpans(p *scanParams) int {
+	if len(p.keys) > 0 {
+		return len(p.keys)
+	}
+	return 1
+}
@@ -0,0 +1,37 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanLog(t *testing.T) {
+	l := newScanLog(time.Second, 2)
+	l.Record(newTestScan(1, time.Now()), nil, 5*time.Millisecond)
+	l.Record(newTestScan(2, time.Now()), ErrScanTimedOut, 50*time.Millisecond)
+	for i := uint64(3); i <= 5; i++ {
+		sd := newTestScan(i, time.Now())
+		sd.client = "127.0.0.1:5000"
+		sd.rows = int64(i)
+		l.Record(sd, nil, time.Duration(i)*time.Second)
+	}
+
+	stats := l.Stats()
+	if stats["num_scans_latency_le_10ms"] != 1 || stats["num_scans_latency_le_100ms"] != 1 ||
+		stats["num_scans_latency_le_10000ms"] != 3 || stats["num_scans_latency_gt_10000ms"] != 0 {
+		t.Errorf("unexpected latency stats %v", stats)
+	}
+	if stats["num_scan_timeouts"] != 1 || stats["num_slow_scans"] != 3 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+
+	// only the 2 most recent slow scans are retained, most recent first.
+	slow := l.Slow()
+	if len(slow) != 2 || slow[0].ScanId != 5 || slow[1].ScanId != 4 {
+		t.Fatalf("unexpected slow scans %v", slow)
+	}
+	if slow[0].Duration != 5000 || slow[0].Rows != 5 || slow[0].Spans != 1 ||
+		slow[0].Client != "127.0.0.1:5000" || slow[0].Index != "idx" {
+		t.Errorf("unexpected slow scan %+v", slow[0])
+	}
+}
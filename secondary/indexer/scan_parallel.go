@@ -0,0 +1,161 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"sync"
+)
+
+// Full table scan of all slices, across partitions, of an index snapshot.
+// Slices are read in parallel, upto scanParallelism at a time, and their
+// entries interleaved into sd.respch. If the scan asks for ordered
+// entries, all slices are read together and merged in index order.
+func (s *scanCoordinator) scanAllSlices(sd *scanDescriptor, snap IndexSnapshot) {
+	slices := snapshotSlices(snap)
+
+	common.Debugf("%v: scanAllSlices: SCAN_ID: %v slices: %v ordered: %v",
+		s.logPrefix, sd.scanId, len(slices), sd.p.ordered)
+
+	if sd.p.ordered && len(slices) > 1 {
+		s.mergeSlices(sd, slices, func(snap Snapshot) (chan Key, chan error) {
+			return snap.KeySet(sd.stopch)
+		})
+	} else {
+		s.readSlices(sd, slices, s.config["scanParallelism"].Int())
+	}
+}
+
+// Range scan of all slices, across partitions, of an index snapshot,
+// with entries of all slices merged in index order.
+func (s *scanCoordinator) scanRangeOrdered(sd *scanDescriptor, snap IndexSnapshot) {
+	slices := snapshotSlices(snap)
+
+	common.Debugf("%v: scanRangeOrdered: SCAN_ID: %v slices: %v",
+		s.logPrefix, sd.scanId, len(slices))
+
+	s.mergeSlices(sd, slices, func(snap Snapshot) (chan Key, chan error) {
+		chkey, cherr, _ := snap.KeyRange(sd.p.low, sd.p.high, sd.p.incl, sd.stopch)
+		return chkey, cherr
+	})
+}
+
+func snapshotSlices(snap IndexSnapshot) []SliceSnapshot {
+	var slices []SliceSnapshot
+	for _, ps := range snap.Partitions() {
+		for _, ss := range ps.Slices() {
+			slices = append(slices, ss)
+		}
+	}
+	return slices
+}
+
+// readSlices scans slices with upto parallelism workers, each worker
+// picking the next slice once done with the previous one.
+func (s *scanCoordinator) readSlices(sd *scanDescriptor,
+	slices []SliceSnapshot, parallelism int) {
+
+	if parallelism <= 0 || parallelism > len(slices) {
+		parallelism = len(slices)
+	}
+
+	slicech := make(chan SliceSnapshot, len(slices))
+	for _, ss := range slices {
+		slicech <- ss
+	}
+	close(slicech)
+
+	var wg sync.WaitGroup
+	var workerStopChannels []StopChannel
+
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		stopch := make(StopChannel)
+		workerStopChannels = append(workerStopChannels, stopch)
+		go func(stopch StopChannel) {
+			defer wg.Done()
+			for ss := range slicech {
+				select {
+				case <-stopch:
+					// release remaining slices without scanning them
+				default:
+					s.queryScanAll(sd, ss.Snapshot(), stopch)
+				}
+				ss.Snapshot().Close()
+			}
+		}(stopch)
+	}
+
+	s.monitorWorkers(&wg, sd.stopch, workerStopChannels, "readSlices")
+}
+
+// mergeSlices scans all slices together, each with read, and merges
+// their entries in index order.
+func (s *scanCoordinator) mergeSlices(sd *scanDescriptor,
+	slices []SliceSnapshot, read func(Snapshot) (chan Key, chan error)) {
+
+	chkeys := make([]chan Key, 0, len(slices))
+	cherrs := make([]chan error, 0, len(slices))
+	for _, ss := range slices {
+		chkey, cherr := read(ss.Snapshot())
+		chkeys = append(chkeys, chkey)
+		cherrs = append(cherrs, cherr)
+	}
+
+	mergeKeys(chkeys, cherrs, sd.respch)
+
+	for _, ss := range slices {
+		ss.Snapshot().Close()
+	}
+}
+
+// mergeKeys merges key streams, each in index order, into respch till all
+// of them are closed. Errors from a stream are forwarded as they arrive,
+// a closed error channel is no longer waited upon.
+func mergeKeys(chkeys []chan Key, cherrs []chan error, respch chan interface{}) {
+	heads := make([]Key, len(chkeys))
+	valid := make([]bool, len(chkeys))
+	errchs := make([]chan error, len(cherrs))
+	copy(errchs, cherrs)
+
+	next := func(i int) {
+		for {
+			select {
+			case key, ok := <-chkeys[i]:
+				heads[i], valid[i] = key, ok
+				return
+			case err, ok := <-errchs[i]:
+				if !ok {
+					errchs[i] = nil // block on chkeys[i] alone
+				} else if err != nil {
+					respch <- err
+				}
+			}
+		}
+	}
+
+	for i := range chkeys {
+		next(i)
+	}
+
+	for {
+		min := -1
+		for i := range heads {
+			if valid[i] && (min < 0 || heads[i].Compare(heads[min]) < 0) {
+				min = i
+			}
+		}
+		if min < 0 {
+			return
+		}
+		respch <- heads[min]
+		next(min)
+	}
+}
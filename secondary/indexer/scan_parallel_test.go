@@ -0,0 +1,60 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeKeys(t *testing.T) {
+	streams := [][]string{
+		{"a", "d", "g"},
+		{"b", "c", "h", "i"},
+		{},
+		{"e", "f"},
+	}
+
+	chkeys := make([]chan Key, len(streams))
+	cherrs := make([]chan error, len(streams))
+	for i, stream := range streams {
+		chkeys[i], cherrs[i] = make(chan Key), make(chan error)
+		go func(keys []string, chkey chan Key, cherr chan error) {
+			defer close(chkey)
+			if len(keys) > 0 {
+				// keys still follow a closed error channel.
+				close(cherr)
+			}
+			for _, k := range keys {
+				key, _ := NewKeyFromEncodedBytes([]byte(k))
+				chkey <- key
+			}
+			if len(keys) == 0 {
+				cherr <- ErrInternal
+			}
+		}(stream, chkeys[i], cherrs[i])
+	}
+
+	respch := make(chan interface{})
+	go func() {
+		mergeKeys(chkeys, cherrs, respch)
+		close(respch)
+	}()
+
+	var keys []string
+	var errs []error
+	for resp := range respch {
+		switch r := resp.(type) {
+		case Key:
+			keys = append(keys, string(r.Encoded()))
+		case error:
+			errs = append(errs, r)
+		}
+	}
+
+	ref := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}
+	if !reflect.DeepEqual(keys, ref) {
+		t.Fatalf("expected %v, got %v", ref, keys)
+	}
+	if len(errs) != 1 || errs[0] != ErrInternal {
+		t.Fatalf("expected %v, got %v", ErrInternal, errs)
+	}
+}
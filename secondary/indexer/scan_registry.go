@@ -0,0 +1,229 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"github.com/couchbase/indexing/secondary/common"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrScanNotFound = errors.New("Scan not found or already finished")
+)
+
+// Information about an in-flight scan, as reported by the admin API
+type activeScanInfo struct {
+	ScanId        uint64 `json:"scanId"`
+	Bucket        string `json:"bucket"`
+	Index         string `json:"index"`
+	Type          string `json:"type"`
+	Duration      int64  `json:"durationMs"`
+	Rows          int64  `json:"rows"`
+	BytesRead     int64  `json:"bytesRead"`
+	BytesBuffered int64  `json:"bytesBuffered"`
+	Killed        bool   `json:"killed"`
+}
+
+// Outcome of draining in-flight scans of an index instance
+type scanDrainStats struct {
+	Drained   int // scans that finished within the deadline
+	Cancelled int // scans killed at the deadline
+}
+
+// Outcome of draining in-flight scans of a dropped index
+type scanDropInfo struct {
+	Index string // as <bucket>:<name>
+	scanDrainStats
+}
+
+// In-flight scans, indexed by scan id, that can be listed and killed
+// through the admin API.
+type activeScans struct {
+	// cumulative drain outcomes, updated atomically
+	drained   int64
+	cancelled int64
+
+	mu       sync.Mutex
+	scans    map[uint64]*scanDescriptor
+	limit    int           // maximum in-flight scans, 0 for no limit
+	lastDrop *scanDropInfo // last dropped index, nil if none
+}
+
+func newActiveScans(limit int) *activeScans {
+	return &activeScans{scans: make(map[uint64]*scanDescriptor), limit: limit}
+}
+
+// Add registers a scan, the scan should be removed once it is finished.
+// Fails with ErrorServerBusy if as many scans as the limit are in-flight.
+func (as *activeScans) Add(sd *scanDescriptor) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if as.limit > 0 && len(as.scans) >= as.limit {
+		return common.ErrorServerBusy
+	}
+	sd.donech = make(chan struct{})
+	as.scans[sd.scanId] = sd
+	return nil
+}
+
+// Remove a finished scan.
+func (as *activeScans) Remove(scanId uint64) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if sd, ok := as.scans[scanId]; ok {
+		close(sd.donech)
+		delete(as.scans, scanId)
+	}
+}
+
+// Kill a scan by closing its kill channel, the scan returns
+// ErrScanKilled to its client.
+func (as *activeScans) Kill(scanId uint64) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	sd, ok := as.scans[scanId]
+	if !ok {
+		return ErrScanNotFound
+	}
+	if !sd.killed {
+		sd.killed = true
+		close(sd.killch)
+	}
+	return nil
+}
+
+// Drain waits upto timeout for in-flight scans on index instance instId
+// to finish. Scans still running past timeout are killed, and waited upto
+// another timeout to stop. New scans on the instance are expected to be
+// refused by the caller while draining.
+func (as *activeScans) Drain(instId common.IndexInstId,
+	timeout time.Duration) scanDrainStats {
+
+	as.mu.Lock()
+	inflight := make([]*scanDescriptor, 0)
+	for _, sd := range as.scans {
+		if sd.instId == instId {
+			inflight = append(inflight, sd)
+		}
+	}
+	as.mu.Unlock()
+
+	var stats scanDrainStats
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	killed := make([]*scanDescriptor, 0)
+	expired := false
+	for _, sd := range inflight {
+		if !expired {
+			select {
+			case <-sd.donech:
+				stats.Drained++
+				continue
+			case <-deadline.C:
+				expired = true
+			}
+		}
+		if err := as.Kill(sd.scanId); err == ErrScanNotFound {
+			stats.Drained++
+			continue
+		}
+		killed = append(killed, sd)
+	}
+	stats.Cancelled = len(killed)
+
+	if len(killed) > 0 {
+		grace := time.NewTimer(timeout)
+		defer grace.Stop()
+	wait:
+		for _, sd := range killed {
+			select {
+			case <-sd.donech:
+			case <-grace.C:
+				common.Warnf("ScanCoordinator: Killed scans of index "+
+					"instance %v did not stop within %v", instId, timeout)
+				break wait
+			}
+		}
+	}
+
+	atomic.AddInt64(&as.drained, int64(stats.Drained))
+	atomic.AddInt64(&as.cancelled, int64(stats.Cancelled))
+	return stats
+}
+
+// DrainStats returns the number of scans drained and cancelled so far
+// while dropping indexes.
+func (as *activeScans) DrainStats() (drained, cancelled int64) {
+	return atomic.LoadInt64(&as.drained), atomic.LoadInt64(&as.cancelled)
+}
+
+// RecordDrop keeps the outcome of draining scans of dropped `index`, so
+// that it is reported along with stats.
+func (as *activeScans) RecordDrop(index string, stats scanDrainStats) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.lastDrop = &scanDropInfo{Index: index, scanDrainStats: stats}
+}
+
+// LastDrop returns the outcome of draining scans of the last dropped
+// index, nil if no index was dropped.
+func (as *activeScans) LastDrop() *scanDropInfo {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return as.lastDrop
+}
+
+// List returns in-flight scans running for at least minDuration, longest
+// running scans first.
+func (as *activeScans) List(minDuration time.Duration) []activeScanInfo {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	now := time.Now()
+	infos := make([]activeScanInfo, 0, len(as.scans))
+	for _, sd := range as.scans {
+		duration := now.Sub(sd.startTime)
+		if duration < minDuration {
+			continue
+		}
+		infos = append(infos, activeScanInfo{
+			ScanId:        sd.scanId,
+			Bucket:        sd.p.bucket,
+			Index:         sd.p.indexName,
+			Type:          string(sd.p.scanType),
+			Duration:      int64(duration / time.Millisecond),
+			Rows:          atomic.LoadInt64(&sd.rows),
+			BytesRead:     atomic.LoadInt64(&sd.bytesRead),
+			BytesBuffered: atomic.LoadInt64(&sd.bytesBuffered),
+			Killed:        sd.killed,
+		})
+	}
+	sort.Sort(byDuration(infos))
+	return infos
+}
+
+// Len returns the number of in-flight scans.
+func (as *activeScans) Len() int {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return len(as.scans)
+}
+
+type byDuration []activeScanInfo
+
+func (s byDuration) Len() int           { return len(s) }
+func (s byDuration) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byDuration) Less(i, j int) bool { return s[i].Duration > s[j].Duration }
@@ -0,0 +1,182 @@
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+	"time"
+)
+
+func newTestScan(scanId uint64, startTime time.Time) *scanDescriptor {
+	return &scanDescriptor{
+		scanId:    scanId,
+		p:         &scanParams{scanType: queryScan, bucket: "default", indexName: "idx"},
+		startTime: startTime,
+		killch:    make(chan struct{}),
+	}
+}
+
+func TestActiveScansList(t *testing.T) {
+	as := newActiveScans(0)
+	as.Add(newTestScan(1, time.Now()))
+	sd := newTestScan(2, time.Now().Add(-time.Minute))
+	sd.bytesBuffered = 100
+	as.Add(sd)
+
+	infos := as.List(0)
+	if len(infos) != 2 || infos[0].ScanId != 2 || infos[0].BytesBuffered != 100 {
+		t.Errorf("unexpected scans %v", infos)
+	}
+	if infos = as.List(time.Second); len(infos) != 1 || infos[0].ScanId != 2 {
+		t.Errorf("expected only long running scan, got %v", infos)
+	}
+
+	as.Remove(2)
+	if as.Len() != 1 {
+		t.Errorf("expected finished scan to be removed")
+	}
+}
+
+func TestActiveScansLimit(t *testing.T) {
+	as := newActiveScans(2)
+	for id := uint64(1); id <= 2; id++ {
+		if err := as.Add(newTestScan(id, time.Now())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := as.Add(newTestScan(3, time.Now())); err != common.ErrorServerBusy {
+		t.Fatalf("expected %v, got %v", common.ErrorServerBusy, err)
+	} else if as.Len() != 2 {
+		t.Fatalf("expected rejected scan not to be added")
+	}
+
+	// finished scans make room for new ones.
+	as.Remove(1)
+	if err := as.Add(newTestScan(3, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestActiveScansKill(t *testing.T) {
+	as := newActiveScans(0)
+	sd := newTestScan(1, time.Now())
+	as.Add(sd)
+
+	if err := as.Kill(1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sd.killch:
+	default:
+		t.Errorf("expected kill channel to be closed")
+	}
+	// killing twice is harmless
+	if err := as.Kill(1); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if infos := as.List(0); !infos[0].Killed {
+		t.Errorf("expected scan to be reported as killed")
+	}
+
+	as.Remove(1)
+	if err := as.Kill(1); err != ErrScanNotFound {
+		t.Errorf("expected %v, got %v", ErrScanNotFound, err)
+	}
+}
+
+func TestActiveScansDrain(t *testing.T) {
+	as := newActiveScans(0)
+	finished := newTestScan(1, time.Now())
+	finished.instId = 10
+	stuck := newTestScan(2, time.Now())
+	stuck.instId = 10
+	other := newTestScan(3, time.Now())
+	other.instId = 20
+	as.Add(finished)
+	as.Add(stuck)
+	as.Add(other)
+
+	// a scan finishing in time is drained, the one that does not is
+	// killed and stops once it sees the kill.
+	go as.Remove(1)
+	go func() {
+		<-stuck.killch
+		as.Remove(2)
+	}()
+	stats := as.Drain(10, 100*time.Millisecond)
+	if stats.Drained != 1 || stats.Cancelled != 1 {
+		t.Errorf("unexpected drain outcome %+v", stats)
+	}
+	if drained, cancelled := as.DrainStats(); drained != 1 || cancelled != 1 {
+		t.Errorf("unexpected drain stats %v %v", drained, cancelled)
+	}
+	if as.Len() != 1 || other.killed {
+		t.Errorf("expected scans of other index to be left alone")
+	}
+}
+
+func TestDropIndexResponse(t *testing.T) {
+	drainch := make(chan scanDrainStats, 1)
+	clientCh := make(MsgChannel)
+	go sendDropIndexResponse(drainch, clientCh)
+
+	// response waits for the scans to be drained.
+	select {
+	case msg := <-clientCh:
+		t.Fatalf("unexpected response before drain %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	drainch <- scanDrainStats{Drained: 2, Cancelled: 1}
+	msg := <-clientCh
+	if msg.GetMsgType() != MSG_SUCCESS {
+		t.Fatalf("expected success, got %v", msg)
+	}
+	res := msg.(*MsgDropIndexResponse)
+	if res.GetDrainedScans() != 2 || res.GetCancelledScans() != 1 {
+		t.Errorf("unexpected drain outcome %v %v",
+			res.GetDrainedScans(), res.GetCancelledScans())
+	}
+}
+
+func TestDrainScansStats(t *testing.T) {
+	s := &scanCoordinator{
+		supvCmdch: make(MsgChannel, 1),
+		config:    common.SystemConfig.SectionConfig("indexer.", true),
+		scans:     newActiveScans(0),
+	}
+	if drop := s.scans.LastDrop(); drop != nil {
+		t.Fatalf("unexpected drop %+v", drop)
+	}
+
+	sd := newTestScan(1, time.Now())
+	sd.instId = 10
+	s.scans.Add(sd)
+	go s.scans.Remove(1)
+
+	respch := make(chan scanDrainStats, 1)
+	s.handleDrainScans(&MsgDrainScans{instId: 10, index: "default:idx", respch: respch})
+	<-s.supvCmdch
+	stats := <-respch
+	if stats.Drained != 1 || stats.Cancelled != 0 {
+		t.Fatalf("unexpected drain outcome %+v", stats)
+	}
+
+	// outcome of the drop is reported in stats.
+	drop := s.scans.LastDrop()
+	if drop == nil || drop.Index != "default:idx" || drop.Drained != 1 || drop.Cancelled != 0 {
+		t.Fatalf("unexpected drop %+v", drop)
+	}
+}
+
+func TestReadKeyBatchKilled(t *testing.T) {
+	sd := newTestScan(1, time.Now())
+	sd.respch = make(chan interface{})
+	sd.stopch = make(StopChannel)
+	close(sd.killch)
+
+	rdr := newResponseReader(sd)
+	if _, _, err := rdr.ReadKeyBatch(); err != ErrScanKilled {
+		t.Errorf("expected %v, got %v", ErrScanKilled, err)
+	}
+	close(sd.respch)
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/couchbase/indexing/secondary/common"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const SLICE_WAL_FILE = "wal.log"
+
+// records larger than this can only be the result of a corrupt header
+const SLICE_WAL_MAX_RECORD = 64 * 1024 * 1024
+
+var (
+	ErrWalRecordCorrupt = errors.New("WAL Record Corrupt")
+)
+
+type walRecordType byte
+
+const (
+	WAL_RECORD_INSERT walRecordType = iota + 1
+	WAL_RECORD_DELETE
+	WAL_RECORD_SNAPSHOT
+)
+
+// walRecord is a single entry of slice write-ahead log. Insert carries
+// encoded key and value, delete carries the docid and snapshot carries
+// the timestamp upto which preceding records were flushed.
+type walRecord struct {
+	typ   walRecordType
+	key   []byte
+	value []byte
+	ts    *common.TsVbuuid
+}
+
+// sliceWal is a write-ahead log of index entries flushed to a slice
+// since its last persisted snapshot. Records are appended as they are
+// written to the slice and synced to disk when an in-memory snapshot is
+// created. On restart, records upto the last synced snapshot can be
+// re-applied to the slice, so that recovery only needs to catchup from
+// that snapshot instead of the last persisted one. Log is truncated
+// whenever slice persists a snapshot or rolls back.
+// Append methods can be called concurrently.
+type sliceWal struct {
+	lock sync.Mutex
+	path string
+	file *os.File
+	buf  *bufio.Writer
+	size int64
+}
+
+// openSliceWal opens the write-ahead log in slice directory, creating
+// it if it doesn't exist.
+func openSliceWal(dirpath string) (*sliceWal, error) {
+
+	path := filepath.Join(dirpath, SLICE_WAL_FILE)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	wal := &sliceWal{
+		path: path,
+		file: file,
+		buf:  bufio.NewWriter(file),
+	}
+	return wal, nil
+}
+
+// AppendInsert logs insert of an encoded key/value pair
+func (w *sliceWal) AppendInsert(key []byte, value []byte) error {
+
+	payload := make([]byte, 4+len(key)+len(value))
+	binary.BigEndian.PutUint32(payload[:4], uint32(len(key)))
+	copy(payload[4:], key)
+	copy(payload[4+len(key):], value)
+	return w.append(WAL_RECORD_INSERT, payload)
+}
+
+// AppendDelete logs delete of a docid
+func (w *sliceWal) AppendDelete(docid []byte) error {
+	return w.append(WAL_RECORD_DELETE, docid)
+}
+
+// Snapshot logs that all preceding records are flushed upto the given
+// timestamp and syncs the log to disk.
+func (w *sliceWal) Snapshot(ts *common.TsVbuuid) error {
+
+	payload, err := json.Marshal(ts)
+	if err != nil {
+		return err
+	}
+	if err = w.append(WAL_RECORD_SNAPSHOT, payload); err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err = w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Truncate discards all records, once they are persisted in slice
+// or rolled back.
+func (w *sliceWal) Truncate() error {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buf.Reset(w.file)
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, 0); err != nil {
+		return err
+	}
+	w.size = 0
+	return w.file.Sync()
+}
+
+// Size returns the number of bytes logged since last truncate
+func (w *sliceWal) Size() int64 {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.size
+}
+
+// Replay reads the log from beginning and calls apply for every insert
+// and delete record followed by a snapshot record. Records after the
+// last snapshot record, including a partially written one, are
+// discarded. Returns timestamp of the last snapshot record, nil if
+// there is none.
+func (w *sliceWal) Replay(apply func(rec *walRecord) error) (*common.TsVbuuid, error) {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, err := w.file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	var lastTs *common.TsVbuuid
+	var size, lastSize int64
+	pending := make([]*walRecord, 0)
+
+	r := bufio.NewReader(w.file)
+	for {
+		rec, n, err := readWalRecord(r)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF &&
+				err != ErrWalRecordCorrupt {
+				return nil, err
+			}
+			break
+		}
+		size += n
+
+		if rec.typ != WAL_RECORD_SNAPSHOT {
+			pending = append(pending, rec)
+			continue
+		}
+		for _, p := range pending {
+			if err := apply(p); err != nil {
+				return nil, err
+			}
+		}
+		pending = pending[:0]
+		lastTs = rec.ts
+		lastSize = size
+	}
+
+	//drop the unsynced tail, further records get appended after the
+	//last snapshot record
+	if err := w.file.Truncate(lastSize); err != nil {
+		return nil, err
+	}
+	if _, err := w.file.Seek(lastSize, 0); err != nil {
+		return nil, err
+	}
+	w.buf.Reset(w.file)
+	w.size = lastSize
+
+	return lastTs, nil
+}
+
+// Close the log file, records are retained on disk.
+func (w *sliceWal) Close() error {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buf.Flush()
+	return w.file.Close()
+}
+
+// Destroy closes and removes the log file.
+func (w *sliceWal) Destroy() error {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.file.Close()
+	return os.Remove(w.path)
+}
+
+// append writes a record as
+// [type:1][payload length:4][payload][crc32 of type and payload:4]
+func (w *sliceWal) append(typ walRecordType, payload []byte) error {
+
+	var hdr [5]byte
+	hdr[0] = byte(typ)
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[:1])
+	crc.Write(payload)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, err := w.buf.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := w.buf.Write(payload); err != nil {
+		return err
+	}
+	if _, err := w.buf.Write(sum[:]); err != nil {
+		return err
+	}
+	w.size += int64(len(hdr) + len(payload) + len(sum))
+	return nil
+}
+
+func readWalRecord(r io.Reader) (*walRecord, int64, error) {
+
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, 0, err
+	}
+
+	plen := binary.BigEndian.Uint32(hdr[1:])
+	if plen > SLICE_WAL_MAX_RECORD {
+		return nil, 0, ErrWalRecordCorrupt
+	}
+	payload := make([]byte, plen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, err
+	}
+
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return nil, 0, err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[:1])
+	crc.Write(payload)
+	if crc.Sum32() != binary.BigEndian.Uint32(sum[:]) {
+		return nil, 0, ErrWalRecordCorrupt
+	}
+
+	n := int64(len(hdr) + len(payload) + len(sum))
+	rec := &walRecord{typ: walRecordType(hdr[0])}
+	switch rec.typ {
+	case WAL_RECORD_INSERT:
+		if len(payload) < 4 {
+			return nil, 0, ErrWalRecordCorrupt
+		}
+		klen := binary.BigEndian.Uint32(payload[:4])
+		if int(klen) > len(payload)-4 {
+			return nil, 0, ErrWalRecordCorrupt
+		}
+		rec.key = payload[4 : 4+klen]
+		rec.value = payload[4+klen:]
+
+	case WAL_RECORD_DELETE:
+		rec.key = payload
+
+	case WAL_RECORD_SNAPSHOT:
+		rec.ts = &common.TsVbuuid{}
+		if err := json.Unmarshal(payload, rec.ts); err != nil {
+			return nil, 0, ErrWalRecordCorrupt
+		}
+
+	default:
+		return nil, 0, ErrWalRecordCorrupt
+	}
+	return rec, n, nil
+}
@@ -0,0 +1,125 @@
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func replayWal(t *testing.T, dir string) ([]*walRecord, *common.TsVbuuid, *sliceWal) {
+	wal, err := openSliceWal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recs []*walRecord
+	ts, err := wal.Replay(func(rec *walRecord) error {
+		recs = append(recs, rec)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recs, ts, wal
+}
+
+func TestSliceWalReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slicewal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wal, err := openSliceWal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := common.NewTsVbuuid("default", 4)
+	ts.Seqnos[1] = 10
+	wal.AppendInsert([]byte("key1"), []byte("value1"))
+	wal.AppendDelete([]byte("doc2"))
+	if err := wal.Snapshot(ts); err != nil {
+		t.Fatal(err)
+	}
+	// not followed by a snapshot, lost on crash
+	wal.AppendInsert([]byte("key3"), []byte("value3"))
+	wal.Close()
+
+	recs, replayTs, wal := replayWal(t, dir)
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %v", len(recs))
+	}
+	if recs[0].typ != WAL_RECORD_INSERT || string(recs[0].key) != "key1" ||
+		string(recs[0].value) != "value1" {
+		t.Errorf("unexpected insert record %v", recs[0])
+	}
+	if recs[1].typ != WAL_RECORD_DELETE || string(recs[1].key) != "doc2" {
+		t.Errorf("unexpected delete record %v", recs[1])
+	}
+	if replayTs == nil || replayTs.Seqnos[1] != 10 {
+		t.Errorf("unexpected replay timestamp %v", replayTs)
+	}
+
+	// entries appended after replay follow the last snapshot
+	wal.AppendInsert([]byte("key4"), []byte("value4"))
+	ts.Seqnos[1] = 20
+	wal.Snapshot(ts)
+	wal.Close()
+
+	recs, replayTs, wal = replayWal(t, dir)
+	if len(recs) != 3 || string(recs[2].key) != "key4" {
+		t.Fatalf("unexpected records %v", recs)
+	}
+	if replayTs.Seqnos[1] != 20 {
+		t.Errorf("unexpected replay timestamp %v", replayTs)
+	}
+
+	if err := wal.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+	if recs, replayTs, _ = replayWal(t, dir); len(recs) != 0 || replayTs != nil {
+		t.Errorf("expected empty log after truncate, got %v %v", recs, replayTs)
+	}
+}
+
+func TestSliceWalCorruptTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slicewal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wal, err := openSliceWal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := common.NewTsVbuuid("default", 4)
+	wal.AppendInsert([]byte("key1"), []byte("value1"))
+	wal.Snapshot(ts)
+	size := wal.Size()
+	wal.AppendInsert([]byte("key2"), []byte("value2"))
+	wal.Snapshot(ts)
+	wal.Close()
+
+	// flip a byte in the second insert
+	path := filepath.Join(dir, SLICE_WAL_FILE)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[size+6] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, replayTs, wal := replayWal(t, dir)
+	defer wal.Close()
+	if len(recs) != 1 || string(recs[0].key) != "key1" || replayTs == nil {
+		t.Fatalf("expected only the first record, got %v %v", recs, replayTs)
+	}
+	if wal.Size() != size {
+		t.Errorf("expected log truncated to %v, got %v", size, wal.Size())
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"github.com/couchbase/indexing/secondary/common"
+	"time"
+)
+
+var (
+	ErrSnapshotNotFound = errors.New("Retained snapshot not found or released")
+)
+
+// A persisted index snapshot retained after it was superseded by newer
+// snapshots, so that it can be scanned by its handle.
+type retainedSnapshot struct {
+	handle  uint64
+	snap    IndexSnapshot
+	created time.Time
+}
+
+// RetainedSnapshotInfo describes a retained snapshot, as reported by the
+// admin API.
+type RetainedSnapshotInfo struct {
+	Handle    uint64             `json:"handle"`
+	InstId    common.IndexInstId `json:"instId"`
+	Bucket    string             `json:"bucket"`
+	Index     string             `json:"index"`
+	Created   string             `json:"created"`
+	Timestamp string             `json:"timestamp"`
+}
+
+// Recent persisted snapshots of each index instance, upto count snapshots
+// per instance, each retained for at most maxAge. Owned by storage manager,
+// not thread safe.
+type snapshotRetention struct {
+	count      int
+	maxAge     time.Duration // 0 for no age limit
+	nextHandle uint64
+	snaps      map[common.IndexInstId][]*retainedSnapshot // oldest first
+}
+
+func newSnapshotRetention(count int, maxAge time.Duration) *snapshotRetention {
+	return &snapshotRetention{
+		count:  count,
+		maxAge: maxAge,
+		snaps:  make(map[common.IndexInstId][]*retainedSnapshot),
+	}
+}
+
+func (sr *snapshotRetention) Enabled() bool {
+	return sr.count > 0
+}
+
+// Retain a reference on snapshot and return its handle, the oldest
+// snapshot of the index instance is released if count is exceeded.
+func (sr *snapshotRetention) Retain(is IndexSnapshot) uint64 {
+	sr.nextHandle++
+	rs := &retainedSnapshot{
+		handle:  sr.nextHandle,
+		snap:    CloneIndexSnapshot(is),
+		created: time.Now(),
+	}
+
+	instId := is.IndexInstId()
+	snaps := append(sr.snaps[instId], rs)
+	for len(snaps) > sr.count {
+		DestroyIndexSnapshot(snaps[0].snap)
+		snaps = snaps[1:]
+	}
+	sr.snaps[instId] = snaps
+	return rs.handle
+}
+
+// Get a reference on the snapshot of index instance `instId` retained
+// with `handle`, caller shall destroy the snapshot once done.
+func (sr *snapshotRetention) Get(
+	instId common.IndexInstId, handle uint64) (IndexSnapshot, error) {
+
+	for _, rs := range sr.snaps[instId] {
+		if rs.handle == handle {
+			return CloneIndexSnapshot(rs.snap), nil
+		}
+	}
+	return nil, ErrSnapshotNotFound
+}
+
+// Expire snapshots older than maxAge.
+func (sr *snapshotRetention) Expire() {
+	if sr.maxAge == 0 {
+		return
+	}
+	now := time.Now()
+	for instId, snaps := range sr.snaps {
+		for len(snaps) > 0 && now.Sub(snaps[0].created) > sr.maxAge {
+			DestroyIndexSnapshot(snaps[0].snap)
+			snaps = snaps[1:]
+		}
+		if len(snaps) == 0 {
+			delete(sr.snaps, instId)
+		} else {
+			sr.snaps[instId] = snaps
+		}
+	}
+}
+
+// Release all snapshots retained for index instance `instId`.
+func (sr *snapshotRetention) Release(instId common.IndexInstId) {
+	for _, rs := range sr.snaps[instId] {
+		DestroyIndexSnapshot(rs.snap)
+	}
+	delete(sr.snaps, instId)
+}
+
+// List retained snapshots of all index instances.
+func (sr *snapshotRetention) List(
+	indexInstMap common.IndexInstMap) []RetainedSnapshotInfo {
+
+	infos := make([]RetainedSnapshotInfo, 0)
+	for instId, snaps := range sr.snaps {
+		inst := indexInstMap[instId]
+		for _, rs := range snaps {
+			infos = append(infos, RetainedSnapshotInfo{
+				Handle:    rs.handle,
+				InstId:    instId,
+				Bucket:    inst.Defn.Bucket,
+				Index:     inst.Defn.Name,
+				Created:   rs.created.Format(time.RFC3339),
+				Timestamp: ScanTStoString(rs.snap.Timestamp()),
+			})
+		}
+	}
+	return infos
+}
@@ -0,0 +1,53 @@
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRetentionCount(t *testing.T) {
+	sr := newSnapshotRetention(2, 0)
+
+	h1 := sr.Retain(&indexSnapshot{instId: 1})
+	h2 := sr.Retain(&indexSnapshot{instId: 1})
+	h3 := sr.Retain(&indexSnapshot{instId: 1})
+	sr.Retain(&indexSnapshot{instId: 2})
+
+	if _, err := sr.Get(1, h1); err != ErrSnapshotNotFound {
+		t.Errorf("expected oldest snapshot to be released, got %v", err)
+	}
+	for _, h := range []uint64{h2, h3} {
+		if snap, err := sr.Get(1, h); err != nil || snap.IndexInstId() != 1 {
+			t.Errorf("expected snapshot %v, got %v (%v)", h, snap, err)
+		}
+	}
+	// handle belongs to another index
+	if _, err := sr.Get(2, h3); err != ErrSnapshotNotFound {
+		t.Errorf("expected %v, got %v", ErrSnapshotNotFound, err)
+	}
+
+	infos := sr.List(common.IndexInstMap{})
+	if len(infos) != 3 {
+		t.Errorf("expected 3 retained snapshots, got %v", infos)
+	}
+
+	sr.Release(1)
+	if _, err := sr.Get(1, h3); err != ErrSnapshotNotFound {
+		t.Errorf("expected snapshots of index to be released, got %v", err)
+	}
+}
+
+func TestSnapshotRetentionExpire(t *testing.T) {
+	sr := newSnapshotRetention(10, time.Millisecond)
+	h := sr.Retain(&indexSnapshot{instId: 1})
+
+	time.Sleep(5 * time.Millisecond)
+	sr.Expire()
+	if _, err := sr.Get(1, h); err != ErrSnapshotNotFound {
+		t.Errorf("expected expired snapshot to be released, got %v", err)
+	}
+	if len(sr.List(common.IndexInstMap{})) != 0 {
+		t.Errorf("expected no retained snapshots")
+	}
+}
@@ -30,6 +30,8 @@ func NewStatsManager(supvCmdch MsgChannel,
 
 	http.HandleFunc("/stats", s.handleStatsReq)
 	http.HandleFunc("/stats/mem", s.handleMemStatsReq)
+	http.HandleFunc("/stats/capacity", s.handleCapacityReq)
+	http.HandleFunc("/snapshots", s.handleSnapshotsReq)
 	return s, &MsgSuccess{}
 }
 
@@ -72,6 +74,45 @@ func (s *statsManager) handleMemStatsReq(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// handleCapacityReq reports aggregate capacity of this indexer node, used
+// for placing new indexes.
+func (s *statsManager) handleCapacityReq(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" || r.Method == "GET" {
+		capch := make(chan *common.IndexerCapacity)
+		s.supvMsgch <- &MsgCapacityRequest{respch: capch}
+		capacity := <-capch
+
+		statch := make(chan []IndexStorageStats)
+		s.supvMsgch <- &MsgIndexStorageStats{respch: statch}
+		for _, st := range <-statch {
+			capacity.DiskUsed += uint64(st.Stats.DiskSize)
+		}
+
+		bytes, _ := json.Marshal(capacity)
+		w.WriteHeader(200)
+		w.Write(bytes)
+	} else {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+	}
+}
+
+// handleSnapshotsReq lists persisted snapshots retained by storage manager,
+// a snapshot can be scanned by its handle until it is released.
+func (s *statsManager) handleSnapshotsReq(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" || r.Method == "GET" {
+		ch := make(chan []RetainedSnapshotInfo)
+		s.supvMsgch <- &MsgIndexSnapList{respch: ch}
+
+		bytes, _ := json.Marshal(<-ch)
+		w.WriteHeader(200)
+		w.Write(bytes)
+	} else {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+	}
+}
+
 func (s *statsManager) run() {
 loop:
 	for {
@@ -12,10 +12,13 @@ package indexer
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbaselabs/goforestdb"
+	"net/http"
+	"time"
 )
 
 var (
@@ -42,6 +45,14 @@ type storageMgr struct {
 	// List of waiters waiting for a snapshot to be created with expected
 	// atleast-timestamp
 	waitersMap map[common.IndexInstId][]*snapshotWaiter
+	// Recent persisted snapshots that can be scanned by handle
+	retention *snapshotRetention
+	// Purgers reclaiming entries of documents purged from KV
+	purgers map[common.IndexInstId]*tombstonePurger
+	// Reports of the last scrub pass on every index
+	scrubs *scrubReports
+	// Reports of the last verification of every index against KV
+	verifications *verifyReports
 
 	dbfile *forestdb.File
 	meta   *forestdb.KVStore // handle for index meta
@@ -88,8 +99,13 @@ func NewStorageManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 		supvRespch:   supvRespch,
 		indexSnapMap: make(map[common.IndexInstId]IndexSnapshot),
 		waitersMap:   make(map[common.IndexInstId][]*snapshotWaiter),
+		purgers:      make(map[common.IndexInstId]*tombstonePurger),
+		scrubs:       newScrubReports(),
 		config:       config,
 	}
+	s.verifications = newVerifyReports()
+	s.retention = newSnapshotRetention(config["snapshotRetention.count"].Int(),
+		time.Duration(config["snapshotRetention.maxAge"].Int())*time.Millisecond)
 
 	//if manager is not enabled, create meta file
 	if config["enableManager"].Bool() == false {
@@ -109,6 +125,9 @@ func NewStorageManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 
 	s.updateIndexSnapMap(indexPartnMap, common.ALL_STREAMS, "")
 
+	http.HandleFunc("/scrub", s.handleScrubReports)
+	http.HandleFunc("/verify", s.handleVerifyReports)
+
 	//start Storage Manager loop which listens to commands from its supervisor
 	go s.run()
 
@@ -164,9 +183,21 @@ func (s *storageMgr) handleSupvervisorCommands(cmd Message) {
 	case STORAGE_INDEX_STORAGE_STATS:
 		s.handleGetIndexStorageStats(cmd)
 
+	case STORAGE_INDEX_SNAP_LIST:
+		s.handleListRetainedSnapshots(cmd)
+
 	case STORAGE_INDEX_COMPACT:
 		s.handleIndexCompaction(cmd)
 
+	case STORAGE_INDEX_PURGE:
+		s.handleIndexPurge(cmd)
+
+	case STORAGE_INDEX_SCRUB:
+		s.handleIndexScrub(cmd)
+
+	case STORAGE_INDEX_VERIFY:
+		s.handleIndexVerify(cmd)
+
 	case STORAGE_STATS:
 		s.handleStats(cmd)
 	}
@@ -179,7 +210,7 @@ func (s *storageMgr) handleCreateSnapshot(cmd Message) {
 	common.Tracef("StorageMgr::handleCreateSnapshot %v", cmd)
 
 	bucket := cmd.(*MsgMutMgrFlushDone).GetBucket()
-	tsVbuuid := cmd.(*MsgMutMgrFlushDone).GetTS()
+	tsVbuuid := cmd.(*MsgMutMgrFlushDone).GetFlushedTS()
 	streamId := cmd.(*MsgMutMgrFlushDone).GetStreamId()
 
 	numVbuckets := s.config["numVbuckets"].Int()
@@ -302,6 +333,13 @@ func (s *storageMgr) handleCreateSnapshot(cmd Message) {
 				DestroyIndexSnapshot(s.indexSnapMap[idxInstId])
 				s.indexSnapMap[idxInstId] = is
 
+				// Keep recent persisted snapshots for scans by handle
+				if needsCommit && s.retention.Enabled() {
+					handle := s.retention.Retain(is)
+					common.Debugf("StorageMgr::handleCreateSnapshot \n\tRetained Snapshot "+
+						"Index: %v Handle: %v", idxInstId, handle)
+				}
+
 				// Also notify any waiters for snapshots creation
 				var newWaiters []*snapshotWaiter
 				for _, w := range s.waitersMap[idxInstId] {
@@ -318,6 +356,7 @@ func (s *storageMgr) handleCreateSnapshot(cmd Message) {
 			}
 		}
 	}
+	s.retention.Expire()
 
 	s.supvCmdch <- &MsgSuccess{}
 
@@ -341,6 +380,15 @@ func (sm *storageMgr) handleRollback(cmd Message) {
 		//if this bucket in stream needs to be rolled back
 		if idxInst.Defn.Bucket == bucket && idxInst.Stream == streamId {
 
+			// Retained snapshots are not valid beyond rollback
+			sm.retention.Release(idxInstId)
+			// Entries restored by rollback shall be verified again, a
+			// purge in progress is stopped before slices are rolled back
+			if purger, ok := sm.purgers[idxInstId]; ok {
+				purger.Close()
+				delete(sm.purgers, idxInstId)
+			}
+
 			//for all partitions managed by this indexer
 			for partnId, partnInst := range partnMap {
 				sc := partnInst.Sc
@@ -431,14 +479,19 @@ func (s *storageMgr) handleUpdateIndexInstMap(cmd Message) {
 		}
 	}
 
-	// Cleanup all invalid index's snapshots
+	// Cleanup all invalid index's snapshots, indexes back in ready
+	// state had their build aborted and their slices are replaced
 	for idxInstId, is := range s.indexSnapMap {
 		if inst, ok := s.indexInstMap[idxInstId]; !ok ||
-			inst.State == common.INDEX_STATE_DELETED {
+			inst.State == common.INDEX_STATE_DELETED ||
+			inst.State == common.INDEX_STATE_READY {
 			DestroyIndexSnapshot(is)
 			delete(s.indexSnapMap, idxInstId)
+			s.retention.Release(idxInstId)
 		}
 	}
+	s.scrubs.Retain(s.indexInstMap)
+	s.verifications.Retain(s.indexInstMap)
 
 	//if manager is not enable, store the updated InstMap in
 	//meta file
@@ -498,6 +551,16 @@ func (s *storageMgr) handleGetIndexSnapshot(cmd Message) {
 		return
 	}
 
+	// Scan against an older snapshot retained with the requested handle
+	if req.GetHandle() != 0 {
+		if snap, err := s.retention.Get(req.GetIndexId(), req.GetHandle()); err != nil {
+			req.respch <- err
+		} else {
+			req.respch <- snap
+		}
+		return
+	}
+
 	// Return snapshot immediately if a matching snapshot exists already
 	// Otherwise add into waiters list so that next snapshot creation event
 	// can notify the requester when a snapshot with matching timestamp
@@ -520,6 +583,14 @@ func (s *storageMgr) handleGetIndexSnapshot(cmd Message) {
 	}
 }
 
+// List persisted snapshots retained for scans by handle.
+func (s *storageMgr) handleListRetainedSnapshots(cmd Message) {
+	s.supvCmdch <- &MsgSuccess{}
+	req := cmd.(*MsgIndexSnapList)
+	s.retention.Expire()
+	req.GetReplyChannel() <- s.retention.List(s.indexInstMap)
+}
+
 func (s *storageMgr) handleGetIndexStorageStats(cmd Message) {
 	s.supvCmdch <- &MsgSuccess{}
 	req := cmd.(*MsgIndexStorageStats)
@@ -543,6 +614,7 @@ func (s *storageMgr) handleStats(cmd Message) {
 		statsMap[k] = v
 		k = fmt.Sprintf("%s:%s:data_size", inst.Defn.Bucket, inst.Defn.Name)
 		v = fmt.Sprint(st.Stats.DataSize)
+		statsMap[k] = v
 		k = fmt.Sprintf("%s:%s:get_bytes", inst.Defn.Bucket, inst.Defn.Name)
 		v = fmt.Sprint(st.Stats.GetBytes)
 		statsMap[k] = v
@@ -552,6 +624,37 @@ func (s *storageMgr) handleStats(cmd Message) {
 		k = fmt.Sprintf("%s:%s:delete_bytes", inst.Defn.Bucket, inst.Defn.Name)
 		v = fmt.Sprint(st.Stats.DeleteBytes)
 		statsMap[k] = v
+		k = fmt.Sprintf("%s:%s:num_reads", inst.Defn.Bucket, inst.Defn.Name)
+		v = fmt.Sprint(st.Stats.NumReads)
+		statsMap[k] = v
+		k = fmt.Sprintf("%s:%s:avg_read_latency", inst.Defn.Bucket, inst.Defn.Name)
+		v = fmt.Sprint(int64(st.Stats.AvgReadLatency()))
+		statsMap[k] = v
+		k = fmt.Sprintf("%s:%s:wal_size", inst.Defn.Bucket, inst.Defn.Name)
+		v = fmt.Sprint(st.Stats.WalSize)
+		statsMap[k] = v
+		k = fmt.Sprintf("%s:%s:items_purged", inst.Defn.Bucket, inst.Defn.Name)
+		v = fmt.Sprint(st.Stats.PurgedItems)
+		statsMap[k] = v
+		k = fmt.Sprintf("%s:%s:num_expirations", inst.Defn.Bucket, inst.Defn.Name)
+		v = fmt.Sprint(st.Stats.Expirations)
+		statsMap[k] = v
+		if report, ok := s.scrubs.Get(st.InstId); ok {
+			k = fmt.Sprintf("%s:%s:scrub_entries", inst.Defn.Bucket, inst.Defn.Name)
+			v = fmt.Sprint(report.Entries)
+			statsMap[k] = v
+			k = fmt.Sprintf("%s:%s:scrub_corrupt_entries", inst.Defn.Bucket, inst.Defn.Name)
+			v = fmt.Sprint(report.Corrupt)
+			statsMap[k] = v
+		}
+		if report, ok := s.verifications.Get(st.InstId); ok {
+			k = fmt.Sprintf("%s:%s:verify_sampled_docs", inst.Defn.Bucket, inst.Defn.Name)
+			v = fmt.Sprint(report.Sampled - report.Skipped)
+			statsMap[k] = v
+			k = fmt.Sprintf("%s:%s:verify_divergence", inst.Defn.Bucket, inst.Defn.Name)
+			v = fmt.Sprint(report.Divergence)
+			statsMap[k] = v
+		}
 	}
 
 	replych <- statsMap
@@ -565,6 +668,8 @@ func (s *storageMgr) getIndexStorageStats() []IndexStorageStats {
 	for idxInstId, partnMap := range s.indexPartnMap {
 		var dataSz, diskSz int64
 		var getBytes, insertBytes, deleteBytes int64
+		var numReads, readTime int64
+		var walSize, purgedItems, expirations int64
 	loop:
 		for _, partnInst := range partnMap {
 			for _, slice := range partnInst.Sc.GetAllSlices() {
@@ -578,6 +683,11 @@ func (s *storageMgr) getIndexStorageStats() []IndexStorageStats {
 				getBytes += sts.GetBytes
 				insertBytes += sts.InsertBytes
 				deleteBytes += sts.DeleteBytes
+				numReads += sts.NumReads
+				readTime += sts.ReadTime
+				walSize += sts.WalSize
+				purgedItems += sts.PurgedItems
+				expirations += sts.Expirations
 			}
 		}
 
@@ -590,6 +700,14 @@ func (s *storageMgr) getIndexStorageStats() []IndexStorageStats {
 					GetBytes:    getBytes,
 					InsertBytes: insertBytes,
 					DeleteBytes: deleteBytes,
+
+					NumReads: numReads,
+					ReadTime: readTime,
+
+					WalSize: walSize,
+
+					PurgedItems: purgedItems,
+					Expirations: expirations,
 				},
 			}
 
@@ -634,6 +752,206 @@ func (s *storageMgr) handleIndexCompaction(cmd Message) {
 	}()
 }
 
+// Purge entries of documents purged from KV, refer tombstonePurger,
+// using the latest snapshot of the index.
+func (s *storageMgr) handleIndexPurge(cmd Message) {
+	s.supvCmdch <- &MsgSuccess{}
+	req := cmd.(*MsgIndexPurge)
+	errch := req.GetErrorChannel()
+	idxInstId := req.GetInstId()
+
+	idxInst, ok := s.indexInstMap[idxInstId]
+	partnMap, ok1 := s.indexPartnMap[idxInstId]
+	if !ok || !ok1 {
+		errch <- ErrIndexNotFound
+		return
+	}
+
+	// Nothing to purge before the index has a snapshot
+	if s.indexSnapMap[idxInstId] == nil {
+		errch <- nil
+		return
+	}
+	is := CloneIndexSnapshot(s.indexSnapMap[idxInstId])
+
+	purger, ok := s.purgers[idxInstId]
+	if !ok {
+		purger = newTombstonePurger(s.config, idxInst.Defn.Bucket)
+		s.purgers[idxInstId] = purger
+	}
+
+	// Purge without blocking storage manager main loop
+	go func() {
+		defer DestroyIndexSnapshot(is)
+		count, err := purger.Purge(is, partnMap)
+		if err == ErrPurgeStopped {
+			err = nil
+		} else if err == nil {
+			common.Infof("StorageMgr::handleIndexPurge \n\tIndex: %v Found %v "+
+				"Entries Purged From KV", idxInstId, count)
+		}
+		errch <- err
+	}()
+}
+
+// Verify storage of the latest snapshot of the index, refer
+// scrubChecker. Corrupted indexes are moved to error state if configured,
+// so that they are rebuilt instead of failing scans.
+func (s *storageMgr) handleIndexScrub(cmd Message) {
+	s.supvCmdch <- &MsgSuccess{}
+	req := cmd.(*MsgIndexScrub)
+	errch := req.GetErrorChannel()
+	idxInstId := req.GetInstId()
+
+	idxInst, ok := s.indexInstMap[idxInstId]
+	if !ok {
+		errch <- ErrIndexNotFound
+		return
+	}
+
+	// Nothing to verify before the index has a snapshot, or once it
+	// awaits rebuild
+	if s.indexSnapMap[idxInstId] == nil ||
+		idxInst.State == common.INDEX_STATE_ERROR {
+		errch <- nil
+		return
+	}
+	is := CloneIndexSnapshot(s.indexSnapMap[idxInstId])
+
+	opts := newScrubOptions(s.config)
+	markRebuild := s.config["scrub.markRebuild"].Bool()
+
+	// Scrub without blocking storage manager main loop
+	go func() {
+		defer DestroyIndexSnapshot(is)
+
+		start := time.Now()
+		result, err := scrubIndexSnapshot(is, opts)
+		report := scrubReport{
+			InstId:   idxInstId,
+			Bucket:   idxInst.Defn.Bucket,
+			Index:    idxInst.Defn.Name,
+			Time:     start,
+			Duration: int64(time.Since(start) / time.Millisecond),
+			Entries:  result.Entries,
+			Corrupt:  result.Corrupt,
+			Samples:  result.Samples,
+		}
+		if err != nil {
+			report.Error = err.Error()
+		}
+
+		if result.Corrupt > 0 {
+			common.Errorf("StorageMgr::handleIndexScrub \n\tIndex: %v Found %v "+
+				"Corrupted Entries Of %v. Samples %v", idxInstId, result.Corrupt,
+				result.Entries, result.Samples)
+			if markRebuild {
+				report.MarkedForRebuild = true
+				cause := common.CountError(common.WrapError(
+					common.ErrorIndexCorrupted, "corrupt", result.Corrupt))
+				s.supvRespch <- &MsgIndexCorrupted{instId: idxInstId, err: cause}
+			}
+		} else if err == nil {
+			common.Infof("StorageMgr::handleIndexScrub \n\tIndex: %v Verified %v "+
+				"Entries", idxInstId, result.Entries)
+		}
+		s.scrubs.Set(report)
+		errch <- err
+	}()
+}
+
+// Verify the latest snapshot of the index against documents sampled from
+// KV, refer indexVerifier.
+func (s *storageMgr) handleIndexVerify(cmd Message) {
+	s.supvCmdch <- &MsgSuccess{}
+	req := cmd.(*MsgIndexVerify)
+	errch := req.GetErrorChannel()
+	idxInstId := req.GetInstId()
+
+	idxInst, ok := s.indexInstMap[idxInstId]
+	if !ok {
+		errch <- ErrIndexNotFound
+		return
+	}
+
+	// Nothing to verify before the index is built
+	if s.indexSnapMap[idxInstId] == nil ||
+		idxInst.State != common.INDEX_STATE_ACTIVE {
+		errch <- nil
+		return
+	}
+	is := CloneIndexSnapshot(s.indexSnapMap[idxInstId])
+
+	verifier := newIndexVerifier(s.config)
+
+	// Verify without blocking storage manager main loop
+	go func() {
+		defer DestroyIndexSnapshot(is)
+
+		start := time.Now()
+		result, err := verifier.Verify(idxInst, is)
+		report := verifyReport{
+			InstId:     idxInstId,
+			Bucket:     idxInst.Defn.Bucket,
+			Index:      idxInst.Defn.Name,
+			Time:       start,
+			Duration:   int64(time.Since(start) / time.Millisecond),
+			Sampled:    result.Sampled,
+			Skipped:    result.Skipped,
+			Missing:    result.Missing,
+			Stale:      result.Stale,
+			Unexpected: result.Unexpected,
+			Divergence: result.Divergence(),
+			Samples:    result.Samples,
+		}
+		if err != nil {
+			report.Error = err.Error()
+		}
+
+		if report.Divergence > 0 {
+			common.Warnf("StorageMgr::handleIndexVerify \n\tIndex: %v Diverges "+
+				"From KV By %.4f. Missing %v Stale %v Unexpected %v Of %v. "+
+				"Samples %v", idxInstId, report.Divergence, result.Missing,
+				result.Stale, result.Unexpected, result.Sampled-result.Skipped,
+				result.Samples)
+		} else if err == nil {
+			common.Infof("StorageMgr::handleIndexVerify \n\tIndex: %v Verified %v "+
+				"Documents, Skipped %v", idxInstId, result.Sampled-result.Skipped,
+				result.Skipped)
+		}
+		s.verifications.Set(report)
+		errch <- err
+	}()
+}
+
+// handleVerifyReports returns reports of the last verification of every
+// index against KV, most divergent indexes first.
+func (s *storageMgr) handleVerifyReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	bytes, _ := json.Marshal(s.verifications.List())
+	w.WriteHeader(200)
+	w.Write(bytes)
+}
+
+// handleScrubReports returns reports of the last scrub pass on every
+// index, corrupted indexes first.
+func (s *storageMgr) handleScrubReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	bytes, _ := json.Marshal(s.scrubs.List())
+	w.WriteHeader(200)
+	w.Write(bytes)
+}
+
 // Update index-snapshot map using index partition map
 // This function should be called only during initialization
 // of storage manager and during rollback.
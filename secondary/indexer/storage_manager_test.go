@@ -0,0 +1,62 @@
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+	"time"
+)
+
+type statsSlice struct {
+	*mockSlice
+	stats StorageStatistics
+}
+
+func (s *statsSlice) Statistics() (StorageStatistics, error) {
+	return s.stats, nil
+}
+
+func TestStorageMgrReadStats(t *testing.T) {
+	slices := []*statsSlice{
+		{&mockSlice{}, StorageStatistics{NumReads: 3, ReadTime: int64(6 * time.Millisecond)}},
+		{&mockSlice{}, StorageStatistics{NumReads: 1, ReadTime: int64(2 * time.Millisecond)}},
+	}
+	partnMap := make(PartitionInstMap)
+	for i, slice := range slices {
+		sc := NewHashedSliceContainer()
+		sc.AddSlice(SliceId(0), slice)
+		partnMap[common.PartitionId(i)] = PartitionInst{Sc: sc}
+	}
+
+	s := &storageMgr{
+		supvCmdch:     make(MsgChannel, 1),
+		indexInstMap:  make(common.IndexInstMap),
+		indexPartnMap: IndexPartnMap{1: partnMap},
+		scrubs:        newScrubReports(),
+		verifications: newVerifyReports(),
+	}
+	s.indexInstMap[1] = common.IndexInst{
+		InstId: 1,
+		Defn:   common.IndexDefn{Bucket: "default", Name: "idx"},
+	}
+
+	respch := make(chan map[string]string, 1)
+	s.handleStats(&MsgStatsRequest{respch: respch})
+	stats := <-respch
+	if v := stats["default:idx:num_reads"]; v != "4" {
+		t.Errorf("expected 4 reads, got %v", v)
+	}
+	if v := stats["default:idx:avg_read_latency"]; v != "2000000" {
+		t.Errorf("expected average latency of 2ms, got %v", v)
+	}
+	for _, k := range []string{"default:idx:resident_percent", "default:idx:cache_hit_percent"} {
+		if _, ok := stats[k]; ok {
+			t.Errorf("unexpected stat %v", k)
+		}
+	}
+}
+
+func TestStorageStatisticsAvgReadLatency(t *testing.T) {
+	if l := (StorageStatistics{}).AvgReadLatency(); l != 0 {
+		t.Errorf("expected 0 latency without reads, got %v", l)
+	}
+}
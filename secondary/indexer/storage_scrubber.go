@@ -0,0 +1,287 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Longest prefix of a corrupted key retained in scrub samples.
+const scrubSampleKeyLen = 64
+
+// scrubOptions control how much a scrub pass can load the storage, the
+// pass pauses for `throttle` after every `batchSize` entries.
+type scrubOptions struct {
+	batchSize  int
+	throttle   time.Duration
+	maxSamples int
+}
+
+func newScrubOptions(config common.Config) scrubOptions {
+	return scrubOptions{
+		batchSize:  config["scrub.batchSize"].Int(),
+		throttle:   time.Duration(config["scrub.throttle"].Int()) * time.Millisecond,
+		maxSamples: config["scrub.maxSamples"].Int(),
+	}
+}
+
+// scrubResult of verifying one or more slice snapshots.
+type scrubResult struct {
+	Entries int64    // entries verified
+	Corrupt int64    // entries found corrupted
+	Samples []string // first few corrupted entries, with reason
+}
+
+func (r *scrubResult) add(other scrubResult, maxSamples int) {
+	r.Entries += other.Entries
+	r.Corrupt += other.Corrupt
+	for _, s := range other.Samples {
+		if len(r.Samples) >= maxSamples {
+			break
+		}
+		r.Samples = append(r.Samples, s)
+	}
+}
+
+// scrubber is implemented by snapshots whose storage can be verified.
+type scrubber interface {
+	Scrub(opts scrubOptions) (scrubResult, error)
+}
+
+// scrubChecker verifies entries of the main index, in key order, against
+// the structure expected by the readers: keys are strictly ordered,
+// values decode to a document and the back index maps the document to
+// the same key.
+type scrubChecker struct {
+	opts    scrubOptions
+	lastKey []byte
+	result  scrubResult
+}
+
+// check an entry of main index, `backKey` looks up the back index entry
+// of a document and returns nil if there is none.
+func (sc *scrubChecker) check(key, value []byte,
+	backKey func(docid []byte) ([]byte, error)) {
+
+	sc.result.Entries++
+
+	if len(key) == 0 {
+		sc.corrupted(key, "empty key")
+		return
+	}
+	outOfOrder := sc.lastKey != nil && bytes.Compare(sc.lastKey, key) >= 0
+	sc.lastKey = append(sc.lastKey[:0], key...)
+	if outOfOrder {
+		sc.corrupted(key, "key out of order")
+		return
+	}
+
+	val, err := NewValueFromEncodedBytes(value)
+	if err != nil {
+		sc.corrupted(key, fmt.Sprintf("undecodable value, %v", err))
+		return
+	} else if len(val.Docid()) == 0 {
+		sc.corrupted(key, "value without docid")
+		return
+	}
+
+	if kbytes, err := backKey(val.Docid()); err != nil {
+		sc.corrupted(key, fmt.Sprintf("back index read failed, %v", err))
+	} else if kbytes == nil {
+		sc.corrupted(key, fmt.Sprintf("no back index entry for %q", val.Docid()))
+	} else if !bytes.Equal(kbytes, key) {
+		sc.corrupted(key, fmt.Sprintf("back index entry for %q differs", val.Docid()))
+	}
+}
+
+// missing reports a back index entry of `docid` whose key is not found
+// in the main index.
+func (sc *scrubChecker) missing(docid, key []byte, err error) {
+	sc.result.Corrupt++
+	reason := fmt.Sprintf("no main index entry for %q", docid)
+	if err != nil {
+		reason = fmt.Sprintf("main index read failed for %q, %v", docid, err)
+	}
+	sc.sample(key, reason)
+}
+
+func (sc *scrubChecker) corrupted(key []byte, reason string) {
+	sc.result.Corrupt++
+	sc.sample(key, reason)
+}
+
+func (sc *scrubChecker) sample(key []byte, reason string) {
+	if len(sc.result.Samples) >= sc.opts.maxSamples {
+		return
+	}
+	if len(key) > scrubSampleKeyLen {
+		key = key[:scrubSampleKeyLen]
+	}
+	sc.result.Samples = append(sc.result.Samples, fmt.Sprintf("%s: key %q", reason, key))
+}
+
+// throttle pauses after every batch of entries, so that scrubbing stays
+// in the background of mutations and scans.
+func (sc *scrubChecker) throttle(n int64) {
+	if sc.opts.batchSize > 0 && sc.opts.throttle > 0 &&
+		n%int64(sc.opts.batchSize) == 0 {
+		time.Sleep(sc.opts.throttle)
+	}
+}
+
+// scrubIndexSnapshot verifies every slice snapshot of index snapshot `is`.
+func scrubIndexSnapshot(is IndexSnapshot, opts scrubOptions) (scrubResult, error) {
+
+	var result scrubResult
+	for _, ps := range is.Partitions() {
+		for _, ss := range ps.Slices() {
+			snap, ok := ss.Snapshot().(scrubber)
+			if !ok {
+				continue
+			}
+			r, err := snap.Scrub(opts)
+			result.add(r, opts.maxSamples)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// scrubReport of the last scrub pass on an index instance.
+type scrubReport struct {
+	InstId           common.IndexInstId `json:"instId"`
+	Bucket           string             `json:"bucket"`
+	Index            string             `json:"index"`
+	Time             time.Time          `json:"time"`
+	Duration         int64              `json:"durationMs"`
+	Entries          int64              `json:"entries"`
+	Corrupt          int64              `json:"corrupt"`
+	Samples          []string           `json:"samples,omitempty"`
+	MarkedForRebuild bool               `json:"markedForRebuild"`
+	Error            string             `json:"error,omitempty"`
+}
+
+// scrubReports of index instances, read by stats and admin requests.
+type scrubReports struct {
+	mu      sync.Mutex
+	reports map[common.IndexInstId]scrubReport
+}
+
+func newScrubReports() *scrubReports {
+	return &scrubReports{reports: make(map[common.IndexInstId]scrubReport)}
+}
+
+func (sr *scrubReports) Set(report scrubReport) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.reports[report.InstId] = report
+}
+
+func (sr *scrubReports) Get(instId common.IndexInstId) (scrubReport, bool) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	report, ok := sr.reports[instId]
+	return report, ok
+}
+
+// Retain reports only of instances in `indexInstMap`.
+func (sr *scrubReports) Retain(indexInstMap common.IndexInstMap) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	for instId := range sr.reports {
+		if inst, ok := indexInstMap[instId]; !ok ||
+			inst.State == common.INDEX_STATE_DELETED {
+			delete(sr.reports, instId)
+		}
+	}
+}
+
+// List reports, corrupted instances first.
+func (sr *scrubReports) List() []scrubReport {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	list := make([]scrubReport, 0, len(sr.reports))
+	for _, report := range sr.reports {
+		list = append(list, report)
+	}
+	sort.Sort(scrubReportsByCorrupt(list))
+	return list
+}
+
+type scrubReportsByCorrupt []scrubReport
+
+func (s scrubReportsByCorrupt) Len() int      { return len(s) }
+func (s scrubReportsByCorrupt) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s scrubReportsByCorrupt) Less(i, j int) bool {
+	if s[i].Corrupt != s[j].Corrupt {
+		return s[i].Corrupt > s[j].Corrupt
+	}
+	return s[i].InstId < s[j].InstId
+}
+
+// scrubDaemon periodically runs a scrub pass on every index instance,
+// one instance at a time.
+type scrubDaemon struct {
+	quitch  chan bool
+	started bool
+	ticker  *time.Ticker
+	msgch   MsgChannel
+	config  common.Config
+}
+
+func (sd *scrubDaemon) Start() {
+	interval := sd.config["interval"].Int()
+	if !sd.started && interval > 0 {
+		sd.ticker = time.NewTicker(time.Second * time.Duration(interval))
+		sd.started = true
+		go sd.loop()
+	}
+}
+
+func (sd *scrubDaemon) Stop() {
+	if sd.started {
+		sd.ticker.Stop()
+		sd.quitch <- true
+		<-sd.quitch
+	}
+}
+
+func (sd *scrubDaemon) loop() {
+loop:
+	for {
+		select {
+		case _, ok := <-sd.ticker.C:
+			if ok {
+				replych := make(chan []IndexStorageStats)
+				sd.msgch <- &MsgIndexStorageStats{respch: replych}
+				stats := <-replych
+
+				for _, is := range stats {
+					errch := make(chan error)
+					sd.msgch <- &MsgIndexScrub{instId: is.InstId, errch: errch}
+					if err := <-errch; err != nil {
+						common.Errorf("ScrubDaemon: Index instance:%v Scrub failed with reason - %v", is.InstId, err)
+					}
+				}
+			}
+
+		case <-sd.quitch:
+			sd.quitch <- true
+			break loop
+		}
+	}
+}
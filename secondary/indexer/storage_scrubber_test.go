@@ -0,0 +1,70 @@
+package indexer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestScrubChecker(t *testing.T) {
+	back := map[string][]byte{
+		"doc1": []byte(`["a"]`),
+		"doc2": []byte(`["x"]`),
+	}
+	backKey := func(docid []byte) ([]byte, error) {
+		if string(docid) == "bad" {
+			return nil, errors.New("checksum error")
+		}
+		return back[string(docid)], nil
+	}
+	value := func(docid string) []byte {
+		v, _ := NewValue([]byte(docid), 0, 1, nil)
+		return v.Encoded()
+	}
+
+	sc := &scrubChecker{opts: scrubOptions{maxSamples: 3}}
+	sc.check([]byte(`["a"]`), value("doc1"), backKey)
+	if sc.result.Entries != 1 || sc.result.Corrupt != 0 {
+		t.Fatalf("unexpected result %+v", sc.result)
+	}
+
+	sc.check([]byte(`["b"]`), value("doc2"), backKey) // back index differs
+	sc.check([]byte(`["c"]`), value("doc3"), backKey) // no back index entry
+	sc.check([]byte(`["d"]`), []byte("{garbage"), backKey)
+	sc.check([]byte(`["e"]`), value("bad"), backKey)  // back index read fails
+	sc.check([]byte(`["d"]`), value("doc1"), backKey) // out of order
+	sc.check(nil, value("doc1"), backKey)
+	if sc.result.Entries != 7 || sc.result.Corrupt != 6 {
+		t.Fatalf("unexpected result %+v", sc.result)
+	}
+	if len(sc.result.Samples) != 3 {
+		t.Fatalf("expected 3 samples, got %v", sc.result.Samples)
+	}
+	if !strings.HasPrefix(sc.result.Samples[0], `back index entry for "doc2" differs`) {
+		t.Errorf("unexpected sample %v", sc.result.Samples[0])
+	}
+
+	var result scrubResult
+	result.add(sc.result, 4)
+	result.add(sc.result, 4)
+	if result.Entries != 14 || result.Corrupt != 12 || len(result.Samples) != 4 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestScrubReports(t *testing.T) {
+	sr := newScrubReports()
+	sr.Set(scrubReport{InstId: 1, Entries: 10})
+	sr.Set(scrubReport{InstId: 2, Entries: 10, Corrupt: 2})
+	sr.Set(scrubReport{InstId: 3, Entries: 10})
+
+	list := sr.List()
+	if len(list) != 3 || list[0].InstId != 2 || list[1].InstId != 1 {
+		t.Fatalf("unexpected order %+v", list)
+	}
+
+	sr.Retain(nil)
+	if _, ok := sr.Get(2); ok {
+		t.Errorf("expected reports of dropped indexes to be removed")
+	}
+}
@@ -11,6 +11,12 @@ package indexer
 
 import (
 	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/dataport"
@@ -25,6 +31,106 @@ type MutationStreamReader interface {
 
 var mutationCount uint64
 
+//openStream is registered for each open stream and is carried to
+//projectors in topic requests.
+type openStream struct {
+	//registration token issued for the stream. Projector endpoints frame
+	//their connection with this token, so that endpoints of an older
+	//topic are rejected by the stream.
+	token string
+	//port allocated for the stream's dataport, empty if the stream
+	//listens on its configured port.
+	port string
+	//dataport of the stream, to require the token from projectors
+	//that have acknowledged it.
+	server *dataport.Server
+}
+
+var openStreams = struct {
+	sync.Mutex
+	streams map[common.StreamId]openStream
+}{streams: make(map[common.StreamId]openStream)}
+
+//getStreamToken returns the registration token issued for streamId,
+//empty string if the stream is not open.
+func getStreamToken(streamId common.StreamId) string {
+	openStreams.Lock()
+	defer openStreams.Unlock()
+	return openStreams.streams[streamId].token
+}
+
+//getStreamPort returns the port allocated for streamId, empty string if
+//the stream is not open or listens on its configured port.
+func getStreamPort(streamId common.StreamId) string {
+	openStreams.Lock()
+	defer openStreams.Unlock()
+	return openStreams.streams[streamId].port
+}
+
+func registerStream(streamId common.StreamId, stream openStream) {
+	openStreams.Lock()
+	defer openStreams.Unlock()
+	openStreams.streams[streamId] = stream
+}
+
+//ackStreamToken is called with the token echoed by projector at addr in
+//its TopicResponse. Once projector has acknowledged the token issued for
+//streamId, its endpoints are required to frame the token. Until then,
+//endpoints without token are accepted, as older projectors do not
+//support it.
+func ackStreamToken(streamId common.StreamId, addr, token string) {
+	openStreams.Lock()
+	stream, ok := openStreams.streams[streamId]
+	openStreams.Unlock()
+
+	if !ok || token == "" || token != stream.token {
+		return
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	//endpoint connections carry the projector's ip address.
+	hosts, err := net.LookupHost(host)
+	if err != nil {
+		common.Errorf("MutationStreamReader::ackStreamToken StreamId %v "+
+			"Projector %v. Err %v", streamId, addr, err)
+		return
+	}
+	if err := stream.server.RequireToken(hosts...); err != nil {
+		common.Errorf("MutationStreamReader::ackStreamToken StreamId %v "+
+			"Projector %v. Err %v", streamId, addr, err)
+	}
+}
+
+func unregisterStream(streamId common.StreamId) {
+	openStreams.Lock()
+	defer openStreams.Unlock()
+	delete(openStreams.streams, streamId)
+}
+
+//streamPortRange parses a port range, as "<first>-<last>", and returns
+//the listen addresses for ports in the range. Empty range returns nil.
+func streamPortRange(portRange string) ([]string, error) {
+	if portRange == "" {
+		return nil, nil
+	}
+	parts := strings.Split(portRange, "-")
+	if len(parts) != 2 {
+		return nil, ErrInvalidPortRange
+	}
+	first, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	last, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil || first <= 0 || first > last || last > 65535 {
+		return nil, ErrInvalidPortRange
+	}
+	laddrs := make([]string, 0, last-first+1)
+	for port := first; port <= last; port++ {
+		laddrs = append(laddrs, net.JoinHostPort("", strconv.Itoa(port)))
+	}
+	return laddrs, nil
+}
+
 type mutationStreamReader struct {
 	stream   *dataport.Server //handle to the Dataport server
 	streamId common.StreamId
@@ -36,29 +142,64 @@ type mutationStreamReader struct {
 
 	numWorkers int // number of workers to process mutation stream
 
-	workerch     []MutationChannel //buffered channel for each worker
-	workerStopCh []StopChannel     //stop channels of workers
+	//buffered channel for each worker, mutations and connection errors
+	//of a vbucket are always processed by the same worker to preserve
+	//their order
+	workerch     []chan interface{}
+	workerStopCh []StopChannel //stop channels of workers
+	workerWg     sync.WaitGroup
+
+	//closed once the reader or a worker panics, mutations are no longer
+	//handed over to workers after that
+	killch   chan bool
+	killOnce sync.Once
 
 	bucketQueueMap BucketQueueMap //indexId to mutation queue map
 
 	bucketFilterMap map[string]*common.TsVbuuid
+
+	queueMem         *memoryAccount //memory of all mutation queues
+	throttleInterval time.Duration  //pause before enqueue beyond memory budget
 }
 
 //CreateMutationStreamReader creates a new mutation stream and starts
-//a reader to listen and process the mutations.
+//a reader to listen and process the mutations. While queueMem is beyond
+//its budget, workers wait upto throttleInterval for memory to be released
+//before processing a batch of mutations. The stream listens on the first address in laddrs that
+//is free, or on its configured address if laddrs is empty.
 //In case returned MutationStreamReader is nil, Message will have the error msg.
 func CreateMutationStreamReader(streamId common.StreamId, bucketQueueMap BucketQueueMap,
-	supvCmdch MsgChannel, supvRespch MsgChannel, numWorkers int) (
-	MutationStreamReader, Message) {
+	supvCmdch MsgChannel, supvRespch MsgChannel, numWorkers int,
+	queueMem *memoryAccount, throttleInterval time.Duration,
+	laddrs []string) (MutationStreamReader, Message) {
 
 	//start a new mutation stream
 	streamMutch := make(chan interface{})
 	config := common.SystemConfig.SectionConfig(
 		"projector.dataport.indexer.", true /*trim*/)
-	stream, err := dataport.NewServer(
-		string(StreamAddrMap[streamId]),
-		common.SystemConfig["maxVbuckets"].Int(),
-		config, streamMutch)
+	var stream *dataport.Server
+	var err error
+	var port string
+	if len(laddrs) == 0 {
+		stream, err = dataport.NewServer(
+			string(StreamAddrMap[streamId]),
+			common.SystemConfig["maxVbuckets"].Int(),
+			config, streamMutch)
+	} else {
+		err = ErrNoStreamPort
+		for _, laddr := range laddrs {
+			//ports in use, say by other streams, fail to listen
+			stream, err = dataport.NewServer(laddr,
+				common.SystemConfig["maxVbuckets"].Int(),
+				config, streamMutch)
+			if err == nil {
+				_, port, _ = net.SplitHostPort(laddr)
+				common.Infof("MutationStreamReader: StreamId %v listening "+
+					"on allocated port %v", streamId, port)
+				break
+			}
+		}
+	}
 	if err != nil {
 		//return stream init error
 		common.Errorf("MutationStreamReader: Error returned from NewServer."+
@@ -72,6 +213,27 @@ func CreateMutationStreamReader(streamId common.StreamId, bucketQueueMap BucketQ
 		return nil, msgErr
 	}
 
+	//issue a registration token for the topic, before any projector
+	//is asked to stream to it.
+	uuid, err := common.NewUUID()
+	if err == nil {
+		err = stream.SetToken(uuid.Str())
+	}
+	if err != nil {
+		common.Errorf("MutationStreamReader: Error issuing stream token."+
+			"StreamId: %v, Err: %v", streamId, err)
+		stream.Close()
+
+		msgErr := &MsgError{
+			err: Error{code: ERROR_STREAM_INIT,
+				severity: FATAL,
+				category: STREAM_READER,
+				cause:    err}}
+		return nil, msgErr
+	}
+	registerStream(streamId,
+		openStream{token: uuid.Str(), port: port, server: stream})
+
 	//init the reader
 	r := &mutationStreamReader{streamId: streamId,
 		stream:          stream,
@@ -79,10 +241,13 @@ func CreateMutationStreamReader(streamId common.StreamId, bucketQueueMap BucketQ
 		supvCmdch:       supvCmdch,
 		supvRespch:      supvRespch,
 		numWorkers:      numWorkers,
-		workerch:        make([]MutationChannel, numWorkers),
+		workerch:        make([]chan interface{}, numWorkers),
 		workerStopCh:    make([]StopChannel, numWorkers),
+		killch:          make(chan bool),
 		bucketQueueMap:  CopyBucketQueueMap(bucketQueueMap),
 		bucketFilterMap: make(map[string]*common.TsVbuuid),
+		queueMem:         queueMem,
+		throttleInterval: throttleInterval,
 	}
 
 	r.initBucketFilter()
@@ -92,7 +257,7 @@ func CreateMutationStreamReader(streamId common.StreamId, bucketQueueMap BucketQ
 
 	//init worker buffers
 	for w := 0; w < r.numWorkers; w++ {
-		r.workerch[w] = make(MutationChannel, MAX_STREAM_READER_WORKER_BUFFER)
+		r.workerch[w] = make(chan interface{}, MAX_STREAM_READER_WORKER_BUFFER)
 		r.workerStopCh[w] = make(StopChannel)
 	}
 
@@ -110,6 +275,7 @@ func (r *mutationStreamReader) Shutdown() {
 
 	//close the mutation stream
 	r.stream.Close()
+	unregisterStream(r.streamId)
 
 	//stop all workers
 	r.stopWorkers()
@@ -149,6 +315,12 @@ func (r *mutationStreamReader) run() {
 				r.supvRespch <- msgErr
 			}
 
+		case <-r.killch:
+			//a worker has panicked, supervisor sends no more commands
+			//once the panic is reported. Shutdown stream reader.
+			r.Shutdown()
+			return
+
 		case cmd, ok := <-r.supvCmdch:
 			if ok {
 				//handle commands from supervisor
@@ -171,15 +343,71 @@ func (r *mutationStreamReader) run() {
 
 }
 
+//handleVbKeyVersions shards the incoming mutations by vbucket
+//and hands them over to the stream workers
 func (r *mutationStreamReader) handleVbKeyVersions(vbKeyVers []*protobuf.VbKeyVersions) {
 
 	for _, vb := range vbKeyVers {
 
-		r.handleKeyVersions(vb.GetBucketname(), Vbucket(vb.GetVbucket()),
-			Vbuuid(vb.GetVbuuid()), vb.GetKvs())
+		if !r.sendToWorker(r.workerId(Vbucket(vb.GetVbucket())), vb) {
+			return
+		}
+
+	}
+
+}
+
+//handleConnectionError hands over the vbuckets of a connection error to
+//the workers owning them, behind the mutations and control messages
+//already queued for those vbuckets, so that supervisor receives the error
+//after the StreamBegin of the vbuckets.
+func (r *mutationStreamReader) handleConnectionError(connErr dataport.ConnectionError) {
+
+	workerErrs := make([]dataport.ConnectionError, r.numWorkers)
+	for bucket, vbList := range connErr {
+		//ConnError with empty vblist is still forwarded as is, by
+		//the first worker.
+		if len(vbList) == 0 {
+			if workerErrs[0] == nil {
+				workerErrs[0] = make(dataport.ConnectionError)
+			}
+			workerErrs[0][bucket] = vbList
+			continue
+		}
+		for _, vb := range vbList {
+			w := r.workerId(Vbucket(vb))
+			if workerErrs[w] == nil {
+				workerErrs[w] = make(dataport.ConnectionError)
+			}
+			workerErrs[w][bucket] = append(workerErrs[w][bucket], vb)
+		}
+	}
 
+	for w, workerErr := range workerErrs {
+		if workerErr == nil {
+			continue
+		}
+		if !r.sendToWorker(w, workerErr) {
+			return
+		}
 	}
+}
+
+//workerId returns the worker owning vbucket
+func (r *mutationStreamReader) workerId(vbucket Vbucket) int {
+	return int(vbucket) % r.numWorkers
+}
 
+//sendToWorker queues msg for worker, returns false if workers have failed
+func (r *mutationStreamReader) sendToWorker(workerId int, msg interface{}) bool {
+
+	select {
+	case r.workerch[workerId] <- msg:
+		return true
+	case <-r.killch:
+		//worker has failed, stream error is already with supervisor
+		return false
+	}
 }
 
 func (r *mutationStreamReader) handleKeyVersions(bucket string, vbucket Vbucket, vbuuid Vbuuid,
@@ -193,7 +421,7 @@ func (r *mutationStreamReader) handleKeyVersions(bucket string, vbucket Vbucket,
 }
 
 //handleSingleKeyVersion processes a single mutation based on the command type
-//A mutation is put in a worker queue and control message is sent to supervisor
+//A mutation is put in the mutation queue and control message is sent to supervisor
 func (r *mutationStreamReader) handleSingleKeyVersion(bucket string, vbucket Vbucket, vbuuid Vbuuid,
 	kv *protobuf.KeyVersions) {
 
@@ -215,7 +443,7 @@ func (r *mutationStreamReader) handleSingleKeyVersion(bucket string, vbucket Vbu
 		switch byte(cmd) {
 
 		//case protobuf.Command_Upsert, protobuf.Command_Deletion, protobuf.Command_UpsertDeletion:
-		case common.Upsert, common.Deletion, common.UpsertDeletion:
+		case common.Upsert, common.Deletion, common.UpsertDeletion, common.Expiration:
 
 			//As there can multiple keys in a KeyVersion for a mutation,
 			//filter needs to be evaluated and set only once.
@@ -250,6 +478,11 @@ func (r *mutationStreamReader) handleSingleKeyVersion(bucket string, vbucket Vbu
 			mut.commands = append(mut.commands,
 				byte(kv.GetCommands()[i]))
 			mut.partnkeys = append(mut.partnkeys, kv.GetKeys()[i])
+			if values := kv.GetValues(); len(values) > i && len(values[i]) > 0 {
+				mut.values = append(mut.values, values[i])
+			} else {
+				mut.values = append(mut.values, nil)
+			}
 
 		case common.Sync:
 			msg := &MsgStream{mType: STREAM_READER_SYNC,
@@ -301,23 +534,39 @@ func (r *mutationStreamReader) handleSingleKeyVersion(bucket string, vbucket Vbu
 		}
 	}
 
-	//place secKey in the right worker's queue
+	//place secKey in the mutation queue
 	if mut != nil {
-		r.workerch[int(vbucket)%r.numWorkers] <- mut
+		r.handleSingleMutation(mut)
 	}
 
 }
 
-//startMutationStreamWorker is the worker which processes mutation in a worker queue
+//startMutationStreamWorker is the worker which processes mutations of
+//the vbuckets sharded to its worker queue
 func (r *mutationStreamReader) startMutationStreamWorker(workerId int, stopch StopChannel) {
 
+	//panic handler, deferred first so that the worker is done before
+	//the panic is reported
+	defer r.panicHandler()
+	defer r.workerWg.Done()
+
 	common.Infof("MutationStreamReader::startMutationStreamWorker Stream Worker %v "+
 		"Started for Stream %v.", workerId, r.streamId)
 
 	for {
 		select {
-		case mut := <-r.workerch[workerId]:
-			r.handleSingleMutation(mut)
+		case msg := <-r.workerch[workerId]:
+			switch msg := msg.(type) {
+			case *protobuf.VbKeyVersions:
+				//hold the stream while flushers catch up with the queues,
+				//waiting is bounded so that sync messages are not held up
+				r.queueMem.throttle(r.throttleInterval, stopch)
+				r.handleKeyVersions(msg.GetBucketname(), Vbucket(msg.GetVbucket()),
+					Vbuuid(msg.GetVbuuid()), msg.GetKvs())
+
+			case dataport.ConnectionError:
+				r.sendConnectionError(msg)
+			}
 		case <-stopch:
 			common.Infof("MutationStreamReader::startMutationStreamWorker Stream Worker %v "+
 				"Stopped for Stream %v", workerId, r.streamId)
@@ -354,16 +603,14 @@ func (r *mutationStreamReader) handleStreamInfoMsg(msg interface{}) {
 		common.Debugf("MutationStreamReader::handleStreamInfoMsg \n\tReceived ConnectionError "+
 			"from Client for Stream %v %v.", r.streamId, msg.(dataport.ConnectionError))
 
-		//send a separate message for each bucket. If the ConnError is with empty vblist,
-		//the message is ignored.
-		for bucket, vbList := range msg.(dataport.ConnectionError) {
-			supvMsg = &MsgStreamInfo{mType: STREAM_READER_CONN_ERROR,
-				streamId: r.streamId,
-				bucket:   bucket,
-				vbList:   copyVbList(vbList),
-			}
-			r.supvRespch <- supvMsg
-		}
+		r.handleConnectionError(msg.(dataport.ConnectionError))
+
+	case *dataport.ConnectionInfo:
+		info := msg.(*dataport.ConnectionInfo)
+		common.Infof("MutationStreamReader::handleStreamInfoMsg \n\tReceived ConnectionInfo "+
+			"from %v for Stream %v Topic %v.", info.Raddr, r.streamId, info.Topic)
+
+		r.handleConnectionInfo(info)
 
 	default:
 		common.Errorf("MutationStreamReader::handleStreamError \n\tReceived Unknown Message "+
@@ -376,6 +623,55 @@ func (r *mutationStreamReader) handleStreamInfoMsg(msg interface{}) {
 	}
 }
 
+//sendConnectionError sends a separate message for each bucket of the
+//connection error to supervisor. If the ConnError is with empty vblist,
+//the message is ignored.
+func (r *mutationStreamReader) sendConnectionError(connErr dataport.ConnectionError) {
+
+	for bucket, vbList := range connErr {
+		supvMsg := &MsgStreamInfo{mType: STREAM_READER_CONN_ERROR,
+			streamId: r.streamId,
+			bucket:   bucket,
+			vbList:   copyVbList(vbList),
+		}
+		r.supvRespch <- supvMsg
+	}
+}
+
+//handleConnectionInfo validates the routing of a projector connection
+//against the mutation queues of this stream. Vbuckets beyond the maximum
+//are rejected by dataport itself, vbuckets beyond the mutation queue of
+//their bucket get the connection closed, so that the stream is repaired
+//instead of failing on enqueue. Buckets not served by the stream are
+//expected while the projector catches up with a bucket removed from the
+//stream, their mutations are skipped.
+func (r *mutationStreamReader) handleConnectionInfo(info *dataport.ConnectionInfo) {
+
+	for bucket, vbList := range info.Vbuckets {
+		q, ok := r.bucketQueueMap[bucket]
+		if !ok {
+			common.Warnf("MutationStreamReader::handleConnectionInfo \n\tBucket %v "+
+				"framed by %v not in Stream %v, skipping its mutations.",
+				bucket, info.Raddr, r.streamId)
+			continue
+		}
+		numVbuckets := q.queue.GetNumVbuckets()
+		for _, vb := range vbList {
+			if vb < numVbuckets {
+				continue
+			}
+			common.Errorf("MutationStreamReader::handleConnectionInfo \n\tInvalid "+
+				"Vbucket %v for Bucket %v framed by %v for Stream %v.", vb, bucket,
+				info.Raddr, r.streamId)
+			if err := r.stream.RejectRouting(info.Raddr); err != nil {
+				common.Errorf("MutationStreamReader::handleConnectionInfo \n\tFail to "+
+					"close connection %v for Stream %v. Error %v", info.Raddr, r.streamId, err)
+			}
+			return
+		}
+	}
+}
+
 //handleSupervisorCommands handles the messages from Supervisor
 func (r *mutationStreamReader) handleSupervisorCommands(cmd Message) Message {
 
@@ -414,6 +710,7 @@ func (r *mutationStreamReader) panicHandler() {
 	//panic recovery
 	if rc := recover(); rc != nil {
 		common.Fatalf("MutationStreamReader::panicHandler \n\tReceived Panic for Stream %v", r.streamId)
+		r.killOnce.Do(func() { close(r.killch) })
 		//TODO Log the stack trace here
 		var err error
 		switch x := rc.(type) {
@@ -442,6 +739,7 @@ func (r *mutationStreamReader) startWorkers() {
 
 	//start worker goroutines to process incoming mutation concurrently
 	for w := 0; w < r.numWorkers; w++ {
+		r.workerWg.Add(1)
 		go r.startMutationStreamWorker(w, r.workerStopCh[w])
 	}
 }
@@ -452,9 +750,15 @@ func (r *mutationStreamReader) stopWorkers() {
 
 	common.Debugf("MutationStreamReader::stopWorkers Stopping All Stream Workers")
 
-	//stop all workers
+	//stop all workers, workers that have panicked are already done
 	for _, ch := range r.workerStopCh {
-		ch <- true
+		close(ch)
+	}
+	r.workerWg.Wait()
+
+	//workers are started again on queue map update
+	for w := range r.workerStopCh {
+		r.workerStopCh[w] = make(StopChannel)
 	}
 }
 
@@ -535,10 +839,10 @@ func copyVbList(vbList []uint16) []Vbucket {
 
 func logPerfStat() {
 
-	mutationCount++
-	if (mutationCount%10000 == 0) || mutationCount == 1 {
+	count := atomic.AddUint64(&mutationCount, 1)
+	if (count%10000 == 0) || count == 1 {
 		common.Infof("MutationStreamReader::logPerfStat \n"+
-			"MutationCount %v", mutationCount)
+			"MutationCount %v", count)
 	}
 
 }
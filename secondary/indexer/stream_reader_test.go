@@ -0,0 +1,171 @@
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/dataport"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
+	"github.com/couchbaselabs/goprotobuf/proto"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStreamPortRange(t *testing.T) {
+	if laddrs, err := streamPortRange(""); err != nil || laddrs != nil {
+		t.Errorf("expected no addresses for empty range, got %v %v", laddrs, err)
+	}
+	laddrs, err := streamPortRange("9200-9202")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref := []string{":9200", ":9201", ":9202"}; !reflect.DeepEqual(laddrs, ref) {
+		t.Errorf("expected %v, got %v", ref, laddrs)
+	}
+	for _, portRange := range []string{"9200", "9202-9200", "0-10", "a-b", "9200-70000"} {
+		if _, err := streamPortRange(portRange); err != ErrInvalidPortRange {
+			t.Errorf("expected %v for %q, got %v", ErrInvalidPortRange, portRange, err)
+		}
+	}
+}
+
+func TestOpenStreams(t *testing.T) {
+	registerStream(common.INIT_STREAM, openStream{token: "token", port: "9200"})
+	if token := getStreamToken(common.INIT_STREAM); token != "token" {
+		t.Errorf("expected token, got %q", token)
+	}
+	if port := getStreamPort(common.INIT_STREAM); port != "9200" {
+		t.Errorf("expected port 9200, got %q", port)
+	}
+	if port := getStreamPort(common.MAINT_STREAM); port != "" {
+		t.Errorf("expected no port for stream not open, got %q", port)
+	}
+	unregisterStream(common.INIT_STREAM)
+	if token := getStreamToken(common.INIT_STREAM); token != "" {
+		t.Errorf("expected no token after stream is closed, got %q", token)
+	}
+}
+
+func TestStreamWorkerPanic(t *testing.T) {
+	supvRespch := make(MsgChannel, 1)
+	r := &mutationStreamReader{streamId: common.MAINT_STREAM,
+		supvRespch:   supvRespch,
+		numWorkers:   2,
+		workerch:     make([]chan interface{}, 2),
+		workerStopCh: make([]StopChannel, 2),
+		killch:       make(chan bool),
+		//queue of the bucket is missing, enqueue panics
+		bucketQueueMap:  BucketQueueMap{"default": IndexerMutationQueue{}},
+		bucketFilterMap: map[string]*common.TsVbuuid{"default": common.NewTsVbuuid("default", 2)},
+	}
+	for w := 0; w < r.numWorkers; w++ {
+		r.workerch[w] = make(chan interface{}, 1)
+		r.workerStopCh[w] = make(StopChannel)
+	}
+	r.startWorkers()
+
+	vb := &protobuf.VbKeyVersions{
+		Bucketname: proto.String("default"),
+		Vbucket:    proto.Uint32(1),
+		Vbuuid:     proto.Uint64(10),
+		Kvs: []*protobuf.KeyVersions{{
+			Seqno:    proto.Uint64(1),
+			Docid:    []byte("doc1"),
+			Uuids:    []uint64{1},
+			Commands: []uint32{uint32(common.Upsert)},
+			Keys:     [][]byte{[]byte("key1")},
+			Oldkeys:  [][]byte{nil},
+		}},
+	}
+	r.handleVbKeyVersions([]*protobuf.VbKeyVersions{vb})
+
+	select {
+	case msg := <-supvRespch:
+		errMsg, ok := msg.(*MsgStreamError)
+		if !ok || errMsg.GetError().code != ERROR_STREAM_READER_PANIC {
+			t.Fatalf("expected stream reader panic, got %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("worker panic is not reported")
+	}
+
+	//mutations are no longer handed to the failed worker
+	for i := 0; i < 4; i++ {
+		r.handleVbKeyVersions([]*protobuf.VbKeyVersions{vb})
+	}
+	//other workers are stopped, failed worker is already done
+	donech := make(chan bool)
+	go func() {
+		r.stopWorkers()
+		close(donech)
+	}()
+	select {
+	case <-donech:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("workers are not stopped")
+	}
+}
+
+func TestStreamConnErrorOrder(t *testing.T) {
+	supvRespch := make(MsgChannel, 10)
+	r := &mutationStreamReader{streamId: common.MAINT_STREAM,
+		supvRespch:      supvRespch,
+		numWorkers:      2,
+		workerch:        make([]chan interface{}, 2),
+		workerStopCh:    make([]StopChannel, 2),
+		killch:          make(chan bool),
+		bucketQueueMap:  BucketQueueMap{"default": IndexerMutationQueue{}},
+		bucketFilterMap: map[string]*common.TsVbuuid{"default": common.NewTsVbuuid("default", 4)},
+	}
+	for w := 0; w < r.numWorkers; w++ {
+		r.workerch[w] = make(chan interface{}, 10)
+		r.workerStopCh[w] = make(StopChannel)
+	}
+
+	//StreamBegin of vbuckets is queued with workers not yet running
+	vbs := make([]*protobuf.VbKeyVersions, 0)
+	for _, vbno := range []uint32{1, 2} {
+		vbs = append(vbs, &protobuf.VbKeyVersions{
+			Bucketname: proto.String("default"),
+			Vbucket:    proto.Uint32(vbno),
+			Vbuuid:     proto.Uint64(10),
+			Kvs: []*protobuf.KeyVersions{{
+				Seqno:    proto.Uint64(1),
+				Commands: []uint32{uint32(common.StreamBegin)},
+			}},
+		})
+	}
+	r.handleVbKeyVersions(vbs)
+	r.handleStreamInfoMsg(dataport.ConnectionError{"default": {1, 2}})
+
+	select {
+	case msg := <-supvRespch:
+		t.Fatalf("ConnectionError forwarded ahead of workers: %v", msg)
+	default:
+	}
+
+	r.startWorkers()
+	defer r.stopWorkers()
+
+	begun := make(map[Vbucket]bool)
+	errored := make(map[Vbucket]bool)
+	for len(errored) < 2 {
+		select {
+		case msg := <-supvRespch:
+			switch msg.GetMsgType() {
+			case STREAM_READER_STREAM_BEGIN:
+				begun[msg.(*MsgStream).GetMutationMeta().vbucket] = true
+			case STREAM_READER_CONN_ERROR:
+				for _, vb := range msg.(*MsgStreamInfo).GetVbList() {
+					if !begun[vb] {
+						t.Fatalf("ConnectionError for vbucket %v before StreamBegin", vb)
+					}
+					errored[vb] = true
+				}
+			default:
+				t.Fatalf("unexpected message %v", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ConnectionError is not forwarded")
+		}
+	}
+}
@@ -345,12 +345,7 @@ func (ss *StreamState) incrSyncCount(streamId common.StreamId,
 		//update only if its less than trigger count, otherwise it makes no
 		//difference. On long running systems, syncCount may overflow otherwise
 
-		snapInterval := ss.config["settings.inmemory_snapshot.interval"].Uint64() * uint64(ss.config["numVbuckets"].Int())
-		syncPeriod := ss.config["sync_period"].Uint64()
-		// Number of sync messages after which an inmemory snapshot is triggered.
-		maxSyncCount := snapInterval / syncPeriod
-
-		if syncCount <= maxSyncCount {
+		if syncCount <= ss.maxSyncCount(streamId) {
 			bucketSyncCountMap[bucket] = syncCount
 		}
 
@@ -382,12 +377,8 @@ func (ss *StreamState) checkNewTSDue(streamId common.StreamId, bucket string) bo
 
 	bucketNewTsReqd := ss.streamBucketNewTsReqdMap[streamId]
 	bucketSyncCountMap := ss.streamBucketSyncCountMap[streamId]
-	snapInterval := ss.config["settings.inmemory_snapshot.interval"].Uint64() * uint64(ss.config["numVbuckets"].Int())
-	syncPeriod := ss.config["sync_period"].Uint64()
-	// Number of sync messages after which an inmemory snapshot is triggered.
-	maxSyncCount := snapInterval / syncPeriod
 
-	if bucketSyncCountMap[bucket] >= maxSyncCount &&
+	if bucketSyncCountMap[bucket] >= ss.maxSyncCount(streamId) &&
 		bucketNewTsReqd[bucket] == true &&
 		ss.checkAllStreamBeginsReceived(streamId, bucket) == true {
 		return true
@@ -407,14 +398,7 @@ func (ss *StreamState) getNextStabilityTS(streamId common.StreamId,
 	//snapshot high seq num as that persistence will happen at these seqnums.
 	updateTsSeqNumToSnapshot(tsVbuuid)
 
-	numVbuckets := uint64(ss.config["numVbuckets"].Int())
-	snapInterval := ss.config["settings.inmemory_snapshot.interval"].Uint64() * numVbuckets
-	snapPersistInterval := ss.config["settings.persisted_snapshot.interval"].Uint64() * numVbuckets
-	syncPeriod := ss.config["sync_period"].Uint64()
-	// Number of inmemory ts after which a persisted timestamp should be generated
-	numInMemTs := snapPersistInterval / (syncPeriod * snapInterval)
-
-	if ss.streamBucketInMemTsCountMap[streamId][bucket] == numInMemTs {
+	if ss.streamBucketInMemTsCountMap[streamId][bucket] >= ss.numInMemTs(streamId) {
 		//set persisted flag
 		tsVbuuid.SetPersisted(true)
 		ss.streamBucketInMemTsCountMap[streamId][bucket] = 0
@@ -453,6 +437,48 @@ func (ss *StreamState) UpdateConfig(cfg common.Config) {
 	ss.config = cfg
 }
 
+//snapshotIntervals returns the inmemory and persisted snapshot intervals,
+//in milliseconds, for the stream. Initial build and catchup streams use
+//the init_build intervals, when set, and the maintenance stream intervals
+//otherwise.
+func (ss *StreamState) snapshotIntervals(streamId common.StreamId) (
+	snapInterval, persistInterval uint64) {
+
+	snapInterval = ss.config["settings.inmemory_snapshot.interval"].Uint64()
+	persistInterval = ss.config["settings.persisted_snapshot.interval"].Uint64()
+
+	if streamId == common.INIT_STREAM || streamId == common.CATCHUP_STREAM {
+		if v := ss.config["settings.inmemory_snapshot.init_build.interval"].Uint64(); v > 0 {
+			snapInterval = v
+		}
+		if v := ss.config["settings.persisted_snapshot.init_build.interval"].Uint64(); v > 0 {
+			persistInterval = v
+		}
+	}
+	return snapInterval, persistInterval
+}
+
+//maxSyncCount returns the number of sync messages after which an inmemory
+//snapshot is triggered for the stream.
+func (ss *StreamState) maxSyncCount(streamId common.StreamId) uint64 {
+
+	snapInterval, _ := ss.snapshotIntervals(streamId)
+	numVbuckets := uint64(ss.config["numVbuckets"].Int())
+	syncPeriod := ss.config["sync_period"].Uint64()
+	return snapInterval * numVbuckets / syncPeriod
+}
+
+//numInMemTs returns the number of inmemory timestamps after which a
+//persisted timestamp is generated for the stream.
+func (ss *StreamState) numInMemTs(streamId common.StreamId) uint64 {
+
+	snapInterval, persistInterval := ss.snapshotIntervals(streamId)
+	if snapInterval == 0 {
+		return 0
+	}
+	return persistInterval / (ss.config["sync_period"].Uint64() * snapInterval)
+}
+
 //helper function to update Seqnos in TsVbuuid to
 //high seqnum of snapshot markers
 func updateTsSeqNumToSnapshot(ts *common.TsVbuuid) {
@@ -0,0 +1,45 @@
+package indexer
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+)
+
+func TestStreamSnapshotIntervals(t *testing.T) {
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	config.SetValue("numVbuckets", 4)
+	config.SetValue("sync_period", uint64(100))
+	config.SetValue("settings.inmemory_snapshot.interval", uint64(200))
+	config.SetValue("settings.persisted_snapshot.interval", uint64(40000))
+	ss := InitStreamState(config)
+
+	// init_build intervals are not set, all streams use the same intervals.
+	for _, streamId := range []common.StreamId{common.MAINT_STREAM, common.INIT_STREAM} {
+		if n := ss.maxSyncCount(streamId); n != 8 {
+			t.Errorf("%v: expected max sync count 8, got %v", streamId, n)
+		}
+		if n := ss.numInMemTs(streamId); n != 2 {
+			t.Errorf("%v: expected 2 inmemory ts per persisted ts, got %v", streamId, n)
+		}
+	}
+
+	config = config.Clone()
+	config.SetValue("settings.inmemory_snapshot.init_build.interval", uint64(1000))
+	config.SetValue("settings.persisted_snapshot.init_build.interval", uint64(400000))
+	ss.UpdateConfig(config)
+
+	if n := ss.maxSyncCount(common.MAINT_STREAM); n != 8 {
+		t.Errorf("expected max sync count 8 for maintenance stream, got %v", n)
+	}
+	if n := ss.numInMemTs(common.MAINT_STREAM); n != 2 {
+		t.Errorf("expected 2 inmemory ts for maintenance stream, got %v", n)
+	}
+	for _, streamId := range []common.StreamId{common.INIT_STREAM, common.CATCHUP_STREAM} {
+		if n := ss.maxSyncCount(streamId); n != 40 {
+			t.Errorf("%v: expected max sync count 40, got %v", streamId, n)
+		}
+		if n := ss.numInMemTs(streamId); n != 4 {
+			t.Errorf("%v: expected 4 inmemory ts per persisted ts, got %v", streamId, n)
+		}
+	}
+}
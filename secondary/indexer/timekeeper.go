@@ -1669,6 +1669,17 @@ func (tk *timekeeper) sendRestartMsg(restartMsg Message) {
 			tk.supvRespch <- &MsgRecovery{mType: INDEXER_BUCKET_NOT_FOUND,
 				streamId: streamId,
 				bucket:   bucket}
+		} else if isBucketUUIDChangedMsg(kvresp) {
+			common.Errorf("Timekeeper::sendRestartMsg \n\tBucket Flushed Or Recreated "+
+				"For Stream %v Bucket %v", streamId, bucket)
+
+			delete(tk.ss.streamBucketRepairStopCh[streamId], bucket)
+
+			tk.ss.streamBucketStatus[streamId][bucket] = STREAM_INACTIVE
+
+			tk.supvRespch <- &MsgRecovery{mType: INDEXER_BUCKET_UUID_CHANGED,
+				streamId: streamId,
+				bucket:   bucket}
 		} else {
 			common.Fatalf("Timekeeper::sendRestartMsg Error Response "+
 				"from KV %v For Request %v. Retrying RestartVbucket.", kvresp, restartMsg)
@@ -0,0 +1,286 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"errors"
+	"github.com/couchbase/indexing/secondary/common"
+	memcached "github.com/couchbase/indexing/secondary/dcp/transport/client"
+	"sync"
+	"time"
+)
+
+var ErrPurgeStopped = errors.New("Purge stopped")
+
+// docObserver observes the state of documents in KV, only metadata of
+// a document is fetched.
+type docObserver interface {
+	Observe(docid string) (memcached.ObserveResult, error)
+}
+
+// tombstonePurger reclaims index entries of documents deleted or expired
+// in KV whose deletion never reached the index, because KV purged their
+// tombstones before they were streamed, say when the index restarted from
+// an older snapshot. Such entries otherwise linger in the index for good.
+//
+// Entries of a vbucket at or below its KV purge seqno are verified with
+// KV and the ones missing there are purged. A vbucket is verified again
+// only after its purge seqno moves ahead, as entries verified earlier
+// could have lost their tombstones only then, and a pass is skipped
+// altogether when no purge seqno has moved.
+//
+// A pass can be stopped by Close(), which waits for it to exit, so that
+// the index is not purged while it is rolled back.
+type tombstonePurger struct {
+	cluster   string
+	bucket    string
+	numVbs    int
+	batchSize int
+
+	// KV access, replaced by tests
+	purgeSeqnos func() (Timestamp, error)
+	connect     func() (docObserver, func(), error)
+
+	mu       sync.Mutex // held by a pass
+	lastTs   Timestamp  // purge seqnos verified by the last pass
+	stopch   chan bool
+	stopOnce sync.Once
+}
+
+func newTombstonePurger(config common.Config, bucket string) *tombstonePurger {
+	tp := &tombstonePurger{
+		cluster:   config["clusterAddr"].String(),
+		bucket:    bucket,
+		numVbs:    config["numVbuckets"].Int(),
+		batchSize: config["purge.batchSize"].Int(),
+		stopch:    make(chan bool),
+	}
+	tp.purgeSeqnos = func() (Timestamp, error) {
+		return GetCurrentKVPurgeTs(tp.cluster, tp.bucket, tp.numVbs)
+	}
+	tp.connect = func() (docObserver, func(), error) {
+		b, err := common.ConnectBucket(tp.cluster, DEFAULT_POOL, tp.bucket)
+		if err != nil {
+			return nil, nil, err
+		}
+		return b, b.Close, nil
+	}
+	return tp
+}
+
+// Close stops the pass in progress, if any, and waits for it to exit.
+// Later passes return ErrPurgeStopped.
+func (tp *tombstonePurger) Close() {
+	tp.stopOnce.Do(func() { close(tp.stopch) })
+	tp.mu.Lock()
+	tp.mu.Unlock()
+}
+
+// Purge entries of index snapshot `is` from slices of `partnMap`, and
+// return the number of entries found missing in KV. Purged entries are
+// removed from the next snapshot, snapshots taken earlier, including
+// retained snapshots, continue to see them.
+func (tp *tombstonePurger) Purge(is IndexSnapshot,
+	partnMap PartitionInstMap) (int, error) {
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	select {
+	case <-tp.stopch:
+		return 0, ErrPurgeStopped
+	default:
+	}
+
+	purgeTs, err := tp.purgeSeqnos()
+	if err != nil {
+		return 0, err
+	}
+	if !tp.hasCandidates(purgeTs) {
+		return 0, nil
+	}
+
+	kv, closeKV, err := tp.connect()
+	if err != nil {
+		return 0, err
+	}
+	defer closeKV()
+
+	count := 0
+	for partnId, ps := range is.Partitions() {
+		partnInst, ok := partnMap[partnId]
+		if !ok {
+			continue
+		}
+		for sliceId, ss := range ps.Slices() {
+			slice := partnInst.Sc.GetSliceById(sliceId)
+			if slice == nil {
+				continue
+			}
+			n, err := tp.purgeSlice(ss.Snapshot(), slice, purgeTs, kv)
+			count += n
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+
+	tp.lastTs = purgeTs
+	return count, nil
+}
+
+func (tp *tombstonePurger) purgeSlice(snap Snapshot, slice Slice,
+	purgeTs Timestamp, kv docObserver) (count int, err error) {
+
+	stopch := make(StopChannel)
+	chval, cherr := snap.ValueSet(stopch)
+	defer func() {
+		if err != nil {
+			close(stopch)
+			for _ = range chval {
+			}
+		}
+	}()
+
+	batch := make(map[string]Seqno)
+	for {
+		select {
+		case val, ok := <-chval:
+			if !ok {
+				n, err := tp.verify(batch, slice, kv)
+				return count + n, err
+			}
+			if !tp.isCandidate(val.Raw(), purgeTs) {
+				continue
+			}
+			batch[string(val.Docid())] = val.Raw().Seqno
+			if len(batch) >= tp.batchSize {
+				n, err := tp.verify(batch, slice, kv)
+				count += n
+				if err != nil {
+					return count, err
+				}
+				batch = make(map[string]Seqno)
+			}
+
+		case err, ok := <-cherr:
+			if !ok {
+				cherr = nil // values are drained from chval
+			} else if err != nil {
+				return count, err
+			}
+
+		case <-tp.stopch:
+			return count, ErrPurgeStopped
+		}
+	}
+}
+
+// verify a batch of documents with KV and purge the ones missing there,
+// return the number of documents purged.
+func (tp *tombstonePurger) verify(batch map[string]Seqno, slice Slice,
+	kv docObserver) (int, error) {
+
+	missing := 0
+	for docid, seqno := range batch {
+		result, err := kv.Observe(docid)
+		if err != nil {
+			return missing, err
+		}
+		if result.Status != memcached.ObservedNotFound &&
+			result.Status != memcached.ObservedLogicallyDeleted {
+			continue
+		}
+		if err := slice.Purge([]byte(docid), seqno); err != nil {
+			return missing, err
+		}
+		missing++
+	}
+	return missing, nil
+}
+
+// hasCandidates returns true if purge seqno of any vbucket has moved
+// since the last pass.
+func (tp *tombstonePurger) hasCandidates(purgeTs Timestamp) bool {
+	for vbno, seqno := range purgeTs {
+		if seqno == 0 {
+			continue
+		} else if vbno >= len(tp.lastTs) || seqno != tp.lastTs[vbno] {
+			return true
+		}
+	}
+	return false
+}
+
+// entries of documents that could have been purged from KV since the
+// last pass.
+func (tp *tombstonePurger) isCandidate(v Valuedata, purgeTs Timestamp) bool {
+	vbno := int(v.Vbucket)
+	if vbno >= len(purgeTs) || v.Seqno > purgeTs[vbno] {
+		return false
+	}
+	if vbno < len(tp.lastTs) && purgeTs[vbno] == tp.lastTs[vbno] {
+		return false
+	}
+	return true
+}
+
+// purgeDaemon periodically runs a purge pass on every index instance,
+// one instance at a time.
+type purgeDaemon struct {
+	quitch  chan bool
+	started bool
+	ticker  *time.Ticker
+	msgch   MsgChannel
+	config  common.Config
+}
+
+func (pd *purgeDaemon) Start() {
+	interval := pd.config["interval"].Int()
+	if !pd.started && interval > 0 {
+		pd.ticker = time.NewTicker(time.Second * time.Duration(interval))
+		pd.started = true
+		go pd.loop()
+	}
+}
+
+func (pd *purgeDaemon) Stop() {
+	if pd.started {
+		pd.ticker.Stop()
+		pd.quitch <- true
+		<-pd.quitch
+	}
+}
+
+func (pd *purgeDaemon) loop() {
+loop:
+	for {
+		select {
+		case _, ok := <-pd.ticker.C:
+			if ok {
+				replych := make(chan []IndexStorageStats)
+				pd.msgch <- &MsgIndexStorageStats{respch: replych}
+				stats := <-replych
+
+				for _, is := range stats {
+					errch := make(chan error)
+					pd.msgch <- &MsgIndexPurge{instId: is.InstId, errch: errch}
+					if err := <-errch; err != nil {
+						common.Errorf("PurgeDaemon: Index instance:%v Purge failed with reason - %v", is.InstId, err)
+					}
+				}
+			}
+
+		case <-pd.quitch:
+			pd.quitch <- true
+			break loop
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package indexer
+
+import (
+	memcached "github.com/couchbase/indexing/secondary/dcp/transport/client"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTombstonePurgerCandidate(t *testing.T) {
+	tp := &tombstonePurger{numVbs: 2, batchSize: 10}
+	purgeTs := Timestamp{10, 20}
+
+	// first pass verifies every entry upto purge seqno
+	if !tp.isCandidate(Valuedata{Vbucket: 0, Seqno: 10}, purgeTs) {
+		t.Errorf("expected entry at purge seqno to be a candidate")
+	}
+	if tp.isCandidate(Valuedata{Vbucket: 0, Seqno: 11}, purgeTs) {
+		t.Errorf("expected entry beyond purge seqno to be skipped")
+	}
+	if tp.isCandidate(Valuedata{Vbucket: 2, Seqno: 1}, purgeTs) {
+		t.Errorf("expected entry of unknown vbucket to be skipped")
+	}
+
+	// later passes verify only vbuckets whose purge seqno moved ahead
+	tp.lastTs = purgeTs
+	purgeTs = Timestamp{10, 30}
+	if tp.isCandidate(Valuedata{Vbucket: 0, Seqno: 5}, purgeTs) {
+		t.Errorf("expected entry of verified vbucket to be skipped")
+	}
+	if !tp.isCandidate(Valuedata{Vbucket: 1, Seqno: 5}, purgeTs) {
+		t.Errorf("expected entry of vbucket with new purge seqno to be a candidate")
+	}
+}
+
+type purgeRecorder struct {
+	*mockSlice
+	purged map[string]Seqno
+}
+
+func (s *purgeRecorder) Purge(docid []byte, seqno Seqno) error {
+	s.purged[string(docid)] = seqno
+	return nil
+}
+
+// fakeObserver knows the status of documents present in KV.
+type fakeObserver map[string]memcached.ObservedStatus
+
+func (kv fakeObserver) Observe(docid string) (memcached.ObserveResult, error) {
+	status, ok := kv[docid]
+	if !ok {
+		status = memcached.ObservedNotFound
+	}
+	return memcached.ObserveResult{Status: status}, nil
+}
+
+func TestTombstonePurgerPurgeSlice(t *testing.T) {
+	tp := &tombstonePurger{numVbs: 2, batchSize: 2, stopch: make(chan bool)}
+	entries := []struct {
+		docid string
+		vbno  Vbucket
+		seqno Seqno
+	}{
+		{"live", 0, 5},
+		{"deleted", 0, 6},
+		{"expired", 1, 7},
+		{"recent", 0, 50},
+		{"pending", 1, 8},
+	}
+	valch := make(chan Value, len(entries))
+	for _, e := range entries {
+		val, err := NewValue([]byte(e.docid), e.vbno, e.seqno, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		valch <- val
+	}
+	close(valch)
+	errch := make(chan error)
+	close(errch)
+
+	snap := &mockSnapshot{valch: valch, errch: errch}
+	slice := &purgeRecorder{&mockSlice{}, make(map[string]Seqno)}
+	kv := fakeObserver{
+		"live":    memcached.ObservedPersisted,
+		"pending": memcached.ObservedLogicallyDeleted,
+	}
+	count, err := tp.purgeSlice(snap, slice, Timestamp{10, 10}, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// entries beyond purge seqno are not verified, though missing in KV.
+	ref := map[string]Seqno{"deleted": 6, "expired": 7, "pending": 8}
+	if count != 3 || !reflect.DeepEqual(slice.purged, ref) {
+		t.Fatalf("expected %v to be purged, got %v (%v)", ref, slice.purged, count)
+	}
+}
+
+func TestTombstonePurgerClose(t *testing.T) {
+	tp := &tombstonePurger{numVbs: 2, batchSize: 2, stopch: make(chan bool)}
+	errch := make(chan error)
+	close(errch)
+	// scan that never completes
+	snap := &mockSnapshot{valch: make(chan Value), errch: errch}
+	slice := &purgeRecorder{&mockSlice{}, make(map[string]Seqno)}
+
+	donech := make(chan error, 1)
+	go func() {
+		tp.mu.Lock()
+		defer tp.mu.Unlock()
+		_, err := tp.purgeSlice(snap, slice, Timestamp{10, 10}, fakeObserver{})
+		donech <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	tp.Close()
+	select {
+	case err := <-donech:
+		if err != ErrPurgeStopped {
+			t.Fatalf("expected %v, got %v", ErrPurgeStopped, err)
+		}
+	default:
+		t.Fatalf("expected Close to wait for the pass to stop")
+	}
+	if _, err := tp.Purge(nil, nil); err != ErrPurgeStopped {
+		t.Fatalf("expected pass after close to be stopped, got %v", err)
+	}
+}
+
+func TestTombstonePurgerSkipPass(t *testing.T) {
+	tp := &tombstonePurger{numVbs: 2, batchSize: 2, stopch: make(chan bool)}
+	tp.lastTs = Timestamp{10, 20}
+	tp.purgeSeqnos = func() (Timestamp, error) { return Timestamp{10, 20}, nil }
+	tp.connect = func() (docObserver, func(), error) {
+		t.Fatalf("unexpected pass when no purge seqno has moved")
+		return nil, nil, nil
+	}
+	if count, err := tp.Purge(nil, nil); count != 0 || err != nil {
+		t.Fatalf("expected pass to be skipped, got %v %v", count, err)
+	}
+}
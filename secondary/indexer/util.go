@@ -69,6 +69,16 @@ func IndexPath(inst *common.IndexInst, sliceId SliceId) string {
 }
 
 func GetCurrentKVTs(cluster, bucket string, numVbs int) (Timestamp, error) {
+	return getCurrentKVSeqnos(cluster, bucket, numVbs, "high_seqno")
+}
+
+// GetCurrentKVPurgeTs returns the purge seqno of every vbucket, upto
+// which KV has purged tombstones of deleted and expired documents.
+func GetCurrentKVPurgeTs(cluster, bucket string, numVbs int) (Timestamp, error) {
+	return getCurrentKVSeqnos(cluster, bucket, numVbs, "purge_seqno")
+}
+
+func getCurrentKVSeqnos(cluster, bucket string, numVbs int, stat string) (Timestamp, error) {
 	ts := NewTimestamp(numVbs)
 	start := time.Now()
 	if b, err := common.ConnectBucket(cluster, "default", bucket); err == nil {
@@ -79,16 +89,16 @@ func GetCurrentKVTs(cluster, bucket string, numVbs int) (Timestamp, error) {
 		for _, nodestat := range stats {
 			//for all vbuckets
 			for i := 0; i < numVbs; i++ {
-				vbkey := "vb_" + strconv.Itoa(i) + ":high_seqno"
-				if highseqno, ok := nodestat[vbkey]; ok {
-					if s, err := strconv.Atoi(highseqno); err == nil {
+				vbkey := "vb_" + strconv.Itoa(i) + ":" + stat
+				if seqno, ok := nodestat[vbkey]; ok {
+					if s, err := strconv.Atoi(seqno); err == nil {
 						ts[i] = Seqno(s)
 					}
 				}
 			}
 		}
 		elapsed := time.Since(start)
-		common.Debugf("Indexer::getCurrentKVTs Time Taken %v \n\t TS Returned %v", elapsed, ts)
+		common.Debugf("Indexer::getCurrentKVTs Time Taken %v \n\t %v TS Returned %v", elapsed, stat, ts)
 		b.Close()
 		return ts, nil
 
@@ -124,3 +134,12 @@ func ValidateBucket(cluster, bucket string) bool {
 		return false
 	}
 }
+
+// isBucketUUIDChangedMsg returns true if msg is an error response from
+// kv sender for a bucket flushed or recreated after its indexes were defined.
+func isBucketUUIDChangedMsg(msg Message) bool {
+	if msg.GetMsgType() != MSG_ERROR {
+		return false
+	}
+	return msg.(*MsgError).GetError().code == ERROR_KVSENDER_BUCKET_UUID_CHANGED
+}
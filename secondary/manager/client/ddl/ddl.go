@@ -0,0 +1,117 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package ddl creates indexes from N1QL DDL statements, for tooling that
+// drives index creation from DDL scripts. It is kept apart from package
+// client so that clients do not depend on the N1QL parser.
+package ddl
+
+import (
+	c "github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/manager/client"
+	"github.com/couchbaselabs/query/algebra"
+	"github.com/couchbaselabs/query/datastore"
+	"github.com/couchbaselabs/query/expression"
+	"github.com/couchbaselabs/query/parser/n1ql"
+	"github.com/couchbaselabs/query/value"
+	"strings"
+)
+
+// CreateIndex creates, through provider, the index defined by a CREATE
+// INDEX or CREATE PRIMARY INDEX statement, refer ParseIndexDDL(), with
+// the plan given by its WITH clause.
+func CreateIndex(provider *client.MetadataProvider, ddl string) (c.IndexDefnId, error) {
+
+	defn, plan, err := ParseIndexDDL(ddl)
+	if err != nil {
+		return c.IndexDefnId(0), err
+	}
+
+	return provider.CreateIndexWithPlan(defn.Name, defn.Bucket, string(defn.Using),
+		string(defn.ExprType), defn.PartitionKey, defn.WhereExpr,
+		defn.SecExprs, defn.IsPrimary, plan)
+}
+
+// ParseIndexDDL parses a N1QL CREATE INDEX or CREATE PRIMARY INDEX
+// statement into an index definition, and the plan given by its WITH
+// clause, if any. Indexes with a USING clause other than GSI, or on a
+// keyspace of a namespace other than default, are not supported. Index
+// expressions are normalized to their N1QL string form, as done by the
+// query engine. DefnId and Nodes of the definition are left to be filled
+// in when the index is created.
+//
+// - return client.ErrInvalidIndexDDL if the statement is not a valid CREATE INDEX.
+func ParseIndexDDL(ddl string) (*c.IndexDefn, map[string]interface{}, error) {
+
+	stmt, err := n1ql.ParseStatement(ddl)
+	if err != nil {
+		return nil, nil, c.WrapError(client.ErrInvalidIndexDDL, err)
+	}
+
+	defn := &c.IndexDefn{
+		Using:           c.IndexType(datastore.GSI),
+		ExprType:        c.ExprType("N1QL"),
+		PartitionScheme: c.SINGLE,
+	}
+
+	var keyspace *algebra.KeyspaceRef
+	var using datastore.IndexType
+	var with value.Value
+
+	switch s := stmt.(type) {
+	case *algebra.CreateIndex:
+		keyspace = s.Keyspace()
+		defn.Name = s.Name()
+		defn.Bucket = keyspace.Keyspace()
+		for _, expr := range s.Expressions() {
+			defn.SecExprs = append(defn.SecExprs, expression.NewStringer().Visit(expr))
+		}
+		if s.Partition() != nil {
+			defn.PartitionKey = expression.NewStringer().Visit(s.Partition())
+		}
+		if s.Where() != nil {
+			defn.WhereExpr = expression.NewStringer().Visit(s.Where())
+		}
+		using, with = s.Using(), s.With()
+
+	case *algebra.CreatePrimaryIndex:
+		keyspace = s.Keyspace()
+		defn.Name = s.Name()
+		defn.Bucket = keyspace.Keyspace()
+		defn.IsPrimary = true
+		using, with = s.Using(), s.With()
+
+	default:
+		return nil, nil, c.WrapError(client.ErrInvalidIndexDDL, "not a CREATE INDEX statement")
+	}
+
+	// indexes are defined on buckets, which belong to the default namespace
+	switch namespace := keyspace.Namespace(); namespace {
+	case "", "default":
+	default:
+		return nil, nil, c.WrapError(client.ErrInvalidIndexDDL, "namespace", namespace)
+	}
+
+	// statements without USING clause are created as GSI
+	switch strings.ToLower(string(using)) {
+	case "", "default", string(datastore.GSI):
+	default:
+		return nil, nil, c.WrapError(client.ErrInvalidIndexDDL, "using", using)
+	}
+
+	var plan map[string]interface{}
+	if with != nil {
+		var ok bool
+		if plan, ok = with.Actual().(map[string]interface{}); !ok {
+			return nil, nil, c.WrapError(client.ErrInvalidIndexDDL, "WITH clause is not an object")
+		}
+	}
+
+	return defn, plan, nil
+}
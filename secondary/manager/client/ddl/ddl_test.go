@@ -0,0 +1,58 @@
+package ddl
+
+import (
+	c "github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/manager/client"
+	"reflect"
+	"testing"
+)
+
+func TestParseIndexDDL(t *testing.T) {
+	defn, plan, err := ParseIndexDDL(
+		"CREATE INDEX by_city ON default(city, age) WHERE age > 21 " +
+			"USING GSI WITH {\"defer_build\": true}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defn.Name != "by_city" || defn.Bucket != "default" || defn.IsPrimary {
+		t.Fatalf("unexpected definition %v", defn)
+	}
+	if len(defn.SecExprs) != 2 || defn.WhereExpr == "" {
+		t.Fatalf("unexpected expressions %v where %v", defn.SecExprs, defn.WhereExpr)
+	}
+	if !reflect.DeepEqual(plan, map[string]interface{}{"defer_build": true}) {
+		t.Fatalf("unexpected plan %v", plan)
+	}
+
+	defn, plan, err = ParseIndexDDL("CREATE PRIMARY INDEX ON default:beer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !defn.IsPrimary || defn.Bucket != "beer" || plan != nil {
+		t.Fatalf("unexpected definition %v, plan %v", defn, plan)
+	}
+
+	for _, ddl := range []string{
+		"CREATE INDEX by_city ON default(city",
+		"SELECT * FROM default",
+		"CREATE INDEX by_city ON default(city) USING VIEW",
+		"CREATE INDEX by_city ON system:default(city)",
+	} {
+		if _, _, err := ParseIndexDDL(ddl); !c.IsError(err, client.ErrInvalidIndexDDL) {
+			t.Errorf("%q: expected %v, got %v", ddl, client.ErrInvalidIndexDDL, err)
+		}
+	}
+}
+
+func TestCreateIndexReadOnly(t *testing.T) {
+	provider, err := client.NewReadOnlyMetadataProvider("ddltest")
+	if err != nil {
+		t.Skipf("no provider without network address: %v", err)
+	}
+	defer provider.Close()
+
+	_, err = CreateIndex(provider, "CREATE INDEX by_city ON default(city)")
+	if _, ok := err.(*client.ReadOnlyError); !ok {
+		t.Fatalf("expected ReadOnlyError, got %v", err)
+	}
+}
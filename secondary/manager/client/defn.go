@@ -20,12 +20,46 @@ import (
 ////////////////////////////////////////////////////////////////////////
 
 const (
-	OPCODE_CREATE_INDEX      common.OpCode = common.OPCODE_CUSTOM + 1
-	OPCODE_DROP_INDEX                      = OPCODE_CREATE_INDEX + 1
-	OPCODE_BUILD_INDEX                     = OPCODE_DROP_INDEX + 1
-	OPCODE_UPDATE_INDEX_INST               = OPCODE_BUILD_INDEX + 1
+	OPCODE_CREATE_INDEX           common.OpCode = common.OPCODE_CUSTOM + 1
+	OPCODE_DROP_INDEX                           = OPCODE_CREATE_INDEX + 1
+	OPCODE_BUILD_INDEX                          = OPCODE_DROP_INDEX + 1
+	OPCODE_UPDATE_INDEX_INST                    = OPCODE_BUILD_INDEX + 1
+	OPCODE_RESYNC_KEY                           = OPCODE_UPDATE_INDEX_INST + 1
+	OPCODE_DROP_INDEXES_BY_BUCKET               = OPCODE_RESYNC_KEY + 1
+	OPCODE_RESERVE_INDEX_NAME                   = OPCODE_DROP_INDEXES_BY_BUCKET + 1
+	OPCODE_RELEASE_INDEX_NAME                   = OPCODE_RESERVE_INDEX_NAME + 1
 )
 
+// ErrDuplicateIndexName is returned when creating an index whose name is
+// already taken on the bucket, by an index or by a reservation made for
+// an index being created, on any indexer of the cluster.  Indexers respond
+// with this error as is, so that it can be told apart from other failures.
+var ErrDuplicateIndexName = c.NewError(300, "Index name already exists on the bucket", false)
+
+// ErrIndexNotFound is returned for requests on an index that is not
+// defined, annotated with the index.
+var ErrIndexNotFound = c.NewError(302, "Index does not exist", false)
+
+// ErrIndexNotReady is returned when building an index that is not in
+// READY state, annotated with the index.
+var ErrIndexNotReady = c.NewError(303, "Index is not in READY state", false)
+
+// ErrCapacityNotAvailable is returned by NodeInfo for nodes whose
+// capacity stats cannot be fetched.
+var ErrCapacityNotAvailable = c.NewError(304, "Capacity is not available", false)
+
+// ErrIndexWaitTimeout is returned by WaitForIndexOnline when indexes are
+// not online before the timeout, annotated with the pending indexes.
+var ErrIndexWaitTimeout = c.NewError(305, "Timed out waiting for index to be online", true)
+
+// ErrIndexBuildFailed is returned by WaitForIndexOnline for an index whose
+// instance is in error, annotated with the index and the error.
+var ErrIndexBuildFailed = c.NewError(306, "Index build failed", false)
+
+// ErrInvalidIndexDDL is returned by ddl.ParseIndexDDL for a statement that is
+// not a valid CREATE INDEX statement, annotated with the reason.
+var ErrInvalidIndexDDL = c.NewError(307, "Invalid index DDL statement", false)
+
 /////////////////////////////////////////////////////////////////////////
 // Topology Definition
 ////////////////////////////////////////////////////////////////////////
@@ -48,11 +82,12 @@ type IndexDefnDistribution struct {
 }
 
 type IndexInstDistribution struct {
-	InstId     uint64                  `json:"instId,omitempty"`
-	State      uint32                  `json:"state,omitempty"`
-	StreamId   uint32                  `json:"streamId,omitempty"`
-	Error      string                  `json:"error,omitempty"`
-	Partitions []IndexPartDistribution `json:"partitions,omitempty"`
+	InstId       uint64                  `json:"instId,omitempty"`
+	State        uint32                  `json:"state,omitempty"`
+	StreamId     uint32                  `json:"streamId,omitempty"`
+	Error        string                  `json:"error,omitempty"`
+	ErrorHistory []string                `json:"errorHistory,omitempty"`
+	Partitions   []IndexPartDistribution `json:"partitions,omitempty"`
 }
 
 type IndexPartDistribution struct {
@@ -84,12 +119,29 @@ type IndexIdList struct {
 	DefnIds []uint64 `json:"defnIds,omitempty"`
 }
 
+/////////////////////////////////////////////////////////////////////////
+// Index Name Reservation
+////////////////////////////////////////////////////////////////////////
+
+// IndexNameReservation claims the name of an index on a bucket for the
+// index definition being created.
+type IndexNameReservation struct {
+	Bucket string `json:"bucket,omitempty"`
+	Name   string `json:"name,omitempty"`
+	DefnId uint64 `json:"defnId,omitempty"`
+}
+
 /////////////////////////////////////////////////////////////////////////
 // private method : unmarshalling
 ////////////////////////////////////////////////////////////////////////
 
 func unmarshallIndexTopology(data []byte) (*IndexTopology, error) {
 
+	data, err := c.VerifyChecksum(data)
+	if err != nil {
+		return nil, err
+	}
+
 	topology := new(IndexTopology)
 	if err := json.Unmarshal(data, topology); err != nil {
 		return nil, err
@@ -105,7 +157,7 @@ func marshallIndexTopology(topology *IndexTopology) ([]byte, error) {
 		return nil, err
 	}
 
-	return buf, nil
+	return c.AddChecksum(buf), nil
 }
 
 func BuildIndexIdList(ids []c.IndexDefnId) *IndexIdList {
@@ -136,3 +188,29 @@ func MarshallIndexIdList(list *IndexIdList) ([]byte, error) {
 
 	return buf, nil
 }
+
+func UnmarshallIndexNameReservation(data []byte) (*IndexNameReservation, error) {
+
+	reservation := new(IndexNameReservation)
+	if err := json.Unmarshal(data, reservation); err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+func MarshallIndexNameReservation(reservation *IndexNameReservation) ([]byte, error) {
+
+	buf, err := json.Marshal(&reservation)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// IsDuplicateIndexName returns whether err, possibly received from an
+// indexer, is ErrDuplicateIndexName.
+func IsDuplicateIndexName(err error) bool {
+	return c.IsError(err, ErrDuplicateIndexName)
+}
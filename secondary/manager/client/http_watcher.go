@@ -0,0 +1,201 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/couchbase/gometa/common"
+	c "github.com/couchbase/indexing/secondary/common"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+// Path of the indexer's REST endpoint serving a MetadataSnapshot.
+const METADATA_SNAPSHOT_PATH = "/getMetadataSnapshot"
+
+// Default time to wait for the watcher protocol to sync with an indexer
+// before falling back to polling its metadata over http.
+const DEFAULT_WATCH_TIMEOUT = 30 * time.Second
+
+// Default interval between polls of an indexer's metadata over http.
+const DEFAULT_HTTP_POLL_INTERVAL = 5 * time.Second
+
+// Timeout for fetching a metadata snapshot from an indexer node.
+const SNAPSHOT_REQUEST_TIMEOUT = 10 * time.Second
+
+// MetadataSnapshot is the index definitions and topologies of an indexer,
+// served over http to providers that cannot reach its watcher port.
+type MetadataSnapshot struct {
+	Entries []MetadataEntry `json:"entries,omitempty"`
+}
+
+// MetadataEntry is a definition or topology as persisted in the indexer's
+// metadata repository.
+type MetadataEntry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+///////////////////////////////////////////////////////
+// private function : Watcher
+///////////////////////////////////////////////////////
+
+// waitForSync waits for the watcher protocol to sync with the indexer.
+// If it does not sync within the watch timeout, metadata is polled over
+// http until it does, so that callers still see the indexer's indexes.
+func (w *watcher) waitForSync(readych <-chan bool, timeout time.Duration) {
+
+	if timeout <= 0 {
+		<-readych
+		return
+	}
+
+	select {
+	case <-readych:
+		return
+	case <-time.After(timeout):
+	}
+
+	c.Warnf("watcher.waitForSync(): indexer %v not synced after %v, polling metadata over http", w.leaderAddr, timeout)
+	pollch := make(chan bool)
+	w.mutex.Lock()
+	w.pollch = pollch
+	w.mutex.Unlock()
+	atomic.StoreInt32(&w.polling, 1)
+
+	go w.pollUntilSync(readych, pollch)
+}
+
+// pollUntilSync polls the indexer's metadata until the watcher protocol
+// syncs or until the watcher is closed.
+func (w *watcher) pollUntilSync(readych <-chan bool, pollch chan bool) {
+
+	defer atomic.StoreInt32(&w.polling, 0)
+
+	var last map[string][]byte
+	for {
+		interval := w.provider.httpPollInterval()
+		if httpAddr, ok := w.provider.indexerHttpAddr(w.leaderAddr); ok {
+			current, err := w.pollMetadata(httpAddr, last)
+			if err != nil {
+				w.provider.stats.httpPollFailures.Add(1)
+				c.Errorf("watcher.pollUntilSync(): fail to poll metadata of indexer %v. Error = %v", w.leaderAddr, err)
+			} else {
+				last = current
+			}
+			w.provider.stats.httpPolls.Add(1)
+		}
+
+		select {
+		case <-readych:
+			c.Infof("watcher.pollUntilSync(): indexer %v synced, stop polling metadata", w.leaderAddr)
+			return
+		case <-pollch:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// pollMetadata fetches a snapshot of the indexer's metadata and applies
+// what changed since snapshot `last`.  Returns the entries applied, keys
+// whose content is rejected are left out so that they are applied again
+// on the next poll.
+func (w *watcher) pollMetadata(httpAddr string, last map[string][]byte) (map[string][]byte, error) {
+
+	client := &http.Client{Timeout: SNAPSHOT_REQUEST_TIMEOUT}
+	resp, err := client.Get("http://" + httpAddr + METADATA_SNAPSHOT_PATH)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Fail to get metadata of indexer %s: %s", w.leaderAddr, resp.Status))
+	}
+
+	snapshot := new(MetadataSnapshot)
+	if err := json.NewDecoder(resp.Body).Decode(snapshot); err != nil {
+		return nil, err
+	}
+	return w.applySnapshot(snapshot, last), nil
+}
+
+// applySnapshot diffs snapshot against snapshot `last` into the repo.
+func (w *watcher) applySnapshot(snapshot *MetadataSnapshot, last map[string][]byte) map[string][]byte {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	seen := make(map[string]bool)
+	current := make(map[string][]byte)
+	for _, entry := range snapshot.Entries {
+		if !isIndexDefnKey(entry.Key) && !isIndexTopologyKey(entry.Key) {
+			continue
+		}
+		seen[entry.Key] = true
+		if prev, ok := last[entry.Key]; ok && bytes.Equal(prev, entry.Value) {
+			current[entry.Key] = entry.Value
+			continue
+		}
+		if err := w.processChange(uint32(common.OPCODE_SET), entry.Key, entry.Value); err == nil {
+			current[entry.Key] = entry.Value
+		}
+	}
+
+	// definitions known to the watcher but not in the snapshot are dropped.
+	for defnId, _ := range w.indices {
+		key := indexDefnKey(defnId)
+		if !seen[key] {
+			w.processChange(uint32(common.OPCODE_DELETE), key, nil)
+		}
+	}
+
+	// so are topologies of buckets no longer hosted by the indexer.
+	for _, bucket := range w.provider.repo.topologyBuckets(w.leaderAddr) {
+		key := indexTopologyKey(bucket)
+		if !seen[key] {
+			w.processChange(uint32(common.OPCODE_DELETE), key, nil)
+		}
+	}
+
+	return current
+}
+
+func (w *watcher) isPolling() bool {
+	return atomic.LoadInt32(&w.polling) == 1
+}
+
+func (w *watcher) stopPolling() {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.pollch != nil {
+		close(w.pollch)
+		w.pollch = nil
+	}
+}
+
+func indexDefnKey(id c.IndexDefnId) string {
+	return fmt.Sprintf("IndexDefinitionId/%d", id)
+}
+
+func indexTopologyKey(bucket string) string {
+	return fmt.Sprintf("IndexTopology/%s", bucket)
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"encoding/json"
+	c "github.com/couchbase/indexing/secondary/common"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPollMetadata(t *testing.T) {
+	var snapshot MetadataSnapshot
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != METADATA_SNAPSHOT_PATH {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(&snapshot)
+	}))
+	defer server.Close()
+	httpAddr := strings.TrimPrefix(server.URL, "http://")
+
+	o := &MetadataProvider{
+		readOnly:  true,
+		httpAddrs: map[string]string{"indexer:9100": httpAddr},
+		repo:      newMetadataRepo(),
+		stats:     newProviderStats(),
+	}
+	w := newWatcher(o, "indexer:9100")
+	w.polling = 1
+
+	defn := func(id c.IndexDefnId, name string) MetadataEntry {
+		data, err := c.MarshallIndexDefn(&c.IndexDefn{DefnId: id, Name: name, Bucket: "default"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return MetadataEntry{Key: indexDefnKey(id), Value: data}
+	}
+	indexName := func(id c.IndexDefnId) string {
+		o.repo.mutex.Lock()
+		defer o.repo.mutex.Unlock()
+		if meta, ok := o.repo.indices[id]; ok {
+			return meta.Definition.Name
+		}
+		return ""
+	}
+
+	snapshot.Entries = []MetadataEntry{
+		defn(1, "by_city"), defn(2, "by_zip"),
+		{Key: "StabilityTimestamp", Value: []byte(`{}`)},
+	}
+	last, err := w.pollMetadata(httpAddr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 2 || indexName(1) != "by_city" || indexName(2) != "by_zip" {
+		t.Fatalf("unexpected metadata %v", last)
+	}
+
+	// corrupted content is left out, to be applied on the next poll.
+	corrupted := defn(3, "by_age")
+	corrupted.Value = corrupted.Value[:len(corrupted.Value)-1]
+	snapshot.Entries = []MetadataEntry{defn(1, "by_town"), corrupted}
+	if last, err = w.pollMetadata(httpAddr, last); err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 1 || indexName(1) != "by_town" || indexName(2) != "" || indexName(3) != "" {
+		t.Fatalf("unexpected metadata %v", last)
+	}
+	if w.numIndices() != 1 {
+		t.Fatalf("expected 1 index, got %v", w.numIndices())
+	}
+
+	snapshot.Entries = []MetadataEntry{defn(1, "by_town"), defn(3, "by_age")}
+	if last, err = w.pollMetadata(httpAddr, last); err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 2 || indexName(3) != "by_age" {
+		t.Fatalf("unexpected metadata %v", last)
+	}
+
+	// topologies of buckets no longer hosted by the indexer are dropped.
+	data, err := marshallIndexTopology(&IndexTopology{Version: 1, Bucket: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	topology := MetadataEntry{Key: indexTopologyKey("default"), Value: data}
+	snapshot.Entries = []MetadataEntry{defn(1, "by_town"), topology}
+	if last, err = w.pollMetadata(httpAddr, last); err != nil {
+		t.Fatal(err)
+	}
+	if version := o.repo.topologyVersion("indexer:9100", "default"); version != 1 {
+		t.Fatalf("expected topology version 1, got %v", version)
+	}
+	snapshot.Entries = []MetadataEntry{defn(1, "by_town")}
+	if last, err = w.pollMetadata(httpAddr, last); err != nil {
+		t.Fatal(err)
+	}
+	if buckets := o.repo.topologyBuckets("indexer:9100"); len(buckets) != 0 || len(last) != 1 {
+		t.Fatalf("unexpected topologies %v", buckets)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	c "github.com/couchbase/indexing/secondary/common"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+// Default interval between progress callbacks of WaitForIndexOnline().
+const DEFAULT_PROGRESS_INTERVAL = 5 * time.Second
+
+// Timeout for fetching index stats from an indexer node.
+const STATS_REQUEST_TIMEOUT = 10 * time.Second
+
+// IndexProgress is the state of an index waited on by WaitForIndexOnline().
+type IndexProgress struct {
+	DefnId c.IndexDefnId
+	Name   string
+	Bucket string
+	State  c.IndexState
+	// Percentage of documents indexed by the initial build, -1 if it
+	// cannot be fetched from the indexer.
+	Progress float64
+}
+
+// IndexProgressCallback is called by WaitForIndexOnline() with the
+// progress of each index waited on.
+type IndexProgressCallback func(progress []*IndexProgress)
+
+///////////////////////////////////////////////////////
+// Public function : MetadataProvider
+///////////////////////////////////////////////////////
+
+// WaitForIndexOnline blocks until indexes defnIds are ACTIVE.  It wakes up
+// on every metadata change received from indexers and, if callback is not
+// nil, calls it with the build progress of the indexes every progress
+// interval, refer SetProgressInterval(), and once more when they are all
+// online.  A zero timeout waits forever.
+//
+// - return ErrIndexNotFound if an index is not defined or is dropped.
+// - return ErrIndexBuildFailed if an index instance is in error.
+// - return ErrIndexWaitTimeout if indexes are not online within timeout.
+func (o *MetadataProvider) WaitForIndexOnline(defnIds []c.IndexDefnId,
+	timeout time.Duration, callback IndexProgressCallback) error {
+
+	o.mutex.Lock()
+	interval := o.progressInterval
+	o.mutex.Unlock()
+	if interval <= 0 {
+		interval = DEFAULT_PROGRESS_INTERVAL
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var timeoutch <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutch = timer.C
+	}
+
+	report := false
+	for {
+		// channel is fetched before reading the repo, so that a change
+		// made in between is not missed.
+		changech := o.repo.changed()
+
+		progress, online, err := o.indexProgress(defnIds)
+		if err != nil {
+			return err
+		}
+		if callback != nil && (report || online) {
+			o.updateBuildProgress(defnIds, progress)
+			callback(progress)
+		}
+		if online {
+			return nil
+		}
+
+		report = false
+		select {
+		case <-changech:
+		case <-ticker.C:
+			report = true
+		case <-timeoutch:
+			pending := make([]string, 0, len(progress))
+			for _, p := range progress {
+				if p.State != c.INDEX_STATE_ACTIVE {
+					pending = append(pending, fmt.Sprintf("%s:%s", p.Bucket, p.Name))
+				}
+			}
+			return c.WrapError(ErrIndexWaitTimeout, "timeout", timeout, "pending", pending)
+		}
+	}
+}
+
+///////////////////////////////////////////////////////
+// private function : MetadataProvider
+///////////////////////////////////////////////////////
+
+// indexProgress returns the state of indexes defnIds and whether all of
+// them are ACTIVE.  Progress is 100 for ACTIVE indexes and 0 for others,
+// refer updateBuildProgress().
+func (o *MetadataProvider) indexProgress(defnIds []c.IndexDefnId) ([]*IndexProgress, bool, error) {
+
+	o.repo.mutex.Lock()
+	defer o.repo.mutex.Unlock()
+
+	online := true
+	progress := make([]*IndexProgress, 0, len(defnIds))
+	for _, defnId := range defnIds {
+		meta, ok := o.repo.indices[defnId]
+		if !ok || meta.Definition == nil {
+			return nil, false, c.WrapError(ErrIndexNotFound, "defnId", defnId)
+		}
+
+		p := &IndexProgress{
+			DefnId: defnId,
+			Name:   meta.Definition.Name,
+			Bucket: meta.Definition.Bucket,
+			State:  c.INDEX_STATE_CREATED,
+		}
+		if len(meta.Instances) > 0 {
+			inst := meta.Instances[0]
+			if inst.State == c.INDEX_STATE_DELETED {
+				return nil, false, c.WrapError(ErrIndexNotFound, "index", p.Name)
+			} else if inst.State == c.INDEX_STATE_ERROR || inst.Error != "" {
+				return nil, false, c.WrapError(ErrIndexBuildFailed, "index", p.Name, inst.Error)
+			}
+			p.State = inst.State
+		}
+		if p.State == c.INDEX_STATE_ACTIVE {
+			p.Progress = 100
+		} else {
+			online = false
+		}
+		progress = append(progress, p)
+	}
+	return progress, online, nil
+}
+
+// updateBuildProgress sets the progress of indexes under initial build,
+// or catching up, from the stats of the indexers hosting them.
+func (o *MetadataProvider) updateBuildProgress(defnIds []c.IndexDefnId, progress []*IndexProgress) {
+
+	building := false
+	for _, p := range progress {
+		if p.State == c.INDEX_STATE_INITIAL || p.State == c.INDEX_STATE_CATCHUP {
+			building = true
+		}
+	}
+	if !building {
+		return
+	}
+
+	ids := make(map[c.IndexDefnId]bool)
+	for _, defnId := range defnIds {
+		ids[defnId] = true
+	}
+
+	o.mutex.Lock()
+	httpAddrs := make([]string, 0, len(o.watchers))
+	for addr, watcher := range o.watchers {
+		if httpAddr, ok := o.httpAddrs[addr]; ok && watcher.hostsAnyDefn(ids) {
+			httpAddrs = append(httpAddrs, httpAddr)
+		}
+	}
+	o.mutex.Unlock()
+
+	stats := make(map[string]string)
+	for _, httpAddr := range httpAddrs {
+		indexerStats, err := getIndexerStats(httpAddr)
+		if err != nil {
+			c.Warnf("MetadataProvider.updateBuildProgress(): Fail to get stats of indexer %s. Reason = %v", httpAddr, err)
+			continue
+		}
+		for k, v := range indexerStats {
+			stats[k] = v
+		}
+	}
+
+	for _, p := range progress {
+		if p.State == c.INDEX_STATE_INITIAL || p.State == c.INDEX_STATE_CATCHUP {
+			p.Progress = buildProgress(stats, p.Bucket, p.Name)
+		}
+	}
+}
+
+// getIndexerStats fetches the stats served by an indexer at httpAddr.
+func getIndexerStats(httpAddr string) (map[string]string, error) {
+
+	client := &http.Client{Timeout: STATS_REQUEST_TIMEOUT}
+	resp, err := client.Get("http://" + httpAddr + "/stats")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Fail to get stats of indexer %s: %s", httpAddr, resp.Status))
+	}
+
+	stats := make(map[string]string)
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// buildProgress is the percentage of documents indexed, out of those
+// indexed, queued and pending, for index bucket:name.  Returns -1 if the
+// index has no stats.
+func buildProgress(stats map[string]string, bucket, name string) float64 {
+
+	var total, indexed uint64
+	for _, stat := range []string{"num_docs_indexed", "num_docs_queued", "num_docs_pending"} {
+		v, ok := stats[fmt.Sprintf("%s:%s:%s", bucket, name, stat)]
+		if !ok {
+			return -1
+		}
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return -1
+		}
+		if stat == "num_docs_indexed" {
+			indexed = n
+		}
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(indexed) * 100 / float64(total)
+}
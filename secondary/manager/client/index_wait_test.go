@@ -0,0 +1,87 @@
+package client
+
+import (
+	"encoding/json"
+	c "github.com/couchbase/indexing/secondary/common"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setIndexState(o *MetadataProvider, id c.IndexDefnId, state c.IndexState, err string) {
+	o.repo.updateTopology("indexer:9100", &IndexTopology{
+		Definitions: []IndexDefnDistribution{{
+			DefnId: uint64(id),
+			Instances: []IndexInstDistribution{
+				{InstId: uint64(id), State: uint32(state), Error: err},
+			},
+		}},
+	})
+}
+
+func TestWaitForIndexOnline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"default:by_city:num_docs_indexed": "30",
+			"default:by_city:num_docs_queued":  "10",
+			"default:by_city:num_docs_pending": "60",
+		})
+	}))
+	defer server.Close()
+
+	o := &MetadataProvider{
+		watchers:         make(map[string]*watcher),
+		httpAddrs:        map[string]string{"indexer:9100": strings.TrimPrefix(server.URL, "http://")},
+		repo:             newMetadataRepo(),
+		progressInterval: 10 * time.Millisecond,
+	}
+	w := newWatcher(o, "indexer:9100")
+	w.addDefn(1)
+	o.watchers["indexer:9100"] = w
+	o.repo.addDefn(&c.IndexDefn{DefnId: 1, Name: "by_city", Bucket: "default"})
+	o.repo.addDefn(&c.IndexDefn{DefnId: 2, Name: "by_zip", Bucket: "default"})
+	setIndexState(o, 1, c.INDEX_STATE_INITIAL, "")
+	setIndexState(o, 2, c.INDEX_STATE_ACTIVE, "")
+
+	err := o.WaitForIndexOnline([]c.IndexDefnId{1, 2}, 50*time.Millisecond, nil)
+	if !c.IsError(err, ErrIndexWaitTimeout) {
+		t.Fatalf("expected %v, got %v", ErrIndexWaitTimeout, err)
+	}
+
+	progressch := make(chan []*IndexProgress, 100)
+	errch := make(chan error, 1)
+	go func() {
+		errch <- o.WaitForIndexOnline([]c.IndexDefnId{1, 2}, 0, func(progress []*IndexProgress) {
+			progressch <- progress
+		})
+	}()
+
+	progress := <-progressch
+	if progress[0].State != c.INDEX_STATE_INITIAL || progress[0].Progress != 30 || progress[1].Progress != 100 {
+		t.Fatalf("unexpected progress %v, %v", progress[0], progress[1])
+	}
+	setIndexState(o, 1, c.INDEX_STATE_ACTIVE, "")
+	if err := <-errch; err != nil {
+		t.Fatal(err)
+	}
+	for len(progressch) > 0 {
+		progress = <-progressch
+	}
+	if progress[0].State != c.INDEX_STATE_ACTIVE || progress[0].Progress != 100 {
+		t.Fatalf("expected final progress of online index, got %v", progress[0])
+	}
+
+	setIndexState(o, 2, c.INDEX_STATE_INITIAL, "Build failed")
+	err = o.WaitForIndexOnline([]c.IndexDefnId{1, 2}, 0, nil)
+	if !c.IsError(err, ErrIndexBuildFailed) {
+		t.Fatalf("expected %v, got %v", ErrIndexBuildFailed, err)
+	}
+
+	o.repo.removeDefn(1)
+	err = o.WaitForIndexOnline([]c.IndexDefnId{1}, 0, nil)
+	if !c.IsError(err, ErrIndexNotFound) {
+		t.Fatalf("expected %v, got %v", ErrIndexNotFound, err)
+	}
+}
@@ -10,6 +10,7 @@
 package client
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/couchbase/gometa/common"
@@ -17,53 +18,118 @@ import (
 	"github.com/couchbase/gometa/protocol"
 	c "github.com/couchbase/indexing/secondary/common"
 	"net"
+	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 ///////////////////////////////////////////////////////
 // Type Definition
 ///////////////////////////////////////////////////////
 
+// Default number of requests a watcher allows in flight against
+// a single indexer node.
+const DEFAULT_REQUEST_WINDOW = 8
+
+// Timeout for fetching capacity stats from an indexer node.
+const CAPACITY_REQUEST_TIMEOUT = 5 * time.Second
+
 type MetadataProvider struct {
 	providerId string
+	reqWindow  int
+	readOnly   bool
 	watchers   map[string]*watcher
+	httpAddrs  map[string]string // indexer adminport -> http address
+	placement  PlacementPolicy
 	repo       *metadataRepo
-	mutex      sync.Mutex
+	stats      *providerStats
+	slowDDL    time.Duration
+	// fallback to polling metadata over http, refer waitForSync()
+	watchTimeout time.Duration
+	pollInterval time.Duration
+	// interval between progress callbacks, refer WaitForIndexOnline()
+	progressInterval time.Duration
+	// fail CreateIndex if any indexer cannot reserve the index name,
+	// refer SetStrictNameReservation()
+	strictNames bool
+	mutex       sync.Mutex
 }
 
+// metadataRepo hands out IndexMetadata that is never modified once added
+// to the repo, changes are made on a copy that replaces it.
 type metadataRepo struct {
 	definitions map[c.IndexDefnId]*c.IndexDefn
-	instances   map[c.IndexDefnId]*IndexInstDistribution
+	topologies  map[topologyKey]*topologySnapshot
 	indices     map[c.IndexDefnId]*IndexMetadata
+	changech    chan bool // closed and renewed on every change
 	mutex       sync.Mutex
 }
 
+// topologyKey identifies the topology of a bucket on an indexer, every
+// indexer keeps the topology of the indexes it hosts.
+type topologyKey struct {
+	indexer string // index admin address, as watched
+	bucket  string
+}
+
+// topologySnapshot is a version of the topology of a bucket on an
+// indexer, swapped in whole and not modified thereafter.
+type topologySnapshot struct {
+	version   uint64
+	instances map[c.IndexDefnId]*IndexInstDistribution
+}
+
 type watcher struct {
 	provider   *MetadataProvider
 	leaderAddr string
 	factory    protocol.MsgFactory
 	pendings   map[common.Txnid]protocol.LogEntryMsg
+	proposedAt map[common.Txnid]time.Time
 	killch     chan bool
 	mutex      sync.Mutex
 	indices    map[c.IndexDefnId]interface{}
 
 	incomingReqs chan *protocol.RequestHandle
+	window       *requestWindow                     // bounds outstanding requests
 	pendingReqs  map[uint64]*protocol.RequestHandle // key : request id
 	loggedReqs   map[common.Txnid]*protocol.RequestHandle
+
+	polling int32     // 1 while metadata is polled over http
+	pollch  chan bool // closed to stop polling
+}
+
+// ReadOnlyError is returned by DDL methods of a read-only
+// MetadataProvider.
+type ReadOnlyError struct {
+	Op string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("MetadataProvider is read-only, %s is not allowed", e.Op)
 }
 
 type IndexMetadata struct {
 	Definition *c.IndexDefn
 	Instances  []*InstanceDefn
+	// TopologyVersion is the version of the bucket's topology, on the
+	// indexer hosting the index, that Instances are taken from.
+	TopologyVersion uint64
 }
 
 type InstanceDefn struct {
 	InstId c.IndexInstId
 	State  c.IndexState
 	Error  string
-	Endpts []c.Endpoint
+	// ErrorHistory has the most recent errors of the instance, oldest
+	// first, like the errors of each failed build attempt.
+	ErrorHistory []string
+	Endpts       []c.Endpoint
+	// NeedsRebuild is set when the bucket was flushed or recreated after
+	// the index was defined, index has to be dropped and created again.
+	NeedsRebuild bool
 }
 
 ///////////////////////////////////////////////////////
@@ -74,7 +140,15 @@ func NewMetadataProvider(providerId string) (s *MetadataProvider, err error) {
 
 	s = new(MetadataProvider)
 	s.watchers = make(map[string]*watcher)
+	s.httpAddrs = make(map[string]string)
+	s.placement = &leastLoadedPolicy{}
 	s.repo = newMetadataRepo()
+	s.stats = newProviderStats()
+	s.reqWindow = DEFAULT_REQUEST_WINDOW
+	s.slowDDL = DEFAULT_SLOW_DDL_THRESHOLD
+	s.watchTimeout = DEFAULT_WATCH_TIMEOUT
+	s.pollInterval = DEFAULT_HTTP_POLL_INTERVAL
+	s.progressInterval = DEFAULT_PROGRESS_INTERVAL
 
 	s.providerId, err = s.getWatcherAddr(providerId)
 	if err != nil {
@@ -85,6 +159,100 @@ func NewMetadataProvider(providerId string) (s *MetadataProvider, err error) {
 	return s, nil
 }
 
+// NewReadOnlyMetadataProvider creates a MetadataProvider that only watches
+// and serves metadata.  DDL methods return ReadOnlyError, and its watchers
+// do not keep any state for outstanding requests.
+func NewReadOnlyMetadataProvider(providerId string) (s *MetadataProvider, err error) {
+
+	s, err = NewMetadataProvider(providerId)
+	if err != nil {
+		return nil, err
+	}
+	s.readOnly = true
+
+	return s, nil
+}
+
+// IsReadOnly returns true if DDL is disabled for this MetadataProvider.
+func (o *MetadataProvider) IsReadOnly() bool {
+	return o.readOnly
+}
+
+// SetRequestWindow sets the maximum number of requests that can be in
+// flight to a single indexer node.  It applies to watchers already
+// started as well, requests in flight beyond a reduced window are let
+// to complete.
+func (o *MetadataProvider) SetRequestWindow(size int) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if size <= 0 {
+		size = 1
+	}
+	o.reqWindow = size
+	for _, watcher := range o.watchers {
+		if watcher.window != nil {
+			watcher.window.resize(size)
+		}
+	}
+}
+
+// SetSlowDDLThreshold sets the duration beyond which a DDL request is
+// logged as slow.
+func (o *MetadataProvider) SetSlowDDLThreshold(threshold time.Duration) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.slowDDL = threshold
+}
+
+// SetHttpFallback sets how long a watcher waits for an indexer to sync
+// before polling its metadata over http, and the interval between polls.
+// Polling needs the indexer's http address, refer SetIndexerHttpAddr().
+// A zero timeout disables the fallback.  It applies to watchers started
+// after the call.
+func (o *MetadataProvider) SetHttpFallback(timeout, interval time.Duration) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if interval <= 0 {
+		interval = DEFAULT_HTTP_POLL_INTERVAL
+	}
+	o.watchTimeout = timeout
+	o.pollInterval = interval
+}
+
+// SetProgressInterval sets the interval between progress callbacks made
+// by WaitForIndexOnline().
+func (o *MetadataProvider) SetProgressInterval(interval time.Duration) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if interval <= 0 {
+		interval = DEFAULT_PROGRESS_INTERVAL
+	}
+	o.progressInterval = interval
+}
+
+// SetStrictNameReservation sets whether creating an index fails when the
+// index name cannot be reserved with an indexer, say because it is not
+// reachable.  By default such indexers are skipped, uniqueness of the name
+// is then not guaranteed against indexes created through other providers
+// on them.  A name already taken on any indexer fails either way.
+func (o *MetadataProvider) SetStrictNameReservation(strict bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.strictNames = strict
+}
+
+// GetStatistics returns the DDL requests made by this MetadataProvider,
+// by type and by failure class, their latencies, and the latency and lag
+// of applying metadata changes received from indexers.
+func (o *MetadataProvider) GetStatistics() c.Statistics {
+	return o.stats.statistics()
+}
+
 func (o *MetadataProvider) WatchMetadata(indexAdminPort string) {
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
@@ -117,21 +285,53 @@ func (o *MetadataProvider) CreateIndexWithPlan(
 	name, bucket, using, exprType, partnExpr, whereExpr string,
 	secExprs []string, isPrimary bool, plan map[string]interface{}) (c.IndexDefnId, error) {
 
+	if o.readOnly {
+		return c.IndexDefnId(0), &ReadOnlyError{Op: "CreateIndex"}
+	}
+
 	if o.FindIndexByName(name, bucket) != nil {
 		return c.IndexDefnId(0), errors.New(fmt.Sprintf("Index %s already exist.", name))
 	}
 
-	ns, ok := plan["nodes"].([]interface{})
-	if !ok || len(ns) != 1 {
-		return c.IndexDefnId(0), errors.New("Create Index is allowed for one and only one node")
+	var nodes []string
+	if _, ok := plan["nodes"]; ok {
+		ns, ok := plan["nodes"].([]interface{})
+		if !ok || len(ns) != 1 {
+			return c.IndexDefnId(0), errors.New("Create Index is allowed for one and only one node")
+		}
+		nodes = []string{ns[0].(string)}
+	} else {
+		// no explicit deployment, let placement policy pick the node
+		node, err := o.pickNode()
+		if err != nil {
+			return c.IndexDefnId(0), errors.New(fmt.Sprintf("Fails to create index.  %v", err))
+		}
+		nodes = []string{node}
 	}
-	nodes := []string{ns[0].(string)}
 
 	deferred, ok := plan["defer_build"].(bool)
 	if !ok {
 		deferred = false
 	}
 
+	// fields to be stored along with index entries (covering index)
+	var include []string
+	if fields, ok := plan["include"].([]interface{}); ok {
+		for _, field := range fields {
+			expr, ok := field.(string)
+			if !ok {
+				return c.IndexDefnId(0), errors.New("Fails to create index.  Include expects a list of fields")
+			}
+			include = append(include, expr)
+		}
+	}
+
+	// collation of string keys, binary by default
+	collation, _ := plan["collation"].(string)
+	if !c.IsValidCollation(collation) {
+		return c.IndexDefnId(0), errors.New(fmt.Sprintf("Fails to create index.  Unknown collation %v", collation))
+	}
+
 	watcher := o.findMatchingWatcher(nodes[0])
 	if watcher == nil {
 		return c.IndexDefnId(0),
@@ -143,6 +343,12 @@ func (o *MetadataProvider) CreateIndexWithPlan(
 		return c.IndexDefnId(0), errors.New(fmt.Sprintf("Fails to create index. Fail to create uuid for index definition."))
 	}
 
+	reserved, err := o.reserveIndexName(bucket, name, defnID)
+	if err != nil {
+		return c.IndexDefnId(0), err
+	}
+	defer o.releaseIndexName(reserved, bucket, name, defnID)
+
 	idxDefn := &c.IndexDefn{
 		DefnId:          defnID,
 		Name:            name,
@@ -155,7 +361,9 @@ func (o *MetadataProvider) CreateIndexWithPlan(
 		PartitionKey:    partnExpr,
 		WhereExpr:       whereExpr,
 		Deferred:        deferred,
-		Nodes:           nodes}
+		Nodes:           nodes,
+		Include:         include,
+		Collation:       collation}
 
 	content, err := c.MarshallIndexDefn(idxDefn)
 	if err != nil {
@@ -164,14 +372,70 @@ func (o *MetadataProvider) CreateIndexWithPlan(
 
 	key := fmt.Sprintf("%d", defnID)
 	err = watcher.makeRequest(OPCODE_CREATE_INDEX, key, content)
+	if IsDuplicateIndexName(err) {
+		err = ErrDuplicateIndexName
+	}
 
 	return defnID, err
 }
 
+// SetPlacementPolicy sets the policy used to select a node for indexes
+// created without an explicit node in their plan.  The default policy is
+// least_loaded.
+func (o *MetadataProvider) SetPlacementPolicy(policy PlacementPolicy) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.placement = policy
+}
+
+// SetIndexerHttpAddr sets the http address of the indexer watched at
+// indexAdminPort, which serves its capacity stats and its metadata when
+// the watcher cannot sync with it.
+func (o *MetadataProvider) SetIndexerHttpAddr(indexAdminPort, httpAddr string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.httpAddrs[indexAdminPort] = httpAddr
+}
+
+// GetIndexerCapacity fetches the capacity stats of the indexer watched at
+// indexAdminPort.
+func (o *MetadataProvider) GetIndexerCapacity(indexAdminPort string) (*c.IndexerCapacity, error) {
+	o.mutex.Lock()
+	httpAddr, ok := o.httpAddrs[indexAdminPort]
+	o.mutex.Unlock()
+
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown http address for indexer %s", indexAdminPort))
+	}
+
+	client := &http.Client{Timeout: CAPACITY_REQUEST_TIMEOUT}
+	resp, err := client.Get("http://" + httpAddr + "/stats/capacity")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Fail to get capacity of indexer %s: %s", indexAdminPort, resp.Status))
+	}
+
+	capacity := new(c.IndexerCapacity)
+	if err := json.NewDecoder(resp.Body).Decode(capacity); err != nil {
+		return nil, err
+	}
+	return capacity, nil
+}
+
 func (o *MetadataProvider) CreateIndex(
 	name, bucket, using, exprType, partnExpr, whereExpr, indexAdminPort string,
 	secExprs []string, isPrimary bool) (c.IndexDefnId, error) {
 
+	if o.readOnly {
+		return c.IndexDefnId(0), &ReadOnlyError{Op: "CreateIndex"}
+	}
+
 	if o.FindIndexByName(name, bucket) != nil {
 		return c.IndexDefnId(0), errors.New(fmt.Sprintf("Index %s already exist.", name))
 	}
@@ -198,6 +462,12 @@ func (o *MetadataProvider) CreateIndex(
 		return 0, err
 	}
 
+	reserved, err := o.reserveIndexName(bucket, name, defnID)
+	if err != nil {
+		return 0, err
+	}
+	defer o.releaseIndexName(reserved, bucket, name, defnID)
+
 	content, err := c.MarshallIndexDefn(idxDefn)
 	if err != nil {
 		return 0, err
@@ -205,14 +475,21 @@ func (o *MetadataProvider) CreateIndex(
 
 	key := fmt.Sprintf("%d", defnID)
 	err = watcher.makeRequest(OPCODE_CREATE_INDEX, key, content)
+	if IsDuplicateIndexName(err) {
+		err = ErrDuplicateIndexName
+	}
 
 	return defnID, err
 }
 
 func (o *MetadataProvider) DropIndex(defnID c.IndexDefnId, indexAdminPort string) error {
 
+	if o.readOnly {
+		return &ReadOnlyError{Op: "DropIndex"}
+	}
+
 	if o.FindIndex(defnID) == nil {
-		return errors.New("Index does not exist.")
+		return c.WrapError(ErrIndexNotFound, "defnId", defnID)
 	}
 
 	watcher, err := o.findWatcher(indexAdminPort)
@@ -224,15 +501,47 @@ func (o *MetadataProvider) DropIndex(defnID c.IndexDefnId, indexAdminPort string
 	return watcher.makeRequest(OPCODE_DROP_INDEX, key, []byte(""))
 }
 
+// DropIndexesByBucket drops all indexes defined on bucket with a single
+// request to every indexer hosting them, instead of dropping them one by
+// one, used when bucket is flushed or recreated.
+func (o *MetadataProvider) DropIndexesByBucket(bucket string) error {
+
+	if o.readOnly {
+		return &ReadOnlyError{Op: "DropIndexesByBucket"}
+	}
+
+	watchers := o.findWatchersByBucket(bucket)
+	if len(watchers) == 0 {
+		return errors.New(fmt.Sprintf("No index defined on bucket %s", bucket))
+	}
+
+	errMessages := make([]string, 0)
+	for _, watcher := range watchers {
+		err := watcher.makeRequest(OPCODE_DROP_INDEXES_BY_BUCKET, bucket, []byte(""))
+		if err != nil {
+			msg := fmt.Sprintf("drop error with %q indexer: %v", watcher.leaderAddr, err)
+			errMessages = append(errMessages, msg)
+		}
+	}
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+	return nil
+}
+
 func (o *MetadataProvider) BuildIndexes(adminport string, defnIDs []c.IndexDefnId) error {
 
+	if o.readOnly {
+		return &ReadOnlyError{Op: "BuildIndexes"}
+	}
+
 	for _, id := range defnIDs {
 		meta := o.FindIndex(id)
 		if meta == nil {
-			return errors.New(fmt.Sprintf("Index %s not found", meta.Definition.Name))
+			return c.WrapError(ErrIndexNotFound, "defnId", id)
 		}
-		if meta.Instances != nil && meta.Instances[0].State != c.INDEX_STATE_READY {
-			return errors.New(fmt.Sprintf("Index %s is not in READY state.", meta.Definition.Name))
+		if meta.Instances != nil && !meta.Instances[0].State.IsBuildable() {
+			return c.WrapError(ErrIndexNotReady, "index", meta.Definition.Name)
 		}
 	}
 
@@ -292,6 +601,14 @@ func (o *MetadataProvider) FindIndexByName(name string, bucket string) *IndexMet
 	return nil
 }
 
+// TopologyVersion returns the version of the topology of bucket on the
+// indexer at indexAdminPort, as last seen by the provider, 0 if the
+// provider has not seen it.  Versions increase with every change to the
+// topology, refer IndexMetadata.TopologyVersion.
+func (o *MetadataProvider) TopologyVersion(indexAdminPort string, bucket string) uint64 {
+	return o.repo.topologyVersion(indexAdminPort, bucket)
+}
+
 func (o *MetadataProvider) Close() {
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
@@ -319,12 +636,33 @@ func (o *MetadataProvider) startWatcher(addr string) *watcher {
 		s.killch,
 		readych)
 
-	// TODO: timeout
-	<-readych
+	s.waitForSync(readych, o.watchTimeout)
 
 	return s
 }
 
+func (o *MetadataProvider) httpPollInterval() time.Duration {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.pollInterval
+}
+
+func (o *MetadataProvider) indexerHttpAddr(indexAdminPort string) (string, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	httpAddr, ok := o.httpAddrs[indexAdminPort]
+	return httpAddr, ok
+}
+
+func (o *MetadataProvider) slowDDLThreshold() time.Duration {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.slowDDL
+}
+
 func (o *MetadataProvider) findWatcher(indexAdminPort string) (*watcher, error) {
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
@@ -337,6 +675,98 @@ func (o *MetadataProvider) findWatcher(indexAdminPort string) (*watcher, error)
 	return watcher, nil
 }
 
+// findWatchersByBucket returns watchers of indexers hosting an index
+// defined on bucket.
+func (o *MetadataProvider) findWatchersByBucket(bucket string) []*watcher {
+
+	defnIds := make(map[c.IndexDefnId]bool)
+	o.repo.mutex.Lock()
+	for defnId, meta := range o.repo.indices {
+		if meta.Definition != nil && meta.Definition.Bucket == bucket {
+			defnIds[defnId] = true
+		}
+	}
+	o.repo.mutex.Unlock()
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	result := make([]*watcher, 0, len(o.watchers))
+	for _, watcher := range o.watchers {
+		if watcher.hostsAnyDefn(defnIds) {
+			result = append(result, watcher)
+		}
+	}
+	return result
+}
+
+// reserveIndexName reserves name of a new index on bucket with every
+// watched indexer, in the order of their address, so that of concurrent
+// creates of the same name through different providers at most one gets
+// all the reservations.  Returns the watchers holding the reservation, to
+// be released once the index is created or fails to be created.
+// Indexers that fail to reserve the name for reasons other than it being
+// taken are skipped, unless reservation is strict.
+// - return ErrDuplicateIndexName if name is taken on any indexer.
+func (o *MetadataProvider) reserveIndexName(bucket, name string, defnID c.IndexDefnId) ([]*watcher, error) {
+
+	content, err := MarshallIndexNameReservation(
+		&IndexNameReservation{Bucket: bucket, Name: name, DefnId: uint64(defnID)})
+	if err != nil {
+		return nil, err
+	}
+
+	o.mutex.Lock()
+	strict := o.strictNames
+	watchers := make([]*watcher, 0, len(o.watchers))
+	for _, watcher := range o.watchers {
+		if watcher != nil {
+			watchers = append(watchers, watcher)
+		}
+	}
+	o.mutex.Unlock()
+	sort.Sort(watchersByAddr(watchers))
+
+	reserved := make([]*watcher, 0, len(watchers))
+	for _, watcher := range watchers {
+		err := watcher.makeRequest(OPCODE_RESERVE_INDEX_NAME, name, content)
+		if err != nil && !strict && !IsDuplicateIndexName(err) {
+			c.Warnf("MetadataProvider.reserveIndexName(): skip reserving index %v on bucket %v with %v. Reason = %v",
+				name, bucket, watcher.leaderAddr, err)
+			continue
+		} else if err != nil {
+			c.Errorf("MetadataProvider.reserveIndexName(): fail to reserve index %v on bucket %v with %v. Reason = %v",
+				name, bucket, watcher.leaderAddr, err)
+			o.releaseIndexName(reserved, bucket, name, defnID)
+			if IsDuplicateIndexName(err) {
+				return nil, ErrDuplicateIndexName
+			}
+			return nil, errors.New(fmt.Sprintf("Fails to create index.  Fail to reserve index name. %v", err))
+		}
+		reserved = append(reserved, watcher)
+	}
+
+	return reserved, nil
+}
+
+// releaseIndexName releases reservations made by reserveIndexName.  It is
+// best effort, reservations not released expire at the indexer.
+func (o *MetadataProvider) releaseIndexName(watchers []*watcher, bucket, name string, defnID c.IndexDefnId) {
+
+	content, err := MarshallIndexNameReservation(
+		&IndexNameReservation{Bucket: bucket, Name: name, DefnId: uint64(defnID)})
+	if err != nil {
+		return
+	}
+
+	for _, watcher := range watchers {
+		if err := watcher.makeRequest(OPCODE_RELEASE_INDEX_NAME, name, content); err != nil {
+			c.Warnf("MetadataProvider.releaseIndexName(): fail to release index %v on bucket %v with %v. Reason = %v",
+				name, bucket, watcher.leaderAddr, err)
+		}
+	}
+}
+
 func (o *MetadataProvider) getWatcherAddr(MetadataProviderId string) (string, error) {
 
 	addrs, err := net.InterfaceAddrs()
@@ -364,6 +794,33 @@ func (o *MetadataProvider) getWatcherAddr(MetadataProviderId string) (string, er
 	return "", errors.New("MetadataProvider.getWatcherAddr() : Fail to find an IP address")
 }
 
+// pickNode returns the indexer node selected by the placement policy for
+// a new index.
+func (o *MetadataProvider) pickNode() (string, error) {
+	o.mutex.Lock()
+	policy := o.placement
+	nodes := make([]*NodeInfo, 0, len(o.watchers))
+	for _, watcher := range o.watchers {
+		if watcher != nil {
+			addr := watcher.leaderAddr
+			nodes = append(nodes, &NodeInfo{
+				Addr:       addr,
+				NumIndexes: watcher.numIndices(),
+				getCapacity: func() (*c.IndexerCapacity, error) {
+					return o.GetIndexerCapacity(addr)
+				},
+			})
+		}
+	}
+	o.mutex.Unlock()
+
+	if len(nodes) == 0 {
+		return "", ErrNoIndexerNode
+	}
+	sortNodes(nodes)
+	return policy.PickNode(nodes)
+}
+
 func (o *MetadataProvider) findMatchingWatcher(deployNodeName string) *watcher {
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
@@ -387,12 +844,7 @@ func (o *MetadataProvider) isValidIndex(meta *IndexMetadata) bool {
 		return false
 	}
 
-	if meta.Instances[0].State == c.INDEX_STATE_CREATED ||
-		meta.Instances[0].State == c.INDEX_STATE_DELETED {
-		return false
-	}
-
-	return true
+	return meta.Instances[0].State.IsDeployed()
 }
 
 ///////////////////////////////////////////////////////
@@ -403,8 +855,24 @@ func newMetadataRepo() *metadataRepo {
 
 	return &metadataRepo{
 		definitions: make(map[c.IndexDefnId]*c.IndexDefn),
-		instances:   make(map[c.IndexDefnId]*IndexInstDistribution),
-		indices:     make(map[c.IndexDefnId]*IndexMetadata)}
+		topologies:  make(map[topologyKey]*topologySnapshot),
+		indices:     make(map[c.IndexDefnId]*IndexMetadata),
+		changech:    make(chan bool)}
+}
+
+// changed returns a channel closed on the next change to the repo.
+func (r *metadataRepo) changed() <-chan bool {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.changech
+}
+
+func (r *metadataRepo) notifyChangeNoLock() {
+
+	close(r.changech)
+	r.changech = make(chan bool)
 }
 
 func (r *metadataRepo) addDefn(defn *c.IndexDefn) {
@@ -413,12 +881,14 @@ func (r *metadataRepo) addDefn(defn *c.IndexDefn) {
 	defer r.mutex.Unlock()
 
 	r.definitions[defn.DefnId] = defn
-	r.indices[defn.DefnId] = r.makeIndexMetadata(defn)
-
-	inst, ok := r.instances[defn.DefnId]
-	if ok {
-		r.updateIndexMetadata(defn.DefnId, inst)
+	meta := r.makeIndexMetadata(defn)
+	for _, snapshot := range r.topologies {
+		if inst, ok := snapshot.instances[defn.DefnId]; ok {
+			meta = r.updateIndexMetadata(meta, inst, snapshot.version)
+		}
 	}
+	r.indices[defn.DefnId] = meta
+	r.notifyChangeNoLock()
 }
 
 func (r *metadataRepo) removeDefn(defnId c.IndexDefnId) {
@@ -427,24 +897,105 @@ func (r *metadataRepo) removeDefn(defnId c.IndexDefnId) {
 	defer r.mutex.Unlock()
 
 	delete(r.definitions, defnId)
-	delete(r.instances, defnId)
 	delete(r.indices, defnId)
+	r.notifyChangeNoLock()
+}
+
+// updateTopology applies a version of the topology of a bucket hosted by
+// indexer in two phases.  A snapshot of the topology, and a copy of the
+// IndexMetadata of every index in it, are made aside and then swapped in
+// together under the lock, so that readers see all the indexes in either
+// the old or the new topology and never a mix of both.  Versions older
+// than the one swapped in are ignored, they can be received from an http
+// poll that raced with the watcher.
+func (r *metadataRepo) updateTopology(indexer string, topology *IndexTopology) {
+
+	key := topologyKey{indexer: indexer, bucket: topology.Bucket}
+	snapshot := &topologySnapshot{
+		version:   topology.Version,
+		instances: make(map[c.IndexDefnId]*IndexInstDistribution),
+	}
+	for i, _ := range topology.Definitions {
+		defnRef := &topology.Definitions[i]
+		for j, _ := range defnRef.Instances {
+			snapshot.instances[c.IndexDefnId(defnRef.DefnId)] = &defnRef.Instances[j]
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if current, ok := r.topologies[key]; ok && current.version > snapshot.version {
+		c.Warnf("metadataRepo.updateTopology(): ignore topology version %v of bucket %v on %v, older than version %v",
+			snapshot.version, key.bucket, indexer, current.version)
+		return
+	}
+
+	indices := make(map[c.IndexDefnId]*IndexMetadata)
+	for defnId, inst := range snapshot.instances {
+		if meta, ok := r.indices[defnId]; ok {
+			indices[defnId] = r.updateIndexMetadata(meta, inst, snapshot.version)
+		}
+	}
+
+	r.topologies[key] = snapshot
+	for defnId, meta := range indices {
+		r.indices[defnId] = meta
+	}
+	r.notifyChangeNoLock()
+}
+
+// removeTopology of a bucket hosted by indexer, once the topology is
+// deleted from the indexer, versions of a topology created again start
+// afresh.
+func (r *metadataRepo) removeTopology(indexer string, bucket string) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.topologies, topologyKey{indexer: indexer, bucket: bucket})
 }
 
-func (r *metadataRepo) updateTopology(topology *IndexTopology) {
+// removeTopologies of all buckets hosted by indexer.
+func (r *metadataRepo) removeTopologies(indexer string) {
 
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	for _, defnRef := range topology.Definitions {
-		defnId := c.IndexDefnId(defnRef.DefnId)
-		for _, instRef := range defnRef.Instances {
-			r.instances[defnId] = &instRef
-			r.updateIndexMetadata(defnId, &instRef)
+	for key, _ := range r.topologies {
+		if key.indexer == indexer {
+			delete(r.topologies, key)
 		}
 	}
 }
 
+// topologyBuckets hosted by indexer, for which a topology is known.
+func (r *metadataRepo) topologyBuckets(indexer string) []string {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var buckets []string
+	for key, _ := range r.topologies {
+		if key.indexer == indexer {
+			buckets = append(buckets, key.bucket)
+		}
+	}
+	return buckets
+}
+
+// topologyVersion of a bucket hosted by indexer, 0 if not known.
+func (r *metadataRepo) topologyVersion(indexer string, bucket string) uint64 {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if snapshot, ok := r.topologies[topologyKey{indexer: indexer, bucket: bucket}]; ok {
+		return snapshot.version
+	}
+	return 0
+}
+
 func (r *metadataRepo) unmarshallAndAddDefn(content []byte) error {
 
 	defn, err := c.UnmarshallIndexDefn(content)
@@ -455,13 +1006,13 @@ func (r *metadataRepo) unmarshallAndAddDefn(content []byte) error {
 	return nil
 }
 
-func (r *metadataRepo) unmarshallAndAddInst(content []byte) error {
+func (r *metadataRepo) unmarshallAndAddInst(indexer string, content []byte) error {
 
 	topology, err := unmarshallIndexTopology(content)
 	if err != nil {
 		return err
 	}
-	r.updateTopology(topology)
+	r.updateTopology(indexer, topology)
 	return nil
 }
 
@@ -471,21 +1022,27 @@ func (r *metadataRepo) makeIndexMetadata(defn *c.IndexDefn) *IndexMetadata {
 		Instances: nil}
 }
 
-func (r *metadataRepo) updateIndexMetadata(defnId c.IndexDefnId, inst *IndexInstDistribution) {
-
-	meta, ok := r.indices[defnId]
-	if ok {
-		idxInst := new(InstanceDefn)
-		idxInst.InstId = c.IndexInstId(inst.InstId)
-		idxInst.State = c.IndexState(inst.State)
-		idxInst.Error = inst.Error
-
-		for _, partition := range inst.Partitions {
-			for _, slice := range partition.SinglePartition.Slices {
-				idxInst.Endpts = append(idxInst.Endpts, c.Endpoint(slice.Host))
-			}
+// updateIndexMetadata returns a copy of meta with the instance from
+// version of the topology, meta is not modified.
+func (r *metadataRepo) updateIndexMetadata(meta *IndexMetadata,
+	inst *IndexInstDistribution, version uint64) *IndexMetadata {
+
+	idxInst := new(InstanceDefn)
+	idxInst.InstId = c.IndexInstId(inst.InstId)
+	idxInst.State = c.IndexState(inst.State)
+	idxInst.Error = inst.Error
+	idxInst.ErrorHistory = inst.ErrorHistory
+	idxInst.NeedsRebuild = inst.Error == c.ErrorBucketUUIDChanged.Error()
+
+	for _, partition := range inst.Partitions {
+		for _, slice := range partition.SinglePartition.Slices {
+			idxInst.Endpts = append(idxInst.Endpts, c.Endpoint(slice.Host))
 		}
-		meta.Instances = []*InstanceDefn{idxInst}
+	}
+	return &IndexMetadata{
+		Definition:      meta.Definition,
+		Instances:       []*InstanceDefn{idxInst},
+		TopologyVersion: version,
 	}
 }
 
@@ -493,6 +1050,12 @@ func (r *metadataRepo) updateIndexMetadata(defnId c.IndexDefnId, inst *IndexInst
 // private function : Watcher
 ///////////////////////////////////////////////////////
 
+type watchersByAddr []*watcher
+
+func (s watchersByAddr) Len() int           { return len(s) }
+func (s watchersByAddr) Less(i, j int) bool { return s[i].leaderAddr < s[j].leaderAddr }
+func (s watchersByAddr) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 func newWatcher(o *MetadataProvider, addr string) *watcher {
 	s := new(watcher)
 
@@ -501,10 +1064,20 @@ func newWatcher(o *MetadataProvider, addr string) *watcher {
 	s.killch = make(chan bool, 1) // make it buffered to unblock sender
 	s.factory = message.NewConcreteMsgFactory()
 	s.pendings = make(map[common.Txnid]protocol.LogEntryMsg)
-	s.incomingReqs = make(chan *protocol.RequestHandle)
+	s.proposedAt = make(map[common.Txnid]time.Time)
+	s.indices = make(map[c.IndexDefnId]interface{})
+
+	if o.readOnly {
+		// read-only watcher never issues a request, it only needs
+		// a request channel to hand over to the watcher server.
+		s.incomingReqs = make(chan *protocol.RequestHandle)
+		return s
+	}
+
+	s.incomingReqs = make(chan *protocol.RequestHandle, o.reqWindow)
+	s.window = newRequestWindow(o.reqWindow)
 	s.pendingReqs = make(map[uint64]*protocol.RequestHandle)
 	s.loggedReqs = make(map[common.Txnid]*protocol.RequestHandle)
-	s.indices = make(map[c.IndexDefnId]interface{})
 
 	return s
 }
@@ -535,6 +1108,28 @@ func (w *watcher) removeDefnWithNoLock(defnId c.IndexDefnId) {
 	delete(w.indices, defnId)
 }
 
+func (w *watcher) numIndices() int {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return len(w.indices)
+}
+
+// hostsAnyDefn returns whether watcher's indexer hosts any of defnIds.
+func (w *watcher) hostsAnyDefn(defnIds map[c.IndexDefnId]bool) bool {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for defnId, _ := range w.indices {
+		if defnIds[defnId] {
+			return true
+		}
+	}
+	return false
+}
+
 func (w *watcher) cleanupIndices(repo *metadataRepo) {
 
 	w.mutex.Lock()
@@ -543,6 +1138,7 @@ func (w *watcher) cleanupIndices(repo *metadataRepo) {
 	for defnId, _ := range w.indices {
 		repo.removeDefn(defnId)
 	}
+	repo.removeTopologies(w.leaderAddr)
 }
 
 func (w *watcher) close() {
@@ -550,12 +1146,22 @@ func (w *watcher) close() {
 	if len(w.killch) == 0 {
 		w.killch <- true
 	}
+	w.stopPolling()
 }
 
 func (w *watcher) makeRequest(opCode common.OpCode, key string, content []byte) error {
 
+	start := time.Now()
+	stats := w.provider.stats
+
+	if w.provider.readOnly {
+		stats.addRequest(opCode, time.Since(start), DDL_FAILURE_READ_ONLY)
+		return &ReadOnlyError{Op: fmt.Sprintf("request %v", opCode)}
+	}
+
 	uuid, err := c.NewUUID()
 	if err != nil {
+		stats.addRequest(opCode, time.Since(start), DDL_FAILURE_INTERNAL)
 		return err
 	}
 	id := uuid.Uint64()
@@ -565,6 +1171,13 @@ func (w *watcher) makeRequest(opCode common.OpCode, key string, content []byte)
 	handle := &protocol.RequestHandle{Request: request, Err: nil}
 	handle.CondVar = sync.NewCond(&handle.Mutex)
 
+	// Wait for a slot in the in-flight window.  Responses are matched
+	// back to the handle by request id (pendingReqs) and txnid (loggedReqs),
+	// so multiple requests can be outstanding at the same time.
+	w.window.acquire()
+	defer w.window.release()
+	queued := time.Since(start)
+
 	handle.CondVar.L.Lock()
 	defer handle.CondVar.L.Unlock()
 
@@ -572,9 +1185,65 @@ func (w *watcher) makeRequest(opCode common.OpCode, key string, content []byte)
 
 	handle.CondVar.Wait()
 
+	elapsed := time.Since(start)
+	failureClass := ""
+	if handle.Err != nil {
+		failureClass = DDL_FAILURE_REJECTED
+	}
+	stats.addRequest(opCode, elapsed, failureClass)
+
+	if elapsed > w.provider.slowDDLThreshold() {
+		stats.slowDDLs.Add(1)
+		c.Warnf("watcher.makeRequest(): slow %v request for key = %v to %v.  Took %v, queued for %v.  Error = %v",
+			opCodeName(opCode), key, w.leaderAddr, elapsed, queued, handle.Err)
+	}
+
 	return handle.Err
 }
 
+// requestWindow bounds the number of requests a watcher has in flight,
+// its size can be changed while requests are outstanding.
+type requestWindow struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	size     int
+	inflight int
+}
+
+func newRequestWindow(size int) *requestWindow {
+	window := &requestWindow{size: size}
+	window.cond = sync.NewCond(&window.mutex)
+	return window
+}
+
+// acquire a slot in the window, waiting for one to be released if all
+// slots are in use.
+func (r *requestWindow) acquire() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for r.inflight >= r.size {
+		r.cond.Wait()
+	}
+	r.inflight++
+}
+
+func (r *requestWindow) release() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.inflight--
+	r.cond.Signal()
+}
+
+func (r *requestWindow) resize(size int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.size = size
+	r.cond.Broadcast()
+}
+
 ///////////////////////////////////////////////////////
 // private function
 ///////////////////////////////////////////////////////
@@ -587,6 +1256,14 @@ func isIndexTopologyKey(key string) bool {
 	return strings.Contains(key, "IndexTopology/")
 }
 
+func getBucketFromTopologyKey(key string) string {
+	i := strings.Index(key, "IndexTopology/")
+	if i != -1 {
+		return key[i+len("IndexTopology/"):]
+	}
+	return ""
+}
+
 ///////////////////////////////////////////////////////
 // Interface : RequestMgr
 ///////////////////////////////////////////////////////
@@ -634,6 +1311,11 @@ func (w *watcher) GetQuorumVerifier() protocol.QuorumVerifier {
 
 func (w *watcher) Commit(txid common.Txnid) error {
 
+	start := time.Now()
+	defer func() {
+		w.provider.stats.commitLatency.Add(int64(time.Since(start)))
+	}()
+
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
@@ -644,6 +1326,10 @@ func (w *watcher) Commit(txid common.Txnid) error {
 	}
 
 	delete(w.pendings, txid)
+	if proposedAt, ok := w.proposedAt[txid]; ok {
+		delete(w.proposedAt, txid)
+		w.provider.stats.addApplyLag(start.Sub(proposedAt))
+	}
 	err := w.processChange(msg.GetOpCode(), msg.GetKey(), msg.GetContent())
 
 	handle, ok := w.loggedReqs[txid]
@@ -666,6 +1352,7 @@ func (w *watcher) LogProposal(p protocol.ProposalMsg) error {
 
 	msg := w.factory.CreateLogEntry(p.GetTxnid(), p.GetOpCode(), p.GetKey(), p.GetContent())
 	w.pendings[common.Txnid(p.GetTxnid())] = msg
+	w.proposedAt[common.Txnid(p.GetTxnid())] = time.Now()
 
 	handle, ok := w.pendingReqs[p.GetReqId()]
 	if ok {
@@ -698,7 +1385,8 @@ func (w *watcher) respond(reqId uint64, err string) {
 		defer handle.CondVar.L.Unlock()
 
 		if len(err) != 0 {
-			handle.Err = errors.New(err)
+			// restore typed errors, like ErrDuplicateIndexName.
+			handle.Err = c.DecodeError(c.ErrorCodeUnknown, err)
 		}
 
 		handle.CondVar.Signal()
@@ -773,6 +1461,11 @@ func (w *watcher) processChange(op uint32, key string, content []byte) error {
 	c.Debugf("watcher.processChange(): key = %v", key)
 	defer c.Debugf("watcher.processChange(): done -> key = %v", key)
 
+	start := time.Now()
+	defer func() {
+		w.provider.stats.changeLatency.Add(int64(time.Since(start)))
+	}()
+
 	opCode := common.OpCode(op)
 
 	switch opCode {
@@ -786,14 +1479,21 @@ func (w *watcher) processChange(op uint32, key string, content []byte) error {
 			if err != nil {
 				return err
 			}
+			if err := w.provider.repo.unmarshallAndAddDefn(content); err != nil {
+				w.requestResync(key, err)
+				return err
+			}
 			w.addDefnWithNoLock(c.IndexDefnId(id))
-			return w.provider.repo.unmarshallAndAddDefn(content)
+			return nil
 
 		} else if isIndexTopologyKey(key) {
 			if len(content) == 0 {
 				c.Debugf("watcher.processChange(): content of key = %v is empty.", key)
 			}
-			return w.provider.repo.unmarshallAndAddInst(content)
+			if err := w.provider.repo.unmarshallAndAddInst(w.leaderAddr, content); err != nil {
+				w.requestResync(key, err)
+				return err
+			}
 		}
 	case common.OPCODE_DELETE:
 		if isIndexDefnKey(key) {
@@ -804,12 +1504,35 @@ func (w *watcher) processChange(op uint32, key string, content []byte) error {
 			}
 			w.removeDefnWithNoLock(c.IndexDefnId(id))
 			w.provider.repo.removeDefn(c.IndexDefnId(id))
+
+		} else if isIndexTopologyKey(key) {
+			w.provider.repo.removeTopology(w.leaderAddr, getBucketFromTopologyKey(key))
 		}
 	}
 
 	return nil
 }
 
+// requestResync asks the indexer to send the value of key again, after
+// a corrupted or truncated payload for key is rejected. Read-only watchers
+// cannot make requests, they pick up the key on its next update. Watchers
+// polling over http pick up the key on the next poll.
+func (w *watcher) requestResync(key string, reason error) {
+
+	c.Errorf("watcher.processChange(): reject content of key = %v. Reason = %v", key, reason)
+	if w.provider.readOnly || w.isPolling() {
+		return
+	}
+
+	// processChange is called by the watcher's protocol handler, which also
+	// delivers the response, so the request cannot be waited upon here.
+	go func() {
+		if err := w.makeRequest(OPCODE_RESYNC_KEY, key, []byte("")); err != nil {
+			c.Errorf("watcher.requestResync(): re-sync of key = %v fails. Reason = %v", key, err)
+		}
+	}()
+}
+
 func extractDefnIdFromKey(key string) (c.IndexDefnId, error) {
 	i := strings.Index(key, "/")
 	if i != -1 && i < len(key)-1 {
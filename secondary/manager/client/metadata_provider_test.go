@@ -0,0 +1,253 @@
+package client
+
+import (
+	"fmt"
+	"github.com/couchbase/gometa/common"
+	"github.com/couchbase/gometa/message"
+	"github.com/couchbase/gometa/protocol"
+	c "github.com/couchbase/indexing/secondary/common"
+	"testing"
+	"time"
+)
+
+func TestTopologySnapshot(t *testing.T) {
+	o := &MetadataProvider{repo: newMetadataRepo()}
+	topology := func(version uint64, state c.IndexState) *IndexTopology {
+		return &IndexTopology{
+			Version: version,
+			Bucket:  "default",
+			Definitions: []IndexDefnDistribution{
+				{DefnId: 1, Instances: []IndexInstDistribution{{InstId: 1, State: uint32(state)}}},
+				{DefnId: 2, Instances: []IndexInstDistribution{{InstId: 2, State: uint32(state)}}},
+			},
+		}
+	}
+	check := func(state c.IndexState, version uint64) {
+		for _, id := range []c.IndexDefnId{1, 2} {
+			meta := o.FindIndex(id)
+			if meta == nil || meta.Instances[0].State != state || meta.TopologyVersion != version {
+				t.Fatalf("expected index %v in state %v at version %v, got %v", id, state, version, meta)
+			}
+			if meta.Instances[0].InstId != c.IndexInstId(id) {
+				t.Fatalf("expected instance %v, got %v", id, meta.Instances[0].InstId)
+			}
+		}
+	}
+
+	o.repo.addDefn(&c.IndexDefn{DefnId: 1, Name: "by_city", Bucket: "default"})
+	o.repo.updateTopology("indexer:9100", topology(1, c.INDEX_STATE_READY))
+	o.repo.addDefn(&c.IndexDefn{DefnId: 2, Name: "by_zip", Bucket: "default"})
+	check(c.INDEX_STATE_READY, 1)
+
+	before := o.FindIndex(1)
+	o.repo.updateTopology("indexer:9100", topology(3, c.INDEX_STATE_ACTIVE))
+	check(c.INDEX_STATE_ACTIVE, 3)
+	if before.Instances[0].State != c.INDEX_STATE_READY || before.TopologyVersion != 1 {
+		t.Fatalf("expected metadata handed out to be left as is, got %v", before)
+	}
+
+	o.repo.updateTopology("indexer:9100", topology(2, c.INDEX_STATE_INITIAL))
+	check(c.INDEX_STATE_ACTIVE, 3)
+	if v := o.TopologyVersion("indexer:9100", "default"); v != 3 {
+		t.Fatalf("expected topology version 3, got %v", v)
+	}
+
+	// topology created again after it was deleted.
+	o.repo.removeTopology("indexer:9100", "default")
+	if v := o.TopologyVersion("indexer:9100", "default"); v != 0 {
+		t.Fatalf("expected no topology version, got %v", v)
+	}
+	o.repo.updateTopology("indexer:9100", topology(1, c.INDEX_STATE_INITIAL))
+	check(c.INDEX_STATE_INITIAL, 1)
+}
+
+// newTestProvider returns a provider watching a single indexer, that is
+// hosting active indexes `defnIds`, without connecting to it.
+func newTestProvider(window int, defnIds ...c.IndexDefnId) (*MetadataProvider, *watcher) {
+	o := &MetadataProvider{
+		watchers:  make(map[string]*watcher),
+		repo:      newMetadataRepo(),
+		stats:     newProviderStats(),
+		reqWindow: window,
+		slowDDL:   time.Minute,
+	}
+	w := newWatcher(o, "indexer:9100")
+	o.watchers["indexer:9100"] = w
+
+	topology := &IndexTopology{Version: 1, Bucket: "default"}
+	for _, id := range defnIds {
+		w.addDefn(id)
+		o.repo.addDefn(&c.IndexDefn{DefnId: id, Name: fmt.Sprintf("idx%d", id), Bucket: "default"})
+		topology.Definitions = append(topology.Definitions, IndexDefnDistribution{
+			DefnId:    uint64(id),
+			Instances: []IndexInstDistribution{{InstId: uint64(id), State: uint32(c.INDEX_STATE_ACTIVE)}},
+		})
+	}
+	o.repo.updateTopology("indexer:9100", topology)
+	return o, w
+}
+
+// serveRequests plays the indexer for `n` requests issued to `w`, it lets
+// requests in flight pile up to `window` before answering them in reverse
+// order, failing drop requests of odd index ids with the id.
+func serveRequests(t *testing.T, w *watcher, n, window int) {
+	reqch := w.GetRequestChannel()
+	for served := 0; served < n; {
+		handles := make([]*protocol.RequestHandle, 0, window)
+		for len(handles) < window && served+len(handles) < n {
+			handle := <-reqch
+			w.AddPendingRequest(handle)
+			handles = append(handles, handle)
+		}
+		select {
+		case handle := <-reqch:
+			t.Errorf("request %v beyond window of %v", handle.Request.GetKey(), window)
+			w.AddPendingRequest(handle)
+			handles = append(handles, handle)
+		case <-time.After(50 * time.Millisecond):
+		}
+		for i := len(handles) - 1; i >= 0; i-- {
+			req := handles[i].Request
+			var id int
+			fmt.Sscanf(req.GetKey(), "%d", &id)
+			errMsg := ""
+			if id%2 == 1 {
+				errMsg = req.GetKey()
+			}
+			w.Respond(w.GetFollowerId(), req.GetReqId(), errMsg)
+		}
+		served += len(handles)
+	}
+}
+
+func TestRequestWindow(t *testing.T) {
+	ids := make([]c.IndexDefnId, 0, 10)
+	for id := c.IndexDefnId(1); id <= 10; id++ {
+		ids = append(ids, id)
+	}
+	o, w := newTestProvider(3, ids...)
+
+	dropIndexes := func(ids []c.IndexDefnId, window int) {
+		errch := make(chan error, len(ids))
+		for _, id := range ids {
+			go func(id c.IndexDefnId) {
+				err := o.DropIndex(id, "indexer:9100")
+				if id%2 == 1 && (err == nil || err.Error() != fmt.Sprintf("%d", id)) {
+					err = fmt.Errorf("drop %v: expected its own error, got %v", id, err)
+				} else if id%2 == 0 && err != nil {
+					err = fmt.Errorf("drop %v: expected no error, got %v", id, err)
+				} else {
+					err = nil
+				}
+				errch <- err
+			}(id)
+		}
+		donech := make(chan bool)
+		go func() {
+			serveRequests(t, w, len(ids), window)
+			close(donech)
+		}()
+		for range ids {
+			select {
+			case err := <-errch:
+				if err != nil {
+					t.Error(err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("requests did not complete")
+			}
+		}
+		<-donech
+	}
+
+	dropIndexes(ids, 3)
+
+	// window is resized for watchers already started.
+	o.SetRequestWindow(1)
+	dropIndexes(ids[:4], 1)
+}
+
+func TestReadOnlyProvider(t *testing.T) {
+	o := &MetadataProvider{
+		readOnly:  true,
+		watchers:  make(map[string]*watcher),
+		placement: &leastLoadedPolicy{},
+		repo:      newMetadataRepo(),
+		stats:     newProviderStats(),
+		reqWindow: DEFAULT_REQUEST_WINDOW,
+		slowDDL:   time.Minute,
+	}
+	w := newWatcher(o, "indexer:9100")
+	o.watchers["indexer:9100"] = w
+	if w.pendingReqs != nil || w.loggedReqs != nil || w.window != nil {
+		t.Fatalf("expected read-only watcher without request state")
+	}
+
+	// changes proposed and committed for DDLs of other providers.
+	defn, err := c.MarshallIndexDefn(&c.IndexDefn{DefnId: 1, Name: "by_city", Bucket: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	topology, err := marshallIndexTopology(&IndexTopology{
+		Version: 1,
+		Bucket:  "default",
+		Definitions: []IndexDefnDistribution{
+			{DefnId: 1, Instances: []IndexInstDistribution{{InstId: 1, State: uint32(c.INDEX_STATE_ACTIVE)}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := message.NewConcreteMsgFactory()
+	changes := []struct {
+		key     string
+		content []byte
+	}{{indexDefnKey(1), defn}, {indexTopologyKey("default"), topology}}
+	for i, change := range changes {
+		txnid, reqId := uint64(i+1), uint64(100+i)
+		p := factory.CreateProposal(txnid, "provider2", reqId, uint32(common.OPCODE_ADD), change.key, change.content)
+		if err := w.LogProposal(p); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Commit(common.Txnid(txnid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Respond("provider2", 100, "")
+	w.Abort("provider2", 101, "rejected")
+	if meta := o.FindIndexByName("by_city", "default"); meta == nil {
+		t.Fatalf("expected index committed by other provider to be watched")
+	}
+
+	// every DDL entry point is rejected.
+	ddls := map[string]func() error{
+		"CreateIndex": func() error {
+			_, err := o.CreateIndex("by_zip", "default", "GSI", "N1QL", "", "",
+				"indexer:9100", []string{"zip"}, false)
+			return err
+		},
+		"CreateIndexWithPlan": func() error {
+			_, err := o.CreateIndexWithPlan("by_zip", "default", "GSI", "N1QL", "", "",
+				[]string{"zip"}, false, map[string]interface{}{"nodes": []interface{}{"indexer:9100"}})
+			return err
+		},
+		"DropIndex": func() error {
+			return o.DropIndex(1, "indexer:9100")
+		},
+		"BuildIndexes": func() error {
+			return o.BuildIndexes("indexer:9100", []c.IndexDefnId{1})
+		},
+		"DropIndexesByBucket": func() error {
+			return o.DropIndexesByBucket("default")
+		},
+		"makeRequest": func() error {
+			return w.makeRequest(OPCODE_CREATE_INDEX, indexDefnKey(2), nil)
+		},
+	}
+	for name, ddl := range ddls {
+		err := ddl()
+		if _, ok := err.(*ReadOnlyError); !ok {
+			t.Errorf("%v: expected ReadOnlyError, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	c "github.com/couchbase/indexing/secondary/common"
+	"sort"
+	"sync"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+const (
+	PLACEMENT_ROUND_ROBIN   = "round_robin"
+	PLACEMENT_LEAST_INDEXES = "least_indexes"
+	PLACEMENT_LEAST_DISK    = "least_disk"
+	PLACEMENT_LEAST_LOADED  = "least_loaded"
+)
+
+var ErrNoIndexerNode = c.NewError(301, "There is no indexer node available", true)
+
+// PlacementPolicy selects the indexer node for a new index when the plan
+// given to CreateIndexWithPlan does not name any node.
+type PlacementPolicy interface {
+	// PickNode returns the address of the node, from a non-empty list of
+	// nodes sorted by address, on which the index is to be placed.
+	PickNode(nodes []*NodeInfo) (string, error)
+}
+
+// NodeInfo describes an indexer node watched by the MetadataProvider.
+type NodeInfo struct {
+	Addr       string // indexer adminport
+	NumIndexes int    // index definitions known to be on the node
+
+	capacity    *c.IndexerCapacity
+	capacityErr error
+	getCapacity func() (*c.IndexerCapacity, error)
+}
+
+type roundRobinPolicy struct {
+	mutex sync.Mutex
+	next  int
+}
+
+type leastIndexesPolicy struct{}
+
+type leastDiskPolicy struct{}
+
+type leastLoadedPolicy struct{}
+
+///////////////////////////////////////////////////////
+// Public function
+///////////////////////////////////////////////////////
+
+// NewPlacementPolicy returns the placement policy for name, which is one
+// of round_robin, least_indexes, least_disk or least_loaded.
+func NewPlacementPolicy(name string) (PlacementPolicy, error) {
+	switch name {
+	case PLACEMENT_ROUND_ROBIN:
+		return &roundRobinPolicy{}, nil
+	case PLACEMENT_LEAST_INDEXES:
+		return &leastIndexesPolicy{}, nil
+	case PLACEMENT_LEAST_DISK:
+		return &leastDiskPolicy{}, nil
+	case PLACEMENT_LEAST_LOADED:
+		return &leastLoadedPolicy{}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown placement policy %s", name))
+}
+
+// Capacity returns the capacity stats reported by the node.  Stats are
+// fetched on first use.
+func (n *NodeInfo) Capacity() (*c.IndexerCapacity, error) {
+	if n.capacity == nil && n.capacityErr == nil {
+		if n.getCapacity == nil {
+			n.capacityErr = ErrCapacityNotAvailable
+		} else {
+			n.capacity, n.capacityErr = n.getCapacity()
+		}
+	}
+	return n.capacity, n.capacityErr
+}
+
+///////////////////////////////////////////////////////
+// Placement Policies
+///////////////////////////////////////////////////////
+
+// round_robin cycles through the nodes, across calls.
+func (p *roundRobinPolicy) PickNode(nodes []*NodeInfo) (string, error) {
+	if len(nodes) == 0 {
+		return "", ErrNoIndexerNode
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	node := nodes[p.next%len(nodes)]
+	p.next++
+	return node.Addr, nil
+}
+
+// least_indexes picks the node hosting fewest indexes.
+func (p *leastIndexesPolicy) PickNode(nodes []*NodeInfo) (string, error) {
+	if len(nodes) == 0 {
+		return "", ErrNoIndexerNode
+	}
+
+	best := nodes[0]
+	for _, node := range nodes[1:] {
+		if node.NumIndexes < best.NumIndexes {
+			best = node
+		}
+	}
+	return best.Addr, nil
+}
+
+// least_disk picks the node with least disk used by indexes.  Nodes not
+// reporting capacity are considered only if no node does.
+func (p *leastDiskPolicy) PickNode(nodes []*NodeInfo) (string, error) {
+	return pickByCapacity(nodes, func(x, y *c.IndexerCapacity) bool {
+		return x.DiskUsed < y.DiskUsed
+	})
+}
+
+// least_loaded picks the node with most capacity left, see
+// IndexerCapacity.LessLoaded().
+func (p *leastLoadedPolicy) PickNode(nodes []*NodeInfo) (string, error) {
+	return pickByCapacity(nodes, func(x, y *c.IndexerCapacity) bool {
+		return x.LessLoaded(y)
+	})
+}
+
+// pickByCapacity picks the best node as ordered by `less` on capacity
+// stats, falling back to least_indexes if no node reports its capacity.
+func pickByCapacity(nodes []*NodeInfo,
+	less func(x, y *c.IndexerCapacity) bool) (string, error) {
+
+	var best *NodeInfo
+	var bestCapacity *c.IndexerCapacity
+	for _, node := range nodes {
+		capacity, err := node.Capacity()
+		if err != nil {
+			c.Debugf("PlacementPolicy.PickNode(): node %s: %v", node.Addr, err)
+			continue
+		}
+		if bestCapacity == nil || less(capacity, bestCapacity) {
+			best, bestCapacity = node, capacity
+		}
+	}
+	if best != nil {
+		return best.Addr, nil
+	}
+	return (&leastIndexesPolicy{}).PickNode(nodes)
+}
+
+// sortNodes orders nodes by address, so that policies are deterministic.
+func sortNodes(nodes []*NodeInfo) {
+	sort.Sort(nodesByAddr(nodes))
+}
+
+type nodesByAddr []*NodeInfo
+
+func (s nodesByAddr) Len() int           { return len(s) }
+func (s nodesByAddr) Less(i, j int) bool { return s[i].Addr < s[j].Addr }
+func (s nodesByAddr) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,148 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"github.com/couchbase/gometa/common"
+	c "github.com/couchbase/indexing/secondary/common"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+// DDL requests taking longer than this are logged, along with the time
+// spent waiting for a slot in the request window.
+const DEFAULT_SLOW_DDL_THRESHOLD = 10 * time.Second
+
+// Classes of failed DDL requests.
+const (
+	DDL_FAILURE_READ_ONLY = "read_only" // provider does not allow DDL
+	DDL_FAILURE_INTERNAL  = "internal"  // request could not be made
+	DDL_FAILURE_REJECTED  = "rejected"  // indexer responded with an error
+)
+
+// providerStats are the statistics of a MetadataProvider, shared by all
+// of its watchers.  Latencies are in nanoseconds.
+type providerStats struct {
+	ddlOps           map[string]*c.Counter   // op -> no. of requests made
+	ddlFailures      map[string]*c.Counter   // failure class -> no. of requests
+	ddlLatency       map[string]*c.Histogram // op -> request latency
+	slowDDLs         c.Counter               // requests over slow threshold
+	commitLatency    *c.Histogram            // time to commit a logged proposal
+	changeLatency    *c.Histogram            // time to apply a metadata change
+	applyLag         *c.Histogram            // proposal logged -> committed
+	lastApplyLag     c.Gauge
+	httpPolls        c.Counter // metadata polled over http
+	httpPollFailures c.Counter
+	mutex            sync.Mutex
+}
+
+///////////////////////////////////////////////////////
+// private function : providerStats
+///////////////////////////////////////////////////////
+
+func newProviderStats() *providerStats {
+	return &providerStats{
+		ddlOps:        make(map[string]*c.Counter),
+		ddlFailures:   make(map[string]*c.Counter),
+		ddlLatency:    make(map[string]*c.Histogram),
+		commitLatency: c.NewLatencyHistogram(),
+		changeLatency: c.NewLatencyHistogram(),
+		applyLag:      c.NewLatencyHistogram(),
+	}
+}
+
+// addRequest accounts a DDL request for opCode that took elapsed,
+// failureClass is empty if it succeeded.
+func (s *providerStats) addRequest(opCode common.OpCode, elapsed time.Duration, failureClass string) {
+
+	op := opCodeName(opCode)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.ddlOps[op]; !ok {
+		s.ddlOps[op] = &c.Counter{}
+		s.ddlLatency[op] = c.NewLatencyHistogram()
+	}
+	s.ddlOps[op].Add(1)
+	s.ddlLatency[op].Add(int64(elapsed))
+
+	if len(failureClass) != 0 {
+		if _, ok := s.ddlFailures[failureClass]; !ok {
+			s.ddlFailures[failureClass] = &c.Counter{}
+		}
+		s.ddlFailures[failureClass].Add(1)
+	}
+}
+
+func (s *providerStats) addApplyLag(lag time.Duration) {
+	s.applyLag.Add(int64(lag))
+	s.lastApplyLag.Set(int64(lag))
+}
+
+func (s *providerStats) statistics() c.Statistics {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ops := make(map[string]interface{})
+	for op, counter := range s.ddlOps {
+		ops[op] = counter.Value()
+	}
+	failures := make(map[string]interface{})
+	for class, counter := range s.ddlFailures {
+		failures[class] = counter.Value()
+	}
+	latencies := make(map[string]interface{})
+	for op, histogram := range s.ddlLatency {
+		latencies[op] = histogram
+	}
+
+	stats, _ := c.NewStatistics(nil)
+	stats["ddl_ops"] = ops
+	stats["ddl_failures"] = failures
+	stats["ddl_latency"] = latencies
+	stats["slow_ddls"] = s.slowDDLs.Value()
+	stats["commit_latency"] = s.commitLatency
+	stats["change_latency"] = s.changeLatency
+	stats["apply_lag"] = s.applyLag
+	stats["last_apply_lag"] = s.lastApplyLag.Value()
+	stats["http_polls"] = s.httpPolls.Value()
+	stats["http_poll_failures"] = s.httpPollFailures.Value()
+	return stats
+}
+
+func opCodeName(opCode common.OpCode) string {
+
+	switch opCode {
+	case OPCODE_CREATE_INDEX:
+		return "create_index"
+	case OPCODE_DROP_INDEX:
+		return "drop_index"
+	case OPCODE_BUILD_INDEX:
+		return "build_index"
+	case OPCODE_UPDATE_INDEX_INST:
+		return "update_index_inst"
+	case OPCODE_RESYNC_KEY:
+		return "resync_key"
+	case OPCODE_DROP_INDEXES_BY_BUCKET:
+		return "drop_indexes_by_bucket"
+	case OPCODE_RESERVE_INDEX_NAME:
+		return "reserve_index_name"
+	case OPCODE_RELEASE_INDEX_NAME:
+		return "release_index_name"
+	}
+	return fmt.Sprintf("opcode_%d", opCode)
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProviderStats(t *testing.T) {
+	stats := newProviderStats()
+	stats.addRequest(OPCODE_CREATE_INDEX, time.Millisecond, "")
+	stats.addRequest(OPCODE_CREATE_INDEX, 2*time.Millisecond, DDL_FAILURE_REJECTED)
+	stats.addRequest(OPCODE_DROP_INDEX, time.Millisecond, DDL_FAILURE_READ_ONLY)
+	stats.addApplyLag(3 * time.Millisecond)
+
+	s := stats.statistics()
+	ops := s["ddl_ops"].(map[string]interface{})
+	if ops["create_index"] != int64(2) || ops["drop_index"] != int64(1) {
+		t.Fatalf("unexpected ddl ops %v", ops)
+	}
+	failures := s["ddl_failures"].(map[string]interface{})
+	if failures[DDL_FAILURE_REJECTED] != int64(1) || failures[DDL_FAILURE_READ_ONLY] != int64(1) {
+		t.Fatalf("unexpected ddl failures %v", failures)
+	}
+	if _, ok := failures[DDL_FAILURE_INTERNAL]; ok {
+		t.Fatalf("unexpected internal failure in %v", failures)
+	}
+	if s["last_apply_lag"] != int64(3*time.Millisecond) {
+		t.Fatalf("unexpected apply lag %v", s["last_apply_lag"])
+	}
+	if _, err := s.Encode(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpCodeName(t *testing.T) {
+	if name := opCodeName(OPCODE_BUILD_INDEX); name != "build_index" {
+		t.Fatalf("unexpected name %v", name)
+	}
+	if name := opCodeName(OPCODE_RELEASE_INDEX_NAME + 1); name == "" {
+		t.Fatalf("expected a name for unknown opcode")
+	}
+}
@@ -769,7 +769,7 @@ func (c *Coordinator) getPeerUDPAddr() []string {
 //
 func (c *Coordinator) createIndex(key string, content []byte) bool {
 
-	defn, err := co.UnmarshallIndexDefn(content)
+	defn, err := upgradeIndexDefn(content)
 	if err != nil {
 		return false
 	}
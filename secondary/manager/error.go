@@ -29,6 +29,9 @@ const (
 	ERROR_META_IDX_DEFN_EXIST     = 52
 	ERROR_META_IDX_DEFN_NOT_EXIST = 53
 	ERROR_META_FAIL_TO_PARSE_INT  = 54
+	ERROR_META_NO_TEMPLATE        = 55
+	ERROR_META_NO_MIGRATION       = 56
+	ERROR_META_REPO_CLOSED        = 57
 
 	// Event Manager (101-150)
 	ERROR_EVT_DUPLICATE_NOTIFIER = 101
@@ -55,6 +58,7 @@ const (
 	ERROR_STREAM_STREAM_END         = 308
 	ERROR_STREAM_FEEDER             = 309
 	ERROR_STREAM_INCONSISTENT_VBMAP = 310
+	ERROR_STREAM_ENDPOINT_FAILED    = 311
 )
 
 type errSeverity int16
@@ -0,0 +1,204 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+	"regexp"
+	"strings"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+// Parameter naming the bucket, implicitly declared by every template.  It
+// is the bucket of indexes in the template that do not name one.
+const INDEX_TEMPLATE_PARAM_BUCKET = "bucket"
+
+// IndexTemplate is a named set of index definitions that can be created
+// together on a bucket.  Name, bucket and expressions of the indexes can
+// refer to parameters as ${param}, substituted when indexes are created
+// from the template, e.g. "${prefix}_by_city".
+type IndexTemplate struct {
+	Name    string      `json:"name,omitempty"`
+	Params  []string    `json:"params,omitempty"`
+	Indexes []IndexInfo `json:"indexes,omitempty"`
+}
+
+var templateParamRef = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// parameters are substituted in names and expressions as is, restrict them
+// to characters that are valid in bucket names, and to identifiers when
+// substituted in expressions so that a value cannot change the meaning of
+// an expression, like "a-b" or "a) OR (b".
+var templateParamValue = regexp.MustCompile(`^[A-Za-z0-9_.%-]+$`)
+var templateParamExprValue = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var templateParamName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+///////////////////////////////////////////////////////
+// Public Function
+///////////////////////////////////////////////////////
+
+// Validate the template.  Every parameter referred to by the indexes
+// shall be declared.
+func (t *IndexTemplate) Validate() error {
+
+	if len(t.Name) == 0 || strings.Contains(t.Name, "/") {
+		return templateError(fmt.Sprintf("Invalid index template name '%s'", t.Name))
+	}
+
+	if len(t.Indexes) == 0 {
+		return templateError(fmt.Sprintf("Index template '%s' has no index", t.Name))
+	}
+
+	declared := map[string]bool{INDEX_TEMPLATE_PARAM_BUCKET: true}
+	for _, param := range t.Params {
+		if !templateParamName.MatchString(param) {
+			return templateError(fmt.Sprintf("Invalid parameter name '%s' in index template '%s'", param, t.Name))
+		}
+		declared[param] = true
+	}
+
+	for _, info := range t.Indexes {
+		if len(info.Name) == 0 {
+			return templateError(fmt.Sprintf("Index without name in index template '%s'", t.Name))
+		}
+		for _, field := range templateFields(&info) {
+			for _, ref := range templateParamRef.FindAllStringSubmatch(*field, -1) {
+				if !declared[ref[1]] {
+					return templateError(fmt.Sprintf("Undeclared parameter '%s' in index template '%s'", ref[1], t.Name))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Instantiate the indexes of the template by substituting params.  All
+// declared parameters, along with bucket, shall be given.
+func (t *IndexTemplate) Instantiate(params map[string]string) ([]IndexInfo, error) {
+
+	required := append([]string{INDEX_TEMPLATE_PARAM_BUCKET}, t.Params...)
+	for _, param := range required {
+		value, ok := params[param]
+		if !ok {
+			return nil, templateError(fmt.Sprintf("Missing parameter '%s' for index template '%s'", param, t.Name))
+		}
+		if !templateParamValue.MatchString(value) {
+			return nil, templateError(fmt.Sprintf("Invalid value '%s' of parameter '%s' for index template '%s'", value, param, t.Name))
+		}
+	}
+
+	indexes := make([]IndexInfo, 0, len(t.Indexes))
+	for _, info := range t.Indexes {
+		info.SecExprs = append([]string(nil), info.SecExprs...)
+		if len(info.Bucket) == 0 {
+			info.Bucket = "${" + INDEX_TEMPLATE_PARAM_BUCKET + "}"
+		}
+		for _, field := range []*string{&info.Name, &info.Bucket} {
+			*field = substituteParams(*field, params, nil)
+		}
+		for _, field := range templateExprFields(&info) {
+			var invalid []string
+			*field = substituteParams(*field, params, &invalid)
+			if len(invalid) > 0 {
+				return nil, templateError(fmt.Sprintf("Value '%s' of parameter '%s' for index template '%s' is not an identifier, cannot be used in expression",
+					params[invalid[0]], invalid[0], t.Name))
+			}
+		}
+		indexes = append(indexes, info)
+	}
+
+	return indexes, nil
+}
+
+///////////////////////////////////////////////////////
+// package local function
+///////////////////////////////////////////////////////
+
+// fields of an index that can refer to parameters
+func templateFields(info *IndexInfo) []*string {
+
+	return append([]*string{&info.Name, &info.Bucket}, templateExprFields(info)...)
+}
+
+// expressions of an index that can refer to parameters
+func templateExprFields(info *IndexInfo) []*string {
+
+	fields := []*string{&info.PartnExpr, &info.WhereExpr}
+	for i := range info.SecExprs {
+		fields = append(fields, &info.SecExprs[i])
+	}
+	return fields
+}
+
+// substitute parameter references in `field`, parameters whose value is
+// not an identifier are appended to `invalid` unless it is nil.
+func substituteParams(field string, params map[string]string, invalid *[]string) string {
+
+	return templateParamRef.ReplaceAllStringFunc(field, func(ref string) string {
+		param := ref[2 : len(ref)-1]
+		value := params[param]
+		if invalid != nil && !templateParamExprValue.MatchString(value) {
+			*invalid = append(*invalid, param)
+		}
+		return value
+	})
+}
+
+// index definition for an index instantiated from a template
+func templateIndexDefn(info *IndexInfo, defnId common.IndexDefnId) *common.IndexDefn {
+
+	return &common.IndexDefn{
+		DefnId:          defnId,
+		Name:            info.Name,
+		Using:           common.ForestDB,
+		Bucket:          info.Bucket,
+		IsPrimary:       info.IsPrimary,
+		SecExprs:        info.SecExprs,
+		ExprType:        common.N1QL,
+		PartitionScheme: common.SINGLE,
+		PartitionKey:    info.PartnExpr,
+		WhereExpr:       info.WhereExpr,
+	}
+}
+
+func templateError(msg string) error {
+	return NewError(ERROR_ARGUMENTS, NORMAL, INDEX_MANAGER, nil, msg)
+}
+
+func marshallIndexTemplate(template *IndexTemplate) ([]byte, error) {
+
+	buf, err := json.Marshal(template)
+	if err != nil {
+		return nil, err
+	}
+
+	return common.AddChecksum(buf), nil
+}
+
+func unmarshallIndexTemplate(data []byte) (*IndexTemplate, error) {
+
+	data, err := common.VerifyChecksum(data)
+	if err != nil {
+		return nil, err
+	}
+
+	template := new(IndexTemplate)
+	if err := json.Unmarshal(data, template); err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
@@ -12,10 +12,12 @@ package manager
 
 import (
 	"encoding/json"
+	"fmt"
 	c "github.com/couchbase/gometa/common"
 	"github.com/couchbase/gometa/message"
 	"github.com/couchbase/gometa/protocol"
 	"github.com/couchbase/indexing/secondary/common"
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
 	"github.com/couchbase/indexing/secondary/manager/client"
 	//"runtime/debug"
 )
@@ -27,6 +29,8 @@ type LifecycleMgr struct {
 	incomings chan *requestHolder
 	outgoings chan c.Packet
 	killch    chan bool
+	states    *common.IndexStateMachine
+	reserved  *nameReservations // index names reserved for creation
 }
 
 type requestHolder struct {
@@ -49,7 +53,13 @@ func NewLifecycleMgr(scanport string, notifier MetadataNotifier) *LifecycleMgr {
 		notifier:  notifier,
 		incomings: make(chan *requestHolder, 1000),
 		outgoings: make(chan c.Packet, 1000),
-		killch:    make(chan bool)}
+		killch:    make(chan bool),
+		states:    common.NewIndexStateMachine(),
+		reserved:  newNameReservations(INDEX_NAME_RESERVATION_EXPIRY)}
+
+	mgr.states.AddHook(func(instId common.IndexInstId, from, to common.IndexState) {
+		common.Debugf("LifecycleMgr: index inst %v state changes from %v to %v", instId, from, to)
+	})
 
 	return mgr
 }
@@ -131,6 +141,14 @@ func (m *LifecycleMgr) dispatchRequest(request *requestHolder, factory *message.
 		err = m.handleDeleteIndex(key)
 	case client.OPCODE_BUILD_INDEX:
 		err = m.handleBuildIndexes(content, m.scanport)
+	case client.OPCODE_RESYNC_KEY:
+		err = m.handleResyncKey(key)
+	case client.OPCODE_DROP_INDEXES_BY_BUCKET:
+		err = m.DeleteIndexesByBucket(key)
+	case client.OPCODE_RESERVE_INDEX_NAME:
+		err = m.handleReserveIndexName(content)
+	case client.OPCODE_RELEASE_INDEX_NAME:
+		err = m.handleReleaseIndexName(content)
 	}
 
 	common.Debugf("LifecycleMgr.dispatchRequest () : send response for requestId %d", reqId)
@@ -146,7 +164,7 @@ func (m *LifecycleMgr) dispatchRequest(request *requestHolder, factory *message.
 
 func (m *LifecycleMgr) handleCreateIndex(key string, content []byte, scanport string) error {
 
-	defn, err := common.UnmarshallIndexDefn(content)
+	defn, err := upgradeIndexDefn(content)
 	if err != nil {
 		common.Errorf("LifecycleMgr.handleCreateIndex() : createIndex fails. Unable to unmarshall index definition. Reason = %v", err)
 		return err
@@ -157,6 +175,19 @@ func (m *LifecycleMgr) handleCreateIndex(key string, content []byte, scanport st
 
 func (m *LifecycleMgr) CreateIndex(defn *common.IndexDefn, scanport string) error {
 
+	// the index guards its own name once created, the reservation made
+	// for it is not needed either way.
+	defer m.reserved.release(defn.Bucket, defn.Name, defn.DefnId)
+
+	if err := m.checkIndexName(defn.Bucket, defn.Name, defn.DefnId); err != nil {
+		common.Errorf("LifecycleMgr.handleCreateIndex() : createIndex fails. Reason = %v", err)
+		return err
+	}
+
+	if defn.BucketUUID == "" {
+		defn.BucketUUID = getBucketUUID(defn.Bucket)
+	}
+
 	if err := m.repo.CreateIndex(defn); err != nil {
 		common.Errorf("LifecycleMgr.handleCreateIndex() : createIndex fails. Reason = %v", err)
 		return err
@@ -203,6 +234,84 @@ func (m *LifecycleMgr) CreateIndex(defn *common.IndexDefn, scanport string) erro
 	return nil
 }
 
+func (m *LifecycleMgr) handleReserveIndexName(content []byte) error {
+
+	reservation, err := client.UnmarshallIndexNameReservation(content)
+	if err != nil {
+		common.Errorf("LifecycleMgr.handleReserveIndexName() : reserveIndexName fails. Unable to unmarshall reservation. Reason = %v", err)
+		return err
+	}
+
+	return m.ReserveIndexName(reservation.Bucket, reservation.Name, common.IndexDefnId(reservation.DefnId))
+}
+
+// ReserveIndexName reserves name on bucket for the index definition defnId
+// about to be created, on this or another indexer.
+// - return client.ErrDuplicateIndexName if name is taken or reserved.
+func (m *LifecycleMgr) ReserveIndexName(bucket, name string, defnId common.IndexDefnId) error {
+
+	m.reserved.purge()
+
+	if err := m.checkIndexName(bucket, name, defnId); err != nil {
+		common.Errorf("LifecycleMgr.ReserveIndexName() : index %v on bucket %v cannot be reserved. Reason = %v", name, bucket, err)
+		return err
+	}
+
+	common.Debugf("LifecycleMgr.ReserveIndexName() : index %v on bucket %v reserved for %v", name, bucket, defnId)
+	return nil
+}
+
+func (m *LifecycleMgr) handleReleaseIndexName(content []byte) error {
+
+	reservation, err := client.UnmarshallIndexNameReservation(content)
+	if err != nil {
+		common.Errorf("LifecycleMgr.handleReleaseIndexName() : releaseIndexName fails. Unable to unmarshall reservation. Reason = %v", err)
+		return err
+	}
+
+	m.reserved.release(reservation.Bucket, reservation.Name, common.IndexDefnId(reservation.DefnId))
+	return nil
+}
+
+// checkIndexName verifies that name on bucket is neither taken by another
+// index nor reserved for another index definition, and reserves it for
+// defnId.
+func (m *LifecycleMgr) checkIndexName(bucket, name string, defnId common.IndexDefnId) error {
+
+	if m.hasIndexName(bucket, name, defnId) {
+		return client.ErrDuplicateIndexName
+	}
+
+	if !m.reserved.reserve(bucket, name, defnId) {
+		return client.ErrDuplicateIndexName
+	}
+
+	return nil
+}
+
+// hasIndexName returns whether an index definition other than defnId, that
+// is not deleted, has name on bucket.
+func (m *LifecycleMgr) hasIndexName(bucket, name string, defnId common.IndexDefnId) bool {
+
+	topology, err := m.repo.GetTopologyByBucket(bucket)
+	if err != nil || topology == nil {
+		// no index is defined on bucket.
+		return false
+	}
+
+	for _, defnRef := range topology.Definitions {
+		if defnRef.Name != name || defnRef.DefnId == uint64(defnId) {
+			continue
+		}
+		inst := topology.GetIndexInstByDefn(common.IndexDefnId(defnRef.DefnId))
+		if inst == nil || common.IndexState(inst.State) != common.INDEX_STATE_DELETED {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (m *LifecycleMgr) handleBuildIndexes(content []byte, scanport string) error {
 
 	list, err := client.UnmarshallIndexIdList(content)
@@ -273,6 +382,100 @@ func (m *LifecycleMgr) DeleteIndex(id common.IndexDefnId) error {
 	return nil
 }
 
+// DeleteIndexesByBucket drops all indexes defined on bucket, used when the
+// bucket is flushed or recreated.  All index instances of the bucket are
+// marked deleted with a single topology update, before any of them is
+// cleaned up, so that no index of the bucket is seen live while the rest
+// are being dropped.  The request is idempotent, if cleanup fails the
+// indexes stay marked deleted and a retry resumes the cleanup.
+func (m *LifecycleMgr) DeleteIndexesByBucket(bucket string) error {
+
+	topology, err := m.repo.GetTopologyByBucket(bucket)
+	if err != nil {
+		common.Errorf("LifecycleMgr.DeleteIndexesByBucket() : deleteIndexes fails. Reason = %v", err)
+		return err
+	}
+
+	// no index is defined on bucket.
+	if topology == nil || len(topology.Definitions) == 0 {
+		return nil
+	}
+
+	// indexes already marked deleted by an earlier attempt stay deleted.
+	ids := make([]common.IndexDefnId, 0, len(topology.Definitions))
+	for _, defnRef := range topology.Definitions {
+		id := common.IndexDefnId(defnRef.DefnId)
+		if err := m.transitionIndexState(topology, id, common.INDEX_STATE_DELETED); err != nil {
+			common.Errorf("LifecycleMgr.DeleteIndexesByBucket() : deleteIndexes fails. Reason = %v", err)
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := m.repo.SetTopologyByBucket(bucket, topology); err != nil {
+		common.Errorf("LifecycleMgr.DeleteIndexesByBucket() : deleteIndexes fails. Reason = %v", err)
+		return err
+	}
+
+	for _, id := range ids {
+		if m.notifier != nil {
+			m.notifier.OnIndexDelete(id)
+		}
+		if err := m.repo.DropIndexById(id); err != nil && !isIndexDefnNotExist(err) {
+			common.Errorf("LifecycleMgr.DeleteIndexesByBucket() : cleanup of index %v fails. Reason = %v", id, err)
+			return err
+		}
+	}
+
+	// forget the dropped definitions with a single topology update.
+	for _, id := range ids {
+		topology.RemoveIndexDefinitionById(id)
+	}
+	if err := m.repo.SetTopologyByBucket(bucket, topology); err != nil {
+		common.Errorf("LifecycleMgr.DeleteIndexesByBucket() : cleanup of topology fails. Reason = %v", err)
+		return err
+	}
+
+	common.Debugf("LifecycleMgr.DeleteIndexesByBucket() : dropped %d indexes of bucket %v", len(ids), bucket)
+
+	return nil
+}
+
+// isIndexDefnNotExist returns whether err is returned for an index
+// definition that does not exist, like one dropped by an earlier attempt.
+func isIndexDefnNotExist(err error) bool {
+	e, ok := err.(Error)
+	return ok && e.code == ERROR_META_IDX_DEFN_NOT_EXIST
+}
+
+// handleResyncKey re-broadcasts the stored value of an index definition or
+// topology key, after a watcher rejected a corrupted or truncated payload
+// for the key.
+func (m *LifecycleMgr) handleResyncKey(key string) error {
+
+	if !isIndexDefnKey(key) && !isIndexTopologyKey(key) {
+		return fmt.Errorf("cannot re-sync metadata key %v", key)
+	}
+
+	data, err := m.repo.getMeta(key)
+	if err != nil {
+		common.Errorf("LifecycleMgr.handleResyncKey() : re-sync of key %v fails. Reason = %v", key, err)
+		return err
+	}
+
+	// key is deleted, watcher is notified of the delete.
+	if data == nil {
+		return nil
+	}
+
+	if _, err := common.VerifyChecksum(data); err != nil {
+		common.Errorf("LifecycleMgr.handleResyncKey() : stored metadata for key %v is corrupted.", key)
+		return err
+	}
+
+	return m.repo.setMeta(key, data)
+}
+
 func (m *LifecycleMgr) handleTopologyChange(content []byte) error {
 
 	change := new(topologyChange)
@@ -294,7 +497,10 @@ func (m *LifecycleMgr) UpdateIndexInstance(bucket string, defnId common.IndexDef
 	}
 
 	if state != common.INDEX_STATE_NIL {
-		topology.UpdateStateForIndexInstByDefn(common.IndexDefnId(defnId), common.IndexState(state))
+		if err := m.transitionIndexState(topology, defnId, state); err != nil {
+			common.Errorf("LifecycleMgr.handleTopologyChange() : index instance update fails. Reason = %v", err)
+			return err
+		}
 	}
 	
 	if streamId != common.NIL_STREAM {
@@ -319,7 +525,10 @@ func (m *LifecycleMgr) updateIndexState(bucket string, defnId common.IndexDefnId
 		return err
 	}
 
-	topology.UpdateStateForIndexInstByDefn(defnId, state)
+	if err := m.transitionIndexState(topology, defnId, state); err != nil {
+		common.Errorf("LifecycleMgr.updateIndexState() : fail to update state of index instance.  Reason = %v", err)
+		return err
+	}
 
 	if err := m.repo.SetTopologyByBucket(bucket, topology); err != nil {
 		common.Errorf("LifecycleMgr.updateIndexState() : fail to update state of index instance.  Reason = %v", err)
@@ -328,3 +537,34 @@ func (m *LifecycleMgr) updateIndexState(bucket string, defnId common.IndexDefnId
 
 	return nil
 }
+
+// transitionIndexState moves the index instance of a definition to a new
+// state in topology, if the transition is legal from its current state.
+func (m *LifecycleMgr) transitionIndexState(topology *IndexTopology, defnId common.IndexDefnId,
+	state common.IndexState) error {
+
+	if inst := topology.GetIndexInstByDefn(defnId); inst != nil {
+		from := common.IndexState(inst.State)
+		if err := m.states.Transition(common.IndexInstId(inst.InstId), from, state); err != nil {
+			return fmt.Errorf("%v: index %v from %v to %v", err, defnId, from, state)
+		}
+	}
+
+	topology.UpdateStateForIndexInstByDefn(defnId, state)
+	return nil
+}
+
+// getBucketUUID returns the uuid of bucket, used to detect the bucket being
+// flushed or recreated after an index is defined on it. Returns empty string
+// if bucket cannot be reached, in which case the check is skipped.
+func getBucketUUID(bucket string) string {
+
+	bucketRef, err := couchbase.GetBucket(COUCHBASE_INTERNAL_BUCKET_URL, DEFAULT_POOL_NAME, bucket)
+	if err != nil {
+		common.Warnf("LifecycleMgr.getBucketUUID() : unable to get uuid of bucket %v. Reason = %v", bucket, err)
+		return ""
+	}
+	defer bucketRef.Close()
+
+	return bucketRef.UUID
+}
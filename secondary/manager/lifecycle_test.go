@@ -0,0 +1,124 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"errors"
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+)
+
+// fakeRepoRef is an in-memory RepoRef, deletes fail while failDelete is set.
+type fakeRepoRef struct {
+	values     map[string][]byte
+	failDelete bool
+}
+
+func newFakeRepo() (*MetadataRepo, *fakeRepoRef) {
+	ref := &fakeRepoRef{values: make(map[string][]byte)}
+	return &MetadataRepo{repo: ref}, ref
+}
+
+func (r *fakeRepoRef) getMeta(name string) ([]byte, error) {
+	return r.values[name], nil
+}
+
+func (r *fakeRepoRef) setMeta(name string, value []byte) error {
+	r.values[name] = value
+	return nil
+}
+
+func (r *fakeRepoRef) deleteMeta(name string) error {
+	if r.failDelete {
+		return errors.New("delete failed")
+	}
+	delete(r.values, name)
+	return nil
+}
+
+func (r *fakeRepoRef) newIterator() (*MetaIterator, error)           { return nil, nil }
+func (r *fakeRepoRef) registerNotifier(notifier MetadataNotifier)    {}
+func (r *fakeRepoRef) setLocalValue(name string, value string) error { return nil }
+func (r *fakeRepoRef) getLocalValue(name string) (string, error)     { return "", nil }
+func (r *fakeRepoRef) deleteLocalValue(name string) error            { return nil }
+func (r *fakeRepoRef) close()                                        {}
+
+func addTestIndex(t *testing.T, repo *MetadataRepo, bucket, name string, id common.IndexDefnId) {
+	defn := &common.IndexDefn{DefnId: id, Name: name, Using: common.ForestDB, Bucket: bucket}
+	if err := repo.CreateIndex(defn); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.addIndexToTopology(defn, common.IndexInstId(id), "localhost:9100"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteIndexesByBucket(t *testing.T) {
+
+	repo, ref := newFakeRepo()
+	addTestIndex(t, repo, "default", "idx1", 1)
+	addTestIndex(t, repo, "default", "idx2", 2)
+	addTestIndex(t, repo, "other", "idx3", 3)
+
+	mgr := NewLifecycleMgr("", nil)
+	mgr.repo = repo
+
+	// cleanup fails, indexes of the bucket stay marked deleted.
+	ref.failDelete = true
+	if err := mgr.DeleteIndexesByBucket("default"); err == nil {
+		t.Fatalf("expected cleanup to fail")
+	}
+	topology, err := repo.GetTopologyByBucket("default")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(topology.Definitions) != 2 {
+		t.Fatalf("expected 2 definitions, got %v", len(topology.Definitions))
+	}
+	for _, id := range []common.IndexDefnId{1, 2} {
+		inst := topology.GetIndexInstByDefn(id)
+		if state := common.IndexState(inst.State); state != common.INDEX_STATE_DELETED {
+			t.Fatalf("expected index %v to be deleted, got %v", id, state)
+		}
+	}
+
+	// retry resumes the cleanup.
+	ref.failDelete = false
+	if err := mgr.DeleteIndexesByBucket("default"); err != nil {
+		t.Fatal(err)
+	}
+	if topology, err = repo.GetTopologyByBucket("default"); err != nil {
+		t.Fatal(err)
+	} else if len(topology.Definitions) != 0 {
+		t.Fatalf("expected no definitions, got %v", topology.Definitions)
+	}
+	for _, id := range []common.IndexDefnId{1, 2} {
+		if defn, _ := repo.GetIndexDefnById(id); defn != nil {
+			t.Fatalf("expected index %v to be dropped", id)
+		}
+	}
+
+	// dropping again is a no-op.
+	if err := mgr.DeleteIndexesByBucket("default"); err != nil {
+		t.Fatal(err)
+	}
+
+	// indexes of other buckets are left alone.
+	if defn, err := repo.GetIndexDefnById(3); err != nil || defn == nil {
+		t.Fatalf("expected index 3 to exist, got %v", err)
+	}
+	topology, err = repo.GetTopologyByBucket("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst := topology.GetIndexInstByDefn(3)
+	if state := common.IndexState(inst.State); state != common.INDEX_STATE_CREATED {
+		t.Fatalf("expected index 3 to be created, got %v", state)
+	}
+}
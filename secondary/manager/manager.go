@@ -17,7 +17,6 @@ import (
 	gometaL "github.com/couchbase/gometa/log"
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/manager/client"
-	"path/filepath"
 	"sync"
 	"time"
 	"os"
@@ -38,6 +37,7 @@ type IndexManager struct {
 	dataport      string
 	requestServer RequestServer
 	basepath      string
+	compactor     *metaCompactor
 
 	// stream management
 	streamMgr *StreamManager
@@ -163,16 +163,33 @@ func NewIndexManagerInternal(msgAddr string,
 	//mgr.repo, err = NewMetadataRepo(requestAddr, leaderAddr, config, mgr)
 	mgr.basepath = config["storage_dir"].String()
 	os.Mkdir(mgr.basepath, 0755)	
-	repoName := filepath.Join(mgr.basepath, gometaC.REPOSITORY_NAME)
+	repoName := metaRepoFile(mgr.basepath)
 	mgr.repo, mgr.requestServer, err = NewLocalMetadataRepo(msgAddr, mgr.eventMgr, mgr.lifecycleMgr, repoName)
 	if err != nil {
 		mgr.Close()
 		return nil, err
 	}
 
+	// Compact the metadata repository on schedule, or on admin request.
+	if handle, ok := mgr.repo.compactionHandle(); ok {
+		mgr.compactor = newMetaCompactor(handle, repoName, config)
+	} else {
+		common.Warnf("IndexManager: metadata repository %v cannot be compacted", repoName)
+	}
+
+	// Upgrade metadata persisted by older versions, before it is served.
+	if err := mgr.repo.upgradeMetadataRepo(); err != nil {
+		mgr.Close()
+		return nil, err
+	}
+
 	// start lifecycle manager
 	mgr.lifecycleMgr.Run(mgr.repo)
 
+	// serve metadata over http to providers that cannot reach the watcher port
+	registerSnapshotHandler(mgr)
+	registerMetaCompactHandler(mgr)
+
 	// Initialize request handler.  This is non-blocking.  The index manager
 	// will not be able handle new request until request handler is done initialization.
 	//mgr.reqHandler, err = NewRequestHandler(mgr)
@@ -236,6 +253,13 @@ func (m *IndexManager) Close() {
 
 	m.stopMasterServiceNoLock()
 
+	unregisterSnapshotHandler(m)
+	unregisterMetaCompactHandler(m)
+
+	if m.compactor != nil {
+		m.compactor.close()
+	}
+
 	if m.repo != nil {
 		m.repo.Close()
 	}
@@ -403,6 +427,15 @@ func (m *IndexManager) HandleDeleteIndexDDL(defnId common.IndexDefnId) error {
 	return nil
 }
 
+//
+// Reserve the name of an index about to be created on bucket, so that an
+// index of the same name cannot be created on this node meanwhile.  Index
+// names are normally reserved by MetadataProvider before creating an index.
+//
+func (m *IndexManager) ReserveIndexName(bucket string, name string, defnId common.IndexDefnId) error {
+	return m.lifecycleMgr.ReserveIndexName(bucket, name, defnId)
+}
+
 func (m *IndexManager) UpdateIndexInstance(bucket string, defnId common.IndexDefnId, state common.IndexState,
 	streamId common.StreamId, err string) error {
 
@@ -446,6 +479,77 @@ func (m *IndexManager) GetGlobalTopology() (*GlobalTopology, error) {
 	return m.repo.GetGlobalTopology()
 }
 
+///////////////////////////////////////////////////////
+// public function - Index Template
+///////////////////////////////////////////////////////
+
+//
+// Create or replace an index template
+//
+func (m *IndexManager) SetIndexTemplate(template *IndexTemplate) error {
+
+	if err := template.Validate(); err != nil {
+		return err
+	}
+	return m.repo.SetIndexTemplate(template)
+}
+
+func (m *IndexManager) GetIndexTemplate(name string) (*IndexTemplate, error) {
+
+	return m.repo.GetIndexTemplate(name)
+}
+
+func (m *IndexManager) GetIndexTemplates() ([]*IndexTemplate, error) {
+
+	return m.repo.GetIndexTemplates()
+}
+
+func (m *IndexManager) DeleteIndexTemplate(name string) error {
+
+	return m.repo.DeleteIndexTemplate(name)
+}
+
+//
+// Create the indexes of a template, substituting params.  Either all
+// indexes are created or, if creating one of them fails, those already
+// created are dropped and the error is returned.
+//
+func (m *IndexManager) CreateIndexesFromTemplate(name string, params map[string]string) ([]*common.IndexDefn, error) {
+
+	template, err := m.repo.GetIndexTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := template.Instantiate(params)
+	if err != nil {
+		return nil, err
+	}
+
+	defns := make([]*common.IndexDefn, 0, len(indexes))
+	for i := range indexes {
+		defnId, err := common.NewIndexDefnId()
+		if err == nil {
+			defn := templateIndexDefn(&indexes[i], defnId)
+			if err = m.HandleCreateIndexDDL(defn); err == nil {
+				defns = append(defns, defn)
+				continue
+			}
+		}
+
+		common.Errorf("IndexManager.CreateIndexesFromTemplate(): fail to create index %v from template %v, err %v",
+			indexes[i].Name, name, err)
+		for _, defn := range defns {
+			if err := m.HandleDeleteIndexDDL(defn.DefnId); err != nil {
+				common.Errorf("IndexManager.CreateIndexesFromTemplate(): fail to drop index %v, err %v", defn.Name, err)
+			}
+		}
+		return nil, err
+	}
+
+	return defns, nil
+}
+
 ///////////////////////////////////////////////////////
 // public function - Timestamp Operation
 ///////////////////////////////////////////////////////
@@ -0,0 +1,332 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"fmt"
+	gometaC "github.com/couchbase/gometa/common"
+	"github.com/couchbase/indexing/secondary/common"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+// Admin endpoint, served by the indexer's http server, that returns the
+// compaction stats of the metadata repository on GET and compacts the
+// repository on POST.
+const METADATA_COMPACT_PATH = "/compactMetadata"
+
+// MetaCompactionStats is the size of the metadata repository file along
+// with the outcome of its last compaction.
+type MetaCompactionStats struct {
+	File           string `json:"file,omitempty"`
+	DiskSize       int64  `json:"diskSize,omitempty"`
+	DataSize       int64  `json:"dataSize,omitempty"`
+	NumCompactions int64  `json:"numCompactions,omitempty"`
+	LastCompaction string `json:"lastCompaction,omitempty"`
+	LastDuration   int64  `json:"lastDuration,omitempty"` // in milliseconds
+	LastReclaimed  int64  `json:"lastReclaimed,omitempty"`
+	LastError      string `json:"lastError,omitempty"`
+}
+
+// metaRepoHandle is the handle of the metadata repository owned by the
+// embedded gometa server. Compaction goes through this handle, so that
+// gometa switches to the new revision of the file and the previous
+// revision is removed by forestdb once it is no longer referenced.
+type metaRepoHandle interface {
+	// Compact the repository into the file at `newpath`.
+	Compact(newpath string) error
+	// EstimateSpaceUsed by live metadata in the repository file.
+	EstimateSpaceUsed() uint64
+}
+
+// metaCompactor compacts the metadata repository. The repository is a
+// forestdb file appended with every metadata change, so that DDL churn
+// grows it without bound and slows down the manager on restart.
+// Compaction writes the live metadata to the next revision of the file,
+// refer metaRepoFile.
+type metaCompactor struct {
+	mutex    sync.Mutex
+	handle   metaRepoHandle
+	path     string // current revision of the repository file
+	interval time.Duration
+	minFrag  int
+	minSize  uint64
+	stats    MetaCompactionStats
+	stopch   chan bool
+	donech   chan bool
+}
+
+type metaCompactHandler struct {
+	initializer sync.Once
+	mgr         *IndexManager
+	mutex       sync.Mutex
+}
+
+var metaCompactServer metaCompactHandler
+
+///////////////////////////////////////////////////////
+// private function : metaCompactor
+///////////////////////////////////////////////////////
+
+// newMetaCompactor compacts the repository file at `path` through
+// `handle`. If the interval of metadata compaction is configured, the
+// repository is compacted on schedule whenever it is fragmented beyond
+// the limits.
+func newMetaCompactor(handle metaRepoHandle, path string, config common.Config) *metaCompactor {
+
+	cfg := config.SectionConfig("metadata.compaction.", true)
+	c := &metaCompactor{
+		handle:   handle,
+		path:     path,
+		interval: time.Duration(cfg["interval"].Int()) * time.Second,
+		minFrag:  cfg["minFrag"].Int(),
+		minSize:  cfg["minSize"].Uint64(),
+		stopch:   make(chan bool),
+		donech:   make(chan bool),
+	}
+
+	if c.interval > 0 {
+		go c.run()
+	} else {
+		close(c.donech)
+	}
+	return c
+}
+
+func (c *metaCompactor) run() {
+	defer close(c.donech)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := c.Compact(false); err != nil {
+				common.Errorf("metaCompactor.run(): fail to compact metadata repository. Reason = %v", err)
+			}
+		case <-c.stopch:
+			return
+		}
+	}
+}
+
+// Compact the repository if it is fragmented beyond the configured
+// limits, or unconditionally if `force` is true. Returns the stats
+// after compaction.
+func (c *metaCompactor) Compact(force bool) (MetaCompactionStats, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.handle == nil {
+		return c.stats, NewError4(ERROR_META_REPO_CLOSED, NORMAL, METADATA_REPO,
+			"Metadata repository is closed")
+	}
+
+	c.refreshStats()
+	if !force && !c.needsCompaction() {
+		return c.stats, nil
+	}
+
+	oldpath, oldsize := c.path, c.stats.DiskSize
+	newpath := nextMetaRepoFile(oldpath)
+
+	common.Infof("metaCompactor.Compact(): compacting metadata repository %v (Data:%v, Disk:%v)",
+		oldpath, c.stats.DataSize, c.stats.DiskSize)
+
+	start := time.Now()
+	err := c.handle.Compact(newpath)
+	c.stats.LastCompaction = start.Format(time.RFC3339)
+	c.stats.LastDuration = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		c.stats.LastError = err.Error()
+		return c.stats, err
+	}
+
+	c.path = newpath
+	c.refreshStats()
+	c.stats.NumCompactions++
+	c.stats.LastReclaimed = oldsize - c.stats.DiskSize
+	c.stats.LastError = ""
+
+	common.Infof("metaCompactor.Compact(): compacted metadata repository to %v, reclaimed %v bytes in %vms",
+		newpath, c.stats.LastReclaimed, c.stats.LastDuration)
+
+	return c.stats, nil
+}
+
+// Stats returns the current size of the repository.
+func (c *metaCompactor) Stats() MetaCompactionStats {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.handle != nil {
+		c.refreshStats()
+	}
+	return c.stats
+}
+
+func (c *metaCompactor) refreshStats() {
+
+	c.stats.File = c.path
+	if info, err := os.Stat(c.path); err == nil {
+		c.stats.DiskSize = info.Size()
+	}
+	c.stats.DataSize = int64(c.handle.EstimateSpaceUsed())
+}
+
+// needsCompaction returns whether the percentage of the repository file
+// not used by live metadata has reached minFrag.
+func (c *metaCompactor) needsCompaction() bool {
+
+	disk, data := c.stats.DiskSize, c.stats.DataSize
+	if disk <= 0 || uint64(disk) < c.minSize {
+		return false
+	}
+	frag := (disk - data) * 100 / disk
+	return frag >= int64(c.minFrag)
+}
+
+func (c *metaCompactor) close() {
+
+	close(c.stopch)
+	<-c.donech
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// the handle is owned, and closed, by the embedded gometa server.
+	c.handle = nil
+}
+
+// metaRepoFile returns the latest revision of the metadata repository
+// under `basepath`. The repository is created as gometa's
+// REPOSITORY_NAME, every compaction writes the next revision
+// REPOSITORY_NAME.<n>. Revisions that are no longer referenced are
+// removed by forestdb.
+func metaRepoFile(basepath string) string {
+
+	path := filepath.Join(basepath, gometaC.REPOSITORY_NAME)
+	latest := path
+
+	files, _ := filepath.Glob(path + ".*")
+	version := 0
+	for _, file := range files {
+		if v, ok := metaRepoVersion(path, file); ok && v > version {
+			latest, version = file, v
+		}
+	}
+	return latest
+}
+
+// nextMetaRepoFile returns the revision of the repository file that
+// follows `current`.
+func nextMetaRepoFile(current string) string {
+
+	path, version := current, 0
+	if i := strings.LastIndex(current, "."); i > 0 {
+		if v, ok := metaRepoVersion(current[:i], current); ok {
+			path, version = current[:i], v
+		}
+	}
+	return fmt.Sprintf("%s.%d", path, version+1)
+}
+
+// metaRepoVersion returns the revision of `file`, if it is a revision of
+// the repository file at `path`.
+func metaRepoVersion(path, file string) (int, bool) {
+
+	if !strings.HasPrefix(file, path+".") {
+		return 0, false
+	}
+	v, err := strconv.Atoi(file[len(path)+1:])
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+	return v, true
+}
+
+///////////////////////////////////////////////////////
+// private function : admin endpoint
+///////////////////////////////////////////////////////
+
+// registerMetaCompactHandler registers METADATA_COMPACT_PATH with the
+// default http mux, served by the indexer's http server.
+func registerMetaCompactHandler(mgr *IndexManager) {
+
+	metaCompactServer.initializer.Do(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				common.Warnf("error encountered when registering http metadata compaction handler : %v.  Ignored.\n", r)
+			}
+		}()
+
+		http.HandleFunc(METADATA_COMPACT_PATH, metaCompactServer.compactRequest)
+	})
+
+	metaCompactServer.mutex.Lock()
+	defer metaCompactServer.mutex.Unlock()
+	metaCompactServer.mgr = mgr
+}
+
+func unregisterMetaCompactHandler(mgr *IndexManager) {
+
+	metaCompactServer.mutex.Lock()
+	defer metaCompactServer.mutex.Unlock()
+
+	if metaCompactServer.mgr == mgr {
+		metaCompactServer.mgr = nil
+	}
+}
+
+func (h *metaCompactHandler) compactRequest(w http.ResponseWriter, r *http.Request) {
+
+	h.mutex.Lock()
+	mgr := h.mgr
+	h.mutex.Unlock()
+
+	if mgr == nil || mgr.IsClose() || mgr.compactor == nil {
+		sendHttpError(w, "Index manager is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		sendResponse(w, mgr.compactor.Stats())
+
+	case "POST":
+		if ok, err := common.IsAdminRequest(r); err != nil || !ok {
+			common.Errorf("metaCompactHandler.compactRequest(): unauthorized request. Reason = %v", err)
+			sendHttpError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		stats, err := mgr.compactor.Compact(true)
+		if err != nil {
+			common.Errorf("metaCompactHandler.compactRequest(): fail to compact metadata repository. Reason = %v", err)
+			sendHttpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sendResponse(w, stats)
+
+	default:
+		sendHttpError(w, "Unsupported method "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"errors"
+	gometaC "github.com/couchbase/gometa/common"
+	"github.com/couchbase/indexing/secondary/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeRepoHandle compacts by writing `data` bytes to the new revision and
+// removing the previous one, like forestdb does.
+type fakeRepoHandle struct {
+	path string
+	data int
+	err  error
+}
+
+func (h *fakeRepoHandle) Compact(newpath string) error {
+	if h.err != nil {
+		return h.err
+	}
+	if err := ioutil.WriteFile(newpath, make([]byte, h.data), 0644); err != nil {
+		return err
+	}
+	os.Remove(h.path)
+	h.path = newpath
+	return nil
+}
+
+func (h *fakeRepoHandle) EstimateSpaceUsed() uint64 {
+	return uint64(h.data)
+}
+
+func TestMetaRepoVersion(t *testing.T) {
+
+	path := filepath.Join("data", gometaC.REPOSITORY_NAME)
+	testcases := []struct {
+		file    string
+		version int
+		ok      bool
+	}{
+		{path + ".1", 1, true},
+		{path + ".12", 12, true},
+		{path, 0, false},
+		{path + ".0", 0, false},
+		{path + ".-1", 0, false},
+		{path + ".x", 0, false},
+		{path + "x.1", 0, false},
+	}
+	for _, tc := range testcases {
+		if v, ok := metaRepoVersion(path, tc.file); v != tc.version || ok != tc.ok {
+			t.Errorf("%v: expected %v %v, got %v %v", tc.file, tc.version, tc.ok, v, ok)
+		}
+	}
+}
+
+func TestNextMetaRepoFile(t *testing.T) {
+
+	path := filepath.Join("data", gometaC.REPOSITORY_NAME)
+	testcases := map[string]string{
+		path:          path + ".1",
+		path + ".1":   path + ".2",
+		path + ".9":   path + ".10",
+		path + ".x":   path + ".x.1",
+		"data.1/repo": "data.1/repo.1",
+	}
+	for current, next := range testcases {
+		if s := nextMetaRepoFile(current); s != next {
+			t.Errorf("%v: expected %v, got %v", current, next, s)
+		}
+	}
+}
+
+func TestMetaRepoFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "metarepo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, gometaC.REPOSITORY_NAME)
+	if s := metaRepoFile(dir); s != path {
+		t.Fatalf("expected %v, got %v", path, s)
+	}
+	for _, name := range []string{".2", ".10", ".9", ".x", ".0"} {
+		if err := ioutil.WriteFile(path+name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := metaRepoFile(dir); s != path+".10" {
+		t.Fatalf("expected %v, got %v", path+".10", s)
+	}
+}
+
+func TestMetaCompactorNeedsCompaction(t *testing.T) {
+
+	c := &metaCompactor{minFrag: 50, minSize: 100}
+	testcases := []struct {
+		disk, data int64
+		needs      bool
+	}{
+		{0, 0, false},
+		{99, 0, false},     // below minSize
+		{1000, 501, false}, // 49% fragmented
+		{1000, 500, true},  // 50% fragmented
+		{1000, 0, true},
+		{1000, 1200, false}, // estimate beyond file size
+	}
+	for _, tc := range testcases {
+		c.stats.DiskSize, c.stats.DataSize = tc.disk, tc.data
+		if needs := c.needsCompaction(); needs != tc.needs {
+			t.Errorf("disk %v data %v: expected %v, got %v", tc.disk, tc.data, tc.needs, needs)
+		}
+	}
+}
+
+func TestMetaCompactorCompact(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "metarepo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := metaRepoFile(dir)
+	if err := ioutil.WriteFile(path, make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	config.SetValue("metadata.compaction.interval", 0)
+	config.SetValue("metadata.compaction.minSize", uint64(100))
+	handle := &fakeRepoHandle{path: path, data: 800}
+	c := newMetaCompactor(handle, path, config)
+
+	// 20% fragmented, not compacted unless forced.
+	stats, err := c.Compact(false)
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.NumCompactions != 0 || handle.path != path {
+		t.Fatalf("unexpected compaction %+v", stats)
+	}
+
+	stats, err = c.Compact(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.File != path+".1" || handle.path != path+".1" {
+		t.Fatalf("expected compaction to %v, got %v", path+".1", stats.File)
+	} else if stats.NumCompactions != 1 || stats.LastReclaimed != 200 {
+		t.Fatalf("unexpected stats %+v", stats)
+	} else if stats.DiskSize != 800 || stats.DataSize != 800 {
+		t.Fatalf("unexpected size %+v", stats)
+	}
+	if s := metaRepoFile(dir); s != path+".1" {
+		t.Fatalf("expected %v to be the latest revision, got %v", path+".1", s)
+	}
+
+	handle.err = errors.New("compaction failed")
+	if stats, err = c.Compact(true); err != handle.err {
+		t.Fatalf("expected %v, got %v", handle.err, err)
+	} else if stats.LastError != handle.err.Error() || stats.File != path+".1" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	c.close()
+	if _, err := c.Compact(true); err == nil {
+		t.Fatalf("expected closed compactor to fail")
+	}
+}
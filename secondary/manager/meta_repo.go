@@ -75,6 +75,7 @@ const (
 	KIND_TOPOLOGY
 	KIND_GLOBAL_TOPOLOGY
 	KIND_STABILITY_TIMESTAMP
+	KIND_INDEX_TEMPLATE
 )
 
 ///////////////////////////////////////////////////////
@@ -135,6 +136,21 @@ func (c *MetadataRepo) GetLocalValue(key string) (string, error) {
 	return c.repo.getLocalValue(key)
 }
 
+// compactionHandle returns the handle of the repository owned by the
+// embedded gometa server, ok is false if the repository is remote or the
+// server does not support compaction of its repository.
+func (c *MetadataRepo) compactionHandle() (handle metaRepoHandle, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	local, ok := c.repo.(*LocalRepoRef)
+	if !ok || local.server == nil {
+		return nil, false
+	}
+	handle, ok = interface{}(local.server).(metaRepoHandle)
+	return handle, ok
+}
+
 func (c *MetadataRepo) Close() {
 
 	/*
@@ -269,6 +285,74 @@ func (c *MetadataRepo) SetGlobalTopology(topology *GlobalTopology) error {
 	return c.setMeta(lookupName, data)
 }
 
+///////////////////////////////////////////////////////
+//  Public Function : Index Template
+///////////////////////////////////////////////////////
+
+func (c *MetadataRepo) GetIndexTemplate(name string) (*IndexTemplate, error) {
+
+	lookupName := indexTemplateKey(name)
+	data, err := c.getMeta(lookupName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, NewError(ERROR_META_NO_TEMPLATE, NORMAL, METADATA_REPO, nil,
+			fmt.Sprintf("Index Template '%s' does not exist", name))
+	}
+
+	return unmarshallIndexTemplate(data)
+}
+
+func (c *MetadataRepo) SetIndexTemplate(template *IndexTemplate) error {
+
+	data, err := marshallIndexTemplate(template)
+	if err != nil {
+		return err
+	}
+
+	lookupName := indexTemplateKey(template.Name)
+	return c.setMeta(lookupName, data)
+}
+
+func (c *MetadataRepo) DeleteIndexTemplate(name string) error {
+
+	if _, err := c.GetIndexTemplate(name); err != nil {
+		return err
+	}
+
+	lookupName := indexTemplateKey(name)
+	return c.deleteMeta(lookupName)
+}
+
+func (c *MetadataRepo) GetIndexTemplates() ([]*IndexTemplate, error) {
+
+	iter, err := c.repo.newIterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var templates []*IndexTemplate
+	for {
+		key, content, err := iter.iterator.Next()
+		if err != nil {
+			break
+		}
+
+		if isIndexTemplateKey(key) {
+			template, err := unmarshallIndexTemplate(content)
+			if err != nil {
+				return nil, err
+			}
+			templates = append(templates, template)
+		}
+	}
+
+	return templates, nil
+}
+
 ///////////////////////////////////////////////////////
 //  Public Function : Index DDL
 ///////////////////////////////////////////////////////
@@ -630,7 +714,10 @@ func (c *MetadataRepo) deleteMeta(name string) error {
 
 func findTypeFromKey(key string) MetadataKind {
 
-	if isIndexDefnKey(key) {
+	// template names may contain other kinds of keys
+	if isIndexTemplateKey(key) {
+		return KIND_INDEX_TEMPLATE
+	} else if isIndexDefnKey(key) {
 		return KIND_INDEX_DEFN
 	} else if isIndexTopologyKey(key) {
 		return KIND_TOPOLOGY
@@ -646,6 +733,19 @@ func findTypeFromKey(key string) MetadataKind {
 // package local function : Index Definition
 ///////////////////////////////////////////////////////
 
+// upgradeIndexDefn unmarshalls definitions sent by nodes and clients not
+// yet upgraded, upgrading those of older schema versions. Persisted
+// definitions are upgraded once on startup and read as is.
+func upgradeIndexDefn(data []byte) (*common.IndexDefn, error) {
+
+	data, _, err := upgradeMeta(KIND_INDEX_DEFN, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return common.UnmarshallIndexDefn(data)
+}
+
 func indexDefnIdStr(id common.IndexDefnId) string {
 	return strconv.FormatUint(uint64(id), 10)
 }
@@ -699,16 +799,22 @@ func isIndexTopologyKey(key string) bool {
 
 func MarshallIndexTopology(topology *IndexTopology) ([]byte, error) {
 
+	topology.SchemaVersion = TOPOLOGY_SCHEMA_VERSION
 	buf, err := json.Marshal(&topology)
 	if err != nil {
 		return nil, err
 	}
 
-	return buf, nil
+	return common.AddChecksum(buf), nil
 }
 
 func unmarshallIndexTopology(data []byte) (*IndexTopology, error) {
 
+	data, err := common.VerifyChecksum(data)
+	if err != nil {
+		return nil, err
+	}
+
 	topology := new(IndexTopology)
 	if err := json.Unmarshal(data, topology); err != nil {
 		return nil, err
@@ -727,6 +833,7 @@ func isGlobalTopologyKey(key string) bool {
 
 func marshallGlobalTopology(topology *GlobalTopology) ([]byte, error) {
 
+	topology.SchemaVersion = GLOBAL_TOPOLOGY_SCHEMA_VERSION
 	buf, err := json.Marshal(&topology)
 	if err != nil {
 		return nil, err
@@ -757,6 +864,18 @@ func isStabilityTimestampKey(key string) bool {
 	return strings.Contains(key, "StabilityTimestamp")
 }
 
+///////////////////////////////////////////////////////
+// package local function : Index Template
+///////////////////////////////////////////////////////
+
+func indexTemplateKey(name string) string {
+	return fmt.Sprintf("IndexTemplate/%s", name)
+}
+
+func isIndexTemplateKey(key string) bool {
+	return strings.HasPrefix(key, "IndexTemplate/")
+}
+
 ///////////////////////////////////////////////////////////
 // package local function : Index Definition and Topology
 ///////////////////////////////////////////////////////////
@@ -872,7 +991,7 @@ func (m *LocalRepoRef) onNewProposalForCreateIndexDefn(txnid c.Txnid, op c.OpCod
 
 	common.Debugf("LocalRepoRef.OnNewProposalForCreateIndexDefn(): key %s", key)
 
-	indexDefn, err := common.UnmarshallIndexDefn(content)
+	indexDefn, err := upgradeIndexDefn(content)
 	if err != nil {
 		common.Debugf("LocalRepoRef.OnNewProposalForCreateIndexDefn(): fail to unmarshall index defn for key %s", key)
 		return &c.RecoverableError{Reason: err.Error()}
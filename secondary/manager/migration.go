@@ -0,0 +1,193 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+///////////////////////////////////////////////////////
+// Metadata schema versions
+///////////////////////////////////////////////////////
+
+// Schema version of topologies marshalled by this version, topologies
+// persisted without a schema version are of version 0.
+const TOPOLOGY_SCHEMA_VERSION = 1
+const GLOBAL_TOPOLOGY_SCHEMA_VERSION = 1
+
+// metaMigration upgrades a persisted document of `kind` from schema
+// version `from` to `from+1`. Documents are upgraded in their JSON form,
+// so that a migration can rename or reinterpret fields that the current
+// structures no longer carry. A nil `upgrade` only bumps the version.
+type metaMigration struct {
+	kind    MetadataKind
+	from    uint32
+	desc    string
+	upgrade func(doc map[string]interface{}) error
+}
+
+// metaMigrations are applied in the order of their schema version, a
+// migration is added for every schema version bumped.
+var metaMigrations = []metaMigration{
+	{KIND_INDEX_DEFN, 0, "versioned index definition", nil},
+	{KIND_TOPOLOGY, 0, "versioned index topology", nil},
+	{KIND_GLOBAL_TOPOLOGY, 0, "versioned global topology", nil},
+}
+
+func metaSchemaVersion(kind MetadataKind) (uint32, bool) {
+	switch kind {
+	case KIND_INDEX_DEFN:
+		return common.IndexDefnSchemaVersion, true
+	case KIND_TOPOLOGY:
+		return TOPOLOGY_SCHEMA_VERSION, true
+	case KIND_GLOBAL_TOPOLOGY:
+		return GLOBAL_TOPOLOGY_SCHEMA_VERSION, true
+	}
+	return 0, false
+}
+
+// global topology is persisted without checksum.
+func isChecksummed(kind MetadataKind) bool {
+	return kind != KIND_GLOBAL_TOPOLOGY
+}
+
+///////////////////////////////////////////////////////
+// Public Function : Migration
+///////////////////////////////////////////////////////
+
+// UpgradeMetadata upgrades the document persisted under `key` to the
+// schema version of this version. Returns true along with the upgraded
+// document if it was of an older version. Documents of a newer version,
+// written by an upgraded node during rolling upgrade, are returned as is
+// since schema changes only add fields.
+func UpgradeMetadata(key string, data []byte) ([]byte, bool, error) {
+
+	return upgradeMeta(findTypeFromKey(key), data)
+}
+
+func upgradeMeta(kind MetadataKind, data []byte) ([]byte, bool, error) {
+
+	current, ok := metaSchemaVersion(kind)
+	if !ok {
+		return data, false, nil
+	}
+
+	payload, err := common.VerifyChecksum(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// numbers are kept as is, index definition ids do not fit a float64.
+	doc := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, false, err
+	}
+
+	version, err := docSchemaVersion(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	if version > current {
+		common.Debugf("UpgradeMetadata(): schema version %v is newer than %v, skipped", version, current)
+		return data, false, nil
+	} else if version == current {
+		return data, false, nil
+	}
+
+	for ; version < current; version++ {
+		m := findMigration(kind, version)
+		if m == nil {
+			return nil, false, NewError(ERROR_META_NO_MIGRATION, NORMAL, METADATA_REPO, nil,
+				fmt.Sprintf("No migration from schema version %v for metadata kind %v", version, kind))
+		}
+		if m.upgrade != nil {
+			if err := m.upgrade(doc); err != nil {
+				return nil, false, NewError(ERROR_META_NO_MIGRATION, NORMAL, METADATA_REPO, err,
+					fmt.Sprintf("Migration '%v' failed", m.desc))
+			}
+		}
+	}
+	doc["schemaVersion"] = current
+
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	if isChecksummed(kind) {
+		buf = common.AddChecksum(buf)
+	}
+	return buf, true, nil
+}
+
+func findMigration(kind MetadataKind, from uint32) *metaMigration {
+	for i := range metaMigrations {
+		if metaMigrations[i].kind == kind && metaMigrations[i].from == from {
+			return &metaMigrations[i]
+		}
+	}
+	return nil
+}
+
+func docSchemaVersion(doc map[string]interface{}) (uint32, error) {
+	value, ok := doc["schemaVersion"]
+	if !ok {
+		return 0, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("invalid schema version %v", value)
+	}
+	version, err := number.Int64()
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid schema version %v", value)
+	}
+	return uint32(version), nil
+}
+
+// upgradeMetadataRepo rewrites documents of older schema versions in the
+// repository. It is called on startup, before any listener registers
+// with the event manager, so the rewrites are not seen as new indexes.
+func (c *MetadataRepo) upgradeMetadataRepo() error {
+
+	iter, err := c.repo.newIterator()
+	if err != nil {
+		return err
+	}
+
+	upgraded := make(map[string][]byte)
+	for {
+		key, content, err := iter.iterator.Next()
+		if err != nil {
+			break
+		}
+		data, ok, err := UpgradeMetadata(key, content)
+		if err != nil {
+			common.Errorf("MetadataRepo.upgradeMetadataRepo(): unable to upgrade %v. Reason = %v", key, err)
+			continue
+		}
+		if ok {
+			upgraded[key] = data
+		}
+	}
+	iter.Close()
+
+	for key, data := range upgraded {
+		if err := c.setMeta(key, data); err != nil {
+			return err
+		}
+		common.Infof("MetadataRepo.upgradeMetadataRepo(): upgraded %v", key)
+	}
+	return nil
+}
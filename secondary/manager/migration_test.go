@@ -0,0 +1,47 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+)
+
+func TestUpgradeIndexDefn(t *testing.T) {
+
+	legacy := []byte(`{"defnId":7,"name":"by_city","using":"forestdb","bucket":"default"}`)
+
+	// definitions sent by nodes not yet upgraded are upgraded.
+	defn, err := upgradeIndexDefn(legacy)
+	if err != nil {
+		t.Fatal(err)
+	} else if defn.SchemaVersion != common.IndexDefnSchemaVersion || defn.Name != "by_city" {
+		t.Fatalf("unexpected definition %+v", defn)
+	}
+
+	// persisted definitions are upgraded on startup, not when read.
+	repo, ref := newFakeRepo()
+	ref.values[indexDefnKeyById(7)] = legacy
+	if defn, err = repo.GetIndexDefnById(7); err != nil {
+		t.Fatal(err)
+	} else if defn.SchemaVersion != 0 || defn.Name != "by_city" {
+		t.Fatalf("unexpected definition %+v", defn)
+	}
+
+	// definitions are persisted with the current schema version.
+	if err := repo.CreateIndex(&common.IndexDefn{DefnId: 8, Name: "by_zip"}); err != nil {
+		t.Fatal(err)
+	}
+	if defn, err = repo.GetIndexDefnById(8); err != nil {
+		t.Fatal(err)
+	} else if defn.SchemaVersion != common.IndexDefnSchemaVersion {
+		t.Fatalf("expected schema version %v, got %v", common.IndexDefnSchemaVersion, defn.SchemaVersion)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+// A reservation that is neither consumed by creating the index nor
+// released by the MetadataProvider, say because the provider went away,
+// is dropped after this long.
+const INDEX_NAME_RESERVATION_EXPIRY = 60 * time.Second
+
+// nameReservations tracks index names reserved on this indexer by
+// MetadataProviders for indexes they are about to create, keyed by
+// bucket and name.  A provider reserves the name with every indexer
+// before creating the index on one of them, so that two indexes of the
+// same name cannot be created concurrently through different providers.
+type nameReservations struct {
+	reservations map[string]*nameReservation
+	expiry       time.Duration
+	mutex        sync.Mutex
+}
+
+type nameReservation struct {
+	defnId  common.IndexDefnId
+	expires time.Time
+}
+
+///////////////////////////////////////////////////////
+// package local function
+///////////////////////////////////////////////////////
+
+func newNameReservations(expiry time.Duration) *nameReservations {
+	return &nameReservations{
+		reservations: make(map[string]*nameReservation),
+		expiry:       expiry,
+	}
+}
+
+// reserve name on bucket for defnId.  Returns false if the name is held
+// by an unexpired reservation of another index definition.  Reserving
+// again for the same definition extends the reservation.
+func (r *nameReservations) reserve(bucket, name string, defnId common.IndexDefnId) bool {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	key := reservationKey(bucket, name)
+	if held, ok := r.reservations[key]; ok && held.defnId != defnId && now.Before(held.expires) {
+		return false
+	}
+
+	r.reservations[key] = &nameReservation{defnId: defnId, expires: now.Add(r.expiry)}
+	return true
+}
+
+// release the reservation of name on bucket, if it is held by defnId.
+func (r *nameReservations) release(bucket, name string, defnId common.IndexDefnId) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := reservationKey(bucket, name)
+	if held, ok := r.reservations[key]; ok && held.defnId == defnId {
+		delete(r.reservations, key)
+	}
+}
+
+// purge drops expired reservations.
+func (r *nameReservations) purge() {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for key, held := range r.reservations {
+		if !now.Before(held.expires) {
+			delete(r.reservations, key)
+		}
+	}
+}
+
+// bucket names cannot contain '/', which makes the key unambiguous.
+func reservationKey(bucket, name string) string {
+	return bucket + "/" + name
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/manager/client"
+	"testing"
+	"time"
+)
+
+func TestNameReservations(t *testing.T) {
+
+	r := newNameReservations(time.Hour)
+	if !r.reserve("default", "idx", 1) {
+		t.Fatalf("expected idx to be reserved")
+	}
+	// reserving again for the same definition extends the reservation.
+	if !r.reserve("default", "idx", 1) {
+		t.Fatalf("expected idx to be reserved again for the same index")
+	}
+	if r.reserve("default", "idx", 2) {
+		t.Fatalf("expected idx to be held for index 1")
+	}
+	// names are reserved per bucket.
+	if !r.reserve("other", "idx", 2) {
+		t.Fatalf("expected idx to be reserved on another bucket")
+	}
+
+	// only the holder can release a reservation.
+	r.release("default", "idx", 2)
+	if r.reserve("default", "idx", 2) {
+		t.Fatalf("expected idx to be held after release by another index")
+	}
+	r.release("default", "idx", 1)
+	if !r.reserve("default", "idx", 2) {
+		t.Fatalf("expected idx to be reserved once released")
+	}
+}
+
+func TestNameReservationsExpiry(t *testing.T) {
+
+	r := newNameReservations(10 * time.Millisecond)
+	if !r.reserve("default", "idx", 1) {
+		t.Fatalf("expected idx to be reserved")
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// expired reservations can be taken over, and are purged.
+	if !r.reserve("default", "idx", 2) {
+		t.Fatalf("expected expired reservation to be taken over")
+	}
+	r.reserve("default", "idx2", 3)
+	time.Sleep(20 * time.Millisecond)
+	r.purge()
+	if n := len(r.reservations); n != 0 {
+		t.Fatalf("expected expired reservations to be purged, got %v", n)
+	}
+}
+
+func TestReserveIndexName(t *testing.T) {
+
+	repo, _ := newFakeRepo()
+	addTestIndex(t, repo, "default", "idx1", 1)
+
+	mgr := NewLifecycleMgr("", nil)
+	mgr.repo = repo
+
+	if err := mgr.ReserveIndexName("default", "idx1", 2); err != client.ErrDuplicateIndexName {
+		t.Fatalf("expected %v, got %v", client.ErrDuplicateIndexName, err)
+	}
+	// an index does not conflict with itself.
+	if err := mgr.ReserveIndexName("default", "idx1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.ReserveIndexName("other", "idx1", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mgr.ReserveIndexName("default", "idx2", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.ReserveIndexName("default", "idx2", 3); err != client.ErrDuplicateIndexName {
+		t.Fatalf("expected %v, got %v", client.ErrDuplicateIndexName, err)
+	}
+	mgr.reserved.release("default", "idx2", 2)
+	if err := mgr.ReserveIndexName("default", "idx2", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// names of deleted indexes can be reused.
+	mgr.reserved.release("default", "idx1", 1)
+	topology, err := repo.GetTopologyByBucket("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topology.UpdateStateForIndexInstByDefn(common.IndexDefnId(1), common.INDEX_STATE_DELETED)
+	if err := repo.SetTopologyByBucket("default", topology); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.ReserveIndexName("default", "idx1", 4); err != nil {
+		t.Fatal(err)
+	}
+}
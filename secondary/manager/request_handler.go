@@ -78,6 +78,21 @@ type TopologyResponse struct {
 	Errors    []IndexError   `json:"errors,omitempty"`
 }
 
+type TemplateRequest struct {
+	Version  uint64            `json:"version,omitempty"`
+	Type     RequestType       `json:"type,omitempty"`
+	Template IndexTemplate     `json:"template,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+type TemplateResponse struct {
+	Version   uint64          `json:"version,omitempty"`
+	Status    ResponseStatus  `json:"status,omitempty"`
+	Templates []IndexTemplate `json:"templates,omitempty"`
+	Indexes   []IndexInfo     `json:"indexes,omitempty"`
+	Errors    []IndexError    `json:"errors,omitempty"`
+}
+
 type ResponseStatus string
 
 const (
@@ -288,6 +303,92 @@ func (m *httpHandler) getTopologyRequest(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+func (m *httpHandler) createIndexTemplateRequest(w http.ResponseWriter, r *http.Request) {
+
+	request := convertTemplateRequest(r)
+	if request == nil {
+		sendTemplateError(w, "RequestHandler::createIndexTemplateRequest: Unable to convert request")
+		return
+	}
+
+	if err := m.mgr.SetIndexTemplate(&request.Template); err != nil {
+		sendTemplateError(w, err.Error())
+		return
+	}
+
+	res := TemplateResponse{
+		Status:    RESP_SUCCESS,
+		Templates: []IndexTemplate{request.Template},
+	}
+	sendResponse(w, res)
+}
+
+func (m *httpHandler) dropIndexTemplateRequest(w http.ResponseWriter, r *http.Request) {
+
+	request := convertTemplateRequest(r)
+	if request == nil {
+		sendTemplateError(w, "RequestHandler::dropIndexTemplateRequest: Unable to convert request")
+		return
+	}
+
+	if err := m.mgr.DeleteIndexTemplate(request.Template.Name); err != nil {
+		sendTemplateError(w, err.Error())
+		return
+	}
+
+	sendResponse(w, TemplateResponse{Status: RESP_SUCCESS})
+}
+
+func (m *httpHandler) getIndexTemplatesRequest(w http.ResponseWriter, r *http.Request) {
+
+	templates, err := m.mgr.GetIndexTemplates()
+	if err != nil {
+		sendTemplateError(w, err.Error())
+		return
+	}
+
+	res := TemplateResponse{
+		Status:    RESP_SUCCESS,
+		Templates: make([]IndexTemplate, 0, len(templates)),
+	}
+	for _, template := range templates {
+		res.Templates = append(res.Templates, *template)
+	}
+	sendResponse(w, res)
+}
+
+func (m *httpHandler) createIndexFromTemplateRequest(w http.ResponseWriter, r *http.Request) {
+
+	request := convertTemplateRequest(r)
+	if request == nil {
+		sendTemplateError(w, "RequestHandler::createIndexFromTemplateRequest: Unable to convert request")
+		return
+	}
+
+	common.Debugf("RequestHandler::createIndexFromTemplateRequest: invoke IndexManager for template %s params %v",
+		request.Template.Name, request.Params)
+
+	defns, err := m.mgr.CreateIndexesFromTemplate(request.Template.Name, request.Params)
+	if err != nil {
+		sendTemplateError(w, err.Error())
+		return
+	}
+
+	res := TemplateResponse{Status: RESP_SUCCESS}
+	for _, defn := range defns {
+		res.Indexes = append(res.Indexes, IndexInfo{
+			Name:      defn.Name,
+			Bucket:    defn.Bucket,
+			DefnID:    indexDefnIdStr(defn.DefnId),
+			PartnExpr: defn.PartitionKey,
+			SecExprs:  defn.SecExprs,
+			WhereExpr: defn.WhereExpr,
+			IsPrimary: defn.IsPrimary,
+		})
+	}
+	sendResponse(w, res)
+}
+
 ///////////////////////////////////////////////////////
 // Private Function
 ///////////////////////////////////////////////////////
@@ -330,6 +431,35 @@ func convertTopologyRequest(r *http.Request) *TopologyRequest {
 	return &req
 }
 
+func convertTemplateRequest(r *http.Request) *TemplateRequest {
+	req := TemplateRequest{}
+	buf := make([]byte, r.ContentLength)
+	common.Debugf("RequestHandler::convertTemplateRequest: request content length %d", len(buf))
+
+	// Body will be non-null but can return EOF if being empty
+	if n, err := r.Body.Read(buf); err != nil && int64(n) != r.ContentLength {
+		common.Debugf("RequestHandler::convertTemplateRequest: unable to read request body, err %v", err)
+		return nil
+	}
+
+	if err := json.Unmarshal(buf, &req); err != nil {
+		common.Debugf("RequestHandler::convertTemplateRequest: unable to unmarshall request body. Buf = %s, err %v", buf, err)
+		return nil
+	}
+
+	return &req
+}
+
+func sendTemplateError(w http.ResponseWriter, msg string) {
+
+	ierr := IndexError{Code: string(RESP_ERROR), Msg: msg}
+	res := TemplateResponse{
+		Status: RESP_ERROR,
+		Errors: []IndexError{ierr},
+	}
+	sendResponse(w, res)
+}
+
 func sendResponse(w http.ResponseWriter, res interface{}) {
 	common.Debugf("RequestHandler::sendResponse: sending response back to caller")
 
@@ -360,6 +490,10 @@ func (r *requestHandler) run() {
 		http.HandleFunc("/createIndex", handler.createIndexRequest)
 		http.HandleFunc("/dropIndex", handler.dropIndexRequest)
 		http.HandleFunc("/getTopology", handler.getTopologyRequest)
+		http.HandleFunc("/createIndexTemplate", handler.createIndexTemplateRequest)
+		http.HandleFunc("/dropIndexTemplate", handler.dropIndexTemplateRequest)
+		http.HandleFunc("/getIndexTemplates", handler.getIndexTemplatesRequest)
+		http.HandleFunc("/createIndexFromTemplate", handler.createIndexFromTemplateRequest)
 	})
 
 	handler.mgr = r.mgr
@@ -0,0 +1,107 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/manager/client"
+	"net/http"
+	"sync"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+// snapshotHandler serves the metadata of the index manager over http, for
+// metadata providers that cannot reach the watcher port of the indexer.
+type snapshotHandler struct {
+	initializer sync.Once
+	mgr         *IndexManager
+	mutex       sync.Mutex
+}
+
+var snapshotServer snapshotHandler
+
+///////////////////////////////////////////////////////
+// private function
+///////////////////////////////////////////////////////
+
+// registerSnapshotHandler registers client.METADATA_SNAPSHOT_PATH with the
+// default http mux, served by the indexer's http server.
+func registerSnapshotHandler(mgr *IndexManager) {
+
+	snapshotServer.initializer.Do(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				common.Warnf("error encountered when registering http metadata snapshot handler : %v.  Ignored.\n", r)
+			}
+		}()
+
+		http.HandleFunc(client.METADATA_SNAPSHOT_PATH, snapshotServer.metadataSnapshotRequest)
+	})
+
+	snapshotServer.mutex.Lock()
+	defer snapshotServer.mutex.Unlock()
+	snapshotServer.mgr = mgr
+}
+
+func unregisterSnapshotHandler(mgr *IndexManager) {
+
+	snapshotServer.mutex.Lock()
+	defer snapshotServer.mutex.Unlock()
+
+	if snapshotServer.mgr == mgr {
+		snapshotServer.mgr = nil
+	}
+}
+
+func (h *snapshotHandler) metadataSnapshotRequest(w http.ResponseWriter, r *http.Request) {
+
+	h.mutex.Lock()
+	mgr := h.mgr
+	h.mutex.Unlock()
+
+	if mgr == nil || mgr.IsClose() {
+		sendHttpError(w, "Index manager is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	snapshot, err := mgr.repo.GetMetadataSnapshot()
+	if err != nil {
+		common.Errorf("snapshotHandler.metadataSnapshotRequest(): fail to read metadata. Reason = %v", err)
+		sendHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendResponse(w, snapshot)
+}
+
+// GetMetadataSnapshot returns the index definitions and topologies in the
+// repository, as persisted.
+func (c *MetadataRepo) GetMetadataSnapshot() (*client.MetadataSnapshot, error) {
+
+	iter, err := c.repo.newIterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	snapshot := new(client.MetadataSnapshot)
+	for {
+		key, content, err := iter.iterator.Next()
+		if err != nil {
+			break
+		}
+		if isIndexDefnKey(key) || isIndexTopologyKey(key) {
+			snapshot.Entries = append(snapshot.Entries, client.MetadataEntry{Key: key, Value: content})
+		}
+	}
+	return snapshot, nil
+}
@@ -16,7 +16,6 @@ import (
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
 	"net"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -86,7 +85,7 @@ type ProjectorStreamClient interface {
 	MutationTopicRequest(topic, endpointType string, reqTimestamps []*protobuf.TsVbuuid,
 		instances []*protobuf.Instance) (*protobuf.TopicResponse, error)
 	DelInstances(topic string, uuids []uint64) error
-	RepairEndpoints(topic string, endpoints []string) error
+	RepairEndpoints(topic string, endpoints []string) (*protobuf.RepairEndpointsResponse, error)
 	InitialRestartTimestamp(pooln, bucketn string) (*protobuf.TsVbuuid, error)
 	RestartVbuckets(topic string, restartTimestamps []*protobuf.TsVbuuid) (*protobuf.TopicResponse, error)
 }
@@ -644,23 +643,23 @@ func (worker *adminWorker) shouldRetryAddInstances(requestTs []*protobuf.TsVbuui
 	errStr := err.Error()
 	common.Debugf("adminWorker::shouldRetryAddInstances(): Error encountered when calling MutationTopicRequest. Error=%v", errStr)
 
-	if strings.Contains(errStr, projectorC.ErrorTopicExist.Error()) {
+	if common.IsError(err, projectorC.ErrorTopicExist) {
 		// TODO: Need pratap to define the semantic of ErrorTopExist.   Right now return as an non-recoverable error.
 		return nil, NewError(ERROR_STREAM_REQUEST_ERROR, NORMAL, STREAM, err, "")
 
-	} else if strings.Contains(errStr, projectorC.ErrorInconsistentFeed.Error()) {
+	} else if common.IsError(err, projectorC.ErrorInconsistentFeed) {
 		// This is fatal error.  Should only happen due to coding error.   Need to return this error.
 		// For those projectors that have already been opened, let's leave it open. Eventually those
 		// projectors will fill up the buffer and terminate the connection by itself.
 		return nil, NewError(ERROR_STREAM_REQUEST_ERROR, NORMAL, STREAM, err, "")
 
-	} else if strings.Contains(errStr, projectorC.ErrorNotMyVbucket.Error()) {
+	} else if common.IsError(err, projectorC.ErrorNotMyVbucket) {
 		return nil, NewError(ERROR_STREAM_WRONG_VBUCKET, NORMAL, STREAM, err, "")
 
-	} else if strings.Contains(errStr, projectorC.ErrorInvalidVbucketBranch.Error()) {
+	} else if common.IsError(err, projectorC.ErrorInvalidVbucketBranch) {
 		return nil, NewError(ERROR_STREAM_INVALID_TIMESTAMP, NORMAL, STREAM, err, "")
 
-	} else if strings.Contains(errStr, projectorC.ErrorInvalidKVaddrs.Error()) {
+	} else if common.IsError(err, projectorC.ErrorInvalidKVaddrs) {
 		return nil, NewError(ERROR_STREAM_INVALID_KVADDRS, NORMAL, STREAM, err, "")
 	}
 
@@ -714,7 +713,7 @@ func (worker *adminWorker) deleteInstances(instances []uint64, doneCh chan *admi
 			}
 
 			common.Debugf("adminWorker::deleteInstances(): Error encountered when calling DelInstances. Error=%v", err.Error())
-			if strings.Contains(err.Error(), projectorC.ErrorTopicMissing.Error()) {
+			if common.IsError(err, projectorC.ErrorTopicMissing) {
 				// It is OK if topic is missing
 				worker.err = nil
 				return
@@ -762,15 +761,25 @@ func (worker *adminWorker) repairEndpoint(endpoint string, doneCh chan *adminWor
 			return
 		default:
 
-			err := client.RepairEndpoints(topic, []string{endpoint})
+			response, err := client.RepairEndpoints(topic, []string{endpoint})
 			if err == nil {
+				// projector cannot reconnect to the endpoint.  Return the reason so that
+				// the caller can restart the stream rather than retrying the repair.
+				if reason, ok := response.Failed()[endpoint]; ok {
+					common.Debugf("adminWorker::repairEndpoint(): Projector fails to repair endpoint %v. Error=%v",
+						endpoint, reason)
+					worker.err = NewError(ERROR_STREAM_ENDPOINT_FAILED, NORMAL, STREAM, reason,
+						"Projector fails to repair endpoint "+endpoint)
+					return
+				}
+
 				// no error, it is successful for this node
 				worker.err = nil
 				return
 			}
 
 			common.Debugf("adminWorker::repairEndpiont(): Error encountered when calling RepairEndpoint. Error=%v", err.Error())
-			if strings.Contains(err.Error(), projectorC.ErrorTopicMissing.Error()) {
+			if common.IsError(err, projectorC.ErrorTopicMissing) {
 				// It is OK if topic is missing
 				worker.err = nil
 				return
@@ -869,22 +878,22 @@ func (worker *adminWorker) shouldRetryRestartVbuckets(requestTs []*protobuf.TsVb
 	errStr := err.Error()
 	common.Debugf("adminWorker::shouldRetryRestartVbuckets(): Error encountered when calling RestartVbuckets. Error=%v", errStr)
 
-	if strings.Contains(errStr, projectorC.ErrorTopicMissing.Error()) {
+	if common.IsError(err, projectorC.ErrorTopicMissing) {
 		return nil, NewError(ERROR_STREAM_REQUEST_ERROR, NORMAL, STREAM, err, "")
 
-	} else if strings.Contains(errStr, projectorC.ErrorInvalidBucket.Error()) {
+	} else if common.IsError(err, projectorC.ErrorInvalidBucket) {
 		return nil, NewError(ERROR_STREAM_REQUEST_ERROR, NORMAL, STREAM, err, "")
 
-	} else if strings.Contains(errStr, projectorC.ErrorFeeder.Error()) {
+	} else if common.IsError(err, projectorC.ErrorFeeder) {
 		return nil, NewError(ERROR_STREAM_FEEDER, NORMAL, STREAM, err, "")
 
-	} else if strings.Contains(errStr, projectorC.ErrorNotMyVbucket.Error()) {
+	} else if common.IsError(err, projectorC.ErrorNotMyVbucket) {
 		return nil, NewError(ERROR_STREAM_WRONG_VBUCKET, NORMAL, STREAM, err, "")
 
-	} else if strings.Contains(errStr, projectorC.ErrorInvalidVbucketBranch.Error()) {
+	} else if common.IsError(err, projectorC.ErrorInvalidVbucketBranch) {
 		return nil, NewError(ERROR_STREAM_INVALID_TIMESTAMP, NORMAL, STREAM, err, "")
 
-	} else if strings.Contains(errStr, projectorC.ErrorStreamEnd.Error()) {
+	} else if common.IsError(err, projectorC.ErrorStreamEnd) {
 		return nil, NewError(ERROR_STREAM_STREAM_END, NORMAL, STREAM, err, "")
 	}
 
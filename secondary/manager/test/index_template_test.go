@@ -0,0 +1,74 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package test
+
+import (
+	"github.com/couchbase/indexing/secondary/manager"
+	"testing"
+)
+
+func TestIndexTemplate(t *testing.T) {
+
+	template := &manager.IndexTemplate{
+		Name:   "by_city",
+		Params: []string{"prefix"},
+		Indexes: []manager.IndexInfo{
+			{Name: "${prefix}_city", SecExprs: []string{"`${prefix}_city`"}},
+			{Name: "${prefix}_zip", Bucket: "geo", SecExprs: []string{"zip"}, WhereExpr: "type = \"${prefix}\""},
+		},
+	}
+	if err := template.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := *template
+	bad.Name = "by/city"
+	if err := bad.Validate(); err == nil {
+		t.Fatal("expected error for invalid template name")
+	}
+	bad = *template
+	bad.Params = nil
+	if err := bad.Validate(); err == nil {
+		t.Fatal("expected error for undeclared parameter")
+	}
+
+	if _, err := template.Instantiate(map[string]string{"bucket": "default"}); err == nil {
+		t.Fatal("expected error for missing parameter")
+	}
+	if _, err := template.Instantiate(map[string]string{"bucket": "default", "prefix": "a`b"}); err == nil {
+		t.Fatal("expected error for invalid parameter value")
+	}
+	// bucket names are not identifiers, and can't be used in expressions.
+	if _, err := template.Instantiate(map[string]string{"bucket": "default", "prefix": "a-b"}); err == nil {
+		t.Fatal("expected error for parameter value that is not an identifier in expression")
+	}
+	if indexes, err := template.Instantiate(map[string]string{"bucket": "travel-sample", "prefix": "user"}); err != nil {
+		t.Fatal(err)
+	} else if indexes[0].Bucket != "travel-sample" {
+		t.Fatalf("unexpected index %v", indexes[0])
+	}
+
+	indexes, err := template.Instantiate(map[string]string{"bucket": "default", "prefix": "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 2 {
+		t.Fatalf("expected 2 indexes, got %d", len(indexes))
+	}
+	if indexes[0].Name != "user_city" || indexes[0].Bucket != "default" || indexes[0].SecExprs[0] != "`user_city`" {
+		t.Fatalf("unexpected index %v", indexes[0])
+	}
+	if indexes[1].Name != "user_zip" || indexes[1].Bucket != "geo" || indexes[1].WhereExpr != "type = \"user\"" {
+		t.Fatalf("unexpected index %v", indexes[1])
+	}
+	if template.Indexes[0].SecExprs[0] != "`${prefix}_city`" {
+		t.Fatal("template modified by instantiation")
+	}
+}
@@ -155,6 +155,19 @@ func TestMetadataProvider(t *testing.T) {
 	}
 	common.Infof("done creating index 104")
 
+	// Create Index whose name is reserved for an index being created through another provider.
+	if err := mgr.ReserveIndexName("Default", "metadata_provider_test_105", common.IndexDefnId(105)); err != nil {
+		t.Fatal("Cannot reserve name of Index Defn 105")
+	}
+	if _, err := provider.CreateIndex("metadata_provider_test_105", "Default", common.ForestDB,
+		common.N1QL, "Testing", "Testing", msgAddr, []string{"Testing"}, false); err != client.ErrDuplicateIndexName {
+		t.Fatal(fmt.Sprintf("Expected duplicate name error for Index Defn 105, got %v", err))
+	}
+	if err := mgr.ReserveIndexName("Default", "metadata_provider_test_103", common.IndexDefnId(105)); err != client.ErrDuplicateIndexName {
+		t.Fatal(fmt.Sprintf("Expected duplicate name error reserving name of Index Defn 103, got %v", err))
+	}
+	common.Infof("done creating duplicate index 105")
+
 	common.Infof("Verify Changed Data *********************************************************")
 
 	if lookup(provider, common.IndexDefnId(100)) == nil {
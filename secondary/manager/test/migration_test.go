@@ -0,0 +1,73 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/manager"
+	"testing"
+)
+
+func TestUpgradeMetadata(t *testing.T) {
+
+	// definition persisted before schema versions and checksums.
+	legacy := []byte(`{"defnId":18446744073709551557,"name":"by_city","using":"forestdb","bucket":"default","secExprs":["city"]}`)
+	data, ok, err := manager.UpgradeMetadata("IndexDefinitionId/18446744073709551557", legacy)
+	if err != nil || !ok {
+		t.Fatalf("expected legacy definition to be upgraded, got %v %v", ok, err)
+	}
+	defn, err := common.UnmarshallIndexDefn(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defn.SchemaVersion != common.IndexDefnSchemaVersion ||
+		defn.DefnId != common.IndexDefnId(18446744073709551557) ||
+		defn.Name != "by_city" || len(defn.SecExprs) != 1 {
+		t.Fatalf("unexpected definition %+v", defn)
+	}
+
+	// upgraded definitions are left as is.
+	if _, ok, err := manager.UpgradeMetadata("IndexDefinitionId/1", data); err != nil || ok {
+		t.Fatalf("expected current definition to be left as is, got %v %v", ok, err)
+	}
+	current, _ := common.MarshallIndexDefn(&common.IndexDefn{DefnId: 1, Name: "by_zip"})
+	if _, ok, err := manager.UpgradeMetadata("IndexDefinitionId/1", current); err != nil || ok {
+		t.Fatalf("expected current definition to be left as is, got %v %v", ok, err)
+	}
+
+	// definitions of newer versions are tolerated during rolling upgrade.
+	newer := common.AddChecksum([]byte(`{"defnId":2,"name":"by_age","schemaVersion":1000,"replicas":2}`))
+	if _, ok, err := manager.UpgradeMetadata("IndexDefinitionId/2", newer); err != nil || ok {
+		t.Fatalf("expected newer definition to be left as is, got %v %v", ok, err)
+	}
+	if defn, err := common.UnmarshallIndexDefn(newer); err != nil || defn.Name != "by_age" {
+		t.Fatalf("unexpected definition %+v, %v", defn, err)
+	}
+
+	corrupted := bytes.Replace(current, []byte("by_zip"), []byte("by_zap"), 1)
+	if _, _, err := manager.UpgradeMetadata("IndexDefinitionId/1", corrupted); err == nil {
+		t.Fatal("expected error for corrupted definition")
+	}
+
+	topology := []byte(`{"version":3,"bucket":"default"}`)
+	if data, ok, err := manager.UpgradeMetadata("IndexTopology/default", topology); err != nil || !ok {
+		t.Fatalf("expected legacy topology to be upgraded, got %v %v", ok, err)
+	} else if _, err := common.VerifyChecksum(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// other kinds of metadata are not versioned.
+	stamp := []byte(`{"timestamps":[]}`)
+	if data, ok, err := manager.UpgradeMetadata("StabilityTimestamp", stamp); err != nil || ok ||
+		string(data) != string(stamp) {
+		t.Fatalf("expected stability timestamp to be left as is, got %v %v", ok, err)
+	}
+}
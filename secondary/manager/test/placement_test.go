@@ -0,0 +1,53 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package test
+
+import (
+	"github.com/couchbase/indexing/secondary/manager/client"
+	"testing"
+)
+
+func TestPlacementPolicy(t *testing.T) {
+
+	nodes := []*client.NodeInfo{
+		&client.NodeInfo{Addr: "node1:9100", NumIndexes: 3},
+		&client.NodeInfo{Addr: "node2:9100", NumIndexes: 1},
+		&client.NodeInfo{Addr: "node3:9100", NumIndexes: 2},
+	}
+
+	policy, err := client.NewPlacementPolicy(client.PLACEMENT_ROUND_ROBIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2*len(nodes); i++ {
+		node, err := policy.PickNode(nodes)
+		if err != nil || node != nodes[i%len(nodes)].Addr {
+			t.Errorf("round_robin: expected %v, got %v (%v)", nodes[i%len(nodes)].Addr, node, err)
+		}
+	}
+
+	// capacity based policies fall back to least_indexes when no node
+	// reports its capacity.
+	for _, name := range []string{client.PLACEMENT_LEAST_INDEXES,
+		client.PLACEMENT_LEAST_DISK, client.PLACEMENT_LEAST_LOADED} {
+
+		policy, err := client.NewPlacementPolicy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node, err := policy.PickNode(nodes); err != nil || node != "node2:9100" {
+			t.Errorf("%s: expected node2:9100, got %v (%v)", name, node, err)
+		}
+	}
+
+	if _, err := client.NewPlacementPolicy("random"); err == nil {
+		t.Errorf("expected error for unknown placement policy")
+	}
+}
@@ -481,8 +481,8 @@ func (c *deleteTestProjectorClient) DelInstances(topic string, uuids []uint64) e
 	return nil
 }
 
-func (c *deleteTestProjectorClient) RepairEndpoints(topic string, endpoints []string) error {
-	return nil
+func (c *deleteTestProjectorClient) RepairEndpoints(topic string, endpoints []string) (*protobuf.RepairEndpointsResponse, error) {
+	return protobuf.NewRepairEndpointsResponse(), nil
 }
 
 func (c *deleteTestProjectorClient) InitialRestartTimestamp(pooln, bucketn string) (*protobuf.TsVbuuid, error) {
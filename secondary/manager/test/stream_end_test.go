@@ -298,8 +298,8 @@ func (c *streamEndTestProjectorClient) DelInstances(topic string, uuids []uint64
 	return nil
 }
 
-func (c *streamEndTestProjectorClient) RepairEndpoints(topic string, endpoints []string) error {
-	return nil
+func (c *streamEndTestProjectorClient) RepairEndpoints(topic string, endpoints []string) (*protobuf.RepairEndpointsResponse, error) {
+	return protobuf.NewRepairEndpointsResponse(), nil
 }
 
 func (c *streamEndTestProjectorClient) InitialRestartTimestamp(pooln, bucketn string) (*protobuf.TsVbuuid, error) {
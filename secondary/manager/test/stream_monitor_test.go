@@ -286,8 +286,8 @@ func (c *monitorTestProjectorClient) DelInstances(topic string, uuids []uint64)
 	return nil
 }
 
-func (c *monitorTestProjectorClient) RepairEndpoints(topic string, endpoints []string) error {
-	return nil
+func (c *monitorTestProjectorClient) RepairEndpoints(topic string, endpoints []string) (*protobuf.RepairEndpointsResponse, error) {
+	return protobuf.NewRepairEndpointsResponse(), nil
 }
 
 func (c *monitorTestProjectorClient) InitialRestartTimestamp(pooln, bucketn string) (*protobuf.TsVbuuid, error) {
@@ -276,8 +276,8 @@ func (c *syncTestProjectorClient) DelInstances(topic string, uuids []uint64) err
 	return nil
 }
 
-func (c *syncTestProjectorClient) RepairEndpoints(topic string, endpoints []string) error {
-	return nil
+func (c *syncTestProjectorClient) RepairEndpoints(topic string, endpoints []string) (*protobuf.RepairEndpointsResponse, error) {
+	return protobuf.NewRepairEndpointsResponse(), nil
 }
 
 func (c *syncTestProjectorClient) InitialRestartTimestamp(pooln, bucketn string) (*protobuf.TsVbuuid, error) {
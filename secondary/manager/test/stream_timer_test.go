@@ -292,8 +292,8 @@ func (c *timerTestProjectorClient) DelInstances(topic string, uuids []uint64) er
 	return nil
 }
 
-func (c *timerTestProjectorClient) RepairEndpoints(topic string, endpoints []string) error {
-	return nil
+func (c *timerTestProjectorClient) RepairEndpoints(topic string, endpoints []string) (*protobuf.RepairEndpointsResponse, error) {
+	return protobuf.NewRepairEndpointsResponse(), nil
 }
 
 func (c *timerTestProjectorClient) InitialRestartTimestamp(pooln, bucketn string) (*protobuf.TsVbuuid, error) {
@@ -21,13 +21,17 @@ import (
 ////////////////////////////////////////////////////////////////////////
 
 type GlobalTopology struct {
-	TopologyKeys []string `json:"topologyKeys,omitempty"`
+	TopologyKeys  []string `json:"topologyKeys,omitempty"`
+	SchemaVersion uint32   `json:"schemaVersion,omitempty"`
 }
 
+// IndexTopology.Version counts updates to the topology, SchemaVersion
+// is the format it was persisted in.
 type IndexTopology struct {
-	Version     uint64                  `json:"version,omitempty"`
-	Bucket      string                  `json:"bucket,omitempty"`
-	Definitions []IndexDefnDistribution `json:"definitions,omitempty"`
+	Version       uint64                  `json:"version,omitempty"`
+	Bucket        string                  `json:"bucket,omitempty"`
+	Definitions   []IndexDefnDistribution `json:"definitions,omitempty"`
+	SchemaVersion uint32                  `json:"schemaVersion,omitempty"`
 }
 
 type IndexDefnDistribution struct {
@@ -38,13 +42,17 @@ type IndexDefnDistribution struct {
 }
 
 type IndexInstDistribution struct {
-	InstId     uint64                  `json:"instId,omitempty"`
-	State      uint32                  `json:"state,omitempty"`
-	StreamId   uint32                  `json:"steamId,omitempty"`
-	Error      string                  `json:"error,omitempty"`
-	Partitions []IndexPartDistribution `json:"partitions,omitempty"`
+	InstId       uint64                  `json:"instId,omitempty"`
+	State        uint32                  `json:"state,omitempty"`
+	StreamId     uint32                  `json:"steamId,omitempty"`
+	Error        string                  `json:"error,omitempty"`
+	ErrorHistory []string                `json:"errorHistory,omitempty"`
+	Partitions   []IndexPartDistribution `json:"partitions,omitempty"`
 }
 
+// maximum number of errors retained in IndexInstDistribution.ErrorHistory
+const maxInstErrorHistory = 10
+
 type IndexPartDistribution struct {
 	PartId          uint64                      `json:"partId,omitempty"`
 	SinglePartition IndexSinglePartDistribution `json:"singlePartition,omitempty"`
@@ -251,7 +259,15 @@ func (t *IndexTopology) SetErrorForIndexInstByDefn(defnId common.IndexDefnId, er
 	for i, _ := range t.Definitions {
 		if t.Definitions[i].DefnId == uint64(defnId) {
 			for j, _ := range t.Definitions[i].Instances {
-				t.Definitions[i].Instances[j].Error = errorStr
+				inst := &t.Definitions[i].Instances[j]
+				inst.Error = errorStr
+				n := len(inst.ErrorHistory)
+				if errorStr != "" && (n == 0 || inst.ErrorHistory[n-1] != errorStr) {
+					inst.ErrorHistory = append(inst.ErrorHistory, errorStr)
+					if n+1 > maxInstErrorHistory {
+						inst.ErrorHistory = inst.ErrorHistory[n+1-maxInstErrorHistory:]
+					}
+				}
 				common.Debugf("IndexTopology.SetErrorForIndexInstByDefn(): Set error for index '%v' inst '%v.  Error = '%v'",
 					defnId, t.Definitions[i].Instances[j].InstId, t.Definitions[i].Instances[j].Error)
 			}
@@ -10,14 +10,21 @@ import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
 var reqVbmap = &protobuf.VbmapRequest{}
 var reqFailoverLog = &protobuf.FailoverLogRequest{}
 var reqMutationFeed = &protobuf.MutationTopicRequest{}
+var reqCatchupFeed = &protobuf.CatchupTopicRequest{}
 var reqRestartVbuckets = &protobuf.RestartVbucketsRequest{}
 var reqShutdownVbuckets = &protobuf.ShutdownVbucketsRequest{}
 var reqAddBuckets = &protobuf.AddBucketsRequest{}
 var reqDelBuckets = &protobuf.DelBucketsRequest{}
 var reqAddInstances = &protobuf.AddInstancesRequest{}
 var reqDelInstances = &protobuf.DelInstancesRequest{}
+var reqDisableInstances = &protobuf.DisableInstancesRequest{}
+var reqEnableInstances = &protobuf.EnableInstancesRequest{}
+var reqPauseTopic = &protobuf.PauseTopicRequest{}
+var reqResumeTopic = &protobuf.ResumeTopicRequest{}
 var reqRepairEndpoints = &protobuf.RepairEndpointsRequest{}
+var reqTopicOperations = &protobuf.TopicOperationsRequest{}
 var reqShutdownFeed = &protobuf.ShutdownTopicRequest{}
+var reqProbe = &protobuf.ProbeRequest{}
 var reqStats = c.Statistics{}
 
 // admin-port entry point, once started never shutsdown.
@@ -25,15 +32,25 @@ func (p *Projector) mainAdminPort(reqch chan ap.Request) {
 	p.admind.Register(reqVbmap)
 	p.admind.Register(reqFailoverLog)
 	p.admind.Register(reqMutationFeed)
+	p.admind.Register(reqCatchupFeed)
 	p.admind.Register(reqRestartVbuckets)
 	p.admind.Register(reqShutdownVbuckets)
 	p.admind.Register(reqAddBuckets)
 	p.admind.Register(reqDelBuckets)
 	p.admind.Register(reqAddInstances)
 	p.admind.Register(reqDelInstances)
+	p.admind.Register(reqDisableInstances)
+	p.admind.Register(reqEnableInstances)
+	p.admind.Register(reqPauseTopic)
+	p.admind.Register(reqResumeTopic)
 	p.admind.Register(reqRepairEndpoints)
+	p.admind.Register(reqTopicOperations)
 	p.admind.Register(reqShutdownFeed)
+	p.admind.Register(reqProbe)
 	p.admind.Register(reqStats)
+	p.admind.RegisterHTTPHandler("/logtail", p.handleLogTail)
+	p.admind.RegisterHTTPHandler("/mutationsamples", p.handleMutationSamples)
+	p.admind.RegisterHTTPHandler("/events", p.handleEvents)
 
 	expvar.Publish("projector", expvar.Func(p.doStatistics))
 
@@ -69,6 +86,8 @@ func (p *Projector) handleRequest(req ap.Request) {
 		response = p.doFailoverLog(request)
 	case *protobuf.MutationTopicRequest:
 		response = p.doMutationTopic(request)
+	case *protobuf.CatchupTopicRequest:
+		response = p.doCatchupTopic(request)
 	case *protobuf.RestartVbucketsRequest:
 		response = p.doRestartVbuckets(request)
 	case *protobuf.ShutdownVbucketsRequest:
@@ -81,10 +100,22 @@ func (p *Projector) handleRequest(req ap.Request) {
 		response = p.doAddInstances(request)
 	case *protobuf.DelInstancesRequest:
 		response = p.doDelInstances(request)
+	case *protobuf.DisableInstancesRequest:
+		response = p.doDisableInstances(request)
+	case *protobuf.EnableInstancesRequest:
+		response = p.doEnableInstances(request)
+	case *protobuf.PauseTopicRequest:
+		response = p.doPauseTopic(request)
+	case *protobuf.ResumeTopicRequest:
+		response = p.doResumeTopic(request)
 	case *protobuf.RepairEndpointsRequest:
 		response = p.doRepairEndpoints(request)
+	case *protobuf.TopicOperationsRequest:
+		response = p.doTopicOperations(request)
 	case *protobuf.ShutdownTopicRequest:
 		response = p.doShutdownTopic(request)
+	case *protobuf.ProbeRequest:
+		response = p.doProbe(request)
 	default:
 		err = c.ErrorInvalidRequest
 	}
@@ -0,0 +1,113 @@
+// routing audit samples mutations on a vbucket and records the endpoints
+// each engine routed them to, to diagnose misrouting.
+
+package projector
+
+import "strconv"
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+// routingAudit is owned by a single vbucket-routine, not thread safe.
+type routingAudit struct {
+	samples int // mutations audited ...
+	period  int // ... out of these many mutations
+	count   int // mutations seen so far
+	audited float64
+	// engine-uuid -> endpoint -> no. of mutations routed
+	routes map[uint64]map[string]float64
+	// engine-uuid -> endpoint -> no. of mutations routed to an endpoint
+	// that does not host the engine or is not known to the vbucket.
+	misroutes map[uint64]map[string]float64
+}
+
+// newRoutingAudit returns nil if audit is disabled.
+func newRoutingAudit(samples, period int) *routingAudit {
+	if samples <= 0 || period <= 0 {
+		return nil
+	}
+	return &routingAudit{
+		samples:   samples,
+		period:    period,
+		routes:    make(map[uint64]map[string]float64),
+		misroutes: make(map[uint64]map[string]float64),
+	}
+}
+
+// sample shall be called once for every mutation, returns whether the
+// mutation is to be audited.
+func (ra *routingAudit) sample() bool {
+	n := ra.count % ra.period
+	ra.count++
+	return n < ra.samples
+}
+
+// record the endpoints chosen by engines for a mutation, `data` is the
+// per endpoint data prepared by engines' TransformRoute().
+func (ra *routingAudit) record(
+	engines map[uint64]*Engine,
+	endpoints map[string]c.RouterEndpoint,
+	data map[string]interface{}) {
+
+	ra.audited++
+	for raddr, v := range data {
+		dkv, ok := v.(*c.DataportKeyVersions)
+		if !ok || dkv.Kv == nil {
+			continue
+		}
+		_, known := endpoints[raddr]
+		seen := make(map[uint64]bool)
+		for _, uuid := range dkv.Kv.Uuids {
+			if seen[uuid] { // upsert and upsert-deletion for same engine.
+				continue
+			}
+			seen[uuid] = true
+			incrRoute(ra.routes, uuid, raddr)
+			engine, ok := engines[uuid]
+			if !known || !ok || !hostsEndpoint(engine, raddr) {
+				incrRoute(ra.misroutes, uuid, raddr)
+			}
+		}
+	}
+}
+
+// toMap returns audit as statistics.
+func (ra *routingAudit) toMap() map[string]interface{} {
+	routesToMap := func(
+		routes map[uint64]map[string]float64) map[string]interface{} {
+
+		m := make(map[string]interface{})
+		for uuid, eps := range routes {
+			counts := make(map[string]interface{})
+			for raddr, n := range eps {
+				counts[raddr] = n
+			}
+			m[strconv.FormatUint(uuid, 10)] = counts
+		}
+		return m
+	}
+	return map[string]interface{}{
+		"samples":   float64(ra.samples),
+		"period":    float64(ra.period),
+		"audited":   ra.audited,
+		"routes":    routesToMap(ra.routes),
+		"misroutes": routesToMap(ra.misroutes),
+	}
+}
+
+func incrRoute(routes map[uint64]map[string]float64, uuid uint64, raddr string) {
+	eps, ok := routes[uuid]
+	if !ok {
+		eps = make(map[string]float64)
+		routes[uuid] = eps
+	}
+	eps[raddr]++
+}
+
+func hostsEndpoint(engine *Engine, raddr string) bool {
+	for _, ep := range engine.Endpoints() {
+		if ep == raddr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package projector
+
+import "reflect"
+import "testing"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+
+func TestRoutingAuditSample(t *testing.T) {
+	if ra := newRoutingAudit(0, 10); ra != nil {
+		t.Fatalf("expected audit to be disabled")
+	} else if ra = newRoutingAudit(1, 0); ra != nil {
+		t.Fatalf("expected audit to be disabled")
+	}
+
+	ra := newRoutingAudit(2, 5)
+	sampled := make([]bool, 0, 10)
+	for i := 0; i < 10; i++ {
+		sampled = append(sampled, ra.sample())
+	}
+	ref := []bool{true, true, false, false, false, true, true, false, false, false}
+	if !reflect.DeepEqual(sampled, ref) {
+		t.Fatalf("expected %v, got %v", ref, sampled)
+	}
+}
+
+func TestRoutingAuditRecord(t *testing.T) {
+	instances := protobuf.ExampleIndexInstances(
+		[]string{"default"}, []string{"ep1"}, "")
+	engines := make(map[uint64]*Engine)
+	for _, instance := range instances {
+		ii := instance.GetIndexInstance()
+		engines[ii.GetInstId()] = NewEngine(ii.GetInstId(), nil, ii)
+	}
+	endpoints := map[string]c.RouterEndpoint{"ep1": nil, "ep2": nil}
+
+	ra := newRoutingAudit(1, 1)
+	data := map[string]interface{}{
+		// upsert and upsert-deletion for engine 1 count once.
+		"ep1": &c.DataportKeyVersions{Kv: &c.KeyVersions{Uuids: []uint64{1, 1, 2}}},
+		// endpoint does not host engine 2, engine 3 is not known.
+		"ep2": &c.DataportKeyVersions{Kv: &c.KeyVersions{Uuids: []uint64{2, 3}}},
+		// endpoint is not known to the vbucket.
+		"ep3": &c.DataportKeyVersions{Kv: &c.KeyVersions{Uuids: []uint64{1}}},
+		"ep4": &c.DataportKeyVersions{},
+	}
+	ra.record(engines, endpoints, data)
+
+	stats := ra.toMap()
+	if stats["audited"] != float64(1) {
+		t.Fatalf("expected 1 audited mutation, got %v", stats["audited"])
+	}
+	routes := map[string]interface{}{
+		"1": map[string]interface{}{"ep1": float64(1), "ep3": float64(1)},
+		"2": map[string]interface{}{"ep1": float64(1), "ep2": float64(1)},
+		"3": map[string]interface{}{"ep2": float64(1)},
+	}
+	if !reflect.DeepEqual(stats["routes"], routes) {
+		t.Errorf("expected routes %v, got %v", routes, stats["routes"])
+	}
+	misroutes := map[string]interface{}{
+		"1": map[string]interface{}{"ep3": float64(1)},
+		"2": map[string]interface{}{"ep2": float64(1)},
+		"3": map[string]interface{}{"ep2": float64(1)},
+	}
+	if !reflect.DeepEqual(stats["misroutes"], misroutes) {
+		t.Errorf("expected misroutes %v, got %v", misroutes, stats["misroutes"])
+	}
+}
@@ -2,6 +2,7 @@
 //
 // Client APIs:
 //   - start a new feed for one or more buckets with one or more instances.
+//   - start a catchup feed, whose vbucket streams end at specified seqnos.
 //   - restart one or more {bucket,vbuckets}.
 //   - shutdown one or more {bucket,buckets}.
 //   - add one or more buckets to existing feed.
@@ -10,6 +11,10 @@
 //   - del one or more instances from an existing feed.
 //   - repair one or more endpoints for an existing feed, to restart
 //     an endpoint client that experienced transient connection problems.
+//   - apply a batch of add-buckets, add-instances and restart-vbuckets
+//     to an existing feed in a single request.
+//   - pause an existing feed, retaining its vbucket streams, and resume
+//     it later.
 //
 // what is an instance ?
 //   An instance is an abstraction implementing Evaluator{} and Router{}
@@ -62,10 +67,8 @@
 
 package client
 
-import "fmt"
 import "time"
 import "strings"
-import "errors"
 
 import ap "github.com/couchbase/indexing/secondary/adminport"
 import c "github.com/couchbase/indexing/secondary/common"
@@ -75,54 +78,94 @@ import "github.com/couchbaselabs/goprotobuf/proto"
 // error codes
 
 // ErrorTopicExist
-var ErrorTopicExist = errors.New("projector.topicExist")
+var ErrorTopicExist = c.NewError(100, "projector.topicExist", false)
 
 // ErrorTopicMissing
-var ErrorTopicMissing = errors.New("projector.topicMissing")
+var ErrorTopicMissing = c.NewError(101, "projector.topicMissing", false)
 
 // ErrorInvalidBucket
-var ErrorInvalidBucket = errors.New("feed.invalidBucket")
+var ErrorInvalidBucket = c.NewError(102, "feed.invalidBucket", false)
 
 // ErrorInvalidKVaddrs
-var ErrorInvalidKVaddrs = errors.New("feed.invalidKVaddrs")
+var ErrorInvalidKVaddrs = c.NewError(103, "feed.invalidKVaddrs", false)
 
 // ErrorInvalidVbucketBranch
-var ErrorInvalidVbucketBranch = errors.New("feed.invalidVbucketBranch")
+var ErrorInvalidVbucketBranch = c.NewError(104, "feed.invalidVbucketBranch", false)
 
 // ErrorInvalidVbucket
-var ErrorInvalidVbucket = errors.New("feed.invalidVbucket")
+var ErrorInvalidVbucket = c.NewError(105, "feed.invalidVbucket", false)
 
 // ErrorInconsistentFeed
-var ErrorInconsistentFeed = errors.New("feed.inconsistentFeed")
+var ErrorInconsistentFeed = c.NewError(106, "feed.inconsistentFeed", false)
 
 // ErrorFeeder
-var ErrorFeeder = errors.New("feed.feeder")
+var ErrorFeeder = c.NewError(107, "feed.feeder", true)
 
 // ErrorDCPConnection
-var ErrorDCPConnection = errors.New("feed.dcpConnection")
+var ErrorDCPConnection = c.NewError(108, "feed.dcpConnection", true)
 
 // ErrorDCPPool
-var ErrorDCPPool = errors.New("feed.dcpPool")
+var ErrorDCPPool = c.NewError(109, "feed.dcpPool", true)
 
 // ErrorDCPBucket
-var ErrorDCPBucket = errors.New("feed.dcpBucket")
+var ErrorDCPBucket = c.NewError(110, "feed.dcpBucket", false)
+
+// ErrorBucketUUIDChanged is returned when a bucket is flushed or recreated
+// after its indexes were defined.
+var ErrorBucketUUIDChanged = c.ErrorBucketUUIDChanged
 
 // ErrorClusterInfo
-var ErrorClusterInfo = errors.New("feed.clusterInfo")
+var ErrorClusterInfo = c.NewError(111, "feed.clusterInfo", true)
 
 // ErrorNotMyVbucket
-var ErrorNotMyVbucket = errors.New("feed.notMyVbucket")
+var ErrorNotMyVbucket = c.NewError(112, "feed.notMyVbucket", false)
 
 // ErrorStreamRequest
-var ErrorStreamRequest = errors.New("feed.streamRequest")
+var ErrorStreamRequest = c.NewError(113, "feed.streamRequest", true)
 
 // ErrorStreamEnd
-var ErrorStreamEnd = errors.New("feed.streamEnd")
+var ErrorStreamEnd = c.NewError(114, "feed.streamEnd", false)
+
+// ErrorInvalidFeedConfig is returned for topic requests overriding
+// unknown or non-overridable feed settings, or with out of range values.
+var ErrorInvalidFeedConfig = c.NewError(115, "feed.invalidConfig", false)
+
+// ErrorRequestTimeout is returned when a feed does not respond to a
+// synchronous request within "projector.feedRequestTimeout".
+var ErrorRequestTimeout = c.NewError(116, "feed.requestTimeout", false)
+
+// ErrorTooManyTopics is returned when a new topic would exceed
+// "projector.maxTopics".
+var ErrorTooManyTopics = c.NewError(117, "projector.tooManyTopics", false)
+
+// ErrorTooManyBuckets is returned when buckets on a topic would exceed
+// "projector.maxBucketsPerTopic".
+var ErrorTooManyBuckets = c.NewError(118, "feed.tooManyBuckets", false)
+
+// ErrorTooManyEngines is returned when engines for a bucket on a topic
+// would exceed "projector.maxEnginesPerBucket".
+var ErrorTooManyEngines = c.NewError(119, "feed.tooManyEngines", false)
+
+// ErrorFeedClosed is returned for requests posted to a feed that is
+// draining or already closed.
+var ErrorFeedClosed = c.NewError(120, "feed.closed", false)
 
 // ErrorResponseTimeout is sent when projector does not recieve
 // expected control message like StreamBegin (when stream is started)
 // and StreamEnd (when stream is closed).
-var ErrorResponseTimeout = errors.New("feed.responseTimeout")
+var ErrorResponseTimeout = c.NewError(121, "feed.responseTimeout", false)
+
+// ErrorInvalidInstance is returned when instances to be disabled or
+// enabled are not defined on the topic.
+var ErrorInvalidInstance = c.NewError(122, "feed.invalidInstance", false)
+
+// ErrorEndpoint is returned when projector cannot connect with a
+// downstream endpoint.
+var ErrorEndpoint = c.NewError(123, "feed.endpoint", true)
+
+// ErrorTopicPaused is returned for requests that start or shutdown
+// vbucket streams on a paused topic.
+var ErrorTopicPaused = c.NewError(124, "feed.topicPaused", false)
 
 // Client connects with a projector's adminport to
 // issues request and get back response.
@@ -175,8 +218,8 @@ func (client *Client) GetVbmap(
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if protoerr := res.GetErr(); protoerr != nil {
-				return fmt.Errorf(protoerr.GetError())
+			} else if protoerr := res.GetErr().ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
@@ -204,8 +247,8 @@ func (client *Client) GetFailoverLogs(
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if protoerr := res.GetErr(); protoerr != nil {
-				return fmt.Errorf(protoerr.GetError())
+			} else if protoerr := res.GetErr().ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
@@ -266,8 +309,8 @@ func (client *Client) InitialTopicRequest(
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if protoerr := res.GetErr(); protoerr != nil {
-				return fmt.Errorf(protoerr.GetError())
+			} else if protoerr := res.GetErr().ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
@@ -311,16 +354,106 @@ func (client *Client) MutationTopicRequest(
 	reqTimestamps []*protobuf.TsVbuuid,
 	instances []*protobuf.Instance) (*protobuf.TopicResponse, error) {
 
+	return client.MutationTopicRequestWithConfig(
+		topic, endpointType, reqTimestamps, instances, nil)
+}
+
+// MutationTopicRequestWithConfig is same as MutationTopicRequest, in
+// addition `config` overrides projector's feed settings for this topic,
+// allowed settings are,
+//   "feedChanSize", "feedChanMinSize", "mutationChanSize",
+//   "mutationChanMinSize", "vbucketSyncTimeout",
+//   "routingAuditSamples", "routingAuditPeriod",
+//   "feedWaitStreamReqTimeout", "feedWaitStreamEndTimeout"
+//
+// Settings are applied only when the feed is created, they are ignored
+// if the topic is already started on the projector.
+// - return ErrorInvalidFeedConfig for unknown or out of range settings.
+func (client *Client) MutationTopicRequestWithConfig(
+	topic, endpointType string,
+	reqTimestamps []*protobuf.TsVbuuid,
+	instances []*protobuf.Instance,
+	config map[string]interface{}) (*protobuf.TopicResponse, error) {
+
 	req := protobuf.NewMutationTopicRequest(topic, endpointType, instances)
 	req.ReqTimestamps = reqTimestamps
+	if len(config) > 0 {
+		if _, err := req.SetConfig(config); err != nil {
+			return nil, err
+		}
+	}
+	return client.mutationTopicRequest(req)
+}
+
+// MutationTopicRequestWithToken is same as MutationTopicRequest, in
+// addition endpoints started for this topic will frame their connection
+// with registration `token`, issued by the indexer for the topic, so
+// that its dataport can reject endpoints from an older topic. Projector
+// acknowledges the token by setting it in TopicResponse, older projectors
+// ignore the token and respond without it.
+func (client *Client) MutationTopicRequestWithToken(
+	topic, endpointType, token string,
+	reqTimestamps []*protobuf.TsVbuuid,
+	instances []*protobuf.Instance) (*protobuf.TopicResponse, error) {
+
+	req := protobuf.NewMutationTopicRequest(topic, endpointType, instances)
+	req.ReqTimestamps = reqTimestamps
+	req.SetToken(token)
+	return client.mutationTopicRequest(req)
+}
+
+func (client *Client) mutationTopicRequest(
+	req *protobuf.MutationTopicRequest) (*protobuf.TopicResponse, error) {
+
 	res := &protobuf.TopicResponse{}
 	err := client.withRetry(
 		func() error {
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if protoerr := res.GetErr(); protoerr != nil {
-				return fmt.Errorf(protoerr.GetError())
+			} else if protoerr := res.GetErr().ToError(); protoerr != nil {
+				return protoerr
+			}
+			return err // nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CatchupTopicRequest topic from a kvnode, with initial set of
+// instances. Vbucket streams are started from `restartTimestamps` and
+// each one of them is ended once it reaches its seqno in
+// `endTimestamps`, StreamEnd is published downstream for the vbucket and
+// TopicResponse:catchupTimestamps will list the vbucket.
+//
+// Idempotent API.
+// - return TopicResponse that contain current set of
+//   active-timestamps, rollback-timestamps and catchup-timestamps
+//   reflected from projector, even in case of error.
+//
+// Possible errors returned,
+// - http errors for transport related failures.
+// - ErrorInconsistentFeed for malformed feed request, or if a bucket
+//   has no end-timestamp.
+// - and all errors returned by MutationTopicRequest().
+func (client *Client) CatchupTopicRequest(
+	topic, endpointType string,
+	restartTimestamps, endTimestamps []*protobuf.TsVbuuid,
+	instances []*protobuf.Instance) (*protobuf.TopicResponse, error) {
+
+	req := protobuf.NewCatchupTopicRequest(topic, endpointType, instances)
+	req.RestartTimestamps = restartTimestamps
+	req.EndTimestamps = endTimestamps
+	res := &protobuf.TopicResponse{}
+	err := client.withRetry(
+		func() error {
+			err := client.ap.Request(req, res)
+			if err != nil {
+				return err
+			} else if protoerr := res.GetErr().ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
@@ -371,14 +504,35 @@ func (client *Client) RestartVbuckets(
 	for _, restartTs := range restartTimestamps {
 		req.Append(restartTs)
 	}
+	return client.restartVbuckets(req)
+}
+
+// RestartVbucketsWithToken is same as RestartVbuckets, in addition
+// endpoints restarted for this topic will frame their connection with
+// registration `token`, refer MutationTopicRequestWithToken().
+func (client *Client) RestartVbucketsWithToken(
+	topic, token string,
+	restartTimestamps []*protobuf.TsVbuuid) (*protobuf.TopicResponse, error) {
+
+	req := protobuf.NewRestartVbucketsRequest(topic)
+	for _, restartTs := range restartTimestamps {
+		req.Append(restartTs)
+	}
+	req.SetToken(token)
+	return client.restartVbuckets(req)
+}
+
+func (client *Client) restartVbuckets(
+	req *protobuf.RestartVbucketsRequest) (*protobuf.TopicResponse, error) {
+
 	res := &protobuf.TopicResponse{}
 	err := client.withRetry(
 		func() error {
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if protoerr := res.GetErr(); protoerr != nil {
-				return fmt.Errorf(protoerr.GetError())
+			} else if protoerr := res.GetErr().ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
@@ -427,8 +581,8 @@ func (client *Client) ShutdownVbuckets(
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if s := res.GetError(); s != "" {
-				return fmt.Errorf(s)
+			} else if protoerr := res.ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
@@ -472,14 +626,33 @@ func (client *Client) AddBuckets(
 
 	req := protobuf.NewAddBucketsRequest(topic, instances)
 	req.ReqTimestamps = reqTimestamps
+	return client.addBuckets(req)
+}
+
+// AddBucketsWithToken is same as AddBuckets, in addition endpoints
+// started for this topic will frame their connection with registration
+// `token`, refer MutationTopicRequestWithToken().
+func (client *Client) AddBucketsWithToken(
+	topic, token string, reqTimestamps []*protobuf.TsVbuuid,
+	instances []*protobuf.Instance) (*protobuf.TopicResponse, error) {
+
+	req := protobuf.NewAddBucketsRequest(topic, instances)
+	req.ReqTimestamps = reqTimestamps
+	req.SetToken(token)
+	return client.addBuckets(req)
+}
+
+func (client *Client) addBuckets(
+	req *protobuf.AddBucketsRequest) (*protobuf.TopicResponse, error) {
+
 	res := &protobuf.TopicResponse{}
 	err := client.withRetry(
 		func() error {
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if protoerr := res.GetErr(); protoerr != nil {
-				return fmt.Errorf(protoerr.GetError())
+			} else if protoerr := res.GetErr().ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
@@ -503,8 +676,8 @@ func (client *Client) DelBuckets(topic string, buckets []string) error {
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if s := res.GetError(); s != "" {
-				return fmt.Errorf(s)
+			} else if protoerr := res.ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
@@ -532,8 +705,8 @@ func (client *Client) AddInstances(
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if s := res.GetError(); s != "" {
-				return fmt.Errorf(s)
+			} else if protoerr := res.ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
@@ -559,8 +732,114 @@ func (client *Client) DelInstances(topic string, uuids []uint64) error {
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if s := res.GetError(); s != "" {
-				return fmt.Errorf(s)
+			} else if protoerr := res.ToError(); protoerr != nil {
+				return protoerr
+			}
+			return err // nil
+		})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DisableInstances will stop routing mutations to one or more
+// instances, their definitions are retained on the topic and downstream
+// endpoints no longer expect data for them. Useful when an index's slice
+// is under repair. Idempotent API.
+//
+// Possible errors returned,
+// - http errors for transport related failures.
+// - ErrorTopicMissing if feed is not started.
+// - ErrorInvalidInstance if an instance is not defined on the topic.
+func (client *Client) DisableInstances(topic string, uuids []uint64) error {
+	req := protobuf.NewDisableInstancesRequest(topic, uuids)
+	res := &protobuf.Error{}
+	err := client.withRetry(
+		func() error {
+			err := client.ap.Request(req, res)
+			if err != nil {
+				return err
+			} else if protoerr := res.ToError(); protoerr != nil {
+				return protoerr
+			}
+			return err // nil
+		})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// EnableInstances will resume routing mutations to one or more
+// instances disabled by DisableInstances(). Idempotent API.
+//
+// Possible errors returned,
+// - http errors for transport related failures.
+// - ErrorTopicMissing if feed is not started.
+// - ErrorInvalidInstance if an instance is not defined on the topic.
+func (client *Client) EnableInstances(topic string, uuids []uint64) error {
+	req := protobuf.NewEnableInstancesRequest(topic, uuids)
+	res := &protobuf.Error{}
+	err := client.withRetry(
+		func() error {
+			err := client.ap.Request(req, res)
+			if err != nil {
+				return err
+			} else if protoerr := res.ToError(); protoerr != nil {
+				return protoerr
+			}
+			return err // nil
+		})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// PauseTopic will stop draining mutations from upstream and routing
+// them to endpoints, vbucket streams are retained and downstream can
+// resume the topic from where it was paused. Requests that start or
+// shutdown vbucket streams fail with ErrorTopicPaused while the topic is
+// paused. Idempotent API.
+//
+// Possible errors returned,
+// - http errors for transport related failures.
+// - ErrorTopicMissing if feed is not started.
+func (client *Client) PauseTopic(topic string) error {
+	req := protobuf.NewPauseTopicRequest(topic)
+	res := &protobuf.Error{}
+	err := client.withRetry(
+		func() error {
+			err := client.ap.Request(req, res)
+			if err != nil {
+				return err
+			} else if protoerr := res.ToError(); protoerr != nil {
+				return protoerr
+			}
+			return err // nil
+		})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResumeTopic will resume a topic paused by PauseTopic(). Idempotent API.
+//
+// Possible errors returned,
+// - http errors for transport related failures.
+// - ErrorTopicMissing if feed is not started.
+func (client *Client) ResumeTopic(topic string) error {
+	req := protobuf.NewResumeTopicRequest(topic)
+	res := &protobuf.Error{}
+	err := client.withRetry(
+		func() error {
+			err := client.ap.Request(req, res)
+			if err != nil {
+				return err
+			} else if protoerr := res.ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
@@ -572,27 +851,65 @@ func (client *Client) DelInstances(topic string, uuids []uint64) error {
 
 // RepairEndpoints will restart endpoints. Idempotent API.
 //
+// - return RepairEndpointsResponse with outcome of each endpoint,
+//   repaired, already active or failed with reason. Projectors that
+//   predate per-endpoint results respond without any outcome.
 // - return http errors for transport related failures.
 // - return ErrorTopicMissing if feed is not started.
 func (client *Client) RepairEndpoints(
-	topic string, endpoints []string) error {
+	topic string,
+	endpoints []string) (*protobuf.RepairEndpointsResponse, error) {
 
 	req := protobuf.NewRepairEndpointsRequest(topic, endpoints)
-	res := &protobuf.Error{}
+	req.Results = proto.Bool(true)
+	res := &protobuf.RepairEndpointsResponse{}
 	err := client.withRetry(
 		func() error {
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if s := res.GetError(); s != "" {
-				return fmt.Errorf(s)
+			} else if protoerr := res.GetErr().ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return res, nil
+}
+
+// TopicOperations will apply a batch of AddBuckets, AddInstances and
+// RestartVbuckets, in that order, on an existing topic with a single
+// round trip. Projector applies the batch without interleaving other
+// requests on the same topic. Idempotent API, provided the individual
+// operations are idempotent.
+//
+// Operations are not atomic across buckets, failed buckets of each
+// operation are listed in response, refer FailedBuckets().
+//
+// - return TopicOperationsResponse that contain per-operation errors and
+//   TopicResponse reflecting the topic after all operations.
+// - return http errors for transport related failures.
+// - return ErrorTopicMissing if feed is not started.
+func (client *Client) TopicOperations(
+	req *protobuf.TopicOperationsRequest) (*protobuf.TopicOperationsResponse, error) {
+
+	res := &protobuf.TopicOperationsResponse{}
+	err := client.withRetry(
+		func() error {
+			err := client.ap.Request(req, res)
+			if err != nil {
+				return err
+			} else if protoerr := res.GetErr().ToError(); protoerr != nil {
+				return protoerr
+			}
+			return err // nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 // ShutdownTopic will stop the feed for topic. Idempotent API.
@@ -607,8 +924,8 @@ func (client *Client) ShutdownTopic(topic string) error {
 			err := client.ap.Request(req, res)
 			if err != nil {
 				return err
-			} else if s := res.GetError(); s != "" {
-				return fmt.Errorf(s)
+			} else if protoerr := res.ToError(); protoerr != nil {
+				return protoerr
 			}
 			return err // nil
 		})
@@ -618,6 +935,37 @@ func (client *Client) ShutdownTopic(topic string) error {
 	return nil
 }
 
+// Probe will check whether projector can stream `bucketn` to endpoint
+// `raddr`, by validating failover logs of vbuckets `vbnos`, or of all
+// vbuckets local to projector if empty, opening a DCP connection with the
+// bucket and connecting with the endpoint. Nothing is left running on
+// projector, hence topic can be any name not in use.
+//
+// - return ProbeResponse with diagnostics, also along with errors below.
+// - return http errors for transport related failures.
+// - return ErrorFeeder if DCP connection cannot be opened.
+// - return ErrorInvalidVbucket for missing or invalid failover logs.
+// - return ErrorEndpoint if endpoint cannot be connected.
+func (client *Client) Probe(
+	topic, endpointType, pooln, bucketn, raddr string,
+	vbnos []uint16) (*protobuf.ProbeResponse, error) {
+
+	req := protobuf.NewProbeRequest(
+		topic, endpointType, pooln, bucketn, raddr, vbnos)
+	res := &protobuf.ProbeResponse{}
+	err := client.withRetry(
+		func() error {
+			err := client.ap.Request(req, res)
+			if err != nil {
+				return err
+			} else if protoerr := res.GetErr().ToError(); protoerr != nil {
+				return protoerr
+			}
+			return err // nil
+		})
+	return res, err
+}
+
 // InitialRestartTimestamp will compose the initial set of timestamp
 // for a subset of vbuckets in `bucket`.
 // - return http errors for transport related failures.
@@ -0,0 +1,226 @@
+package projector
+
+import "sync/atomic"
+
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+import c "github.com/couchbase/indexing/secondary/common"
+
+// elasticQueue is a FIFO ring buffer whose capacity follows its
+// occupancy, within [minSize, maxSize]. Capacity doubles when the queue
+// is full and halves when less than a quarter of it is used, so that a
+// feed idling after a burst of load does not hold on to large buffers.
+//
+// Queue is owned by a single routine, statistics can be read
+// concurrently.
+type elasticQueue struct {
+	items   []interface{}
+	head    int
+	n       int
+	minSize int
+	maxSize int
+	// statistics
+	length    int64 // no. of items queued
+	capacity  int64 // current capacity
+	maxLength int64 // peak value of length
+	grows     c.Counter
+	shrinks   c.Counter
+}
+
+func newElasticQueue(minSize, maxSize int) *elasticQueue {
+	if minSize < 1 {
+		minSize = 1
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+	q := &elasticQueue{
+		items:   make([]interface{}, minSize),
+		minSize: minSize,
+		maxSize: maxSize,
+	}
+	q.update()
+	return q
+}
+
+func (q *elasticQueue) isEmpty() bool {
+	return q.n == 0
+}
+
+// isFull returns true only when the queue cannot grow any further.
+func (q *elasticQueue) isFull() bool {
+	return q.n >= q.maxSize
+}
+
+func (q *elasticQueue) push(item interface{}) {
+	if q.n == len(q.items) {
+		size := 2 * len(q.items)
+		if size > q.maxSize {
+			size = q.maxSize
+		}
+		q.resize(size)
+		q.grows.Add(1)
+	}
+	q.items[(q.head+q.n)%len(q.items)] = item
+	q.n++
+	q.update()
+}
+
+func (q *elasticQueue) peek() interface{} {
+	return q.items[q.head]
+}
+
+func (q *elasticQueue) pop() interface{} {
+	item := q.items[q.head]
+	q.items[q.head] = nil
+	q.head = (q.head + 1) % len(q.items)
+	q.n--
+	if q.n < len(q.items)/4 {
+		size := len(q.items) / 2
+		if size < q.minSize {
+			size = q.minSize
+		}
+		if size < len(q.items) {
+			q.resize(size)
+			q.shrinks.Add(1)
+		}
+	}
+	q.update()
+	return item
+}
+
+func (q *elasticQueue) resize(size int) {
+	items := make([]interface{}, size)
+	for i := 0; i < q.n; i++ {
+		items[i] = q.items[(q.head+i)%len(q.items)]
+	}
+	q.items, q.head = items, 0
+}
+
+func (q *elasticQueue) update() {
+	atomic.StoreInt64(&q.length, int64(q.n))
+	atomic.StoreInt64(&q.capacity, int64(len(q.items)))
+	if int64(q.n) > atomic.LoadInt64(&q.maxLength) {
+		atomic.StoreInt64(&q.maxLength, int64(q.n))
+	}
+}
+
+func (q *elasticQueue) statistics() map[string]interface{} {
+	return map[string]interface{}{
+		"length":    float64(atomic.LoadInt64(&q.length)),
+		"capacity":  float64(atomic.LoadInt64(&q.capacity)),
+		"maxLength": float64(atomic.LoadInt64(&q.maxLength)),
+		"minSize":   float64(q.minSize),
+		"maxSize":   float64(q.maxSize),
+		"grows":     &q.grows,
+		"shrinks":   &q.shrinks,
+	}
+}
+
+// elasticChan is a gen-server channel buffered by an elasticQueue,
+// messages sent on `in` are received on `out` in the same order. Senders
+// block only when maxSize messages are pending.
+type elasticChan struct {
+	in    chan []interface{}
+	out   chan []interface{}
+	queue *elasticQueue
+}
+
+// newElasticChan creates a channel that is served until `finch` is
+// closed.
+func newElasticChan(minSize, maxSize int, finch chan bool) *elasticChan {
+	ec := &elasticChan{
+		in:    make(chan []interface{}),
+		out:   make(chan []interface{}),
+		queue: newElasticQueue(minSize, maxSize),
+	}
+	go ec.run(finch)
+	return ec
+}
+
+// Len returns the no. of messages pending on the channel.
+func (ec *elasticChan) Len() int {
+	return int(atomic.LoadInt64(&ec.queue.length))
+}
+
+func (ec *elasticChan) run(finch chan bool) {
+	for {
+		in, out := ec.in, ec.out
+		var next []interface{}
+		if ec.queue.isFull() {
+			in = nil
+		}
+		if ec.queue.isEmpty() {
+			out = nil
+		} else {
+			next = ec.queue.peek().([]interface{})
+		}
+
+		select {
+		case msg := <-in:
+			ec.queue.push(msg)
+		case out <- next:
+			ec.queue.pop()
+		case <-finch:
+			return
+		}
+	}
+}
+
+// mutationBatchSize is the maximum no. of mutations handed over to
+// kvdata in a single batch.
+const mutationBatchSize = 64
+
+// elasticMutch buffers mutations received from upstream in an
+// elasticQueue, mutations are received on `out` in batches, in the same
+// order. `out` is closed once upstream is closed and its mutations are
+// drained.
+type elasticMutch struct {
+	out   chan []*mc.UprEvent
+	queue *elasticQueue
+}
+
+// newElasticMutch buffers `mutch` until it is closed or until `finch` is
+// closed.
+func newElasticMutch(
+	mutch <-chan *mc.UprEvent, minSize, maxSize int,
+	finch chan bool) *elasticMutch {
+
+	em := &elasticMutch{
+		// one batch in flight while the next one is gathered.
+		out:   make(chan []*mc.UprEvent, 1),
+		queue: newElasticQueue(minSize, maxSize),
+	}
+	go em.run(mutch, finch)
+	return em
+}
+
+func (em *elasticMutch) run(mutch <-chan *mc.UprEvent, finch chan bool) {
+	defer close(em.out)
+
+	var batch []*mc.UprEvent
+	for mutch != nil || !em.queue.isEmpty() || len(batch) > 0 {
+		for len(batch) < mutationBatchSize && !em.queue.isEmpty() {
+			batch = append(batch, em.queue.pop().(*mc.UprEvent))
+		}
+		in, out := mutch, em.out
+		if em.queue.isFull() {
+			in = nil
+		}
+		if len(batch) == 0 {
+			out = nil
+		}
+
+		select {
+		case m, ok := <-in:
+			if !ok { // upstream has closed, drain
+				mutch = nil
+				continue
+			}
+			em.queue.push(m)
+		case out <- batch:
+			batch = nil
+		case <-finch:
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package projector
+
+import "testing"
+
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+
+func TestElasticQueue(t *testing.T) {
+	q := newElasticQueue(4, 32)
+	for i := 0; i < 32; i++ {
+		q.push(i)
+	}
+	if !q.isFull() || q.capacity != 32 || q.grows.Value() != 3 {
+		t.Fatalf("unexpected queue %v/%v, grows %v",
+			q.n, q.capacity, q.grows.Value())
+	}
+	for i := 0; i < 32; i++ {
+		if v := q.pop().(int); v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	if !q.isEmpty() || q.capacity != 4 || q.shrinks.Value() != 3 {
+		t.Fatalf("unexpected queue %v/%v, shrinks %v",
+			q.n, q.capacity, q.shrinks.Value())
+	}
+	if stats := q.statistics(); stats["maxLength"] != float64(32) {
+		t.Fatalf("unexpected maxLength %v", stats["maxLength"])
+	}
+}
+
+func TestElasticChan(t *testing.T) {
+	finch := make(chan bool)
+	defer close(finch)
+
+	ec := newElasticChan(2, 8, finch)
+	for i := 0; i < 8; i++ { // does not block upto maxSize
+		ec.in <- []interface{}{i}
+	}
+	for i := 0; i < 8; i++ {
+		if msg := <-ec.out; msg[0].(int) != i {
+			t.Fatalf("expected %v, got %v", i, msg[0])
+		}
+	}
+
+	mutch := make(chan *mc.UprEvent)
+	em := newElasticMutch(mutch, 2, 8, finch)
+	for i := 0; i < 8; i++ {
+		mutch <- &mc.UprEvent{Seqno: uint64(i)}
+	}
+	close(mutch)
+	seqno := uint64(0)
+	for batch := range em.out { // drained before closed
+		for _, m := range batch {
+			if m.Seqno != seqno {
+				t.Fatalf("expected %v, got %v", seqno, m.Seqno)
+			}
+			seqno++
+		}
+	}
+	if seqno != 8 {
+		t.Fatalf("expected 8 mutations, got %v", seqno)
+	}
+}
+
+func TestElasticMutchBatch(t *testing.T) {
+	finch := make(chan bool)
+	defer close(finch)
+
+	n := 3*mutationBatchSize + 1
+	mutch := make(chan *mc.UprEvent)
+	em := newElasticMutch(mutch, 2, n, finch)
+	for i := 0; i < n; i++ { // does not block upto maxSize
+		mutch <- &mc.UprEvent{Seqno: uint64(i)}
+	}
+	close(mutch)
+	seqno, batches := uint64(0), 0
+	for batch := range em.out {
+		if len(batch) == 0 || len(batch) > mutationBatchSize {
+			t.Fatalf("unexpected batch of %v mutations", len(batch))
+		}
+		for _, m := range batch {
+			if m.Seqno != seqno {
+				t.Fatalf("expected %v, got %v", seqno, m.Seqno)
+			}
+			seqno++
+		}
+		batches++
+	}
+	if seqno != uint64(n) {
+		t.Fatalf("expected %v mutations, got %v", n, seqno)
+	} else if batches >= n {
+		t.Fatalf("expected mutations to be batched, got %v batches", batches)
+	}
+}
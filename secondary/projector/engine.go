@@ -32,6 +32,43 @@ func (engine *Engine) Endpoints() []string {
 	return engine.router.Endpoints()
 }
 
+// VbucketEndpoints hosting this engine and owning vbucket `vbno`.
+func (engine *Engine) VbucketEndpoints(vbno uint16) []string {
+	return engine.router.VbucketEndpoints(vbno)
+}
+
+// BucketUUID is the uuid of bucket when this engine was defined, empty
+// string if not known.
+func (engine *Engine) BucketUUID() string {
+	return engine.evaluator.BucketUUID()
+}
+
+// Namespace is the key prefix of documents evaluated by this engine,
+// empty string if it evaluates all documents of its bucket.
+func (engine *Engine) Namespace() string {
+	return engine.evaluator.Namespace()
+}
+
+// InNamespace tells whether document `key` is within engine's namespace.
+func (engine *Engine) InNamespace(key []byte) bool {
+	return inNamespace(engine.evaluator.Namespace(), key)
+}
+
+// defaultNamespace names, in statistics, the namespace of engines that
+// evaluate all documents of their bucket.
+const defaultNamespace = "_default"
+
+func inNamespace(ns string, key []byte) bool {
+	return len(key) >= len(ns) && string(key[:len(ns)]) == ns
+}
+
+func namespaceName(ns string) string {
+	if ns == "" {
+		return defaultNamespace
+	}
+	return ns
+}
+
 // StreamBeginData from this engine.
 func (engine *Engine) StreamBeginData(
 	vbno uint16, vbuuid, seqno uint64) interface{} {
@@ -0,0 +1,133 @@
+// event log retains recent control path events of a topic, like streams
+// begun and ended, rollbacks, endpoint repairs and errors, so that they
+// can be looked up after an incident without debug logging.
+
+package projector
+
+import "encoding/json"
+import "fmt"
+import "net/http"
+import "sync"
+import "time"
+
+// control path events recorded in event log.
+const (
+	eventStreamBegin   = "streamBegin"
+	eventStreamEnd     = "streamEnd"
+	eventRollback      = "rollback"
+	eventStreamFailed  = "streamRequestFailed"
+	eventCatchupEnd    = "catchupEnd"
+	eventConnReset     = "connectionReset"
+	eventRepair        = "repairEndpoint"
+	eventError         = "error"
+	eventFeedShutdown  = "shutdown"
+	eventBucketCleanup = "bucketCleanup"
+	eventPause         = "pause"
+	eventResume        = "resume"
+)
+
+// FeedEvent on the control path of a feed.
+type FeedEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Bucket string    `json:"bucket,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// eventLog is shared by successive feeds of a topic, thread safe. Methods
+// of a nil eventLog are no-ops.
+type eventLog struct {
+	mu       sync.Mutex
+	events   []*FeedEvent // ring buffer
+	next     int          // index of next event in ring buffer
+	recorded int64        // no. of events recorded
+}
+
+// newEventLog returns nil if event log is disabled.
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		return nil
+	}
+	return &eventLog{events: make([]*FeedEvent, 0, size)}
+}
+
+// record an event for `bucket`, which is empty for events of the topic.
+func (el *eventLog) record(
+	event, bucket string, format string, args ...interface{}) {
+
+	if el == nil {
+		return
+	}
+	ev := &FeedEvent{
+		Time:   time.Now(),
+		Event:  event,
+		Bucket: bucket,
+		Detail: fmt.Sprintf(format, args...),
+	}
+
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	if len(el.events) < cap(el.events) {
+		el.events = append(el.events, ev)
+	} else {
+		el.events[el.next] = ev
+	}
+	el.next = (el.next + 1) % cap(el.events)
+	el.recorded++
+}
+
+// recent events, oldest first, filtered by `bucket` unless it is empty.
+// Events of the topic are not filtered.
+func (el *eventLog) recent(bucket string) []*FeedEvent {
+	if el == nil {
+		return nil
+	}
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	events := make([]*FeedEvent, 0, len(el.events))
+	start := 0
+	if len(el.events) == cap(el.events) {
+		start = el.next
+	}
+	for i := 0; i < len(el.events); i++ {
+		ev := el.events[(start+i)%len(el.events)]
+		if bucket == "" || ev.Bucket == "" || ev.Bucket == bucket {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+func (el *eventLog) statistics() map[string]interface{} {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	return map[string]interface{}{
+		"size":     float64(cap(el.events)),
+		"recorded": float64(el.recorded),
+	}
+}
+
+// handleEvents responds with recent control path events of a topic, as
+// JSON, oldest first. Events are retained across feeds of the topic, even
+// after the topic is shutdown. Query parameter `topic` is mandatory,
+// `bucket` filters the events to that bucket.
+//
+// eg: curl "http://localhost:9999/events?topic=maintenance&bucket=default"
+func (p *Projector) handleEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	topic := query.Get("topic")
+	if topic == "" {
+		http.Error(w, "missing topic", http.StatusBadRequest)
+		return
+	}
+	p.mu.RLock()
+	el, ok := p.events[topic]
+	p.mu.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("no events for topic %q", topic), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(el.recent(query.Get("bucket")))
+}
@@ -92,6 +92,11 @@ func (b *FakeBucket) EndVbStreams(
 	return
 }
 
+// GetStatistics is method receiver for BucketFeeder interface
+func (b *FakeBucket) GetStatistics() map[string]interface{} {
+	return make(map[string]interface{})
+}
+
 // CloseFeed is method receiver for BucketFeeder interface
 func (b *FakeBucket) CloseFeed() (err error) {
 	return
@@ -2,6 +2,10 @@ package projector
 
 import "fmt"
 import "time"
+import "sort"
+import "strconv"
+import "context"
+import "sync/atomic"
 import "runtime/debug"
 
 import "github.com/couchbase/indexing/secondary/dcp"
@@ -17,6 +21,8 @@ type Feed struct {
 	cluster      string // immutable
 	topic        string // immutable
 	endpointType string // immutable
+	token        string // registration token issued by downstream
+	version      uint32 // mutation stream version understood downstream
 
 	// upstream
 	// reqTs, book-keeping on outstanding request posted to feeder.
@@ -29,192 +35,598 @@ type Feed struct {
 	// rollTs, when StreamBegin ROLLBACK response is got back from UPR,
 	// vbucket entry is moved here.
 	rollTss map[string]*protobuf.TsVbuuid // bucket -> TsVbuuid
+	// localVbs, vbuckets local to this node, framed to endpoints.
+	localVbs map[string][]uint16 // bucket -> vbuckets
+	// endTss, for catchup topic, seqno at which each vbucket stream
+	// shall be ended.
+	endTss map[string]*protobuf.TsVbuuid // bucket -> TsVbuuid
+	// catchupTss, for catchup topic, vbuckets that reached their end
+	// seqno and whose streams are ended.
+	catchupTss map[string]*protobuf.TsVbuuid // bucket -> TsVbuuid
 
 	feeders map[string]BucketFeeder // bucket -> BucketFeeder{}
+	// connResets, upstream connections failed for a bucket, retained
+	// across bucket cleanup for the life of the feed.
+	connResets map[string]int64 // bucket -> count
+	// bucketUUIDs, uuid of bucket when its upstream was first started.
+	bucketUUIDs map[string]string // bucket -> uuid
+	// staleBuckets, buckets flushed or recreated after their indexes were
+	// defined, retained until the bucket is deleted from the feed.
+	staleBuckets map[string]bool
 	// downstream
 	kvdata    map[string]*KVData            // bucket -> kvdata
 	engines   map[string]map[uint64]*Engine // bucket -> uuid -> engine
 	endpoints map[string]c.RouterEndpoint
-	// genServer channel
-	reqch  chan []interface{}
-	backch chan []interface{}
+	// disabled, engines retained on the feed but not routed to, until
+	// they are enabled again.
+	disabled map[uint64]bool // uuid -> true
+	// paused, kv data-paths neither drain upstream nor route mutations,
+	// until the feed is resumed.
+	paused bool
+	// genServer channel, sized by load between feedChanMinSize and
+	// feedChanSize.
+	reqch  *elasticChan
+	backch *elasticChan
 	finch  chan bool
+	state  int32 // feedInitializing, feedActive, feedDraining, feedClosed
+
+	// feedback book-keeping
+	lateWaits      map[feedbackKey]time.Time // vbuckets of timed out waits
+	staleFeedback  []interface{}             // applied once the request is done
+	nLateFeedback  c.Counter                 // feedback received after its wait timed out
+	nStaleFeedback c.Counter                 // feedback left unclaimed for staleTimeout
+	nStreamRetries c.Counter                 // StreamRequests re-issued for failed vbuckets
+	reqLatency     *c.Histogram              // time taken by StreamRequests, with retries
+	resources      *topicResources           // cpu and memory used by data path
+	events         *eventLog                 // control path events, nil if disabled
+
+	// startup timings, refer timePhase()
+	timings      map[string]*protobuf.BucketTimings // bucket -> last request
+	phaseLatency map[string]*c.Histogram            // phase -> time per call
 
 	// config params
-	maxVbuckets int
-	reqTimeout  time.Duration
-	endTimeout  time.Duration
-	epFactory   c.RouterEndpointFactory
-	config      c.Config
-	logPrefix   string
+	maxVbuckets  int
+	maxBuckets   int // maximum buckets on this feed, 0 for no limit
+	maxEngines   int // maximum engines per bucket, 0 for no limit
+	reqTimeout   time.Duration
+	endTimeout   time.Duration
+	staleTimeout time.Duration
+	epFactory    c.RouterEndpointFactory
+	kv           KVConnector
+	config       c.Config
+	logPrefix    string
+
+	// vbuckets whose StreamRequest failed, re-requested in background
+	// with exponential backoff, refer retryVbuckets().
+	retries *vbRetryQueue
+	retryAt time.Time // when fCmdRetryVbuckets is due, zero if not armed
+
+	// pacing of StreamRequests, refer streamBatches()
+	reqBatchSize     int // 0 requests all vbuckets at once
+	reqBatchInterval time.Duration
+	batchRuns        map[streamBatchKey]*streamBatchRun
 }
 
 // NewFeed creates a new topic feed.
 // `config` contains following keys.
 //    maxVbuckets: configured number vbuckets per bucket.
+//    maxBucketsPerTopic: maximum number of buckets on this feed
+//    maxEnginesPerBucket: maximum number of engines per bucket
 //    clusterAddr: KV cluster address <host:port>.
+//    username: username for bucket connections, empty for cbauth
+//    password: password for bucket connections, empty for cbauth
 //    feedWaitStreamReqTimeout: wait for a response to StreamRequest
 //    feedWaitStreamEndTimeout: wait for a response to StreamEnd
+//    staleFeedbackTimeout: purge unclaimed feedback older than this
+//    feedRetryInterval: initial backoff to re-request failed vbuckets
+//    feedRetryMaxInterval: maximum backoff to re-request failed vbuckets
+//    feedRetryBudget: time failed vbuckets are re-requested, 0 disables
+//    feedStreamReqBatchSize: vbuckets requested per batch, 0 disables pacing
+//    feedStreamReqBatchInterval: pause between batches of StreamRequests
 //    feedChanSize: channel size for feed's control path and back path
+//    feedChanMinSize: channel size that feed's channels shrink back to
 //    mutationChanSize: channel size of projector's data path routine
+//    mutationChanMinSize: buffer size that upstream mutations shrink back to
 //    vbucketSyncTimeout: timeout, in ms, for sending periodic Sync messages
+//    routingAuditSamples: mutations audited out of routingAuditPeriod
+//    routingAuditPeriod: mutations over which routing audit samples
+//    mutationSampleEvery: sample every Nth mutation, 0 disables
+//    mutationSampleSize: recent mutation samples retained per bucket
+//    feedEventLogSize: recent control path events retained, 0 disables
+//    eventLog: optional event log shared with earlier feeds of topic
 //    routerEndpointFactory: endpoint factory
+//    kvConnector: optional KVConnector{} to use instead of clusterAddr
 func NewFeed(topic string, config c.Config) (*Feed, error) {
 	epf := config["routerEndpointFactory"].Value.(c.RouterEndpointFactory)
 	chsize := config["feedChanSize"].Int()
+	minChsize := config["feedChanMinSize"].Int()
+	finch := make(chan bool)
 	feed := &Feed{
 		cluster: config["clusterAddr"].String(),
 		topic:   topic,
 
 		// upstream
-		reqTss:  make(map[string]*protobuf.TsVbuuid),
-		actTss:  make(map[string]*protobuf.TsVbuuid),
-		rollTss: make(map[string]*protobuf.TsVbuuid),
-		feeders: make(map[string]BucketFeeder),
+		reqTss:   make(map[string]*protobuf.TsVbuuid),
+		actTss:   make(map[string]*protobuf.TsVbuuid),
+		rollTss:  make(map[string]*protobuf.TsVbuuid),
+		localVbs: make(map[string][]uint16),
+		feeders:  make(map[string]BucketFeeder),
+		// catchup
+		endTss:     make(map[string]*protobuf.TsVbuuid),
+		catchupTss: make(map[string]*protobuf.TsVbuuid),
+		// connection resets
+		connResets: make(map[string]int64),
+		// bucket uuids
+		bucketUUIDs:  make(map[string]string),
+		staleBuckets: make(map[string]bool),
 		// downstream
 		kvdata:    make(map[string]*KVData),
 		engines:   make(map[string]map[uint64]*Engine),
 		endpoints: make(map[string]c.RouterEndpoint),
+		disabled:  make(map[uint64]bool),
 		// genServer channel
-		reqch:  make(chan []interface{}, chsize),
-		backch: make(chan []interface{}, chsize),
-		finch:  make(chan bool),
-
-		maxVbuckets: config["maxVbuckets"].Int(),
-		reqTimeout:  time.Duration(config["feedWaitStreamReqTimeout"].Int()),
-		endTimeout:  time.Duration(config["feedWaitStreamEndTimeout"].Int()),
-		epFactory:   epf,
-		config:      config,
+		reqch:  newElasticChan(minChsize, chsize, finch),
+		backch: newElasticChan(minChsize, chsize, finch),
+		finch:  finch,
+		state:  feedInitializing,
+		// feedback book-keeping
+		lateWaits:  make(map[feedbackKey]time.Time),
+		reqLatency: c.NewLatencyHistogram(),
+		resources:  newTopicResources(),
+		// startup timings
+		timings: make(map[string]*protobuf.BucketTimings),
+		phaseLatency: map[string]*c.Histogram{
+			phaseVbmap:         c.NewLatencyHistogram(),
+			phaseFailoverLog:   c.NewLatencyHistogram(),
+			phaseStreamRequest: c.NewLatencyHistogram(),
+			phaseFeedback:      c.NewLatencyHistogram(),
+		},
+
+		maxVbuckets:  config["maxVbuckets"].Int(),
+		maxBuckets:   config["maxBucketsPerTopic"].Int(),
+		maxEngines:   config["maxEnginesPerBucket"].Int(),
+		reqTimeout:   time.Duration(config["feedWaitStreamReqTimeout"].Int()),
+		endTimeout:   time.Duration(config["feedWaitStreamEndTimeout"].Int()),
+		staleTimeout: time.Duration(config["staleFeedbackTimeout"].Int()),
+		epFactory:    epf,
+		config:       config,
+
+		retries: newVbRetryQueue(
+			time.Duration(config["feedRetryInterval"].Int())*time.Millisecond,
+			time.Duration(config["feedRetryMaxInterval"].Int())*time.Millisecond,
+			time.Duration(config["feedRetryBudget"].Int())*time.Millisecond),
+
+		reqBatchSize:     config["feedStreamReqBatchSize"].Int(),
+		reqBatchInterval: time.Duration(config["feedStreamReqBatchInterval"].Int()),
+		batchRuns:        make(map[streamBatchKey]*streamBatchRun),
+	}
+	feed.logPrefix = fmt.Sprintf("FEED[<=>%v(%v)]", topic, c.RedactURL(feed.cluster))
+	if el, ok := config["eventLog"]; ok {
+		feed.events, _ = el.Value.(*eventLog)
+	} else {
+		feed.events = newEventLog(config["feedEventLogSize"].Int())
+	}
+	if kv, ok := config["kvConnector"]; ok && kv.Value != nil {
+		feed.kv = kv.Value.(KVConnector)
+	} else {
+		feed.kv = &clusterKV{feed: feed}
 	}
-	feed.logPrefix = fmt.Sprintf("FEED[<=>%v(%v)]", topic, feed.cluster)
 
 	go feed.genServer()
 	c.Infof("%v started ...\n", feed.logPrefix)
 	return feed, nil
 }
 
+// feed states,
+//   feedInitializing -> feedActive -> feedDraining -> feedClosed
+// requests are accepted only while the feed is initializing or
+// active, they get queued until gen-server becomes active.
+const (
+	feedInitializing int32 = iota
+	feedActive
+	feedDraining
+	feedClosed
+)
+
+// phases of starting vbucket streams for a bucket, timed for requests
+// that start or restart streams, refer timePhase().
+const (
+	phaseVbmap         = "vbmap"
+	phaseFailoverLog   = "failoverLog"
+	phaseStreamRequest = "streamRequest"
+	phaseFeedback      = "feedback"
+)
+
+func feedStateString(state int32) string {
+	switch state {
+	case feedInitializing:
+		return "initializing"
+	case feedActive:
+		return "active"
+	case feedDraining:
+		return "draining"
+	case feedClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+func (feed *Feed) getState() int32 {
+	return atomic.LoadInt32(&feed.state)
+}
+
+func (feed *Feed) setState(state int32) {
+	old := atomic.SwapInt32(&feed.state, state)
+	c.Debugf("%v state %v -> %v\n",
+		feed.logPrefix, feedStateString(old), feedStateString(state))
+}
+
+// failsafeOp posts a synchronous request to gen-server.
+// - return ErrorFeedClosed if feed is draining or closed, or
+//   gen-server exits before responding.
+// - return ErrorRequestTimeout if ctx deadline expires before
+//   gen-server responds.
+// - return ctx.Err() if ctx is cancelled by the caller.
+func (feed *Feed) failsafeOp(
+	ctx context.Context,
+	respch chan []interface{}, cmd []interface{}) ([]interface{}, error) {
+
+	if state := feed.getState(); state == feedDraining || state == feedClosed {
+		return nil, c.CountError(projC.ErrorFeedClosed)
+	}
+	resp, err := c.FailsafeOpContext(ctx, feed.reqch.in, respch, cmd, feed.finch)
+	if err == c.ErrorClosed {
+		return nil, c.CountError(projC.ErrorFeedClosed)
+	} else if err == context.DeadlineExceeded {
+		c.Errorf("%v request %v timed out\n", feed.logPrefix, cmd[0])
+		return nil, c.CountError(projC.ErrorRequestTimeout)
+	}
+	return resp, err
+}
+
+// topicResponse that shall be returned when gen-server could not
+// serve the request.
+func (feed *Feed) failedTopicResponse() *protobuf.TopicResponse {
+	return &protobuf.TopicResponse{Topic: proto.String(feed.topic)}
+}
+
 const (
 	fCmdStart byte = iota + 1
+	fCmdCatchupTopic
 	fCmdRestartVbuckets
 	fCmdShutdownVbuckets
 	fCmdAddBuckets
 	fCmdDelBuckets
 	fCmdAddInstances
 	fCmdDelInstances
+	fCmdDisableInstances
+	fCmdEnableInstances
+	fCmdPauseTopic
+	fCmdResumeTopic
 	fCmdRepairEndpoints
+	fCmdTopicOperations
+	fCmdProbe
 	fCmdShutdown
 	fCmdGetTopicResponse
 	fCmdGetStatistics
+	fCmdGetMutationSamples
+	fCmdRetryVbuckets
+	fCmdStreamBatch
 )
 
 // MutationTopic will start the feed.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
 // Synchronous call.
 func (feed *Feed) MutationTopic(
+	ctx context.Context,
 	req *protobuf.MutationTopicRequest) (*protobuf.TopicResponse, error) {
 
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{fCmdStart, req, respch}
-	resp, err := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	if err != nil {
+		return feed.failedTopicResponse(), err
+	}
+	return resp[0].(*protobuf.TopicResponse), c.OpError(err, resp, 1)
+}
+
+// CatchupTopic will start the feed as catchup topic, each vbucket
+// stream is ended once it reaches its end seqno.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
+// Synchronous call.
+func (feed *Feed) CatchupTopic(
+	ctx context.Context,
+	req *protobuf.CatchupTopicRequest) (*protobuf.TopicResponse, error) {
+
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{fCmdCatchupTopic, req, respch}
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	if err != nil {
+		return feed.failedTopicResponse(), err
+	}
 	return resp[0].(*protobuf.TopicResponse), c.OpError(err, resp, 1)
 }
 
 // RestartVbuckets will restart upstream vbuckets for specified buckets.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
 // Synchronous call.
 func (feed *Feed) RestartVbuckets(
+	ctx context.Context,
 	req *protobuf.RestartVbucketsRequest) (*protobuf.TopicResponse, error) {
 
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{fCmdRestartVbuckets, req, respch}
-	resp, err := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	if err != nil {
+		return feed.failedTopicResponse(), err
+	}
 	return resp[0].(*protobuf.TopicResponse), c.OpError(err, resp, 1)
 }
 
 // ShutdownVbuckets will shutdown streams for
 // specified buckets.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
 // Synchronous call.
-func (feed *Feed) ShutdownVbuckets(req *protobuf.ShutdownVbucketsRequest) error {
+func (feed *Feed) ShutdownVbuckets(
+	ctx context.Context, req *protobuf.ShutdownVbucketsRequest) error {
+
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{fCmdShutdownVbuckets, req, respch}
-	resp, err := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
 	return c.OpError(err, resp, 0)
 }
 
 // AddBuckets will remove buckets and all its upstream
 // and downstream elements, except endpoints.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
 // Synchronous call.
 func (feed *Feed) AddBuckets(
+	ctx context.Context,
 	req *protobuf.AddBucketsRequest) (*protobuf.TopicResponse, error) {
 
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{fCmdAddBuckets, req, respch}
-	resp, err := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	if err != nil {
+		return feed.failedTopicResponse(), err
+	}
 	return resp[0].(*protobuf.TopicResponse), c.OpError(err, resp, 1)
 }
 
 // DelBuckets will remove buckets and all its upstream
 // and downstream elements, except endpoints.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
 // Synchronous call.
-func (feed *Feed) DelBuckets(req *protobuf.DelBucketsRequest) error {
+func (feed *Feed) DelBuckets(
+	ctx context.Context, req *protobuf.DelBucketsRequest) error {
+
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{fCmdDelBuckets, req, respch}
-	resp, err := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
 	return c.OpError(err, resp, 0)
 }
 
 // AddInstances will restart specified endpoint-address if
 // it is not active already.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
 // Synchronous call.
-func (feed *Feed) AddInstances(req *protobuf.AddInstancesRequest) error {
+func (feed *Feed) AddInstances(
+	ctx context.Context, req *protobuf.AddInstancesRequest) error {
+
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{fCmdAddInstances, req, respch}
-	resp, err := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
 	return c.OpError(err, resp, 0)
 }
 
 // DelInstances will restart specified endpoint-address if
 // it is not active already.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
 // Synchronous call.
-func (feed *Feed) DelInstances(req *protobuf.DelInstancesRequest) error {
+func (feed *Feed) DelInstances(
+	ctx context.Context, req *protobuf.DelInstancesRequest) error {
+
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{fCmdDelInstances, req, respch}
-	resp, err := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	return c.OpError(err, resp, 0)
+}
+
+// DisableInstances will stop routing mutations to specified instances,
+// instances are retained on the feed and endpoints hosting them are
+// re-framed without their vbuckets.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
+// Synchronous call.
+func (feed *Feed) DisableInstances(
+	ctx context.Context, req *protobuf.DisableInstancesRequest) error {
+
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{fCmdDisableInstances, req, respch}
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	return c.OpError(err, resp, 0)
+}
+
+// EnableInstances will resume routing mutations to specified instances,
+// that were disabled by DisableInstances().
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
+// Synchronous call.
+func (feed *Feed) EnableInstances(
+	ctx context.Context, req *protobuf.EnableInstancesRequest) error {
+
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{fCmdEnableInstances, req, respch}
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	return c.OpError(err, resp, 0)
+}
+
+// PauseTopic will stop draining mutations from upstream and routing
+// them to endpoints, vbucket streams and their book-keeping are retained
+// until the feed is resumed.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
+// Synchronous call.
+func (feed *Feed) PauseTopic(
+	ctx context.Context, req *protobuf.PauseTopicRequest) error {
+
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{fCmdPauseTopic, req, respch}
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	return c.OpError(err, resp, 0)
+}
+
+// ResumeTopic will resume draining and routing mutations on a feed
+// paused by PauseTopic().
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
+// Synchronous call.
+func (feed *Feed) ResumeTopic(
+	ctx context.Context, req *protobuf.ResumeTopicRequest) error {
+
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{fCmdResumeTopic, req, respch}
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
 	return c.OpError(err, resp, 0)
 }
 
 // RepairEndpoints will restart specified endpoint-address if
-// it is not active already.
+// it is not active already. Outcome of repairing each endpoint is
+// returned in response.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
 // Synchronous call.
-func (feed *Feed) RepairEndpoints(req *protobuf.RepairEndpointsRequest) error {
+func (feed *Feed) RepairEndpoints(
+	ctx context.Context,
+	req *protobuf.RepairEndpointsRequest) (*protobuf.RepairEndpointsResponse, error) {
+
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{fCmdRepairEndpoints, req, respch}
-	resp, err := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
-	return c.OpError(err, resp, 0)
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return resp[0].(*protobuf.RepairEndpointsResponse), nil
+}
+
+// TopicOperations will apply a batch of AddBuckets, AddInstances and
+// RestartVbuckets, in that order, without interleaving other requests
+// to this feed. Result of each operation is returned in response, along
+// with buckets for which an operation failed. Operations are not rolled
+// back for buckets that succeeded.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
+// Synchronous call.
+func (feed *Feed) TopicOperations(
+	ctx context.Context,
+	req *protobuf.TopicOperationsRequest) (*protobuf.TopicOperationsResponse, error) {
+
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{fCmdTopicOperations, req, respch}
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	if err != nil {
+		response := &protobuf.TopicOperationsResponse{
+			Response: feed.failedTopicResponse(),
+		}
+		return response, err
+	}
+	return resp[0].(*protobuf.TopicOperationsResponse), nil
+}
+
+// Probe will validate failover logs of vbuckets, open a DCP connection
+// with bucket and connect with endpoint, closing the connections once
+// done. Diagnostics are returned in response, also along with an error.
+// - return ErrorFeedClosed if feed is draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
+// - return ErrorFeeder if DCP connection cannot be opened.
+// - return ErrorInvalidVbucket for missing or invalid failover logs.
+// - return ErrorEndpoint if endpoint cannot be connected.
+// Synchronous call.
+func (feed *Feed) Probe(
+	ctx context.Context,
+	req *protobuf.ProbeRequest) (*protobuf.ProbeResponse, error) {
+
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{fCmdProbe, req, respch}
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	if err != nil {
+		return &protobuf.ProbeResponse{}, err
+	}
+	return resp[0].(*protobuf.ProbeResponse), c.OpError(err, resp, 1)
 }
 
-// GetTopicResponse for this feed.
+// GetTopicResponse for this feed, if feed is draining or closed, or
+// `ctx` expires before feed responds, an empty response is returned.
 // Synchronous call.
-func (feed *Feed) GetTopicResponse() *protobuf.TopicResponse {
+func (feed *Feed) GetTopicResponse(ctx context.Context) *protobuf.TopicResponse {
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{fCmdGetTopicResponse, respch}
-	resp, _ := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	if err != nil {
+		return feed.failedTopicResponse()
+	}
 	return resp[0].(*protobuf.TopicResponse)
 }
 
-// GetStatistics for this feed.
+// GetStatistics for this feed, if feed is draining or closed, or
+// `ctx` expires before feed responds, only its topic and state are
+// returned.
 // Synchronous call.
-func (feed *Feed) GetStatistics() c.Statistics {
+func (feed *Feed) GetStatistics(ctx context.Context) c.Statistics {
 	respch := make(chan []interface{}, 1)
-	cmd := []interface{}{fCmdGetStatistics, respch}
-	resp, _ := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	cmd := []interface{}{fCmdGetStatistics, ctx, respch}
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	if err != nil {
+		stats, _ := c.NewStatistics(nil)
+		stats.Set("topic", feed.topic)
+		stats.Set("state", feedStateString(feed.getState()))
+		return stats
+	}
 	return resp[0].(c.Statistics)
 }
 
+// MutationSamples recently captured by kv data path of `bucket`, or of
+// every bucket if it is empty, filtered by `docid` unless it is empty.
+// - return ErrorFeedClosed if feed is already draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
+// Synchronous call.
+func (feed *Feed) MutationSamples(
+	ctx context.Context,
+	bucket, docid string) (map[string][]*MutationSample, error) {
+
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{fCmdGetMutationSamples, bucket, docid, respch}
+	resp, err := feed.failsafeOp(ctx, respch, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return resp[0].(map[string][]*MutationSample), nil
+}
+
+// Events recently recorded on the control path of this feed, and of
+// earlier feeds of the topic, oldest first, filtered by `bucket` unless it
+// is empty. Does not wait on the feed, hence can be called while feed is
+// busy or closed.
+func (feed *Feed) Events(bucket string) []*FeedEvent {
+	return feed.events.recent(bucket)
+}
+
 // Shutdown feed, its upstream connection with kv and downstream endpoints.
+// - return ErrorFeedClosed if feed is already draining or closed.
+// - return ErrorRequestTimeout if `ctx` expires before feed responds.
 // Synchronous call.
-func (feed *Feed) Shutdown() error {
+func (feed *Feed) Shutdown(ctx context.Context) error {
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{fCmdShutdown, respch}
-	_, err := c.FailsafeOp(feed.reqch, respch, cmd, feed.finch)
+	_, err := feed.failsafeOp(ctx, respch, cmd)
 	return err
 }
 
@@ -225,6 +637,7 @@ type controlStreamRequest struct {
 	vbno   uint16
 	vbuuid uint64
 	seqno  uint64 // also doubles as rollback-seqno
+	posted time.Time
 }
 
 func (v *controlStreamRequest) Repr() string {
@@ -243,8 +656,9 @@ func (feed *Feed) PostStreamRequest(bucket string, m *mc.UprEvent) {
 		vbno:   m.VBucket,
 		vbuuid: m.VBuuid,
 		seqno:  m.Seqno, // can also be roll-back seqno, based on status
+		posted: time.Now(),
 	}
-	c.FailsafeOp(feed.backch, respch, []interface{}{cmd}, feed.finch)
+	c.FailsafeOp(feed.backch.in, respch, []interface{}{cmd}, feed.finch)
 }
 
 type controlStreamEnd struct {
@@ -252,6 +666,7 @@ type controlStreamEnd struct {
 	opaque uint16
 	status mcd.Status
 	vbno   uint16
+	posted time.Time
 }
 
 func (v *controlStreamEnd) Repr() string {
@@ -268,24 +683,46 @@ func (feed *Feed) PostStreamEnd(bucket string, m *mc.UprEvent) {
 		opaque: m.Opaque,
 		status: m.Status,
 		vbno:   m.VBucket,
+		posted: time.Now(),
 	}
-	c.FailsafeOp(feed.backch, respch, []interface{}{cmd}, feed.finch)
+	c.FailsafeOp(feed.backch.in, respch, []interface{}{cmd}, feed.finch)
+}
+
+type controlCatchupEnd struct {
+	bucket string
+	vbno   uint16
+	seqno  uint64
+}
+
+func (v *controlCatchupEnd) Repr() string {
+	return fmt.Sprintf("{controlCatchupEnd, %s, %d, %d}", v.bucket, v.vbno, v.seqno)
+}
+
+// PostCatchupEnd feedback from data-path, vbucket of a catchup topic
+// has reached its end `seqno`.
+// Asynchronous call.
+func (feed *Feed) PostCatchupEnd(bucket string, vbno uint16, seqno uint64) {
+	var respch chan []interface{}
+	cmd := &controlCatchupEnd{bucket: bucket, vbno: vbno, seqno: seqno}
+	c.FailsafeOp(feed.backch.in, respch, []interface{}{cmd}, feed.finch)
 }
 
 type controlFinKVData struct {
 	bucket string
+	err    error // upstream connection failure, if any
 }
 
 func (v *controlFinKVData) Repr() string {
 	return fmt.Sprintf("{controlFinKVData, %s}", v.bucket)
 }
 
-// PostFinKVdata feedback from data-path.
+// PostFinKVdata feedback from data-path, `err` is the upstream connection
+// failure that ended the data-path, if any.
 // Asynchronous call.
-func (feed *Feed) PostFinKVdata(bucket string) {
+func (feed *Feed) PostFinKVdata(bucket string, err error) {
 	var respch chan []interface{}
-	cmd := &controlFinKVData{bucket: bucket}
-	c.FailsafeOp(feed.backch, respch, []interface{}{cmd}, feed.finch)
+	cmd := &controlFinKVData{bucket: bucket, err: err}
+	c.FailsafeOp(feed.backch.in, respch, []interface{}{cmd}, feed.finch)
 }
 
 func (feed *Feed) genServer() {
@@ -302,51 +739,35 @@ func (feed *Feed) genServer() {
 	timeout := time.Tick(1000 * time.Millisecond)
 	ctrlMsg := "%v control channel has %v messages"
 
+	feed.setState(feedActive)
+
 loop:
 	for {
 		select {
-		case msg = <-feed.reqch:
+		case msg = <-feed.reqch.out:
 			if feed.handleCommand(msg) {
 				break loop
 			}
+			feed.applyStaleFeedback()
 
-		case msg = <-feed.backch:
+		case msg = <-feed.backch.out:
+			feed.checkLateFeedback(msg[0])
 			if v, ok := msg[0].(*controlStreamRequest); ok {
-				reqTs, ok := feed.reqTss[v.bucket]
-				seqno, vbuuid, sStart, sEnd, err := reqTs.Get(v.vbno)
-				if err != nil {
-					c.Errorf("%v unexpected %T for %v\n", feed.logPrefix, v, v)
-
-				} else if ok {
-					c.Debugf("%v back channel flush %v\n", feed.logPrefix, v.Repr())
-					reqTs = reqTs.FilterByVbuckets([]uint16{v.vbno})
-					feed.reqTss[v.bucket] = reqTs
-
-					if v.status == mcd.ROLLBACK {
-						rollTs := feed.rollTss[v.bucket]
-						rollTs.Append(v.vbno, v.seqno, vbuuid, sStart, sEnd)
-
-					} else if v.status == mcd.SUCCESS {
-						actTs := feed.actTss[v.bucket]
-						actTs.Append(v.vbno, seqno, vbuuid, sStart, sEnd)
-					}
-				}
+				feed.applyStreamRequest(v)
 
 			} else if v, ok := msg[0].(*controlStreamEnd); ok {
-				c.Debugf("%v back channel flush %v\n", feed.logPrefix, v.Repr())
-				reqTs := feed.reqTss[v.bucket]
-				reqTs = reqTs.FilterByVbuckets([]uint16{v.vbno})
-				feed.reqTss[v.bucket] = reqTs
+				feed.applyStreamEnd(v)
 
-				actTs := feed.actTss[v.bucket]
-				actTs = actTs.FilterByVbuckets([]uint16{v.vbno})
-				feed.actTss[v.bucket] = actTs
-
-				rollTs := feed.rollTss[v.bucket]
-				rollTs = rollTs.FilterByVbuckets([]uint16{v.vbno})
-				feed.rollTss[v.bucket] = rollTs
+			} else if v, ok := msg[0].(*controlCatchupEnd); ok {
+				feed.endCatchupStream(v)
 
 			} else if v, ok := msg[0].(*controlFinKVData); ok {
+				if v.err != nil {
+					format := "%v upstream connection reset for bucket %v: %v\n"
+					c.Errorf(format, feed.logPrefix, v.bucket, v.err)
+					feed.connResets[v.bucket]++
+					feed.events.record(eventConnReset, v.bucket, "%v", v.err)
+				}
 				actTs, ok := feed.actTss[v.bucket]
 				if ok && actTs != nil && actTs.Len() == 0 { // bucket is done
 					prefix := feed.logPrefix
@@ -360,9 +781,10 @@ loop:
 
 		case <-timeout:
 			// TODO: should this be ERROR ?
-			if len(feed.backch) > 0 {
-				c.Debugf(ctrlMsg, feed.logPrefix, len(feed.backch))
+			if feed.backch.Len() > 0 {
+				c.Debugf(ctrlMsg, feed.logPrefix, feed.backch.Len())
 			}
+			feed.purgeLateWaits()
 		}
 	}
 }
@@ -374,16 +796,23 @@ func (feed *Feed) handleCommand(msg []interface{}) (exit bool) {
 	case fCmdStart:
 		req := msg[1].(*protobuf.MutationTopicRequest)
 		respch := msg[2].(chan []interface{})
-		err := feed.start(req)
-		response := feed.topicResponse()
-		respch <- []interface{}{response, err}
+		feed.resetTimings()
+		reply := feed.newTopicReply(respch)
+		feed.replyTopic(reply, feed.start(req, reply))
+
+	case fCmdCatchupTopic:
+		req := msg[1].(*protobuf.CatchupTopicRequest)
+		respch := msg[2].(chan []interface{})
+		feed.resetTimings()
+		reply := feed.newTopicReply(respch)
+		feed.replyTopic(reply, feed.catchupTopic(req, reply))
 
 	case fCmdRestartVbuckets:
 		req := msg[1].(*protobuf.RestartVbucketsRequest)
 		respch := msg[2].(chan []interface{})
-		err := feed.restartVbuckets(req)
-		response := feed.topicResponse()
-		respch <- []interface{}{response, err}
+		feed.resetTimings()
+		reply := feed.newTopicReply(respch)
+		feed.replyTopic(reply, feed.restartVbuckets(req, reply))
 
 	case fCmdShutdownVbuckets:
 		req := msg[1].(*protobuf.ShutdownVbucketsRequest)
@@ -393,9 +822,9 @@ func (feed *Feed) handleCommand(msg []interface{}) (exit bool) {
 	case fCmdAddBuckets:
 		req := msg[1].(*protobuf.AddBucketsRequest)
 		respch := msg[2].(chan []interface{})
-		err := feed.addBuckets(req)
-		response := feed.topicResponse()
-		respch <- []interface{}{response, err}
+		feed.resetTimings()
+		reply := feed.newTopicReply(respch)
+		feed.replyTopic(reply, feed.addBuckets(req, reply))
 
 	case fCmdDelBuckets:
 		req := msg[1].(*protobuf.DelBucketsRequest)
@@ -405,25 +834,78 @@ func (feed *Feed) handleCommand(msg []interface{}) (exit bool) {
 	case fCmdAddInstances:
 		req := msg[1].(*protobuf.AddInstancesRequest)
 		respch := msg[2].(chan []interface{})
-		respch <- []interface{}{feed.addInstances(req)}
+		respch <- []interface{}{feed.addInstances(req, nil)}
 
 	case fCmdDelInstances:
 		req := msg[1].(*protobuf.DelInstancesRequest)
 		respch := msg[2].(chan []interface{})
 		respch <- []interface{}{feed.delInstances(req)}
 
+	case fCmdDisableInstances:
+		req := msg[1].(*protobuf.DisableInstancesRequest)
+		respch := msg[2].(chan []interface{})
+		respch <- []interface{}{feed.disableInstances(req)}
+
+	case fCmdEnableInstances:
+		req := msg[1].(*protobuf.EnableInstancesRequest)
+		respch := msg[2].(chan []interface{})
+		respch <- []interface{}{feed.enableInstances(req)}
+
+	case fCmdPauseTopic:
+		respch := msg[2].(chan []interface{})
+		respch <- []interface{}{feed.pauseTopic()}
+
+	case fCmdResumeTopic:
+		respch := msg[2].(chan []interface{})
+		respch <- []interface{}{feed.resumeTopic()}
+
 	case fCmdRepairEndpoints:
 		req := msg[1].(*protobuf.RepairEndpointsRequest)
 		respch := msg[2].(chan []interface{})
 		respch <- []interface{}{feed.repairEndpoints(req)}
 
+	case fCmdTopicOperations:
+		req := msg[1].(*protobuf.TopicOperationsRequest)
+		respch := msg[2].(chan []interface{})
+		feed.topicOperations(req, respch)
+
+	case fCmdProbe:
+		req := msg[1].(*protobuf.ProbeRequest)
+		respch := msg[2].(chan []interface{})
+		response, err := feed.probe(req)
+		respch <- []interface{}{response, err}
+
 	case fCmdGetTopicResponse:
 		respch := msg[1].(chan []interface{})
 		respch <- []interface{}{feed.topicResponse()}
 
 	case fCmdGetStatistics:
-		respch := msg[1].(chan []interface{})
-		respch <- []interface{}{feed.getStatistics()}
+		ctx := msg[1].(context.Context)
+		respch := msg[2].(chan []interface{})
+		respch <- []interface{}{feed.getStatistics(ctx)}
+
+	case fCmdGetMutationSamples:
+		bucket, docid := msg[1].(string), msg[2].(string)
+		respch := msg[3].(chan []interface{})
+		samples := make(map[string][]*MutationSample)
+		for bucketn, kvdata := range feed.kvdata {
+			if bucket == "" || bucket == bucketn {
+				samples[bucketn] = kvdata.MutationSamples(docid)
+			}
+		}
+		respch <- []interface{}{samples}
+
+	case fCmdRetryVbuckets:
+		feed.retryAt = time.Time{}
+		feed.retryVbuckets()
+		feed.scheduleRetries()
+
+	case fCmdStreamBatch:
+		key := streamBatchKey{bucketn: msg[1].(string), opaque: msg[2].(uint16)}
+		seq, timedout := msg[3].(int), msg[4].(bool)
+		if run, ok := feed.batchRuns[key]; ok && run.seq == seq {
+			feed.postStreamBatch(run, timedout)
+		}
 
 	case fCmdShutdown:
 		respch := msg[1].(chan []interface{})
@@ -434,16 +916,35 @@ func (feed *Feed) handleCommand(msg []interface{}) (exit bool) {
 	return exit
 }
 
-// start a new feed.
+// start a new feed, StreamRequests paced in batches beyond the first are
+// posted in background and `reply` is deferred until they complete, refer
+// streamBatches().
+// - return ErrorTooManyBuckets if maxBuckets is exceeded.
+// - return ErrorTooManyEngines if maxEngines is exceeded.
 // - return ErrorInconsistentFeed for malformed feed request
 // - return ErrorInvalidVbucketBranch for malformed vbuuid.
 // - return ErrorFeeder if upstream connection has failures.
+// - return ErrorBucketUUIDChanged if bucket was flushed or recreated.
 // - return ErrorNotMyVbucket due to rebalances and failures.
 // - return ErrorStreamRequest if StreamRequest failed for some reason
 // - return ErrorResponseTimeout if feedback is not completed within timeout.
-func (feed *Feed) start(req *protobuf.MutationTopicRequest) (err error) {
+// - return ErrorTopicPaused if feed is paused.
+func (feed *Feed) start(
+	req *protobuf.MutationTopicRequest, reply *topicReply) (err error) {
+
+	if err = feed.checkPaused(); err != nil {
+		return err
+	}
 	feed.endpointType = req.GetEndpointType()
+	feed.setToken(req.Token)
+	// downstream that does not advertise a version predates versioning.
+	if feed.version = req.GetVersion(); feed.version == 0 {
+		feed.version = c.StreamVersion1
+	}
 
+	if err = feed.checkBucketLimit(req.GetReqTimestamps()); err != nil {
+		return err
+	}
 	// update engines and endpoints
 	if err = feed.processSubscribers(req); err != nil { // :SideEffect:
 		return err
@@ -452,31 +953,37 @@ func (feed *Feed) start(req *protobuf.MutationTopicRequest) (err error) {
 	opaque := newOpaque()
 	for _, ts := range req.GetReqTimestamps() {
 		pooln, bucketn := ts.GetPool(), ts.GetBucket()
+		begin := time.Now()
 		vbnos, e := feed.getLocalVbuckets(pooln, bucketn)
+		feed.timePhase(bucketn, phaseVbmap, begin)
 		if e != nil {
 			err = e
 			feed.cleanupBucket(bucketn, false)
 			continue
 		}
+		// frame endpoints before upstream is started for vbnos.
+		feed.localVbs[bucketn] = vbnos // :SideEffect:
+		feed.sendVbmaps()
 		ts := ts.SelectByVbuckets(vbnos)
 
 		actTs, ok := feed.actTss[bucketn]
 		if ok { // don't re-request for already active vbuckets
-			ts = ts.FilterByVbuckets(c.Vbno32to16(actTs.GetVbnos()))
+			ts = ts.FilterByVbSet(actTs.VbSet())
 		}
 		rollTs, ok := feed.rollTss[bucketn]
 		if ok { // forget previous rollback for the current set of vbuckets
-			rollTs = rollTs.FilterByVbuckets(c.Vbno32to16(ts.GetVbnos()))
+			rollTs = rollTs.FilterByVbSet(ts.VbSet())
 		}
 		reqTs, ok := feed.reqTss[bucketn]
 		// book-keeping of out-standing request, vbuckets that have
 		// out-standing request will be ignored.
 		if ok {
-			ts = ts.FilterByVbuckets(c.Vbno32to16(reqTs.GetVbnos()))
+			ts = ts.FilterByVbSet(reqTs.VbSet())
 		}
 		reqTs = ts.Union(reqTs)
 		// start upstream, after filtering out remove vbuckets.
-		feeder, e := feed.bucketFeed(opaque, false, true, ts)
+		batches := feed.streamBatches(ts)
+		feeder, e := feed.bucketFeed(opaque, false, true, batches[0])
 		if e != nil { // all feed errors are fatal, skip this bucket.
 			err = e
 			feed.cleanupBucket(bucketn, false)
@@ -487,13 +994,13 @@ func (feed *Feed) start(req *protobuf.MutationTopicRequest) (err error) {
 		kvdata := feed.startDataPath(bucketn, feeder, ts)
 		feed.kvdata[bucketn] = kvdata // :SideEffect:
 		// wait for stream to start ...
-		r, f, a, e := feed.waitStreamRequests(opaque, pooln, bucketn, ts)
+		r, f, a, e := feed.waitStreamRequestsRetry(opaque, pooln, bucketn, batches, reply)
 		feed.rollTss[bucketn] = rollTs.Union(r) // :SideEffect:
 		feed.actTss[bucketn] = actTs.Union(a)   // :SideEffect:
 		// forget vbuckets for which a response is already received.
-		reqTs = reqTs.FilterByVbuckets(c.Vbno32to16(r.GetVbnos()))
-		reqTs = reqTs.FilterByVbuckets(c.Vbno32to16(a.GetVbnos()))
-		reqTs = reqTs.FilterByVbuckets(c.Vbno32to16(f.GetVbnos()))
+		reqTs = reqTs.FilterByVbSet(r.VbSet())
+		reqTs = reqTs.FilterByVbSet(a.VbSet())
+		reqTs = reqTs.FilterByVbSet(f.VbSet())
 		feed.reqTss[bucketn] = reqTs // :SideEffect:
 		if e != nil {
 			err = e
@@ -506,15 +1013,94 @@ func (feed *Feed) start(req *protobuf.MutationTopicRequest) (err error) {
 	return err
 }
 
-// a subset of upstreams are restarted.
+// start a new feed for catchup topic, vbucket streams are started from
+// restart-timestamps and only for vbuckets that have an end seqno.
+// Retrying the request is idempotent, end-timestamps of buckets already
+// streaming for this topic are retained.
+// - return ErrorInconsistentFeed if a bucket has no end-timestamp.
+// - return ErrorInconsistentFeed if bucket is streaming for mutation topic.
+// - return errors returned by start().
+func (feed *Feed) catchupTopic(
+	req *protobuf.CatchupTopicRequest, reply *topicReply) (err error) {
+
+	if err = feed.checkPaused(); err != nil {
+		return err
+	}
+	mreq := req.ToMutationTopicRequest()
+	reqTss := make([]*protobuf.TsVbuuid, 0, len(mreq.GetReqTimestamps()))
+	for _, ts := range mreq.GetReqTimestamps() {
+		bucketn := ts.GetBucket()
+		endTs := req.EndTimestampFor(bucketn)
+		if endTs == nil {
+			feed.errorf("catchupTopic() missing end-timestamp", bucketn, nil)
+			err = c.CountError(projC.ErrorInconsistentFeed)
+			continue
+		}
+		if _, ok := feed.kvdata[bucketn]; !ok {
+			feed.endTss[bucketn] = endTs     // :SideEffect:
+			delete(feed.catchupTss, bucketn) // :SideEffect:
+		} else if _, ok := feed.endTss[bucketn]; !ok {
+			feed.errorf("catchupTopic() already streaming", bucketn, nil)
+			err = c.CountError(projC.ErrorInconsistentFeed)
+			continue
+		}
+		reqTss = append(reqTss, ts.SelectByVbSet(feed.endTss[bucketn].VbSet()))
+	}
+	mreq.ReqTimestamps = reqTss
+	if e := feed.start(mreq, reply); e != nil {
+		err = e
+	}
+	return err
+}
+
+// end the stream of a catchup topic's vbucket that has reached its end
+// seqno, StreamEnd from upstream is handled like any other.
+func (feed *Feed) endCatchupStream(v *controlCatchupEnd) {
+	actTs, ok1 := feed.actTss[v.bucket]
+	feeder, ok2 := feed.feeders[v.bucket]
+	if !ok1 || !ok2 || !actTs.Contains(v.vbno) {
+		c.Warnf("%v catchup end for inactive vbucket %v\n", feed.logPrefix, v.Repr())
+		return
+	}
+	ts := actTs.SelectByVbuckets([]uint16{v.vbno})
+	_, vbuuid, _, _, _ := ts.Get(v.vbno)
+	catchupTs, ok := feed.catchupTss[v.bucket]
+	if !ok {
+		catchupTs = protobuf.NewTsVbuuid(ts.GetPool(), v.bucket, feed.maxVbuckets)
+	}
+	catchupTs = catchupTs.FilterByVbuckets([]uint16{v.vbno})
+	catchupTs.Append(v.vbno, v.seqno, vbuuid, v.seqno, v.seqno)
+	feed.catchupTss[v.bucket] = catchupTs // :SideEffect:
+
+	opaque := newOpaque()
+	if err := feeder.EndVbStreams(opaque, ts); err != nil {
+		feed.errorf("EndVbStreams()", v.bucket, err)
+		return
+	}
+	c.Infof("%v catchup completed for bucket %v, vbno %v at seqno %v #%x\n",
+		feed.logPrefix, v.bucket, v.vbno, v.seqno, opaque)
+	feed.events.record(eventCatchupEnd, v.bucket, "vbno %v, seqno %v", v.vbno, v.seqno)
+}
+
+// a subset of upstreams are restarted, restart-points applied for
+// vbuckets that were successfully restarted are added to `reply`, per
+// bucket. StreamRequests are paced like start().
 // - return ErrorInvalidBucket if bucket is not added.
 // - return ErrorInvalidVbucketBranch for malformed vbuuid.
 // - return ErrorFeeder if upstream connection has failures.
+// - return ErrorBucketUUIDChanged if bucket was flushed or recreated.
 // - return ErrorNotMyVbucket due to rebalances and failures.
 // - return ErrorStreamRequest if StreamRequest failed for some reason
 // - return ErrorResponseTimeout if feedback is not completed within timeout.
+// - return ErrorTopicPaused if feed is paused.
 func (feed *Feed) restartVbuckets(
-	req *protobuf.RestartVbucketsRequest) (err error) {
+	req *protobuf.RestartVbucketsRequest, reply *topicReply) (err error) {
+
+	reply.restart = true
+	if err = feed.checkPaused(); err != nil {
+		return err
+	}
+	feed.setToken(req.Token)
 
 	// FIXME: restart-vbuckets implies a repair Endpoint.
 	raddrs := feed.endpointRaddrs()
@@ -525,37 +1111,48 @@ func (feed *Feed) restartVbuckets(
 	opaque := newOpaque()
 	for _, ts := range req.GetRestartTimestamps() {
 		pooln, bucketn := ts.GetPool(), ts.GetBucket()
+		begin := time.Now()
 		vbnos, e := feed.getLocalVbuckets(pooln, bucketn)
+		feed.timePhase(bucketn, phaseVbmap, begin)
 		if e != nil {
-			err = e
+			err = reply.bucketError(bucketn, e)
 			feed.cleanupBucket(bucketn, false)
 			continue
 		}
+		// frame endpoints before upstream is started for vbnos.
+		feed.localVbs[bucketn] = vbnos // :SideEffect:
+		feed.sendVbmaps()
 		ts := ts.SelectByVbuckets(vbnos)
 
 		actTs, ok := feed.actTss[bucketn]
 		if ok { // don't re-request for already active vbuckets
-			ts = ts.FilterByVbuckets(c.Vbno32to16(actTs.GetVbnos()))
+			ts = ts.FilterByVbSet(actTs.VbSet())
 		}
 		rollTs, ok := feed.rollTss[bucketn]
 		if ok { // forget previous rollback for the current set of vbuckets
-			rollTs = rollTs.FilterByVbuckets(c.Vbno32to16(ts.GetVbnos()))
+			rollTs = rollTs.FilterByVbSet(ts.VbSet())
 		}
 		reqTs, ok := feed.reqTss[bucketn]
 		// book-keeping of out-standing request, vbuckets that have
 		// out-standing request will be ignored.
 		if ok {
-			ts = ts.FilterByVbuckets(c.Vbno32to16(reqTs.GetVbnos()))
+			ts = ts.FilterByVbSet(reqTs.VbSet())
 		}
-		reqTs = ts.Union(ts)
-		// if bucket already present update kvdata first.
-		if _, ok := feed.kvdata[bucketn]; ok {
-			feed.kvdata[bucketn].UpdateTs(ts)
+		// if bucket already present update kvdata first, vbuckets that
+		// are still streaming on it are not re-requested.
+		if kvdata, ok := feed.kvdata[bucketn]; ok {
+			if ts, e = kvdata.UpdateTs(ts); e != nil {
+				err = reply.bucketError(bucketn, e)
+				feed.cleanupBucket(bucketn, false)
+				continue
+			}
 		}
+		reqTs = ts.Union(reqTs)
 		// (re)start the upstream, after filtering out remote vbuckets.
-		feeder, e := feed.bucketFeed(opaque, false, true, ts)
+		batches := feed.streamBatches(ts)
+		feeder, e := feed.bucketFeed(opaque, false, true, batches[0])
 		if e != nil { // all feed errors are fatal, skip this bucket.
-			err = e
+			err = reply.bucketError(bucketn, e)
 			feed.cleanupBucket(bucketn, false)
 			continue
 		}
@@ -566,21 +1163,23 @@ func (feed *Feed) restartVbuckets(
 			feed.kvdata[bucketn] = kvdata // :SideEffect:
 		}
 		// wait stream to start ...
-		r, f, a, e := feed.waitStreamRequests(opaque, pooln, bucketn, ts)
+		r, f, a, e := feed.waitStreamRequestsRetry(opaque, pooln, bucketn, batches, reply)
 		feed.rollTss[bucketn] = rollTs.Union(r) // :SideEffect:
 		feed.actTss[bucketn] = actTs.Union(a)   // :SideEffect:
 		// forget vbuckets for which a response is already received.
-		reqTs = reqTs.FilterByVbuckets(c.Vbno32to16(r.GetVbnos()))
-		reqTs = reqTs.FilterByVbuckets(c.Vbno32to16(a.GetVbnos()))
-		reqTs = reqTs.FilterByVbuckets(c.Vbno32to16(f.GetVbnos()))
+		reqTs = reqTs.FilterByVbSet(r.VbSet())
+		reqTs = reqTs.FilterByVbSet(a.VbSet())
+		reqTs = reqTs.FilterByVbSet(f.VbSet())
 		feed.reqTss[bucketn] = reqTs // :SideEffect:
 		if e != nil {
-			err = e
+			err = reply.bucketError(bucketn, e)
 		}
 		c.Infof("%v stream-request %s, rollback: %v, success: vbnos %v #%x\n",
 			feed.logPrefix, bucketn,
 			feed.rollTss[bucketn].GetVbnos(),
 			feed.actTss[bucketn].GetVbnos(), opaque)
+		// where each of the restarted vbuckets resumed from.
+		reply.addRestartTs(ts.SelectByVbSet(a.VbSet()))
 	}
 	return err
 }
@@ -592,8 +1191,12 @@ func (feed *Feed) restartVbuckets(
 // - return ErrorNotMyVbucket due to rebalances and failures.
 // - return ErrorStreamEnd if StreamEnd failed for some reason
 // - return ErrorResponseTimeout if feedback is not completed within timeout.
+// - return ErrorTopicPaused if feed is paused.
 func (feed *Feed) shutdownVbuckets(
 	req *protobuf.ShutdownVbucketsRequest) (err error) {
+	if err = feed.checkPaused(); err != nil {
+		return err
+	}
 	// iterate request-timestamp for each bucket.
 	opaque := newOpaque()
 	for _, ts := range req.GetShutdownTimestamps() {
@@ -607,6 +1210,8 @@ func (feed *Feed) shutdownVbuckets(
 			continue
 		}
 		ts := ts.SelectByVbuckets(vbnos)
+		// vbuckets being shutdown are no more retried.
+		feed.retries.remove(bucketn, ts.VbSet()) // :SideEffect:
 
 		actTs, ok1 := feed.actTss[bucketn]
 		rollTs, ok2 := feed.rollTss[bucketn]
@@ -614,7 +1219,7 @@ func (feed *Feed) shutdownVbuckets(
 		if !ok1 || !ok2 || !ok3 {
 			msg := "%v shutdownVbuckets() invalid bucket %v\n"
 			c.Errorf(msg, feed.logPrefix, bucketn)
-			err = projC.ErrorInvalidBucket
+			err = c.CountError(c.WrapError(projC.ErrorInvalidBucket, "bucket", bucketn))
 			continue
 		}
 		// shutdown upstream
@@ -627,29 +1232,44 @@ func (feed *Feed) shutdownVbuckets(
 			continue
 		}
 		endTs, _, e := feed.waitStreamEnds(opaque, bucketn, ts)
-		vbnos = c.Vbno32to16(endTs.GetVbnos())
+		endVbs := endTs.VbSet()
 		// forget vbnos that are shutdown
-		feed.actTss[bucketn] = actTs.FilterByVbuckets(vbnos)   // :SideEffect:
-		feed.reqTss[bucketn] = reqTs.FilterByVbuckets(vbnos)   // :SideEffect:
-		feed.rollTss[bucketn] = rollTs.FilterByVbuckets(vbnos) // :SideEffect:
+		feed.actTss[bucketn] = actTs.FilterByVbSet(endVbs)   // :SideEffect:
+		feed.reqTss[bucketn] = reqTs.FilterByVbSet(endVbs)   // :SideEffect:
+		feed.rollTss[bucketn] = rollTs.FilterByVbSet(endVbs) // :SideEffect:
+		feed.dropStreamBatches(bucketn, ts.VbSet())
 		if e != nil {
 			err = e
 		}
 		c.Infof("%v stream-end completed for bucket %v, vbnos %v #%x\n",
-			feed.logPrefix, bucketn, vbnos, opaque)
+			feed.logPrefix, bucketn, endVbs, opaque)
+		feed.events.record(eventStreamEnd, bucketn, "vbnos %v", endVbs)
 	}
 	return err
 }
 
 // upstreams are added for buckets data-path opened and
-// vbucket-routines started.
+// vbucket-routines started. StreamRequests are paced like start().
+// - return ErrorTooManyBuckets if maxBuckets is exceeded.
+// - return ErrorTooManyEngines if maxEngines is exceeded.
 // - return ErrorInconsistentFeed for malformed feed request
 // - return ErrorInvalidVbucketBranch for malformed vbuuid.
 // - return ErrorFeeder if upstream connection has failures.
+// - return ErrorBucketUUIDChanged if bucket was flushed or recreated.
 // - return ErrorNotMyVbucket due to rebalances and failures.
 // - return ErrorStreamRequest if StreamRequest failed for some reason
 // - return ErrorResponseTimeout if feedback is not completed within timeout.
-func (feed *Feed) addBuckets(req *protobuf.AddBucketsRequest) (err error) {
+// - return ErrorTopicPaused if feed is paused.
+func (feed *Feed) addBuckets(
+	req *protobuf.AddBucketsRequest, reply *topicReply) (err error) {
+
+	if err = feed.checkPaused(); err != nil {
+		return err
+	}
+	feed.setToken(req.Token)
+	if err = feed.checkBucketLimit(req.GetReqTimestamps()); err != nil {
+		return err
+	}
 	// update engines and endpoints
 	if err = feed.processSubscribers(req); err != nil { // :SideEffect:
 		return err
@@ -659,33 +1279,39 @@ func (feed *Feed) addBuckets(req *protobuf.AddBucketsRequest) (err error) {
 	opaque := newOpaque()
 	for _, ts := range req.GetReqTimestamps() {
 		pooln, bucketn := ts.GetPool(), ts.GetBucket()
+		begin := time.Now()
 		vbnos, e := feed.getLocalVbuckets(pooln, bucketn)
+		feed.timePhase(bucketn, phaseVbmap, begin)
 		if e != nil {
-			err = e
+			err = reply.bucketError(bucketn, e)
 			feed.cleanupBucket(bucketn, false)
 			continue
 		}
+		// frame endpoints before upstream is started for vbnos.
+		feed.localVbs[bucketn] = vbnos // :SideEffect:
+		feed.sendVbmaps()
 		ts := ts.SelectByVbuckets(vbnos)
 
 		actTs, ok := feed.actTss[bucketn]
 		if ok { // don't re-request for already active vbuckets
-			ts.FilterByVbuckets(c.Vbno32to16(actTs.GetVbnos()))
+			ts = ts.FilterByVbSet(actTs.VbSet())
 		}
 		rollTs, ok := feed.rollTss[bucketn]
 		if ok { // foget previous rollback for the current set of buckets
-			rollTs = rollTs.FilterByVbuckets(c.Vbno32to16(ts.GetVbnos()))
+			rollTs = rollTs.FilterByVbSet(ts.VbSet())
 		}
 		reqTs, ok := feed.reqTss[bucketn]
 		// book-keeping of out-standing request, vbuckets that have
 		// out-standing request will be ignored.
 		if ok {
-			ts = ts.FilterByVbuckets(c.Vbno32to16(reqTs.GetVbnos()))
+			ts = ts.FilterByVbSet(reqTs.VbSet())
 		}
-		reqTs = ts.Union(ts)
+		reqTs = ts.Union(reqTs)
 		// start upstream
-		feeder, e := feed.bucketFeed(opaque, false, true, ts)
+		batches := feed.streamBatches(ts)
+		feeder, e := feed.bucketFeed(opaque, false, true, batches[0])
 		if e != nil { // all feed errors are fatal, skip this bucket.
-			err = e
+			err = reply.bucketError(bucketn, e)
 			feed.cleanupBucket(bucketn, false)
 			continue
 		}
@@ -694,16 +1320,16 @@ func (feed *Feed) addBuckets(req *protobuf.AddBucketsRequest) (err error) {
 		kvdata := feed.startDataPath(bucketn, feeder, ts)
 		feed.kvdata[bucketn] = kvdata // :SideEffect:
 		// wait for stream to start ...
-		r, f, a, e := feed.waitStreamRequests(opaque, pooln, bucketn, ts)
+		r, f, a, e := feed.waitStreamRequestsRetry(opaque, pooln, bucketn, batches, reply)
 		feed.rollTss[bucketn] = rollTs.Union(r) // :SideEffect:
 		feed.actTss[bucketn] = actTs.Union(a)   // :SideEffect
 		// forget vbucket for which a response is already received.
-		reqTs = reqTs.FilterByVbuckets(c.Vbno32to16(r.GetVbnos()))
-		reqTs = reqTs.FilterByVbuckets(c.Vbno32to16(a.GetVbnos()))
-		reqTs = reqTs.FilterByVbuckets(c.Vbno32to16(f.GetVbnos()))
+		reqTs = reqTs.FilterByVbSet(r.VbSet())
+		reqTs = reqTs.FilterByVbSet(a.VbSet())
+		reqTs = reqTs.FilterByVbSet(f.VbSet())
 		feed.reqTss[bucketn] = reqTs // :SideEffect:
 		if e != nil {
-			err = e
+			err = reply.bucketError(bucketn, e)
 		}
 		c.Infof("%v stream-request %s, rollback: %v, success: vbnos %v #%x\n",
 			feed.logPrefix, bucketn,
@@ -719,24 +1345,30 @@ func (feed *Feed) delBuckets(req *protobuf.DelBucketsRequest) error {
 	for _, bucketn := range req.GetBuckets() {
 		feed.cleanupBucket(bucketn, true)
 	}
+	feed.sendVbmaps()
 	return nil
 }
 
 // only data-path shall be updated.
+// - return ErrorTooManyEngines if maxEngines is exceeded.
 // - return ErrorInconsistentFeed for malformed feed request
-func (feed *Feed) addInstances(req *protobuf.AddInstancesRequest) error {
+func (feed *Feed) addInstances(
+	req *protobuf.AddInstancesRequest, reply *topicReply) error {
+
 	// update engines and endpoints
 	if err := feed.processSubscribers(req); err != nil { // :SideEffect:
 		return err
 	}
 	var err error
 	// post to kv data-path
-	for bucketn, engines := range feed.engines {
+	for bucketn := range feed.engines {
 		if _, ok := feed.kvdata[bucketn]; ok {
+			engines := feed.activeEngines(bucketn)
 			feed.kvdata[bucketn].AddEngines(engines, feed.endpoints)
 		} else {
 			feed.errorf("addInstances() invalid bucket", bucketn, nil)
-			err = projC.ErrorInvalidBucket
+			e := c.WrapError(projC.ErrorInvalidBucket, "bucket", bucketn)
+			err = reply.bucketError(bucketn, c.CountError(e))
 		}
 	}
 	return err
@@ -748,13 +1380,17 @@ func (feed *Feed) addInstances(req *protobuf.AddInstancesRequest) error {
 func (feed *Feed) delInstances(req *protobuf.DelInstancesRequest) error {
 	// reconstruct instance uuids bucket-wise.
 	instanceIds := req.GetInstanceIds()
+	delIds := make(map[uint64]bool, len(instanceIds))
+	for _, uuid := range instanceIds {
+		delIds[uuid] = true
+	}
 	bucknIds := make(map[string][]uint64)           // bucket -> []instance
 	fengines := make(map[string]map[uint64]*Engine) // bucket-> uuid-> instance
 	for bucketn, engines := range feed.engines {
 		uuids := make([]uint64, 0)
 		m := make(map[uint64]*Engine)
 		for uuid, engine := range engines {
-			if c.HasUint64(uuid, instanceIds) {
+			if delIds[uuid] {
 				uuids = append(uuids, uuid)
 			} else {
 				m[uuid] = engine
@@ -763,6 +1399,9 @@ func (feed *Feed) delInstances(req *protobuf.DelInstancesRequest) error {
 		bucknIds[bucketn] = uuids
 		fengines[bucketn] = m
 	}
+	for _, uuid := range instanceIds {
+		delete(feed.disabled, uuid) // :SideEffect:
+	}
 	var err error
 	// posted post to kv data-path.
 	for bucketn, uuids := range bucknIds {
@@ -770,39 +1409,173 @@ func (feed *Feed) delInstances(req *protobuf.DelInstancesRequest) error {
 			feed.kvdata[bucketn].DeleteEngines(uuids)
 		} else {
 			feed.errorf("delInstances() invalid bucket", bucketn, nil)
-			err = projC.ErrorInvalidBucket
+			err = c.CountError(c.WrapError(projC.ErrorInvalidBucket, "bucket", bucketn))
 		}
 	}
 	feed.engines = fengines // :SideEffect:
 	return err
 }
 
-// endpoints are independent.
+// only data-path shall be updated, engines are retained on the feed.
+// - return ErrorInvalidInstance if an instance is not defined on the feed.
+func (feed *Feed) disableInstances(
+	req *protobuf.DisableInstancesRequest) (err error) {
+
+	bucknIds, err := feed.bucketInstances(req.GetInstanceIds())
+	for _, uuids := range bucknIds {
+		for _, uuid := range uuids {
+			feed.disabled[uuid] = true // :SideEffect:
+		}
+	}
+	// posted to kv data-path.
+	for bucketn, uuids := range bucknIds {
+		if kvdata, ok := feed.kvdata[bucketn]; ok {
+			kvdata.DeleteEngines(uuids)
+		}
+	}
+	// frame endpoints without vbuckets of disabled engines, once
+	// mutations are no more routed to them.
+	feed.sendVbmaps()
+	c.Infof("%v disabled engines %v\n", feed.logPrefix, bucknIds)
+	return err
+}
+
+// only data-path shall be updated.
+// - return ErrorInvalidInstance if an instance is not defined on the feed.
+func (feed *Feed) enableInstances(
+	req *protobuf.EnableInstancesRequest) (err error) {
+
+	bucknIds, err := feed.bucketInstances(req.GetInstanceIds())
+	for _, uuids := range bucknIds {
+		for _, uuid := range uuids {
+			delete(feed.disabled, uuid) // :SideEffect:
+		}
+	}
+	// frame endpoints with vbuckets of enabled engines before routing
+	// mutations to them.
+	feed.sendVbmaps()
+	// posted to kv data-path.
+	for bucketn := range bucknIds {
+		if kvdata, ok := feed.kvdata[bucketn]; ok {
+			kvdata.AddEngines(feed.activeEngines(bucketn), feed.endpoints)
+		}
+	}
+	c.Infof("%v enabled engines %v\n", feed.logPrefix, bucknIds)
+	return err
+}
+
+// kv data-paths stop draining upstream, mutations back up into DCP
+// until the feed is resumed. Idempotent.
+func (feed *Feed) pauseTopic() error {
+	if feed.paused {
+		return nil
+	}
+	for bucketn, kvdata := range feed.kvdata {
+		if err := kvdata.Pause(); err != nil {
+			feed.errorf("pauseTopic()", bucketn, err)
+		}
+	}
+	feed.paused = true // :SideEffect:
+	feed.events.record(eventPause, "", "buckets %v", len(feed.kvdata))
+	c.Infof("%v paused\n", feed.logPrefix)
+	return nil
+}
+
+// kv data-paths resume draining upstream from where they were paused.
+// Idempotent.
+func (feed *Feed) resumeTopic() error {
+	if !feed.paused {
+		return nil
+	}
+	for bucketn, kvdata := range feed.kvdata {
+		if err := kvdata.Resume(); err != nil {
+			feed.errorf("resumeTopic()", bucketn, err)
+		}
+	}
+	feed.paused = false // :SideEffect:
+	feed.scheduleRetries()
+	feed.events.record(eventResume, "", "buckets %v", len(feed.kvdata))
+	c.Infof("%v resumed\n", feed.logPrefix)
+	return nil
+}
+
+// requests that start or shutdown vbucket streams wait on feedback from
+// kv data-path, which does not arrive while the feed is paused.
+func (feed *Feed) checkPaused() error {
+	if feed.paused {
+		return c.CountError(projC.ErrorTopicPaused)
+	}
+	return nil
+}
+
+// group instance uuids by the bucket their engines are defined on.
+// - return ErrorInvalidInstance if an instance is not defined on the feed.
+func (feed *Feed) bucketInstances(
+	instanceIds []uint64) (map[string][]uint64, error) {
+
+	var err error
+	bucknIds := make(map[string][]uint64) // bucket -> []instance
+	for _, uuid := range instanceIds {
+		found := false
+		for bucketn, engines := range feed.engines {
+			if _, ok := engines[uuid]; ok {
+				bucknIds[bucketn] = append(bucknIds[bucketn], uuid)
+				found = true
+				break
+			}
+		}
+		if !found {
+			fmsg := "%v instance %v not defined on feed\n"
+			c.Errorf(fmsg, feed.logPrefix, uuid)
+			err = c.CountError(c.WrapError(projC.ErrorInvalidInstance, "instance", uuid))
+		}
+	}
+	return bucknIds, err
+}
+
+// engines defined on bucket that are not disabled.
+func (feed *Feed) activeEngines(bucketn string) map[uint64]*Engine {
+	engines := make(map[uint64]*Engine)
+	for uuid, engine := range feed.engines[bucketn] {
+		if !feed.disabled[uuid] {
+			engines[uuid] = engine
+		}
+	}
+	return engines
+}
+
+// endpoints are independent, outcome of repairing each endpoint is
+// collected in response.
 func (feed *Feed) repairEndpoints(
-	req *protobuf.RepairEndpointsRequest) (err error) {
+	req *protobuf.RepairEndpointsRequest) *protobuf.RepairEndpointsResponse {
 
+	resp := protobuf.NewRepairEndpointsResponse()
 	prefix := feed.logPrefix
 	for _, raddr := range req.GetEndpoints() {
 		c.Debugf("%v trying to repair %q\n", prefix, raddr)
 		raddr1, endpoint, e := feed.getEndpoint(raddr)
 		if e != nil {
 			c.Errorf("%v error repairing endpoint %q\n", prefix, raddr1)
-			err = e
+			resp.AddEndpoint(raddr, protobuf.RepairStatus_EndpointFailed, e)
 			continue
 
 		} else if (endpoint == nil) || (endpoint != nil && !endpoint.Ping()) {
 			// endpoint found but not active or enpoint is not found.
 			c.Infof("%v endpoint %q restarting ...\n", prefix, raddr)
 			topic, typ := feed.topic, feed.endpointType
-			endpoint, e = feed.epFactory(topic, typ, raddr)
+			endpoint, e = feed.epFactory(topic, typ, raddr, feed.token)
 			if e != nil {
 				c.Errorf("%v error repairing endpoint %q\n", prefix, raddr1)
-				err = e
+				feed.events.record(eventRepair, "", "endpoint %q: %v", raddr, e)
+				resp.AddEndpoint(raddr, protobuf.RepairStatus_EndpointFailed, e)
 				continue
 			}
+			feed.events.record(eventRepair, "", "endpoint %q restarted", raddr)
+			resp.AddEndpoint(raddr, protobuf.RepairStatus_EndpointRepaired, nil)
 
 		} else {
 			c.Infof("%v endpoint %q active ...\n", prefix, raddr)
+			resp.AddEndpoint(raddr, protobuf.RepairStatus_EndpointActive, nil)
 		}
 		// FIXME: hack to make both node-name available from
 		// endpoints table.
@@ -810,37 +1583,262 @@ func (feed *Feed) repairEndpoints(
 		feed.endpoints[raddr1] = endpoint // :SideEffect:
 	}
 
+	// frame restarted endpoints before routing mutations to them.
+	feed.sendVbmaps()
 	// posted to each kv data-path
 	for bucketn, kvdata := range feed.kvdata {
 		// though only endpoints have been updated
-		kvdata.AddEngines(feed.engines[bucketn], feed.endpoints)
+		kvdata.AddEngines(feed.activeEngines(bucketn), feed.endpoints)
 	}
-	return nil
+	return resp
 }
 
-func (feed *Feed) getStatistics() c.Statistics {
-	stats, _ := c.NewStatistics(nil)
-	stats.Set("topic", feed.topic)
-	stats.Set("engines", feed.engineNames())
-	for bucketn, kvdata := range feed.kvdata {
-		stats.Set("bucket-"+bucketn, kvdata.GetStatistics())
+// apply a batch of operations, in order, and collect their results. The
+// response is posted on `respch` once StreamRequests paced for every
+// operation are complete.
+func (feed *Feed) topicOperations(
+	req *protobuf.TopicOperationsRequest, respch chan []interface{}) {
+
+	resp := &protobuf.TopicOperationsResponse{}
+	restart := &topicReply{}
+	// held until every operation is applied.
+	ops := &topicReply{pending: 1}
+	ops.done = func(error) {
+		resp.Response = feed.topicResponse()
+		resp.Response.RestartTimestamps = restart.restartTss
+		respch <- []interface{}{resp}
 	}
-	endStats, _ := c.NewStatistics(nil)
-	for raddr, endpoint := range feed.endpoints {
-		endStats.Set(raddr, endpoint.GetStatistics())
+	opReply := func(name string, result **protobuf.Error) *topicReply {
+		ops.pending++
+		reply := &topicReply{pending: 1, bucketErrs: make(map[string]error)}
+		reply.done = func(err error) {
+			feed.opResult(name, err)
+			*result = protobuf.NewError(err)
+			resp.BucketErrors = append(
+				resp.BucketErrors, newBucketErrors(name, reply.bucketErrs)...)
+			feed.replyTopic(ops, nil)
+		}
+		return reply
 	}
-	stats.Set("endpoints", endStats)
-	return stats
+
+	if op := req.GetAddBuckets(); op != nil {
+		reply := opReply("addBuckets", &resp.AddBuckets)
+		feed.replyTopic(reply, feed.addBuckets(op, reply))
+	}
+	if op := req.GetAddInstances(); op != nil {
+		reply := opReply("addInstances", &resp.AddInstances)
+		feed.replyTopic(reply, feed.addInstances(op, reply))
+	}
+	if op := req.GetRestartVbuckets(); op != nil {
+		restart = opReply("restartVbuckets", &resp.RestartVbuckets)
+		feed.replyTopic(restart, feed.restartVbuckets(op, restart))
+	}
+	feed.replyTopic(ops, nil)
 }
 
-func (feed *Feed) shutdown() error {
-	defer func() {
-		if r := recover(); r != nil {
+// newBucketErrors of operation `op`, sorted by bucket.
+func newBucketErrors(op string, errs map[string]error) []*protobuf.BucketError {
+	bucketns := make([]string, 0, len(errs))
+	for bucketn := range errs {
+		bucketns = append(bucketns, bucketn)
+	}
+	sort.Strings(bucketns)
+	berrs := make([]*protobuf.BucketError, 0, len(errs))
+	for _, bucketn := range bucketns {
+		berrs = append(berrs, &protobuf.BucketError{
+			Operation: proto.String(op),
+			Bucket:    proto.String(bucketn),
+			Err:       protobuf.NewError(errs[bucketn]),
+		})
+	}
+	return berrs
+}
+
+// probe connectivity with bucket and endpoint, nothing is added to feed.
+func (feed *Feed) probe(
+	req *protobuf.ProbeRequest) (*protobuf.ProbeResponse, error) {
+
+	prefix := feed.logPrefix
+	pooln, bucketn := req.GetPool(), req.GetBucket()
+	resp := &protobuf.ProbeResponse{}
+
+	// upstream, failover-logs and DCP connection.
+	start := time.Now()
+	vbnos := c.Vbno32to16(req.GetVbnos())
+	if len(vbnos) == 0 {
+		var err error
+		if vbnos, err = feed.getLocalVbuckets(pooln, bucketn); err != nil {
+			return resp, err
+		}
+	}
+	flogs, bucketUUID, err := feed.kv.GetFailoverLogs(pooln, bucketn, vbnos)
+	if err != nil {
+		return resp, c.CountError(c.WrapError(projC.ErrorFeeder, "bucket", bucketn))
+	}
+	resp.BucketUUID = proto.String(bucketUUID)
+	for _, vbno := range vbnos {
+		flog, valid := flogs[vbno], false
+		if len(flog) > 0 {
+			_, _, e := flog.Latest()
+			valid = e == nil
+		}
+		if valid {
+			resp.Vbnos = append(resp.Vbnos, uint32(vbno))
+		} else {
+			resp.InvalidVbnos = append(resp.InvalidVbnos, uint32(vbno))
+		}
+	}
+	uuid, err := c.NewUUID()
+	if err != nil {
+		return resp, err
+	}
+	name := newDCPConnectionName(bucketn, feed.topic, uuid.Uint64())
+	feeder, err := feed.kv.OpenBucketFeed(pooln, bucketn, name)
+	if err != nil {
+		feed.errorf("OpenBucketFeed()", bucketn, err)
+		return resp, c.CountError(c.WrapError(projC.ErrorFeeder, "bucket", bucketn))
+	}
+	feeder.CloseFeed()
+	resp.DcpTime = proto.Int64(int64(time.Since(start)))
+
+	// downstream endpoint.
+	start = time.Now()
+	raddr, typ := req.GetEndpointAddress(), req.GetEndpointType()
+	endpoint, err := feed.epFactory(feed.topic, typ, raddr, feed.token)
+	if err != nil || !endpoint.Ping() {
+		c.Errorf("%v probe endpoint %q: %v\n", prefix, raddr, err)
+		if endpoint != nil {
+			endpoint.Close()
+		}
+		err := c.WrapError(projC.ErrorEndpoint, "endpoint", raddr)
+		return resp, c.CountError(err)
+	}
+	endpoint.Close()
+	resp.EndpointTime = proto.Int64(int64(time.Since(start)))
+
+	if len(resp.InvalidVbnos) > 0 {
+		feed.errorf("probe invalid failover logs", bucketn, resp.InvalidVbnos)
+		err := c.WrapError(
+			projC.ErrorInvalidVbucket, "bucket", bucketn,
+			"vbuckets", resp.InvalidVbnos)
+		return resp, c.CountError(err)
+	}
+	c.Infof("%v probe {%v,%v} endpoint %q ok\n", prefix, pooln, bucketn, raddr)
+	return resp, nil
+}
+
+func (feed *Feed) opResult(op string, err error) {
+	if err != nil {
+		c.Errorf("%v topicOperations %v: %v\n", feed.logPrefix, op, err)
+		feed.events.record(eventError, "", "topicOperations %v: %v", op, err)
+	} else {
+		c.Infof("%v topicOperations %v: ok\n", feed.logPrefix, op)
+	}
+}
+
+func (feed *Feed) getStatistics(ctx context.Context) c.Statistics {
+	stats, _ := c.NewStatistics(nil)
+	stats.Set("topic", feed.topic)
+	stats.Set("state", feedStateString(feed.getState()))
+	stats.Set("engines", feed.engineNames())
+	stats.Set("buckets", float64(len(feed.feeders)))
+	stats.Set("maxBuckets", float64(feed.maxBuckets))
+	stats.Set("maxEngines", float64(feed.maxEngines))
+	bucketEngines := make(map[string]interface{})
+	namespaceEngines := make(map[string]interface{})
+	for bucketn, engines := range feed.engines {
+		bucketEngines[bucketn] = float64(len(engines))
+		namespaces := make(map[string]interface{})
+		for _, engine := range engines {
+			ns := namespaceName(engine.Namespace())
+			n, _ := namespaces[ns].(float64)
+			namespaces[ns] = n + 1
+		}
+		namespaceEngines[bucketn] = namespaces
+	}
+	stats.Set("bucketEngines", bucketEngines)
+	stats.Set("namespaceEngines", namespaceEngines)
+	stats.Set("disabledEngines", float64(len(feed.disabled)))
+	stats.Set("paused", feed.paused)
+	stats.Set("lateFeedback", &feed.nLateFeedback)
+	stats.Set("staleFeedback", &feed.nStaleFeedback)
+	stats.Set("streamRetries", &feed.nStreamRetries)
+	stats.Set("retryQueue", float64(feed.retries.pending()))
+	stats.Set("streamRequestLatency", feed.reqLatency)
+	phaseStats, _ := c.NewStatistics(nil)
+	for phase, latency := range feed.phaseLatency {
+		phaseStats.Set(phase, latency)
+	}
+	stats.Set("startupLatency", phaseStats)
+	stats.Set("resources", feed.resources.statistics())
+	if feed.events != nil {
+		stats.Set("events", feed.events.statistics())
+	}
+	stats.Set("reqch", feed.reqch.queue.statistics())
+	stats.Set("backch", feed.backch.queue.statistics())
+	for bucketn, kvdata := range feed.kvdata {
+		stats.Set("bucket-"+bucketn, kvdata.GetStatistics(ctx))
+	}
+	buckets := make(map[string]bool)
+	for bucketn := range feed.feeders {
+		buckets[bucketn] = true
+	}
+	for bucketn := range feed.connResets {
+		buckets[bucketn] = true
+	}
+	for bucketn := range buckets {
+		stats.Set("dcp-"+bucketn, feed.getDcpStatistics(bucketn))
+	}
+	endStats, _ := c.NewStatistics(nil)
+	for raddr, endpoint := range feed.endpoints {
+		endStats.Set(raddr, endpoint.GetStatistics(ctx))
+	}
+	stats.Set("endpoints", endStats)
+	return stats
+}
+
+// DCP statistics for a bucket, state of vbucket streams is one of
+// "pending", for StreamRequest awaiting response, "active" or "closed".
+func (feed *Feed) getDcpStatistics(bucketn string) c.Statistics {
+	stats, _ := c.NewStatistics(nil)
+	if feeder, ok := feed.feeders[bucketn]; ok {
+		for key, value := range feeder.GetStatistics() {
+			stats.Set(key, value)
+		}
+	}
+	stats.Set("connResets", float64(feed.connResets[bucketn]))
+
+	streams := make(map[string]interface{})
+	for _, vbno := range feed.localVbs[bucketn] {
+		streams[strconv.Itoa(int(vbno))] = "closed"
+	}
+	for _, vbno := range feed.reqTss[bucketn].GetVbnos() {
+		streams[strconv.Itoa(int(vbno))] = "pending"
+	}
+	for _, vbno := range feed.actTss[bucketn].GetVbnos() {
+		streams[strconv.Itoa(int(vbno))] = "active"
+	}
+	stats.Set("streams", streams)
+	return stats
+}
+
+func (feed *Feed) shutdown() error {
+	defer func() {
+		if r := recover(); r != nil {
 			c.Errorf("%v shutdown() crashed: %v\n", feed.logPrefix, r)
 			c.StackTrace(string(debug.Stack()))
 		}
 	}()
 
+	// reject new requests while upstream and downstream are closed.
+	feed.setState(feedDraining)
+
+	// requests awaiting paced StreamRequests are failed.
+	for _, run := range feed.batchRuns {
+		run.rest = nil
+		feed.finishStreamBatches(run, c.CountError(projC.ErrorFeedClosed))
+	}
+
 	// close upstream
 	for _, feeder := range feed.feeders {
 		feeder.CloseFeed()
@@ -856,6 +1854,8 @@ func (feed *Feed) shutdown() error {
 	}
 	// cleanup
 	close(feed.finch)
+	feed.setState(feedClosed)
+	feed.events.record(eventFeedShutdown, "", "feed stopped")
 	c.Infof("%v ... stopped\n", feed.logPrefix)
 	return nil
 }
@@ -863,11 +1863,23 @@ func (feed *Feed) shutdown() error {
 // shutdown upstream, data-path and remove data-structure for this bucket.
 func (feed *Feed) cleanupBucket(bucketn string, enginesOk bool) {
 	if enginesOk {
-		delete(feed.engines, bucketn) // :SideEffect:
+		for uuid := range feed.engines[bucketn] {
+			delete(feed.disabled, uuid) // :SideEffect:
+		}
+		delete(feed.engines, bucketn)      // :SideEffect:
+		delete(feed.bucketUUIDs, bucketn)  // :SideEffect:
+		delete(feed.staleBuckets, bucketn) // :SideEffect:
+	}
+	delete(feed.reqTss, bucketn)   // :SideEffect:
+	delete(feed.actTss, bucketn)   // :SideEffect:
+	delete(feed.rollTss, bucketn)  // :SideEffect:
+	delete(feed.localVbs, bucketn) // :SideEffect:
+	feed.retries.clear(bucketn)    // :SideEffect:
+	feed.dropStreamBatches(bucketn, nil)
+	if enginesOk {
+		delete(feed.endTss, bucketn)     // :SideEffect:
+		delete(feed.catchupTss, bucketn) // :SideEffect:
 	}
-	delete(feed.reqTss, bucketn)  // :SideEffect:
-	delete(feed.actTss, bucketn)  // :SideEffect:
-	delete(feed.rollTss, bucketn) // :SideEffect:
 	// close upstream
 	feeder, ok := feed.feeders[bucketn]
 	if ok {
@@ -879,6 +1891,7 @@ func (feed *Feed) cleanupBucket(bucketn string, enginesOk bool) {
 		kvdata.Close()
 	}
 	delete(feed.kvdata, bucketn) // :SideEffect:
+	feed.events.record(eventBucketCleanup, bucketn, "engines removed: %v", enginesOk)
 }
 
 // start a feed for a bucket with a set of kvfeeder,
@@ -901,9 +1914,18 @@ func (feed *Feed) bucketFeed(
 	}()
 
 	vbnos := c.Vbno32to16(reqTs.GetVbnos())
-	_ /*vbuuids*/, err = feed.bucketDetails(pooln, bucketn, vbnos)
+	begin := time.Now()
+	_ /*vbuuids*/, bucketUUID, err := feed.bucketDetails(pooln, bucketn, vbnos)
+	if start {
+		feed.timePhase(bucketn, phaseFailoverLog, begin)
+	}
 	if err != nil {
-		return nil, projC.ErrorFeeder
+		return nil, c.CountError(c.WrapError(projC.ErrorFeeder, "bucket", bucketn))
+	}
+	if start {
+		if err = feed.checkBucketUUID(bucketn, bucketUUID); err != nil {
+			return nil, err
+		}
 	}
 
 	// if streams need to be started, make sure that branch
@@ -922,20 +1944,16 @@ func (feed *Feed) bucketFeed(
 
 	feeder, ok = feed.feeders[bucketn]
 	if !ok { // the feed is being started for the first time
-		bucket, err := feed.connectBucket(feed.cluster, pooln, bucketn)
-		if err != nil {
-			return nil, projC.ErrorFeeder
-		}
 		uuid, err := c.NewUUID()
 		if err != nil {
 			c.Errorf("Could not generate UUID in c.NewUUID", bucketn, err)
 			return nil, err
 		}
-		name := newDCPConnectionName(bucket.Name, feed.topic, uuid.Uint64())
-		feeder, err = OpenBucketFeed(name, bucket)
+		name := newDCPConnectionName(bucketn, feed.topic, uuid.Uint64())
+		feeder, err = feed.kv.OpenBucketFeed(pooln, bucketn, name)
 		if err != nil {
 			feed.errorf("OpenBucketFeed()", bucketn, err)
-			return nil, projC.ErrorFeeder
+			return nil, c.CountError(c.WrapError(projC.ErrorFeeder, "bucket", bucketn))
 		}
 	}
 
@@ -944,14 +1962,17 @@ func (feed *Feed) bucketFeed(
 		c.Infof("%v stop-timestamp- %v\n", feed.logPrefix, reqTs.Repr())
 		if err = feeder.EndVbStreams(opaque, reqTs); err != nil {
 			feed.errorf("EndVbStreams()", bucketn, err)
-			return feeder, projC.ErrorFeeder
+			return feeder, c.CountError(c.WrapError(projC.ErrorFeeder, "bucket", bucketn))
 		}
 
 	} else if start {
 		c.Infof("%v start-timestamp- %v\n", feed.logPrefix, reqTs.Repr())
-		if err = feeder.StartVbStreams(opaque, reqTs); err != nil {
+		begin := time.Now()
+		err = feeder.StartVbStreams(opaque, reqTs)
+		feed.timePhase(bucketn, phaseStreamRequest, begin)
+		if err != nil {
 			feed.errorf("StartVbStreams()", bucketn, err)
-			return feeder, projC.ErrorFeeder
+			return feeder, c.CountError(c.WrapError(projC.ErrorFeeder, "bucket", bucketn))
 		}
 	}
 	return feeder, nil
@@ -959,65 +1980,134 @@ func (feed *Feed) bucketFeed(
 
 // - return dcp-client failures.
 func (feed *Feed) bucketDetails(
-	pooln, bucketn string, vbnos []uint16) ([]uint64, error) {
-
-	bucket, err := feed.connectBucket(feed.cluster, pooln, bucketn)
-	if err != nil {
-		return nil, err
-	}
-	defer bucket.Close()
+	pooln, bucketn string, vbnos []uint16) ([]uint64, string, error) {
 
 	// failover-logs
-	flogs, err := bucket.GetFailoverLogs(vbnos)
+	flogs, bucketUUID, err := feed.kv.GetFailoverLogs(pooln, bucketn, vbnos)
 	if err != nil {
-		feed.errorf("bucket.GetFailoverLogs()", bucketn, err)
-		return nil, err
+		return nil, "", err
 	}
 	vbuuids := make([]uint64, len(vbnos))
 	for i, vbno := range vbnos {
 		flog := flogs[vbno]
 		if len(flog) < 1 {
 			feed.errorf("bucket.FailoverLog empty", bucketn, nil)
-			return nil, projC.ErrorInvalidVbucket
+			err := c.CountError(c.WrapError(
+				projC.ErrorInvalidVbucket, "bucket", bucketn, "vbucket", vbno))
+			return nil, "", err
 		}
 		latestVbuuid, _, err := flog.Latest()
 		if err != nil {
 			feed.errorf("bucket.FailoverLog invalid log", bucketn, nil)
-			return nil, err
+			return nil, "", err
 		}
 		vbuuids[i] = latestVbuuid
 	}
 
-	return vbuuids, nil
+	return vbuuids, bucketUUID, nil
+}
+
+// check whether bucket was flushed or recreated, since the indexes on it
+// were defined or since its upstream was first started on this feed.
+// - return ErrorBucketUUIDChanged if bucket's uuid has changed.
+func (feed *Feed) checkBucketUUID(bucketn, uuid string) error {
+	expected := []string{feed.bucketUUIDs[bucketn]}
+	for _, engine := range feed.engines[bucketn] {
+		expected = append(expected, engine.BucketUUID())
+	}
+	for _, expectedUUID := range expected {
+		if expectedUUID != "" && expectedUUID != uuid {
+			fmsg := "%v bucket %q uuid changed from %v to %v\n"
+			c.Errorf(fmsg, feed.logPrefix, bucketn, expectedUUID, uuid)
+			feed.staleBuckets[bucketn] = true // :SideEffect:
+			return c.CountError(c.WrapError(projC.ErrorBucketUUIDChanged, "bucket", bucketn))
+		}
+	}
+	if _, ok := feed.bucketUUIDs[bucketn]; !ok {
+		feed.bucketUUIDs[bucketn] = uuid // :SideEffect:
+	}
+	delete(feed.staleBuckets, bucketn) // :SideEffect:
+	return nil
 }
 
 func (feed *Feed) getLocalVbuckets(pooln, bucketn string) ([]uint16, error) {
+	return feed.kv.GetLocalVbuckets(pooln, bucketn)
+}
+
+// clusterKV implements KVConnector{} with feed's KV cluster.
+type clusterKV struct {
+	feed *Feed
+}
+
+// GetLocalVbuckets implements KVConnector{} interface.
+func (kv *clusterKV) GetLocalVbuckets(
+	pooln, bucketn string) ([]uint16, error) {
+
+	feed := kv.feed
 	prefix := feed.logPrefix
 	// gather vbnos based on colocation policy.
 	var cinfo *c.ClusterInfoCache
-	url, err := c.ClusterAuthUrl(feed.config["clusterAddr"].String())
+	url, err := c.ClusterAuthUrlWithAuth(
+		feed.config["clusterAddr"].String(), feed.authHandler(bucketn))
 	if err == nil {
 		cinfo, err = c.NewClusterInfoCache(url, pooln)
 	}
 	if err != nil {
 		c.Errorf("%v ClusterInfoCache(`%v`): %v\n", prefix, bucketn, err)
-		return nil, projC.ErrorClusterInfo
+		return nil, c.CountError(projC.ErrorClusterInfo)
 	}
 	if err := cinfo.Fetch(); err != nil {
 		c.Errorf("%v cinfo.Fetch(`%v`): %v\n", prefix, bucketn, err)
-		return nil, projC.ErrorClusterInfo
+		return nil, c.CountError(projC.ErrorClusterInfo)
 	}
 	nodeID := cinfo.GetCurrentNode()
 	vbnos32, err := cinfo.GetVBuckets(nodeID, bucketn)
 	if err != nil {
 		c.Errorf("%v cinfo.GetVBuckets(`%v`): %v\n", prefix, bucketn, err)
-		return nil, projC.ErrorClusterInfo
+		return nil, c.CountError(projC.ErrorClusterInfo)
 	}
 	vbnos := c.Vbno32to16(vbnos32)
 	c.Infof("%v vbmap {%v,%v} - %v\n", prefix, pooln, bucketn, vbnos)
 	return vbnos, nil
 }
 
+// GetFailoverLogs implements KVConnector{} interface.
+func (kv *clusterKV) GetFailoverLogs(
+	pooln, bucketn string,
+	vbnos []uint16) (couchbase.FailoverLog, string, error) {
+
+	feed := kv.feed
+	bucket, err := feed.connectBucket(feed.cluster, pooln, bucketn)
+	if err != nil {
+		return nil, "", err
+	}
+	defer bucket.Close()
+
+	flogs, err := bucket.GetFailoverLogs(vbnos)
+	if err != nil {
+		feed.errorf("bucket.GetFailoverLogs()", bucketn, err)
+		return nil, "", err
+	}
+	return flogs, bucket.UUID, nil
+}
+
+// OpenBucketFeed implements KVConnector{} interface.
+func (kv *clusterKV) OpenBucketFeed(
+	pooln, bucketn, feedname string) (BucketFeeder, error) {
+
+	feed := kv.feed
+	bucket, err := feed.connectBucket(feed.cluster, pooln, bucketn)
+	if err != nil {
+		return nil, err
+	}
+	feeder, err := OpenBucketFeed(feedname, bucket)
+	if err != nil {
+		bucket.Close()
+		return nil, err
+	}
+	return feeder, nil
+}
+
 // start data-path each kvaddr
 func (feed *Feed) startDataPath(
 	bucketn string, feeder BucketFeeder, ts *protobuf.TsVbuuid) *KVData {
@@ -1027,8 +2117,9 @@ func (feed *Feed) startDataPath(
 	if ok {
 		kvdata.UpdateTs(ts)
 	} else { // pass engines & endpoints to kvdata.
-		engs, ends := feed.engines[bucketn], feed.endpoints
-		kvdata = NewKVData(feed, bucketn, ts, engs, ends, mutch)
+		engs, ends := feed.activeEngines(bucketn), feed.endpoints
+		endTs := feed.endTss[bucketn]
+		kvdata = NewKVData(feed, bucketn, ts, endTs, engs, ends, mutch)
 	}
 	return kvdata
 }
@@ -1039,6 +2130,9 @@ func (feed *Feed) processSubscribers(req Subscriber) error {
 	if err != nil {
 		return err
 	}
+	if err = feed.checkEngineLimit(evaluators); err != nil {
+		return err
+	}
 
 	// start fresh set of all endpoints from routers.
 	if err = feed.startEndpoints(routers); err != nil {
@@ -1056,6 +2150,61 @@ func (feed *Feed) processSubscribers(req Subscriber) error {
 		m[uuid] = engine
 		feed.engines[bucketn] = m // :SideEffect:
 	}
+	// frame endpoints with buckets routed by updated engines.
+	feed.sendVbmaps()
+	return nil
+}
+
+// buckets already on the feed along with buckets in request shall not
+// exceed maxBuckets.
+// - return ErrorTooManyBuckets if limit is exceeded.
+func (feed *Feed) checkBucketLimit(reqTss []*protobuf.TsVbuuid) error {
+	if feed.maxBuckets <= 0 {
+		return nil
+	}
+	buckets := make(map[string]bool)
+	for bucketn := range feed.feeders {
+		buckets[bucketn] = true
+	}
+	for _, ts := range reqTss {
+		buckets[ts.GetBucket()] = true
+	}
+	if len(buckets) > feed.maxBuckets {
+		fmsg := "%v %v buckets exceed limit %v\n"
+		c.Errorf(fmsg, feed.logPrefix, len(buckets), feed.maxBuckets)
+		return c.CountError(projC.ErrorTooManyBuckets)
+	}
+	return nil
+}
+
+// engines already on the feed along with engines for subscribers in
+// request shall not exceed maxEngines for any bucket.
+// - return ErrorTooManyEngines if limit is exceeded.
+func (feed *Feed) checkEngineLimit(evaluators map[uint64]c.Evaluator) error {
+	if feed.maxEngines <= 0 {
+		return nil
+	}
+	uuids := make(map[string]map[uint64]bool) // bucket -> uuid
+	for bucketn, engines := range feed.engines {
+		uuids[bucketn] = make(map[uint64]bool)
+		for uuid := range engines {
+			uuids[bucketn][uuid] = true
+		}
+	}
+	for uuid, evaluator := range evaluators {
+		bucketn := evaluator.Bucket()
+		if _, ok := uuids[bucketn]; !ok {
+			uuids[bucketn] = make(map[uint64]bool)
+		}
+		uuids[bucketn][uuid] = true
+	}
+	for bucketn, m := range uuids {
+		if len(m) > feed.maxEngines {
+			fmsg := "%v bucket %v: %v engines exceed limit %v\n"
+			c.Errorf(fmsg, feed.logPrefix, bucketn, len(m), feed.maxEngines)
+			return c.CountError(projC.ErrorTooManyEngines)
+		}
+	}
 	return nil
 }
 
@@ -1076,7 +2225,7 @@ func (feed *Feed) startEndpoints(routers map[uint64]c.Router) (err error) {
 				// endpoint found but not active or enpoint is not found.
 				c.Infof("%v endpoint %q starting ...\n", prefix, raddr)
 				topic, typ := feed.topic, feed.endpointType
-				endpoint, e = feed.epFactory(topic, typ, raddr)
+				endpoint, e = feed.epFactory(topic, typ, raddr, feed.token)
 				if e != nil {
 					c.Errorf("%v error repairing endpoint %q\n", prefix, raddr1)
 					err = e
@@ -1095,6 +2244,52 @@ func (feed *Feed) startEndpoints(routers map[uint64]c.Router) (err error) {
 	return nil
 }
 
+// frame the vbuckets local to this node, for each bucket routed to an
+// endpoint, and send them downstream. Endpoints are framed before
+// upstream is started for new vbuckets, so that their StreamBegin is
+// received by the other end only after the frame.
+func (feed *Feed) sendVbmaps() {
+	// endpoint -> bucket -> vbuckets owned by the endpoint, an endpoint
+	// is indexed by more than one address, refer startEndpoints().
+	routes := make(map[c.RouterEndpoint]map[string]map[uint16]bool)
+	for _, endpoint := range feed.endpoints {
+		routes[endpoint] = make(map[string]map[uint16]bool)
+	}
+	for bucketn := range feed.engines {
+		engines := feed.activeEngines(bucketn)
+		for _, vbno := range feed.localVbs[bucketn] {
+			for _, engine := range engines {
+				for _, raddr := range engine.VbucketEndpoints(vbno) {
+					endpoint, ok := feed.endpoints[raddr]
+					if !ok {
+						continue
+					}
+					if _, ok := routes[endpoint][bucketn]; !ok {
+						routes[endpoint][bucketn] = make(map[uint16]bool)
+					}
+					routes[endpoint][bucketn][vbno] = true
+				}
+			}
+		}
+	}
+	for endpoint, buckets := range routes {
+		vbmaps := make([]*c.VbConnectionMap, 0, len(buckets))
+		for bucketn, owned := range buckets {
+			vbnos := make([]uint16, 0, len(owned))
+			for _, vbno := range feed.localVbs[bucketn] {
+				if owned[vbno] {
+					vbnos = append(vbnos, vbno)
+				}
+			}
+			vbmap := &c.VbConnectionMap{Bucket: bucketn, Vbuckets: vbnos}
+			vbmaps = append(vbmaps, vbmap)
+		}
+		if err := endpoint.SendVbmaps(vbmaps); err != nil {
+			c.Errorf("%v endpoint.SendVbmaps(): %v\n", feed.logPrefix, err)
+		}
+	}
+}
+
 func (feed *Feed) getEndpoint(raddr string) (string, c.RouterEndpoint, error) {
 	prefix := feed.logPrefix
 	_, eqRaddr, err := c.EquivalentIP(raddr, feed.endpointRaddrs())
@@ -1118,21 +2313,21 @@ func (feed *Feed) subscribers(
 
 	evaluators, err := req.GetEvaluators()
 	if err != nil {
-		return nil, nil, projC.ErrorInconsistentFeed
+		return nil, nil, c.CountError(projC.ErrorInconsistentFeed)
 	}
 	routers, err := req.GetRouters()
 	if err != nil {
-		return nil, nil, projC.ErrorInconsistentFeed
+		return nil, nil, c.CountError(projC.ErrorInconsistentFeed)
 	}
 
 	if len(evaluators) != len(routers) {
-		err = projC.ErrorInconsistentFeed
+		err = c.CountError(projC.ErrorInconsistentFeed)
 		c.Errorf("%v error %v, len() mismatch\n", feed.logPrefix, err)
 		return nil, nil, err
 	}
 	for uuid := range evaluators {
 		if _, ok := routers[uuid]; ok == false {
-			err = projC.ErrorInconsistentFeed
+			err = c.CountError(projC.ErrorInconsistentFeed)
 			c.Errorf("%v error %v, uuid mismatch\n", feed.logPrefix, err)
 			return nil, nil, err
 		}
@@ -1156,27 +2351,335 @@ func (feed *Feed) endpointRaddrs() []string {
 	return raddrs
 }
 
-// wait for kvdata to post StreamRequest.
+// split `ts` into batches of "feedStreamReqBatchSize" vbuckets, to pace
+// StreamRequests instead of posting them for all vbuckets at once. Always
+// return atleast one batch.
+func (feed *Feed) streamBatches(ts *protobuf.TsVbuuid) []*protobuf.TsVbuuid {
+	vbnos := c.Vbno32to16(ts.GetVbnos())
+	size := feed.reqBatchSize
+	if size <= 0 || len(vbnos) <= size {
+		return []*protobuf.TsVbuuid{ts}
+	}
+	batches := make([]*protobuf.TsVbuuid, 0, (len(vbnos)+size-1)/size)
+	for i := 0; i < len(vbnos); i += size {
+		j := i + size
+		if j > len(vbnos) {
+			j = len(vbnos)
+		}
+		batches = append(batches, ts.SelectByVbuckets(vbnos[i:j]))
+	}
+	return batches
+}
+
+// wait for kvdata to post StreamRequest for vbuckets of `ts`.
 // - return ErrorResponseTimeout if feedback is not completed within timeout
 // - return ErrorNotMyVbucket if vbucket has migrated.
-// - return ErrorStreamEnd for failed stream-end request.
+// - return ErrorStreamRequest for failed stream-request.
 func (feed *Feed) waitStreamRequests(
 	opaque uint16,
 	pooln, bucketn string,
 	ts *protobuf.TsVbuuid) (rollTs, failTs, actTs *protobuf.TsVbuuid, err error) {
 
-	vbnos := c.Vbno32to16(ts.GetVbnos())
-	rollTs = protobuf.NewTsVbuuid(ts.GetPool(), ts.GetBucket(), len(vbnos))
-	failTs = protobuf.NewTsVbuuid(ts.GetPool(), ts.GetBucket(), len(vbnos))
-	actTs = protobuf.NewTsVbuuid(ts.GetPool(), ts.GetBucket(), len(vbnos))
-	if len(vbnos) == 0 {
+	n := len(ts.GetVbnos())
+	rollTs = protobuf.NewTsVbuuid(ts.GetPool(), ts.GetBucket(), n)
+	failTs = protobuf.NewTsVbuuid(ts.GetPool(), ts.GetBucket(), n)
+	actTs = protobuf.NewTsVbuuid(ts.GetPool(), ts.GetBucket(), n)
+	if n == 0 {
 		return rollTs, failTs, actTs, nil
 	}
+	defer func() {
+		feed.recordStreamRequests(bucketn, rollTs, failTs, actTs, err)
+	}()
+
+	begin := time.Now()
+	err = feed.waitStreamBatch(opaque, bucketn, ts, rollTs, failTs, actTs)
+	feed.timePhase(bucketn, phaseFeedback, begin)
+	return rollTs, failTs, actTs, err
+}
 
+// streamBatchKey identifies StreamRequests paced for a bucket.
+type streamBatchKey struct {
+	bucketn string
+	opaque  uint16
+}
+
+// streamBatchRun of StreamRequests posted for a bucket with the same
+// opaque, one batch at a time, refer paceStreamBatches().
+type streamBatchRun struct {
+	bucketn string
+	opaque  uint16
+	seq     int                  // current batch, discards stale timers
+	current *protobuf.TsVbuuid   // batch awaiting responses
+	rest    []*protobuf.TsVbuuid // batches yet to be posted
+	posted  *protobuf.TsVbuuid   // batches posted in background
+	reply   *topicReply          // nil for background retries
+	err     error
+}
+
+// topicReply of a topic request, deferred until StreamRequests paced for
+// the request are complete, refer replyTopic().
+type topicReply struct {
+	done       func(err error)
+	pending    int  // request and its streamBatchRun yet to complete
+	restart    bool // reply with restart-points, refer restartVbuckets()
+	restartTss []*protobuf.TsVbuuid
+	bucketErrs map[string]error // first error per bucket, if tracked
+	err        error
+}
+
+// newTopicReply replies with feed's topic response on `respch`, held
+// until the request is applied.
+func (feed *Feed) newTopicReply(respch chan []interface{}) *topicReply {
+	reply := &topicReply{pending: 1}
+	reply.done = func(err error) {
+		response := feed.topicResponse()
+		if reply.restart {
+			response.RestartTimestamps = reply.restartTss
+		}
+		respch <- []interface{}{response, err}
+	}
+	return reply
+}
+
+// addRestartTs merges restart-points of a bucket with the reply's.
+func (reply *topicReply) addRestartTs(restartTs *protobuf.TsVbuuid) {
+	if !reply.restart || restartTs == nil || restartTs.IsEmpty() {
+		return
+	}
+	for i, ts := range reply.restartTss {
+		if ts.GetBucket() == restartTs.GetBucket() {
+			reply.restartTss[i] = ts.Union(restartTs)
+			return
+		}
+	}
+	reply.restartTss = append(reply.restartTss, restartTs)
+}
+
+// bucketError retains the first error of a bucket, if `reply` tracks
+// errors per bucket, and returns err.
+func (reply *topicReply) bucketError(bucketn string, err error) error {
+	if reply == nil || reply.bucketErrs == nil || err == nil {
+		return err
+	} else if _, ok := reply.bucketErrs[bucketn]; !ok {
+		reply.bucketErrs[bucketn] = err
+	}
+	return err
+}
+
+// replyTopic releases a hold on `reply`, by the request or by one of its
+// streamBatchRun, and replies once every hold is released. The first
+// error is retained.
+func (feed *Feed) replyTopic(reply *topicReply, err error) {
+	if reply.err == nil {
+		reply.err = err
+	}
+	if reply.pending--; reply.pending == 0 {
+		reply.done(reply.err)
+	}
+}
+
+// paceStreamBatches posts `batches`, after the first, one batch at a time
+// by timer message fCmdStreamBatch. A batch is posted
+// "feedStreamReqBatchInterval" after responses for the previous batch are
+// applied by applyStreamRequest(), if `awaiting` responses for the first
+// batch are yet to be applied. `reply`, if not nil, is deferred until the
+// last batch is responded.
+func (feed *Feed) paceStreamBatches(
+	bucketn string, opaque uint16,
+	batches []*protobuf.TsVbuuid, reply *topicReply, awaiting bool) {
+
+	if len(batches) < 2 {
+		return
+	}
+	run := &streamBatchRun{
+		bucketn: bucketn,
+		opaque:  opaque,
+		current: batches[0],
+		rest:    batches[1:],
+		reply:   reply,
+	}
+	feed.batchRuns[streamBatchKey{bucketn, opaque}] = run // :SideEffect:
+	if reply != nil {
+		reply.pending++
+	}
+	if awaiting {
+		feed.scheduleStreamBatch(run, feed.reqTimeout*time.Millisecond, true)
+	} else {
+		feed.scheduleStreamBatch(run, feed.reqBatchInterval*time.Millisecond, false)
+	}
+}
+
+// scheduleStreamBatch posts fCmdStreamBatch for the current batch of run
+// to gen-server after `after`, if `timedout` the batch is failed unless
+// responded by then.
+func (feed *Feed) scheduleStreamBatch(
+	run *streamBatchRun, after time.Duration, timedout bool) {
+
+	cmd := []interface{}{fCmdStreamBatch, run.bucketn, run.opaque, run.seq, timedout}
+	time.AfterFunc(after, func() {
+		c.FailsafeOpAsync(feed.reqch.in, cmd, feed.finch)
+	})
+}
+
+// postStreamBatch posts the next batch of run on fCmdStreamBatch, or
+// fails the current batch and every batch after it if responses for the
+// current batch have timed out.
+func (feed *Feed) postStreamBatch(run *streamBatchRun, timedout bool) {
+	awaiting := run.current.SelectByVbSet(feed.reqTss[run.bucketn].VbSet())
+	if timedout {
+		if awaiting.IsEmpty() { // responded, next batch is scheduled.
+			return
+		}
+		// account feedback arriving later for this batch.
+		feed.addLateWaits(run.bucketn, awaiting.VbSet(), run.opaque) // :SideEffect:
+		err := c.CountError(projC.ErrorResponseTimeout)
+		c.Errorf("%v feedback timeout for stream-request %s, vbnos %v #%x\n",
+			feed.logPrefix, run.bucketn, awaiting.GetVbnos(), run.opaque)
+		feed.failStreamBatches(run, awaiting, err)
+		return
+
+	} else if !awaiting.IsEmpty() { // refer applyStreamRequest()
+		return
+
+	} else if len(run.rest) == 0 {
+		feed.finishStreamBatches(run, nil)
+		return
+	}
+
+	batch := run.rest[0]
+	c.Debugf("%v stream-request %s batch, vbnos %v #%x\n",
+		feed.logPrefix, run.bucketn, batch.GetVbnos(), run.opaque)
+	var err error
+	if feeder, ok := feed.feeders[run.bucketn]; !ok {
+		err = c.CountError(c.WrapError(projC.ErrorFeeder, "bucket", run.bucketn))
+	} else {
+		begin := time.Now()
+		e := feeder.StartVbStreams(run.opaque, batch)
+		feed.timePhase(run.bucketn, phaseStreamRequest, begin)
+		if e != nil {
+			feed.errorf("StartVbStreams()", run.bucketn, e)
+			err = c.CountError(c.WrapError(projC.ErrorFeeder, "bucket", run.bucketn))
+		}
+	}
+	if err != nil {
+		feed.failStreamBatches(run, nil, err)
+		return
+	}
+	run.current, run.rest, run.seq = batch, run.rest[1:], run.seq+1
+	run.posted = run.posted.Union(batch)
+	feed.retries.posted(run.bucketn, batch.VbSet(), time.Now())
+	feed.scheduleStreamBatch(run, feed.reqTimeout*time.Millisecond, true)
+}
+
+// streamBatchResponded is called by applyStreamRequest() for responses
+// to a batch of run, the next batch is scheduled once every vbucket of
+// current batch has responded.
+func (feed *Feed) streamBatchResponded(run *streamBatchRun) {
+	awaiting := run.current.SelectByVbSet(feed.reqTss[run.bucketn].VbSet())
+	if !awaiting.IsEmpty() {
+		return
+	} else if len(run.rest) == 0 {
+		feed.finishStreamBatches(run, nil)
+		return
+	}
+	feed.scheduleStreamBatch(run, feed.reqBatchInterval*time.Millisecond, false)
+}
+
+// failStreamBatches fails `failTs`, awaiting responses, and batches of
+// run yet to be posted. Failed vbuckets are queued for retry.
+func (feed *Feed) failStreamBatches(
+	run *streamBatchRun, failTs *protobuf.TsVbuuid, err error) {
+
+	for _, batch := range run.rest {
+		failTs = failTs.Union(batch)
+	}
+	run.rest = nil
+	reqTs := feed.reqTss[run.bucketn]
+	feed.reqTss[run.bucketn] = reqTs.FilterByVbSet(failTs.VbSet()) // :SideEffect:
+	feed.events.record(eventStreamFailed, run.bucketn,
+		"vbnos %v: %v", failTs.VbSet(), err)
+	// vbuckets already queued retain their budget.
+	queued := c.NewVbSet()
+	failTs.VbSet().Range(func(vbno uint16) bool {
+		if feed.retries.queued(run.bucketn, vbno) {
+			queued.Add(vbno)
+		}
+		return true
+	})
+	feed.retries.add(failTs.FilterByVbSet(queued), time.Now())
+	feed.scheduleRetries()
+	feed.finishStreamBatches(run, err)
+}
+
+// finishStreamBatches forgets run, and replies to its request once the
+// request has no other run pending.
+func (feed *Feed) finishStreamBatches(run *streamBatchRun, err error) {
+	delete(feed.batchRuns, streamBatchKey{run.bucketn, run.opaque}) // :SideEffect:
+	if run.err == nil {
+		run.err = err
+	}
+	if reply := run.reply; reply != nil {
+		// where each of the vbuckets requested in background resumed from.
+		actTs := feed.actTss[run.bucketn]
+		reply.addRestartTs(run.posted.SelectByVbSet(actTs.VbSet()))
+		feed.replyTopic(reply, reply.bucketError(run.bucketn, run.err))
+	}
+}
+
+// dropStreamBatches drops vbuckets in `vbset` from runs of bucket, and
+// forgets their out-standing request. If `vbset` is nil every run of the
+// bucket is complete.
+func (feed *Feed) dropStreamBatches(bucketn string, vbset *c.VbSet) {
+	if vbset != nil {
+		reqTs := feed.reqTss[bucketn]
+		feed.reqTss[bucketn] = reqTs.FilterByVbSet(vbset) // :SideEffect:
+	}
+	for key, run := range feed.batchRuns {
+		if key.bucketn != bucketn {
+			continue
+		} else if vbset == nil {
+			run.rest = nil
+			feed.finishStreamBatches(run, nil)
+			continue
+		}
+		run.current = run.current.FilterByVbSet(vbset)
+		rest := make([]*protobuf.TsVbuuid, 0, len(run.rest))
+		for _, batch := range run.rest {
+			if batch = batch.FilterByVbSet(vbset); !batch.IsEmpty() {
+				rest = append(rest, batch)
+			}
+		}
+		run.rest = rest
+		feed.streamBatchResponded(run)
+	}
+}
+
+// record vbuckets whose streams began, rolled back or failed in event log.
+func (feed *Feed) recordStreamRequests(
+	bucketn string, rollTs, failTs, actTs *protobuf.TsVbuuid, err error) {
+
+	if vbset := actTs.VbSet(); !vbset.IsEmpty() {
+		feed.events.record(eventStreamBegin, bucketn, "vbnos %v", vbset)
+	}
+	if vbset := rollTs.VbSet(); !vbset.IsEmpty() {
+		feed.events.record(eventRollback, bucketn, "vbnos %v", vbset)
+	}
+	if vbset := failTs.VbSet(); !vbset.IsEmpty() {
+		feed.events.record(eventStreamFailed, bucketn, "vbnos %v: %v", vbset, err)
+	}
+}
+
+// wait for responses to StreamRequests posted for a batch of vbuckets,
+// and book-keep them in rollTs, failTs and actTs.
+func (feed *Feed) waitStreamBatch(
+	opaque uint16, bucketn string,
+	batch, rollTs, failTs, actTs *protobuf.TsVbuuid) (err error) {
+
+	pending := batch.VbSet()
 	timeout := time.After(feed.reqTimeout * time.Millisecond)
-	err1 := feed.waitOnFeedback(timeout, func(msg interface{}) string {
+	err1 := feed.waitOnFeedback(opaque, bucketn, pending, timeout, func(msg interface{}) string {
 		if val, ok := msg.(*controlStreamRequest); ok && val.bucket == bucketn && val.opaque == opaque &&
-			ts.Contains(val.vbno) {
+			pending.Has(val.vbno) {
 
 			if val.status == mcd.SUCCESS {
 				actTs.Append(val.vbno, val.seqno, val.vbuuid, 0, 0)
@@ -1184,13 +2687,12 @@ func (feed *Feed) waitStreamRequests(
 				rollTs.Append(val.vbno, val.seqno, val.vbuuid, 0, 0)
 			} else if val.status == mcd.NOT_MY_VBUCKET {
 				failTs.Append(val.vbno, val.seqno, val.vbuuid, 0, 0)
-				err = projC.ErrorNotMyVbucket
+				err = c.CountError(projC.ErrorNotMyVbucket)
 			} else {
 				failTs.Append(val.vbno, val.seqno, val.vbuuid, 0, 0)
-				err = projC.ErrorStreamRequest
+				err = c.CountError(projC.ErrorStreamRequest)
 			}
-			vbnos = c.RemoveUint16(val.vbno, vbnos)
-			if len(vbnos) == 0 {
+			if pending.Remove(val.vbno).IsEmpty() {
 				return "done"
 			}
 			return "ok"
@@ -1200,9 +2702,247 @@ func (feed *Feed) waitStreamRequests(
 	if err == nil {
 		err = err1
 	}
+	return err
+}
+
+// wait for kvdata to post StreamRequest for the first of `batches`,
+// already posted by bucketFeed(), rest of the batches are paced in
+// background, refer paceStreamBatches(). Vbuckets whose StreamRequest
+// failed, say due to rebalance, are queued to be re-requested in
+// background with exponential backoff, until they succeed or the retry
+// budget is exhausted, refer retryVbuckets().
+// - return ErrorResponseTimeout if feedback is not completed within timeout,
+//   later batches are not requested and returned as failed.
+// - return ErrorNotMyVbucket if vbucket has migrated.
+// - return ErrorStreamRequest for failed stream-request.
+func (feed *Feed) waitStreamRequestsRetry(
+	opaque uint16,
+	pooln, bucketn string,
+	batches []*protobuf.TsVbuuid,
+	reply *topicReply) (rollTs, failTs, actTs *protobuf.TsVbuuid, err error) {
+
+	start := time.Now()
+	rollTs, failTs, actTs, err = feed.waitStreamRequests(opaque, pooln, bucketn, batches[0])
+	feed.reqLatency.Add(int64(time.Since(start)))
+	if err == projC.ErrorResponseTimeout {
+		for _, rest := range batches[1:] {
+			failTs = failTs.Union(rest)
+		}
+	} else {
+		feed.paceStreamBatches(bucketn, opaque, batches, reply, false)
+	}
+	// vbuckets requested afresh are retried with a fresh budget.
+	var ts *protobuf.TsVbuuid
+	for _, batch := range batches {
+		ts = ts.Union(batch)
+	}
+	feed.retries.remove(bucketn, ts.VbSet()) // :SideEffect:
+	feed.retries.add(ts.SelectByVbSet(failTs.VbSet()), time.Now())
+	feed.scheduleRetries()
 	return rollTs, failTs, actTs, err
 }
 
+// scheduleRetries arms a timer that posts fCmdRetryVbuckets to gen-server
+// once the earliest backoff in the retry queue elapses, unless a timer is
+// already armed to fire by then.
+func (feed *Feed) scheduleRetries() {
+	next, ok := feed.retries.next()
+	if !ok || feed.paused { // re-scheduled when feed is resumed.
+		return
+	} else if !feed.retryAt.IsZero() && !next.Before(feed.retryAt) {
+		return
+	}
+	feed.retryAt = next // :SideEffect:
+	time.AfterFunc(next.Sub(time.Now()), func() {
+		cmd := []interface{}{fCmdRetryVbuckets}
+		c.FailsafeOpAsync(feed.reqch.in, cmd, feed.finch)
+	})
+}
+
+// re-request vbuckets, queued by waitStreamRequestsRetry() or
+// applyStreamRequest(), whose backoff has elapsed, on fCmdRetryVbuckets.
+// Vbuckets that are active or no longer local to this node are dropped
+// from the queue, the rest are backed off until they succeed or their
+// retry budget is exhausted.
+func (feed *Feed) retryVbuckets() {
+	if feed.paused { // feedback does not arrive while paused.
+		return
+	}
+	dueTss, expired := feed.retries.due(time.Now())
+	for bucketn, vbset := range expired {
+		fmsg := "%v retries exhausted for stream-request %s, vbnos %v\n"
+		c.Errorf(fmsg, feed.logPrefix, bucketn, vbset)
+		feed.events.record(
+			eventStreamFailed, bucketn, "vbnos %v: retries exhausted", vbset)
+	}
+	for bucketn, ts := range dueTss {
+		feed.retryBucket(bucketn, ts)
+	}
+}
+
+func (feed *Feed) retryBucket(bucketn string, ts *protobuf.TsVbuuid) {
+	kvdata, ok := feed.kvdata[bucketn]
+	if !ok { // bucket is cleaned up
+		feed.retries.clear(bucketn)
+		return
+	}
+	pooln := ts.GetPool()
+	vbnos, err := feed.getLocalVbuckets(pooln, bucketn)
+	if err != nil { // retried after backoff
+		feed.errorf("retryBucket() getLocalVbuckets()", bucketn, err)
+		return
+	}
+	local := ts.SelectByVbuckets(vbnos)
+	retryTs := local.FilterByVbSet(feed.actTss[bucketn].VbSet())
+	drop := ts.FilterByVbSet(retryTs.VbSet())
+	feed.retries.remove(bucketn, drop.VbSet()) // :SideEffect:
+	// vbuckets awaiting response, or paced to be requested, are retried
+	// after backoff, unless the previous attempt has timed out.
+	now, timeout := time.Now(), feed.reqTimeout*time.Millisecond
+	reqTs := feed.reqTss[bucketn]
+	awaiting := c.NewVbSet()
+	retryTs.VbSet().Intersect(reqTs.VbSet()).Range(func(vbno uint16) bool {
+		if feed.retries.awaiting(bucketn, vbno, now, timeout) ||
+			feed.streamBatchPending(bucketn, vbno) {
+			awaiting.Add(vbno)
+		}
+		return true
+	})
+	retryTs = retryTs.FilterByVbSet(awaiting)
+	feed.reqTss[bucketn] = reqTs.FilterByVbSet(retryTs.VbSet()) // :SideEffect:
+	if retryTs.IsEmpty() {
+		return
+	}
+
+	feed.localVbs[bucketn] = vbnos // :SideEffect:
+	feed.sendVbmaps()
+	feed.nStreamRetries.Add(int64(len(retryTs.GetVbnos())))
+	c.Infof("%v background retry stream-request %s, vbnos %v\n",
+		feed.logPrefix, bucketn, retryTs.GetVbnos())
+
+	kvdata.UpdateTs(retryTs)
+	opaque := newOpaque()
+	batches := feed.streamBatches(retryTs)
+	feeder, err := feed.bucketFeed(opaque, false, true, batches[0])
+	if err != nil {
+		feed.errorf("retryBucket() bucketFeed()", bucketn, err)
+		return
+	}
+	// responses are applied by gen-server as they arrive, refer
+	// applyStreamRequest().
+	feed.feeders[bucketn] = feeder                             // :SideEffect:
+	feed.reqTss[bucketn] = retryTs.Union(feed.reqTss[bucketn]) // :SideEffect:
+	feed.retries.posted(bucketn, batches[0].VbSet(), now)
+	feed.paceStreamBatches(bucketn, opaque, batches, nil, true)
+}
+
+// applyStreamEnd book-keeps StreamEnd feedback that is not claimed by a
+// waiting request.
+func (feed *Feed) applyStreamEnd(v *controlStreamEnd) {
+	c.Debugf("%v back channel flush %v\n", feed.logPrefix, v.Repr())
+	feed.events.record(
+		eventStreamEnd, v.bucket, "vbno %v, status %v", v.vbno, v.status)
+	reqTs := feed.reqTss[v.bucket]
+	reqTs = reqTs.FilterByVbuckets([]uint16{v.vbno})
+	feed.reqTss[v.bucket] = reqTs
+
+	actTs := feed.actTss[v.bucket]
+	actTs = actTs.FilterByVbuckets([]uint16{v.vbno})
+	feed.actTss[v.bucket] = actTs
+
+	rollTs := feed.rollTss[v.bucket]
+	rollTs = rollTs.FilterByVbuckets([]uint16{v.vbno})
+	feed.rollTss[v.bucket] = rollTs
+}
+
+// applyStaleFeedback applies feedback set aside by waitOnFeedback(), once
+// the request that was waiting has book-kept its own feedback.
+func (feed *Feed) applyStaleFeedback() {
+	for _, msg := range feed.staleFeedback {
+		switch v := msg.(type) {
+		case *controlStreamRequest:
+			feed.applyStreamRequest(v)
+		case *controlStreamEnd:
+			feed.applyStreamEnd(v)
+		}
+	}
+	feed.staleFeedback = nil
+}
+
+// streamBatchPending returns whether vbucket of bucket is in a batch yet
+// to be posted or responded, refer paceStreamBatches().
+func (feed *Feed) streamBatchPending(bucketn string, vbno uint16) bool {
+	for key, run := range feed.batchRuns {
+		if key.bucketn != bucketn {
+			continue
+		} else if run.current.Contains(vbno) {
+			return true
+		}
+		for _, batch := range run.rest {
+			if batch.Contains(vbno) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyStreamRequest book-keeps StreamRequest feedback that is not
+// claimed by a waiting request, like responses for background retries.
+// Vbuckets that succeed or rollback leave the retry queue, failed
+// vbuckets are (re)queued for retry.
+func (feed *Feed) applyStreamRequest(v *controlStreamRequest) {
+	reqTs, ok := feed.reqTss[v.bucket]
+	seqno, vbuuid, sStart, sEnd, err := reqTs.Get(v.vbno)
+	if err != nil {
+		c.Errorf("%v unexpected %T for %v\n", feed.logPrefix, v, v)
+		return
+	} else if !ok {
+		return
+	}
+	c.Debugf("%v back channel flush %v\n", feed.logPrefix, v.Repr())
+	restartTs := reqTs.SelectByVbuckets([]uint16{v.vbno})
+	reqTs = reqTs.FilterByVbuckets([]uint16{v.vbno})
+	feed.reqTss[v.bucket] = reqTs
+	feed.retries.responded(v.bucket, v.vbno)
+
+	switch v.status {
+	case mcd.ROLLBACK:
+		rollTs := feed.rollTss[v.bucket]
+		rollTs.Append(v.vbno, v.seqno, vbuuid, sStart, sEnd)
+		feed.events.record(eventRollback, v.bucket,
+			"vbno %v, seqno %v", v.vbno, v.seqno)
+		feed.retries.remove(v.bucket, c.NewVbSet(v.vbno))
+
+	case mcd.SUCCESS:
+		actTs := feed.actTss[v.bucket]
+		actTs.Append(v.vbno, seqno, vbuuid, sStart, sEnd)
+		feed.events.record(eventStreamBegin, v.bucket,
+			"vbno %v, seqno %v", v.vbno, seqno)
+		feed.retries.remove(v.bucket, c.NewVbSet(v.vbno))
+
+	default: // retried after backoff, with its budget intact if queued.
+		c.Warnf("%v stream-request %s, vbno %v failed with status %v\n",
+			feed.logPrefix, v.bucket, v.vbno, v.status)
+		if !feed.retries.queued(v.bucket, v.vbno) {
+			feed.retries.add(restartTs, time.Now())
+		}
+		feed.scheduleRetries()
+	}
+
+	// responses to a batch paced in background.
+	key := streamBatchKey{bucketn: v.bucket, opaque: v.opaque}
+	if run, ok := feed.batchRuns[key]; ok && run.current.Contains(v.vbno) {
+		failed := v.status != mcd.SUCCESS && v.status != mcd.ROLLBACK
+		if run.err == nil && v.status == mcd.NOT_MY_VBUCKET {
+			run.err = c.CountError(projC.ErrorNotMyVbucket)
+		} else if run.err == nil && failed {
+			run.err = c.CountError(projC.ErrorStreamRequest)
+		}
+		feed.streamBatchResponded(run)
+	}
+}
+
 // wait for kvdata to post StreamEnd.
 // - return ErrorResponseTimeout if feedback is not completed within timeout.
 // - return ErrorNotMyVbucket if vbucket has migrated.
@@ -1212,29 +2952,28 @@ func (feed *Feed) waitStreamEnds(
 	bucketn string,
 	ts *protobuf.TsVbuuid) (endTs, failTs *protobuf.TsVbuuid, err error) {
 
-	vbnos := c.Vbno32to16(ts.GetVbnos())
-	endTs = protobuf.NewTsVbuuid(ts.GetPool(), ts.GetBucket(), len(vbnos))
-	failTs = protobuf.NewTsVbuuid(ts.GetPool(), ts.GetBucket(), len(vbnos))
-	if len(vbnos) == 0 {
+	pending := ts.VbSet()
+	endTs = protobuf.NewTsVbuuid(ts.GetPool(), ts.GetBucket(), pending.Len())
+	failTs = protobuf.NewTsVbuuid(ts.GetPool(), ts.GetBucket(), pending.Len())
+	if pending.IsEmpty() {
 		return endTs, failTs, nil
 	}
 
 	timeout := time.After(feed.endTimeout * time.Millisecond)
-	err1 := feed.waitOnFeedback(timeout, func(msg interface{}) string {
+	err1 := feed.waitOnFeedback(opaque, bucketn, pending, timeout, func(msg interface{}) string {
 		if val, ok := msg.(*controlStreamEnd); ok && val.bucket == bucketn && val.opaque == opaque &&
-			ts.Contains(val.vbno) {
+			pending.Has(val.vbno) {
 
 			if val.status == mcd.SUCCESS {
 				endTs.Append(val.vbno, 0 /*seqno*/, 0 /*vbuuid*/, 0, 0)
 			} else if val.status == mcd.NOT_MY_VBUCKET {
 				failTs.Append(val.vbno, 0 /*seqno*/, 0 /*vbuuid*/, 0, 0)
-				err = projC.ErrorNotMyVbucket
+				err = c.CountError(projC.ErrorNotMyVbucket)
 			} else {
 				failTs.Append(val.vbno, 0 /*seqno*/, 0 /*vbuuid*/, 0, 0)
-				err = projC.ErrorStreamEnd
+				err = c.CountError(projC.ErrorStreamEnd)
 			}
-			vbnos = c.RemoveUint16(val.vbno, vbnos)
-			if len(vbnos) == 0 {
+			if pending.Remove(val.vbno).IsEmpty() {
 				return "done"
 			}
 			return "ok"
@@ -1247,19 +2986,31 @@ func (feed *Feed) waitStreamEnds(
 	return endTs, failTs, err
 }
 
-// block feed until feedback posted back from kvdata.
+// block feed until feedback posted back from kvdata for `pending`
+// vbuckets of bucket. Feedback left unclaimed for staleTimeout is set
+// aside, so that it does not confuse later waits, and applied once the
+// request is done, refer applyStaleFeedback().
 // - return ErrorResponseTimeout if feedback is not completed within timeout
 func (feed *Feed) waitOnFeedback(
+	opaque uint16, bucketn string, pending *c.VbSet,
 	timeout <-chan time.Time, callb func(msg interface{}) string) (err error) {
 
 	msgs := make([][]interface{}, 0)
 loop:
 	for {
 		select {
-		case msg := <-feed.backch:
+		case msg := <-feed.backch.out:
 			c.Debugf("%v back channel %T\n", feed.logPrefix, msg[0])
 			switch callb(msg[0]) {
 			case "skip":
+				if feed.isStaleFeedback(msg[0]) {
+					feed.checkLateFeedback(msg[0])
+					feed.nStaleFeedback.Add(1)
+					c.Warnf("%v deferring stale feedback %v\n",
+						feed.logPrefix, feedbackRepr(msg[0]))
+					feed.staleFeedback = append(feed.staleFeedback, msg[0])
+					continue
+				}
 				msgs = append(msgs, msg)
 			case "done":
 				break loop
@@ -1267,18 +3018,94 @@ loop:
 			}
 
 		case <-timeout:
-			err = projC.ErrorResponseTimeout
-			c.Errorf("%v feedback timeout %v\n", feed.logPrefix, err)
+			err = c.CountError(projC.ErrorResponseTimeout)
+			// remember the wait, so that feedback arriving later for
+			// pending vbuckets can be accounted.
+			feed.addLateWaits(bucketn, pending, opaque)
+			c.Errorf("%v feedback timeout for %s vbnos %v opaque %x: %v\n",
+				feed.logPrefix, bucketn, pending, opaque, err)
 			break loop
 		}
 	}
 	// re-populate in the same order.
 	for _, msg := range msgs {
-		feed.backch <- []interface{}{msg}
+		feed.backch.in <- msg
 	}
 	return
 }
 
+// feedbackKey identifies the wait for feedback of a vbucket, opaques
+// are reused across requests and buckets.
+type feedbackKey struct {
+	bucketn string
+	vbno    uint16
+	opaque  uint16
+}
+
+// feedbackInfo returns the key and posting time of a stream feedback,
+// ok is false for any other control message.
+func feedbackInfo(msg interface{}) (key feedbackKey, posted time.Time, ok bool) {
+	switch v := msg.(type) {
+	case *controlStreamRequest:
+		return feedbackKey{v.bucket, v.vbno, v.opaque}, v.posted, true
+	case *controlStreamEnd:
+		return feedbackKey{v.bucket, v.vbno, v.opaque}, v.posted, true
+	}
+	return feedbackKey{}, time.Time{}, false
+}
+
+func feedbackRepr(msg interface{}) string {
+	if v, ok := msg.(interface {
+		Repr() string
+	}); ok {
+		return v.Repr()
+	}
+	return fmt.Sprintf("%T", msg)
+}
+
+// isStaleFeedback returns true if feedback has stayed unclaimed for more
+// than staleTimeout.
+func (feed *Feed) isStaleFeedback(msg interface{}) bool {
+	_, posted, ok := feedbackInfo(msg)
+	if !ok || feed.staleTimeout <= 0 {
+		return false
+	}
+	return time.Since(posted) > feed.staleTimeout*time.Millisecond
+}
+
+// addLateWaits remembers vbuckets whose wait for feedback timed out.
+func (feed *Feed) addLateWaits(bucketn string, vbset *c.VbSet, opaque uint16) {
+	now := time.Now()
+	vbset.Range(func(vbno uint16) bool {
+		feed.lateWaits[feedbackKey{bucketn, vbno, opaque}] = now
+		return true
+	})
+}
+
+// checkLateFeedback accounts feedback arriving for a wait that has
+// already timed out.
+func (feed *Feed) checkLateFeedback(msg interface{}) {
+	key, posted, ok := feedbackInfo(msg)
+	if !ok {
+		return
+	}
+	if timedout, ok := feed.lateWaits[key]; ok {
+		delete(feed.lateWaits, key)
+		feed.nLateFeedback.Add(1)
+		c.Warnf("%v late feedback %v, opaque %x posted %v after timeout\n",
+			feed.logPrefix, feedbackRepr(msg), key.opaque, posted.Sub(timedout))
+	}
+}
+
+// purgeLateWaits forgets waits that timed out before staleTimeout.
+func (feed *Feed) purgeLateWaits() {
+	for key, timedout := range feed.lateWaits {
+		if time.Since(timedout) > feed.staleTimeout*time.Millisecond {
+			delete(feed.lateWaits, key)
+		}
+	}
+}
+
 // compose topic-response for caller
 func (feed *Feed) topicResponse() *protobuf.TopicResponse {
 	uuids := make([]uint64, 0)
@@ -1297,11 +3124,72 @@ func (feed *Feed) topicResponse() *protobuf.TopicResponse {
 			ys = append(ys, ts)
 		}
 	}
+	stale := make([]string, 0, len(feed.staleBuckets))
+	for bucketn := range feed.staleBuckets {
+		stale = append(stale, bucketn)
+	}
+	sort.Strings(stale)
+	zs := make([]*protobuf.TsVbuuid, 0, len(feed.catchupTss))
+	for _, ts := range feed.catchupTss {
+		zs = append(zs, ts)
+	}
+	disabled := make([]uint64, 0, len(feed.disabled))
+	for uuid := range feed.disabled {
+		disabled = append(disabled, uuid)
+	}
+	timings := make([]*protobuf.BucketTimings, 0, len(feed.timings))
+	for _, t := range feed.timings {
+		timings = append(timings, t)
+	}
 	return &protobuf.TopicResponse{
-		Topic:              proto.String(feed.topic),
-		InstanceIds:        uuids,
-		ActiveTimestamps:   xs,
-		RollbackTimestamps: ys,
+		Topic:               proto.String(feed.topic),
+		InstanceIds:         uuids,
+		ActiveTimestamps:    xs,
+		RollbackTimestamps:  ys,
+		StaleBuckets:        stale,
+		CatchupTimestamps:   zs,
+		DisabledInstanceIds: disabled,
+		Timings:             timings,
+		Paused:              proto.Bool(feed.paused),
+		Token:               proto.String(feed.token),
+	}
+}
+
+// setToken issued by downstream for the topic, if request carries one,
+// endpoints started hereafter frame their connection with it.
+func (feed *Feed) setToken(token *string) {
+	if token != nil {
+		feed.token = *token
+	}
+}
+
+// resetTimings before a request that starts or restarts streams, so that
+// topic-response carries timings of the last such request.
+func (feed *Feed) resetTimings() {
+	feed.timings = make(map[string]*protobuf.BucketTimings)
+}
+
+// timePhase accounts time elapsed since `begin` to `phase` of starting
+// streams for bucketn, so that slow startup can be attributed to
+// cluster-info, KV or projector itself.
+func (feed *Feed) timePhase(bucketn, phase string, begin time.Time) {
+	d := int64(time.Since(begin))
+	feed.phaseLatency[phase].Add(d)
+
+	t, ok := feed.timings[bucketn]
+	if !ok {
+		t = &protobuf.BucketTimings{Bucket: proto.String(bucketn)}
+		feed.timings[bucketn] = t
+	}
+	switch phase {
+	case phaseVbmap:
+		t.VbmapTime = proto.Int64(t.GetVbmapTime() + d)
+	case phaseFailoverLog:
+		t.FailoverLogTime = proto.Int64(t.GetFailoverLogTime() + d)
+	case phaseStreamRequest:
+		t.StreamRequestTime = proto.Int64(t.GetStreamRequestTime() + d)
+	case phaseFeedback:
+		t.FeedbackTime = proto.Int64(t.GetFeedbackTime() + d)
 	}
 }
 
@@ -1320,6 +3208,7 @@ func newDCPConnectionName(bucketn, topic string, uuid uint64) string {
 
 func (feed *Feed) errorf(prefix, bucketn string, val interface{}) {
 	c.Errorf("%v %v for %q: %v\n", feed.logPrefix, prefix, bucketn, val)
+	feed.events.record(eventError, bucketn, "%v: %v", prefix, val)
 }
 
 func (feed *Feed) debugf(prefix, bucketn string, val interface{}) {
@@ -1330,23 +3219,39 @@ func (feed *Feed) infof(prefix, bucketn string, val interface{}) {
 	c.Infof("%v %v for %q: %v\n", feed.logPrefix, prefix, bucketn, val)
 }
 
+// authHandler authenticates connections to bucketn with the configured
+// credentials, or with cbauth if none are configured.
+func (feed *Feed) authHandler(bucketn string) couchbase.AuthHandler {
+	username := feed.config["username"].String()
+	password := feed.config["password"].Secret()
+	return c.NewAuthHandler(feed.cluster, bucketn, username, password)
+}
+
 // connectBucket will instantiate a couchbase-bucket instance with cluster.
+// both pool/bucket REST calls and DCP connections made on the bucket are
+// authenticated, DCP connections are made over TLS if configured.
 // caller's responsibility to close the bucket.
 func (feed *Feed) connectBucket(cluster, pooln, bucketn string) (*couchbase.Bucket, error) {
-	couch, err := couchbase.Connect("http://" + cluster)
+	ah := feed.authHandler(bucketn)
+	tlsConfig, port, err := c.NewKVTLSConfig(feed.config)
+	if err != nil {
+		feed.errorf("NewKVTLSConfig(`%v`)", bucketn, err)
+		return nil, c.CountError(c.WrapError(projC.ErrorDCPConnection, "bucket", bucketn))
+	}
+	couch, err := couchbase.ConnectWithAuthTLS("http://"+cluster, ah, tlsConfig, port)
 	if err != nil {
 		feed.errorf("connectBucket(`%v`)", bucketn, err)
-		return nil, projC.ErrorDCPConnection
+		return nil, c.CountError(c.WrapError(projC.ErrorDCPConnection, "bucket", bucketn))
 	}
 	pool, err := couch.GetPool(pooln)
 	if err != nil {
 		feed.errorf("GetPool(`%v`)", pooln, err)
-		return nil, projC.ErrorDCPPool
+		return nil, c.CountError(c.WrapError(projC.ErrorDCPPool, "pool", pooln))
 	}
 	bucket, err := pool.GetBucket(bucketn)
 	if err != nil {
 		feed.errorf("GetBucket(`%v`)", bucketn, err)
-		return nil, projC.ErrorDCPBucket
+		return nil, c.CountError(c.WrapError(projC.ErrorDCPBucket, "bucket", bucketn))
 	}
 	return bucket, nil
 }
@@ -0,0 +1,1232 @@
+package projector_test
+
+import "context"
+import "errors"
+import "reflect"
+import "strconv"
+import "testing"
+import "time"
+
+import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+import mcs "github.com/couchbase/indexing/secondary/dcp/transport/server"
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+import "github.com/couchbase/indexing/secondary/projector"
+import projC "github.com/couchbase/indexing/secondary/projector/client"
+import "github.com/couchbase/indexing/secondary/projector/feedtest"
+import "github.com/couchbaselabs/goprotobuf/proto"
+
+const testTopic = "feedtest"
+const testRaddr = "127.0.0.1:9020"
+
+var testVbnos = []uint16{0, 1, 2, 3}
+
+func startTestFeed(
+	t *testing.T,
+	setup func(kv *feedtest.KV, config c.Config)) (
+	*projector.Feed, *feedtest.KV, *feedtest.Endpoints) {
+
+	kv, eps := feedtest.NewKV(), feedtest.NewEndpoints()
+	kv.AddBucket("default", "uuid1", testVbnos)
+	config := feedtest.FeedConfig(kv, eps, len(testVbnos), 200)
+	if setup != nil {
+		setup(kv, config)
+	}
+	feed, err := projector.NewFeed(testTopic, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return feed, kv, eps
+}
+
+func testContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 10*time.Second)
+}
+
+// timestamper is implemented by fake KVs.
+type timestamper interface {
+	Timestamp(pooln, bucketn string) *protobuf.TsVbuuid
+}
+
+func mutationTopic(
+	feed *projector.Feed, kv timestamper) (*protobuf.TopicResponse, error) {
+
+	return mutationTopicAt(feed, kv.Timestamp("default", "default"))
+}
+
+func mutationTopicAt(
+	feed *projector.Feed,
+	reqTs *protobuf.TsVbuuid) (*protobuf.TopicResponse, error) {
+
+	instances := protobuf.ExampleIndexInstances(
+		[]string{"default"}, []string{testRaddr}, "")
+	req := protobuf.NewMutationTopicRequest(testTopic, "dataport", instances)
+	req.Append(reqTs)
+
+	ctx, cancel := testContext()
+	defer cancel()
+	return feed.MutationTopic(ctx, req)
+}
+
+func activeVbnos(resp *protobuf.TopicResponse, bucketn string) []uint16 {
+	for _, ts := range resp.GetActiveTimestamps() {
+		if ts.GetBucket() == bucketn {
+			return c.Vbno32to16(ts.GetVbnos())
+		}
+	}
+	return nil
+}
+
+// waitActiveVbnos waits for vbuckets of bucket default to be active.
+func waitActiveVbnos(t *testing.T, feed *projector.Feed, vbnos []uint16) {
+	ctx, cancel := testContext()
+	defer cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp := feed.GetTopicResponse(ctx)
+		if reflect.DeepEqual(activeVbnos(resp, "default"), vbnos) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("expected active vbuckets %v", vbnos)
+}
+
+func shutdownFeed(t *testing.T, feed *projector.Feed) {
+	ctx, cancel := testContext()
+	defer cancel()
+	if err := feed.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFeedStartShutdown(t *testing.T) {
+	feed, kv, eps := startTestFeed(t, nil)
+
+	resp, err := mutationTopic(feed, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, testVbnos) {
+		t.Fatalf("expected active vbuckets %v, got %v", testVbnos, vbnos)
+	}
+	endpoint := eps.Get(testRaddr)
+	if endpoint == nil {
+		t.Fatalf("expected endpoint %v to be started", testRaddr)
+	}
+	vbmaps := endpoint.Vbmaps()
+	if len(vbmaps) != 1 || !reflect.DeepEqual(vbmaps[0].Vbuckets, testVbnos) {
+		t.Fatalf("unexpected vbmaps %v", vbmaps)
+	}
+
+	shutdownFeed(t, feed)
+	for _, feeder := range kv.Feeders("default") {
+		if !feeder.IsClosed() {
+			t.Errorf("expected upstream %v to be closed", feeder.Name())
+		}
+	}
+	if !endpoint.IsClosed() {
+		t.Errorf("expected endpoint to be closed")
+	}
+	if _, err := mutationTopic(feed, kv); err != projC.ErrorFeedClosed {
+		t.Errorf("expected %v after shutdown, got %v", projC.ErrorFeedClosed, err)
+	}
+}
+
+// feedRequests issues requests that fail with ErrorFeedClosed once feed
+// is shutdown, returns the error of each request on the channel.
+func feedRequests(feed *projector.Feed, kv *feedtest.KV) <-chan error {
+	ts := kv.Timestamp("default", "default").SelectByVbuckets([]uint16{0})
+	requests := []func(context.Context) error{
+		func(ctx context.Context) error {
+			_, err := mutationTopic(feed, kv)
+			return err
+		},
+		func(ctx context.Context) error {
+			req := protobuf.NewRestartVbucketsRequest(testTopic).Append(ts)
+			_, err := feed.RestartVbuckets(ctx, req)
+			return err
+		},
+		func(ctx context.Context) error {
+			req := protobuf.NewShutdownVbucketsRequest(testTopic).Append(ts)
+			return feed.ShutdownVbuckets(ctx, req)
+		},
+		feed.Shutdown,
+	}
+	errch := make(chan error, len(requests))
+	for _, request := range requests {
+		go func(request func(context.Context) error) {
+			ctx, cancel := testContext()
+			defer cancel()
+			errch <- request(ctx)
+		}(request)
+	}
+	return errch
+}
+
+func TestFeedShutdownInflight(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		config.SetValue("feedWaitStreamEndTimeout", 500)
+	})
+	if _, err := mutationTopic(feed, kv); err != nil {
+		t.Fatal(err)
+	}
+
+	// keep gen-server busy waiting for a StreamEnd that never arrives,
+	// so that the requests below are queued behind shutdown.
+	kv.RespondStreamEnd("default", 3, feedtest.Response{Drop: true})
+	ts := kv.Timestamp("default", "default").SelectByVbuckets([]uint16{3})
+	go func() {
+		ctx, cancel := testContext()
+		defer cancel()
+		req := protobuf.NewShutdownVbucketsRequest(testTopic).Append(ts)
+		feed.ShutdownVbuckets(ctx, req)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	shutch := make(chan error, 1)
+	go func() {
+		ctx, cancel := testContext()
+		defer cancel()
+		shutch <- feed.Shutdown(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	checkClosed := func(errch <-chan error) {
+		for i := 0; i < cap(errch); i++ {
+			select {
+			case err := <-errch:
+				if err != projC.ErrorFeedClosed {
+					t.Errorf("expected %v, got %v", projC.ErrorFeedClosed, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("request did not return after shutdown")
+			}
+		}
+	}
+	inflight := feedRequests(feed, kv)
+	select {
+	case err := <-shutch:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("shutdown did not return")
+	}
+	checkClosed(inflight)
+
+	// requests after shutdown are failed without waiting on gen-server.
+	checkClosed(feedRequests(feed, kv))
+}
+
+func TestFeedRollback(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		rollback := feedtest.Response{Status: mcd.ROLLBACK, Seqno: 10}
+		kv.RespondStreamRequest("default", 2, rollback)
+	})
+	defer shutdownFeed(t, feed)
+
+	resp, err := mutationTopic(feed, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, []uint16{0, 1, 3}) {
+		t.Fatalf("unexpected active vbuckets %v", vbnos)
+	}
+	rollTss := resp.GetRollbackTimestamps()
+	if len(rollTss) != 1 {
+		t.Fatalf("expected rollback timestamp, got %v", rollTss)
+	}
+	if seqno, err := rollTss[0].SeqnoFor(2); err != nil || seqno != 10 {
+		t.Fatalf("expected rollback to seqno 10, got %v %v", seqno, err)
+	}
+}
+
+func TestFeedEvents(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		rollback := feedtest.Response{Status: mcd.ROLLBACK, Seqno: 10}
+		kv.RespondStreamRequest("default", 2, rollback)
+	})
+	if _, err := mutationTopic(feed, kv); err != nil {
+		t.Fatal(err)
+	}
+	shutdownFeed(t, feed)
+
+	details := make(map[string]string)
+	for _, ev := range feed.Events("default") {
+		details[ev.Event] = ev.Detail
+	}
+	if details["streamBegin"] != "vbnos [0-1 3]" {
+		t.Fatalf("unexpected stream begin event %q", details["streamBegin"])
+	} else if details["rollback"] != "vbnos [2]" {
+		t.Fatalf("unexpected rollback event %q", details["rollback"])
+	} else if _, ok := details["shutdown"]; !ok {
+		t.Fatalf("expected shutdown event, got %v", details)
+	}
+	// events of other buckets are filtered, not those of the topic.
+	for _, ev := range feed.Events("other") {
+		if ev.Bucket != "" {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	}
+}
+
+func TestFeedNotMyVbucket(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		kv.RespondStreamRequest(
+			"default", 1, feedtest.Response{Status: mcd.NOT_MY_VBUCKET})
+	})
+	defer shutdownFeed(t, feed)
+
+	resp, err := mutationTopic(feed, kv)
+	if err != projC.ErrorNotMyVbucket {
+		t.Fatalf("expected %v, got %v", projC.ErrorNotMyVbucket, err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, []uint16{0, 2, 3}) {
+		t.Fatalf("unexpected active vbuckets %v", vbnos)
+	}
+}
+
+func TestFeedRetryNotMyVbucket(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		kv.RespondStreamRequest(
+			"default", 1, feedtest.Response{Status: mcd.NOT_MY_VBUCKET})
+		config.SetValue("feedRetryInterval", 300)
+		config.SetValue("feedRetryBudget", 10*1000)
+	})
+	defer shutdownFeed(t, feed)
+
+	begin := time.Now()
+	resp, err := mutationTopic(feed, kv)
+	if err != projC.ErrorNotMyVbucket {
+		t.Fatalf("expected %v, got %v", projC.ErrorNotMyVbucket, err)
+	}
+	// request does not wait for the backoff of failed vbuckets.
+	if elapsed := time.Since(begin); elapsed >= 300*time.Millisecond {
+		t.Fatalf("expected request to return before backoff, took %v", elapsed)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, []uint16{0, 2, 3}) {
+		t.Fatalf("unexpected active vbuckets %v", vbnos)
+	}
+
+	// failed vbucket is re-requested in background.
+	waitActiveVbnos(t, feed, testVbnos)
+	if n := kv.StreamRequests("default", 1); n != 2 {
+		t.Fatalf("expected 2 stream requests for vbucket 1, got %v", n)
+	}
+}
+
+func TestFeedRetryExhausted(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		failed := feedtest.Response{Status: mcd.NOT_MY_VBUCKET}
+		kv.RespondStreamRequest("default", 1,
+			failed, failed, failed, failed, failed, failed, failed, failed)
+		config.SetValue("feedRetryInterval", 10)
+		config.SetValue("feedRetryMaxInterval", 20)
+		config.SetValue("feedRetryBudget", 100)
+	})
+	defer shutdownFeed(t, feed)
+
+	if _, err := mutationTopic(feed, kv); err != projC.ErrorNotMyVbucket {
+		t.Fatalf("expected %v, got %v", projC.ErrorNotMyVbucket, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, ev := range feed.Events("default") {
+			if ev.Event == "streamRequestFailed" &&
+				ev.Detail == "vbnos [1]: retries exhausted" {
+
+				if n := kv.StreamRequests("default", 1); n < 2 {
+					t.Fatalf("expected vbucket 1 to be retried, got %v requests", n)
+				}
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("expected retries for vbucket 1 to be exhausted")
+}
+
+func TestFeedRetryTimeout(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		kv.RespondStreamRequest("default", 1,
+			feedtest.Response{Status: mcd.NOT_MY_VBUCKET},
+			feedtest.Response{Drop: true})
+		config.SetValue("feedRetryInterval", 10)
+		config.SetValue("feedRetryMaxInterval", 20)
+		config.SetValue("feedRetryBudget", 10*1000)
+	})
+	defer shutdownFeed(t, feed)
+
+	if _, err := mutationTopic(feed, kv); err != projC.ErrorNotMyVbucket {
+		t.Fatalf("expected %v, got %v", projC.ErrorNotMyVbucket, err)
+	}
+	// feed is not blocked while the retry awaits its response.
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := testContext()
+	defer cancel()
+	begin := time.Now()
+	feed.GetTopicResponse(ctx)
+	if elapsed := time.Since(begin); elapsed >= 100*time.Millisecond {
+		t.Fatalf("expected feed to respond while retrying, took %v", elapsed)
+	}
+
+	// dropped retry is re-requested once it times out.
+	waitActiveVbnos(t, feed, testVbnos)
+	if n := kv.StreamRequests("default", 1); n != 3 {
+		t.Fatalf("expected 3 stream requests for vbucket 1, got %v", n)
+	}
+}
+
+func TestFeedStreamRequestTimeout(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		kv.RespondStreamRequest("default", 3, feedtest.Response{Drop: true})
+	})
+	defer shutdownFeed(t, feed)
+
+	resp, err := mutationTopic(feed, kv)
+	if err != projC.ErrorResponseTimeout {
+		t.Fatalf("expected %v, got %v", projC.ErrorResponseTimeout, err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, []uint16{0, 1, 2}) {
+		t.Fatalf("unexpected active vbuckets %v", vbnos)
+	}
+}
+
+func TestFeedStreamRequestBatches(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		config.SetValue("feedStreamReqBatchSize", 3)
+		config.SetValue("feedStreamReqBatchInterval", 10)
+	})
+	defer shutdownFeed(t, feed)
+
+	resp, err := mutationTopic(feed, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, testVbnos) {
+		t.Fatalf("expected active vbuckets %v, got %v", testVbnos, vbnos)
+	}
+	batches := kv.StreamRequestBatches("default")
+	if ref := [][]uint16{{0, 1, 2}, {3}}; !reflect.DeepEqual(batches, ref) {
+		t.Fatalf("expected stream request batches %v, got %v", ref, batches)
+	}
+}
+
+func TestFeedStartupTimings(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, nil)
+	defer shutdownFeed(t, feed)
+
+	resp, err := mutationTopic(feed, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timings := resp.GetTimings()
+	if len(timings) != 1 || timings[0].GetBucket() != "default" {
+		t.Fatalf("expected timings for bucket default, got %v", timings)
+	}
+	tm := timings[0]
+	if tm.VbmapTime == nil || tm.FailoverLogTime == nil ||
+		tm.StreamRequestTime == nil || tm.FeedbackTime == nil {
+		t.Fatalf("expected every startup phase to be timed, got %v", tm)
+	}
+	if tm.GetFeedbackTime() <= 0 {
+		t.Fatalf("expected time waiting for feedback, got %v", tm)
+	}
+}
+
+func TestFeedStreamRequestBatchTimeout(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		kv.RespondStreamRequest("default", 1, feedtest.Response{Drop: true})
+		config.SetValue("feedStreamReqBatchSize", 2)
+		config.SetValue("feedStreamReqBatchInterval", 10)
+	})
+	defer shutdownFeed(t, feed)
+
+	resp, err := mutationTopic(feed, kv)
+	if err != projC.ErrorResponseTimeout {
+		t.Fatalf("expected %v, got %v", projC.ErrorResponseTimeout, err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, []uint16{0}) {
+		t.Fatalf("unexpected active vbuckets %v", vbnos)
+	}
+	// batch after the timed out batch is not requested.
+	batches := kv.StreamRequestBatches("default")
+	if ref := [][]uint16{{0, 1}}; !reflect.DeepEqual(batches, ref) {
+		t.Fatalf("expected stream request batches %v, got %v", ref, batches)
+	}
+}
+
+func TestFeedStreamRequestBatchesPaced(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		config.SetValue("feedStreamReqBatchSize", 1)
+		config.SetValue("feedStreamReqBatchInterval", 100)
+	})
+	defer shutdownFeed(t, feed)
+
+	type result struct {
+		resp *protobuf.TopicResponse
+		err  error
+	}
+	resultch := make(chan result, 1)
+	go func() {
+		resp, err := mutationTopic(feed, kv)
+		resultch <- result{resp, err}
+	}()
+
+	// feed is not blocked while batches are paced.
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := testContext()
+	defer cancel()
+	begin := time.Now()
+	feed.GetTopicResponse(ctx)
+	if elapsed := time.Since(begin); elapsed >= 100*time.Millisecond {
+		t.Fatalf("expected feed to respond while pacing, took %v", elapsed)
+	}
+
+	// response waits for every batch.
+	res := <-resultch
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if vbnos := activeVbnos(res.resp, "default"); !reflect.DeepEqual(vbnos, testVbnos) {
+		t.Fatalf("expected active vbuckets %v, got %v", testVbnos, vbnos)
+	}
+	batches := kv.StreamRequestBatches("default")
+	if ref := [][]uint16{{0}, {1}, {2}, {3}}; !reflect.DeepEqual(batches, ref) {
+		t.Fatalf("expected stream request batches %v, got %v", ref, batches)
+	}
+}
+
+func TestFeedStreamRequestLaterBatchTimeout(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		kv.RespondStreamRequest("default", 3, feedtest.Response{Drop: true})
+		config.SetValue("feedStreamReqBatchSize", 2)
+		config.SetValue("feedStreamReqBatchInterval", 10)
+	})
+	defer shutdownFeed(t, feed)
+
+	resp, err := mutationTopic(feed, kv)
+	if err != projC.ErrorResponseTimeout {
+		t.Fatalf("expected %v, got %v", projC.ErrorResponseTimeout, err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, []uint16{0, 1, 2}) {
+		t.Fatalf("unexpected active vbuckets %v", vbnos)
+	}
+	batches := kv.StreamRequestBatches("default")
+	if ref := [][]uint16{{0, 1}, {2, 3}}; !reflect.DeepEqual(batches, ref) {
+		t.Fatalf("expected stream request batches %v, got %v", ref, batches)
+	}
+}
+
+func TestFeedUpstreamError(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		kv.SetError(errors.New("connection refused"))
+	})
+	defer shutdownFeed(t, feed)
+
+	if _, err := mutationTopic(feed, kv); err == nil {
+		t.Fatalf("expected error when KV is not reachable")
+	}
+	if feeders := kv.Feeders("default"); len(feeders) != 0 {
+		t.Fatalf("expected no upstream, got %v", len(feeders))
+	}
+}
+
+func TestFeedBucketUUIDChanged(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, nil)
+	defer shutdownFeed(t, feed)
+
+	if _, err := mutationTopic(feed, kv); err != nil {
+		t.Fatal(err)
+	}
+
+	// bucket is flushed, restarting its vbuckets fails.
+	kv.SetUUID("default", "uuid2")
+	ctx, cancel := testContext()
+	defer cancel()
+	ts := kv.Timestamp("default", "default").SelectByVbuckets([]uint16{0})
+	shutReq := protobuf.NewShutdownVbucketsRequest(testTopic).Append(ts)
+	if err := feed.ShutdownVbuckets(ctx, shutReq); err != nil {
+		t.Fatal(err)
+	}
+	restartReq := protobuf.NewRestartVbucketsRequest(testTopic).Append(ts)
+	if _, err := feed.RestartVbuckets(ctx, restartReq); err != projC.ErrorBucketUUIDChanged {
+		t.Fatalf("expected %v, got %v", projC.ErrorBucketUUIDChanged, err)
+	}
+	stale := feed.GetTopicResponse(ctx).GetStaleBuckets()
+	if !reflect.DeepEqual(stale, []string{"default"}) {
+		t.Fatalf("expected stale bucket, got %v", stale)
+	}
+}
+
+func TestFeedIndexBucketUUID(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, nil)
+	defer shutdownFeed(t, feed)
+
+	// indexes defined before the bucket was recreated.
+	instances := protobuf.ExampleIndexInstances(
+		[]string{"default"}, []string{testRaddr}, "")
+	for _, instance := range instances {
+		ii := instance.GetIndexInstance()
+		defn := *ii.GetDefinition()
+		defn.BucketUUID = proto.String("uuid0")
+		ii.Definition = &defn
+	}
+	req := protobuf.NewMutationTopicRequest(testTopic, "dataport", instances)
+	req.Append(kv.Timestamp("default", "default"))
+	ctx, cancel := testContext()
+	defer cancel()
+	if _, err := feed.MutationTopic(ctx, req); err != projC.ErrorBucketUUIDChanged {
+		t.Fatalf("expected %v, got %v", projC.ErrorBucketUUIDChanged, err)
+	}
+	if feeders := kv.Feeders("default"); len(feeders) != 0 {
+		t.Fatalf("expected no upstream, got %v", len(feeders))
+	}
+}
+
+func TestFeedProbe(t *testing.T) {
+	feed, kv, eps := startTestFeed(t, nil)
+	defer shutdownFeed(t, feed)
+
+	ctx, cancel := testContext()
+	defer cancel()
+	req := protobuf.NewProbeRequest(
+		testTopic, "dataport", "default", "default", testRaddr, nil)
+	resp, err := feed.Probe(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vbnos := c.Vbno32to16(resp.GetVbnos())
+	if resp.GetBucketUUID() != "uuid1" || !reflect.DeepEqual(vbnos, testVbnos) {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	feeders := kv.Feeders("default")
+	if len(feeders) != 1 || !feeders[0].IsClosed() {
+		t.Errorf("expected upstream to be opened and closed")
+	}
+	if endpoint := eps.Get(testRaddr); endpoint == nil || !endpoint.IsClosed() {
+		t.Errorf("expected endpoint to be started and closed")
+	}
+
+	kv.SetError(errors.New("connection refused"))
+	req = protobuf.NewProbeRequest(
+		testTopic, "dataport", "default", "default", testRaddr, testVbnos)
+	if _, err := feed.Probe(ctx, req); !c.IsError(err, projC.ErrorFeeder) {
+		t.Errorf("expected %v, got %v", projC.ErrorFeeder, err)
+	}
+}
+
+func TestFeedShutdownRestartVbuckets(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, nil)
+	defer shutdownFeed(t, feed)
+
+	if _, err := mutationTopic(feed, kv); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := kv.Timestamp("default", "default").SelectByVbuckets([]uint16{0, 1})
+	ctx, cancel := testContext()
+	defer cancel()
+
+	shutReq := protobuf.NewShutdownVbucketsRequest(testTopic).Append(ts)
+	if err := feed.ShutdownVbuckets(ctx, shutReq); err != nil {
+		t.Fatal(err)
+	}
+	resp := feed.GetTopicResponse(ctx)
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, []uint16{2, 3}) {
+		t.Fatalf("unexpected active vbuckets %v after shutdown", vbnos)
+	}
+
+	restartReq := protobuf.NewRestartVbucketsRequest(testTopic).Append(ts)
+	resp, err := feed.RestartVbuckets(ctx, restartReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, testVbnos) {
+		t.Fatalf("expected active vbuckets %v, got %v", testVbnos, vbnos)
+	}
+	if n := kv.StreamRequests("default", 0); n != 2 {
+		t.Fatalf("expected 2 stream requests for vbucket 0, got %v", n)
+	}
+	restartTss := resp.GetRestartTimestamps()
+	if len(restartTss) != 1 {
+		t.Fatalf("expected restart timestamp, got %v", restartTss)
+	}
+	if vbnos := c.Vbno32to16(restartTss[0].GetVbnos()); !reflect.DeepEqual(vbnos, []uint16{0, 1}) {
+		t.Fatalf("unexpected restarted vbuckets %v", vbnos)
+	}
+}
+
+func TestFeedAddBucketsActiveVbuckets(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, nil)
+	defer shutdownFeed(t, feed)
+
+	if _, err := mutationTopic(feed, kv); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := testContext()
+	defer cancel()
+	shutTs := kv.Timestamp("default", "default").SelectByVbuckets([]uint16{0, 1})
+	shutReq := protobuf.NewShutdownVbucketsRequest(testTopic).Append(shutTs)
+	if err := feed.ShutdownVbuckets(ctx, shutReq); err != nil {
+		t.Fatal(err)
+	}
+
+	// vbuckets 2, 3 are still active and shall not be re-requested.
+	instances := protobuf.ExampleIndexInstances(
+		[]string{"default"}, []string{testRaddr}, "")
+	req := protobuf.NewAddBucketsRequest(testTopic, instances)
+	req.ReqTimestamps = append(
+		req.ReqTimestamps, kv.Timestamp("default", "default"))
+	resp, err := feed.AddBuckets(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, testVbnos) {
+		t.Fatalf("expected active vbuckets %v, got %v", testVbnos, vbnos)
+	}
+	for vbno, ref := range []int{2, 2, 1, 1} {
+		if n := kv.StreamRequests("default", uint16(vbno)); n != ref {
+			t.Errorf("expected %v stream requests for vbucket %v, got %v",
+				ref, vbno, n)
+		}
+	}
+}
+
+func TestFeedTopicOperationsBucketErrors(t *testing.T) {
+	feed, kv, _ := startTestFeed(t, func(kv *feedtest.KV, config c.Config) {
+		kv.AddBucket("projects", "uuid2", testVbnos)
+	})
+	defer shutdownFeed(t, feed)
+
+	if _, err := mutationTopic(feed, kv); err != nil {
+		t.Fatal(err)
+	}
+
+	// beer-sample is not hosted by KV, projects is added regardless.
+	instances := protobuf.ExampleIndexInstances(
+		[]string{"projects", "beer-sample"}, []string{testRaddr}, "")
+	missingTs := protobuf.NewTsVbuuid("default", "beer-sample", 1)
+	missingTs.Append(0, 0, 0x1, 0, 0)
+	addReq := protobuf.NewAddBucketsRequest(testTopic, instances)
+	addReq.ReqTimestamps = append(
+		addReq.ReqTimestamps, kv.Timestamp("default", "projects"), missingTs)
+	req := protobuf.NewTopicOperationsRequest(testTopic).
+		SetAddBuckets(addReq).
+		SetAddInstances(protobuf.NewAddInstancesRequest(testTopic, instances))
+
+	ctx, cancel := testContext()
+	defer cancel()
+	resp, err := feed.TopicOperations(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetAddBuckets().ToError() == nil {
+		t.Fatalf("expected addBuckets to fail")
+	} else if resp.GetAddInstances().ToError() == nil {
+		t.Fatalf("expected addInstances to fail")
+	}
+	for _, op := range []string{"addBuckets", "addInstances"} {
+		failed := resp.FailedBuckets(op)
+		if _, ok := failed["beer-sample"]; !ok || len(failed) != 1 {
+			t.Fatalf("expected %v to fail only for beer-sample, got %v", op, failed)
+		}
+	}
+	if failed := resp.FailedBuckets("restartVbuckets"); len(failed) != 0 {
+		t.Fatalf("unexpected restartVbuckets failures %v", failed)
+	}
+	// operations are not rolled back for buckets that succeeded.
+	topicResp := resp.GetResponse()
+	if vbnos := activeVbnos(topicResp, "projects"); !reflect.DeepEqual(vbnos, testVbnos) {
+		t.Fatalf("expected active vbuckets %v, got %v", testVbnos, vbnos)
+	}
+	if vbnos := activeVbnos(topicResp, "default"); !reflect.DeepEqual(vbnos, testVbnos) {
+		t.Fatalf("expected active vbuckets %v, got %v", testVbnos, vbnos)
+	}
+}
+
+func TestFeedRegistrationToken(t *testing.T) {
+	feed, kv, eps := startTestFeed(t, nil)
+	defer shutdownFeed(t, feed)
+
+	instances := protobuf.ExampleIndexInstances(
+		[]string{"default"}, []string{testRaddr}, "")
+	req := protobuf.NewMutationTopicRequest(testTopic, "dataport", instances)
+	req.Append(kv.Timestamp("default", "default")).SetToken("token1")
+
+	ctx, cancel := testContext()
+	defer cancel()
+	resp, err := feed.MutationTopic(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := resp.GetToken(); token != "token1" {
+		t.Fatalf("expected token acknowledged, got %q", token)
+	}
+	if token := eps.Get(testRaddr).Token(); token != "token1" {
+		t.Fatalf("expected endpoint framed with token1, got %q", token)
+	}
+
+	// restart carries the token issued for a newer stream.
+	ts := kv.Timestamp("default", "default").SelectByVbuckets([]uint16{0})
+	shutReq := protobuf.NewShutdownVbucketsRequest(testTopic).Append(ts)
+	if err := feed.ShutdownVbuckets(ctx, shutReq); err != nil {
+		t.Fatal(err)
+	}
+	restartReq := protobuf.NewRestartVbucketsRequest(testTopic).Append(ts)
+	resp, err = feed.RestartVbuckets(ctx, restartReq.SetToken("token2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := resp.GetToken(); token != "token2" {
+		t.Fatalf("expected token acknowledged, got %q", token)
+	}
+}
+
+func startUprFeed(t *testing.T) (
+	*projector.Feed, *feedtest.UprKV, *mcs.UprServer, *feedtest.Endpoints) {
+
+	kv, eps := feedtest.NewUprKV(), feedtest.NewEndpoints()
+	server, err := kv.AddBucket("default", "uuid1", testVbnos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := feedtest.FeedConfig(kv, eps, len(testVbnos), 1000)
+	feed, err := projector.NewFeed(testTopic, config)
+	if err != nil {
+		kv.Close()
+		t.Fatal(err)
+	}
+	return feed, kv, server, eps
+}
+
+// waitUpsert waits for the mutation on `docid` to reach endpoint.
+func waitUpsert(
+	t *testing.T, eps *feedtest.Endpoints, vbno uint16, docid string) {
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if endpoint := eps.Get(testRaddr); endpoint != nil {
+			for _, data := range endpoint.Data() {
+				dkv, ok := data.(*c.DataportKeyVersions)
+				if ok && dkv.Vbno == vbno && string(dkv.Kv.Docid) == docid {
+					return
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("mutation %v on vbucket %v did not reach endpoint", docid, vbno)
+}
+
+func TestFeedUprMutations(t *testing.T) {
+	feed, kv, server, eps := startUprFeed(t)
+	defer kv.Close()
+	defer shutdownFeed(t, feed)
+
+	doc := []byte(`{"age": 40, "first-name": "x", "city": "y", "gender": "f"}`)
+	server.Mutation(0, []byte("backfill"), doc)
+
+	resp, err := mutationTopic(feed, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, testVbnos) {
+		t.Fatalf("expected active vbuckets %v, got %v", testVbnos, vbnos)
+	}
+	waitUpsert(t, eps, 0, "backfill")
+
+	server.Mutation(3, []byte("live"), doc)
+	waitUpsert(t, eps, 3, "live")
+
+	ctx, cancel := testContext()
+	defer cancel()
+	resources := feed.GetStatistics(ctx).Get("resources").(map[string]interface{})
+	if events := resources["events"].(float64); events < 2 {
+		t.Errorf("expected events accounted to topic, got %v", resources)
+	}
+	if busy := resources["busyTime"].(float64); busy <= 0 {
+		t.Errorf("expected busy time accounted to topic, got %v", resources)
+	}
+}
+
+func TestFeedUprNamespace(t *testing.T) {
+	feed, kv, server, eps := startUprFeed(t)
+	defer kv.Close()
+	defer shutdownFeed(t, feed)
+
+	instances := protobuf.ExampleIndexInstances(
+		[]string{"default"}, []string{testRaddr}, "")
+	for _, instance := range instances {
+		instance.GetIndexInstance().SetNamespace("user::")
+	}
+	req := protobuf.NewMutationTopicRequest(testTopic, "dataport", instances)
+	req.Append(kv.Timestamp("default", "default"))
+	ctx, cancel := testContext()
+	defer cancel()
+	if _, err := feed.MutationTopic(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := []byte(`{"age": 40, "first-name": "x", "city": "y", "gender": "f"}`)
+	server.Mutation(0, []byte("order::1"), doc)
+	server.Mutation(0, []byte("user::1"), doc)
+	waitUpsert(t, eps, 0, "user::1")
+	for _, data := range eps.Get(testRaddr).Data() {
+		dkv, ok := data.(*c.DataportKeyVersions)
+		if ok && dkv.Kv != nil && string(dkv.Kv.Docid) == "order::1" {
+			t.Fatalf("document outside namespace reached endpoint")
+		}
+	}
+
+	stats := feed.GetStatistics(ctx)
+	nsEngines := stats.Get("namespaceEngines").(map[string]interface{})
+	ref := map[string]interface{}{"user::": float64(len(instances))}
+	if engines := nsEngines["default"]; !reflect.DeepEqual(engines, ref) {
+		t.Errorf("expected namespace engines %v, got %v", ref, engines)
+	}
+}
+
+func TestFeedUprRollback(t *testing.T) {
+	feed, kv, server, _ := startUprFeed(t)
+	defer kv.Close()
+	defer shutdownFeed(t, feed)
+
+	// vbucket 2 fails over at seqno 5 while the indexer is at seqno 7.
+	for i := 0; i < 7; i++ {
+		if i == 5 {
+			server.Failover(2, 2000)
+		}
+		server.Mutation(2, []byte("key"), []byte(`{}`))
+	}
+	reqTs := protobuf.NewTsVbuuid("default", "default", len(testVbnos))
+	for _, vbno := range testVbnos {
+		if vbno == 2 {
+			reqTs.Append(vbno, 7, 1002, 7, 7)
+		} else {
+			reqTs.Append(vbno, 0, uint64(vbno)+1000, 0, 0)
+		}
+	}
+
+	resp, err := mutationTopicAt(feed, reqTs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, []uint16{0, 1, 3}) {
+		t.Fatalf("unexpected active vbuckets %v", vbnos)
+	}
+	rollTss := resp.GetRollbackTimestamps()
+	if len(rollTss) != 1 {
+		t.Fatalf("expected rollback timestamp, got %v", rollTss)
+	}
+	if seqno, err := rollTss[0].SeqnoFor(2); err != nil || seqno != 5 {
+		t.Fatalf("expected rollback to seqno 5, got %v %v", seqno, err)
+	}
+}
+
+func TestFeedUprNotMyVbucket(t *testing.T) {
+	feed, kv, server, _ := startUprFeed(t)
+	defer kv.Close()
+	defer shutdownFeed(t, feed)
+
+	server.RespondStreamRequest(1, mcs.UprResponse{Status: mcd.NOT_MY_VBUCKET})
+	resp, err := mutationTopic(feed, kv)
+	if err != projC.ErrorNotMyVbucket {
+		t.Fatalf("expected %v, got %v", projC.ErrorNotMyVbucket, err)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, []uint16{0, 2, 3}) {
+		t.Fatalf("unexpected active vbuckets %v", vbnos)
+	}
+}
+
+func TestFeedUprConnectionReset(t *testing.T) {
+	feed, kv, server, _ := startUprFeed(t)
+	defer kv.Close()
+	defer shutdownFeed(t, feed)
+
+	if _, err := mutationTopic(feed, kv); err != nil {
+		t.Fatal(err)
+	}
+	server.DropConnections()
+
+	ctx, cancel := testContext()
+	defer cancel()
+	for {
+		resp := feed.GetTopicResponse(ctx)
+		if len(activeVbnos(resp, "default")) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("vbuckets still active after upstream reset")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestFeedUprDcpStatistics(t *testing.T) {
+	feed, kv, server, eps := startUprFeed(t)
+	defer kv.Close()
+	defer shutdownFeed(t, feed)
+
+	if _, err := mutationTopic(feed, kv); err != nil {
+		t.Fatal(err)
+	}
+	doc := []byte(`{"age": 40, "first-name": "x", "city": "y", "gender": "f"}`)
+	server.Mutation(1, []byte("live"), doc)
+	waitUpsert(t, eps, 1, "live")
+
+	ctx, cancel := testContext()
+	defer cancel()
+	dcpStats := feed.GetStatistics(ctx).Get("dcp-default").(c.Statistics)
+	if n := dcpStats.Get("mutations").(float64); n < 1 {
+		t.Errorf("expected mutations received from DCP, got %v", dcpStats)
+	} else if n := dcpStats.Get("bytes").(float64); n <= 0 {
+		t.Errorf("expected bytes received from DCP, got %v", dcpStats)
+	} else if n := dcpStats.Get("connResets").(float64); n != 0 {
+		t.Errorf("expected no connection resets, got %v", n)
+	}
+	streams := dcpStats.Get("streams").(map[string]interface{})
+	for _, vbno := range testVbnos {
+		if state := streams[strconv.Itoa(int(vbno))]; state != "active" {
+			t.Errorf("expected vbucket %v to be active, got %v", vbno, state)
+		}
+	}
+
+	// connection resets are retained after the bucket is done.
+	server.DropConnections()
+	isActive := func(streams map[string]interface{}) bool {
+		for _, state := range streams {
+			if state == "active" {
+				return true
+			}
+		}
+		return false
+	}
+	for {
+		dcpStats = feed.GetStatistics(ctx).Get("dcp-default").(c.Statistics)
+		streams = dcpStats.Get("streams").(map[string]interface{})
+		if dcpStats.Get("connResets").(float64) == 1 && !isActive(streams) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("connection reset not accounted, got %v", dcpStats)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestFeedUprRestartPoints(t *testing.T) {
+	feed, kv, server, eps := startUprFeed(t)
+	defer kv.Close()
+	defer shutdownFeed(t, feed)
+
+	for i := 0; i < 3; i++ {
+		server.Mutation(0, []byte("key"), []byte(`{}`))
+	}
+	if _, err := mutationTopic(feed, kv); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := testContext()
+	defer cancel()
+	ts := kv.Timestamp("default", "default").SelectByVbuckets([]uint16{0})
+	shutReq := protobuf.NewShutdownVbucketsRequest(testTopic).Append(ts)
+	if err := feed.ShutdownVbuckets(ctx, shutReq); err != nil {
+		t.Fatal(err)
+	}
+
+	// vbucket 0 resumes from seqno 3, vbucket 2 is still streaming.
+	restartTs := protobuf.NewTsVbuuid("default", "default", len(testVbnos))
+	restartTs.Append(0, 3, 1000, 3, 3)
+	restartTs.Append(2, 0, 1002, 0, 0)
+	restartReq := protobuf.NewRestartVbucketsRequest(testTopic).Append(restartTs)
+	resp, err := feed.RestartVbuckets(ctx, restartReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restartTss := resp.GetRestartTimestamps()
+	if len(restartTss) != 1 {
+		t.Fatalf("expected restart timestamp, got %v", restartTss)
+	}
+	if vbnos := c.Vbno32to16(restartTss[0].GetVbnos()); !reflect.DeepEqual(vbnos, []uint16{0}) {
+		t.Fatalf("unexpected restarted vbuckets %v", vbnos)
+	}
+	if seqno, err := restartTss[0].SeqnoFor(0); err != nil || seqno != 3 {
+		t.Fatalf("expected vbucket 0 to resume from 3, got %v %v", seqno, err)
+	}
+	if n := server.StreamRequests(2); n != 1 {
+		t.Fatalf("expected 1 stream request for vbucket 2, got %v", n)
+	}
+
+	doc := []byte(`{"age": 40, "first-name": "x", "city": "y", "gender": "f"}`)
+	server.Mutation(0, []byte("resumed"), doc)
+	waitUpsert(t, eps, 0, "resumed")
+}
+
+func TestFeedUprCatchup(t *testing.T) {
+	feed, kv, server, eps := startUprFeed(t)
+	defer kv.Close()
+	defer shutdownFeed(t, feed)
+
+	doc := []byte(`{"age": 40, "first-name": "x", "city": "y", "gender": "f"}`)
+	for i := 0; i < 5; i++ {
+		server.Mutation(0, []byte("key"+strconv.Itoa(i)), doc)
+	}
+	// vbucket 0 catches up to seqno 3, others are already at their end.
+	restartTs := kv.Timestamp("default", "default")
+	endTs := protobuf.NewTsVbuuid("default", "default", len(testVbnos))
+	for _, vbno := range testVbnos {
+		seqno := uint64(0)
+		if vbno == 0 {
+			seqno = 3
+		}
+		endTs.Append(vbno, seqno, uint64(vbno)+1000, seqno, seqno)
+	}
+	instances := protobuf.ExampleIndexInstances(
+		[]string{"default"}, []string{testRaddr}, "")
+	req := protobuf.NewCatchupTopicRequest(testTopic, "dataport", instances)
+	req.Append(restartTs, endTs)
+
+	ctx, cancel := testContext()
+	defer cancel()
+	if _, err := feed.CatchupTopic(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		resp := feed.GetTopicResponse(ctx)
+		catchupTss := resp.GetCatchupTimestamps()
+		if len(activeVbnos(resp, "default")) == 0 && len(catchupTss) == 1 &&
+			reflect.DeepEqual(c.Vbno32to16(catchupTss[0].GetVbnos()), testVbnos) {
+
+			if seqno, err := catchupTss[0].SeqnoFor(0); err != nil || seqno != 3 {
+				t.Fatalf("expected vbucket 0 to end at 3, got %v %v", seqno, err)
+			}
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("vbuckets did not complete catchup, %v", resp)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	streamEnd := false
+	for _, data := range eps.Get(testRaddr).Data() {
+		dkv, ok := data.(*c.DataportKeyVersions)
+		if !ok || dkv.Vbno != 0 {
+			continue
+		} else if dkv.Kv.Seqno > 3 {
+			t.Fatalf("unexpected mutation beyond end seqno %v", dkv.Kv.Seqno)
+		}
+		for _, cmd := range dkv.Kv.Commands {
+			streamEnd = streamEnd || cmd == c.StreamEnd
+		}
+	}
+	if !streamEnd {
+		t.Fatalf("expected StreamEnd for vbucket 0")
+	}
+}
+
+func TestFeedUprDisableInstances(t *testing.T) {
+	feed, kv, server, eps := startUprFeed(t)
+	defer kv.Close()
+	defer shutdownFeed(t, feed)
+
+	resp, err := mutationTopic(feed, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uuids := resp.GetInstanceIds()
+
+	ctx, cancel := testContext()
+	defer cancel()
+	disableReq := protobuf.NewDisableInstancesRequest(testTopic, uuids)
+	if err := feed.DisableInstances(ctx, disableReq); err != nil {
+		t.Fatal(err)
+	}
+	resp = feed.GetTopicResponse(ctx)
+	if !reflect.DeepEqual(resp.GetDisabledInstanceIds(), uuids) {
+		t.Fatalf("expected disabled instances %v, got %v", uuids, resp)
+	}
+	if !reflect.DeepEqual(resp.GetInstanceIds(), uuids) {
+		t.Fatalf("expected instances %v to be retained, got %v", uuids, resp)
+	}
+
+	doc := []byte(`{"age": 40, "first-name": "x", "city": "y", "gender": "f"}`)
+	server.Mutation(1, []byte("disabled"), doc)
+	time.Sleep(100 * time.Millisecond)
+	enableReq := protobuf.NewEnableInstancesRequest(testTopic, uuids)
+	if err := feed.EnableInstances(ctx, enableReq); err != nil {
+		t.Fatal(err)
+	}
+	server.Mutation(2, []byte("enabled"), doc)
+	waitUpsert(t, eps, 2, "enabled")
+	for _, data := range eps.Get(testRaddr).Data() {
+		dkv, ok := data.(*c.DataportKeyVersions)
+		if ok && string(dkv.Kv.Docid) == "disabled" {
+			t.Fatalf("unexpected mutation routed to disabled instance")
+		}
+	}
+	if resp = feed.GetTopicResponse(ctx); len(resp.GetDisabledInstanceIds()) != 0 {
+		t.Fatalf("expected no disabled instances, got %v", resp)
+	}
+
+	disableReq = protobuf.NewDisableInstancesRequest(testTopic, []uint64{0xdead})
+	err = feed.DisableInstances(ctx, disableReq)
+	if !c.IsError(err, projC.ErrorInvalidInstance) {
+		t.Fatalf("expected %v, got %v", projC.ErrorInvalidInstance, err)
+	}
+}
+
+func TestFeedUprPauseTopic(t *testing.T) {
+	feed, kv, server, eps := startUprFeed(t)
+	defer kv.Close()
+	defer shutdownFeed(t, feed)
+
+	if _, err := mutationTopic(feed, kv); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := testContext()
+	defer cancel()
+	if err := feed.PauseTopic(ctx, protobuf.NewPauseTopicRequest(testTopic)); err != nil {
+		t.Fatal(err)
+	}
+	resp := feed.GetTopicResponse(ctx)
+	if !resp.GetPaused() {
+		t.Fatalf("expected topic to be paused, got %v", resp)
+	}
+
+	doc := []byte(`{"age": 40, "first-name": "x", "city": "y", "gender": "f"}`)
+	server.Mutation(1, []byte("paused"), doc)
+	time.Sleep(100 * time.Millisecond)
+	for _, data := range eps.Get(testRaddr).Data() {
+		dkv, ok := data.(*c.DataportKeyVersions)
+		if ok && string(dkv.Kv.Docid) == "paused" {
+			t.Fatalf("unexpected mutation routed while topic is paused")
+		}
+	}
+
+	ts := kv.Timestamp("default", "default").SelectByVbuckets([]uint16{0})
+	restartReq := protobuf.NewRestartVbucketsRequest(testTopic).Append(ts)
+	_, err := feed.RestartVbuckets(ctx, restartReq)
+	if !c.IsError(err, projC.ErrorTopicPaused) {
+		t.Fatalf("expected %v, got %v", projC.ErrorTopicPaused, err)
+	}
+
+	if err := feed.ResumeTopic(ctx, protobuf.NewResumeTopicRequest(testTopic)); err != nil {
+		t.Fatal(err)
+	}
+	waitUpsert(t, eps, 1, "paused")
+	resp = feed.GetTopicResponse(ctx)
+	if resp.GetPaused() {
+		t.Fatalf("expected topic to be resumed, got %v", resp)
+	}
+	if vbnos := activeVbnos(resp, "default"); !reflect.DeepEqual(vbnos, testVbnos) {
+		t.Fatalf("expected active vbuckets %v retained, got %v", testVbnos, vbnos)
+	}
+}
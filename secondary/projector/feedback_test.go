@@ -0,0 +1,109 @@
+package projector
+
+import "reflect"
+import "testing"
+import "time"
+
+import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+import c "github.com/couchbase/indexing/secondary/common"
+import projC "github.com/couchbase/indexing/secondary/projector/client"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+
+func newFeedbackTestFeed() (*Feed, chan bool) {
+	finch := make(chan bool)
+	feed := &Feed{
+		reqTss:       make(map[string]*protobuf.TsVbuuid),
+		actTss:       make(map[string]*protobuf.TsVbuuid),
+		rollTss:      make(map[string]*protobuf.TsVbuuid),
+		backch:       newElasticChan(4, 16, finch),
+		finch:        finch,
+		lateWaits:    make(map[feedbackKey]time.Time),
+		staleTimeout: 10,
+		retries:      newVbRetryQueue(time.Second, time.Second, 0),
+		batchRuns:    make(map[streamBatchKey]*streamBatchRun),
+	}
+	reqTs := protobuf.NewTsVbuuid("default", "default", 4)
+	reqTs.Append(1, 10, 0x1, 0, 0)
+	reqTs.Append(2, 20, 0x2, 0, 0)
+	reqTs.Append(3, 30, 0x3, 0, 0)
+	feed.reqTss["default"] = reqTs
+	feed.actTss["default"] = protobuf.NewTsVbuuid("default", "default", 4)
+	feed.rollTss["default"] = protobuf.NewTsVbuuid("default", "default", 4)
+	return feed, finch
+}
+
+func TestWaitOnFeedbackStale(t *testing.T) {
+	feed, finch := newFeedbackTestFeed()
+	defer close(finch)
+
+	// an earlier wait of vbucket 1 with opaque 7 has timed out.
+	feed.addLateWaits("default", []uint16{1}, 7)
+	posted := time.Now().Add(-time.Second)
+	late := &controlStreamRequest{
+		bucket: "default", opaque: 7, status: mcd.SUCCESS, vbno: 1, posted: posted,
+	}
+	// same opaque, but not for a vbucket whose wait timed out.
+	other := &controlStreamRequest{
+		bucket: "default", opaque: 7, status: mcd.SUCCESS, vbno: 3, posted: posted,
+	}
+	feed.backch.in <- []interface{}{late}
+	feed.backch.in <- []interface{}{other}
+
+	pending := c.NewVbSet(2)
+	timeout := time.After(100 * time.Millisecond)
+	err := feed.waitOnFeedback(8, "default", pending, timeout,
+		func(msg interface{}) string { return "skip" })
+	if err != projC.ErrorResponseTimeout {
+		t.Fatalf("expected %v, got %v", projC.ErrorResponseTimeout, err)
+	}
+	if n := feed.nStaleFeedback.Value(); n != 2 {
+		t.Fatalf("expected 2 stale feedback, got %v", n)
+	} else if n := feed.nLateFeedback.Value(); n != 1 {
+		t.Fatalf("expected 1 late feedback, got %v", n)
+	}
+	if _, ok := feed.lateWaits[feedbackKey{"default", 2, 8}]; !ok {
+		t.Fatalf("expected wait for vbucket 2 to be remembered, got %v", feed.lateWaits)
+	} else if _, ok := feed.lateWaits[feedbackKey{"default", 1, 7}]; ok {
+		t.Fatalf("expected late wait to be accounted once")
+	}
+
+	// stale feedback is applied once the request is done.
+	if vbnos := feed.actTss["default"].GetVbnos(); len(vbnos) != 0 {
+		t.Fatalf("unexpected active vbuckets %v", vbnos)
+	}
+	feed.applyStaleFeedback()
+	vbnos := c.Vbno32to16(feed.actTss["default"].GetVbnos())
+	if !reflect.DeepEqual(vbnos, []uint16{1, 3}) {
+		t.Fatalf("expected active vbuckets [1 3], got %v", vbnos)
+	}
+	vbnos = c.Vbno32to16(feed.reqTss["default"].GetVbnos())
+	if !reflect.DeepEqual(vbnos, []uint16{2}) {
+		t.Fatalf("expected outstanding vbuckets [2], got %v", vbnos)
+	}
+	if len(feed.staleFeedback) != 0 {
+		t.Fatalf("expected stale feedback to be applied once")
+	}
+}
+
+func TestWaitOnFeedbackStaleStreamEnd(t *testing.T) {
+	feed, finch := newFeedbackTestFeed()
+	defer close(finch)
+
+	actTs := feed.actTss["default"]
+	actTs.Append(2, 20, 0x2, 0, 0)
+	end := &controlStreamEnd{
+		bucket: "default", opaque: 7, status: mcd.SUCCESS, vbno: 2,
+		posted: time.Now().Add(-time.Second),
+	}
+	feed.backch.in <- []interface{}{end}
+
+	timeout := time.After(100 * time.Millisecond)
+	feed.waitOnFeedback(8, "default", c.NewVbSet(1), timeout,
+		func(msg interface{}) string { return "skip" })
+	feed.applyStaleFeedback()
+	if vbnos := feed.actTss["default"].GetVbnos(); len(vbnos) != 0 {
+		t.Fatalf("expected vbucket 2 to end, got active %v", vbnos)
+	} else if feed.reqTss["default"].Contains(2) {
+		t.Fatalf("expected vbucket 2 to be forgotten")
+	}
+}
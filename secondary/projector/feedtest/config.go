@@ -0,0 +1,26 @@
+package feedtest
+
+import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/projector"
+
+// FeedConfig returns configuration for projector.NewFeed() with fake
+// upstream, KV or UprKV, and downstream. Timeouts are in milliseconds and
+// retries are disabled, callers can override them before creating the
+// feed.
+func FeedConfig(
+	kv projector.KVConnector, eps *Endpoints,
+	maxVbuckets int, reqTimeout int) c.Config {
+
+	config := c.SystemConfig.SectionConfig("projector.", true)
+	config.Set("maxVbuckets", c.SystemConfig["maxVbuckets"])
+	config.SetValue("maxVbuckets", maxVbuckets)
+	config.SetValue("feedWaitStreamReqTimeout", reqTimeout)
+	config.SetValue("feedWaitStreamEndTimeout", reqTimeout)
+	config.SetValue("feedRetryBudget", 0)
+	config.SetValue("routerEndpointFactory", eps.Factory())
+	config.Set("kvConnector", c.ConfigValue{
+		Value: kv,
+		Help:  "fake KV cluster to start upstream with",
+	})
+	return config
+}
@@ -0,0 +1,154 @@
+package feedtest
+
+import "context"
+import "errors"
+import "sync"
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+// ErrorEndpointClosed is returned for data sent to a closed endpoint.
+var ErrorEndpointClosed = errors.New("feedtest.endpointClosed")
+
+// Endpoints is a set of fake downstream endpoints, use Factory() as
+// feed's routerEndpointFactory.
+type Endpoints struct {
+	mu        sync.Mutex
+	endpoints map[string]*Endpoint // raddr -> latest endpoint
+	nStarted  int
+}
+
+// NewEndpoints returns an empty set of fake endpoints.
+func NewEndpoints() *Endpoints {
+	return &Endpoints{endpoints: make(map[string]*Endpoint)}
+}
+
+// Factory returns a RouterEndpointFactory creating fake endpoints.
+func (eps *Endpoints) Factory() c.RouterEndpointFactory {
+	return func(
+		topic, endpointType, raddr, token string) (c.RouterEndpoint, error) {
+
+		eps.mu.Lock()
+		defer eps.mu.Unlock()
+
+		endpoint := &Endpoint{
+			topic:  topic,
+			token:  token,
+			typ:    endpointType,
+			raddr:  raddr,
+			data:   make([]interface{}, 0),
+			vbmaps: make([]*c.VbConnectionMap, 0),
+		}
+		eps.endpoints[raddr] = endpoint
+		eps.nStarted++
+		return endpoint, nil
+	}
+}
+
+// Get returns the latest endpoint started for `raddr`, nil if none.
+func (eps *Endpoints) Get(raddr string) *Endpoint {
+	eps.mu.Lock()
+	defer eps.mu.Unlock()
+	return eps.endpoints[raddr]
+}
+
+// Started returns the number of endpoints started so far, including
+// those restarted for the same address.
+func (eps *Endpoints) Started() int {
+	eps.mu.Lock()
+	defer eps.mu.Unlock()
+	return eps.nStarted
+}
+
+// Endpoint is a fake downstream implementing c.RouterEndpoint{}, it
+// records data and vbmaps sent to it.
+type Endpoint struct {
+	topic string
+	token string
+	typ   string
+	raddr string
+
+	mu     sync.Mutex
+	data   []interface{}
+	vbmaps []*c.VbConnectionMap
+	closed bool
+}
+
+// Close simulates a failed downstream connection, when called by test,
+// or shuts down the endpoint when called by feed.
+func (endpoint *Endpoint) Close() error {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	endpoint.closed = true
+	return nil
+}
+
+// IsClosed returns whether endpoint is closed.
+func (endpoint *Endpoint) IsClosed() bool {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	return endpoint.closed
+}
+
+// Data returns data sent to endpoint so far.
+func (endpoint *Endpoint) Data() []interface{} {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	data := make([]interface{}, len(endpoint.data))
+	copy(data, endpoint.data)
+	return data
+}
+
+// Vbmaps returns the vbmaps last sent to endpoint.
+func (endpoint *Endpoint) Vbmaps() []*c.VbConnectionMap {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	return endpoint.vbmaps
+}
+
+// Token returns the registration token endpoint was started with.
+func (endpoint *Endpoint) Token() string {
+	return endpoint.token
+}
+
+// Ping implements c.RouterEndpoint{} interface.
+func (endpoint *Endpoint) Ping() bool {
+	return !endpoint.IsClosed()
+}
+
+// SetConfig implements c.RouterEndpoint{} interface.
+func (endpoint *Endpoint) SetConfig(config c.Config) error {
+	return nil
+}
+
+// Send implements c.RouterEndpoint{} interface.
+func (endpoint *Endpoint) Send(data interface{}) error {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	if endpoint.closed {
+		return ErrorEndpointClosed
+	}
+	endpoint.data = append(endpoint.data, data)
+	return nil
+}
+
+// SendVbmaps implements c.RouterEndpoint{} interface.
+func (endpoint *Endpoint) SendVbmaps(vbmaps []*c.VbConnectionMap) error {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	if endpoint.closed {
+		return ErrorEndpointClosed
+	}
+	endpoint.vbmaps = vbmaps
+	return nil
+}
+
+// GetStatistics implements c.RouterEndpoint{} interface.
+func (endpoint *Endpoint) GetStatistics(ctx context.Context) map[string]interface{} {
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	return map[string]interface{}{
+		"topic":    endpoint.topic,
+		"raddr":    endpoint.raddr,
+		"messages": float64(len(endpoint.data)),
+	}
+}
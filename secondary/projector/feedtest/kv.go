@@ -0,0 +1,411 @@
+// Package feedtest provides fake upstream and downstream for projector's
+// feed, so that its control path can be tested without a KV cluster or
+// indexer nodes.
+package feedtest
+
+import "errors"
+import "sync"
+
+import "github.com/couchbase/indexing/secondary/dcp"
+import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+import "github.com/couchbase/indexing/secondary/projector"
+
+// ErrorUnknownBucket is returned for buckets not added to fake KV.
+var ErrorUnknownBucket = errors.New("feedtest.unknownBucket")
+
+// Response of fake KV to StreamRequest or StreamEnd for a vbucket.
+type Response struct {
+	Status mcd.Status
+	Seqno  uint64 // rollback seqno, for ROLLBACK status
+	Drop   bool   // don't respond, to simulate timeouts
+}
+
+// KV is a fake KV cluster implementing projector.KVConnector{}. Its
+// buckets are hosted on this node and respond with SUCCESS to all
+// requests, unless specified otherwise.
+type KV struct {
+	mu      sync.Mutex
+	buckets map[string]*fakeBucket
+	err     error // returned by all calls, when set
+}
+
+type fakeBucket struct {
+	uuid      string
+	vbnos     []uint16
+	vbuuids   map[uint16]uint64
+	reqResps  map[uint16][]Response // vbno -> StreamRequest responses
+	endResps  map[uint16][]Response // vbno -> StreamEnd responses
+	nRequests map[uint16]int        // vbno -> StreamRequests received
+	batches   [][]uint16            // vbnos of every StartVbStreams()
+	feeders   []*Feeder
+}
+
+// NewKV returns a fake KV cluster without any bucket.
+func NewKV() *KV {
+	return &KV{buckets: make(map[string]*fakeBucket)}
+}
+
+// AddBucket adds a bucket with `vbnos` hosted on this node.
+func (kv *KV) AddBucket(bucketn, uuid string, vbnos []uint16) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	b := &fakeBucket{
+		uuid:      uuid,
+		vbuuids:   make(map[uint16]uint64),
+		reqResps:  make(map[uint16][]Response),
+		endResps:  make(map[uint16][]Response),
+		nRequests: make(map[uint16]int),
+		feeders:   make([]*Feeder, 0),
+	}
+	b.setVbuckets(vbnos)
+	kv.buckets[bucketn] = b
+}
+
+// SetVbuckets changes vbuckets of the bucket hosted by this node, like
+// after a rebalance.
+func (kv *KV) SetVbuckets(bucketn string, vbnos []uint16) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.buckets[bucketn].setVbuckets(vbnos)
+}
+
+// SetUUID changes bucket's uuid, like after a flush or re-create.
+func (kv *KV) SetUUID(bucketn, uuid string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.buckets[bucketn].uuid = uuid
+}
+
+// SetError makes all calls to fake KV fail with `err`, nil to reset.
+func (kv *KV) SetError(err error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.err = err
+}
+
+// RespondStreamRequest queues responses for the next StreamRequests on
+// vbucket, once they are used up SUCCESS is responded.
+func (kv *KV) RespondStreamRequest(
+	bucketn string, vbno uint16, resps ...Response) {
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	b := kv.buckets[bucketn]
+	b.reqResps[vbno] = append(b.reqResps[vbno], resps...)
+}
+
+// RespondStreamEnd queues responses for the next StreamEnds on vbucket,
+// once they are used up SUCCESS is responded.
+func (kv *KV) RespondStreamEnd(bucketn string, vbno uint16, resps ...Response) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	b := kv.buckets[bucketn]
+	b.endResps[vbno] = append(b.endResps[vbno], resps...)
+}
+
+// StreamRequests returns the number of StreamRequests received for
+// vbucket.
+func (kv *KV) StreamRequests(bucketn string, vbno uint16) int {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.buckets[bucketn].nRequests[vbno]
+}
+
+// StreamRequestBatches returns vbuckets requested by every call to
+// StartVbStreams() on the bucket's feeds, in the order they were called.
+func (kv *KV) StreamRequestBatches(bucketn string) [][]uint16 {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	batches := make([][]uint16, len(kv.buckets[bucketn].batches))
+	copy(batches, kv.buckets[bucketn].batches)
+	return batches
+}
+
+// Feeders returns upstream feeds opened for the bucket, in the order
+// they were opened.
+func (kv *KV) Feeders(bucketn string) []*Feeder {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	feeders := make([]*Feeder, len(kv.buckets[bucketn].feeders))
+	copy(feeders, kv.buckets[bucketn].feeders)
+	return feeders
+}
+
+// Timestamp returns a request timestamp for all vbuckets of the bucket
+// hosted by this node, starting from seqno 0.
+func (kv *KV) Timestamp(pooln, bucketn string) *protobuf.TsVbuuid {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	b := kv.buckets[bucketn]
+	ts := protobuf.NewTsVbuuid(pooln, bucketn, len(b.vbnos))
+	for _, vbno := range b.vbnos {
+		ts.Append(vbno, 0 /*seqno*/, b.vbuuids[vbno], 0, 0)
+	}
+	return ts
+}
+
+// GetLocalVbuckets implements projector.KVConnector{} interface.
+func (kv *KV) GetLocalVbuckets(pooln, bucketn string) ([]uint16, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	b, err := kv.getBucket(bucketn)
+	if err != nil {
+		return nil, err
+	}
+	vbnos := make([]uint16, len(b.vbnos))
+	copy(vbnos, b.vbnos)
+	return vbnos, nil
+}
+
+// GetFailoverLogs implements projector.KVConnector{} interface.
+func (kv *KV) GetFailoverLogs(
+	pooln, bucketn string,
+	vbnos []uint16) (couchbase.FailoverLog, string, error) {
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	b, err := kv.getBucket(bucketn)
+	if err != nil {
+		return nil, "", err
+	}
+	flogs := make(couchbase.FailoverLog)
+	for _, vbno := range vbnos {
+		flogs[vbno] = mc.FailoverLog{{b.vbuuids[vbno], 0}}
+	}
+	return flogs, b.uuid, nil
+}
+
+// OpenBucketFeed implements projector.KVConnector{} interface.
+func (kv *KV) OpenBucketFeed(
+	pooln, bucketn, feedname string) (projector.BucketFeeder, error) {
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	b, err := kv.getBucket(bucketn)
+	if err != nil {
+		return nil, err
+	}
+	feeder := newFeeder(kv, bucketn, feedname)
+	b.feeders = append(b.feeders, feeder)
+	return feeder, nil
+}
+
+func (kv *KV) getBucket(bucketn string) (*fakeBucket, error) {
+	if kv.err != nil {
+		return nil, kv.err
+	}
+	b, ok := kv.buckets[bucketn]
+	if !ok {
+		return nil, ErrorUnknownBucket
+	}
+	return b, nil
+}
+
+// streamRequest accounts a StreamRequest and returns its response.
+func (kv *KV) streamRequest(bucketn string, vbno uint16) (Response, uint64) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	b := kv.buckets[bucketn]
+	b.nRequests[vbno]++
+	resp := Response{Status: mcd.SUCCESS}
+	if resps := b.reqResps[vbno]; len(resps) > 0 {
+		resp, b.reqResps[vbno] = resps[0], resps[1:]
+	} else if !b.isLocal(vbno) {
+		resp = Response{Status: mcd.NOT_MY_VBUCKET}
+	}
+	return resp, b.vbuuids[vbno]
+}
+
+// streamRequestBatch accounts vbuckets requested together.
+func (kv *KV) streamRequestBatch(bucketn string, vbnos []uint16) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	b := kv.buckets[bucketn]
+	b.batches = append(b.batches, vbnos)
+}
+
+// streamEnd returns response for a StreamEnd.
+func (kv *KV) streamEnd(bucketn string, vbno uint16) Response {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	b := kv.buckets[bucketn]
+	resp := Response{Status: mcd.SUCCESS}
+	if resps := b.endResps[vbno]; len(resps) > 0 {
+		resp, b.endResps[vbno] = resps[0], resps[1:]
+	}
+	return resp
+}
+
+func (b *fakeBucket) setVbuckets(vbnos []uint16) {
+	b.vbnos = make([]uint16, len(vbnos))
+	copy(b.vbnos, vbnos)
+	for _, vbno := range vbnos {
+		if _, ok := b.vbuuids[vbno]; !ok {
+			b.vbuuids[vbno] = uint64(vbno) + 1000
+		}
+	}
+}
+
+func (b *fakeBucket) isLocal(vbno uint16) bool {
+	for _, x := range b.vbnos {
+		if x == vbno {
+			return true
+		}
+	}
+	return false
+}
+
+// Feeder is a fake upstream feed implementing projector.BucketFeeder{},
+// it responds to requests as programmed on its KV and mutations can be
+// injected into it.
+type Feeder struct {
+	kv     *KV
+	bucket string
+	name   string
+
+	mu      sync.Mutex
+	mutch   chan *mc.UprEvent
+	streams map[uint16]bool // active vbuckets
+	closed  bool
+}
+
+func newFeeder(kv *KV, bucketn, feedname string) *Feeder {
+	return &Feeder{
+		kv:      kv,
+		bucket:  bucketn,
+		name:    feedname,
+		mutch:   make(chan *mc.UprEvent, 10000),
+		streams: make(map[uint16]bool),
+	}
+}
+
+// Name of the upstream feed.
+func (f *Feeder) Name() string {
+	return f.name
+}
+
+// IsClosed returns whether feed has been closed.
+func (f *Feeder) IsClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// Send injects an event, like a mutation or a snapshot, into the feed.
+func (f *Feeder) Send(m *mc.UprEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.send(m)
+}
+
+// Reset simulates a failed upstream connection, streams of all active
+// vbuckets are ended with `err` and the feed is closed.
+func (f *Feeder) Reset(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for vbno := range f.streams {
+		f.send(&mc.UprEvent{
+			Opcode:  mcd.UPR_STREAMEND,
+			Status:  mcd.SUCCESS,
+			VBucket: vbno,
+			Error:   err,
+		})
+		delete(f.streams, vbno)
+	}
+	f.close()
+}
+
+// GetChannel implements projector.BucketFeeder{} interface.
+func (f *Feeder) GetChannel() <-chan *mc.UprEvent {
+	return f.mutch
+}
+
+// StartVbStreams implements projector.BucketFeeder{} interface.
+func (f *Feeder) StartVbStreams(opaque uint16, ts *protobuf.TsVbuuid) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	vbnos := c.Vbno32to16(ts.GetVbnos())
+	f.kv.streamRequestBatch(f.bucket, vbnos)
+	for _, vbno := range vbnos {
+		resp, vbuuid := f.kv.streamRequest(f.bucket, vbno)
+		if resp.Drop {
+			continue
+		}
+		m := &mc.UprEvent{
+			Opcode:  mcd.UPR_STREAMREQ,
+			Status:  resp.Status,
+			VBucket: vbno,
+			Opaque:  opaque,
+			Seqno:   resp.Seqno,
+		}
+		if resp.Status == mcd.SUCCESS {
+			m.FailoverLog = &mc.FailoverLog{{vbuuid, 0}}
+			f.streams[vbno] = true
+		}
+		f.send(m)
+	}
+	return nil
+}
+
+// EndVbStreams implements projector.BucketFeeder{} interface.
+func (f *Feeder) EndVbStreams(opaque uint16, ts *protobuf.TsVbuuid) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, vbno := range c.Vbno32to16(ts.GetVbnos()) {
+		resp := f.kv.streamEnd(f.bucket, vbno)
+		if resp.Drop {
+			continue
+		}
+		if resp.Status == mcd.SUCCESS {
+			delete(f.streams, vbno)
+		}
+		f.send(&mc.UprEvent{
+			Opcode:  mcd.UPR_STREAMEND,
+			Status:  resp.Status,
+			VBucket: vbno,
+			Opaque:  opaque,
+		})
+	}
+	return nil
+}
+
+// GetStatistics implements projector.BucketFeeder{} interface.
+func (f *Feeder) GetStatistics() map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return map[string]interface{}{
+		"streams": float64(len(f.streams)),
+	}
+}
+
+// CloseFeed implements projector.BucketFeeder{} interface.
+func (f *Feeder) CloseFeed() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.close()
+	return nil
+}
+
+func (f *Feeder) send(m *mc.UprEvent) {
+	if !f.closed {
+		f.mutch <- m
+	}
+}
+
+func (f *Feeder) close() {
+	if !f.closed {
+		close(f.mutch)
+		f.closed = true
+	}
+}
@@ -0,0 +1,212 @@
+package feedtest
+
+import "sort"
+import "sync"
+
+import "github.com/couchbase/indexing/secondary/dcp"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+import mcs "github.com/couchbase/indexing/secondary/dcp/transport/server"
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+import "github.com/couchbase/indexing/secondary/projector"
+
+// UprKV is a fake KV node implementing projector.KVConnector{}, unlike KV
+// its buckets are served by in-process UPR producers, so that feed talks
+// to them over UPR protocol using the same client as with a cluster.
+type UprKV struct {
+	mu      sync.Mutex
+	buckets map[string]*uprBucket
+}
+
+type uprBucket struct {
+	uuid   string
+	server *mcs.UprServer
+}
+
+// NewUprKV returns a fake KV node without any bucket.
+func NewUprKV() *UprKV {
+	return &UprKV{buckets: make(map[string]*uprBucket)}
+}
+
+// AddBucket starts an UPR producer for bucket hosting `vbnos`, use the
+// returned server to inject mutations, rollbacks and errors.
+func (kv *UprKV) AddBucket(
+	bucketn, uuid string, vbnos []uint16) (*mcs.UprServer, error) {
+
+	server, err := mcs.NewUprServer("127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server.SetVbuckets(vbnos)
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.buckets[bucketn] = &uprBucket{uuid: uuid, server: server}
+	return server, nil
+}
+
+// Server returns UPR producer for bucket, nil if bucket is not added.
+func (kv *UprKV) Server(bucketn string) *mcs.UprServer {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if b, ok := kv.buckets[bucketn]; ok {
+		return b.server
+	}
+	return nil
+}
+
+// Timestamp returns a request timestamp for all vbuckets of the bucket,
+// starting from seqno 0.
+func (kv *UprKV) Timestamp(pooln, bucketn string) *protobuf.TsVbuuid {
+	server := kv.Server(bucketn)
+	vbnos := sortVbuckets(server.Vbuckets())
+	ts := protobuf.NewTsVbuuid(pooln, bucketn, len(vbnos))
+	for _, vbno := range vbnos {
+		vbuuid := server.FailoverLog(vbno)[0][0]
+		ts.Append(vbno, 0 /*seqno*/, vbuuid, 0, 0)
+	}
+	return ts
+}
+
+// Close UPR producers of all buckets.
+func (kv *UprKV) Close() {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	for _, b := range kv.buckets {
+		b.server.Close()
+	}
+}
+
+// GetLocalVbuckets implements projector.KVConnector{} interface.
+func (kv *UprKV) GetLocalVbuckets(pooln, bucketn string) ([]uint16, error) {
+	b, err := kv.getBucket(bucketn)
+	if err != nil {
+		return nil, err
+	}
+	return sortVbuckets(b.server.Vbuckets()), nil
+}
+
+// GetFailoverLogs implements projector.KVConnector{} interface.
+func (kv *UprKV) GetFailoverLogs(
+	pooln, bucketn string,
+	vbnos []uint16) (couchbase.FailoverLog, string, error) {
+
+	b, err := kv.getBucket(bucketn)
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := mc.Connect("tcp", b.server.Addr())
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+
+	flogs, err := conn.UprGetFailoverLog(vbnos)
+	if err != nil {
+		return nil, "", err
+	}
+	failoverLogs := make(couchbase.FailoverLog)
+	for vbno, flog := range flogs {
+		failoverLogs[vbno] = *flog
+	}
+	return failoverLogs, b.uuid, nil
+}
+
+// OpenBucketFeed implements projector.KVConnector{} interface.
+func (kv *UprKV) OpenBucketFeed(
+	pooln, bucketn, feedname string) (projector.BucketFeeder, error) {
+
+	b, err := kv.getBucket(bucketn)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := mc.Connect("tcp", b.server.Addr())
+	if err != nil {
+		return nil, err
+	}
+	uprFeed, err := conn.NewUprFeed()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := uprFeed.UprOpen(feedname, 0, 1024*1024); err != nil {
+		uprFeed.Close()
+		return nil, err
+	}
+	uprFeed.StartFeed()
+	return &uprFeeder{uprFeed: uprFeed}, nil
+}
+
+func (kv *UprKV) getBucket(bucketn string) (*uprBucket, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	b, ok := kv.buckets[bucketn]
+	if !ok {
+		return nil, ErrorUnknownBucket
+	}
+	return b, nil
+}
+
+func sortVbuckets(vbnos []uint16) []uint16 {
+	sort.Sort(c.Vbuckets(vbnos))
+	return vbnos
+}
+
+// uprFeeder implements projector.BucketFeeder{} over a single UPR
+// connection.
+type uprFeeder struct {
+	uprFeed *mc.UprFeed
+}
+
+// GetChannel implements projector.BucketFeeder{} interface.
+func (f *uprFeeder) GetChannel() <-chan *mc.UprEvent {
+	return f.uprFeed.C
+}
+
+// StartVbStreams implements projector.BucketFeeder{} interface.
+func (f *uprFeeder) StartVbStreams(
+	opaque uint16, reqTs *protobuf.TsVbuuid) (err error) {
+
+	vbnos := c.Vbno32to16(reqTs.GetVbnos())
+	vbuuids, seqnos := reqTs.GetVbuuids(), reqTs.GetSeqnos()
+	snapshots := reqTs.GetSnapshots()
+	for i, vbno := range vbnos {
+		snapStart, snapEnd := snapshots[i].GetStart(), snapshots[i].GetEnd()
+		e := f.uprFeed.UprRequestStream(
+			vbno, opaque, 0, vbuuids[i], seqnos[i], 0xFFFFFFFFFFFFFFFF,
+			snapStart, snapEnd)
+		if e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// EndVbStreams implements projector.BucketFeeder{} interface.
+func (f *uprFeeder) EndVbStreams(
+	opaque uint16, ts *protobuf.TsVbuuid) (err error) {
+
+	for _, vbno := range c.Vbno32to16(ts.GetVbnos()) {
+		if e := f.uprFeed.CloseStream(vbno, opaque); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// GetStatistics implements projector.BucketFeeder{} interface.
+func (f *uprFeeder) GetStatistics() map[string]interface{} {
+	s := f.uprFeed.GetUprStats()
+	return map[string]interface{}{
+		"bytes":      float64(s.TotalBytes),
+		"bufferAcks": float64(s.TotalBufferAckSent),
+		"mutations":  float64(s.TotalMutation),
+		"snapshots":  float64(s.TotalSnapShot),
+	}
+}
+
+// CloseFeed implements projector.BucketFeeder{} interface.
+func (f *uprFeeder) CloseFeed() error {
+	f.uprFeed.Close()
+	return nil
+}
@@ -4,12 +4,14 @@
 //     feed <---------------------*   NewKVData()
 //                StreamRequest   |     |            *---> vbucket
 //                    StreamEnd   |   (spawn)        |
-//                                |     |            *---> vbucket
+//                   CatchupEnd   |     |            *---> vbucket
 //                                |     |            |
 //        AddEngines() --*-----> runScatter ---------*---> vbucket
 //                       |
 //     DeleteEngines() --*
 //                       |
+//       Pause/Resume() --*
+//                       |
 //     GetStatistics() --*
 //                       |
 //             Close() --*
@@ -17,6 +19,7 @@
 package projector
 
 import "fmt"
+import "context"
 import "strconv"
 import "runtime/debug"
 
@@ -32,20 +35,38 @@ type KVData struct {
 	topic  string // immutable
 	bucket string // immutable
 	vrs    map[uint16]*VbucketRoutine
+	// for catchup topic, seqno at which vbucket streams shall end,
+	// nil otherwise.
+	endTs *protobuf.TsVbuuid
+	ended map[uint16]bool // vbuckets that reached their end seqno
+	// paused, mutations are not drained from upstream.
+	paused bool
 	// evaluators and subscribers
 	engines   map[uint64]*Engine
 	endpoints map[string]c.RouterEndpoint
 	// server channels
 	sbch  chan []interface{}
 	finch chan bool
+	// mutations from upstream, buffered by load between
+	// mutationChanMinSize and mutationChanSize.
+	mutch *elasticMutch
+	// every Nth mutation sampled by vbuckets, nil if disabled.
+	sampler *mutationSampler
+	// statistics
+	eventCount c.Counter // no. of mutations events received
+	addCount   c.Counter // no. of addInstances received
+	delCount   c.Counter // no. of delInsts received
+	tsCount    c.Counter // no. of updateTs received
+	endCount   c.Counter // no. of vbuckets that reached end seqno
 	// misc.
 	logPrefix string
 }
 
-// NewKVData create a new data-path instance.
+// NewKVData create a new data-path instance, `endTs` is nil unless
+// data-path is for a catchup topic.
 func NewKVData(
 	feed *Feed, bucket string,
-	reqTs *protobuf.TsVbuuid,
+	reqTs, endTs *protobuf.TsVbuuid,
 	engines map[uint64]*Engine,
 	endpoints map[string]c.RouterEndpoint,
 	mutch <-chan *mc.UprEvent) *KVData {
@@ -55,13 +76,15 @@ func NewKVData(
 		topic:     feed.topic,
 		bucket:    bucket,
 		vrs:       make(map[uint16]*VbucketRoutine),
+		endTs:     endTs,
+		ended:     make(map[uint16]bool),
 		engines:   make(map[uint64]*Engine),
 		endpoints: make(map[string]c.RouterEndpoint),
 		// 16 is enough, there can't be more than that many out-standing
 		// control calls on this feed.
 		sbch:      make(chan []interface{}, 16),
 		finch:     make(chan bool),
-		logPrefix: fmt.Sprintf("KVDT[<-%v<-%v #%v]", bucket, feed.cluster, feed.topic),
+		logPrefix: fmt.Sprintf("KVDT[<-%v<-%v #%v]", bucket, c.RedactURL(feed.cluster), feed.topic),
 	}
 	for uuid, engine := range engines {
 		kvdata.engines[uuid] = engine
@@ -69,7 +92,13 @@ func NewKVData(
 	for raddr, endpoint := range endpoints {
 		kvdata.endpoints[raddr] = endpoint
 	}
-	go kvdata.runScatter(reqTs, mutch)
+	kvdata.sampler = newMutationSampler(
+		feed.config["mutationSampleEvery"].Int(),
+		feed.config["mutationSampleSize"].Int())
+	kvdata.mutch = newElasticMutch(
+		mutch, feed.config["mutationChanMinSize"].Int(),
+		feed.config["mutationChanSize"].Int(), kvdata.finch)
+	go kvdata.runScatter(reqTs, kvdata.mutch.out)
 	c.Infof("%v started ...\n", kvdata.logPrefix)
 	return kvdata
 }
@@ -79,6 +108,8 @@ const (
 	kvCmdAddEngines byte = iota + 1
 	kvCmdDelEngines
 	kvCmdTs
+	kvCmdPause
+	kvCmdResume
 	kvCmdGetStats
 	kvCmdClose
 )
@@ -107,22 +138,60 @@ func (kvdata *KVData) DeleteEngines(engineKeys []uint64) error {
 	return err
 }
 
-// UpdateTs with new set of {vbno,seqno}, synchronous call.
-func (kvdata *KVData) UpdateTs(ts *protobuf.TsVbuuid) error {
+// UpdateTs with new set of {vbno,seqno}, synchronous call. Vbuckets that
+// are already streaming continue from where they are, return the subset
+// of `ts` that is applied as restart-point for the rest.
+func (kvdata *KVData) UpdateTs(
+	ts *protobuf.TsVbuuid) (*protobuf.TsVbuuid, error) {
+
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{kvCmdTs, ts, respch}
+	resp, err := c.FailsafeOp(kvdata.sbch, respch, cmd, kvdata.finch)
+	if err != nil {
+		return nil, err
+	}
+	return resp[0].(*protobuf.TsVbuuid), nil
+}
+
+// Pause draining mutations from upstream, control commands are served
+// while paused, synchronous call.
+func (kvdata *KVData) Pause() error {
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{kvCmdPause, respch}
+	_, err := c.FailsafeOp(kvdata.sbch, respch, cmd, kvdata.finch)
+	return err
+}
+
+// Resume draining mutations from upstream, synchronous call.
+func (kvdata *KVData) Resume() error {
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{kvCmdResume, respch}
 	_, err := c.FailsafeOp(kvdata.sbch, respch, cmd, kvdata.finch)
 	return err
 }
 
-// GetStatistics from kv data path, synchronous call.
-func (kvdata *KVData) GetStatistics() map[string]interface{} {
+// GetStatistics from kv data path, nil if kv data path is closed or `ctx`
+// expires before it responds, synchronous call.
+func (kvdata *KVData) GetStatistics(ctx context.Context) map[string]interface{} {
 	respch := make(chan []interface{}, 1)
-	cmd := []interface{}{kvCmdGetStats, respch}
-	resp, _ := c.FailsafeOp(kvdata.sbch, respch, cmd, kvdata.finch)
+	cmd := []interface{}{kvCmdGetStats, ctx, respch}
+	resp, err := c.FailsafeOpContext(ctx, kvdata.sbch, respch, cmd, kvdata.finch)
+	if err != nil {
+		return nil
+	}
 	return resp[0].(map[string]interface{})
 }
 
+// MutationSamples recently captured on this kv data path, oldest first,
+// only those of document `docid` unless it is empty. Returns nil if
+// mutation sampling is disabled.
+func (kvdata *KVData) MutationSamples(docid string) []*MutationSample {
+	if kvdata.sampler == nil {
+		return nil
+	}
+	return kvdata.sampler.recent(docid)
+}
+
 // Close kvdata kv data path, synchronous call.
 func (kvdata *KVData) Close() error {
 	respch := make(chan []interface{}, 1)
@@ -133,7 +202,10 @@ func (kvdata *KVData) Close() error {
 
 // go-routine handles data path.
 func (kvdata *KVData) runScatter(
-	ts *protobuf.TsVbuuid, mutch <-chan *mc.UprEvent) {
+	ts *protobuf.TsVbuuid, mutch <-chan []*mc.UprEvent) {
+
+	var upstreamErr error // streams ended by upstream connection failure
+	recvch := mutch       // nil while paused
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -141,24 +213,25 @@ func (kvdata *KVData) runScatter(
 			c.StackTrace(string(debug.Stack()))
 		}
 		kvdata.publishStreamEnd()
-		kvdata.feed.PostFinKVdata(kvdata.bucket)
+		kvdata.feed.PostFinKVdata(kvdata.bucket, upstreamErr)
 		close(kvdata.finch)
 		c.Infof("%v ... stopped\n", kvdata.logPrefix)
 	}()
 
-	// stats
-	eventCount, addCount, delCount := int64(0), int64(0), int64(0)
-	tsCount := int64(0)
-
 loop:
 	for {
 		select {
-		case m, ok := <-mutch:
+		case batch, ok := <-recvch:
 			if ok == false { // upstream has closed
 				break loop
 			}
-			kvdata.scatterMutation(m, ts)
-			eventCount++
+			for _, m := range batch {
+				if m.Opcode == mcd.UPR_STREAMEND && m.Error != nil {
+					upstreamErr = m.Error
+				}
+				kvdata.scatterMutation(m, ts)
+			}
+			kvdata.eventCount.Add(int64(len(batch)))
 
 			// all vbuckets have ended for this stream, exit kvdata.
 			// FIXME : For now don't cleanup the bucket because of this.
@@ -187,7 +260,7 @@ loop:
 						vr.AddEngines(kvdata.engines, kvdata.endpoints)
 					}
 				}
-				addCount++
+				kvdata.addCount.Add(1)
 				respch <- []interface{}{nil}
 
 			case kvCmdDelEngines:
@@ -199,25 +272,37 @@ loop:
 				for _, engineKey := range engineKeys {
 					delete(kvdata.engines, engineKey)
 				}
-				delCount++
+				kvdata.delCount.Add(1)
 				respch <- []interface{}{nil}
 
 			case kvCmdTs:
-				ts = ts.Union(msg[1].(*protobuf.TsVbuuid))
 				respch := msg[2].(chan []interface{})
-				tsCount++
+				running := make([]uint16, 0, len(kvdata.vrs))
+				for vbno := range kvdata.vrs {
+					running = append(running, vbno)
+				}
+				appliedTs := msg[1].(*protobuf.TsVbuuid).FilterByVbuckets(running)
+				ts = ts.Union(appliedTs)
+				kvdata.tsCount.Add(1)
+				respch <- []interface{}{appliedTs}
+
+			case kvCmdPause:
+				respch := msg[1].(chan []interface{})
+				kvdata.paused, recvch = true, nil
 				respch <- []interface{}{nil}
 
-			case kvCmdGetStats:
+			case kvCmdResume:
 				respch := msg[1].(chan []interface{})
+				kvdata.paused, recvch = false, mutch
+				respch <- []interface{}{nil}
+
+			case kvCmdGetStats:
+				ctx := msg[1].(context.Context)
+				respch := msg[2].(chan []interface{})
 				stats := kvdata.newStats()
-				stats.Set("events", float64(eventCount))
-				stats.Set("addInsts", float64(addCount))
-				stats.Set("delInsts", float64(delCount))
-				stats.Set("tsCount", float64(tsCount))
 				statVbuckets := make(map[string]interface{})
 				for i, vr := range kvdata.vrs {
-					statVbuckets[strconv.Itoa(int(i))] = vr.GetStatistics()
+					statVbuckets[strconv.Itoa(int(i))] = vr.GetStatistics(ctx)
 				}
 				stats.Set("vbuckets", statVbuckets)
 				respch <- []interface{}{map[string]interface{}(stats)}
@@ -235,6 +320,7 @@ func (kvdata *KVData) scatterMutation(
 	m *mc.UprEvent, ts *protobuf.TsVbuuid) (err error) {
 
 	vbno := m.VBucket
+	started := false
 
 	switch m.Opcode {
 	case mcd.UPR_STREAMREQ:
@@ -257,13 +343,19 @@ func (kvdata *KVData) scatterMutation(
 			topic, bucket := kvdata.topic, kvdata.bucket
 			m.Seqno, _ = ts.SeqnoFor(vbno)
 			config, cluster := kvdata.feed.config, kvdata.feed.cluster
+			resources, version := kvdata.feed.resources, kvdata.feed.version
 			vr := NewVbucketRoutine(
-				cluster, topic, bucket, vbno, m.VBuuid, m.Seqno, config)
+				cluster, topic, bucket, vbno, m.VBuuid, m.Seqno, config,
+				version, resources, kvdata.sampler)
 			vr.AddEngines(kvdata.engines, kvdata.endpoints)
 			vr.Event(m)
 			kvdata.vrs[vbno] = vr
+			started = true
 		}
 		kvdata.feed.PostStreamRequest(kvdata.bucket, m)
+		if started { // stream may already be at its end seqno.
+			kvdata.catchupEvent(m)
+		}
 
 	case mcd.UPR_STREAMEND:
 		if vr, ok := kvdata.vrs[vbno]; !ok {
@@ -277,12 +369,15 @@ func (kvdata *KVData) scatterMutation(
 			c.Tracef("%v StreamEnd {%v}\n", kvdata.logPrefix, vbno)
 			vr.Event(m)
 			delete(kvdata.vrs, vbno)
+			delete(kvdata.ended, vbno)
 		}
 		kvdata.feed.PostStreamEnd(kvdata.bucket, m)
 
 	case mcd.UPR_MUTATION, mcd.UPR_DELETION, mcd.UPR_SNAPSHOT, mcd.UPR_EXPIRATION:
 		if vr, ok := kvdata.vrs[vbno]; ok {
-			vr.Event(m)
+			if kvdata.catchupEvent(m) {
+				vr.Event(m)
+			}
 
 		} else {
 			c.Errorf("%v unknown vbucket %v\n", kvdata.logPrefix, vbno)
@@ -291,6 +386,41 @@ func (kvdata *KVData) scatterMutation(
 	return
 }
 
+// catchupEvent returns false if event is beyond the end seqno of vbucket,
+// for catchup topic, and shall not be sent downstream. Such events are
+// received until upstream ends the stream. Feed is posted to end the
+// stream once the end seqno is reached.
+func (kvdata *KVData) catchupEvent(m *mc.UprEvent) bool {
+	if kvdata.endTs == nil {
+		return true
+	}
+	vbno := m.VBucket
+	endSeqno, err := kvdata.endTs.SeqnoFor(vbno)
+	if err != nil { // no end seqno for vbucket
+		return true
+	}
+
+	forward, reached := true, false
+	switch m.Opcode {
+	case mcd.UPR_STREAMREQ:
+		reached = m.Seqno >= endSeqno
+	case mcd.UPR_SNAPSHOT:
+		forward = m.SnapstartSeq <= endSeqno
+		reached = !forward
+	default:
+		forward = m.Seqno <= endSeqno
+		reached = m.Seqno >= endSeqno
+	}
+	if reached && !kvdata.ended[vbno] {
+		c.Debugf("%v vbucket %v reached end seqno %v\n",
+			kvdata.logPrefix, vbno, endSeqno)
+		kvdata.ended[vbno] = true
+		kvdata.endCount.Add(1)
+		kvdata.feed.PostCatchupEnd(kvdata.bucket, vbno, endSeqno)
+	}
+	return forward
+}
+
 func (kvdata *KVData) publishStreamEnd() {
 	for _, vr := range kvdata.vrs {
 		m := &mc.UprEvent{
@@ -306,12 +436,18 @@ func (kvdata *KVData) publishStreamEnd() {
 func (kvdata *KVData) newStats() c.Statistics {
 	statVbuckets := make(map[string]interface{})
 	m := map[string]interface{}{
-		"events":   float64(0),   // no. of mutations events received
-		"addInsts": float64(0),   // no. of addInstances received
-		"delInsts": float64(0),   // no. of delInsts received
-		"tsCount":  float64(0),   // no. of updateTs received
+		"events":   &kvdata.eventCount,
+		"addInsts": &kvdata.addCount,
+		"delInsts": &kvdata.delCount,
+		"tsCount":  &kvdata.tsCount,
+		"ended":    &kvdata.endCount,
+		"paused":   kvdata.paused,
+		"mutch":    kvdata.mutch.queue.statistics(),
 		"vbuckets": statVbuckets, // per vbucket statistics
 	}
+	if kvdata.sampler != nil {
+		m["mutationSamples"] = kvdata.sampler.statistics()
+	}
 	stats, _ := c.NewStatistics(m)
 	return stats
 }
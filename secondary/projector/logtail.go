@@ -0,0 +1,84 @@
+package projector
+
+import "fmt"
+import "net/http"
+import "strings"
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+// log messages buffered for a slow client, before they are skipped.
+const logTailBuffer = 1000
+
+var logTailLevels = map[string]int{
+	"error": 0,
+	"info":  c.LogLevelInfo,
+	"debug": c.LogLevelDebug,
+	"trace": c.LogLevelTrace,
+}
+
+// handleLogTail streams recent log messages, followed by messages logged
+// thereafter, until client disconnects. Messages can be filtered by query
+// parameters `topic` and `bucket`, to messages mentioning them, and by
+// `level`, one of error, info, debug, trace, to skip messages logged at a
+// higher verbosity. Default level is trace.
+//
+// eg: curl -N "http://localhost:9999/logtail?topic=maintenance&level=info"
+func (p *Projector) handleLogTail(w http.ResponseWriter, r *http.Request) {
+	if p.logtail == nil {
+		http.Error(w, "log tail is disabled", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	topic, bucket := query.Get("topic"), query.Get("bucket")
+	level := c.LogLevelTrace
+	if s := query.Get("level"); s != "" {
+		if level, ok = logTailLevels[strings.ToLower(s)]; !ok {
+			msg := fmt.Sprintf("invalid level %q", s)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
+	match := func(line string) bool {
+		if topic != "" && !strings.Contains(line, topic) {
+			return false
+		} else if bucket != "" && !strings.Contains(line, bucket) {
+			return false
+		}
+		return c.LogLineLevel(line) <= level
+	}
+
+	recent, ch := p.logtail.Subscribe(logTailBuffer)
+	defer p.logtail.Unsubscribe(ch)
+
+	// responses are sent with chunked transfer encoding as they are
+	// flushed before completion.
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range recent {
+		if match(line) {
+			fmt.Fprintln(w, line)
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case line := <-ch:
+			if !match(line) {
+				continue
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -17,14 +17,15 @@ import (
 var done = make(chan bool)
 
 var options struct {
-	adminport string
-	kvaddrs   string
-	colocate  bool
-	logFile   string
-	auth      string
-	info      bool
-	debug     bool
-	trace     bool
+	adminport  string
+	kvaddrs    string
+	colocate   bool
+	logFile    string
+	auth       string
+	bucketAuth string
+	info       bool
+	debug      bool
+	trace      bool
 }
 
 func argParse() string {
@@ -38,6 +39,9 @@ func argParse() string {
 		"output logs to file default is stdout")
 	flag.StringVar(&options.auth, "auth", "",
 		"Auth user and password")
+	flag.StringVar(&options.bucketAuth, "bucketAuth", "",
+		"user:password for bucket connections, user can be empty for "+
+			"SASL buckets, default is to use cbauth")
 	flag.BoolVar(&options.info, "info", false,
 		"enable info level logging")
 	flag.BoolVar(&options.debug, "debug", false,
@@ -91,6 +95,14 @@ func main() {
 	config.SetValue("routerEndpointFactory", epfactory)
 	config.SetValue("colocate", options.colocate)
 	config.SetValue("adminport.listenAddr", options.adminport)
+	if options.bucketAuth != "" {
+		up := strings.SplitN(options.bucketAuth, ":", 2)
+		if len(up) != 2 {
+			log.Fatal("bucketAuth should be of the form user:password")
+		}
+		config.SetValue("username", up[0])
+		config.SetValue("password", up[1])
+	}
 
 	if !config["colocate"].Bool() {
 		log.Fatal("Only colocation policy is supported for now!")
@@ -105,10 +117,13 @@ func main() {
 func NewEndpointFactory(
 	cluster string, maxvbs int, econf c.Config) c.RouterEndpointFactory {
 
-	return func(topic, endpointType, addr string) (c.RouterEndpoint, error) {
+	return func(
+		topic, endpointType, addr, token string) (c.RouterEndpoint, error) {
+
 		switch endpointType {
 		case "dataport":
-			return dataport.NewRouterEndpoint(cluster, topic, addr, maxvbs, econf)
+			return dataport.NewRouterEndpoint(
+				cluster, topic, addr, token, maxvbs, econf)
 		default:
 			log.Fatal("Unknown endpoint type")
 		}
@@ -0,0 +1,224 @@
+// mutation sampler captures every Nth mutation received by a kv data
+// path, along with the keys evaluated for it by each engine, to answer
+// why a document is, or is not, in an index without debug logging.
+
+package projector
+
+import "encoding/json"
+import "net/http"
+import "strconv"
+import "sync"
+import "sync/atomic"
+import "time"
+
+import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+import c "github.com/couchbase/indexing/secondary/common"
+
+// MutationSample of a mutation, as projected by engines.
+type MutationSample struct {
+	Time    time.Time `json:"time"`
+	Vbucket uint16    `json:"vbucket"`
+	Seqno   uint64    `json:"seqno"`
+	Docid   string    `json:"docid"`
+	Opcode  string    `json:"opcode"`
+	// engine-uuid -> keys evaluated by engine, engines that did not
+	// project the mutation have no commands.
+	Engines map[string]*SampledKeys `json:"engines"`
+}
+
+// SampledKeys projected by an engine for a mutation, and the endpoints
+// they are routed to.
+type SampledKeys struct {
+	Commands  []string `json:"commands,omitempty"`
+	Keys      []string `json:"keys,omitempty"`
+	Oldkeys   []string `json:"oldkeys,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
+}
+
+// mutationSampler is shared by vbucket-routines of a kv data path, thread
+// safe.
+type mutationSampler struct {
+	every   uint64 // sample every Nth mutation
+	count   uint64 // mutations seen so far
+	mu      sync.Mutex
+	samples []*MutationSample // ring buffer
+	next    int               // index of next sample in ring buffer
+	sampled int64             // no. of mutations sampled
+}
+
+// newMutationSampler returns nil if sampling is disabled.
+func newMutationSampler(every, size int) *mutationSampler {
+	if every <= 0 || size <= 0 {
+		return nil
+	}
+	return &mutationSampler{
+		every:   uint64(every),
+		samples: make([]*MutationSample, 0, size),
+	}
+}
+
+// sample shall be called once for every mutation, returns whether the
+// mutation is to be recorded.
+func (ms *mutationSampler) sample() bool {
+	return (atomic.AddUint64(&ms.count, 1)-1)%ms.every == 0
+}
+
+// record mutation `m` on vbucket `vbno`, `data` is the per endpoint data
+// prepared by engines' TransformRoute().
+func (ms *mutationSampler) record(
+	vbno uint16, m *mc.UprEvent,
+	engines map[uint64]*Engine, data map[string]interface{}) {
+
+	sample := &MutationSample{
+		Time:    time.Now(),
+		Vbucket: vbno,
+		Seqno:   m.Seqno,
+		Docid:   string(m.Key),
+		Opcode:  mutationOpcode(m.Opcode),
+		Engines: make(map[string]*SampledKeys),
+	}
+	for uuid := range engines {
+		sample.Engines[strconv.FormatUint(uuid, 10)] = &SampledKeys{}
+	}
+	// same keys are routed to every endpoint of an engine, they are
+	// recorded from the first endpoint seen.
+	recordedFrom := make(map[uint64]string)
+	for raddr, v := range data {
+		dkv, ok := v.(*c.DataportKeyVersions)
+		if !ok || dkv.Kv == nil {
+			continue
+		}
+		kv := dkv.Kv
+		for i, uuid := range kv.Uuids {
+			keys, ok := sample.Engines[strconv.FormatUint(uuid, 10)]
+			if !ok {
+				continue
+			}
+			if !containsString(keys.Endpoints, raddr) {
+				keys.Endpoints = append(keys.Endpoints, raddr)
+			}
+			if from, ok := recordedFrom[uuid]; !ok {
+				recordedFrom[uuid] = raddr
+			} else if from != raddr {
+				continue
+			}
+			keys.Commands = append(keys.Commands, commandName(kv.Commands[i]))
+			keys.Keys = append(keys.Keys, string(kv.Keys[i]))
+			keys.Oldkeys = append(keys.Oldkeys, string(kv.Oldkeys[i]))
+		}
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if len(ms.samples) < cap(ms.samples) {
+		ms.samples = append(ms.samples, sample)
+	} else {
+		ms.samples[ms.next] = sample
+	}
+	ms.next = (ms.next + 1) % cap(ms.samples)
+	ms.sampled++
+}
+
+// recent samples, oldest first, filtered by `docid` unless it is empty.
+func (ms *mutationSampler) recent(docid string) []*MutationSample {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	samples := make([]*MutationSample, 0, len(ms.samples))
+	start := 0
+	if len(ms.samples) == cap(ms.samples) {
+		start = ms.next
+	}
+	for i := 0; i < len(ms.samples); i++ {
+		sample := ms.samples[(start+i)%len(ms.samples)]
+		if docid == "" || sample.Docid == docid {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+func (ms *mutationSampler) statistics() map[string]interface{} {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return map[string]interface{}{
+		"every":   float64(ms.every),
+		"size":    float64(cap(ms.samples)),
+		"sampled": float64(ms.sampled),
+	}
+}
+
+// handleMutationSamples responds with mutations recently sampled on a
+// feed, as JSON, by bucket. Query parameter `topic` is mandatory, `bucket`
+// and `docid` filter the samples to that bucket and document. Buckets
+// whose mutations are not sampled, when "mutationSampleEvery" is 0 for
+// the feed, have null samples.
+//
+// Samples carry document ids and keys, so the request must be made by a
+// cluster administrator.
+//
+// eg: curl -u Administrator:asdasd "http://localhost:9999/mutationsamples?topic=maintenance&docid=user::1"
+func (p *Projector) handleMutationSamples(w http.ResponseWriter, r *http.Request) {
+	if ok, err := c.IsAdminRequest(r); err != nil || !ok {
+		c.Errorf("%v mutationsamples: unauthorized request, %v\n", p.logPrefix, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	topic := query.Get("topic")
+	if topic == "" {
+		http.Error(w, "missing topic", http.StatusBadRequest)
+		return
+	}
+	feed, err := p.GetFeed(topic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := p.requestContext()
+	defer cancel()
+	samples, err := feed.MutationSamples(ctx, query.Get("bucket"), query.Get("docid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(samples)
+}
+
+func mutationOpcode(opcode mcd.CommandCode) string {
+	switch opcode {
+	case mcd.UPR_MUTATION:
+		return "mutation"
+	case mcd.UPR_DELETION:
+		return "deletion"
+	case mcd.UPR_EXPIRATION:
+		return "expiration"
+	}
+	return opcode.String()
+}
+
+func commandName(command byte) string {
+	switch command {
+	case c.Upsert:
+		return "upsert"
+	case c.Deletion:
+		return "deletion"
+	case c.UpsertDeletion:
+		return "upsertDeletion"
+	case c.Expiration:
+		return "expiration"
+	}
+	return strconv.Itoa(int(command))
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package projector
+
+import "reflect"
+import "testing"
+
+import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+import c "github.com/couchbase/indexing/secondary/common"
+
+func TestMutationSampler(t *testing.T) {
+	if newMutationSampler(0, 10) != nil {
+		t.Fatalf("expected sampling to be disabled")
+	}
+
+	ms := newMutationSampler(2, 3)
+	engines := map[uint64]*Engine{10: nil, 20: nil}
+	for seqno := uint64(1); seqno <= 10; seqno++ {
+		m := &mc.UprEvent{
+			Opcode: mcd.UPR_MUTATION, Seqno: seqno, Key: []byte("doc"),
+		}
+		if seqno == 9 {
+			m.Key = []byte("other")
+		}
+		kv := c.NewKeyVersions(seqno, m.Key, 2)
+		kv.AddUpsert(10, []byte(`["city"]`), []byte(`["town"]`))
+		data := map[string]interface{}{
+			"indexer1:9105": &c.DataportKeyVersions{Kv: kv},
+			"indexer2:9105": &c.DataportKeyVersions{Kv: kv},
+		}
+		if ms.sample() {
+			ms.record(5, m, engines, data)
+		}
+	}
+
+	// mutations 1, 3, 5, 7, 9 are sampled, of which the last 3 retained.
+	samples := ms.recent("")
+	if len(samples) != 3 || samples[0].Seqno != 5 || samples[2].Seqno != 9 {
+		t.Fatalf("unexpected samples %v", samples)
+	}
+	sample := samples[0]
+	if sample.Vbucket != 5 || sample.Docid != "doc" || sample.Opcode != "mutation" {
+		t.Fatalf("unexpected sample %+v", sample)
+	}
+	keys := sample.Engines["10"]
+	if !reflect.DeepEqual(keys.Commands, []string{"upsert"}) ||
+		!reflect.DeepEqual(keys.Keys, []string{`["city"]`}) ||
+		!reflect.DeepEqual(keys.Oldkeys, []string{`["town"]`}) ||
+		len(keys.Endpoints) != 2 {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+	if keys := sample.Engines["20"]; len(keys.Commands) != 0 {
+		t.Fatalf("expected no keys for engine 20, got %+v", keys)
+	}
+
+	if samples := ms.recent("other"); len(samples) != 1 || samples[0].Seqno != 9 {
+		t.Fatalf("unexpected samples %v", samples)
+	}
+	if stats := ms.statistics(); stats["sampled"] != float64(5) {
+		t.Fatalf("unexpected statistics %v", stats)
+	}
+}
@@ -2,11 +2,14 @@ package projector
 
 import "fmt"
 import "sync"
+import "time"
+import "context"
 import "strings"
 import "encoding/json"
 
 import ap "github.com/couchbase/indexing/secondary/adminport"
 import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/dcp"
 import projC "github.com/couchbase/indexing/secondary/projector/client"
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
 import "github.com/couchbaselabs/goprotobuf/proto"
@@ -15,9 +18,15 @@ import "github.com/couchbaselabs/goprotobuf/proto"
 // one or more upstream kv-nodes. Works in tandem with
 // projector's adminport.
 type Projector struct {
-	mu     sync.RWMutex
-	admind ap.Server        // admin-port server
-	topics map[string]*Feed // active topics
+	mu      sync.RWMutex
+	admind  ap.Server        // admin-port server
+	topics  map[string]*Feed // active topics
+	probes  map[string]bool  // topics being probed, see doProbe()
+	logtail *c.LogRing       // recent log messages, nil if disabled
+	sampler *resourceSampler // nil if resource sampling is disabled
+	// events, control path events of topics, retained across feeds of a
+	// topic and after its shutdown.
+	events map[string]*eventLog // topic -> event log
 
 	// config params
 	name        string // human readable name of the projector
@@ -35,6 +44,8 @@ func NewProjector(maxvbs int, config c.Config) *Projector {
 		name:        config["name"].String(),
 		clusterAddr: config["clusterAddr"].String(),
 		topics:      make(map[string]*Feed),
+		probes:      make(map[string]bool),
+		events:      make(map[string]*eventLog),
 		maxvbs:      maxvbs,
 		adminport:   config["adminport.listenAddr"].String(),
 		config:      config,
@@ -45,18 +56,32 @@ func NewProjector(maxvbs int, config c.Config) *Projector {
 	}
 	p.logPrefix = fmt.Sprintf("PROJ[%s]", p.adminport)
 
+	if size := config["logTailSize"].Int(); size > 0 {
+		p.logtail = c.NewLogRing(size)
+		c.SetLogTail(p.logtail)
+	}
+
+	if interval := config["resourceSampleInterval"].Int(); interval > 0 {
+		p.sampler = newResourceSampler()
+		go p.sampleResources(time.Duration(interval) * time.Millisecond)
+	}
+
 	apConfig := config.SectionConfig("adminport.", true)
 	apConfig.SetValue("name", "PRAM")
 	reqch := make(chan ap.Request)
 	p.admind = ap.NewHTTPServer(apConfig, reqch)
 
+	if err := validateFeedConfig(p.feedConfig()); err != nil {
+		c.Errorf("%v invalid feed settings, topics will fail: %v\n", p.logPrefix, err)
+	}
+
 	go p.mainAdminPort(reqch)
 	c.Infof("%v started ...\n", p.logPrefix)
 	return p
 }
 
 // GetFeed object for `topic`.
-// - return ErrorTopicMissing if topic is not started.
+// - return ErrorTopicMissing if topic is not started, uncounted.
 func (p *Projector) GetFeed(topic string) (*Feed, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
@@ -64,17 +89,32 @@ func (p *Projector) GetFeed(topic string) (*Feed, error) {
 	if feed, ok := p.topics[topic]; ok {
 		return feed, nil
 	}
-	return nil, projC.ErrorTopicMissing
+	return nil, c.WrapError(projC.ErrorTopicMissing, "topic", topic)
+}
+
+// getStartedFeed is GetFeed for requests that expect `topic` to be
+// started, a missing topic is counted. Requests that clean up a topic
+// expect it to be missing at times, like the indexer retrying them, and
+// do not count it.
+func (p *Projector) getStartedFeed(topic string) (*Feed, error) {
+	feed, err := p.GetFeed(topic)
+	return feed, c.CountError(err)
 }
 
 // AddFeed object for `topic`.
-// - return ErrorTopicExist if topic is duplicate.
+// - return ErrorTopicExist if topic is duplicate or being probed.
+// - return ErrorTooManyTopics if "maxTopics" topics are already started.
 func (p *Projector) AddFeed(topic string, feed *Feed) (err error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if _, ok := p.topics[topic]; ok {
-		return projC.ErrorTopicExist
+	if _, ok := p.topics[topic]; ok || p.probes[topic] {
+		return c.CountError(c.WrapError(projC.ErrorTopicExist, "topic", topic))
+	}
+	if max := p.config["maxTopics"].Int(); max > 0 && len(p.topics) >= max {
+		fmsg := "%v topic %q: exceeds %v topics\n"
+		c.Errorf(fmsg, p.logPrefix, topic, max)
+		return c.CountError(projC.ErrorTooManyTopics)
 	}
 	p.topics[topic] = feed
 	c.Infof("%v %q feed added ...\n", p.logPrefix, topic)
@@ -88,7 +128,7 @@ func (p *Projector) DelFeed(topic string) (err error) {
 	defer p.mu.Unlock()
 
 	if _, ok := p.topics[topic]; ok == false {
-		return projC.ErrorTopicMissing
+		return c.WrapError(projC.ErrorTopicMissing, "topic", topic)
 	}
 	delete(p.topics, topic)
 	c.Infof("%v ... %q feed deleted\n", p.logPrefix, topic)
@@ -109,7 +149,7 @@ func (p *Projector) doVbmapRequest(
 	kvaddrs := request.GetKvaddrs()
 
 	// get vbmap from bucket connection.
-	bucket, err := c.ConnectBucket(p.clusterAddr, pooln, bucketn)
+	bucket, err := p.connectBucket(pooln, bucketn)
 	if err != nil {
 		c.Errorf("%v for bucket %q, %v\n", p.logPrefix, bucketn, err)
 		response.Err = protobuf.NewError(err)
@@ -147,7 +187,7 @@ func (p *Projector) doFailoverLog(
 	bucketn := request.GetBucket()
 	vbuckets := request.GetVbnos()
 
-	bucket, err := c.ConnectBucket(p.clusterAddr, pooln, bucketn)
+	bucket, err := p.connectBucket(pooln, bucketn)
 	if err != nil {
 		c.Errorf("%v %s, %v\n", p.logPrefix, bucketn, err)
 		response.Err = protobuf.NewError(err)
@@ -181,6 +221,11 @@ func (p *Projector) doFailoverLog(
 	return response
 }
 
+// A feed created for the request is dropped if the request fails.
+// - return ErrorTooManyTopics if "maxTopics" topics are already started.
+// - return ErrorInvalidFeedConfig for malformed feed settings.
+// - return ErrorTooManyBuckets if "maxBucketsPerTopic" is exceeded.
+// - return ErrorTooManyEngines if "maxEnginesPerBucket" is exceeded.
 // - return ErrorInvalidKVaddrs for malformed vbuuid.
 // - return ErrorInconsistentFeed for malformed feed request.
 // - return ErrorInvalidVbucketBranch for malformed vbuuid.
@@ -191,31 +236,52 @@ func (p *Projector) doMutationTopic(
 
 	c.Tracef("%v doMutationTopic()\n", p.logPrefix)
 	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
 
-	config, _ := c.NewConfig(map[string]interface{}{})
-	config.SetValue("maxVbuckets", p.maxvbs)
-	config.Set("clusterAddr", p.config["clusterAddr"])
-	config.Set("feedWaitStreamReqTimeout", p.config["feedWaitStreamReqTimeout"])
-	config.Set("feedWaitStreamEndTimeout", p.config["feedWaitStreamEndTimeout"])
-	config.Set("feedChanSize", p.config["feedChanSize"])
-	config.Set("mutationChanSize", p.config["mutationChanSize"])
-	config.Set("vbucketSyncTimeout", p.config["vbucketSyncTimeout"])
-	config.Set("routerEndpointFactory", p.config["routerEndpointFactory"])
+	feed, created, err := p.getOrNewFeed(topic, request.GetConfig())
+	if err != nil {
+		return (&protobuf.TopicResponse{}).SetErr(err)
+	}
+	response, err := feed.MutationTopic(ctx, request)
+	if err != nil {
+		if created {
+			p.dropNewFeed(topic, feed)
+		}
+		response.SetErr(err)
+	}
+	return response
+}
 
-	var err error
+// A feed created for the request is dropped if the request fails.
+// - return ErrorTooManyTopics if "maxTopics" topics are already started.
+// - return ErrorInvalidFeedConfig for malformed feed settings.
+// - return ErrorTooManyBuckets if "maxBucketsPerTopic" is exceeded.
+// - return ErrorTooManyEngines if "maxEnginesPerBucket" is exceeded.
+// - return ErrorInconsistentFeed for malformed feed request.
+// - return ErrorInconsistentFeed if a bucket has no end-timestamp.
+// - return ErrorInvalidVbucketBranch for malformed vbuuid.
+// - return dcp-client failures.
+// - return ErrorResponseTimeout if request is not completed within timeout.
+func (p *Projector) doCatchupTopic(
+	request *protobuf.CatchupTopicRequest) ap.MessageMarshaller {
 
-	feed, _ := p.GetFeed(topic)
-	if feed == nil {
-		feed, err = NewFeed(topic, config)
-		if err != nil {
-			return (&protobuf.TopicResponse{}).SetErr(err)
-		}
+	c.Tracef("%v doCatchupTopic()\n", p.logPrefix)
+	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
+
+	feed, created, err := p.getOrNewFeed(topic, request.GetConfig())
+	if err != nil {
+		return (&protobuf.TopicResponse{}).SetErr(err)
 	}
-	response, err := feed.MutationTopic(request)
+	response, err := feed.CatchupTopic(ctx, request)
 	if err != nil {
+		if created {
+			p.dropNewFeed(topic, feed)
+		}
 		response.SetErr(err)
 	}
-	p.AddFeed(topic, feed)
 	return response
 }
 
@@ -229,18 +295,20 @@ func (p *Projector) doRestartVbuckets(
 
 	c.Tracef("%v doRestartVbuckets()\n", p.logPrefix)
 	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
 
-	feed, err := p.GetFeed(topic) // only existing feed
+	feed, err := p.getStartedFeed(topic) // only existing feed
 	if err != nil {
 		c.Errorf("%v %v\n", p.logPrefix, err)
 		response := &protobuf.TopicResponse{}
-		if err != projC.ErrorTopicMissing {
-			response = feed.GetTopicResponse()
+		if !c.IsError(err, projC.ErrorTopicMissing) {
+			response = feed.GetTopicResponse(ctx)
 		}
 		return response.SetErr(err)
 	}
 
-	response, err := feed.RestartVbuckets(request)
+	response, err := feed.RestartVbuckets(ctx, request)
 	if err == nil {
 		return response
 	}
@@ -257,18 +325,22 @@ func (p *Projector) doShutdownVbuckets(
 
 	c.Tracef("%v doShutdownVbuckets()\n", p.logPrefix)
 	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
 
-	feed, err := p.GetFeed(topic) // only existing feed
+	feed, err := p.getStartedFeed(topic) // only existing feed
 	if err != nil {
 		c.Errorf("%v %v\n", p.logPrefix, err)
 		return protobuf.NewError(err)
 	}
 
-	err = feed.ShutdownVbuckets(request)
+	err = feed.ShutdownVbuckets(ctx, request)
 	return protobuf.NewError(err)
 }
 
 // - return ErrorTopicMissing if feed is not started.
+// - return ErrorTooManyBuckets if "maxBucketsPerTopic" is exceeded.
+// - return ErrorTooManyEngines if "maxEnginesPerBucket" is exceeded.
 // - return ErrorInconsistentFeed for malformed feed request
 // - return ErrorInvalidVbucketBranch for malformed vbuuid.
 // - return dcp-client failures.
@@ -278,25 +350,27 @@ func (p *Projector) doAddBuckets(
 
 	c.Tracef("%v doAddBuckets()\n", p.logPrefix)
 	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
 
-	feed, err := p.GetFeed(topic) // only existing feed
+	feed, err := p.getStartedFeed(topic) // only existing feed
 	if err != nil {
 		c.Errorf("%v %v\n", p.logPrefix, err)
 		response := &protobuf.TopicResponse{}
-		if err != projC.ErrorTopicMissing {
-			response = feed.GetTopicResponse()
+		if !c.IsError(err, projC.ErrorTopicMissing) {
+			response = feed.GetTopicResponse(ctx)
 		}
 		return response.SetErr(err)
 	}
 
-	response, err := feed.AddBuckets(request)
+	response, err := feed.AddBuckets(ctx, request)
 	if err == nil {
 		return response
 	}
 	return response.SetErr(err)
 }
 
-// - return ErrorTopicMissing if feed is not started.
+// - return ErrorTopicMissing if feed is not started, uncounted.
 // - return ErrorInvalidBucket if bucket is not added.
 // - return ErrorInvalidVbucketBranch for malformed vbuuid.
 // - return dcp-client failures.
@@ -306,6 +380,8 @@ func (p *Projector) doDelBuckets(
 
 	c.Tracef("%v doDelBuckets()\n", p.logPrefix)
 	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
 
 	feed, err := p.GetFeed(topic) // only existing feed
 	if err != nil {
@@ -313,7 +389,7 @@ func (p *Projector) doDelBuckets(
 		return protobuf.NewError(err)
 	}
 
-	err = feed.DelBuckets(request)
+	err = feed.DelBuckets(ctx, request)
 	return protobuf.NewError(err)
 }
 
@@ -325,24 +401,28 @@ func (p *Projector) doAddInstances(
 
 	c.Tracef("%v doAddInstances()\n", p.logPrefix)
 	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
 
-	feed, err := p.GetFeed(topic) // only existing feed
+	feed, err := p.getStartedFeed(topic) // only existing feed
 	if err != nil {
 		c.Errorf("%v %v\n", p.logPrefix, err)
 		return protobuf.NewError(err)
 	}
 
-	err = feed.AddInstances(request)
+	err = feed.AddInstances(ctx, request)
 	return protobuf.NewError(err)
 }
 
-// - return ErrorTopicMissing if feed is not started.
+// - return ErrorTopicMissing if feed is not started, uncounted.
 // - otherwise, error is empty string.
 func (p *Projector) doDelInstances(
 	request *protobuf.DelInstancesRequest) ap.MessageMarshaller {
 
 	c.Tracef("%v doDelInstances()\n", p.logPrefix)
 	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
 
 	feed, err := p.GetFeed(topic) // only existing feed
 	if err != nil {
@@ -350,35 +430,154 @@ func (p *Projector) doDelInstances(
 		return protobuf.NewError(err)
 	}
 
-	err = feed.DelInstances(request)
+	err = feed.DelInstances(ctx, request)
 	return protobuf.NewError(err)
 }
 
 // - return ErrorTopicMissing if feed is not started.
+// - return ErrorInvalidInstance if an instance is not defined on the feed.
 // - otherwise, error is empty string.
+func (p *Projector) doDisableInstances(
+	request *protobuf.DisableInstancesRequest) ap.MessageMarshaller {
+
+	c.Tracef("%v doDisableInstances()\n", p.logPrefix)
+	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
+
+	feed, err := p.getStartedFeed(topic) // only existing feed
+	if err != nil {
+		c.Errorf("%v %v\n", p.logPrefix, err)
+		return protobuf.NewError(err)
+	}
+
+	err = feed.DisableInstances(ctx, request)
+	return protobuf.NewError(err)
+}
+
+// - return ErrorTopicMissing if feed is not started.
+// - return ErrorInvalidInstance if an instance is not defined on the feed.
+// - otherwise, error is empty string.
+func (p *Projector) doEnableInstances(
+	request *protobuf.EnableInstancesRequest) ap.MessageMarshaller {
+
+	c.Tracef("%v doEnableInstances()\n", p.logPrefix)
+	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
+
+	feed, err := p.getStartedFeed(topic) // only existing feed
+	if err != nil {
+		c.Errorf("%v %v\n", p.logPrefix, err)
+		return protobuf.NewError(err)
+	}
+
+	err = feed.EnableInstances(ctx, request)
+	return protobuf.NewError(err)
+}
+
+// - return ErrorTopicMissing if feed is not started.
+// - otherwise, error is empty string.
+func (p *Projector) doPauseTopic(
+	request *protobuf.PauseTopicRequest) ap.MessageMarshaller {
+
+	c.Tracef("%v doPauseTopic()\n", p.logPrefix)
+	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
+
+	feed, err := p.getStartedFeed(topic) // only existing feed
+	if err != nil {
+		c.Errorf("%v %v\n", p.logPrefix, err)
+		return protobuf.NewError(err)
+	}
+
+	err = feed.PauseTopic(ctx, request)
+	return protobuf.NewError(err)
+}
+
+// - return ErrorTopicMissing if feed is not started.
+// - otherwise, error is empty string.
+func (p *Projector) doResumeTopic(
+	request *protobuf.ResumeTopicRequest) ap.MessageMarshaller {
+
+	c.Tracef("%v doResumeTopic()\n", p.logPrefix)
+	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
+
+	feed, err := p.getStartedFeed(topic) // only existing feed
+	if err != nil {
+		c.Errorf("%v %v\n", p.logPrefix, err)
+		return protobuf.NewError(err)
+	}
+
+	err = feed.ResumeTopic(ctx, request)
+	return protobuf.NewError(err)
+}
+
+// - return ErrorTopicMissing if feed is not started.
+// - otherwise, outcome of each endpoint is set in response.
+// - older clients, that did not ask for results, get Error message.
 func (p *Projector) doRepairEndpoints(
 	request *protobuf.RepairEndpointsRequest) ap.MessageMarshaller {
 
 	c.Tracef("%v doRepairEndpoints()\n", p.logPrefix)
 	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
 
-	feed, err := p.GetFeed(topic) // only existing feed
+	feed, err := p.getStartedFeed(topic) // only existing feed
 	if err != nil {
 		c.Errorf("%v %v\n", p.logPrefix, err)
-		return protobuf.NewError(err)
+		if !request.GetResults() {
+			return protobuf.NewError(err)
+		}
+		return protobuf.NewRepairEndpointsResponse().SetErr(err)
 	}
 
-	err = feed.RepairEndpoints(request)
-	return protobuf.NewError(err)
+	response, err := feed.RepairEndpoints(ctx, request)
+	if !request.GetResults() {
+		return protobuf.NewError(err)
+	} else if err != nil {
+		return protobuf.NewRepairEndpointsResponse().SetErr(err)
+	}
+	return response
 }
 
 // - return ErrorTopicMissing if feed is not started.
+// - otherwise, each operation's error is set in response.
+func (p *Projector) doTopicOperations(
+	request *protobuf.TopicOperationsRequest) ap.MessageMarshaller {
+
+	c.Tracef("%v doTopicOperations()\n", p.logPrefix)
+	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
+
+	feed, err := p.getStartedFeed(topic) // only existing feed
+	if err != nil {
+		c.Errorf("%v %v\n", p.logPrefix, err)
+		return (&protobuf.TopicOperationsResponse{}).SetErr(err)
+	}
+
+	response, err := feed.TopicOperations(ctx, request)
+	if err == nil {
+		return response
+	}
+	return response.SetErr(err)
+}
+
+// - return ErrorTopicMissing if feed is not started, uncounted.
+// - return ErrorRequestTimeout if feed does not shutdown in time.
 // - otherwise, error is empty string.
 func (p *Projector) doShutdownTopic(
 	request *protobuf.ShutdownTopicRequest) ap.MessageMarshaller {
 
 	c.Tracef("%v doShutdownTopic()\n", p.logPrefix)
 	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
 
 	feed, err := p.GetFeed(topic) // only existing feed
 	if err != nil {
@@ -386,25 +585,88 @@ func (p *Projector) doShutdownTopic(
 		return protobuf.NewError(err)
 	}
 
+	// topic is forgotten only after its feed has shutdown, a feed that did
+	// not respond in time can be shutdown again.
+	if err = feed.Shutdown(ctx); err != nil && err != projC.ErrorFeedClosed {
+		c.Errorf("%v %v\n", p.logPrefix, err)
+		return protobuf.NewError(err)
+	}
 	p.DelFeed(topic)
-	feed.Shutdown()
-	return protobuf.NewError(err)
+	return protobuf.NewError(nil)
+}
+
+// doProbe checks bucket and endpoint connectivity with a short lived feed
+// that is not registered as a topic.
+// - return ErrorTopicExist if topic is already started or being probed.
+// - return ErrorFeeder if DCP connection cannot be opened.
+// - return ErrorInvalidVbucket for missing or invalid failover logs.
+// - return ErrorEndpoint if endpoint cannot be connected.
+// - otherwise, diagnostics are set in response.
+func (p *Projector) doProbe(
+	request *protobuf.ProbeRequest) ap.MessageMarshaller {
+
+	c.Tracef("%v doProbe()\n", p.logPrefix)
+	topic := request.GetTopic()
+	ctx, cancel := p.requestContext()
+	defer cancel()
+
+	// connection names derive from topic, don't share it with a feed or
+	// with another probe.
+	p.mu.Lock()
+	if _, ok := p.topics[topic]; ok || p.probes[topic] {
+		p.mu.Unlock()
+		err := c.CountError(c.WrapError(projC.ErrorTopicExist, "topic", topic))
+		c.Errorf("%v %v\n", p.logPrefix, err)
+		return (&protobuf.ProbeResponse{}).SetErr(err)
+	}
+	p.probes[topic] = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.probes, topic)
+		p.mu.Unlock()
+	}()
+
+	feed, err := NewFeed(topic, p.feedConfig())
+	if err != nil {
+		return (&protobuf.ProbeResponse{}).SetErr(err)
+	}
+	// ctx might have expired by the time probe returns.
+	defer p.shutdownFeed(feed)
+
+	response, err := feed.Probe(ctx, request)
+	if err == nil {
+		return response
+	}
+	return response.SetErr(err)
 }
 
 func (p *Projector) doStatistics() interface{} {
 	c.Tracef("%v doStatistics()\n", p.logPrefix)
+	ctx, cancel := p.requestContext()
+	defer cancel()
 
 	m := map[string]interface{}{
-		"clusterAddr": p.clusterAddr,
+		"clusterAddr": c.RedactURL(p.clusterAddr),
 		"adminport":   p.adminport,
+		"topics":      float64(p.numTopics()),
+		"maxTopics":   float64(p.config["maxTopics"].Int()),
 	}
 	stats, _ := c.NewStatistics(m)
 
 	feeds, _ := c.NewStatistics(nil)
 	for topic, feed := range p.topics {
-		feeds.Set(topic, feed.GetStatistics())
+		feeds.Set(topic, feed.GetStatistics(ctx))
 	}
 	stats.Set("feeds", feeds)
+	errCounts := make(map[string]interface{})
+	for name, n := range c.ErrorCounts() {
+		errCounts[name] = float64(n)
+	}
+	stats.Set("errors", errCounts)
+	if p.sampler != nil {
+		stats.Set("resources", p.sampler.statistics())
+	}
 	data, err := json.Marshal(stats)
 	if err != nil {
 		c.Errorf("%v encoding statistics: %v\n", p.logPrefix, err)
@@ -412,6 +674,185 @@ func (p *Projector) doStatistics() interface{} {
 	return string(data)
 }
 
+// getOrNewFeed returns the feed for topic, a new feed is created if topic
+// is not started, `data` is JSON encoded settings overriding projector's
+// settings for the new feed. A new feed is added to topics before it is
+// returned, with `created` as true, so that concurrent requests for the
+// topic share the feed.
+// - return ErrorTooManyTopics if "maxTopics" topics are already started.
+// - return ErrorInvalidFeedConfig for malformed feed settings.
+func (p *Projector) getOrNewFeed(
+	topic string, data []byte) (feed *Feed, created bool, err error) {
+
+	// a missing topic is not an error here, look it up without GetFeed().
+	p.mu.RLock()
+	feed, ok := p.topics[topic]
+	p.mu.RUnlock()
+	if ok {
+		return feed, false, nil
+	}
+	config := p.feedConfig()
+	if err := overrideFeedConfig(config, data); err != nil {
+		c.Errorf("%v topic %q: %v\n", p.logPrefix, topic, err)
+		return nil, false, c.CountError(projC.ErrorInvalidFeedConfig)
+	}
+	config.Set("eventLog", c.ConfigValue{
+		Value: p.topicEvents(topic),
+		Help:  "event log shared by feeds of the topic",
+	})
+	if feed, err = NewFeed(topic, config); err != nil {
+		return nil, false, err
+	}
+	for {
+		if err = p.AddFeed(topic, feed); err == nil {
+			return feed, true, nil
+		}
+		if !c.IsError(err, projC.ErrorTopicExist) {
+			p.shutdownFeed(feed)
+			return nil, false, err
+		}
+		// lost the race with another request for topic, use its feed
+		// unless it is deleted meanwhile.
+		p.mu.RLock()
+		other, ok := p.topics[topic]
+		probing := p.probes[topic]
+		p.mu.RUnlock()
+		if ok {
+			p.shutdownFeed(feed)
+			return other, false, nil
+		} else if probing {
+			p.shutdownFeed(feed)
+			return nil, false, err
+		}
+	}
+}
+
+// dropNewFeed shuts down a feed created by getOrNewFeed() whose first
+// request failed, and deletes its topic unless the topic is now served by
+// another feed.
+func (p *Projector) dropNewFeed(topic string, feed *Feed) {
+	p.shutdownFeed(feed)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.topics[topic] == feed {
+		delete(p.topics, topic)
+		c.Infof("%v ... %q feed deleted\n", p.logPrefix, topic)
+	}
+}
+
+// shutdownFeed with a context of its own, the request that started the
+// feed might have expired already.
+func (p *Projector) shutdownFeed(feed *Feed) {
+	ctx, cancel := p.requestContext()
+	defer cancel()
+	if err := feed.Shutdown(ctx); err != nil {
+		c.Errorf("%v shutdown feed %q: %v\n", p.logPrefix, feed.topic, err)
+	}
+}
+
+// topicEvents returns the event log of topic, created on first use. Nil
+// if "feedEventLogSize" is 0.
+func (p *Projector) topicEvents(topic string) *eventLog {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	el, ok := p.events[topic]
+	if !ok {
+		el = newEventLog(p.config["feedEventLogSize"].Int())
+		if el != nil {
+			p.events[topic] = el
+		}
+	}
+	return el
+}
+
+// feedConfig returns projector's settings that apply to its feeds.
+func (p *Projector) feedConfig() c.Config {
+	config, _ := c.NewConfig(map[string]interface{}{})
+	config.SetValue("maxVbuckets", p.maxvbs)
+	config.Set("clusterAddr", p.config["clusterAddr"])
+	config.Set("username", p.config["username"])
+	config.Set("password", p.config["password"])
+	config.Set("dcp.tls.enabled", p.config["dcp.tls.enabled"])
+	config.Set("dcp.tls.port", p.config["dcp.tls.port"])
+	config.Set("dcp.tls.caFile", p.config["dcp.tls.caFile"])
+	config.Set("dcp.tls.insecureSkipVerify", p.config["dcp.tls.insecureSkipVerify"])
+	config.Set("feedWaitStreamReqTimeout", p.config["feedWaitStreamReqTimeout"])
+	config.Set("feedWaitStreamEndTimeout", p.config["feedWaitStreamEndTimeout"])
+	config.Set("staleFeedbackTimeout", p.config["staleFeedbackTimeout"])
+	config.Set("feedRetryInterval", p.config["feedRetryInterval"])
+	config.Set("feedRetryMaxInterval", p.config["feedRetryMaxInterval"])
+	config.Set("feedRetryBudget", p.config["feedRetryBudget"])
+	config.Set("feedStreamReqBatchSize", p.config["feedStreamReqBatchSize"])
+	config.Set("feedStreamReqBatchInterval", p.config["feedStreamReqBatchInterval"])
+	config.Set("feedChanSize", p.config["feedChanSize"])
+	config.Set("feedChanMinSize", p.config["feedChanMinSize"])
+	config.Set("mutationChanSize", p.config["mutationChanSize"])
+	config.Set("mutationChanMinSize", p.config["mutationChanMinSize"])
+	config.Set("vbucketSyncTimeout", p.config["vbucketSyncTimeout"])
+	config.Set("routingAuditSamples", p.config["routingAuditSamples"])
+	config.Set("routingAuditPeriod", p.config["routingAuditPeriod"])
+	config.Set("routerEndpointFactory", p.config["routerEndpointFactory"])
+	config.Set("maxBucketsPerTopic", p.config["maxBucketsPerTopic"])
+	config.Set("maxEnginesPerBucket", p.config["maxEnginesPerBucket"])
+	config.Set("feedEventLogSize", p.config["feedEventLogSize"])
+	if kv, ok := p.config["kvConnector"]; ok {
+		config.Set("kvConnector", kv)
+	}
+	return config
+}
+
+// requestContext returns the context for synchronous requests posted to
+// feeds, expiring after "feedRequestTimeout" milliseconds.
+func (p *Projector) requestContext() (context.Context, context.CancelFunc) {
+	timeout := p.config["feedRequestTimeout"].Int()
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(
+		context.Background(), time.Duration(timeout)*time.Millisecond)
+}
+
+// connectBucket to bucket with configured credentials, falling back to
+// cbauth, over TLS if configured.
+func (p *Projector) connectBucket(
+	pooln, bucketn string) (*couchbase.Bucket, error) {
+
+	ah := c.NewAuthHandler(
+		p.clusterAddr, bucketn,
+		p.config["username"].String(), p.config["password"].Secret())
+	tlsConfig, port, err := c.NewKVTLSConfig(p.config)
+	if err != nil {
+		return nil, err
+	}
+	return c.ConnectBucketWithTLS(
+		p.clusterAddr, pooln, bucketn, ah, tlsConfig, port)
+}
+
+// sampleResources periodically, attributing process cpu to active topics.
+func (p *Projector) sampleResources(interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for range tick.C {
+		p.mu.RLock()
+		topics := make(map[string]*topicResources)
+		for topic, feed := range p.topics {
+			topics[topic] = feed.resources
+		}
+		p.mu.RUnlock()
+		p.sampler.sample(topics)
+	}
+}
+
+// return number of active topics
+func (p *Projector) numTopics() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.topics)
+}
+
 // return list of active topics
 func (p *Projector) listTopics() []string {
 	topics := make([]string, 0, len(p.topics))
@@ -420,3 +861,64 @@ func (p *Projector) listTopics() []string {
 	}
 	return topics
 }
+
+// feed settings that can be overridden by a topic request, with the
+// minimum value allowed for each of them.
+var feedConfigOverrides = map[string]int{
+	"feedChanSize":               1,
+	"feedChanMinSize":            1,
+	"mutationChanSize":           1,
+	"mutationChanMinSize":        1,
+	"vbucketSyncTimeout":         1,
+	"routingAuditSamples":        0,
+	"routingAuditPeriod":         1,
+	"feedWaitStreamReqTimeout":   1,
+	"feedWaitStreamEndTimeout":   1,
+	"feedRetryBudget":            0,
+	"feedStreamReqBatchSize":     0,
+	"feedStreamReqBatchInterval": 0,
+}
+
+// feed settings that shall not exceed another setting, when overridden.
+var feedConfigLimits = map[string]string{
+	"feedChanMinSize":     "feedChanSize",
+	"mutationChanMinSize": "mutationChanSize",
+	"routingAuditSamples": "routingAuditPeriod",
+}
+
+// apply JSON encoded settings from topic request on feed's config.
+func overrideFeedConfig(config c.Config, data []byte) error {
+	if len(data) == 0 {
+		return validateFeedConfig(config)
+	}
+	overrides := make(map[string]interface{})
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return err
+	}
+	for key, value := range overrides {
+		if _, ok := feedConfigOverrides[key]; !ok {
+			return fmt.Errorf("feed setting %q cannot be overridden", key)
+		}
+		if err := config.SetValue(key, value); err != nil {
+			return err
+		}
+	}
+	return validateFeedConfig(config)
+}
+
+// validateFeedConfig checks feed settings against their minimum values
+// and their limits.
+func validateFeedConfig(config c.Config) error {
+	for key, min := range feedConfigOverrides {
+		if val := config[key].Int(); val < min {
+			return fmt.Errorf("feed setting %q: %v is less than %v", key, val, min)
+		}
+	}
+	for key, limit := range feedConfigLimits {
+		if val, max := config[key].Int(), config[limit].Int(); val > max {
+			fmsg := "feed setting %q: %v exceeds %q %v"
+			return fmt.Errorf(fmsg, key, val, limit, max)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package projector
+
+import "errors"
+import "sync"
+import "testing"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/dcp"
+import projC "github.com/couchbase/indexing/secondary/projector/client"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+
+func TestOverrideFeedConfig(t *testing.T) {
+	newConfig := func() c.Config {
+		return c.SystemConfig.SectionConfig("projector.", true)
+	}
+
+	config := newConfig()
+	data := []byte(`{"feedChanSize": 200, "feedStreamReqBatchSize": 0}`)
+	if err := overrideFeedConfig(config, data); err != nil {
+		t.Fatal(err)
+	} else if size := config["feedChanSize"].Int(); size != 200 {
+		t.Errorf("expected feedChanSize 200, got %v", size)
+	}
+
+	invalids := []string{
+		`{"maxTopics": 10}`,
+		`{"feedChanSize": "large"}`,
+		`{"feedChanSize": 0}`,
+		`{"mutationChanSize": -1}`,
+		`{"vbucketSyncTimeout": 0}`,
+		`{"feedRetryBudget": -1}`,
+		`{"mutationChanMinSize": 20000}`,
+		`{"feedChanSize": 8, "feedChanMinSize": 16}`,
+		`{"routingAuditSamples": 10, "routingAuditPeriod": 5}`,
+	}
+	for _, data := range invalids {
+		if err := overrideFeedConfig(newConfig(), []byte(data)); err == nil {
+			t.Errorf("expected %s to be rejected", data)
+		}
+	}
+	// settings that are not overridden are validated as well.
+	config = newConfig()
+	config.SetValue("mutationChanMinSize", 0)
+	if err := overrideFeedConfig(config, nil); err == nil {
+		t.Errorf("expected mutationChanMinSize 0 to be rejected")
+	}
+	config = newConfig()
+	config.SetValue("feedChanMinSize", 200)
+	if err := overrideFeedConfig(config, []byte(`{"feedRetryBudget": 0}`)); err == nil {
+		t.Errorf("expected feedChanMinSize 200 to be rejected")
+	}
+}
+
+var errKVUnreachable = errors.New("kv unreachable")
+
+// unreachableKV fails every request, like a kv node that is down.
+type unreachableKV struct{}
+
+func (kv unreachableKV) GetLocalVbuckets(pooln, bucketn string) ([]uint16, error) {
+	return nil, errKVUnreachable
+}
+
+func (kv unreachableKV) GetFailoverLogs(
+	pooln, bucketn string,
+	vbnos []uint16) (couchbase.FailoverLog, string, error) {
+
+	return nil, "", errKVUnreachable
+}
+
+func (kv unreachableKV) OpenBucketFeed(
+	pooln, bucketn, feedname string) (BucketFeeder, error) {
+
+	return nil, errKVUnreachable
+}
+
+func newTestProjector(maxTopics int) *Projector {
+	config := c.SystemConfig.SectionConfig("projector.", true)
+	config.SetValue("maxTopics", maxTopics)
+	config.Set("kvConnector", c.ConfigValue{
+		Value: unreachableKV{},
+		Help:  "fake KV cluster to start upstream with",
+	})
+	return &Projector{
+		topics: make(map[string]*Feed),
+		probes: make(map[string]bool),
+		events: make(map[string]*eventLog),
+		maxvbs: 4,
+		config: config,
+	}
+}
+
+func TestProjectorAddFeedMaxTopics(t *testing.T) {
+	p := newTestProjector(2)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- p.AddFeed(string(rune('a'+i)), &Feed{})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	added := 0
+	for err := range errs {
+		if err == nil {
+			added++
+		} else if !c.IsError(err, projC.ErrorTooManyTopics) {
+			t.Errorf("expected %v, got %v", projC.ErrorTooManyTopics, err)
+		}
+	}
+	if added != 2 || p.numTopics() != 2 {
+		t.Fatalf("expected 2 topics, got %v added and %v topics", added, p.numTopics())
+	}
+	if err := p.AddFeed("a", &Feed{}); err == nil {
+		t.Fatalf("expected duplicate topic to be rejected")
+	}
+}
+
+func TestProjectorGetOrNewFeed(t *testing.T) {
+	p := newTestProjector(1)
+
+	var wg sync.WaitGroup
+	feeds := make([]*Feed, 4)
+	created := make([]bool, 4)
+	for i := range feeds {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			feed, ok, err := p.getOrNewFeed("topic", nil)
+			if err != nil {
+				t.Error(err)
+			}
+			feeds[i], created[i] = feed, ok
+		}(i)
+	}
+	wg.Wait()
+
+	feed, _ := p.GetFeed("topic")
+	n := 0
+	for i := range feeds {
+		if feeds[i] != feed {
+			t.Errorf("expected concurrent requests to share the feed of topic")
+		}
+		if created[i] {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("expected feed to be created once, got %v", n)
+	}
+
+	if _, _, err := p.getOrNewFeed("other", nil); !c.IsError(err, projC.ErrorTooManyTopics) {
+		t.Errorf("expected %v, got %v", projC.ErrorTooManyTopics, err)
+	}
+	p.shutdownFeed(feed)
+}
+
+func TestProjectorMutationTopicFailure(t *testing.T) {
+	p := newTestProjector(0)
+
+	instances := protobuf.ExampleIndexInstances(
+		[]string{"default"}, []string{"127.0.0.1:9020"}, "")
+	req := protobuf.NewMutationTopicRequest("topic", "dataport", instances)
+	reqTs := protobuf.NewTsVbuuid("default", "default", 4)
+	reqTs.Append(0, 10, 0x1, 0, 0)
+	req.Append(reqTs)
+
+	resp := p.doMutationTopic(req).(*protobuf.TopicResponse)
+	if resp.GetErr() == nil {
+		t.Fatalf("expected request to fail")
+	}
+	if _, err := p.GetFeed("topic"); !c.IsError(err, projC.ErrorTopicMissing) {
+		t.Fatalf("expected feed of failed request to be dropped, got %v", err)
+	}
+}
+
+func TestProjectorTopicMissingCount(t *testing.T) {
+	p := newTestProjector(0)
+
+	// requests that clean up a topic expect it to be gone.
+	n := projC.ErrorTopicMissing.Count()
+	p.doDelInstances(protobuf.NewDelInstancesRequest("topic", []uint64{1}))
+	p.doShutdownTopic(protobuf.NewShutdownTopicRequest("topic"))
+	if _, err := p.GetFeed("topic"); !c.IsError(err, projC.ErrorTopicMissing) {
+		t.Fatalf("expected %v, got %v", projC.ErrorTopicMissing, err)
+	}
+	if count := projC.ErrorTopicMissing.Count(); count != n {
+		t.Fatalf("expected %v missing topics, got %v", n, count)
+	}
+
+	resp := p.doPauseTopic(protobuf.NewPauseTopicRequest("topic")).(*protobuf.Error)
+	if !c.IsError(resp.ToError(), projC.ErrorTopicMissing) {
+		t.Fatalf("expected %v, got %v", projC.ErrorTopicMissing, resp.ToError())
+	}
+	if count := projC.ErrorTopicMissing.Count(); count != n+1 {
+		t.Fatalf("expected %v missing topics, got %v", n+1, count)
+	}
+}
+
+func TestProjectorCatchupTopicFailure(t *testing.T) {
+	p := newTestProjector(0)
+
+	instances := protobuf.ExampleIndexInstances(
+		[]string{"default"}, []string{"127.0.0.1:9020"}, "")
+	req := protobuf.NewCatchupTopicRequest("topic", "dataport", instances)
+	reqTs := protobuf.NewTsVbuuid("default", "default", 4)
+	reqTs.Append(0, 10, 0x1, 0, 0)
+	req.RestartTimestamps = append(req.RestartTimestamps, reqTs) // no end-timestamp
+
+	resp := p.doCatchupTopic(req).(*protobuf.TopicResponse)
+	if resp.GetErr() == nil {
+		t.Fatalf("expected request to fail")
+	}
+	if _, err := p.GetFeed("topic"); !c.IsError(err, projC.ErrorTopicMissing) {
+		t.Fatalf("expected feed of failed request to be dropped, got %v", err)
+	}
+
+	// malformed settings are rejected before a feed is created.
+	req.Config = []byte(`{"feedChanSize": 0}`)
+	resp = p.doCatchupTopic(req).(*protobuf.TopicResponse)
+	if err := resp.GetErr().ToError(); !c.IsError(err, projC.ErrorInvalidFeedConfig) {
+		t.Fatalf("expected %v, got %v", projC.ErrorInvalidFeedConfig, err)
+	}
+	if p.numTopics() != 0 {
+		t.Fatalf("expected no topics, got %v", p.numTopics())
+	}
+}
+
+func TestProjectorProbe(t *testing.T) {
+	p := newTestProjector(0)
+
+	req := protobuf.NewProbeRequest(
+		"topic", "dataport", "default", "default", "127.0.0.1:9020", nil)
+	resp := p.doProbe(req).(*protobuf.ProbeResponse)
+	if resp.GetErr() == nil {
+		t.Fatalf("expected probe against unreachable kv to fail")
+	}
+	if p.numTopics() != 0 || len(p.probes) != 0 {
+		t.Fatalf("expected probe to leave no topic behind")
+	}
+
+	// topics being probed are not shared with feeds, nor other probes.
+	p.probes["topic"] = true
+	if _, _, err := p.getOrNewFeed("topic", nil); !c.IsError(err, projC.ErrorTopicExist) {
+		t.Errorf("expected %v, got %v", projC.ErrorTopicExist, err)
+	}
+	resp = p.doProbe(req).(*protobuf.ProbeResponse)
+	if err := resp.GetErr().ToError(); !c.IsError(err, projC.ErrorTopicExist) {
+		t.Errorf("expected %v, got %v", projC.ErrorTopicExist, resp.GetErr())
+	}
+}
@@ -0,0 +1,136 @@
+package projector
+
+import "runtime"
+import "sync"
+import "sync/atomic"
+import "time"
+
+// topicResources account projector resources used by a topic's data path,
+// it is shared by all vbucket routines of the feed.
+//
+// Go does not expose cpu time per goroutine, instead vbucket routines
+// account the time they are busy handling events, and process cpu time,
+// sampled periodically by resourceSampler, is attributed to topics in
+// proportion to the time their routines were busy during the sample
+// period.
+type topicResources struct {
+	busyTime   int64 // nanoseconds spent handling events
+	events     int64 // no. of events handled
+	buffered   int64 // bytes of events queued on vbucket routines
+	peakBuffer int64 // peak value of buffered since feed started
+
+	mu       sync.Mutex // protects following fields, updated by sampler
+	lastBusy int64      // busyTime at last sample
+	cpuTime  int64      // nanoseconds of process cpu attributed to topic
+	cpuShare float64    // share of process cpu in the last sample period
+}
+
+func newTopicResources() *topicResources {
+	return &topicResources{}
+}
+
+// addBusy accounts time spent by a data path routine handling an event.
+func (tr *topicResources) addBusy(d time.Duration) {
+	atomic.AddInt64(&tr.busyTime, int64(d))
+	atomic.AddInt64(&tr.events, 1)
+}
+
+// addBuffered accounts bytes of events queued, negative delta when queued
+// events are handled.
+func (tr *topicResources) addBuffered(delta int64) {
+	buffered := atomic.AddInt64(&tr.buffered, delta)
+	for {
+		peak := atomic.LoadInt64(&tr.peakBuffer)
+		if buffered <= peak ||
+			atomic.CompareAndSwapInt64(&tr.peakBuffer, peak, buffered) {
+			return
+		}
+	}
+}
+
+// busySince last call, called only by resourceSampler.
+func (tr *topicResources) busySince() int64 {
+	busy := atomic.LoadInt64(&tr.busyTime)
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	delta := busy - tr.lastBusy
+	tr.lastBusy = busy
+	return delta
+}
+
+// attribute cpu time to topic.
+func (tr *topicResources) attribute(cpuTime time.Duration, share float64) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.cpuTime += int64(cpuTime)
+	tr.cpuShare = share
+}
+
+func (tr *topicResources) statistics() map[string]interface{} {
+	tr.mu.Lock()
+	cpuTime, cpuShare := tr.cpuTime, tr.cpuShare
+	tr.mu.Unlock()
+
+	return map[string]interface{}{
+		"busyTime":      float64(atomic.LoadInt64(&tr.busyTime)),
+		"events":        float64(atomic.LoadInt64(&tr.events)),
+		"cpuTime":       float64(cpuTime),
+		"cpuShare":      cpuShare,
+		"bufferedBytes": float64(atomic.LoadInt64(&tr.buffered)),
+		"peakBuffered":  float64(atomic.LoadInt64(&tr.peakBuffer)),
+	}
+}
+
+// resourceSampler periodically samples process cpu time and memory, and
+// attributes the cpu time used during each period to topics. Cpu used by
+// control path and endpoints is attributed along with that of data path.
+type resourceSampler struct {
+	mu        sync.Mutex
+	lastCPU   time.Duration // process cpu time at last sample
+	cpuTime   time.Duration // process cpu time used since first sample
+	heapInuse uint64
+	sysMemory uint64
+	samples   int64
+}
+
+func newResourceSampler() *resourceSampler {
+	return &resourceSampler{lastCPU: processCPUTime()}
+}
+
+// sample process resources and attribute cpu time to topics.
+func (rs *resourceSampler) sample(topics map[string]*topicResources) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	cpu := processCPUTime()
+
+	rs.mu.Lock()
+	delta := cpu - rs.lastCPU
+	rs.lastCPU, rs.cpuTime = cpu, rs.cpuTime+delta
+	rs.heapInuse, rs.sysMemory = ms.HeapInuse, ms.Sys
+	rs.samples++
+	rs.mu.Unlock()
+
+	busy, total := make(map[string]int64), int64(0)
+	for topic, tr := range topics {
+		busy[topic] = tr.busySince()
+		total += busy[topic]
+	}
+	for topic, tr := range topics {
+		share := 0.0
+		if total > 0 {
+			share = float64(busy[topic]) / float64(total)
+		}
+		tr.attribute(time.Duration(float64(delta)*share), share)
+	}
+}
+
+func (rs *resourceSampler) statistics() map[string]interface{} {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return map[string]interface{}{
+		"cpuTime":   float64(rs.cpuTime),
+		"heapInuse": float64(rs.heapInuse),
+		"sysMemory": float64(rs.sysMemory),
+		"samples":   float64(rs.samples),
+	}
+}
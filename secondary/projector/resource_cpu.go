@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package projector
+
+import "syscall"
+import "time"
+
+// processCPUTime returns user and system cpu time used by the process.
+func processCPUTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
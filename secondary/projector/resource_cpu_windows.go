@@ -0,0 +1,9 @@
+package projector
+
+import "time"
+
+// processCPUTime is not sampled on windows, topics are accounted only
+// for the time their routines are busy.
+func processCPUTime() time.Duration {
+	return 0
+}
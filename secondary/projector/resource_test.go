@@ -0,0 +1,38 @@
+package projector
+
+import "testing"
+import "time"
+
+func TestTopicResources(t *testing.T) {
+	tr := newTopicResources()
+	tr.addBuffered(100)
+	tr.addBuffered(50)
+	tr.addBuffered(-120)
+	stats := tr.statistics()
+	if stats["bufferedBytes"] != float64(30) || stats["peakBuffered"] != float64(150) {
+		t.Fatalf("unexpected buffer accounting %v", stats)
+	}
+
+	tr.addBusy(3 * time.Millisecond)
+	other := newTopicResources()
+	other.addBusy(time.Millisecond)
+
+	rs := newResourceSampler()
+	rs.sample(map[string]*topicResources{"topic1": tr, "topic2": other})
+	if share := tr.statistics()["cpuShare"]; share != 0.75 {
+		t.Fatalf("expected cpu share 0.75, got %v", share)
+	}
+	if share := other.statistics()["cpuShare"]; share != 0.25 {
+		t.Fatalf("expected cpu share 0.25, got %v", share)
+	}
+
+	// topics that were idle during the period get no share
+	other.addBusy(time.Millisecond)
+	rs.sample(map[string]*topicResources{"topic1": tr, "topic2": other})
+	if share := tr.statistics()["cpuShare"]; share != 0.0 {
+		t.Fatalf("expected cpu share 0, got %v", share)
+	}
+	if events := tr.statistics()["events"]; events != float64(1) {
+		t.Fatalf("unexpected events %v", events)
+	}
+}
@@ -39,10 +39,30 @@ type BucketFeeder interface {
 	// EndVbStreams ends an existing vbucket stream from this feed.
 	EndVbStreams(opaque uint16, endTs *protobuf.TsVbuuid) error
 
+	// GetStatistics returns DCP statistics for this feed.
+	GetStatistics() map[string]interface{}
+
 	// CloseFeed ends all active streams on this feed and free its resources.
 	CloseFeed() (err error)
 }
 
+// KVConnector interface used by feed to locate vbuckets on KV cluster and
+// to open upstream for them. By default feed connects with its cluster,
+// fakes can be supplied to test feed without a cluster, refer feedtest.
+type KVConnector interface {
+	// GetLocalVbuckets returns vbuckets of the bucket hosted by this node.
+	GetLocalVbuckets(pooln, bucketn string) ([]uint16, error)
+
+	// GetFailoverLogs returns the failover logs for specified vbuckets
+	// along with the bucket's uuid.
+	GetFailoverLogs(
+		pooln, bucketn string,
+		vbnos []uint16) (flogs couchbase.FailoverLog, uuid string, err error)
+
+	// OpenBucketFeed opens an upstream feed for the bucket.
+	OpenBucketFeed(pooln, bucketn, feedname string) (BucketFeeder, error)
+}
+
 // concrete type implementing BucketFeeder
 type bucketUpr struct {
 	uprFeed *couchbase.UprFeed
@@ -94,15 +114,32 @@ func (bupr *bucketUpr) StartVbStreams(
 func (bupr *bucketUpr) EndVbStreams(
 	opaque uint16, ts *protobuf.TsVbuuid) (err error) {
 
-	vbnos := c.Vbno32to16(ts.GetVbnos())
-	for _, vbno := range vbnos {
+	ts.VbSet().Range(func(vbno uint16) bool {
 		if e := bupr.uprFeed.UprCloseStream(vbno, opaque); e != nil {
 			err = e
 		}
-	}
+		return true
+	})
 	return err
 }
 
+// GetStatistics implements Feeder{} interface.
+func (bupr *bucketUpr) GetStatistics() map[string]interface{} {
+	stats := map[string]interface{}{
+		"bytes":      float64(0), // bytes received from DCP
+		"bufferAcks": float64(0), // no. of buffer-acks sent to DCP
+		"mutations":  float64(0), // no. of mutations received from DCP
+		"snapshots":  float64(0), // no. of snapshot markers from DCP
+	}
+	if s, err := bupr.uprFeed.GetUprStats(); err == nil {
+		stats["bytes"] = float64(s.TotalBytes)
+		stats["bufferAcks"] = float64(s.TotalBufferAckSent)
+		stats["mutations"] = float64(s.TotalMutation)
+		stats["snapshots"] = float64(s.TotalSnapShot)
+	}
+	return stats
+}
+
 // CloseFeed implements Feeder{} interface.
 func (bupr *bucketUpr) CloseFeed() error {
 	bupr.uprFeed.Close()
@@ -0,0 +1,180 @@
+package projector
+
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+
+// vbRetry schedule of a vbucket queued for retry.
+type vbRetry struct {
+	interval time.Duration // backoff after the next attempt
+	next     time.Time     // next attempt is due
+	deadline time.Time     // retry budget is exhausted
+	posted   time.Time     // last attempt awaiting response, if not zero
+}
+
+// vbRetryQueue of vbuckets whose StreamRequest failed. Each vbucket is
+// re-requested from its restart point with its own exponential backoff,
+// until it succeeds or its retry budget is exhausted. Attempts are posted
+// without waiting, a vbucket leaves the queue when its response is
+// applied. Owned by feed's gen-server.
+type vbRetryQueue struct {
+	interval    time.Duration
+	maxInterval time.Duration
+	budget      time.Duration // 0 disables the queue
+
+	restartTss map[string]*protobuf.TsVbuuid  // bucket -> restart points
+	vbsets     map[string]*c.VbSet            // bucket -> queued vbuckets
+	schedules  map[string]map[uint16]*vbRetry // bucket -> vbno -> schedule
+}
+
+func newVbRetryQueue(interval, maxInterval, budget time.Duration) *vbRetryQueue {
+	return &vbRetryQueue{
+		interval:    interval,
+		maxInterval: maxInterval,
+		budget:      budget,
+		restartTss:  make(map[string]*protobuf.TsVbuuid),
+		vbsets:      make(map[string]*c.VbSet),
+		schedules:   make(map[string]map[uint16]*vbRetry),
+	}
+}
+
+// add vbuckets of `ts` to be retried from their restart point in `ts`,
+// vbuckets already queued are re-scheduled with a fresh budget.
+func (q *vbRetryQueue) add(ts *protobuf.TsVbuuid, now time.Time) {
+	if q.budget == 0 || ts == nil || ts.IsEmpty() {
+		return
+	}
+	bucketn, vbset := ts.GetBucket(), ts.VbSet()
+	q.restartTss[bucketn] = q.restartTss[bucketn].Union(ts)
+	q.vbsets[bucketn] = q.vbsets[bucketn].Union(vbset)
+	schedules, ok := q.schedules[bucketn]
+	if !ok {
+		schedules = make(map[uint16]*vbRetry)
+		q.schedules[bucketn] = schedules
+	}
+	vbset.Range(func(vbno uint16) bool {
+		schedules[vbno] = &vbRetry{
+			interval: q.interval,
+			next:     now.Add(q.interval),
+			deadline: now.Add(q.budget),
+		}
+		return true
+	})
+}
+
+// due returns restart points of vbuckets whose backoff has elapsed, per
+// bucket, and backs off their next attempt. Vbuckets whose budget is
+// exhausted are removed from the queue and returned as expired.
+func (q *vbRetryQueue) due(now time.Time) (
+	dueTss map[string]*protobuf.TsVbuuid, expired map[string]*c.VbSet) {
+
+	dueTss = make(map[string]*protobuf.TsVbuuid)
+	expired = make(map[string]*c.VbSet)
+	for bucketn, vbset := range q.vbsets {
+		schedules := q.schedules[bucketn]
+		dueVbs, expiredVbs := c.NewVbSet(), c.NewVbSet()
+		vbset.Range(func(vbno uint16) bool {
+			r := schedules[vbno]
+			if now.After(r.deadline) {
+				expiredVbs.Add(vbno)
+				return true
+			} else if now.Before(r.next) {
+				return true
+			}
+			dueVbs.Add(vbno)
+			if r.interval *= 2; r.interval > q.maxInterval {
+				r.interval = q.maxInterval
+			}
+			r.next = now.Add(r.interval)
+			return true
+		})
+		if !dueVbs.IsEmpty() {
+			dueTss[bucketn] = q.restartTss[bucketn].SelectByVbSet(dueVbs)
+		}
+		if !expiredVbs.IsEmpty() {
+			expired[bucketn] = expiredVbs
+			q.remove(bucketn, expiredVbs)
+		}
+	}
+	return dueTss, expired
+}
+
+// next returns when the earliest backoff in the queue elapses, ok is
+// false if the queue is empty.
+func (q *vbRetryQueue) next() (next time.Time, ok bool) {
+	for _, schedules := range q.schedules {
+		for _, r := range schedules {
+			if !ok || r.next.Before(next) {
+				next, ok = r.next, true
+			}
+		}
+	}
+	return next, ok
+}
+
+// queued returns whether vbucket of bucket is in the queue.
+func (q *vbRetryQueue) queued(bucketn string, vbno uint16) bool {
+	return q.vbsets[bucketn].Has(vbno)
+}
+
+// posted marks an attempt for vbuckets of bucket as awaiting response.
+func (q *vbRetryQueue) posted(bucketn string, vbset *c.VbSet, now time.Time) {
+	schedules := q.schedules[bucketn]
+	vbset.Intersect(q.vbsets[bucketn]).Range(func(vbno uint16) bool {
+		schedules[vbno].posted = now
+		return true
+	})
+}
+
+// responded marks the attempt for vbucket of bucket as complete.
+func (q *vbRetryQueue) responded(bucketn string, vbno uint16) {
+	if r, ok := q.schedules[bucketn][vbno]; ok {
+		r.posted = time.Time{}
+	}
+}
+
+// awaiting returns whether an attempt for vbucket of bucket is awaiting
+// response for less than timeout.
+func (q *vbRetryQueue) awaiting(
+	bucketn string, vbno uint16, now time.Time, timeout time.Duration) bool {
+
+	r, ok := q.schedules[bucketn][vbno]
+	if !ok || r.posted.IsZero() {
+		return false
+	}
+	return now.Sub(r.posted) < timeout
+}
+
+// remove vbuckets of bucket from the queue.
+func (q *vbRetryQueue) remove(bucketn string, vbset *c.VbSet) {
+	queued, ok := q.vbsets[bucketn]
+	if !ok || vbset.IsEmpty() {
+		return
+	}
+	schedules := q.schedules[bucketn]
+	vbset.Range(func(vbno uint16) bool {
+		delete(schedules, vbno)
+		return true
+	})
+	q.restartTss[bucketn] = q.restartTss[bucketn].FilterByVbSet(vbset)
+	if q.vbsets[bucketn] = queued.Diff(vbset); q.vbsets[bucketn].IsEmpty() {
+		q.clear(bucketn)
+	}
+}
+
+// clear every vbucket of bucket from the queue.
+func (q *vbRetryQueue) clear(bucketn string) {
+	delete(q.schedules, bucketn)
+	delete(q.vbsets, bucketn)
+	delete(q.restartTss, bucketn)
+}
+
+// pending vbuckets in the queue, across buckets.
+func (q *vbRetryQueue) pending() int {
+	n := 0
+	for _, vbset := range q.vbsets {
+		n += vbset.Len()
+	}
+	return n
+}
@@ -0,0 +1,95 @@
+package projector
+
+import "reflect"
+import "testing"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+
+func TestVbRetryQueue(t *testing.T) {
+	q := newVbRetryQueue(10*time.Millisecond, 40*time.Millisecond, time.Second)
+	ts := protobuf.NewTsVbuuid("default", "default", 4)
+	ts.Append(1, 10, 0x1, 0, 0)
+	ts.Append(2, 20, 0x2, 0, 0)
+	now := time.Now()
+	if _, ok := q.next(); ok {
+		t.Fatalf("expected no retry to be due on empty queue")
+	}
+	q.add(ts, now)
+	if n := q.pending(); n != 2 {
+		t.Fatalf("expected 2 vbuckets queued, got %v", n)
+	}
+	if next, ok := q.next(); !ok || !next.Equal(now.Add(10*time.Millisecond)) {
+		t.Fatalf("expected retry due after backoff, got %v %v", next, ok)
+	}
+
+	if dueTss, _ := q.due(now); len(dueTss) != 0 {
+		t.Fatalf("unexpected retry before backoff %v", dueTss)
+	}
+	dueTss, expired := q.due(now.Add(10 * time.Millisecond))
+	if len(expired) != 0 {
+		t.Fatalf("unexpected expired vbuckets %v", expired)
+	}
+	vbnos := c.Vbno32to16(dueTss["default"].GetVbnos())
+	if !reflect.DeepEqual(vbnos, []uint16{1, 2}) {
+		t.Fatalf("expected vbuckets 1, 2 to be retried, got %v", vbnos)
+	}
+	if seqno, _ := dueTss["default"].SeqnoFor(2); seqno != 20 {
+		t.Errorf("expected restart seqno 20, got %v", seqno)
+	}
+	// backoff is doubled after every attempt.
+	if dueTss, _ := q.due(now.Add(25 * time.Millisecond)); len(dueTss) != 0 {
+		t.Fatalf("unexpected retry before backoff %v", dueTss)
+	}
+
+	q.remove("default", c.NewVbSet(1))
+	dueTss, _ = q.due(now.Add(30 * time.Millisecond))
+	vbnos = c.Vbno32to16(dueTss["default"].GetVbnos())
+	if !reflect.DeepEqual(vbnos, []uint16{2}) {
+		t.Fatalf("expected vbucket 2 to be retried, got %v", vbnos)
+	}
+
+	_, expired = q.due(now.Add(2 * time.Second))
+	if !reflect.DeepEqual(expired["default"].Vbnos(), []uint16{2}) {
+		t.Fatalf("expected vbucket 2 to exhaust its budget, got %v", expired)
+	}
+	if n := q.pending(); n != 0 {
+		t.Fatalf("expected empty queue, got %v vbuckets", n)
+	}
+
+	disabled := newVbRetryQueue(10*time.Millisecond, 40*time.Millisecond, 0)
+	if disabled.add(ts, now); disabled.pending() != 0 {
+		t.Fatalf("expected vbuckets not to be queued when disabled")
+	}
+}
+
+func TestVbRetryAwaiting(t *testing.T) {
+	q := newVbRetryQueue(10*time.Millisecond, 40*time.Millisecond, time.Second)
+	ts := protobuf.NewTsVbuuid("default", "default", 4)
+	ts.Append(1, 10, 0x1, 0, 0)
+	now := time.Now()
+	q.add(ts, now)
+	if !q.queued("default", 1) || q.queued("default", 2) {
+		t.Fatalf("expected only vbucket 1 to be queued")
+	}
+	if q.awaiting("default", 1, now, time.Second) {
+		t.Fatalf("unexpected attempt awaiting response")
+	}
+
+	q.posted("default", c.NewVbSet(1, 2), now)
+	if !q.awaiting("default", 1, now.Add(10*time.Millisecond), time.Second) {
+		t.Fatalf("expected attempt to await response")
+	} else if q.awaiting("default", 1, now.Add(2*time.Second), time.Second) {
+		t.Fatalf("expected attempt to time out")
+	} else if q.awaiting("default", 2, now, time.Second) {
+		t.Fatalf("unexpected attempt for vbucket not queued")
+	}
+
+	q.responded("default", 1)
+	if q.awaiting("default", 1, now, time.Second) {
+		t.Fatalf("expected attempt to be complete")
+	} else if !q.queued("default", 1) {
+		t.Fatalf("expected vbucket 1 to remain queued")
+	}
+}
@@ -16,6 +16,8 @@
 package projector
 
 import "fmt"
+import "context"
+import "sync"
 import "time"
 import "runtime/debug"
 
@@ -30,9 +32,18 @@ type VbucketRoutine struct {
 	vbuuid    uint64 // immutable
 	engines   map[uint64]*Engine
 	endpoints map[string]c.RouterEndpoint
+	nsCounts  map[string]float64 // namespace -> no. of mutations within it
+	audit     *routingAudit      // nil unless routing audit is enabled
+	sampler   *mutationSampler   // shared with kvdata, nil if disabled
+	resources *topicResources
+	version   uint32 // mutation stream version understood by endpoints
 	// gen-server
 	reqch chan []interface{}
 	finch chan bool
+	// events are posted under read lock, so that buffered events can be
+	// released on exit without racing with Event().
+	mu       sync.RWMutex
+	released bool
 	// config params
 	mutChanSize int
 	syncTimeout time.Duration // in milliseconds
@@ -42,7 +53,8 @@ type VbucketRoutine struct {
 // NewVbucketRoutine creates a new routine to handle this vbucket stream.
 func NewVbucketRoutine(
 	cluster, topic, bucket string,
-	vbno uint16, vbuuid, startSeqno uint64, config c.Config) *VbucketRoutine {
+	vbno uint16, vbuuid, startSeqno uint64, config c.Config, version uint32,
+	resources *topicResources, sampler *mutationSampler) *VbucketRoutine {
 
 	mutChanSize := config["mutationChanSize"].Int()
 
@@ -52,13 +64,19 @@ func NewVbucketRoutine(
 		vbuuid:    vbuuid,
 		engines:   make(map[uint64]*Engine),
 		endpoints: make(map[string]c.RouterEndpoint),
+		nsCounts:  make(map[string]float64),
+		resources: resources,
+		sampler:   sampler,
+		version:   version,
 		reqch:     make(chan []interface{}, mutChanSize),
 		finch:     make(chan bool),
 	}
-	vr.logPrefix = fmt.Sprintf("VBRT[<-%v<-%v<-%v #%v]", vbno, bucket, cluster, topic)
+	vr.logPrefix = fmt.Sprintf("VBRT[<-%v<-%v<-%v #%v]", vbno, bucket, c.RedactURL(cluster), topic)
 	vr.mutChanSize = mutChanSize
 	vr.syncTimeout = time.Duration(config["vbucketSyncTimeout"].Int())
 	vr.syncTimeout *= time.Millisecond
+	vr.audit = newRoutingAudit(
+		config["routingAuditSamples"].Int(), config["routingAuditPeriod"].Int())
 
 	go vr.run(vr.reqch, startSeqno)
 	c.Infof("%v started ...\n", vr.logPrefix)
@@ -74,8 +92,18 @@ const (
 
 // Event will post an UprEvent, asychronous call.
 func (vr *VbucketRoutine) Event(m *mc.UprEvent) error {
+	vr.mu.RLock()
+	defer vr.mu.RUnlock()
+	if vr.released {
+		return c.ErrorClosed
+	}
 	cmd := []interface{}{vrCmdEvent, m}
-	return c.FailsafeOpAsync(vr.reqch, cmd, vr.finch)
+	vr.resources.addBuffered(eventSize(m))
+	err := c.FailsafeOpAsync(vr.reqch, cmd, vr.finch)
+	if err != nil {
+		vr.resources.addBuffered(-eventSize(m))
+	}
+	return err
 }
 
 // AddEngines update active set of engines and endpoints
@@ -99,12 +127,16 @@ func (vr *VbucketRoutine) DeleteEngines(engines []uint64) error {
 	return err
 }
 
-// GetStatistics for vr vbucket
+// GetStatistics for vr vbucket, nil if routine has exited or `ctx`
+// expires before it responds.
 // synchronous call.
-func (vr *VbucketRoutine) GetStatistics() map[string]interface{} {
+func (vr *VbucketRoutine) GetStatistics(ctx context.Context) map[string]interface{} {
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{vrCmdGetStatistics, respch}
-	resp, _ := c.FailsafeOp(vr.reqch, respch, cmd, vr.finch)
+	resp, err := c.FailsafeOpContext(ctx, vr.reqch, respch, cmd, vr.finch)
+	if err != nil {
+		return nil
+	}
 	return resp[0].(map[string]interface{})
 }
 
@@ -124,7 +156,7 @@ func (vr *VbucketRoutine) run(reqch chan []interface{}, seqno uint64) {
 			vr.broadcast2Endpoints(data)
 		}
 
-		close(vr.finch)
+		vr.releaseBuffered(reqch)
 		c.Infof("%v ... stopped\n", vr.logPrefix)
 	}()
 
@@ -136,6 +168,10 @@ func (vr *VbucketRoutine) run(reqch chan []interface{}, seqno uint64) {
 	syncCount := stats.Get("syncs").(float64)
 	sshotCount := stats.Get("snapshots").(float64)
 	mutationCount := stats.Get("mutations").(float64)
+	deletionCount := stats.Get("deletions").(float64)
+	expirationCount := stats.Get("expirations").(float64)
+	snapStart := stats.Get("snapStart").(float64)
+	snapEnd := stats.Get("snapEnd").(float64)
 
 loop:
 	for {
@@ -153,6 +189,7 @@ loop:
 					}
 					vr.printCtrl(vr.engines)
 				}
+				vr.updateNamespaces()
 
 				if msg[2] != nil {
 					endpoints := msg[2].(map[string]c.RouterEndpoint)
@@ -170,6 +207,7 @@ loop:
 					delete(vr.engines, uuid)
 					c.Tracef("%v DelEngine %v\n", vr.logPrefix, uuid)
 				}
+				vr.updateNamespaces()
 
 				c.Tracef("%v deleted engines %v\n", engineKeys)
 				respch := msg[2].(chan []interface{})
@@ -184,10 +222,23 @@ loop:
 				stats.Set("syncs", syncCount)
 				stats.Set("snapshots", sshotCount)
 				stats.Set("mutations", mutationCount)
+				stats.Set("deletions", deletionCount)
+				stats.Set("expirations", expirationCount)
+				stats.Set("snapStart", snapStart)
+				stats.Set("snapEnd", snapEnd)
+				namespaces := make(map[string]interface{})
+				for ns, n := range vr.nsCounts {
+					namespaces[namespaceName(ns)] = n
+				}
+				stats.Set("namespaces", namespaces)
+				if vr.audit != nil {
+					stats.Set("routingAudit", vr.audit.toMap())
+				}
 				respch <- []interface{}{stats.ToMap()}
 
 			case vrCmdEvent:
 				m := msg[1].(*mc.UprEvent)
+				start := time.Now()
 				if m.Opcode == mcd.UPR_STREAMREQ { // opens up the path
 					heartBeat = time.Tick(vr.syncTimeout)
 					format := "%v heartbeat (%v) loaded ...\n"
@@ -196,11 +247,21 @@ loop:
 
 				// count statistics
 				seqno = vr.handleEvent(m, seqno)
+				vr.resources.addBusy(time.Since(start))
+				vr.resources.addBuffered(-eventSize(m))
 				switch m.Opcode {
 				case mcd.UPR_SNAPSHOT:
 					sshotCount++
-				case mcd.UPR_MUTATION, mcd.UPR_DELETION, mcd.UPR_EXPIRATION:
+					snapStart = float64(m.SnapstartSeq)
+					snapEnd = float64(m.SnapendSeq)
+				case mcd.UPR_MUTATION:
+					mutationCount++
+				case mcd.UPR_DELETION:
+					mutationCount++
+					deletionCount++
+				case mcd.UPR_EXPIRATION:
 					mutationCount++
+					expirationCount++
 				case mcd.UPR_STREAMEND:
 					break loop
 				}
@@ -219,13 +280,14 @@ loop:
 	}
 }
 
-// only endpoints that host engines defined on this vbucket.
+// only endpoints that host engines defined on this vbucket and own the
+// vbucket.
 func (vr *VbucketRoutine) updateEndpoints(
 	eps map[string]c.RouterEndpoint) map[string]c.RouterEndpoint {
 
 	endpoints := make(map[string]c.RouterEndpoint)
 	for _, engine := range vr.engines {
-		for _, raddr := range engine.Endpoints() {
+		for _, raddr := range engine.VbucketEndpoints(vr.vbno) {
 			if _, ok := eps[raddr]; !ok {
 				format := "%v endpoint %v not found\n"
 				c.Errorf(format, vr.logPrefix, raddr)
@@ -263,16 +325,32 @@ func (vr *VbucketRoutine) handleEvent(m *mc.UprEvent, seqno uint64) uint64 {
 	case mcd.UPR_MUTATION, mcd.UPR_DELETION, mcd.UPR_EXPIRATION:
 		// sequence number gets incremented only here.
 		seqno = m.Seqno
+		for ns := range vr.nsCounts {
+			if inNamespace(ns, m.Key) {
+				vr.nsCounts[ns]++
+			}
+		}
 		// prepare a data for each endpoint.
 		dataForEndpoints := make(map[string]interface{})
-		// for each engine distribute transformations to endpoints.
+		ev := downgradeEvent(m, vr.version)
+		// for each engine distribute transformations to endpoints,
+		// engines only evaluate documents within their namespace.
 		for _, engine := range vr.engines {
-			err := engine.TransformRoute(vr.vbuuid, m, dataForEndpoints)
+			if !engine.InNamespace(m.Key) {
+				continue
+			}
+			err := engine.TransformRoute(vr.vbuuid, ev, dataForEndpoints)
 			if err != nil {
 				c.Errorf("%v TransformRoute %v\n", vr.logPrefix, err)
 				continue
 			}
 		}
+		if vr.audit != nil && vr.audit.sample() {
+			vr.audit.record(vr.engines, vr.endpoints, dataForEndpoints)
+		}
+		if vr.sampler != nil && vr.sampler.sample() {
+			vr.sampler.record(vr.vbno, m, vr.engines, dataForEndpoints)
+		}
 		// send data to corresponding endpoint.
 		for raddr, data := range dataForEndpoints {
 			if endpoint, ok := vr.endpoints[raddr]; ok {
@@ -292,6 +370,29 @@ func (vr *VbucketRoutine) handleEvent(m *mc.UprEvent, seqno uint64) uint64 {
 	return seqno
 }
 
+// downgradeEvent returns the event as understood by endpoints of stream
+// `version`, expirations are sent as deletions to endpoints that predate
+// the Expiration command.
+func downgradeEvent(m *mc.UprEvent, version uint32) *mc.UprEvent {
+	if m.Opcode != mcd.UPR_EXPIRATION || version >= c.StreamVersion2 {
+		return m
+	}
+	ev := *m
+	ev.Opcode = mcd.UPR_DELETION
+	return &ev
+}
+
+// track namespaces of active engines, counts of namespaces still in use
+// are retained.
+func (vr *VbucketRoutine) updateNamespaces() {
+	nsCounts := make(map[string]float64)
+	for _, engine := range vr.engines {
+		ns := engine.Namespace()
+		nsCounts[ns] = vr.nsCounts[ns]
+	}
+	vr.nsCounts = nsCounts
+}
+
 // send to all endpoints.
 func (vr *VbucketRoutine) broadcast2Endpoints(data interface{}) {
 	for raddr, endpoint := range vr.endpoints {
@@ -397,11 +498,15 @@ func (vr *VbucketRoutine) makeStreamEndData(seqno uint64) interface{} {
 
 func (vr *VbucketRoutine) newStats() c.Statistics {
 	m := map[string]interface{}{
-		"addInsts":  float64(0), // no. of update-engine commands
-		"delInsts":  float64(0), // no. of delete-engine commands
-		"syncs":     float64(0), // no. of Sync message generated
-		"snapshots": float64(0), // no. of Begin
-		"mutations": float64(0), // no. of Upsert, Delete
+		"addInsts":    float64(0), // no. of update-engine commands
+		"delInsts":    float64(0), // no. of delete-engine commands
+		"syncs":       float64(0), // no. of Sync message generated
+		"snapshots":   float64(0), // no. of Begin
+		"mutations":   float64(0), // no. of Upsert, Delete
+		"snapStart":   float64(0), // start seqno of last snapshot
+		"snapEnd":     float64(0), // end seqno of last snapshot
+		"deletions":   float64(0), // no. of Delete, among mutations
+		"expirations": float64(0), // no. of Delete due to expiry, among mutations
 	}
 	stats, _ := c.NewStatistics(m)
 	return stats
@@ -419,3 +524,29 @@ func (vr *VbucketRoutine) printCtrl(v interface{}) {
 		}
 	}
 }
+
+// release events left unhandled on exit from accounting. Closing finch
+// unblocks Event() calls waiting on a full reqch, once they return, no
+// more events can be posted.
+func (vr *VbucketRoutine) releaseBuffered(reqch chan []interface{}) {
+	close(vr.finch)
+
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	vr.released = true
+	for {
+		select {
+		case msg := <-reqch:
+			if msg[0].(byte) == vrCmdEvent {
+				vr.resources.addBuffered(-eventSize(msg[1].(*mc.UprEvent)))
+			}
+		default:
+			return
+		}
+	}
+}
+
+// size of event's payload, accounted as buffered on the data path.
+func eventSize(m *mc.UprEvent) int64 {
+	return int64(len(m.Key) + len(m.Value))
+}
@@ -0,0 +1,56 @@
+package projector
+
+import "testing"
+import "time"
+
+import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+import c "github.com/couchbase/indexing/secondary/common"
+
+func TestDowngradeEvent(t *testing.T) {
+	m := &mc.UprEvent{Opcode: mcd.UPR_EXPIRATION, Key: []byte("doc1"), Seqno: 10}
+
+	if ev := downgradeEvent(m, c.StreamVersion2); ev != m {
+		t.Errorf("expected expiration to be sent as is, got %v", ev.Opcode)
+	}
+	ev := downgradeEvent(m, c.StreamVersion1)
+	if ev.Opcode != mcd.UPR_DELETION {
+		t.Errorf("expected expiration sent as deletion, got %v", ev.Opcode)
+	} else if string(ev.Key) != "doc1" || ev.Seqno != 10 {
+		t.Errorf("expected key and seqno of expiration, got %v", ev)
+	} else if m.Opcode != mcd.UPR_EXPIRATION {
+		t.Errorf("expected event to be left as is, got %v", m.Opcode)
+	}
+
+	m = &mc.UprEvent{Opcode: mcd.UPR_MUTATION}
+	if ev := downgradeEvent(m, c.StreamVersion1); ev != m {
+		t.Errorf("expected mutation to be sent as is, got %v", ev.Opcode)
+	}
+}
+
+func TestVbucketReleaseBuffered(t *testing.T) {
+	vr := &VbucketRoutine{
+		resources: newTopicResources(),
+		reqch:     make(chan []interface{}, 1),
+		finch:     make(chan bool),
+	}
+	m := &mc.UprEvent{Opcode: mcd.UPR_MUTATION, Key: []byte("doc1")}
+	if err := vr.Event(m); err != nil {
+		t.Fatal(err)
+	}
+	// blocks on the full reqch until the routine exits.
+	errch := make(chan error, 1)
+	go func() { errch <- vr.Event(m) }()
+	time.Sleep(10 * time.Millisecond)
+
+	vr.releaseBuffered(vr.reqch)
+	if err := <-errch; err != c.ErrorClosed {
+		t.Fatalf("expected %v, got %v", c.ErrorClosed, err)
+	}
+	if err := vr.Event(m); err != c.ErrorClosed {
+		t.Fatalf("expected %v, got %v", c.ErrorClosed, err)
+	}
+	if buffered := vr.resources.statistics()["bufferedBytes"]; buffered != float64(0) {
+		t.Fatalf("expected no buffered bytes, got %v", buffered)
+	}
+}
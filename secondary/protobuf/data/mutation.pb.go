@@ -10,6 +10,7 @@ It is generated from these files:
 
 It has these top-level messages:
 	Payload
+	ConnectionFrame
 	VbConnectionMap
 	VbKeyVersions
 	KeyVersions
@@ -80,6 +81,7 @@ type Payload struct {
 	// -- Following fields are mutually exclusive --
 	Vbkeys           []*VbKeyVersions `protobuf:"bytes,2,rep,name=vbkeys" json:"vbkeys,omitempty"`
 	Vbmap            *VbConnectionMap `protobuf:"bytes,3,opt,name=vbmap" json:"vbmap,omitempty"`
+	Frame            *ConnectionFrame `protobuf:"bytes,4,opt,name=frame" json:"frame,omitempty"`
 	XXX_unrecognized []byte           `json:"-"`
 }
 
@@ -108,6 +110,48 @@ func (m *Payload) GetVbmap() *VbConnectionMap {
 	return nil
 }
 
+func (m *Payload) GetFrame() *ConnectionFrame {
+	if m != nil {
+		return m.Frame
+	}
+	return nil
+}
+
+// Initial message on an endpoint connection, describing the topic and
+// the vbuckets, for each bucket, that will be streamed via the connection.
+// It is sent again whenever the set of buckets or vbuckets changes.
+type ConnectionFrame struct {
+	Topic            *string            `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
+	Vbmaps           []*VbConnectionMap `protobuf:"bytes,2,rep,name=vbmaps" json:"vbmaps,omitempty"`
+	Token            *string            `protobuf:"bytes,3,opt,name=token" json:"token,omitempty"`
+	XXX_unrecognized []byte             `json:"-"`
+}
+
+func (m *ConnectionFrame) Reset()         { *m = ConnectionFrame{} }
+func (m *ConnectionFrame) String() string { return proto.CompactTextString(m) }
+func (*ConnectionFrame) ProtoMessage()    {}
+
+func (m *ConnectionFrame) GetTopic() string {
+	if m != nil && m.Topic != nil {
+		return *m.Topic
+	}
+	return ""
+}
+
+func (m *ConnectionFrame) GetVbmaps() []*VbConnectionMap {
+	if m != nil {
+		return m.Vbmaps
+	}
+	return nil
+}
+
+func (m *ConnectionFrame) GetToken() string {
+	if m != nil && m.Token != nil {
+		return *m.Token
+	}
+	return ""
+}
+
 // List of vbuckets that will be streamed via a newly opened connection.
 type VbConnectionMap struct {
 	Bucket           *string  `protobuf:"bytes,1,req,name=bucket" json:"bucket,omitempty"`
@@ -213,6 +257,7 @@ type KeyVersions struct {
 	Keys             [][]byte `protobuf:"bytes,5,rep,name=keys" json:"keys,omitempty"`
 	Oldkeys          [][]byte `protobuf:"bytes,6,rep,name=oldkeys" json:"oldkeys,omitempty"`
 	Partnkeys        [][]byte `protobuf:"bytes,7,rep,name=partnkeys" json:"partnkeys,omitempty"`
+	Values           [][]byte `protobuf:"bytes,8,rep,name=values" json:"values,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -269,6 +314,13 @@ func (m *KeyVersions) GetPartnkeys() [][]byte {
 	return nil
 }
 
+func (m *KeyVersions) GetValues() [][]byte {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("protobuf.Command", Command_name, Command_value)
 }
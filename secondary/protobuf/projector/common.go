@@ -13,14 +13,32 @@ import "github.com/couchbaselabs/goprotobuf/proto"
 // *****
 
 // NewError create a protobuf message `Error` and return its
-// reference back to the caller.
+// reference back to the caller. Code of typed errors is sent along, and
+// context annotated by common.WrapError is sent separately.
 func NewError(err error) *Error {
 	if err != nil {
-		return &Error{Error: proto.String(err.Error())}
+		e := &Error{Error: proto.String(c.ErrorCause(err).Error())}
+		if code := c.ErrorCodeOf(err); code != c.ErrorCodeUnknown {
+			e.Code = proto.Uint32(uint32(code))
+		}
+		if context := c.ErrorContext(err); context != "" {
+			e.Context = proto.String(context)
+		}
+		return e
 	}
 	return &Error{Error: proto.String("")}
 }
 
+// ToError converts protobuf message `Error` back to error, typed errors
+// can be matched with common.IsError. Returns nil for success.
+func (req *Error) ToError() error {
+	err := c.DecodeError(c.ErrorCode(req.GetCode()), req.GetError())
+	if context := req.GetContext(); err != nil && context != "" {
+		return c.WrapError(err, context)
+	}
+	return err
+}
+
 // Name implement MessageMarshaller{} interface
 func (req *Error) Name() string {
 	return "Error"
@@ -176,14 +194,19 @@ func (ts *TsVbuuid) Set(
 
 // Contains with check whether `vbno` has an entry in the timestamp.
 func (ts *TsVbuuid) Contains(vbno uint16) bool {
-	for _, vbno := range ts.GetVbnos() {
-		if vbno == vbno {
+	for _, x := range ts.GetVbnos() {
+		if x == uint32(vbno) {
 			return true
 		}
 	}
 	return false
 }
 
+// VbSet returns the set of vbuckets that have an entry in the timestamp.
+func (ts *TsVbuuid) VbSet() *c.VbSet {
+	return c.NewVbSet32(ts.GetVbnos())
+}
+
 // FromTsVbuuid converts timestamp from common.TsVbuuid to protobuf
 // format.
 func (ts *TsVbuuid) FromTsVbuuid(nativeTs *c.TsVbuuid) *TsVbuuid {
@@ -230,14 +253,10 @@ func (ts *TsVbuuid) Union(other *TsVbuuid) *TsVbuuid {
 	newts.Vbuuids = append(newts.Vbuuids, other.Vbuuids...)
 	newts.Snapshots = append(newts.Snapshots, other.Snapshots...)
 
-	cache := make(map[uint32]bool)
-	for _, vbno := range other.Vbnos {
-		cache[vbno] = true
-	}
-
 	// deduplicate this
+	vbset := other.VbSet()
 	for i, vbno := range ts.Vbnos {
-		if _, ok := cache[vbno]; ok {
+		if vbset.Has(uint16(vbno)) {
 			continue
 		}
 		newts.Vbnos = append(newts.Vbnos, vbno)
@@ -256,26 +275,16 @@ func (ts *TsVbuuid) SelectByVbuckets(vbuckets []uint16) *TsVbuuid {
 	if ts == nil || vbuckets == nil {
 		return ts
 	}
+	return ts.SelectByVbSet(c.NewVbSet(vbuckets...))
+}
 
-	maxVbuckets := len(ts.Seqnos)
-	newts := NewTsVbuuid(ts.GetPool(), ts.GetBucket(), maxVbuckets)
-	if len(ts.Vbnos) == 0 {
-		return newts
-	}
-
-	cache := make(map[uint32]bool)
-	for _, vbno := range vbuckets {
-		cache[uint32(vbno)] = true
-	}
-	for i, vbno := range ts.Vbnos {
-		if _, ok := cache[vbno]; ok {
-			newts.Vbnos = append(newts.Vbnos, vbno)
-			newts.Vbuuids = append(newts.Vbuuids, ts.Vbuuids[i])
-			newts.Seqnos = append(newts.Seqnos, ts.Seqnos[i])
-			newts.Snapshots = append(newts.Snapshots, ts.Snapshots[i])
-		}
+// SelectByVbSet will select vbuckets from `ts` that are
+// present in `vbset`.
+func (ts *TsVbuuid) SelectByVbSet(vbset *c.VbSet) *TsVbuuid {
+	if ts == nil || vbset == nil {
+		return ts
 	}
-	return newts
+	return ts.filter(func(vbno uint32) bool { return vbset.Has(uint16(vbno)) })
 }
 
 // FilterByVbuckets will exclude `vbuckets` from `ts`,
@@ -284,25 +293,30 @@ func (ts *TsVbuuid) FilterByVbuckets(vbuckets []uint16) *TsVbuuid {
 	if ts == nil || vbuckets == nil {
 		return ts
 	}
+	return ts.FilterByVbSet(c.NewVbSet(vbuckets...))
+}
 
-	maxVbuckets := len(ts.Seqnos)
-	newts := NewTsVbuuid(ts.GetPool(), ts.GetBucket(), maxVbuckets)
-	if len(ts.Vbnos) == 0 {
-		return newts
+// FilterByVbSet will exclude vbuckets present in `vbset`
+// from `ts`.
+func (ts *TsVbuuid) FilterByVbSet(vbset *c.VbSet) *TsVbuuid {
+	if ts == nil || vbset == nil {
+		return ts
 	}
+	return ts.filter(func(vbno uint32) bool { return !vbset.Has(uint16(vbno)) })
+}
 
-	cache := make(map[uint32]bool)
-	for _, vbno := range vbuckets {
-		cache[uint32(vbno)] = true
-	}
+// filter returns a new timestamp with entries of `ts` for
+// which `keep` returns true.
+func (ts *TsVbuuid) filter(keep func(vbno uint32) bool) *TsVbuuid {
+	maxVbuckets := len(ts.Seqnos)
+	newts := NewTsVbuuid(ts.GetPool(), ts.GetBucket(), maxVbuckets)
 	for i, vbno := range ts.Vbnos {
-		if _, ok := cache[vbno]; ok {
-			continue
+		if keep(vbno) {
+			newts.Vbnos = append(newts.Vbnos, vbno)
+			newts.Seqnos = append(newts.Seqnos, ts.Seqnos[i])
+			newts.Vbuuids = append(newts.Vbuuids, ts.Vbuuids[i])
+			newts.Snapshots = append(newts.Snapshots, ts.Snapshots[i])
 		}
-		newts.Vbnos = append(newts.Vbnos, vbno)
-		newts.Seqnos = append(newts.Seqnos, ts.Seqnos[i])
-		newts.Vbuuids = append(newts.Vbuuids, ts.Vbuuids[i])
-		newts.Snapshots = append(newts.Snapshots, ts.Snapshots[i])
 	}
 	return newts
 }
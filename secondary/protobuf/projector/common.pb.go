@@ -34,6 +34,10 @@ var _ = math.Inf
 // encapsulated in response packets.
 type Error struct {
 	Error            *string `protobuf:"bytes,1,req,name=error" json:"error,omitempty"`
+	Code             *uint32 `protobuf:"varint,2,opt,name=code" json:"code,omitempty"`
+	// context of the failed operation, error is kept as is so that older
+	// components matching on error messages continue to work.
+	Context          *string `protobuf:"bytes,3,opt,name=context" json:"context,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -48,6 +52,20 @@ func (m *Error) GetError() string {
 	return ""
 }
 
+func (m *Error) GetCode() uint32 {
+	if m != nil && m.Code != nil {
+		return *m.Code
+	}
+	return 0
+}
+
+func (m *Error) GetContext() string {
+	if m != nil && m.Context != nil {
+		return *m.Context
+	}
+	return ""
+}
+
 // list of vbucket numbers
 type Vbuckets struct {
 	Vbnos            []uint32 `protobuf:"varint,1,rep,name=vbnos" json:"vbnos,omitempty"`
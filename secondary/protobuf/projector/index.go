@@ -1,7 +1,9 @@
 package protobuf
 
 import "fmt"
+import "sort"
 
+import "github.com/couchbaselabs/goprotobuf/proto"
 import c "github.com/couchbase/indexing/secondary/common"
 import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
 import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
@@ -47,7 +49,8 @@ func (instance *IndexInst) UpsertEndpoints(
 	if p == nil {
 		return nil
 	}
-	return p.UpsertEndpoints(instance, m, partKey, key, oldKey)
+	raddrs := p.UpsertEndpoints(instance, m, partKey, key, oldKey)
+	return instance.vbucketRoute(raddrs, m.VBucket)
 }
 
 // UpsertDeletionEndpoints implements Router{} interface.
@@ -58,7 +61,8 @@ func (instance *IndexInst) UpsertDeletionEndpoints(
 	if p == nil {
 		return nil
 	}
-	return p.UpsertDeletionEndpoints(instance, m, partKey, key, oldKey)
+	raddrs := p.UpsertDeletionEndpoints(instance, m, partKey, key, oldKey)
+	return instance.vbucketRoute(raddrs, m.VBucket)
 }
 
 // DeletionEndpoints implements Router{} interface.
@@ -69,7 +73,72 @@ func (instance *IndexInst) DeletionEndpoints(
 	if p == nil {
 		return nil
 	}
-	return p.DeletionEndpoints(instance, m, partKey, oldKey)
+	raddrs := p.DeletionEndpoints(instance, m, partKey, oldKey)
+	return instance.vbucketRoute(raddrs, m.VBucket)
+}
+
+// VbucketEndpoints implements Router{} interface.
+func (instance *IndexInst) VbucketEndpoints(vbno uint16) []string {
+	return instance.vbucketRoute(instance.Endpoints(), vbno)
+}
+
+// AddVbucketRoute restricts `endpoint` to receive mutations only from
+// vbuckets `vbnos`, an endpoint with several routes owns the vbuckets
+// of all its routes.
+func (instance *IndexInst) AddVbucketRoute(
+	endpoint string, vbnos []uint16) *IndexInst {
+
+	sorted := append(c.Vbuckets(nil), vbnos...)
+	sort.Sort(sorted)
+	route := &VbucketRoute{
+		Endpoint: proto.String(endpoint),
+		Vbnos:    sorted.To32(),
+	}
+	instance.VbucketRoutes = append(instance.VbucketRoutes, route)
+	return instance
+}
+
+// SetNamespace restricts instance to documents whose key starts with
+// `prefix`, documents outside the namespace are not evaluated.
+func (instance *IndexInst) SetNamespace(prefix string) *IndexInst {
+	instance.Namespace = proto.String(prefix)
+	return instance
+}
+
+// vbucketRoute filters out endpoints from `raddrs` that do not own
+// vbucket `vbno`.
+func (instance *IndexInst) vbucketRoute(raddrs []string, vbno uint16) []string {
+	routes := instance.GetVbucketRoutes()
+	if len(routes) == 0 { // fast path, all endpoints own all vbuckets.
+		return raddrs
+	}
+	filtered := make([]string, 0, len(raddrs))
+	for _, raddr := range raddrs {
+		if instance.ownsVbucket(routes, raddr, vbno) {
+			filtered = append(filtered, raddr)
+		}
+	}
+	return filtered
+}
+
+func (instance *IndexInst) ownsVbucket(
+	routes []*VbucketRoute, raddr string, vbno uint16) bool {
+
+	routed := false
+	for _, route := range routes {
+		if route.GetEndpoint() != raddr {
+			continue
+		}
+		routed = true
+		vbnos := route.GetVbnos()
+		i := sort.Search(len(vbnos), func(i int) bool {
+			return vbnos[i] >= uint32(vbno)
+		})
+		if i < len(vbnos) && vbnos[i] == uint32(vbno) {
+			return true
+		}
+	}
+	return !routed // endpoint without a route owns all vbuckets.
 }
 
 func (instance *IndexInst) GetPartitionObject() Partition {
@@ -94,6 +163,7 @@ type IndexEvaluator struct {
 	skExprs  []interface{} // compiled expression
 	pkExpr   interface{}   // compiled expression
 	whExpr   interface{}   // compiled expression
+	inExprs  []interface{} // compiled expression
 	instance *IndexInst
 }
 
@@ -134,6 +204,13 @@ func NewIndexEvaluator(instance *IndexInst) (*IndexEvaluator, error) {
 				ie.whExpr = cExprs[0]
 			}
 		}
+		// expressions to evaluate fields stored along with the entry
+		if exprs := defn.GetIncludeExpressions(); len(exprs) > 0 {
+			ie.inExprs, err = CompileN1QLExpression(exprs)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	return ie, nil
 }
@@ -143,6 +220,16 @@ func (ie *IndexEvaluator) Bucket() string {
 	return ie.instance.GetDefinition().GetBucket()
 }
 
+// BucketUUID implements Evaluator{} interface.
+func (ie *IndexEvaluator) BucketUUID() string {
+	return ie.instance.GetDefinition().GetBucketUUID()
+}
+
+// Namespace implements Evaluator{} interface.
+func (ie *IndexEvaluator) Namespace() string {
+	return ie.instance.GetNamespace()
+}
+
 // StreamBeginData implement Evaluator{} interface.
 func (ie *IndexEvaluator) StreamBeginData(
 	vbno uint16, vbuuid, seqno uint64) (data interface{}) {
@@ -194,6 +281,7 @@ func (ie *IndexEvaluator) TransformRoute(
 	}()
 
 	var npkey /*new-partition*/, opkey /*old-partition*/, nkey, okey []byte
+	var nvalue /*projected-fields*/ []byte
 	instn := ie.instance
 
 	where, err := ie.wherePredicate(m.Value)
@@ -208,6 +296,9 @@ func (ie *IndexEvaluator) TransformRoute(
 		if nkey, err = ie.evaluate(m.Key, m.Value); err != nil {
 			return err
 		}
+		if nvalue, err = ie.project(m.Value); err != nil {
+			return err
+		}
 	}
 	if len(m.OldValue) > 0 { // project old secondary key
 		if opkey, err = ie.partitionKey(m.OldValue); err != nil {
@@ -238,10 +329,10 @@ func (ie *IndexEvaluator) TransformRoute(
 			dkv, ok := data[raddr].(*c.DataportKeyVersions)
 			if !ok {
 				kv := c.NewKeyVersions(seqno, m.Key, 4)
-				kv.AddUpsert(uuid, nkey, okey)
+				kv.AddUpsertWithValue(uuid, nkey, okey, nvalue)
 				dkv = &c.DataportKeyVersions{bucket, vbno, vbuuid, kv}
 			} else {
-				dkv.Kv.AddUpsert(uuid, nkey, okey)
+				dkv.Kv.AddUpsertWithValue(uuid, nkey, okey, nvalue)
 			}
 			data[raddr] = dkv
 		}
@@ -263,13 +354,17 @@ func (ie *IndexEvaluator) TransformRoute(
 
 	case mcd.UPR_DELETION, mcd.UPR_EXPIRATION:
 		// Delete shall be broadcasted if old-key is not available.
+		// Expiration is a delete, published distinctly so that downstream
+		// can tell TTL expiry from deletion by application.
 		raddrs := instn.DeletionEndpoints(m, opkey, okey)
 		for _, raddr := range raddrs {
 			dkv, ok := data[raddr].(*c.DataportKeyVersions)
 			if !ok {
 				kv := c.NewKeyVersions(seqno, m.Key, 4)
-				kv.AddDeletion(uuid, okey)
 				dkv = &c.DataportKeyVersions{bucket, vbno, vbuuid, kv}
+			}
+			if m.Opcode == mcd.UPR_EXPIRATION {
+				dkv.Kv.AddExpiration(uuid, okey)
 			} else {
 				dkv.Kv.AddDeletion(uuid, okey)
 			}
@@ -279,6 +374,23 @@ func (ie *IndexEvaluator) TransformRoute(
 	return nil
 }
 
+// SecondaryKey evaluates the secondary key of document `doc`, as
+// projected by TransformRoute for a mutation, nil if the document is
+// not indexed.
+func (ie *IndexEvaluator) SecondaryKey(docid, doc []byte) (key []byte, err error) {
+	defer func() { // panic safe
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	where, err := ie.wherePredicate(doc)
+	if err != nil || !where || len(doc) == 0 {
+		return nil, err
+	}
+	return ie.evaluate(docid, doc)
+}
+
 func (ie *IndexEvaluator) evaluate(docid, doc []byte) ([]byte, error) {
 	defn := ie.instance.GetDefinition()
 	if defn.GetIsPrimary() { // primary index supported !!
@@ -289,7 +401,28 @@ func (ie *IndexEvaluator) evaluate(docid, doc []byte) ([]byte, error) {
 	switch exprType {
 	case ExprType_JavaScript:
 	case ExprType_N1QL:
-		return N1QLTransform(docid, doc, ie.skExprs)
+		secKey, err := N1QLTransform(docid, doc, ie.skExprs)
+		if err != nil || secKey == nil {
+			return secKey, err
+		}
+		return N1QLCollate(defn.GetCollation(), secKey)
+	}
+	return nil, nil
+}
+
+// project evaluates fields to be stored along with the index entry,
+// returns nil if index does not include any field.
+func (ie *IndexEvaluator) project(doc []byte) ([]byte, error) {
+	if len(ie.inExprs) == 0 {
+		return nil, nil
+	}
+
+	defn := ie.instance.GetDefinition()
+	exprType := defn.GetExprType()
+	switch exprType {
+	case ExprType_JavaScript:
+	case ExprType_N1QL:
+		return N1QLProject(doc, ie.inExprs)
 	}
 	return nil, nil
 }
@@ -188,6 +188,8 @@ type IndexInst struct {
 	Definition       *IndexDefn       `protobuf:"bytes,3,req,name=definition" json:"definition,omitempty"`
 	Tp               *TestPartition   `protobuf:"bytes,4,opt,name=tp" json:"tp,omitempty"`
 	SinglePartn      *SinglePartition `protobuf:"bytes,5,opt,name=singlePartn" json:"singlePartn,omitempty"`
+	VbucketRoutes    []*VbucketRoute  `protobuf:"bytes,9,rep,name=vbucketRoutes" json:"vbucketRoutes,omitempty"`
+	Namespace        *string          `protobuf:"bytes,10,opt,name=namespace" json:"namespace,omitempty"`
 	XXX_unrecognized []byte           `json:"-"`
 }
 
@@ -230,19 +232,65 @@ func (m *IndexInst) GetSinglePartn() *SinglePartition {
 	return nil
 }
 
+func (m *IndexInst) GetVbucketRoutes() []*VbucketRoute {
+	if m != nil {
+		return m.VbucketRoutes
+	}
+	return nil
+}
+
+func (m *IndexInst) GetNamespace() string {
+	if m != nil && m.Namespace != nil {
+		return *m.Namespace
+	}
+	return ""
+}
+
+// VbucketRoute restricts an endpoint of an instance to a subset of
+// vbuckets, mutations from other vbuckets are not routed to it.
+// Endpoints without a route receive mutations from all vbuckets, an
+// endpoint with several routes owns the vbuckets of all of them, vbnos
+// are sorted.
+type VbucketRoute struct {
+	Endpoint         *string  `protobuf:"bytes,1,req,name=endpoint" json:"endpoint,omitempty"`
+	Vbnos            []uint32 `protobuf:"varint,2,rep,name=vbnos" json:"vbnos,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *VbucketRoute) Reset()         { *m = VbucketRoute{} }
+func (m *VbucketRoute) String() string { return proto.CompactTextString(m) }
+func (*VbucketRoute) ProtoMessage()    {}
+
+func (m *VbucketRoute) GetEndpoint() string {
+	if m != nil && m.Endpoint != nil {
+		return *m.Endpoint
+	}
+	return ""
+}
+
+func (m *VbucketRoute) GetVbnos() []uint32 {
+	if m != nil {
+		return m.Vbnos
+	}
+	return nil
+}
+
 // Index DDL from create index statement.
 type IndexDefn struct {
-	DefnID           *uint64          `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
-	Bucket           *string          `protobuf:"bytes,2,req,name=bucket" json:"bucket,omitempty"`
-	IsPrimary        *bool            `protobuf:"varint,3,req,name=isPrimary" json:"isPrimary,omitempty"`
-	Name             *string          `protobuf:"bytes,4,req,name=name" json:"name,omitempty"`
-	Using            *StorageType     `protobuf:"varint,5,req,name=using,enum=protobuf.StorageType" json:"using,omitempty"`
-	ExprType         *ExprType        `protobuf:"varint,6,req,name=exprType,enum=protobuf.ExprType" json:"exprType,omitempty"`
-	SecExpressions   []string         `protobuf:"bytes,7,rep,name=secExpressions" json:"secExpressions,omitempty"`
-	PartitionScheme  *PartitionScheme `protobuf:"varint,8,opt,name=partitionScheme,enum=protobuf.PartitionScheme" json:"partitionScheme,omitempty"`
-	PartnExpression  *string          `protobuf:"bytes,9,opt,name=partnExpression" json:"partnExpression,omitempty"`
-	WhereExpression  *string          `protobuf:"bytes,10,opt,name=whereExpression" json:"whereExpression,omitempty"`
-	XXX_unrecognized []byte           `json:"-"`
+	DefnID             *uint64          `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
+	Bucket             *string          `protobuf:"bytes,2,req,name=bucket" json:"bucket,omitempty"`
+	IsPrimary          *bool            `protobuf:"varint,3,req,name=isPrimary" json:"isPrimary,omitempty"`
+	Name               *string          `protobuf:"bytes,4,req,name=name" json:"name,omitempty"`
+	Using              *StorageType     `protobuf:"varint,5,req,name=using,enum=protobuf.StorageType" json:"using,omitempty"`
+	ExprType           *ExprType        `protobuf:"varint,6,req,name=exprType,enum=protobuf.ExprType" json:"exprType,omitempty"`
+	SecExpressions     []string         `protobuf:"bytes,7,rep,name=secExpressions" json:"secExpressions,omitempty"`
+	PartitionScheme    *PartitionScheme `protobuf:"varint,8,opt,name=partitionScheme,enum=protobuf.PartitionScheme" json:"partitionScheme,omitempty"`
+	PartnExpression    *string          `protobuf:"bytes,9,opt,name=partnExpression" json:"partnExpression,omitempty"`
+	WhereExpression    *string          `protobuf:"bytes,10,opt,name=whereExpression" json:"whereExpression,omitempty"`
+	IncludeExpressions []string         `protobuf:"bytes,11,rep,name=includeExpressions" json:"includeExpressions,omitempty"`
+	BucketUUID         *string          `protobuf:"bytes,12,opt,name=bucketUUID" json:"bucketUUID,omitempty"`
+	Collation          *string          `protobuf:"bytes,13,opt,name=collation" json:"collation,omitempty"`
+	XXX_unrecognized   []byte           `json:"-"`
 }
 
 func (m *IndexDefn) Reset()         { *m = IndexDefn{} }
@@ -319,6 +367,27 @@ func (m *IndexDefn) GetWhereExpression() string {
 	return ""
 }
 
+func (m *IndexDefn) GetIncludeExpressions() []string {
+	if m != nil {
+		return m.IncludeExpressions
+	}
+	return nil
+}
+
+func (m *IndexDefn) GetBucketUUID() string {
+	if m != nil && m.BucketUUID != nil {
+		return *m.BucketUUID
+	}
+	return ""
+}
+
+func (m *IndexDefn) GetCollation() string {
+	if m != nil && m.Collation != nil {
+		return *m.Collation
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterEnum("protobuf.IndexState", IndexState_name, IndexState_value)
 	proto.RegisterEnum("protobuf.StorageType", StorageType_name, StorageType_value)
@@ -0,0 +1,68 @@
+package protobuf
+
+import (
+	"reflect"
+	"testing"
+
+	mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+	"github.com/couchbaselabs/goprotobuf/proto"
+)
+
+func TestVbucketRoutes(t *testing.T) {
+	defn := &IndexDefn{
+		Bucket:          proto.String("default"),
+		PartitionScheme: PartitionScheme_SINGLE.Enum(),
+	}
+	inst := &IndexInst{
+		Definition:  defn,
+		SinglePartn: NewSinglePartition([]string{"n1:9000", "n2:9000"}),
+	}
+	m := &mc.UprEvent{Opcode: mcd.UPR_MUTATION, VBucket: 3}
+
+	// without routes, all endpoints own all vbuckets.
+	ref := []string{"n1:9000", "n2:9000"}
+	if raddrs := inst.UpsertEndpoints(m, nil, nil, nil); !reflect.DeepEqual(raddrs, ref) {
+		t.Fatalf("expected %v, got %v", ref, raddrs)
+	}
+
+	inst.AddVbucketRoute("n1:9000", []uint16{7, 3, 1})
+	inst.AddVbucketRoute("n2:9000", []uint16{2, 4})
+	if vbnos := inst.GetVbucketRoutes()[0].GetVbnos(); !reflect.DeepEqual(vbnos, []uint32{1, 3, 7}) {
+		t.Fatalf("expected sorted vbnos, got %v", vbnos)
+	}
+
+	ref = []string{"n1:9000"}
+	if raddrs := inst.UpsertEndpoints(m, nil, nil, nil); !reflect.DeepEqual(raddrs, ref) {
+		t.Fatalf("expected %v, got %v", ref, raddrs)
+	}
+	if raddrs := inst.DeletionEndpoints(m, nil, nil); !reflect.DeepEqual(raddrs, ref) {
+		t.Fatalf("expected %v, got %v", ref, raddrs)
+	}
+	ref = []string{"n2:9000"}
+	if raddrs := inst.VbucketEndpoints(4); !reflect.DeepEqual(raddrs, ref) {
+		t.Fatalf("expected %v, got %v", ref, raddrs)
+	}
+	if raddrs := inst.VbucketEndpoints(5); len(raddrs) != 0 {
+		t.Fatalf("expected no endpoints, got %v", raddrs)
+	}
+
+	// an endpoint owns the vbuckets of all its routes.
+	inst.AddVbucketRoute("n2:9000", []uint16{5})
+	if raddrs := inst.VbucketEndpoints(5); !reflect.DeepEqual(raddrs, ref) {
+		t.Fatalf("expected %v, got %v", ref, raddrs)
+	}
+	if raddrs := inst.VbucketEndpoints(2); !reflect.DeepEqual(raddrs, ref) {
+		t.Fatalf("expected %v, got %v", ref, raddrs)
+	}
+	if raddrs := inst.VbucketEndpoints(6); len(raddrs) != 0 {
+		t.Fatalf("expected no endpoints, got %v", raddrs)
+	}
+
+	// endpoints without a route own all vbuckets.
+	inst.SinglePartn.AddEndpoint("n3:9000")
+	ref = []string{"n3:9000"}
+	if raddrs := inst.VbucketEndpoints(6); !reflect.DeepEqual(raddrs, ref) {
+		t.Fatalf("expected %v, got %v", ref, raddrs)
+	}
+}
@@ -1,5 +1,8 @@
 package protobuf
 
+import "bytes"
+import "encoding/json"
+
 import c "github.com/couchbase/indexing/secondary/common"
 import "github.com/couchbase/indexing/secondary/collatejson"
 import qexpr "github.com/couchbaselabs/query/expression"
@@ -70,3 +73,44 @@ func N1QLTransform(docid, doc []byte, cExprs []interface{}) ([]byte, error) {
 	}
 	return nil, nil
 }
+
+// N1QLCollate encodes string components of secondary key `secKey`, as
+// returned by N1QLTransform() with docid, as per index's `collation`.
+// The docid, last component, is left as is.
+func N1QLCollate(collation string, secKey []byte) ([]byte, error) {
+	if !c.IsCollated(collation) {
+		return secKey, nil
+	}
+	var values []interface{}
+	dec := json.NewDecoder(bytes.NewReader(secKey))
+	dec.UseNumber() // retain precision of numbers
+	if err := dec.Decode(&values); err != nil {
+		return nil, err
+	} else if len(values) == 0 {
+		return secKey, nil
+	}
+	n := len(values) - 1
+	collated := append(c.CollateValues(collation, values[:n]), values[n])
+	return json.Marshal(collated)
+}
+
+// N1QLProject will use compiled list of expressions, from N1QL's DDL
+// statement, to evaluate fields that are stored along with an index
+// entry. Always returns a JSON array, missing fields are projected as
+// `null`.
+func N1QLProject(doc []byte, cExprs []interface{}) ([]byte, error) {
+	context := qexpr.NewIndexContext()
+	docval := qvalue.NewValue(doc)
+	projected := qvalue.NewValue(make([]interface{}, len(cExprs)))
+	for i, cExpr := range cExprs {
+		expr := cExpr.(qexpr.Expression)
+		val, err := expr.Evaluate(docval, context)
+		if err != nil {
+			return nil, err
+		} else if val.Type() == qvalue.MISSING {
+			val = qvalue.NewValue(nil)
+		}
+		projected.SetIndex(i, val)
+	}
+	return projected.MarshalJSON()
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -66,6 +67,31 @@ func init() {
 	}
 }
 
+func TestN1QLCollate(t *testing.T) {
+	secKey := []byte(`["Daniel",12345678901234567890,"docid"]`)
+	out, err := N1QLCollate("caseinsensitive", secKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := `["daniel\u0000Daniel",12345678901234567890,"docid"]`
+	if string(out) != ref {
+		t.Fatalf("expected %v, got %v", ref, string(out))
+	}
+	out, err = N1QLCollate("unicode", secKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []interface{}
+	if err = json.Unmarshal(out, &values); err != nil {
+		t.Fatal(err)
+	} else if s := values[0].(string); !strings.HasSuffix(s, "\x00Daniel") {
+		t.Fatalf("unexpected unicode collated key %q", s)
+	}
+	if out, _ = N1QLCollate("binary", secKey); string(out) != string(secKey) {
+		t.Fatalf("binary collation shall not encode %v", string(out))
+	}
+}
+
 func TestN1QLTransform150(t *testing.T) {
 	cExprs, err := CompileN1QLExpression([]string{`city`, `age`})
 	if err != nil {
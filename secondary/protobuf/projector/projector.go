@@ -1,7 +1,7 @@
 package protobuf
 
 import "errors"
-import "sort"
+import "encoding/json"
 
 import c "github.com/couchbase/indexing/secondary/common"
 import "github.com/couchbase/indexing/secondary/dcp"
@@ -127,25 +127,22 @@ func (resp *VbmapResponse) LocateVbucket(vbno uint32) string {
 // AllVbuckets32 return all vbuckets hosted by all kvnodes
 // in sort order. vbuckets are returned as 32-bit values.
 func (resp *VbmapResponse) AllVbuckets32() []uint32 {
-	vbs := make([]uint32, 0)
-	for _, vs := range resp.GetKvvbnos() {
-		vbs = append(vbs, vs.GetVbnos()...)
-	}
-	vbuckets := c.Vbuckets(c.Vbno32to16(vbs))
-	sort.Sort(vbuckets)
-	return vbuckets.To32()
+	return resp.allVbuckets().Vbnos32()
 }
 
 // AllVbuckets16 return all vbuckets hosted by all kvnodes
 // in sort order. vbuckets are returned as 16-bit values.
 func (resp *VbmapResponse) AllVbuckets16() []uint16 {
-	vbs := make([]uint16, 0)
+	return resp.allVbuckets().Vbnos()
+}
+
+// allVbuckets return the set of vbuckets hosted by all kvnodes.
+func (resp *VbmapResponse) allVbuckets() *c.VbSet {
+	vbset := c.NewVbSet()
 	for _, vs := range resp.GetKvvbnos() {
-		vbs = append(vbs, c.Vbno32to16(vs.GetVbnos())...)
+		vbset = vbset.Union(c.NewVbSet32(vs.GetVbnos()))
 	}
-	vbuckets := c.Vbuckets(vbs)
-	sort.Sort(vbuckets)
-	return []uint16(vbuckets)
+	return vbset
 }
 
 //******************
@@ -241,6 +238,7 @@ func NewMutationTopicRequest(
 		EndpointType:  proto.String(endpointType),
 		ReqTimestamps: make([]*TsVbuuid, 0),
 		Instances:     instances,
+		Version:       proto.Uint32(c.StreamVersion),
 	}
 }
 
@@ -257,12 +255,40 @@ func (req *MutationTopicRequest) AddStreams(
 	return req
 }
 
+// SetToken sets the registration token issued for this topic, endpoints
+// frame their connection with this token.
+func (req *AddBucketsRequest) SetToken(token string) *AddBucketsRequest {
+	req.Token = proto.String(token)
+	return req
+}
+
 // Append add a request-timestamp for {pool,bucket} to this topic request.
 func (req *MutationTopicRequest) Append(reqTs *TsVbuuid) *MutationTopicRequest {
 	req.ReqTimestamps = append(req.ReqTimestamps, reqTs)
 	return req
 }
 
+// SetConfig overrides projector's feed settings, like channel sizes and
+// timeouts, for this topic. Settings are applied only when the topic's
+// feed is created.
+func (req *MutationTopicRequest) SetConfig(
+	config map[string]interface{}) (*MutationTopicRequest, error) {
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return req, err
+	}
+	req.Config = data
+	return req, nil
+}
+
+// SetToken sets the registration token issued for this topic, endpoints
+// frame their connection with this token.
+func (req *MutationTopicRequest) SetToken(token string) *MutationTopicRequest {
+	req.Token = proto.String(token)
+	return req
+}
+
 // ReqTimestampFor will get the requested vbucket-stream
 // timestamps for specified `bucket`.
 // TODO: Semantics of TsVbuuid has changed.
@@ -305,6 +331,111 @@ func (req *MutationTopicRequest) Decode(data []byte) (err error) {
 	return proto.Unmarshal(data, req)
 }
 
+// *******************
+// CatchupTopicRequest
+// *******************
+
+// NewCatchupTopicRequest creates a new CatchupTopicRequest
+// for `topic`.
+func NewCatchupTopicRequest(
+	topic, endpointType string, instances []*Instance) *CatchupTopicRequest {
+
+	return &CatchupTopicRequest{
+		Topic:             proto.String(topic),
+		EndpointType:      proto.String(endpointType),
+		RestartTimestamps: make([]*TsVbuuid, 0),
+		EndTimestamps:     make([]*TsVbuuid, 0),
+		Instances:         instances,
+		Version:           proto.Uint32(c.StreamVersion),
+	}
+}
+
+// Append add a restart-timestamp and an end-timestamp, with target
+// seqno for each vbucket, for {pool,bucket} to this topic request.
+func (req *CatchupTopicRequest) Append(
+	restartTs, endTs *TsVbuuid) *CatchupTopicRequest {
+
+	req.RestartTimestamps = append(req.RestartTimestamps, restartTs)
+	req.EndTimestamps = append(req.EndTimestamps, endTs)
+	return req
+}
+
+// SetConfig overrides projector's feed settings, like channel sizes and
+// timeouts, for this topic. Settings are applied only when the topic's
+// feed is created.
+func (req *CatchupTopicRequest) SetConfig(
+	config map[string]interface{}) (*CatchupTopicRequest, error) {
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return req, err
+	}
+	req.Config = data
+	return req, nil
+}
+
+// SetToken sets the registration token issued for this topic, endpoints
+// frame their connection with this token.
+func (req *CatchupTopicRequest) SetToken(token string) *CatchupTopicRequest {
+	req.Token = proto.String(token)
+	return req
+}
+
+// EndTimestampFor will get the end timestamp for specified `bucket`,
+// nil if there is none.
+func (req *CatchupTopicRequest) EndTimestampFor(bucket string) *TsVbuuid {
+	for _, ts := range req.GetEndTimestamps() {
+		if ts.GetBucket() == bucket {
+			return ts
+		}
+	}
+	return nil
+}
+
+// ToMutationTopicRequest returns the MutationTopicRequest that starts
+// streams for this catchup topic.
+func (req *CatchupTopicRequest) ToMutationTopicRequest() *MutationTopicRequest {
+	return &MutationTopicRequest{
+		Topic:         proto.String(req.GetTopic()),
+		EndpointType:  proto.String(req.GetEndpointType()),
+		ReqTimestamps: req.GetRestartTimestamps(),
+		Instances:     req.GetInstances(),
+		Config:        req.GetConfig(),
+		Token:         req.Token,
+		Version:       req.Version,
+	}
+}
+
+// GetEvaluators impelement Subscriber{} interface
+func (req *CatchupTopicRequest) GetEvaluators() (map[uint64]c.Evaluator, error) {
+	return getEvaluators(req.GetInstances())
+}
+
+// GetRouters impelement Subscriber{} interface
+func (req *CatchupTopicRequest) GetRouters() (map[uint64]c.Router, error) {
+	return getRouters(req.GetInstances())
+}
+
+// Name implement MessageMarshaller{} interface
+func (req *CatchupTopicRequest) Name() string {
+	return "catchupTopicRequest"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (req *CatchupTopicRequest) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (req *CatchupTopicRequest) Encode() (data []byte, err error) {
+	return proto.Marshal(req)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (req *CatchupTopicRequest) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, req)
+}
+
 // *************
 // TopicResponse
 // *************
@@ -346,8 +477,7 @@ func (resp *TopicResponse) AddRollbackTimestamp(
 	actTss := make([]*TsVbuuid, len(resp.GetActiveTimestamps()))
 	for i, actTs := range resp.GetActiveTimestamps() {
 		if actTs.GetBucket() == bucket {
-			vbnos := c.Vbno32to16(ts.GetVbnos())
-			actTss[i] = actTs.FilterByVbuckets(vbnos)
+			actTss[i] = actTs.FilterByVbSet(ts.VbSet())
 		} else {
 			actTss[i] = actTs
 		}
@@ -418,6 +548,15 @@ func (req *RestartVbucketsRequest) Append(
 	return req
 }
 
+// SetToken sets the registration token issued for this topic, endpoints
+// frame their connection with this token.
+func (req *RestartVbucketsRequest) SetToken(
+	token string) *RestartVbucketsRequest {
+
+	req.Token = proto.String(token)
+	return req
+}
+
 // RestartTimestampFor will get the requested vbucket-stream
 // timestamps for specified `bucket`.
 // TODO: Semantics of TsVbuuid has changed.
@@ -670,6 +809,132 @@ func (req *DelInstancesRequest) Decode(data []byte) (err error) {
 	return proto.Unmarshal(data, req)
 }
 
+// ***********************
+// DisableInstancesRequest
+// ***********************
+
+// NewDisableInstancesRequest creates a DisableInstancesRequest
+// for topic to stop routing mutations to one or more instances.
+func NewDisableInstancesRequest(topic string, uuids []uint64) *DisableInstancesRequest {
+	return &DisableInstancesRequest{
+		Topic:       proto.String(topic),
+		InstanceIds: uuids,
+	}
+}
+
+// Name implement MessageMarshaller{} interface
+func (req *DisableInstancesRequest) Name() string {
+	return "disableInstancesRequest"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (req *DisableInstancesRequest) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (req *DisableInstancesRequest) Encode() (data []byte, err error) {
+	return proto.Marshal(req)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (req *DisableInstancesRequest) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, req)
+}
+
+// **********************
+// EnableInstancesRequest
+// **********************
+
+// NewEnableInstancesRequest creates a EnableInstancesRequest
+// for topic to resume routing mutations to one or more disabled instances.
+func NewEnableInstancesRequest(topic string, uuids []uint64) *EnableInstancesRequest {
+	return &EnableInstancesRequest{
+		Topic:       proto.String(topic),
+		InstanceIds: uuids,
+	}
+}
+
+// Name implement MessageMarshaller{} interface
+func (req *EnableInstancesRequest) Name() string {
+	return "enableInstancesRequest"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (req *EnableInstancesRequest) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (req *EnableInstancesRequest) Encode() (data []byte, err error) {
+	return proto.Marshal(req)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (req *EnableInstancesRequest) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, req)
+}
+
+// *****************
+// PauseTopicRequest
+// *****************
+
+// NewPauseTopicRequest creates a PauseTopicRequest to stop draining and
+// routing mutations on topic until it is resumed.
+func NewPauseTopicRequest(topic string) *PauseTopicRequest {
+	return &PauseTopicRequest{Topic: proto.String(topic)}
+}
+
+// Name implement MessageMarshaller{} interface
+func (req *PauseTopicRequest) Name() string {
+	return "pauseTopicRequest"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (req *PauseTopicRequest) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (req *PauseTopicRequest) Encode() (data []byte, err error) {
+	return proto.Marshal(req)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (req *PauseTopicRequest) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, req)
+}
+
+// ******************
+// ResumeTopicRequest
+// ******************
+
+// NewResumeTopicRequest creates a ResumeTopicRequest for a topic paused
+// by PauseTopicRequest.
+func NewResumeTopicRequest(topic string) *ResumeTopicRequest {
+	return &ResumeTopicRequest{Topic: proto.String(topic)}
+}
+
+// Name implement MessageMarshaller{} interface
+func (req *ResumeTopicRequest) Name() string {
+	return "resumeTopicRequest"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (req *ResumeTopicRequest) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (req *ResumeTopicRequest) Encode() (data []byte, err error) {
+	return proto.Marshal(req)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (req *ResumeTopicRequest) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, req)
+}
+
 // **********************
 // RepairEndpointsRequest
 // **********************
@@ -705,6 +970,87 @@ func (req *RepairEndpointsRequest) Decode(data []byte) (err error) {
 	return proto.Unmarshal(data, req)
 }
 
+// ***********************
+// RepairEndpointsResponse
+// ***********************
+
+// NewRepairEndpointsResponse creates an empty RepairEndpointsResponse,
+// result for each endpoint can be added using AddEndpoint().
+func NewRepairEndpointsResponse() *RepairEndpointsResponse {
+	return &RepairEndpointsResponse{
+		Error:     proto.String(""), // Error message requires it
+		Endpoints: make([]*EndpointRepair, 0),
+	}
+}
+
+// AddEndpoint add result of repairing endpoint raddr, err is the reason
+// for EndpointFailed status.
+func (resp *RepairEndpointsResponse) AddEndpoint(
+	raddr string, status RepairStatus, err error) *RepairEndpointsResponse {
+
+	result := &EndpointRepair{
+		Raddr:  proto.String(raddr),
+		Status: status.Enum(),
+	}
+	if err != nil {
+		result.Err = NewError(err)
+	}
+	resp.Endpoints = append(resp.Endpoints, result)
+	return resp
+}
+
+// Failed return the endpoints that could not be repaired, and the
+// reason for each of them.
+func (resp *RepairEndpointsResponse) Failed() map[string]error {
+	failed := make(map[string]error)
+	for _, result := range resp.GetEndpoints() {
+		if result.GetStatus() == RepairStatus_EndpointFailed {
+			err := result.GetErr().ToError()
+			if err == nil {
+				err = errors.New("repair failed")
+			}
+			failed[result.GetRaddr()] = err
+		}
+	}
+	return failed
+}
+
+// Name implement MessageMarshaller{} interface
+func (resp *RepairEndpointsResponse) Name() string {
+	return "repairEndpointsResponse"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (resp *RepairEndpointsResponse) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (resp *RepairEndpointsResponse) Encode() (data []byte, err error) {
+	return proto.Marshal(resp)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (resp *RepairEndpointsResponse) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, resp)
+}
+
+// SetErr update request level error value in response.
+func (resp *RepairEndpointsResponse) SetErr(err error) *RepairEndpointsResponse {
+	e := NewError(err)
+	resp.Error, resp.Code, resp.Context = e.Error, e.Code, e.Context
+	return resp
+}
+
+// GetErr return request level error value in response.
+func (resp *RepairEndpointsResponse) GetErr() *Error {
+	return &Error{
+		Error:   proto.String(resp.GetError()),
+		Code:    proto.Uint32(resp.GetCode()),
+		Context: resp.Context,
+	}
+}
+
 // *************************
 // ShutdownTopicRequest
 // *************************
@@ -735,6 +1081,194 @@ func (req *ShutdownTopicRequest) Decode(data []byte) (err error) {
 	return proto.Unmarshal(data, req)
 }
 
+// **********************
+// TopicOperationsRequest
+// **********************
+
+// NewTopicOperationsRequest creates a TopicOperationsRequest for
+// topic, operations can be added using AddBuckets(), AddInstances()
+// and RestartVbuckets() before posting the request.
+func NewTopicOperationsRequest(topic string) *TopicOperationsRequest {
+	return &TopicOperationsRequest{Topic: proto.String(topic)}
+}
+
+// SetAddBuckets add AddBucketsRequest operation to this batch.
+func (req *TopicOperationsRequest) SetAddBuckets(
+	op *AddBucketsRequest) *TopicOperationsRequest {
+
+	req.AddBuckets = op
+	return req
+}
+
+// SetAddInstances add AddInstancesRequest operation to this batch.
+func (req *TopicOperationsRequest) SetAddInstances(
+	op *AddInstancesRequest) *TopicOperationsRequest {
+
+	req.AddInstances = op
+	return req
+}
+
+// SetRestartVbuckets add RestartVbucketsRequest operation to this batch.
+func (req *TopicOperationsRequest) SetRestartVbuckets(
+	op *RestartVbucketsRequest) *TopicOperationsRequest {
+
+	req.RestartVbuckets = op
+	return req
+}
+
+// Name implement MessageMarshaller{} interface
+func (req *TopicOperationsRequest) Name() string {
+	return "topicOperationsRequest"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (req *TopicOperationsRequest) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (req *TopicOperationsRequest) Encode() (data []byte, err error) {
+	return proto.Marshal(req)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (req *TopicOperationsRequest) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, req)
+}
+
+// ***********************
+// TopicOperationsResponse
+// ***********************
+
+// Name implement MessageMarshaller{} interface
+func (resp *TopicOperationsResponse) Name() string {
+	return "topicOperationsResponse"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (resp *TopicOperationsResponse) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (resp *TopicOperationsResponse) Encode() (data []byte, err error) {
+	return proto.Marshal(resp)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (resp *TopicOperationsResponse) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, resp)
+}
+
+// SetErr update request level error value in response.
+func (resp *TopicOperationsResponse) SetErr(err error) *TopicOperationsResponse {
+	resp.Err = NewError(err)
+	return resp
+}
+
+// Errors return the list of failed operations, in the order they
+// were applied, and an error value for each of them.
+func (resp *TopicOperationsResponse) Errors() ([]string, []error) {
+	ops, errs := make([]string, 0), make([]error, 0)
+	results := []struct {
+		op  string
+		err *Error
+	}{
+		{"addBuckets", resp.GetAddBuckets()},
+		{"addInstances", resp.GetAddInstances()},
+		{"restartVbuckets", resp.GetRestartVbuckets()},
+	}
+	for _, result := range results {
+		if err := result.err.ToError(); err != nil {
+			ops, errs = append(ops, result.op), append(errs, err)
+		}
+	}
+	return ops, errs
+}
+
+// FailedBuckets return the buckets for which operation `op` failed, and
+// an error value for each of them.
+func (resp *TopicOperationsResponse) FailedBuckets(op string) map[string]error {
+	failed := make(map[string]error)
+	for _, berr := range resp.GetBucketErrors() {
+		if berr.GetOperation() == op {
+			failed[berr.GetBucket()] = berr.GetErr().ToError()
+		}
+	}
+	return failed
+}
+
+// ************
+// ProbeRequest
+// ************
+
+// NewProbeRequest creates a ProbeRequest to check that bucket can be
+// streamed to endpoint raddr, for vbuckets vbnos or all vbuckets local to
+// projector if empty.
+func NewProbeRequest(
+	topic, endpointType, pooln, bucketn, raddr string,
+	vbnos []uint16) *ProbeRequest {
+
+	return &ProbeRequest{
+		Topic:           proto.String(topic),
+		EndpointType:    proto.String(endpointType),
+		Pool:            proto.String(pooln),
+		Bucket:          proto.String(bucketn),
+		EndpointAddress: proto.String(raddr),
+		Vbnos:           c.Vbno16to32(vbnos),
+	}
+}
+
+// Name implement MessageMarshaller{} interface
+func (req *ProbeRequest) Name() string {
+	return "probeRequest"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (req *ProbeRequest) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (req *ProbeRequest) Encode() (data []byte, err error) {
+	return proto.Marshal(req)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (req *ProbeRequest) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, req)
+}
+
+// *************
+// ProbeResponse
+// *************
+
+// Name implement MessageMarshaller{} interface
+func (resp *ProbeResponse) Name() string {
+	return "probeResponse"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (resp *ProbeResponse) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (resp *ProbeResponse) Encode() (data []byte, err error) {
+	return proto.Marshal(resp)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (resp *ProbeResponse) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, resp)
+}
+
+// SetErr update error value in response.
+func (resp *ProbeResponse) SetErr(err error) *ProbeResponse {
+	resp.Err = NewError(err)
+	return resp
+}
+
 //-- local functions
 
 // TODO: add other types of engines
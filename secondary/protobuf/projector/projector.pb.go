@@ -11,6 +11,43 @@ import math "math"
 var _ = proto.Marshal
 var _ = math.Inf
 
+// Outcome of repairing an endpoint.
+type RepairStatus int32
+
+const (
+	RepairStatus_EndpointRepaired RepairStatus = 1
+	RepairStatus_EndpointActive   RepairStatus = 2
+	RepairStatus_EndpointFailed   RepairStatus = 3
+)
+
+var RepairStatus_name = map[int32]string{
+	1: "EndpointRepaired",
+	2: "EndpointActive",
+	3: "EndpointFailed",
+}
+var RepairStatus_value = map[string]int32{
+	"EndpointRepaired": 1,
+	"EndpointActive":   2,
+	"EndpointFailed":   3,
+}
+
+func (x RepairStatus) Enum() *RepairStatus {
+	p := new(RepairStatus)
+	*p = x
+	return p
+}
+func (x RepairStatus) String() string {
+	return proto.EnumName(RepairStatus_name, int32(x))
+}
+func (x *RepairStatus) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(RepairStatus_value, data, "RepairStatus")
+	if err != nil {
+		return err
+	}
+	*x = RepairStatus(value)
+	return nil
+}
+
 // Requested by Coordinator/indexer to learn vbuckets
 // hosted by kvnodes.
 type VbmapRequest struct {
@@ -142,8 +179,17 @@ type MutationTopicRequest struct {
 	EndpointType  *string     `protobuf:"bytes,2,req,name=endpointType" json:"endpointType,omitempty"`
 	ReqTimestamps []*TsVbuuid `protobuf:"bytes,3,rep,name=reqTimestamps" json:"reqTimestamps,omitempty"`
 	// initial list of instances applicable for this topic
-	Instances        []*Instance `protobuf:"bytes,4,rep,name=instances" json:"instances,omitempty"`
-	XXX_unrecognized []byte      `json:"-"`
+	Instances []*Instance `protobuf:"bytes,4,rep,name=instances" json:"instances,omitempty"`
+	// JSON encoded feed settings, overriding projector's settings for
+	// this topic. Applied only when the feed is created.
+	Config []byte `protobuf:"bytes,5,opt,name=config" json:"config,omitempty"`
+	// registration token, issued by the indexer for this topic, framed
+	// by endpoints on their connection.
+	Token *string `protobuf:"bytes,6,opt,name=token" json:"token,omitempty"`
+	// version of mutation stream understood by endpoints, commands
+	// introduced after this version are not sent downstream.
+	Version          *uint32 `protobuf:"varint,7,opt,name=version" json:"version,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
 func (m *MutationTopicRequest) Reset()         { *m = MutationTopicRequest{} }
@@ -178,15 +224,51 @@ func (m *MutationTopicRequest) GetInstances() []*Instance {
 	return nil
 }
 
-// Response back for
-// MutationTopicRequest, RestartVbucketsRequest, AddBucketsRequest
+func (m *MutationTopicRequest) GetConfig() []byte {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+func (m *MutationTopicRequest) GetToken() string {
+	if m != nil && m.Token != nil {
+		return *m.Token
+	}
+	return ""
+}
+
+func (m *MutationTopicRequest) GetVersion() uint32 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
+// Response back for MutationTopicRequest, CatchupTopicRequest,
+// RestartVbucketsRequest, AddBucketsRequest
 type TopicResponse struct {
 	Topic              *string     `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
 	InstanceIds        []uint64    `protobuf:"varint,2,rep,name=instanceIds" json:"instanceIds,omitempty"`
 	ActiveTimestamps   []*TsVbuuid `protobuf:"bytes,3,rep,name=activeTimestamps" json:"activeTimestamps,omitempty"`
 	RollbackTimestamps []*TsVbuuid `protobuf:"bytes,4,rep,name=rollbackTimestamps" json:"rollbackTimestamps,omitempty"`
 	Err                *Error      `protobuf:"bytes,5,opt,name=err" json:"err,omitempty"`
-	XXX_unrecognized   []byte      `json:"-"`
+	StaleBuckets       []string    `protobuf:"bytes,6,rep,name=staleBuckets" json:"staleBuckets,omitempty"`
+	RestartTimestamps  []*TsVbuuid `protobuf:"bytes,7,rep,name=restartTimestamps" json:"restartTimestamps,omitempty"`
+	CatchupTimestamps  []*TsVbuuid `protobuf:"bytes,8,rep,name=catchupTimestamps" json:"catchupTimestamps,omitempty"`
+	// instances disabled by DisableInstancesRequest, mutations are not
+	// routed to them until they are enabled again.
+	DisabledInstanceIds []uint64 `protobuf:"varint,9,rep,name=disabledInstanceIds" json:"disabledInstanceIds,omitempty"`
+	// time spent in each phase of starting streams, per bucket, by the
+	// last request that started or restarted vbucket streams.
+	Timings []*BucketTimings `protobuf:"bytes,10,rep,name=timings" json:"timings,omitempty"`
+	// topic paused by PauseTopicRequest, mutations are neither drained
+	// from upstream nor routed to endpoints until it is resumed.
+	Paused *bool `protobuf:"varint,11,opt,name=paused" json:"paused,omitempty"`
+	// registration token accepted for the topic, endpoints frame their
+	// connection with it. Not set by projectors that predate tokens.
+	Token            *string `protobuf:"bytes,12,opt,name=token" json:"token,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
 func (m *TopicResponse) Reset()         { *m = TopicResponse{} }
@@ -228,13 +310,199 @@ func (m *TopicResponse) GetErr() *Error {
 	return nil
 }
 
+func (m *TopicResponse) GetStaleBuckets() []string {
+	if m != nil {
+		return m.StaleBuckets
+	}
+	return nil
+}
+
+func (m *TopicResponse) GetRestartTimestamps() []*TsVbuuid {
+	if m != nil {
+		return m.RestartTimestamps
+	}
+	return nil
+}
+
+func (m *TopicResponse) GetCatchupTimestamps() []*TsVbuuid {
+	if m != nil {
+		return m.CatchupTimestamps
+	}
+	return nil
+}
+
+func (m *TopicResponse) GetDisabledInstanceIds() []uint64 {
+	if m != nil {
+		return m.DisabledInstanceIds
+	}
+	return nil
+}
+
+func (m *TopicResponse) GetTimings() []*BucketTimings {
+	if m != nil {
+		return m.Timings
+	}
+	return nil
+}
+
+func (m *TopicResponse) GetPaused() bool {
+	if m != nil && m.Paused != nil {
+		return *m.Paused
+	}
+	return false
+}
+
+func (m *TopicResponse) GetToken() string {
+	if m != nil && m.Token != nil {
+		return *m.Token
+	}
+	return ""
+}
+
+// Time, in nanoseconds, spent by projector in each phase of starting
+// vbucket streams for a bucket. Phases repeated on retries and for
+// batches of StreamRequests are accumulated.
+type BucketTimings struct {
+	Bucket            *string `protobuf:"bytes,1,req,name=bucket" json:"bucket,omitempty"`
+	VbmapTime         *int64  `protobuf:"varint,2,opt,name=vbmapTime" json:"vbmapTime,omitempty"`
+	FailoverLogTime   *int64  `protobuf:"varint,3,opt,name=failoverLogTime" json:"failoverLogTime,omitempty"`
+	StreamRequestTime *int64  `protobuf:"varint,4,opt,name=streamRequestTime" json:"streamRequestTime,omitempty"`
+	FeedbackTime      *int64  `protobuf:"varint,5,opt,name=feedbackTime" json:"feedbackTime,omitempty"`
+	XXX_unrecognized  []byte  `json:"-"`
+}
+
+func (m *BucketTimings) Reset()         { *m = BucketTimings{} }
+func (m *BucketTimings) String() string { return proto.CompactTextString(m) }
+func (*BucketTimings) ProtoMessage()    {}
+
+func (m *BucketTimings) GetBucket() string {
+	if m != nil && m.Bucket != nil {
+		return *m.Bucket
+	}
+	return ""
+}
+
+func (m *BucketTimings) GetVbmapTime() int64 {
+	if m != nil && m.VbmapTime != nil {
+		return *m.VbmapTime
+	}
+	return 0
+}
+
+func (m *BucketTimings) GetFailoverLogTime() int64 {
+	if m != nil && m.FailoverLogTime != nil {
+		return *m.FailoverLogTime
+	}
+	return 0
+}
+
+func (m *BucketTimings) GetStreamRequestTime() int64 {
+	if m != nil && m.StreamRequestTime != nil {
+		return *m.StreamRequestTime
+	}
+	return 0
+}
+
+func (m *BucketTimings) GetFeedbackTime() int64 {
+	if m != nil && m.FeedbackTime != nil {
+		return *m.FeedbackTime
+	}
+	return 0
+}
+
+// Requested by indexer to start a catchup topic. Vbucket streams are
+// started from restartTimestamps and each one of them is ended once it
+// reaches the seqno in endTimestamps, after which StreamEnd is sent
+// downstream for the vbucket. Respond back with TopicResponse.
+type CatchupTopicRequest struct {
+	Topic             *string     `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
+	EndpointType      *string     `protobuf:"bytes,2,req,name=endpointType" json:"endpointType,omitempty"`
+	RestartTimestamps []*TsVbuuid `protobuf:"bytes,3,rep,name=restartTimestamps" json:"restartTimestamps,omitempty"`
+	EndTimestamps     []*TsVbuuid `protobuf:"bytes,4,rep,name=endTimestamps" json:"endTimestamps,omitempty"`
+	// initial list of instances applicable for this topic
+	Instances []*Instance `protobuf:"bytes,5,rep,name=instances" json:"instances,omitempty"`
+	// JSON encoded feed settings, overriding projector's settings for
+	// this topic. Applied only when the feed is created.
+	Config []byte `protobuf:"bytes,6,opt,name=config" json:"config,omitempty"`
+	// registration token, issued by the indexer for this topic, framed
+	// by endpoints on their connection.
+	Token *string `protobuf:"bytes,7,opt,name=token" json:"token,omitempty"`
+	// version of mutation stream understood by endpoints, commands
+	// introduced after this version are not sent downstream.
+	Version          *uint32 `protobuf:"varint,8,opt,name=version" json:"version,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *CatchupTopicRequest) Reset()         { *m = CatchupTopicRequest{} }
+func (m *CatchupTopicRequest) String() string { return proto.CompactTextString(m) }
+func (*CatchupTopicRequest) ProtoMessage()    {}
+
+func (m *CatchupTopicRequest) GetTopic() string {
+	if m != nil && m.Topic != nil {
+		return *m.Topic
+	}
+	return ""
+}
+
+func (m *CatchupTopicRequest) GetEndpointType() string {
+	if m != nil && m.EndpointType != nil {
+		return *m.EndpointType
+	}
+	return ""
+}
+
+func (m *CatchupTopicRequest) GetRestartTimestamps() []*TsVbuuid {
+	if m != nil {
+		return m.RestartTimestamps
+	}
+	return nil
+}
+
+func (m *CatchupTopicRequest) GetEndTimestamps() []*TsVbuuid {
+	if m != nil {
+		return m.EndTimestamps
+	}
+	return nil
+}
+
+func (m *CatchupTopicRequest) GetInstances() []*Instance {
+	if m != nil {
+		return m.Instances
+	}
+	return nil
+}
+
+func (m *CatchupTopicRequest) GetConfig() []byte {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+func (m *CatchupTopicRequest) GetToken() string {
+	if m != nil && m.Token != nil {
+		return *m.Token
+	}
+	return ""
+}
+
+func (m *CatchupTopicRequest) GetVersion() uint32 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
 // RestartVbucketsRequest will restart a subset
 // of vbuckets for each specified buckets.
 // Respond back with TopicResponse
 type RestartVbucketsRequest struct {
 	Topic             *string     `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
 	RestartTimestamps []*TsVbuuid `protobuf:"bytes,2,rep,name=restartTimestamps" json:"restartTimestamps,omitempty"`
-	XXX_unrecognized  []byte      `json:"-"`
+	// registration token, issued by the indexer for this topic, framed
+	// by endpoints on their connection.
+	Token            *string `protobuf:"bytes,3,opt,name=token" json:"token,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
 func (m *RestartVbucketsRequest) Reset()         { *m = RestartVbucketsRequest{} }
@@ -255,6 +523,13 @@ func (m *RestartVbucketsRequest) GetRestartTimestamps() []*TsVbuuid {
 	return nil
 }
 
+func (m *RestartVbucketsRequest) GetToken() string {
+	if m != nil && m.Token != nil {
+		return *m.Token
+	}
+	return ""
+}
+
 // ShutdownVbucketsRequest will shutdown a subset of vbuckets
 // for each specified buckets. Respond back with TopicResponse
 type ShutdownVbucketsRequest struct {
@@ -289,8 +564,11 @@ type AddBucketsRequest struct {
 	Topic         *string     `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
 	ReqTimestamps []*TsVbuuid `protobuf:"bytes,2,rep,name=reqTimestamps" json:"reqTimestamps,omitempty"`
 	// list of instances applicable for buckets.
-	Instances        []*Instance `protobuf:"bytes,3,rep,name=instances" json:"instances,omitempty"`
-	XXX_unrecognized []byte      `json:"-"`
+	Instances []*Instance `protobuf:"bytes,3,rep,name=instances" json:"instances,omitempty"`
+	// registration token, issued by the indexer for this topic, framed
+	// by endpoints on their connection.
+	Token            *string `protobuf:"bytes,4,opt,name=token" json:"token,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
 func (m *AddBucketsRequest) Reset()         { *m = AddBucketsRequest{} }
@@ -318,6 +596,13 @@ func (m *AddBucketsRequest) GetInstances() []*Instance {
 	return nil
 }
 
+func (m *AddBucketsRequest) GetToken() string {
+	if m != nil && m.Token != nil {
+		return *m.Token
+	}
+	return ""
+}
+
 // DelBucketsRequest will shutdown vbucket-streams
 // for specified buckets and remove the buckets from topic.
 // Respond back with TopicResponse
@@ -397,11 +682,106 @@ func (m *DelInstancesRequest) GetInstanceIds() []uint64 {
 	return nil
 }
 
+// Requested by indexer / coordinator to stop routing mutations to
+// index-instances on a topic, say while their slice is under repair.
+// Instances are retained on the topic and can be enabled again with
+// EnableInstancesRequest. Error message will be sent as response.
+type DisableInstancesRequest struct {
+	Topic            *string  `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
+	InstanceIds      []uint64 `protobuf:"varint,2,rep,name=instanceIds" json:"instanceIds,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *DisableInstancesRequest) Reset()         { *m = DisableInstancesRequest{} }
+func (m *DisableInstancesRequest) String() string { return proto.CompactTextString(m) }
+func (*DisableInstancesRequest) ProtoMessage()    {}
+
+func (m *DisableInstancesRequest) GetTopic() string {
+	if m != nil && m.Topic != nil {
+		return *m.Topic
+	}
+	return ""
+}
+
+func (m *DisableInstancesRequest) GetInstanceIds() []uint64 {
+	if m != nil {
+		return m.InstanceIds
+	}
+	return nil
+}
+
+// Requested by indexer / coordinator to resume routing mutations to
+// index-instances disabled on a topic. Error message will be sent as
+// response.
+type EnableInstancesRequest struct {
+	Topic            *string  `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
+	InstanceIds      []uint64 `protobuf:"varint,2,rep,name=instanceIds" json:"instanceIds,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *EnableInstancesRequest) Reset()         { *m = EnableInstancesRequest{} }
+func (m *EnableInstancesRequest) String() string { return proto.CompactTextString(m) }
+func (*EnableInstancesRequest) ProtoMessage()    {}
+
+func (m *EnableInstancesRequest) GetTopic() string {
+	if m != nil && m.Topic != nil {
+		return *m.Topic
+	}
+	return ""
+}
+
+func (m *EnableInstancesRequest) GetInstanceIds() []uint64 {
+	if m != nil {
+		return m.InstanceIds
+	}
+	return nil
+}
+
+// Requested by indexer / coordinator to temporarily stop draining
+// mutations from upstream and routing them to endpoints, streams and
+// their book-keeping are retained until the topic is resumed with
+// ResumeTopicRequest. Error message will be sent as response.
+type PauseTopicRequest struct {
+	Topic            *string `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *PauseTopicRequest) Reset()         { *m = PauseTopicRequest{} }
+func (m *PauseTopicRequest) String() string { return proto.CompactTextString(m) }
+func (*PauseTopicRequest) ProtoMessage()    {}
+
+func (m *PauseTopicRequest) GetTopic() string {
+	if m != nil && m.Topic != nil {
+		return *m.Topic
+	}
+	return ""
+}
+
+// Requested by indexer / coordinator to resume a topic paused by
+// PauseTopicRequest. Error message will be sent as response.
+type ResumeTopicRequest struct {
+	Topic            *string `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *ResumeTopicRequest) Reset()         { *m = ResumeTopicRequest{} }
+func (m *ResumeTopicRequest) String() string { return proto.CompactTextString(m) }
+func (*ResumeTopicRequest) ProtoMessage()    {}
+
+func (m *ResumeTopicRequest) GetTopic() string {
+	if m != nil && m.Topic != nil {
+		return *m.Topic
+	}
+	return ""
+}
+
 // Requested by indexer / coordinator to inform router to re-connect with
-// downstream endpoint. Error message will be sent as response.
+// downstream endpoint. Error message will be sent as response, unless
+// `results` is set, in which case RepairEndpointsResponse will be sent.
 type RepairEndpointsRequest struct {
 	Topic            *string  `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
 	Endpoints        []string `protobuf:"bytes,2,rep,name=endpoints" json:"endpoints,omitempty"`
+	Results          *bool    `protobuf:"varint,3,opt,name=results" json:"results,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -423,6 +803,89 @@ func (m *RepairEndpointsRequest) GetEndpoints() []string {
 	return nil
 }
 
+func (m *RepairEndpointsRequest) GetResults() bool {
+	if m != nil && m.Results != nil {
+		return *m.Results
+	}
+	return false
+}
+
+// Response back for RepairEndpointsRequest, one result for each
+// requested endpoint. First two fields are laid out as in Error message,
+// so that older projectors' response can be decoded as well.
+type RepairEndpointsResponse struct {
+	Error            *string           `protobuf:"bytes,1,opt,name=error" json:"error,omitempty"`
+	Code             *uint32           `protobuf:"varint,2,opt,name=code" json:"code,omitempty"`
+	Context          *string           `protobuf:"bytes,3,opt,name=context" json:"context,omitempty"`
+	Endpoints        []*EndpointRepair `protobuf:"bytes,4,rep,name=endpoints" json:"endpoints,omitempty"`
+	XXX_unrecognized []byte            `json:"-"`
+}
+
+func (m *RepairEndpointsResponse) Reset()         { *m = RepairEndpointsResponse{} }
+func (m *RepairEndpointsResponse) String() string { return proto.CompactTextString(m) }
+func (*RepairEndpointsResponse) ProtoMessage()    {}
+
+func (m *RepairEndpointsResponse) GetError() string {
+	if m != nil && m.Error != nil {
+		return *m.Error
+	}
+	return ""
+}
+
+func (m *RepairEndpointsResponse) GetCode() uint32 {
+	if m != nil && m.Code != nil {
+		return *m.Code
+	}
+	return 0
+}
+
+func (m *RepairEndpointsResponse) GetContext() string {
+	if m != nil && m.Context != nil {
+		return *m.Context
+	}
+	return ""
+}
+
+func (m *RepairEndpointsResponse) GetEndpoints() []*EndpointRepair {
+	if m != nil {
+		return m.Endpoints
+	}
+	return nil
+}
+
+// Result of repairing an endpoint.
+type EndpointRepair struct {
+	Raddr            *string       `protobuf:"bytes,1,req,name=raddr" json:"raddr,omitempty"`
+	Status           *RepairStatus `protobuf:"varint,2,req,name=status,enum=protobuf.RepairStatus" json:"status,omitempty"`
+	Err              *Error        `protobuf:"bytes,3,opt,name=err" json:"err,omitempty"`
+	XXX_unrecognized []byte        `json:"-"`
+}
+
+func (m *EndpointRepair) Reset()         { *m = EndpointRepair{} }
+func (m *EndpointRepair) String() string { return proto.CompactTextString(m) }
+func (*EndpointRepair) ProtoMessage()    {}
+
+func (m *EndpointRepair) GetRaddr() string {
+	if m != nil && m.Raddr != nil {
+		return *m.Raddr
+	}
+	return ""
+}
+
+func (m *EndpointRepair) GetStatus() RepairStatus {
+	if m != nil && m.Status != nil {
+		return *m.Status
+	}
+	return RepairStatus_EndpointRepaired
+}
+
+func (m *EndpointRepair) GetErr() *Error {
+	if m != nil {
+		return m.Err
+	}
+	return nil
+}
+
 // Requested by coordinator to should down a mutation topic and all KV
 // connections active for that topic. Error message will be sent as response.
 type ShutdownTopicRequest struct {
@@ -441,6 +904,262 @@ func (m *ShutdownTopicRequest) GetTopic() string {
 	return ""
 }
 
+// TopicOperationsRequest applies a batch of changes to an existing topic
+// as a single unit, no other request on the topic is interleaved with
+// it. Operations are applied in the order AddBuckets, AddInstances,
+// RestartVbuckets, a failed operation does not prevent the next one.
+// Respond back with TopicOperationsResponse.
+type TopicOperationsRequest struct {
+	Topic            *string                 `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
+	AddBuckets       *AddBucketsRequest      `protobuf:"bytes,2,opt,name=addBuckets" json:"addBuckets,omitempty"`
+	AddInstances     *AddInstancesRequest    `protobuf:"bytes,3,opt,name=addInstances" json:"addInstances,omitempty"`
+	RestartVbuckets  *RestartVbucketsRequest `protobuf:"bytes,4,opt,name=restartVbuckets" json:"restartVbuckets,omitempty"`
+	XXX_unrecognized []byte                  `json:"-"`
+}
+
+func (m *TopicOperationsRequest) Reset()         { *m = TopicOperationsRequest{} }
+func (m *TopicOperationsRequest) String() string { return proto.CompactTextString(m) }
+func (*TopicOperationsRequest) ProtoMessage()    {}
+
+func (m *TopicOperationsRequest) GetTopic() string {
+	if m != nil && m.Topic != nil {
+		return *m.Topic
+	}
+	return ""
+}
+
+func (m *TopicOperationsRequest) GetAddBuckets() *AddBucketsRequest {
+	if m != nil {
+		return m.AddBuckets
+	}
+	return nil
+}
+
+func (m *TopicOperationsRequest) GetAddInstances() *AddInstancesRequest {
+	if m != nil {
+		return m.AddInstances
+	}
+	return nil
+}
+
+func (m *TopicOperationsRequest) GetRestartVbuckets() *RestartVbucketsRequest {
+	if m != nil {
+		return m.RestartVbuckets
+	}
+	return nil
+}
+
+// Response back for TopicOperationsRequest, one result for each
+// requested operation, empty error string means success.
+type TopicOperationsResponse struct {
+	Response         *TopicResponse `protobuf:"bytes,1,opt,name=response" json:"response,omitempty"`
+	AddBuckets       *Error         `protobuf:"bytes,2,opt,name=addBuckets" json:"addBuckets,omitempty"`
+	AddInstances     *Error         `protobuf:"bytes,3,opt,name=addInstances" json:"addInstances,omitempty"`
+	RestartVbuckets  *Error         `protobuf:"bytes,4,opt,name=restartVbuckets" json:"restartVbuckets,omitempty"`
+	Err              *Error         `protobuf:"bytes,5,opt,name=err" json:"err,omitempty"`
+	BucketErrors     []*BucketError `protobuf:"bytes,6,rep,name=bucketErrors" json:"bucketErrors,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
+}
+
+func (m *TopicOperationsResponse) Reset()         { *m = TopicOperationsResponse{} }
+func (m *TopicOperationsResponse) String() string { return proto.CompactTextString(m) }
+func (*TopicOperationsResponse) ProtoMessage()    {}
+
+func (m *TopicOperationsResponse) GetResponse() *TopicResponse {
+	if m != nil {
+		return m.Response
+	}
+	return nil
+}
+
+func (m *TopicOperationsResponse) GetAddBuckets() *Error {
+	if m != nil {
+		return m.AddBuckets
+	}
+	return nil
+}
+
+func (m *TopicOperationsResponse) GetAddInstances() *Error {
+	if m != nil {
+		return m.AddInstances
+	}
+	return nil
+}
+
+func (m *TopicOperationsResponse) GetRestartVbuckets() *Error {
+	if m != nil {
+		return m.RestartVbuckets
+	}
+	return nil
+}
+
+func (m *TopicOperationsResponse) GetErr() *Error {
+	if m != nil {
+		return m.Err
+	}
+	return nil
+}
+
+func (m *TopicOperationsResponse) GetBucketErrors() []*BucketError {
+	if m != nil {
+		return m.BucketErrors
+	}
+	return nil
+}
+
+// BucketError of an operation of TopicOperationsRequest that failed for
+// a bucket, the operation may have succeeded for other buckets.
+type BucketError struct {
+	Operation        *string `protobuf:"bytes,1,req,name=operation" json:"operation,omitempty"`
+	Bucket           *string `protobuf:"bytes,2,req,name=bucket" json:"bucket,omitempty"`
+	Err              *Error  `protobuf:"bytes,3,req,name=err" json:"err,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *BucketError) Reset()         { *m = BucketError{} }
+func (m *BucketError) String() string { return proto.CompactTextString(m) }
+func (*BucketError) ProtoMessage()    {}
+
+func (m *BucketError) GetOperation() string {
+	if m != nil && m.Operation != nil {
+		return *m.Operation
+	}
+	return ""
+}
+
+func (m *BucketError) GetBucket() string {
+	if m != nil && m.Bucket != nil {
+		return *m.Bucket
+	}
+	return ""
+}
+
+func (m *BucketError) GetErr() *Error {
+	if m != nil {
+		return m.Err
+	}
+	return nil
+}
+
+// Requested by indexer to check, before starting a topic, that projector
+// can stream a bucket to a downstream endpoint. Projector validates the
+// failover logs of vbuckets, opens a DCP connection with the bucket and
+// connects with the endpoint, then tears everything down without
+// starting a topic. Respond back with ProbeResponse.
+type ProbeRequest struct {
+	Topic            *string  `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
+	EndpointType     *string  `protobuf:"bytes,2,req,name=endpointType" json:"endpointType,omitempty"`
+	Pool             *string  `protobuf:"bytes,3,req,name=pool" json:"pool,omitempty"`
+	Bucket           *string  `protobuf:"bytes,4,req,name=bucket" json:"bucket,omitempty"`
+	EndpointAddress  *string  `protobuf:"bytes,5,req,name=endpointAddress" json:"endpointAddress,omitempty"`
+	Vbnos            []uint32 `protobuf:"varint,6,rep,name=vbnos" json:"vbnos,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *ProbeRequest) Reset()         { *m = ProbeRequest{} }
+func (m *ProbeRequest) String() string { return proto.CompactTextString(m) }
+func (*ProbeRequest) ProtoMessage()    {}
+
+func (m *ProbeRequest) GetTopic() string {
+	if m != nil && m.Topic != nil {
+		return *m.Topic
+	}
+	return ""
+}
+
+func (m *ProbeRequest) GetEndpointType() string {
+	if m != nil && m.EndpointType != nil {
+		return *m.EndpointType
+	}
+	return ""
+}
+
+func (m *ProbeRequest) GetPool() string {
+	if m != nil && m.Pool != nil {
+		return *m.Pool
+	}
+	return ""
+}
+
+func (m *ProbeRequest) GetBucket() string {
+	if m != nil && m.Bucket != nil {
+		return *m.Bucket
+	}
+	return ""
+}
+
+func (m *ProbeRequest) GetEndpointAddress() string {
+	if m != nil && m.EndpointAddress != nil {
+		return *m.EndpointAddress
+	}
+	return ""
+}
+
+func (m *ProbeRequest) GetVbnos() []uint32 {
+	if m != nil {
+		return m.Vbnos
+	}
+	return nil
+}
+
+// Response back for ProbeRequest, diagnostics are filled in upto the
+// step that failed, if any.
+type ProbeResponse struct {
+	BucketUUID       *string  `protobuf:"bytes,1,opt,name=bucketUUID" json:"bucketUUID,omitempty"`
+	Vbnos            []uint32 `protobuf:"varint,2,rep,name=vbnos" json:"vbnos,omitempty"`
+	InvalidVbnos     []uint32 `protobuf:"varint,3,rep,name=invalidVbnos" json:"invalidVbnos,omitempty"`
+	DcpTime          *int64   `protobuf:"varint,4,opt,name=dcpTime" json:"dcpTime,omitempty"`
+	EndpointTime     *int64   `protobuf:"varint,5,opt,name=endpointTime" json:"endpointTime,omitempty"`
+	Err              *Error   `protobuf:"bytes,6,opt,name=err" json:"err,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *ProbeResponse) Reset()         { *m = ProbeResponse{} }
+func (m *ProbeResponse) String() string { return proto.CompactTextString(m) }
+func (*ProbeResponse) ProtoMessage()    {}
+
+func (m *ProbeResponse) GetBucketUUID() string {
+	if m != nil && m.BucketUUID != nil {
+		return *m.BucketUUID
+	}
+	return ""
+}
+
+func (m *ProbeResponse) GetVbnos() []uint32 {
+	if m != nil {
+		return m.Vbnos
+	}
+	return nil
+}
+
+func (m *ProbeResponse) GetInvalidVbnos() []uint32 {
+	if m != nil {
+		return m.InvalidVbnos
+	}
+	return nil
+}
+
+func (m *ProbeResponse) GetDcpTime() int64 {
+	if m != nil && m.DcpTime != nil {
+		return *m.DcpTime
+	}
+	return 0
+}
+
+func (m *ProbeResponse) GetEndpointTime() int64 {
+	if m != nil && m.EndpointTime != nil {
+		return *m.EndpointTime
+	}
+	return 0
+}
+
+func (m *ProbeResponse) GetErr() *Error {
+	if m != nil {
+		return m.Err
+	}
+	return nil
+}
+
 // Generic instance, can be an index instance, xdcr, search etc ...
 type Instance struct {
 	IndexInstance    *IndexInst `protobuf:"bytes,1,opt,name=indexInstance" json:"indexInstance,omitempty"`
@@ -476,4 +1195,5 @@ func (m *Instances) GetInstances() []*Instance {
 }
 
 func init() {
+	proto.RegisterEnum("protobuf.RepairStatus", RepairStatus_name, RepairStatus_value)
 }
@@ -0,0 +1,127 @@
+package protobuf
+
+import (
+	"errors"
+	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+func TestRepairEndpointsResponse(t *testing.T) {
+	resp := NewRepairEndpointsResponse()
+	resp.AddEndpoint("n1:9000", RepairStatus_EndpointRepaired, nil)
+	resp.AddEndpoint("n2:9000", RepairStatus_EndpointActive, nil)
+	resp.AddEndpoint("n3:9000", RepairStatus_EndpointFailed, errors.New("refused"))
+
+	data, err := resp.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = &RepairEndpointsResponse{}
+	if err := resp.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(resp.GetEndpoints()); n != 3 {
+		t.Fatalf("expected 3 endpoints, got %v", n)
+	}
+	if status := resp.GetEndpoints()[1].GetStatus(); status != RepairStatus_EndpointActive {
+		t.Errorf("expected %v, got %v", RepairStatus_EndpointActive, status)
+	}
+	failed := resp.Failed()
+	if len(failed) != 1 {
+		t.Fatalf("expected 1 failed endpoint, got %v", failed)
+	}
+	if err := failed["n3:9000"]; err == nil || err.Error() != "refused" {
+		t.Errorf("expected failure reason, got %v", err)
+	}
+	if err := resp.GetErr().ToError(); err != nil {
+		t.Errorf("expected no request level error, got %v", err)
+	}
+}
+
+func TestRepairEndpointsResponseCompat(t *testing.T) {
+	// older projectors respond back with Error message.
+	data, err := NewError(errors.New("topic missing")).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &RepairEndpointsResponse{}
+	if err := resp.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.GetErr().ToError(); err == nil || err.Error() != "topic missing" {
+		t.Errorf("expected request level error, got %v", err)
+	}
+	if n := len(resp.Failed()); n != 0 {
+		t.Errorf("expected no failed endpoint, got %v", n)
+	}
+
+	// older clients decode the response as Error message.
+	resp = NewRepairEndpointsResponse()
+	resp.AddEndpoint("n1:9000", RepairStatus_EndpointFailed, errors.New("refused"))
+	if data, err = resp.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	protoerr := &Error{}
+	if err := protoerr.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := protoerr.ToError(); err != nil {
+		t.Errorf("expected success, got %v", err)
+	}
+}
+
+func TestErrorContext(t *testing.T) {
+	err := c.WrapError(c.ErrorServerBusy, "bucket", "default")
+	protoerr := NewError(err)
+	// older components match on the error message.
+	if msg := protoerr.GetError(); msg != c.ErrorServerBusy.Error() {
+		t.Fatalf("expected %q, got %q", c.ErrorServerBusy.Error(), msg)
+	}
+	if context := protoerr.GetContext(); context != "bucket=default" {
+		t.Fatalf("expected context, got %q", context)
+	}
+
+	data, e := protoerr.Encode()
+	if e != nil {
+		t.Fatal(e)
+	}
+	protoerr = &Error{}
+	if e := protoerr.Decode(data); e != nil {
+		t.Fatal(e)
+	}
+	if e := protoerr.ToError(); !c.IsError(e, c.ErrorServerBusy) {
+		t.Fatalf("expected %v, got %v", c.ErrorServerBusy, e)
+	} else if e.Error() != err.Error() {
+		t.Fatalf("expected %q, got %q", err.Error(), e.Error())
+	}
+}
+
+func TestTopicRequestVersion(t *testing.T) {
+	req := NewCatchupTopicRequest("catchup", "dataport", nil)
+	data, err := req.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = &CatchupTopicRequest{}
+	if err := req.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if v := req.ToMutationTopicRequest().GetVersion(); v != c.StreamVersion {
+		t.Errorf("expected stream version %v, got %v", c.StreamVersion, v)
+	}
+
+	// request from downstream that predates versioning.
+	mreq := NewMutationTopicRequest("maint", "dataport", nil)
+	mreq.Version = nil
+	if data, err = mreq.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	mreq = &MutationTopicRequest{}
+	if err := mreq.Decode(data); err != nil {
+		t.Fatal(err)
+	} else if v := mreq.GetVersion(); v != 0 {
+		t.Errorf("expected no stream version, got %v", v)
+	}
+}
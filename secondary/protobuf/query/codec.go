@@ -3,6 +3,7 @@
 package protobuf
 
 import "errors"
+import "sync"
 
 import c "github.com/couchbase/indexing/secondary/common"
 import "github.com/couchbaselabs/goprotobuf/proto"
@@ -17,7 +18,58 @@ var ErrorMissingPayload = errors.New("dataport.missingPlayload")
 // `data` can be transported to the other end and decoded back to Payload
 // message.
 func ProtobufEncode(payload interface{}) (data []byte, err error) {
+	pl, err := EncodePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	data, err = proto.Marshal(pl)
+	return
+}
+
+// ProtobufEncodeInBuf is same as ProtobufEncode, but appends the encoded
+// message to `buf`, typically the transport buffer of a connection. Used
+// in the scan path to encode responses without allocating per message.
+func ProtobufEncodeInBuf(payload interface{}, buf []byte) (data []byte, err error) {
+	pl := payloadPool.Get().(*QueryPayload)
+	defer func() {
+		*pl = QueryPayload{Version: pl.Version} // don't retain the message
+		payloadPool.Put(pl)
+	}()
+	if err = wrapPayload(pl, payload); err != nil {
+		return nil, err
+	}
+
+	pbuf := protoBufPool.Get().(*proto.Buffer)
+	defer protoBufPool.Put(pbuf)
+	pbuf.SetBuf(buf)
+	err = pbuf.Marshal(pl)
+	data = pbuf.Bytes()
+	pbuf.SetBuf(nil)
+	return data, err
+}
+
+// pools for ProtobufEncodeInBuf, payloads are wrapped in pooled
+// QueryPayload and marshalled by pooled proto.Buffer.
+var payloadPool = sync.Pool{
+	New: func() interface{} {
+		return &QueryPayload{Version: proto.Uint32(uint32(ProtobufVersion()))}
+	},
+}
+
+var protoBufPool = sync.Pool{
+	New: func() interface{} { return proto.NewBuffer(nil) },
+}
+
+// EncodePayload wraps request or response message into QueryPayload.
+func EncodePayload(payload interface{}) (*QueryPayload, error) {
 	pl := &QueryPayload{Version: proto.Uint32(uint32(ProtobufVersion()))}
+	if err := wrapPayload(pl, payload); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+func wrapPayload(pl *QueryPayload, payload interface{}) error {
 	switch val := payload.(type) {
 	// request
 	case *StatisticsRequest:
@@ -32,9 +84,21 @@ func ProtobufEncode(payload interface{}) (data []byte, err error) {
 	case *ScanAllRequest:
 		pl.ScanAllRequest = val
 
+	case *LookupRequest:
+		pl.LookupRequest = val
+
+	case *ScanCursorRequest:
+		pl.ScanCursorRequest = val
+
 	case *EndStreamRequest:
 		pl.EndStream = val
 
+	case *AuthRequest:
+		pl.AuthRequest = val
+
+	case *GroupAggregateRequest:
+		pl.GroupAggregateRequest = val
+
 	// response
 	case *StatisticsResponse:
 		pl.Statistics = val
@@ -48,11 +112,13 @@ func ProtobufEncode(payload interface{}) (data []byte, err error) {
 	case *StreamEndResponse:
 		pl.StreamEnd = val
 
+	case *AuthResponse:
+		pl.AuthResponse = val
+
 	default:
-		return nil, ErrorMissingPayload
+		return ErrorMissingPayload
 	}
-	data, err = proto.Marshal(pl)
-	return
+	return nil
 }
 
 // ProtobufDecode complements ProtobufEncode() API. `data` returned by encode
@@ -62,6 +128,12 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 	if err = proto.Unmarshal(data, pl); err != nil {
 		return nil, err
 	}
+	return DecodePayload(pl)
+}
+
+// DecodePayload complements EncodePayload() API, unwraps request or response
+// message from QueryPayload.
+func DecodePayload(pl *QueryPayload) (value interface{}, err error) {
 	currVer := ProtobufVersion()
 	if ver := byte(pl.GetVersion()); ver == currVer {
 		// do nothing
@@ -80,8 +152,16 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 		return val, nil
 	} else if val := pl.GetScanAllRequest(); val != nil {
 		return val, nil
+	} else if val := pl.GetLookupRequest(); val != nil {
+		return val, nil
+	} else if val := pl.GetScanCursorRequest(); val != nil {
+		return val, nil
 	} else if val := pl.GetEndStream(); val != nil {
 		return val, nil
+	} else if val := pl.GetAuthRequest(); val != nil {
+		return val, nil
+	} else if val := pl.GetGroupAggregateRequest(); val != nil {
+		return val, nil
 		// response
 	} else if val := pl.GetStatistics(); val != nil {
 		return val, nil
@@ -93,6 +173,8 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 		return val, nil
 	} else if val := pl.GetStreamEnd(); val != nil {
 		return val, nil
+	} else if val := pl.GetAuthResponse(); val != nil {
+		return val, nil
 	}
 	return nil, ErrorMissingPayload
 }
@@ -0,0 +1,140 @@
+//go:build grpc
+// +build grpc
+
+// gRPC bindings for queryport service, hand written since messages are
+// generated by goprotobuf which does not generate gRPC services.
+
+package protobuf
+
+import "context"
+import "fmt"
+
+import "github.com/couchbaselabs/goprotobuf/proto"
+import "google.golang.org/grpc"
+import "google.golang.org/grpc/encoding"
+
+// GrpcCodecName is the content-subtype of queryport messages on gRPC
+// transport, clients shall call with grpc.CallContentSubtype(GrpcCodecName)
+// and servers pick the codec from the content-subtype of the call.
+const GrpcCodecName = "queryport"
+
+func init() {
+	encoding.RegisterCodec(grpcCodec{})
+}
+
+// grpcCodec marshals queryport messages, generated by goprotobuf, for gRPC
+// transport.
+type grpcCodec struct{}
+
+// Marshal implements encoding.Codec{} interface.
+func (grpcCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("grpcCodec: %T is not a protobuf message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+// Unmarshal implements encoding.Codec{} interface.
+func (grpcCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("grpcCodec: %T is not a protobuf message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// Name implements encoding.Codec{} interface.
+func (grpcCodec) Name() string {
+	return GrpcCodecName
+}
+
+// QueryportServer is the server API for Queryport service.
+type QueryportServer interface {
+	Stream(Queryport_StreamServer) error
+}
+
+// Queryport_StreamServer is the server side of a Queryport stream.
+type Queryport_StreamServer interface {
+	Send(*QueryPayload) error
+	Recv() (*QueryPayload, error)
+	grpc.ServerStream
+}
+
+type queryportStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *queryportStreamServer) Send(m *QueryPayload) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *queryportStreamServer) Recv() (*QueryPayload, error) {
+	m := new(QueryPayload)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func queryportStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(QueryportServer).Stream(&queryportStreamServer{stream})
+}
+
+var queryportServiceDesc = grpc.ServiceDesc{
+	ServiceName: "protobuf.Queryport",
+	HandlerType: (*QueryportServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			Handler:       queryportStreamHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+// RegisterQueryportServer registers Queryport service with gRPC server `s`.
+func RegisterQueryportServer(s *grpc.Server, srv QueryportServer) {
+	s.RegisterService(&queryportServiceDesc, srv)
+}
+
+// Queryport_StreamClient is the client side of a Queryport stream.
+type Queryport_StreamClient interface {
+	Send(*QueryPayload) error
+	Recv() (*QueryPayload, error)
+	grpc.ClientStream
+}
+
+type queryportStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *queryportStreamClient) Send(m *QueryPayload) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *queryportStreamClient) Recv() (*QueryPayload, error) {
+	m := new(QueryPayload)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewQueryportStream opens a Queryport stream on gRPC connection `cc`, `cc`
+// shall be dialed with grpc.CallContentSubtype(GrpcCodecName) as default
+// call option, else it shall be passed in `opts`.
+func NewQueryportStream(
+	ctx context.Context, cc *grpc.ClientConn,
+	opts ...grpc.CallOption) (Queryport_StreamClient, error) {
+
+	desc := &queryportServiceDesc.Streams[0]
+	stream, err := grpc.NewClientStream(
+		ctx, desc, cc, "/protobuf.Queryport/Stream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &queryportStreamClient{stream}, nil
+}
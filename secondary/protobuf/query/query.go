@@ -1,9 +1,11 @@
 package protobuf
 
-import "errors"
+import "encoding/binary"
 import "encoding/json"
+import "hash/crc32"
 
 import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbaselabs/goprotobuf/proto"
 
 // GetEntries implements queryport.client.ResponseReader{} method.
 func (r *ResponseStream) GetEntries() ([]c.SecondaryKey, [][]byte, error) {
@@ -26,14 +28,89 @@ func (r *ResponseStream) GetEntries() ([]c.SecondaryKey, [][]byte, error) {
 	return skeys, pkeys, nil
 }
 
+// GetProjectedValues implements queryport.client.ResponseReader{} method.
+func (r *ResponseStream) GetProjectedValues() ([]c.SecondaryKey, error) {
+	entries := r.GetIndexEntries()
+	values := make([]c.SecondaryKey, 0, len(entries))
+	for _, entry := range entries {
+		data := entry.GetProjectedValue()
+		if len(data) > 0 {
+			value := make(c.SecondaryKey, 0)
+			if err := json.Unmarshal(data, &value); err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		} else {
+			values = append(values, nil)
+		}
+	}
+	return values, nil
+}
+
+// GetGroups implements queryport.client.ResponseReader{} method.
+func (r *ResponseStream) GetGroups() ([]c.SecondaryKey, []c.SecondaryKey, error) {
+	rows := r.GetGroupRows()
+	gkeys := make([]c.SecondaryKey, 0, len(rows))
+	aggrs := make([]c.SecondaryKey, 0, len(rows))
+	for _, row := range rows {
+		gkey, aggr := make(c.SecondaryKey, 0), make(c.SecondaryKey, 0)
+		if err := json.Unmarshal(row.GetGroupKey(), &gkey); err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(row.GetAggregates(), &aggr); err != nil {
+			return nil, nil, err
+		}
+		gkeys, aggrs = append(gkeys, gkey), append(aggrs, aggr)
+	}
+	return gkeys, aggrs, nil
+}
+
+// GetSnapshotTs implements queryport.client.ResponseReader{} method.
+func (r *ResponseStream) GetSnapshotTs() *c.TsVbuuid {
+	if tsc := r.GetTimestamp(); tsc != nil {
+		return tsc.ToTsVbuuid()
+	}
+	return nil
+}
+
 // Error implements queryport.client.ResponseReader{} method.
 func (r *ResponseStream) Error() error {
-	if e := r.GetErr(); e != nil {
-		if ee := e.GetError(); ee != "" {
-			return errors.New(ee)
+	return protoError(r.GetErr())
+}
+
+// SetChecksum computes crc32 of index entries in the response.
+func (r *ResponseStream) SetChecksum() {
+	r.Checksum = proto.Uint32(r.entriesChecksum())
+}
+
+// VerifyChecksum of index entries in the response, responses without a
+// checksum are not verified.
+func (r *ResponseStream) VerifyChecksum() bool {
+	if r.Checksum == nil {
+		return true
+	}
+	return r.entriesChecksum() == r.GetChecksum()
+}
+
+// crc32 over length prefixed fields of all index entries and group
+// rows, in order.
+func (r *ResponseStream) entriesChecksum() uint32 {
+	var length [4]byte
+	crc := crc32.NewIEEE()
+	write := func(fields ...[]byte) {
+		for _, field := range fields {
+			binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+			crc.Write(length[:])
+			crc.Write(field)
 		}
 	}
-	return nil
+	for _, entry := range r.GetIndexEntries() {
+		write(entry.GetEntryKey(), entry.GetPrimaryKey(), entry.GetProjectedValue())
+	}
+	for _, row := range r.GetGroupRows() {
+		write(row.GetGroupKey(), row.GetAggregates())
+	}
+	return crc.Sum32()
 }
 
 // GetEntries implements queryport.client.ResponseReader{} method.
@@ -41,14 +118,82 @@ func (r *StreamEndResponse) GetEntries() ([]c.SecondaryKey, [][]byte, error) {
 	return nil, nil, nil
 }
 
+// GetProjectedValues implements queryport.client.ResponseReader{} method.
+func (r *StreamEndResponse) GetProjectedValues() ([]c.SecondaryKey, error) {
+	return nil, nil
+}
+
+// GetGroups implements queryport.client.ResponseReader{} method.
+func (r *StreamEndResponse) GetGroups() ([]c.SecondaryKey, []c.SecondaryKey, error) {
+	return nil, nil, nil
+}
+
+// GetCursor implements queryport.client.ResponseReader{} method.
+func (r *StreamEndResponse) GetCursor() []byte {
+	return nil
+}
+
+// GetSnapshotTs implements queryport.client.ResponseReader{} method.
+func (r *StreamEndResponse) GetSnapshotTs() *c.TsVbuuid {
+	return nil
+}
+
 // Error implements queryport.client.ResponseReader{} method.
 func (r *StreamEndResponse) Error() error {
-	if e := r.GetErr(); e != nil {
-		if ee := e.GetError(); ee != "" {
-			return errors.New(ee)
+	return protoError(r.GetErr())
+}
+
+// NewTsConsistency returns timestamp `ts` of an index snapshot in its
+// compact form, listing only vbuckets that have mutations.
+func NewTsConsistency(ts *c.TsVbuuid) *TsConsistency {
+	vbnos := make([]uint32, 0)
+	seqnos := make([]uint64, 0)
+	vbuuids := make([]uint64, 0)
+	for i, seqno := range ts.Seqnos {
+		if seqno == 0 {
+			continue
 		}
+		vbnos = append(vbnos, uint32(i))
+		seqnos = append(seqnos, seqno)
+		vbuuids = append(vbuuids, ts.Vbuuids[i])
 	}
-	return nil
+	return &TsConsistency{
+		Bucket:      proto.String(ts.Bucket),
+		NumVbuckets: proto.Uint32(uint32(len(ts.Seqnos))),
+		Vbnos:       vbnos,
+		Seqnos:      seqnos,
+		Vbuuids:     vbuuids,
+	}
+}
+
+// ToTsVbuuid expands compact timestamp into a full timestamp of its
+// bucket, usable for at_plus consistency of subsequent scans.
+func (tsc *TsConsistency) ToTsVbuuid() *c.TsVbuuid {
+	ts := c.NewTsVbuuid(tsc.GetBucket(), int(tsc.GetNumVbuckets()))
+	seqnos, vbuuids := tsc.GetSeqnos(), tsc.GetVbuuids()
+	for i, vbno := range tsc.GetVbnos() {
+		if int(vbno) >= len(ts.Seqnos) || i >= len(seqnos) || i >= len(vbuuids) {
+			continue
+		}
+		ts.Seqnos[vbno] = seqnos[i]
+		ts.Vbuuids[vbno] = vbuuids[i]
+	}
+	return ts
+}
+
+// Error returns the error, if any, of a statistics request.
+func (r *StatisticsResponse) Error() error {
+	return protoError(r.GetErr())
+}
+
+// Error returns the error, if any, of a count request.
+func (r *CountResponse) Error() error {
+	return protoError(r.GetErr())
+}
+
+// Error returns the error, if any, of an authentication request.
+func (r *AuthResponse) Error() error {
+	return protoError(r.GetErr())
 }
 
 // Count implements common.IndexStatistics{} method.
@@ -79,7 +224,39 @@ func (s *IndexStatistics) DistinctCount() (int64, error) {
 	return int64(s.GetUniqueKeysCount()), nil
 }
 
-// Bins implements common.IndexStatistics{} method.
+// Bins implements common.IndexStatistics{} method, bins of the key
+// histogram maintained by indexer, nil if it is disabled.
 func (s *IndexStatistics) Bins() ([]c.IndexStatistics, error) {
-	return nil, nil
+	if len(s.GetHistogram()) == 0 {
+		return nil, nil
+	}
+	bins := make([]c.IndexStatistics, 0, len(s.GetHistogram()))
+	for _, bin := range s.GetHistogram() {
+		bins = append(bins, bin)
+	}
+	return bins, nil
+}
+
+// NewError creates a protobuf message `Error` for a failed request, code
+// of typed errors is sent along, and context annotated by
+// common.WrapError is sent separately.
+func NewError(err error) *Error {
+	e := &Error{Error: proto.String(c.ErrorCause(err).Error())}
+	if code := c.ErrorCodeOf(err); code != c.ErrorCodeUnknown {
+		e.Code = proto.Uint32(uint32(code))
+	}
+	if context := c.ErrorContext(err); context != "" {
+		e.Context = proto.String(context)
+	}
+	return e
+}
+
+// protoError converts an error returned by the server, typed errors that
+// the client is expected to check for are restored.
+func protoError(e *Error) error {
+	err := c.DecodeError(c.ErrorCode(e.GetCode()), e.GetError())
+	if context := e.GetContext(); err != nil && context != "" {
+		return c.WrapError(err, context)
+	}
+	return err
 }
@@ -15,13 +15,22 @@ It has these top-level messages:
 	StatisticsResponse
 	ScanRequest
 	ScanAllRequest
+	LookupRequest
+	ScanCursorRequest
+	GroupAggregateRequest
+	Aggregate
 	EndStreamRequest
+	AuthRequest
+	AuthResponse
 	ResponseStream
 	StreamEndResponse
 	CountRequest
 	CountResponse
 	Span
 	Range
+	Filter
+	Predicate
+	GroupRow
 	IndexEntry
 	IndexStatistics
 */
@@ -38,6 +47,10 @@ var _ = math.Inf
 // encapsulated in response packets.
 type Error struct {
 	Error            *string `protobuf:"bytes,1,req,name=error" json:"error,omitempty"`
+	Code             *uint32 `protobuf:"varint,2,opt,name=code" json:"code,omitempty"`
+	// context of the failed operation, error is kept as is so that older
+	// components matching on error messages continue to work.
+	Context          *string `protobuf:"bytes,3,opt,name=context" json:"context,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -52,19 +65,38 @@ func (m *Error) GetError() string {
 	return ""
 }
 
+func (m *Error) GetCode() uint32 {
+	if m != nil && m.Code != nil {
+		return *m.Code
+	}
+	return 0
+}
+
+func (m *Error) GetContext() string {
+	if m != nil && m.Context != nil {
+		return *m.Context
+	}
+	return ""
+}
+
 // Request can be one of the optional field.
 type QueryPayload struct {
-	Version           *uint32             `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
-	StatisticsRequest *StatisticsRequest  `protobuf:"bytes,2,opt,name=statisticsRequest" json:"statisticsRequest,omitempty"`
-	Statistics        *StatisticsResponse `protobuf:"bytes,3,opt,name=statistics" json:"statistics,omitempty"`
-	ScanRequest       *ScanRequest        `protobuf:"bytes,4,opt,name=scanRequest" json:"scanRequest,omitempty"`
-	ScanAllRequest    *ScanAllRequest     `protobuf:"bytes,5,opt,name=scanAllRequest" json:"scanAllRequest,omitempty"`
-	Stream            *ResponseStream     `protobuf:"bytes,6,opt,name=stream" json:"stream,omitempty"`
-	CountRequest      *CountRequest       `protobuf:"bytes,7,opt,name=countRequest" json:"countRequest,omitempty"`
-	CountResponse     *CountResponse      `protobuf:"bytes,8,opt,name=countResponse" json:"countResponse,omitempty"`
-	EndStream         *EndStreamRequest   `protobuf:"bytes,9,opt,name=endStream" json:"endStream,omitempty"`
-	StreamEnd         *StreamEndResponse  `protobuf:"bytes,10,opt,name=streamEnd" json:"streamEnd,omitempty"`
-	XXX_unrecognized  []byte              `json:"-"`
+	Version               *uint32                `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	StatisticsRequest     *StatisticsRequest     `protobuf:"bytes,2,opt,name=statisticsRequest" json:"statisticsRequest,omitempty"`
+	Statistics            *StatisticsResponse    `protobuf:"bytes,3,opt,name=statistics" json:"statistics,omitempty"`
+	ScanRequest           *ScanRequest           `protobuf:"bytes,4,opt,name=scanRequest" json:"scanRequest,omitempty"`
+	ScanAllRequest        *ScanAllRequest        `protobuf:"bytes,5,opt,name=scanAllRequest" json:"scanAllRequest,omitempty"`
+	Stream                *ResponseStream        `protobuf:"bytes,6,opt,name=stream" json:"stream,omitempty"`
+	CountRequest          *CountRequest          `protobuf:"bytes,7,opt,name=countRequest" json:"countRequest,omitempty"`
+	CountResponse         *CountResponse         `protobuf:"bytes,8,opt,name=countResponse" json:"countResponse,omitempty"`
+	EndStream             *EndStreamRequest      `protobuf:"bytes,9,opt,name=endStream" json:"endStream,omitempty"`
+	StreamEnd             *StreamEndResponse     `protobuf:"bytes,10,opt,name=streamEnd" json:"streamEnd,omitempty"`
+	LookupRequest         *LookupRequest         `protobuf:"bytes,11,opt,name=lookupRequest" json:"lookupRequest,omitempty"`
+	ScanCursorRequest     *ScanCursorRequest     `protobuf:"bytes,12,opt,name=scanCursorRequest" json:"scanCursorRequest,omitempty"`
+	AuthRequest           *AuthRequest           `protobuf:"bytes,13,opt,name=authRequest" json:"authRequest,omitempty"`
+	AuthResponse          *AuthResponse          `protobuf:"bytes,14,opt,name=authResponse" json:"authResponse,omitempty"`
+	GroupAggregateRequest *GroupAggregateRequest `protobuf:"bytes,15,opt,name=groupAggregateRequest" json:"groupAggregateRequest,omitempty"`
+	XXX_unrecognized      []byte                 `json:"-"`
 }
 
 func (m *QueryPayload) Reset()         { *m = QueryPayload{} }
@@ -141,6 +173,41 @@ func (m *QueryPayload) GetStreamEnd() *StreamEndResponse {
 	return nil
 }
 
+func (m *QueryPayload) GetLookupRequest() *LookupRequest {
+	if m != nil {
+		return m.LookupRequest
+	}
+	return nil
+}
+
+func (m *QueryPayload) GetScanCursorRequest() *ScanCursorRequest {
+	if m != nil {
+		return m.ScanCursorRequest
+	}
+	return nil
+}
+
+func (m *QueryPayload) GetAuthRequest() *AuthRequest {
+	if m != nil {
+		return m.AuthRequest
+	}
+	return nil
+}
+
+func (m *QueryPayload) GetAuthResponse() *AuthResponse {
+	if m != nil {
+		return m.AuthResponse
+	}
+	return nil
+}
+
+func (m *QueryPayload) GetGroupAggregateRequest() *GroupAggregateRequest {
+	if m != nil {
+		return m.GroupAggregateRequest
+	}
+	return nil
+}
+
 // Get Index statistics. StatisticsResponse is returned back from indexer.
 type StatisticsRequest struct {
 	DefnID           *uint64 `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
@@ -197,6 +264,9 @@ type ScanRequest struct {
 	Distinct         *bool   `protobuf:"varint,3,req,name=distinct" json:"distinct,omitempty"`
 	Limit            *int64  `protobuf:"varint,4,req,name=limit" json:"limit,omitempty"`
 	PageSize         *int64  `protobuf:"varint,5,req,name=pageSize" json:"pageSize,omitempty"`
+	WithCursor       *bool   `protobuf:"varint,6,opt,name=withCursor" json:"withCursor,omitempty"`
+	Filter           *Filter `protobuf:"bytes,7,opt,name=filter" json:"filter,omitempty"`
+	Snapshot         *uint64 `protobuf:"varint,8,opt,name=snapshot" json:"snapshot,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -239,11 +309,34 @@ func (m *ScanRequest) GetPageSize() int64 {
 	return 0
 }
 
+func (m *ScanRequest) GetWithCursor() bool {
+	if m != nil && m.WithCursor != nil {
+		return *m.WithCursor
+	}
+	return false
+}
+
+func (m *ScanRequest) GetFilter() *Filter {
+	if m != nil {
+		return m.Filter
+	}
+	return nil
+}
+
+func (m *ScanRequest) GetSnapshot() uint64 {
+	if m != nil && m.Snapshot != nil {
+		return *m.Snapshot
+	}
+	return 0
+}
+
 // Full table scan request from indexer.
 type ScanAllRequest struct {
 	DefnID           *uint64 `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
 	PageSize         *int64  `protobuf:"varint,2,req,name=pageSize" json:"pageSize,omitempty"`
 	Limit            *int64  `protobuf:"varint,3,req,name=limit" json:"limit,omitempty"`
+	WithCursor       *bool   `protobuf:"varint,4,opt,name=withCursor" json:"withCursor,omitempty"`
+	Ordered          *bool   `protobuf:"varint,5,opt,name=ordered" json:"ordered,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -272,6 +365,182 @@ func (m *ScanAllRequest) GetLimit() int64 {
 	return 0
 }
 
+func (m *ScanAllRequest) GetWithCursor() bool {
+	if m != nil && m.WithCursor != nil {
+		return *m.WithCursor
+	}
+	return false
+}
+
+func (m *ScanAllRequest) GetOrdered() bool {
+	if m != nil && m.Ordered != nil {
+		return *m.Ordered
+	}
+	return false
+}
+
+// Resume a scan from the cursor returned by a previous scan, on the
+// same snapshot. The cursor is valid only until it expires on the
+// indexer.
+type ScanCursorRequest struct {
+	Cursor           []byte `protobuf:"bytes,1,req,name=cursor" json:"cursor,omitempty"`
+	Limit            *int64 `protobuf:"varint,2,req,name=limit" json:"limit,omitempty"`
+	PageSize         *int64 `protobuf:"varint,3,req,name=pageSize" json:"pageSize,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *ScanCursorRequest) Reset()         { *m = ScanCursorRequest{} }
+func (m *ScanCursorRequest) String() string { return proto.CompactTextString(m) }
+func (*ScanCursorRequest) ProtoMessage()    {}
+
+func (m *ScanCursorRequest) GetCursor() []byte {
+	if m != nil {
+		return m.Cursor
+	}
+	return nil
+}
+
+func (m *ScanCursorRequest) GetLimit() int64 {
+	if m != nil && m.Limit != nil {
+		return *m.Limit
+	}
+	return 0
+}
+
+func (m *ScanCursorRequest) GetPageSize() int64 {
+	if m != nil && m.PageSize != nil {
+		return *m.PageSize
+	}
+	return 0
+}
+
+// Lookup request to indexer, fetches index entries whose secondary key
+// equals one of the keys in the batch, keys are JSON encoded arrays.
+type LookupRequest struct {
+	DefnID           *uint64  `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
+	Keys             [][]byte `protobuf:"bytes,2,rep,name=keys" json:"keys,omitempty"`
+	PageSize         *int64   `protobuf:"varint,3,req,name=pageSize" json:"pageSize,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *LookupRequest) Reset()         { *m = LookupRequest{} }
+func (m *LookupRequest) String() string { return proto.CompactTextString(m) }
+func (*LookupRequest) ProtoMessage()    {}
+
+func (m *LookupRequest) GetDefnID() uint64 {
+	if m != nil && m.DefnID != nil {
+		return *m.DefnID
+	}
+	return 0
+}
+
+func (m *LookupRequest) GetKeys() [][]byte {
+	if m != nil {
+		return m.Keys
+	}
+	return nil
+}
+
+func (m *LookupRequest) GetPageSize() int64 {
+	if m != nil && m.PageSize != nil {
+		return *m.PageSize
+	}
+	return 0
+}
+
+// Group-by request to indexer, entries in span are grouped by the
+// leading groupLength components of their secondary key and one row
+// of aggregates is returned for each group, in index order.
+type GroupAggregateRequest struct {
+	DefnID           *uint64      `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
+	Span             *Span        `protobuf:"bytes,2,req,name=span" json:"span,omitempty"`
+	GroupLength      *uint32      `protobuf:"varint,3,req,name=groupLength" json:"groupLength,omitempty"`
+	Aggregates       []*Aggregate `protobuf:"bytes,4,rep,name=aggregates" json:"aggregates,omitempty"`
+	Filter           *Filter      `protobuf:"bytes,5,opt,name=filter" json:"filter,omitempty"`
+	Limit            *int64       `protobuf:"varint,6,req,name=limit" json:"limit,omitempty"`
+	PageSize         *int64       `protobuf:"varint,7,req,name=pageSize" json:"pageSize,omitempty"`
+	XXX_unrecognized []byte       `json:"-"`
+}
+
+func (m *GroupAggregateRequest) Reset()         { *m = GroupAggregateRequest{} }
+func (m *GroupAggregateRequest) String() string { return proto.CompactTextString(m) }
+func (*GroupAggregateRequest) ProtoMessage()    {}
+
+func (m *GroupAggregateRequest) GetDefnID() uint64 {
+	if m != nil && m.DefnID != nil {
+		return *m.DefnID
+	}
+	return 0
+}
+
+func (m *GroupAggregateRequest) GetSpan() *Span {
+	if m != nil {
+		return m.Span
+	}
+	return nil
+}
+
+func (m *GroupAggregateRequest) GetGroupLength() uint32 {
+	if m != nil && m.GroupLength != nil {
+		return *m.GroupLength
+	}
+	return 0
+}
+
+func (m *GroupAggregateRequest) GetAggregates() []*Aggregate {
+	if m != nil {
+		return m.Aggregates
+	}
+	return nil
+}
+
+func (m *GroupAggregateRequest) GetFilter() *Filter {
+	if m != nil {
+		return m.Filter
+	}
+	return nil
+}
+
+func (m *GroupAggregateRequest) GetLimit() int64 {
+	if m != nil && m.Limit != nil {
+		return *m.Limit
+	}
+	return 0
+}
+
+func (m *GroupAggregateRequest) GetPageSize() int64 {
+	if m != nil && m.PageSize != nil {
+		return *m.PageSize
+	}
+	return 0
+}
+
+// Aggregate function computed for each group, over the component at
+// position in secondary key. Position is ignored for count.
+type Aggregate struct {
+	Function         *uint32 `protobuf:"varint,1,req,name=function" json:"function,omitempty"`
+	Position         *uint32 `protobuf:"varint,2,opt,name=position" json:"position,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Aggregate) Reset()         { *m = Aggregate{} }
+func (m *Aggregate) String() string { return proto.CompactTextString(m) }
+func (*Aggregate) ProtoMessage()    {}
+
+func (m *Aggregate) GetFunction() uint32 {
+	if m != nil && m.Function != nil {
+		return *m.Function
+	}
+	return 0
+}
+
+func (m *Aggregate) GetPosition() uint32 {
+	if m != nil && m.Position != nil {
+		return *m.Position
+	}
+	return 0
+}
+
 // Request by client to stop streaming the query results.
 type EndStreamRequest struct {
 	XXX_unrecognized []byte `json:"-"`
@@ -281,10 +550,57 @@ func (m *EndStreamRequest) Reset()         { *m = EndStreamRequest{} }
 func (m *EndStreamRequest) String() string { return proto.CompactTextString(m) }
 func (*EndStreamRequest) ProtoMessage()    {}
 
+// Authenticate connection, sent by client before other requests when
+// indexer authorizes scans. Credentials apply to every request that
+// follows on the connection.
+type AuthRequest struct {
+	User             *string `protobuf:"bytes,1,req,name=user" json:"user,omitempty"`
+	Password         *string `protobuf:"bytes,2,req,name=password" json:"password,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *AuthRequest) Reset()         { *m = AuthRequest{} }
+func (m *AuthRequest) String() string { return proto.CompactTextString(m) }
+func (*AuthRequest) ProtoMessage()    {}
+
+func (m *AuthRequest) GetUser() string {
+	if m != nil && m.User != nil {
+		return *m.User
+	}
+	return ""
+}
+
+func (m *AuthRequest) GetPassword() string {
+	if m != nil && m.Password != nil {
+		return *m.Password
+	}
+	return ""
+}
+
+type AuthResponse struct {
+	Err              *Error `protobuf:"bytes,1,opt,name=err" json:"err,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *AuthResponse) Reset()         { *m = AuthResponse{} }
+func (m *AuthResponse) String() string { return proto.CompactTextString(m) }
+func (*AuthResponse) ProtoMessage()    {}
+
+func (m *AuthResponse) GetErr() *Error {
+	if m != nil {
+		return m.Err
+	}
+	return nil
+}
+
 type ResponseStream struct {
-	IndexEntries     []*IndexEntry `protobuf:"bytes,1,rep,name=indexEntries" json:"indexEntries,omitempty"`
-	Err              *Error        `protobuf:"bytes,2,opt,name=err" json:"err,omitempty"`
-	XXX_unrecognized []byte        `json:"-"`
+	IndexEntries     []*IndexEntry  `protobuf:"bytes,1,rep,name=indexEntries" json:"indexEntries,omitempty"`
+	Err              *Error         `protobuf:"bytes,2,opt,name=err" json:"err,omitempty"`
+	Cursor           []byte         `protobuf:"bytes,3,opt,name=cursor" json:"cursor,omitempty"`
+	Timestamp        *TsConsistency `protobuf:"bytes,4,opt,name=timestamp" json:"timestamp,omitempty"`
+	Checksum         *uint32        `protobuf:"varint,5,opt,name=checksum" json:"checksum,omitempty"`
+	GroupRows        []*GroupRow    `protobuf:"bytes,6,rep,name=groupRows" json:"groupRows,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
 }
 
 func (m *ResponseStream) Reset()         { *m = ResponseStream{} }
@@ -305,6 +621,34 @@ func (m *ResponseStream) GetErr() *Error {
 	return nil
 }
 
+func (m *ResponseStream) GetCursor() []byte {
+	if m != nil {
+		return m.Cursor
+	}
+	return nil
+}
+
+func (m *ResponseStream) GetTimestamp() *TsConsistency {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+func (m *ResponseStream) GetChecksum() uint32 {
+	if m != nil && m.Checksum != nil {
+		return *m.Checksum
+	}
+	return 0
+}
+
+func (m *ResponseStream) GetGroupRows() []*GroupRow {
+	if m != nil {
+		return m.GroupRows
+	}
+	return nil
+}
+
 // Last response packet sent by server to end query results.
 type StreamEndResponse struct {
 	Err              *Error `protobuf:"bytes,1,opt,name=err" json:"err,omitempty"`
@@ -428,9 +772,88 @@ func (m *Range) GetInclusion() uint32 {
 	return 0
 }
 
+// Filter evaluated by indexer on each entry of a scan, an entry is
+// returned only if it satisfies all predicates.
+type Filter struct {
+	Predicates       []*Predicate `protobuf:"bytes,1,rep,name=predicates" json:"predicates,omitempty"`
+	XXX_unrecognized []byte       `json:"-"`
+}
+
+func (m *Filter) Reset()         { *m = Filter{} }
+func (m *Filter) String() string { return proto.CompactTextString(m) }
+func (*Filter) ProtoMessage()    {}
+
+func (m *Filter) GetPredicates() []*Predicate {
+	if m != nil {
+		return m.Predicates
+	}
+	return nil
+}
+
+// Compare a component of the secondary key with a value, components
+// are compared in index collation order.
+type Predicate struct {
+	Position         *uint32 `protobuf:"varint,1,req,name=position" json:"position,omitempty"`
+	Op               *uint32 `protobuf:"varint,2,req,name=op" json:"op,omitempty"`
+	Value            []byte  `protobuf:"bytes,3,req,name=value" json:"value,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Predicate) Reset()         { *m = Predicate{} }
+func (m *Predicate) String() string { return proto.CompactTextString(m) }
+func (*Predicate) ProtoMessage()    {}
+
+func (m *Predicate) GetPosition() uint32 {
+	if m != nil && m.Position != nil {
+		return *m.Position
+	}
+	return 0
+}
+
+func (m *Predicate) GetOp() uint32 {
+	if m != nil && m.Op != nil {
+		return *m.Op
+	}
+	return 0
+}
+
+func (m *Predicate) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+// Result of a group, group key and aggregates are JSON arrays with
+// one element for each group component and aggregate respectively.
+type GroupRow struct {
+	GroupKey         []byte `protobuf:"bytes,1,req,name=groupKey" json:"groupKey,omitempty"`
+	Aggregates       []byte `protobuf:"bytes,2,req,name=aggregates" json:"aggregates,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *GroupRow) Reset()         { *m = GroupRow{} }
+func (m *GroupRow) String() string { return proto.CompactTextString(m) }
+func (*GroupRow) ProtoMessage()    {}
+
+func (m *GroupRow) GetGroupKey() []byte {
+	if m != nil {
+		return m.GroupKey
+	}
+	return nil
+}
+
+func (m *GroupRow) GetAggregates() []byte {
+	if m != nil {
+		return m.Aggregates
+	}
+	return nil
+}
+
 type IndexEntry struct {
 	EntryKey         []byte `protobuf:"bytes,1,req,name=entryKey" json:"entryKey,omitempty"`
 	PrimaryKey       []byte `protobuf:"bytes,2,req,name=primaryKey" json:"primaryKey,omitempty"`
+	ProjectedValue   []byte `protobuf:"bytes,3,opt,name=projectedValue" json:"projectedValue,omitempty"`
 	XXX_unrecognized []byte `json:"-"`
 }
 
@@ -452,13 +875,73 @@ func (m *IndexEntry) GetPrimaryKey() []byte {
 	return nil
 }
 
+func (m *IndexEntry) GetProjectedValue() []byte {
+	if m != nil {
+		return m.ProjectedValue
+	}
+	return nil
+}
+
+// Timestamp of an index snapshot, as seqno vector of its bucket, only
+// vbuckets having mutations are listed.
+type TsConsistency struct {
+	Bucket           *string  `protobuf:"bytes,1,req,name=bucket" json:"bucket,omitempty"`
+	NumVbuckets      *uint32  `protobuf:"varint,2,req,name=numVbuckets" json:"numVbuckets,omitempty"`
+	Vbnos            []uint32 `protobuf:"varint,3,rep,name=vbnos" json:"vbnos,omitempty"`
+	Seqnos           []uint64 `protobuf:"varint,4,rep,name=seqnos" json:"seqnos,omitempty"`
+	Vbuuids          []uint64 `protobuf:"varint,5,rep,name=vbuuids" json:"vbuuids,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *TsConsistency) Reset()         { *m = TsConsistency{} }
+func (m *TsConsistency) String() string { return proto.CompactTextString(m) }
+func (*TsConsistency) ProtoMessage()    {}
+
+func (m *TsConsistency) GetBucket() string {
+	if m != nil && m.Bucket != nil {
+		return *m.Bucket
+	}
+	return ""
+}
+
+func (m *TsConsistency) GetNumVbuckets() uint32 {
+	if m != nil && m.NumVbuckets != nil {
+		return *m.NumVbuckets
+	}
+	return 0
+}
+
+func (m *TsConsistency) GetVbnos() []uint32 {
+	if m != nil {
+		return m.Vbnos
+	}
+	return nil
+}
+
+func (m *TsConsistency) GetSeqnos() []uint64 {
+	if m != nil {
+		return m.Seqnos
+	}
+	return nil
+}
+
+func (m *TsConsistency) GetVbuuids() []uint64 {
+	if m != nil {
+		return m.Vbuuids
+	}
+	return nil
+}
+
 // Statistics of a given index.
 type IndexStatistics struct {
-	KeysCount        *uint64 `protobuf:"varint,1,req,name=keysCount" json:"keysCount,omitempty"`
-	UniqueKeysCount  *uint64 `protobuf:"varint,2,req,name=uniqueKeysCount" json:"uniqueKeysCount,omitempty"`
-	KeyMin           []byte  `protobuf:"bytes,3,req,name=keyMin" json:"keyMin,omitempty"`
-	KeyMax           []byte  `protobuf:"bytes,4,req,name=keyMax" json:"keyMax,omitempty"`
-	XXX_unrecognized []byte  `json:"-"`
+	KeysCount       *uint64 `protobuf:"varint,1,req,name=keysCount" json:"keysCount,omitempty"`
+	UniqueKeysCount *uint64 `protobuf:"varint,2,req,name=uniqueKeysCount" json:"uniqueKeysCount,omitempty"`
+	KeyMin          []byte  `protobuf:"bytes,3,req,name=keyMin" json:"keyMin,omitempty"`
+	KeyMax          []byte  `protobuf:"bytes,4,req,name=keyMax" json:"keyMax,omitempty"`
+	// approximate equi-depth histogram of keys in the requested range,
+	// each bin with its count, distinct count and bounds.
+	Histogram        []*IndexStatistics `protobuf:"bytes,5,rep,name=histogram" json:"histogram,omitempty"`
+	XXX_unrecognized []byte             `json:"-"`
 }
 
 func (m *IndexStatistics) Reset()         { *m = IndexStatistics{} }
@@ -493,5 +976,12 @@ func (m *IndexStatistics) GetKeyMax() []byte {
 	return nil
 }
 
+func (m *IndexStatistics) GetHistogram() []*IndexStatistics {
+	if m != nil {
+		return m.Histogram
+	}
+	return nil
+}
+
 func init() {
 }
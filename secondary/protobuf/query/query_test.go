@@ -0,0 +1,63 @@
+package protobuf
+
+import (
+	"reflect"
+	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+func TestTsConsistency(t *testing.T) {
+	ts := c.NewTsVbuuid("default", 8)
+	ts.Seqnos[1], ts.Vbuuids[1] = 10, 1234
+	ts.Seqnos[6], ts.Vbuuids[6] = 20, 5678
+	ts.Vbuuids[3] = 99 // vbucket without mutations.
+
+	tsc := NewTsConsistency(ts)
+	if tsc.GetBucket() != "default" || tsc.GetNumVbuckets() != 8 {
+		t.Fatalf("unexpected timestamp %v", tsc)
+	}
+	if !reflect.DeepEqual(tsc.GetVbnos(), []uint32{1, 6}) {
+		t.Fatalf("expected vbuckets [1 6], got %v", tsc.GetVbnos())
+	}
+
+	ref := c.NewTsVbuuid("default", 8)
+	ref.Seqnos[1], ref.Vbuuids[1] = 10, 1234
+	ref.Seqnos[6], ref.Vbuuids[6] = 20, 5678
+	if out := tsc.ToTsVbuuid(); !reflect.DeepEqual(out, ref) {
+		t.Fatalf("expected %v, got %v", ref, out)
+	}
+
+	// malformed vbuckets are skipped.
+	tsc.Vbnos = append(tsc.Vbnos, 8)
+	if out := tsc.ToTsVbuuid(); !reflect.DeepEqual(out, ref) {
+		t.Fatalf("expected %v, got %v", ref, out)
+	}
+}
+
+func TestResponseSnapshotTs(t *testing.T) {
+	ts := c.NewTsVbuuid("default", 4)
+	ts.Seqnos[2], ts.Vbuuids[2] = 100, 4321
+
+	// only the response carrying the timestamp returns it.
+	resp := &ResponseStream{Timestamp: NewTsConsistency(ts)}
+	if out := resp.GetSnapshotTs(); out == nil || !reflect.DeepEqual(out.Seqnos, ts.Seqnos) {
+		t.Fatalf("expected %v, got %v", ts, out)
+	}
+	if skeys, pkeys, err := resp.GetEntries(); err != nil || len(skeys) != 0 || len(pkeys) != 0 {
+		t.Fatalf("unexpected entries %v %v %v", skeys, pkeys, err)
+	}
+	if !resp.VerifyChecksum() {
+		t.Fatalf("expected response without entries to verify")
+	}
+
+	resp = &ResponseStream{
+		IndexEntries: []*IndexEntry{{EntryKey: []byte(`["a"]`), PrimaryKey: []byte("pk")}},
+	}
+	if out := resp.GetSnapshotTs(); out != nil {
+		t.Fatalf("expected no timestamp, got %v", out)
+	}
+	if out := (&StreamEndResponse{}).GetSnapshotTs(); out != nil {
+		t.Fatalf("expected no timestamp, got %v", out)
+	}
+}
@@ -8,14 +8,27 @@ import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 // Application is example application logic that uses query-port server
 func Application(config c.Config) {
 	killch := make(chan bool)
-	s, err := NewServer(
-		"localhost:9990",
+	handlers := NewHandlers().Use(LogRequests("[Application]"))
+	handlers.Register(&protobuf.StatisticsRequest{},
 		func(req interface{},
 			respch chan<- interface{}, quitch <-chan interface{}) {
-			requestHandler(req, respch, quitch, killch)
-		},
-		config)
+			// responses = getStatistics()
+			sendResponses(nil, respch, quitch, killch)
+		})
+	handlers.Register(&protobuf.ScanRequest{},
+		func(req interface{},
+			respch chan<- interface{}, quitch <-chan interface{}) {
+			// responses = scanIndex()
+			sendResponses(nil, respch, quitch, killch)
+		})
+	handlers.Register(&protobuf.ScanAllRequest{},
+		func(req interface{},
+			respch chan<- interface{}, quitch <-chan interface{}) {
+			// responses = fullTableScan()
+			sendResponses(nil, respch, quitch, killch)
+		})
 
+	s, err := NewServer("localhost:9990", handlers, config)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -23,25 +36,15 @@ func Application(config c.Config) {
 	s.Close()
 }
 
-// will be spawned as a go-routine by server's connection handler.
-func requestHandler(
-	req interface{},
+// will be called by handlers spawned as a go-routine by server's
+// connection handler.
+func sendResponses(
+	responses []*protobuf.ResponseStream,
 	respch chan<- interface{}, // send reponse message back to client
 	quitch <-chan interface{}, // client / connection might have quit (done)
 	killch chan bool, // application is shutting down the server.
 ) {
 
-	var responses []*protobuf.ResponseStream
-
-	switch req.(type) {
-	case *protobuf.StatisticsRequest:
-		// responses = getStatistics()
-	case *protobuf.ScanRequest:
-		// responses = scanIndex()
-	case *protobuf.ScanAllRequest:
-		// responses = fullTableScan()
-	}
-
 loop:
 	for _, resp := range responses {
 		// query storage backend for request
@@ -0,0 +1,109 @@
+package queryport
+
+import "sync"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/couchbaselabs/goprotobuf/proto"
+
+// Credentials of a connection, established by protobuf.AuthRequest when
+// the connection is opened. cbauth.Creds satisfies this interface.
+type Credentials interface {
+	Name() string
+	CanReadBucket(bucket string) (bool, error)
+}
+
+// Authenticator verifies user and password supplied by a client.
+type Authenticator func(user, password string) (Credentials, error)
+
+// BucketResolver returns the bucket read by request `req`. Requests whose
+// bucket cannot be resolved, like those on an unknown index, are denied.
+type BucketResolver func(req interface{}) (bucket string, err error)
+
+// AuthStats counts requests denied by authorization, for audit.
+type AuthStats struct {
+	AuthFailures    int64            // authentications rejected
+	Unauthenticated int64            // requests on connections not authenticated
+	Unresolved      int64            // requests denied, bucket not resolved
+	Denials         map[string]int64 // requests denied, by bucket
+}
+
+// authorizer of requests, refer Handlers.Authorize().
+type authorizer struct {
+	authenticate Authenticator
+	bucketOf     BucketResolver
+
+	mu    sync.Mutex
+	stats AuthStats
+}
+
+// authorize request `req` on a connection authenticated with `creds`,
+// returns the error to respond with when the request is denied.
+func (a *authorizer) authorize(creds Credentials, req interface{}) error {
+	bucket, err := a.bucketOf(req)
+	if err != nil {
+		a.mu.Lock()
+		a.stats.Unresolved++
+		a.mu.Unlock()
+		c.Errorf("[Queryport] %v denied, bucket not resolved (%v)\n",
+			RequestName(req), err)
+		return c.CountError(c.WrapError(c.ErrorUnauthorized, err))
+	}
+
+	if creds == nil {
+		a.mu.Lock()
+		a.stats.Unauthenticated++
+		a.mu.Unlock()
+		c.Errorf("[Queryport] %v on bucket %q denied, connection not authenticated\n",
+			RequestName(req), bucket)
+		return c.CountError(c.ErrorAuthFailed)
+	}
+
+	ok, err := creds.CanReadBucket(bucket)
+	if err == nil && ok {
+		return nil
+	}
+	a.mu.Lock()
+	a.stats.Denials[bucket]++
+	a.mu.Unlock()
+	c.Errorf("[Queryport] %v on bucket %q denied for %q (%v)\n",
+		RequestName(req), bucket, creds.Name(), err)
+	return c.CountError(c.WrapError(c.ErrorUnauthorized, "bucket", bucket))
+}
+
+func (a *authorizer) authFailed() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stats.AuthFailures++
+}
+
+func (a *authorizer) statistics() AuthStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := a.stats
+	stats.Denials = make(map[string]int64, len(a.stats.Denials))
+	for bucket, n := range a.stats.Denials {
+		stats.Denials[bucket] = n
+	}
+	return stats
+}
+
+// errorResponse for request `req` that failed with `err`.
+func errorResponse(req interface{}, err error) interface{} {
+	protoErr := protobuf.NewError(err)
+	switch req.(type) {
+	case *protobuf.StatisticsRequest:
+		return &protobuf.StatisticsResponse{
+			Stats: &protobuf.IndexStatistics{
+				KeysCount:       proto.Uint64(0),
+				UniqueKeysCount: proto.Uint64(0),
+				KeyMin:          []byte{},
+				KeyMax:          []byte{},
+			},
+			Err: protoErr,
+		}
+	case *protobuf.CountRequest:
+		return &protobuf.CountResponse{Count: proto.Int64(0), Err: protoErr}
+	}
+	return &protobuf.ResponseStream{Err: protoErr}
+}
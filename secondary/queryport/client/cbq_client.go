@@ -11,6 +11,7 @@ import "io/ioutil"
 import "errors"
 import "strings"
 import "sync"
+import "time"
 
 import "github.com/couchbase/indexing/secondary/common"
 import mclient "github.com/couchbase/indexing/secondary/manager/client"
@@ -190,6 +191,11 @@ func (b *cbqClient) DropIndex(defnID common.IndexDefnId) error {
 	return err
 }
 
+// DropIndexesByBucket implement BridgeAccessor{} interface.
+func (b *cbqClient) DropIndexesByBucket(bucket string) error {
+	return ErrorNotImplemented
+}
+
 // GetScanports implement BridgeAccessor{} interface.
 func (b *cbqClient) GetScanports() (queryports []string) {
 	return []string{b.queryport}
@@ -211,6 +217,20 @@ func (b *cbqClient) IndexState(defnID uint64) (common.IndexState, error) {
 	return common.INDEX_STATE_ACTIVE, nil
 }
 
+// WaitForIndexOnline implement BridgeAccessor{} interface, indexes are
+// online once created.
+func (b *cbqClient) WaitForIndexOnline(
+	defnIDs []common.IndexDefnId, timeout time.Duration,
+	callback mclient.IndexProgressCallback) error {
+
+	return nil
+}
+
+// IndexCollation implement BridgeAccessor{} interface.
+func (b *cbqClient) IndexCollation(defnID uint64) string {
+	return common.CollationBinary
+}
+
 // Close implement BridgeAccessor
 func (b *cbqClient) Close() {
 	// TODO: do nothing ?
@@ -1,36 +1,64 @@
 package client
 
-import "errors"
+import "sync"
+import "sync/atomic"
 import "time"
 
 import "github.com/couchbase/indexing/secondary/common"
-import "github.com/couchbaselabs/goprotobuf/proto"
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 import mclient "github.com/couchbase/indexing/secondary/manager/client"
 
 // ErrorProtocol
-var ErrorProtocol = errors.New("queryport.client.protocol")
+var ErrorProtocol = common.NewError(200, "queryport.client.protocol", false)
+
+// ErrorChecksumMismatch
+var ErrorChecksumMismatch = common.NewError(201, "queryport.client.checksumMismatch", false)
 
 // ErrorNoHost
-var ErrorNoHost = errors.New("queryport.client.noHost")
+var ErrorNoHost = common.NewError(202, "queryport.client.noHost", false)
 
 // ErrorEmptyDeployment
-var ErrorEmptyDeployment = errors.New("queryport.client.emptyDeployment")
+var ErrorEmptyDeployment = common.NewError(203, "queryport.client.emptyDeployment", false)
 
 // ErrorManyDeployment
-var ErrorManyDeployment = errors.New("queryport.client.manyDeployment")
+var ErrorManyDeployment = common.NewError(204, "queryport.client.manyDeployment", false)
 
 // ErrorInvalidDeploymentNode
-var ErrorInvalidDeploymentNode = errors.New("queryport.client.invalidDeploymentPlan")
+var ErrorInvalidDeploymentNode = common.NewError(205, "queryport.client.invalidDeploymentPlan", false)
 
 // ErrorIndexNotFound
-var ErrorIndexNotFound = errors.New("queryport.indexNotFound")
+var ErrorIndexNotFound = common.NewError(206, "queryport.indexNotFound", false)
 
 // ErrorInstanceNotFound
-var ErrorInstanceNotFound = errors.New("queryport.instanceNotFound")
+var ErrorInstanceNotFound = common.NewError(207, "queryport.instanceNotFound", false)
 
 // ErrorIndexNotReady
-var ErrorIndexNotReady = errors.New("queryport.indexNotReady")
+var ErrorIndexNotReady = common.NewError(208, "queryport.indexNotReady", false)
+
+// ErrorEquivalentIndex
+var ErrorEquivalentIndex = common.NewError(209, "queryport.equivalentIndex", false)
+
+// ErrorNoReplicaNode
+var ErrorNoReplicaNode = common.NewError(210, "queryport.client.noReplicaNode", false)
+
+// ErrorInvalidInclude
+var ErrorInvalidInclude = common.NewError(217, "queryport.client.invalidInclude", false)
+
+// ErrorCollatedScan is returned by statistics and group-by scans of an
+// index with collated string keys, if the requested range can only be
+// selected by filtering entries on the client.
+var ErrorCollatedScan = common.NewError(218, "queryport.client.collatedScan", false)
+
+// ErrorGrpcUnavailable is returned when dialing queryport on gRPC
+// transport from a client built without the `grpc` build tag.
+var ErrorGrpcUnavailable = common.NewError(219, "queryport.client.grpcUnavailable", false)
+
+// ErrorNotImplemented is returned for operations not supported by the
+// bridge accessor in use.
+var ErrorNotImplemented = common.NewError(221, "queryport.client.notImplemented", false)
+
+// ErrorScanKilled is returned by a scan that was killed on the indexer.
+var ErrorScanKilled = common.ErrorScanKilled
 
 // ResponseHandler shall interpret response packets from server
 // and handle them. If handler is not interested in receiving any
@@ -46,6 +74,27 @@ type ResponseReader interface {
 	// entries for this query.
 	GetEntries() ([]common.SecondaryKey, [][]byte, error)
 
+	// GetProjectedValues returns, for a covering index, the list of
+	// fields stored along with each entry returned by GetEntries().
+	// Entries of index without included fields are nil.
+	GetProjectedValues() ([]common.SecondaryKey, error)
+
+	// GetGroups returns, for a group-by scan, the list of group keys
+	// and the corresponding aggregates computed for each group.
+	GetGroups() ([]common.SecondaryKey, []common.SecondaryKey, error)
+
+	// GetCursor returns the continuation token of a paginated scan,
+	// it is set only on the last response of a scan that stopped at
+	// limit with more entries left to scan.
+	GetCursor() []byte
+
+	// GetSnapshotTs returns the timestamp of the index snapshot that
+	// served the scan. It is set only on a response without entries,
+	// sent after all entries of a scan that did not fail and before
+	// the cursor of a paginated scan, every other response returns
+	// nil. Can be used for at_plus consistency of subsequent scans.
+	GetSnapshotTs() *common.TsVbuuid
+
 	// Error returns the error value, if nil there is no error.
 	Error() error
 }
@@ -67,6 +116,53 @@ const (
 	Both
 )
 
+// Comparison operator for filter predicates.
+type Comparison uint32
+
+const (
+	// Eq selects entries whose component is equal to value
+	Eq Comparison = iota
+	// Ne selects entries whose component is not equal to value
+	Ne
+	// Lt selects entries whose component is less than value
+	Lt
+	// Le selects entries whose component is less than or equal to value
+	Le
+	// Gt selects entries whose component is greater than value
+	Gt
+	// Ge selects entries whose component is greater than or equal to value
+	Ge
+)
+
+// Predicate compares component at `Position` of secondary key with
+// `Value`, in index collation order.
+type Predicate struct {
+	Position int
+	Op       Comparison
+	Value    interface{}
+}
+
+// AggregateFunc computed by indexer for each group of a group-by scan.
+type AggregateFunc uint32
+
+const (
+	// AggrCount counts entries in the group
+	AggrCount AggregateFunc = iota
+	// AggrSum adds numeric values of component in the group
+	AggrSum
+	// AggrMin selects the smallest value of component in the group
+	AggrMin
+	// AggrMax selects the largest value of component in the group
+	AggrMax
+)
+
+// Aggregate computes `Function` over the component at `Position` of
+// secondary key, for every group. Position is ignored for AggrCount.
+type Aggregate struct {
+	Function AggregateFunc
+	Position int
+}
+
 // BridgeAccessor for Create,Drop,List,Refresh operations.
 type BridgeAccessor interface {
 	// Refresh shall refresh to latest set of index managed by GSI
@@ -99,6 +195,15 @@ type BridgeAccessor interface {
 	//      specify whether the index is created on docid.
 	// with
 	//      JSON marshalled description about index deployment (and more...).
+	//      {"include": [expr, ...]} lists document fields to be stored
+	//      along with each entry, returned by ResponseReader for scans.
+	//      {"collation": "caseinsensitive"} orders string keys by their
+	//      lower case form instead of their bytes, "binary" by default.
+	//      If an equivalent index, same bucket, keys, where clause,
+	//      included fields and collation, already exists CreateIndex fails with ErrorEquivalentIndex,
+	//      unless `with` carries {"replica": true} in which case the
+	//      index is created as a replica on a node that does not host
+	//      an equivalent.
 	CreateIndex(
 		name, bucket, using, exprType, partnExpr, whereExpr string,
 		secExprs []string, isPrimary bool,
@@ -113,6 +218,10 @@ type BridgeAccessor interface {
 	//   from deferred list.
 	DropIndex(defnID common.IndexDefnId) error
 
+	// DropIndexesByBucket to drop all indexes defined on `bucket`, used
+	// when the bucket is flushed or recreated.
+	DropIndexesByBucket(bucket string) error
+
 	// GetScanports shall return list of queryports for all indexer in
 	// the cluster.
 	GetScanports() (queryports []string)
@@ -124,6 +233,17 @@ type BridgeAccessor interface {
 	// IndexState returns the current state of index `defnID` and error.
 	IndexState(defnID uint64) (common.IndexState, error)
 
+	// IndexCollation returns the collation of string keys of index
+	// `defnID`, common.CollationBinary if index is not known.
+	IndexCollation(defnID uint64) string
+
+	// WaitForIndexOnline blocks until indexes `defnIDs` are ACTIVE or
+	// `timeout` expires, zero waits forever. If `callback` is not nil
+	// it is periodically called with the build progress of indexes.
+	WaitForIndexOnline(
+		defnIDs []common.IndexDefnId, timeout time.Duration,
+		callback mclient.IndexProgressCallback) error
+
 	// Timeit will add `value` to incrementalAvg for index-load.
 	Timeit(defnID uint64, value float64)
 
@@ -171,8 +291,14 @@ var useMetadataProvider = true
 // use `adminport` for meta-data operation and `queryport`
 // for index-scan related operations.
 type GsiClient struct {
+	refreshes uint64 // 64-bit aligned for atomic access
+
 	bridge       BridgeAccessor // manages adminport
+	rw           sync.RWMutex   // guards queryClients
 	queryClients map[string]*gsiScanClient
+	hooks        Hooks // guarded by rw, refer SetHooks
+	config       common.Config
+	retry        *retryPolicy
 }
 
 // NewGsiClient returns client to access GSI cluster.
@@ -197,6 +323,24 @@ func (c *GsiClient) IndexState(defnID uint64) (common.IndexState, error) {
 	return c.bridge.IndexState(defnID)
 }
 
+// IndexCollation implements BridgeAccessor{} interface.
+func (c *GsiClient) IndexCollation(defnID uint64) string {
+	return c.bridge.IndexCollation(defnID)
+}
+
+// WaitForIndexOnline implements BridgeAccessor{} interface, so that
+// callers need not poll IndexState() till indexes are built.
+func (c *GsiClient) WaitForIndexOnline(
+	defnIDs []uint64, timeout time.Duration,
+	callback mclient.IndexProgressCallback) error {
+
+	ids := make([]common.IndexDefnId, len(defnIDs))
+	for i, id := range defnIDs {
+		ids[i] = common.IndexDefnId(id)
+	}
+	return c.bridge.WaitForIndexOnline(ids, timeout, callback)
+}
+
 // Refresh implements BridgeAccessor{} interface.
 func (c *GsiClient) Refresh() ([]*mclient.IndexMetadata, error) {
 	return c.bridge.Refresh()
@@ -219,6 +363,23 @@ func (c *GsiClient) CreateIndex(
 	return uint64(defnID), err
 }
 
+// CreateIndexWithPlan is same as CreateIndex, with deployment options
+// passed as `plan` instead of marshalled `with` argument. A nil `plan`
+// creates and builds the index as per placement policy.
+func (c *GsiClient) CreateIndexWithPlan(
+	name, bucket, using, exprType, partnExpr, whereExpr string,
+	secExprs []string, isPrimary bool,
+	plan *IndexPlan) (uint64, error) {
+
+	with, err := plan.with()
+	if err != nil {
+		return 0, err
+	}
+	return c.CreateIndex(
+		name, bucket, using, exprType, partnExpr, whereExpr,
+		secExprs, isPrimary, with)
+}
+
 // BuildIndexes implements BridgeAccessor{} interface.
 func (c *GsiClient) BuildIndexes(defnIDs []uint64) error {
 	ids := make([]common.IndexDefnId, len(defnIDs))
@@ -233,6 +394,11 @@ func (c *GsiClient) DropIndex(defnID uint64) error {
 	return c.bridge.DropIndex(common.IndexDefnId(defnID))
 }
 
+// DropIndexesByBucket implements BridgeAccessor{} interface.
+func (c *GsiClient) DropIndexesByBucket(bucket string) error {
+	return c.bridge.DropIndexesByBucket(bucket)
+}
+
 // LookupStatistics for a single secondary-key.
 func (c *GsiClient) LookupStatistics(
 	defnID uint64, value common.SecondaryKey) (common.IndexStatistics, error) {
@@ -241,14 +407,24 @@ func (c *GsiClient) LookupStatistics(
 	if _, err := c.bridge.IndexState(defnID); err != nil {
 		return nil, err
 	}
-	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
-	if !ok {
-		return nil, ErrorNoHost
-	}
-	qc := c.queryClients[queryport]
 	// time LookupStatistics()
 	begin := time.Now().UnixNano()
-	stats, err := qc.LookupStatistics(defnID, value)
+	collation := c.bridge.IndexCollation(defnID)
+	span := collateSpan(collation, value, value, Both)
+	if !span.exact {
+		return nil, ErrorCollatedScan
+	}
+	var stats common.IndexStatistics
+	err := c.doRequest(defnID, func(qc *gsiScanClient) (err error) {
+		if common.IsCollated(collation) {
+			// lookup is a range over strings sharing the collation key.
+			stats, err = qc.RangeStatistics(
+				defnID, span.low, span.high, span.inclusion)
+			return err
+		}
+		stats, err = qc.LookupStatistics(defnID, value)
+		return err
+	})
 	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
 	return stats, err
 }
@@ -262,14 +438,18 @@ func (c *GsiClient) RangeStatistics(
 	if _, err := c.bridge.IndexState(defnID); err != nil {
 		return nil, err
 	}
-	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
-	if !ok {
-		return nil, ErrorNoHost
-	}
-	qc := c.queryClients[queryport]
 	// time RangeStatistics()
 	begin := time.Now().UnixNano()
-	stats, err := qc.RangeStatistics(defnID, low, high, inclusion)
+	span := collateSpan(c.bridge.IndexCollation(defnID), low, high, inclusion)
+	if !span.exact {
+		return nil, ErrorCollatedScan
+	}
+	var stats common.IndexStatistics
+	err := c.doRequest(defnID, func(qc *gsiScanClient) (err error) {
+		stats, err = qc.RangeStatistics(
+			defnID, span.low, span.high, span.inclusion)
+		return err
+	})
 	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
 	return stats, err
 }
@@ -282,19 +462,48 @@ func (c *GsiClient) Lookup(
 	// check whether the index is present and available.
 	if _, err := c.bridge.IndexState(defnID); err != nil {
 		protoResp := &protobuf.ResponseStream{
-			Err: &protobuf.Error{Error: proto.String(err.Error())},
+			Err: protobuf.NewError(err),
 		}
 		callb(protoResp)
 		return nil
 	}
-	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
-	if !ok {
-		return ErrorNoHost
-	}
-	qc := c.queryClients[queryport]
 	// time Lookup()
 	begin := time.Now().UnixNano()
-	err := qc.Lookup(defnID, values, distinct, limit, callb)
+	var err error
+	if collation := c.bridge.IndexCollation(defnID); common.IsCollated(collation) {
+		err = c.collatedLookup(defnID, collation, values, distinct, limit, callb)
+	} else {
+		err = c.doScan(defnID, callb, func(qc *gsiScanClient, callb ResponseHandler) error {
+			return qc.Lookup(defnID, values, distinct, limit, callb)
+		})
+	}
+	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+	return err
+}
+
+// LookupKeys fetch index entries whose secondary key equals one of the
+// keys in the batch, each key is read with a point lookup.
+func (c *GsiClient) LookupKeys(
+	defnID uint64, keys []common.SecondaryKey, callb ResponseHandler) error {
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		protoResp := &protobuf.ResponseStream{
+			Err: protobuf.NewError(err),
+		}
+		callb(protoResp)
+		return nil
+	}
+	// time LookupKeys()
+	begin := time.Now().UnixNano()
+	var err error
+	if collation := c.bridge.IndexCollation(defnID); common.IsCollated(collation) {
+		err = c.collatedLookup(defnID, collation, keys, false, 0, callb)
+	} else {
+		err = c.doScan(defnID, callb, func(qc *gsiScanClient, callb ResponseHandler) error {
+			return qc.LookupKeys(defnID, keys, callb)
+		})
+	}
 	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
 	return err
 }
@@ -308,19 +517,127 @@ func (c *GsiClient) Range(
 	// check whether the index is present and available.
 	if _, err := c.bridge.IndexState(defnID); err != nil {
 		protoResp := &protobuf.ResponseStream{
-			Err: &protobuf.Error{Error: proto.String(err.Error())},
+			Err: protobuf.NewError(err),
 		}
 		callb(protoResp)
 		return nil
 	}
-	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
-	if !ok {
+	// time Range()
+	begin := time.Now().UnixNano()
+	collation := c.bridge.IndexCollation(defnID)
+	span := collateSpan(collation, low, high, inclusion)
+	callb, limit = c.collatedHandler(
+		collation, span, low, high, inclusion, nil, limit, callb)
+	err := c.doScan(defnID, callb, func(qc *gsiScanClient, callb ResponseHandler) error {
+		return qc.Range(
+			defnID, span.low, span.high, span.inclusion, distinct, limit, callb)
+	})
+	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+	return err
+}
+
+// RangeWithFilter scan index between low and high, returning only
+// entries that satisfy all predicates in filter. Filter is evaluated by
+// indexer, and entries filtered out do not count against limit.
+func (c *GsiClient) RangeWithFilter(
+	defnID uint64, low, high common.SecondaryKey,
+	inclusion Inclusion, distinct bool, limit int64, filter []Predicate,
+	callb ResponseHandler) error {
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		protoResp := &protobuf.ResponseStream{
+			Err: protobuf.NewError(err),
+		}
+		callb(protoResp)
+		return nil
+	}
+	// time RangeWithFilter()
+	begin := time.Now().UnixNano()
+	collation := c.bridge.IndexCollation(defnID)
+	span := collateSpan(collation, low, high, inclusion)
+	filter, residual := collatePredicates(collation, filter)
+	callb, limit = c.collatedHandler(
+		collation, span, low, high, inclusion, residual, limit, callb)
+	err := c.doScan(defnID, callb, func(qc *gsiScanClient, callb ResponseHandler) error {
+		return qc.RangeWithFilter(
+			defnID, span.low, span.high, span.inclusion, distinct, limit,
+			filter, callb)
+	})
+	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+	return err
+}
+
+// GroupAggregate scan index between low and high, grouping entries by
+// the leading `groupLength` components of their secondary key and
+// computing `aggregates` for each group. Since entries are grouped in
+// index order, indexer returns one row per group, in that order, and
+// limit applies to the number of groups.
+func (c *GsiClient) GroupAggregate(
+	defnID uint64, low, high common.SecondaryKey,
+	inclusion Inclusion, groupLength int, aggregates []Aggregate,
+	filter []Predicate, limit int64, callb ResponseHandler) error {
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		protoResp := &protobuf.ResponseStream{
+			Err: protobuf.NewError(err),
+		}
+		callb(protoResp)
+		return nil
+	}
+	// time GroupAggregate()
+	begin := time.Now().UnixNano()
+	collation := c.bridge.IndexCollation(defnID)
+	span := collateSpan(collation, low, high, inclusion)
+	filter, residual := collatePredicates(collation, filter)
+	if !span.exact || len(residual) > 0 {
+		// groups can't be filtered on the client.
+		return ErrorCollatedScan
+	}
+	callb = collateHandler(collation, callb)
+	err := c.doScan(defnID, callb, func(qc *gsiScanClient, callb ResponseHandler) error {
+		return qc.GroupAggregate(
+			defnID, span.low, span.high, span.inclusion, groupLength,
+			aggregates, filter, limit, callb)
+	})
+	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+	return err
+}
+
+// RangeAtSnapshot scan index between low and high, on an older persisted
+// snapshot retained by the indexer hosting the index. Retained snapshots,
+// and their handles, are listed by indexer's /snapshots admin API, handles
+// are local to an indexer node. Scan fails if the snapshot was released
+// meanwhile.
+func (c *GsiClient) RangeAtSnapshot(
+	defnID, snapshot uint64, low, high common.SecondaryKey,
+	inclusion Inclusion, distinct bool, limit int64,
+	callb ResponseHandler) error {
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		protoResp := &protobuf.ResponseStream{
+			Err: protobuf.NewError(err),
+		}
+		callb(protoResp)
+		return nil
+	}
+	// snapshot handles are local to the indexer node hosting the index,
+	// hence the scan is not retried on other nodes.
+	qc := c.scanClient(defnID)
+	if qc == nil {
 		return ErrorNoHost
 	}
-	qc := c.queryClients[queryport]
-	// time Range()
+	// time RangeAtSnapshot()
 	begin := time.Now().UnixNano()
-	err := qc.Range(defnID, low, high, inclusion, distinct, limit, callb)
+	collation := c.bridge.IndexCollation(defnID)
+	span := collateSpan(collation, low, high, inclusion)
+	callb, limit = c.collatedHandler(
+		collation, span, low, high, inclusion, nil, limit, callb)
+	err := qc.RangeAtSnapshot(
+		defnID, snapshot, span.low, span.high, span.inclusion, distinct,
+		limit, callb)
 	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
 	return err
 }
@@ -332,19 +649,116 @@ func (c *GsiClient) ScanAll(
 	// check whether the index is present and available.
 	if _, err := c.bridge.IndexState(defnID); err != nil {
 		protoResp := &protobuf.ResponseStream{
-			Err: &protobuf.Error{Error: proto.String(err.Error())},
+			Err: protobuf.NewError(err),
 		}
 		callb(protoResp)
 		return nil
 	}
-	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
-	if !ok {
+	// time ScanAll()
+	begin := time.Now().UnixNano()
+	callb = collateHandler(c.bridge.IndexCollation(defnID), callb)
+	err := c.doScan(defnID, callb, func(qc *gsiScanClient, callb ResponseHandler) error {
+		return qc.ScanAll(defnID, limit, callb)
+	})
+	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+	return err
+}
+
+// ScanAllOrdered for full table scan, like ScanAll() but entries are
+// returned in index order, merged across all slices of the index,
+// instead of interleaved as slices are read in parallel.
+func (c *GsiClient) ScanAllOrdered(
+	defnID uint64, limit int64, callb ResponseHandler) error {
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		protoResp := &protobuf.ResponseStream{
+			Err: protobuf.NewError(err),
+		}
+		callb(protoResp)
+		return nil
+	}
+	// time ScanAllOrdered()
+	begin := time.Now().UnixNano()
+	callb = collateHandler(c.bridge.IndexCollation(defnID), callb)
+	err := c.doScan(defnID, callb, func(qc *gsiScanClient, callb ResponseHandler) error {
+		return qc.ScanAllOrdered(defnID, limit, callb)
+	})
+	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+	return err
+}
+
+// RangeWithCursor scan index between low and high. If the scan stops at
+// limit with more entries left, the last response carries a cursor that
+// can be passed to ScanCursor() to fetch the next page from the same
+// snapshot. Cursors expire if not resumed within a server side ttl.
+func (c *GsiClient) RangeWithCursor(
+	defnID uint64, low, high common.SecondaryKey,
+	inclusion Inclusion, distinct bool, limit int64,
+	callb ResponseHandler) error {
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		protoResp := &protobuf.ResponseStream{
+			Err: protobuf.NewError(err),
+		}
+		callb(protoResp)
+		return nil
+	}
+	// time RangeWithCursor()
+	begin := time.Now().UnixNano()
+	collation := c.bridge.IndexCollation(defnID)
+	span := collateSpan(collation, low, high, inclusion)
+	// limit is the page size for indexer, entries filtered out on the
+	// client make for shorter pages.
+	callb, _ = c.collatedHandler(
+		collation, span, low, high, inclusion, nil, 0, callb)
+	err := c.doScan(defnID, callb, func(qc *gsiScanClient, callb ResponseHandler) error {
+		return qc.RangeWithCursor(
+			defnID, span.low, span.high, span.inclusion, distinct, limit, callb)
+	})
+	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+	return err
+}
+
+// ScanAllWithCursor for full table scan, paginated like RangeWithCursor().
+func (c *GsiClient) ScanAllWithCursor(
+	defnID uint64, limit int64, callb ResponseHandler) error {
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		protoResp := &protobuf.ResponseStream{
+			Err: protobuf.NewError(err),
+		}
+		callb(protoResp)
+		return nil
+	}
+	// time ScanAllWithCursor()
+	begin := time.Now().UnixNano()
+	callb = collateHandler(c.bridge.IndexCollation(defnID), callb)
+	err := c.doScan(defnID, callb, func(qc *gsiScanClient, callb ResponseHandler) error {
+		return qc.ScanAllWithCursor(defnID, limit, callb)
+	})
+	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
+	return err
+}
+
+// ScanCursor resume a paginated scan on index defnID from cursor, returning
+// upto limit more entries. The last response carries a new cursor if
+// there are more entries left.
+func (c *GsiClient) ScanCursor(
+	defnID uint64, cursor []byte, limit int64, callb ResponseHandler) error {
+
+	// cursors are held by the indexer node hosting the index, hence the
+	// scan is not retried on other nodes.
+	qc := c.scanClient(defnID)
+	if qc == nil {
 		return ErrorNoHost
 	}
-	qc := c.queryClients[queryport]
-	// time ScanAll()
+	// time ScanCursor()
 	begin := time.Now().UnixNano()
-	err := qc.ScanAll(defnID, limit, callb)
+	callb = collateHandler(c.bridge.IndexCollation(defnID), callb)
+	err := qc.ScanCursor(cursor, limit, callb)
 	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
 	return err
 }
@@ -357,14 +771,20 @@ func (c *GsiClient) CountLookup(
 	if _, err := c.bridge.IndexState(defnID); err != nil {
 		return 0, err
 	}
-	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
-	if !ok {
-		return 0, ErrorNoHost
-	}
-	qc := c.queryClients[queryport]
 	// time CountLookup()
 	begin := time.Now().UnixNano()
-	count, err := qc.CountLookup(defnID, values)
+	var count int64
+	var err error
+	if collation := c.bridge.IndexCollation(defnID); common.IsCollated(collation) {
+		count, err = c.collatedCount(func(callb ResponseHandler) error {
+			return c.collatedLookup(defnID, collation, values, false, 0, callb)
+		})
+	} else {
+		err = c.doRequest(defnID, func(qc *gsiScanClient) (err error) {
+			count, err = qc.CountLookup(defnID, values)
+			return err
+		})
+	}
 	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
 	return count, err
 }
@@ -378,31 +798,243 @@ func (c *GsiClient) CountRange(
 	if _, err := c.bridge.IndexState(defnID); err != nil {
 		return 0, err
 	}
-	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
-	if !ok {
-		return 0, ErrorNoHost
-	}
-	qc := c.queryClients[queryport]
 	// time CountRange()
 	begin := time.Now().UnixNano()
-	count, err := qc.CountRange(defnID, low, high, inclusion)
+	collation := c.bridge.IndexCollation(defnID)
+	span := collateSpan(collation, low, high, inclusion)
+	var count int64
+	var err error
+	if span.exact {
+		err = c.doRequest(defnID, func(qc *gsiScanClient) (err error) {
+			count, err = qc.CountRange(
+				defnID, span.low, span.high, span.inclusion)
+			return err
+		})
+	} else {
+		// entries are counted by the client, after filtering them.
+		count, err = c.collatedCount(func(callb ResponseHandler) error {
+			callb, _ = c.collatedHandler(
+				collation, span, low, high, inclusion, nil, 0, callb)
+			return c.doScan(defnID, callb, func(qc *gsiScanClient, callb ResponseHandler) error {
+				return qc.Range(
+					defnID, span.low, span.high, span.inclusion, false, 0, callb)
+			})
+		})
+	}
 	c.bridge.Timeit(defnID, float64(time.Now().UnixNano()-begin))
 	return count, err
 }
 
+// RetryStatistics returns, for each queryport, the number of requests
+// re-issued on transient errors and the number of requests that failed
+// after exhausting their retries.
+func (c *GsiClient) RetryStatistics() common.Statistics {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+
+	stats := make(common.Statistics)
+	for queryport, qc := range c.queryClients {
+		retries, exhausted := qc.RetryStatistics()
+		stats[queryport+":retries"] = retries
+		stats[queryport+":retriesExhausted"] = exhausted
+	}
+	stats["metadataRefreshes"] = atomic.LoadUint64(&c.refreshes)
+	return stats
+}
+
+// SetHooks to trace every request made by the client with `hooks`,
+// replaces hooks set earlier, nil stops tracing.
+func (c *GsiClient) SetHooks(hooks Hooks) {
+	c.rw.Lock()
+	defer c.rw.Unlock()
+	c.hooks = hooks
+	for _, queryClient := range c.queryClients {
+		queryClient.setHooks(hooks)
+	}
+}
+
 // Close the client and all open connections with server.
 func (c *GsiClient) Close() {
 	c.bridge.Close()
+	c.rw.RLock()
+	defer c.rw.RUnlock()
 	for _, queryClient := range c.queryClients {
 		queryClient.Close()
 	}
 }
 
+// scanClient returns the client for queryport hosting index defnID, nil
+// if the index is not hosted by any known queryport.
+func (c *GsiClient) scanClient(defnID uint64) *gsiScanClient {
+	queryport, ok := c.bridge.GetScanport(common.IndexDefnId(defnID))
+	if !ok {
+		return nil
+	}
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+	return c.queryClients[queryport]
+}
+
+// doRequest issues `request` to the queryport hosting index defnID. If
+// the index is not found on that queryport, for having moved to another
+// indexer or local metadata being stale, metadata is refreshed and the
+// request is re-issued to the queryport hosting the index as per
+// refreshed metadata, upto "retry.maxRefreshes" times.
+func (c *GsiClient) doRequest(
+	defnID uint64, request func(qc *gsiScanClient) error) error {
+
+	return c.withRefresh(defnID, func(qc *gsiScanClient, _ bool) error {
+		return request(qc)
+	})
+}
+
+// doScan is doRequest() for streaming scans. Index not found in the first
+// response is not passed to callb as long as the scan can be re-issued,
+// so that callb never sees responses from more than one queryport.
+func (c *GsiClient) doScan(
+	defnID uint64, callb ResponseHandler,
+	scan func(qc *gsiScanClient, callb ResponseHandler) error) error {
+
+	return c.withRefresh(defnID, func(qc *gsiScanClient, canRefresh bool) error {
+		var moved error
+		delivered := false
+		handler := func(resp ResponseReader) bool {
+			err := resp.Error()
+			if !delivered && canRefresh && isTopologyError(err) {
+				moved = err
+				return false
+			}
+			delivered = true
+			return callb(resp)
+		}
+		if err := scan(qc, handler); err != nil {
+			return err
+		}
+		return moved
+	})
+}
+
+// collatedHandler wraps `callb` to decode entries of an index with
+// collated string keys, and to filter out entries that are not selected
+// by the requested range and `residual` predicates, if indexer can't
+// select them exactly. Returns the limit to be applied by indexer.
+func (c *GsiClient) collatedHandler(
+	collation string, span collatedSpan, low, high common.SecondaryKey,
+	inclusion Inclusion, residual []Predicate, limit int64,
+	callb ResponseHandler) (ResponseHandler, int64) {
+
+	var spans []keySpan
+	if !span.exact {
+		spans = []keySpan{{low: low, high: high, inclusion: inclusion}}
+	}
+	filter := newCollatedFilter(collation, spans, residual)
+	if filter != nil {
+		callb, limit = filterHandler(filter, limit, callb), 0
+	}
+	return collateHandler(collation, callb), limit
+}
+
+// collatedLookup looks up `values` on an index with collated string keys,
+// with one range scan for every group of strings sharing a collation key.
+func (c *GsiClient) collatedLookup(
+	defnID uint64, collation string, values []common.SecondaryKey,
+	distinct bool, limit int64, callb ResponseHandler) error {
+
+	spans, keys, exact := lookupSpans(collation, values)
+	if !exact || len(spans) > 1 {
+		// entries are selected, and limit applied across spans, by client.
+		filter := newCollatedFilter(collation, keys, nil)
+		callb, limit = filterHandler(filter, limit, callb), 0
+	}
+	stopped := false
+	handler := collateHandler(collation, func(resp ResponseReader) bool {
+		if !callb(resp) {
+			stopped = true
+			return false
+		}
+		return true
+	})
+	for _, span := range spans {
+		err := c.doScan(defnID, handler, func(qc *gsiScanClient, callb ResponseHandler) error {
+			return qc.Range(
+				defnID, span.low, span.high, span.inclusion, distinct, limit, callb)
+		})
+		if err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+// collatedCount counts entries returned by `scan`.
+func (c *GsiClient) collatedCount(
+	scan func(callb ResponseHandler) error) (int64, error) {
+
+	var count int64
+	var scanErr error
+	err := scan(func(resp ResponseReader) bool {
+		if scanErr = resp.Error(); scanErr != nil {
+			return false
+		}
+		skeys, _, err := resp.GetEntries()
+		if scanErr = err; err != nil {
+			return false
+		}
+		count += int64(len(skeys))
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, scanErr
+}
+
+func (c *GsiClient) withRefresh(
+	defnID uint64, request func(qc *gsiScanClient, canRefresh bool) error) error {
+
+	for attempt := 0; ; attempt++ {
+		canRefresh := attempt < c.retry.maxRefreshes
+		err := error(ErrorNoHost)
+		if qc := c.scanClient(defnID); qc != nil {
+			err = request(qc, canRefresh)
+		}
+		if !canRefresh || !isTopologyError(err) {
+			return err
+		}
+		c.refresh(defnID, attempt, err)
+	}
+}
+
+// refresh index metadata after a request on index defnID failed with
+// `err`, starting clients for queryports that are new to the topology.
+func (c *GsiClient) refresh(defnID uint64, attempt int, err error) {
+	atomic.AddUint64(&c.refreshes, 1)
+	d := c.retry.backoff(attempt)
+	msg := "GsiClient: index %v request failed `%v`, refresh %v after %v\n"
+	common.Warnf(msg, defnID, err, attempt+1, d)
+	time.Sleep(d)
+
+	if _, err := c.bridge.Refresh(); err != nil {
+		common.Errorf("GsiClient: refresh failed `%v`\n", err)
+	}
+	c.rw.Lock()
+	defer c.rw.Unlock()
+	for _, queryport := range c.bridge.GetScanports() {
+		if _, ok := c.queryClients[queryport]; !ok {
+			queryClient := newGsiScanClient(queryport, c.config)
+			queryClient.setHooks(c.hooks)
+			c.queryClients[queryport] = queryClient
+		}
+	}
+}
+
 // create GSI client using cbqBridge and ScanCoordinator
 func makeWithCbq(cluster string, config common.Config) (*GsiClient, error) {
 	var err error
 	c := &GsiClient{
 		queryClients: make(map[string]*gsiScanClient),
+		config:       config,
+		retry:        newRetryPolicy(config),
 	}
 	if c.bridge, err = newCbqClient(cluster); err != nil {
 		return nil, err
@@ -420,8 +1052,10 @@ func makeWithMetaProvider(
 
 	c = &GsiClient{
 		queryClients: make(map[string]*gsiScanClient),
+		config:       config,
+		retry:        newRetryPolicy(config),
 	}
-	c.bridge, err = newMetaBridgeClient(cluster)
+	c.bridge, err = newMetaBridgeClient(cluster, config)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,394 @@
+package client
+
+import "bytes"
+import "encoding/json"
+
+import "github.com/couchbase/indexing/secondary/collatejson"
+import "github.com/couchbase/indexing/secondary/common"
+
+// String components of secondary keys of an index with collation other
+// than binary are stored encoded, see common.CollateString(). All strings
+// sharing a collation key are stored next to each other, ordered by the
+// original string, hence a string in a scan bound is sent to indexer as
+// the bound of that group of strings, see common.CollateBound(), and
+// lookups are turned into ranges over the group.
+//
+// When a group is followed by other components of the bound, that is
+// for composite keys, entries of the group are not ordered by those
+// components. Indexer is then asked for the whole group, bound is
+// truncated after the string, and entries outside the requested range
+// are filtered out by the client. Entries returned by indexer are decoded
+// before handing them over to application.
+
+// collatedSpan is a scan range as sent to indexer for an index with
+// collated string keys.
+type collatedSpan struct {
+	low, high common.SecondaryKey
+	inclusion Inclusion
+	exact     bool // indexer selects exactly the requested entries
+}
+
+// keySpan is a scan range as requested by application.
+type keySpan struct {
+	low, high common.SecondaryKey
+	inclusion Inclusion
+}
+
+func collateSpan(
+	collation string, low, high common.SecondaryKey,
+	inclusion Inclusion) collatedSpan {
+
+	if !common.IsCollated(collation) {
+		return collatedSpan{low: low, high: high, inclusion: inclusion, exact: true}
+	}
+	lowIncl := inclusion == Low || inclusion == Both
+	highIncl := inclusion == High || inclusion == Both
+	span := collatedSpan{inclusion: inclusion}
+	clow, lowExact := collateBound(collation, low, !lowIncl, false)
+	chigh, highExact := collateBound(collation, high, highIncl, true)
+	span.low, span.high = clow, chigh
+	span.exact = lowExact && highExact
+	if !span.exact {
+		// truncated bounds shall include entries sharing their prefix.
+		span.inclusion = Both
+	}
+	return span
+}
+
+// collateBound encodes string components of scan bound `key`. The last
+// component is encoded to sort above its group if `above` is true, other
+// strings are encoded to sort above their group if `widen` is true, and
+// the bound is truncated after them. Bound is truncated before array and
+// object components that contain strings. Returns false if the bound was
+// truncated.
+func collateBound(
+	collation string, key common.SecondaryKey,
+	above, widen bool) (common.SecondaryKey, bool) {
+
+	if key == nil {
+		return nil, true
+	}
+	bound := make(common.SecondaryKey, 0, len(key))
+	for i, value := range key {
+		if !common.HasStrings(value) {
+			bound = append(bound, value)
+			continue
+		}
+		s, ok := value.(string)
+		if !ok {
+			return bound, false
+		} else if i == len(key)-1 {
+			return append(bound, common.CollateBound(collation, s, above)), true
+		}
+		return append(bound, common.CollateBound(collation, s, widen)), false
+	}
+	return bound, true
+}
+
+// lookupSpans returns the ranges of stored entries selected by lookup
+// `values`, values selecting the same range are looked up once, and
+// whether these ranges select exactly the entries equal to values.
+func lookupSpans(
+	collation string,
+	values []common.SecondaryKey) ([]collatedSpan, []keySpan, bool) {
+
+	spans := make([]collatedSpan, 0, len(values))
+	keys := make([]keySpan, 0, len(values))
+	exact, seen := true, make(map[string]bool)
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		span := collateSpan(collation, value, value, Both)
+		keys = append(keys, keySpan{low: value, high: value, inclusion: Both})
+		exact = exact && span.exact
+		if id, err := json.Marshal([]interface{}{span.low, span.high}); err == nil {
+			if seen[string(id)] {
+				continue
+			}
+			seen[string(id)] = true
+		}
+		spans = append(spans, span)
+	}
+	return spans, keys, exact
+}
+
+// collatePredicates translates filter predicates on string components
+// into predicates on the stored encoding, predicates that can't be
+// translated are returned as residual, to be evaluated by the client.
+func collatePredicates(
+	collation string, filter []Predicate) (collated, residual []Predicate) {
+
+	if !common.IsCollated(collation) {
+		return filter, nil
+	}
+	collated = make([]Predicate, 0, len(filter))
+	for _, p := range filter {
+		if !common.HasStrings(p.Value) {
+			collated = append(collated, p)
+			continue
+		}
+		s, ok := p.Value.(string)
+		if !ok || p.Op == Ne {
+			residual = append(residual, p)
+			continue
+		}
+		below := common.CollateBound(collation, s, false)
+		above := common.CollateBound(collation, s, true)
+		switch p.Op {
+		case Eq:
+			collated = append(collated,
+				Predicate{Position: p.Position, Op: Gt, Value: below},
+				Predicate{Position: p.Position, Op: Lt, Value: above})
+		case Lt, Ge:
+			collated = append(collated,
+				Predicate{Position: p.Position, Op: p.Op, Value: below})
+		case Le, Gt:
+			collated = append(collated,
+				Predicate{Position: p.Position, Op: p.Op, Value: above})
+		}
+	}
+	return collated, residual
+}
+
+// collatedFilter selects decoded entries of an index with collated string
+// keys that are within any of `spans`, if any, and satisfy all of the
+// `predicates`.
+type collatedFilter struct {
+	collation  string
+	spans      []keySpan
+	predicates []Predicate
+	codec      *collatejson.Codec
+}
+
+func newCollatedFilter(
+	collation string, spans []keySpan, predicates []Predicate) *collatedFilter {
+
+	if len(spans) == 0 && len(predicates) == 0 {
+		return nil
+	}
+	return &collatedFilter{
+		collation:  collation,
+		spans:      spans,
+		predicates: predicates,
+		codec:      collatejson.NewCodec(16),
+	}
+}
+
+func (f *collatedFilter) match(key common.SecondaryKey) (bool, error) {
+	for _, p := range f.predicates {
+		if p.Position >= len(key) {
+			return false, nil
+		}
+		cmp, err := f.compare(
+			common.SecondaryKey{key[p.Position]},
+			common.SecondaryKey{p.Value})
+		if err != nil || !p.Op.holds(cmp) {
+			return false, err
+		}
+	}
+	if len(f.spans) == 0 {
+		return true, nil
+	}
+	for _, span := range f.spans {
+		if ok, err := f.within(key, span); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (f *collatedFilter) within(key common.SecondaryKey, span keySpan) (bool, error) {
+	if span.low != nil {
+		cmp, err := f.compare(key, span.low)
+		if err != nil {
+			return false, err
+		} else if cmp < 0 || (cmp == 0 && !(span.inclusion == Low || span.inclusion == Both)) {
+			return false, nil
+		}
+	}
+	if span.high != nil {
+		cmp, err := f.compare(key, span.high)
+		if err != nil {
+			return false, err
+		} else if cmp > 0 || (cmp == 0 && !(span.inclusion == High || span.inclusion == Both)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// compare `key` with `bound` in index collation, like indexer does,
+// only the leading components of `key` that are present in `bound` are
+// compared.
+func (f *collatedFilter) compare(key, bound common.SecondaryKey) (int, error) {
+	if len(key) > len(bound) {
+		key = key[:len(bound)]
+	}
+	k, err := f.encode(key)
+	if err != nil {
+		return 0, err
+	}
+	b, err := f.encode(bound)
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Compare(k, b), nil
+}
+
+func (f *collatedFilter) encode(key common.SecondaryKey) ([]byte, error) {
+	data, err := json.Marshal(common.CollationKeyValues(f.collation, key))
+	if err != nil {
+		return nil, err
+	}
+	return f.codec.Encode(data, make([]byte, 0, len(data)*3))
+}
+
+func (op Comparison) holds(cmp int) bool {
+	switch op {
+	case Eq:
+		return cmp == 0
+	case Ne:
+		return cmp != 0
+	case Lt:
+		return cmp < 0
+	case Le:
+		return cmp <= 0
+	case Gt:
+		return cmp > 0
+	case Ge:
+		return cmp >= 0
+	}
+	return false
+}
+
+// filterHandler wraps `callb` to drop entries not selected by `filter`
+// and to stop the scan after `limit` entries, if limit is positive.
+func filterHandler(
+	filter *collatedFilter, limit int64, callb ResponseHandler) ResponseHandler {
+
+	if filter == nil {
+		return callb
+	}
+	var count int64
+	return func(resp ResponseReader) bool {
+		left := int64(-1)
+		if limit > 0 {
+			left = limit - count
+		}
+		r := &filteredResponse{ResponseReader: resp}
+		count += r.load(filter, left)
+		if !callb(r) {
+			return false
+		}
+		return limit <= 0 || count < limit
+	}
+}
+
+type filteredResponse struct {
+	ResponseReader
+	selected []int
+	skeys    []common.SecondaryKey
+	pkeys    [][]byte
+	err      error
+}
+
+// load entries of response that are selected by `filter`, upto `left`
+// entries if it is not negative, returns the number of selected entries.
+func (r *filteredResponse) load(filter *collatedFilter, left int64) int64 {
+	skeys, pkeys, err := r.ResponseReader.GetEntries()
+	if err != nil {
+		r.err = err
+		return 0
+	} else if skeys == nil {
+		return 0
+	}
+	r.skeys = make([]common.SecondaryKey, 0, len(skeys))
+	r.pkeys = make([][]byte, 0, len(pkeys))
+	for i, skey := range skeys {
+		if left >= 0 && int64(len(r.selected)) == left {
+			break
+		}
+		ok, err := filter.match(skey)
+		if err != nil {
+			r.err = err
+			return 0
+		} else if ok {
+			r.selected = append(r.selected, i)
+			r.skeys, r.pkeys = append(r.skeys, skey), append(r.pkeys, pkeys[i])
+		}
+	}
+	return int64(len(r.selected))
+}
+
+// GetEntries implements ResponseReader{} interface.
+func (r *filteredResponse) GetEntries() (
+	[]common.SecondaryKey, [][]byte, error) {
+
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	return r.skeys, r.pkeys, nil
+}
+
+// GetProjectedValues implements ResponseReader{} interface.
+func (r *filteredResponse) GetProjectedValues() ([]common.SecondaryKey, error) {
+	values, err := r.ResponseReader.GetProjectedValues()
+	if err != nil || values == nil {
+		return values, err
+	}
+	selected := make([]common.SecondaryKey, 0, len(r.selected))
+	for _, i := range r.selected {
+		selected = append(selected, values[i])
+	}
+	return selected, nil
+}
+
+// collateHandler wraps `callb` to decode entries returned by scans.
+func collateHandler(collation string, callb ResponseHandler) ResponseHandler {
+	if !common.IsCollated(collation) {
+		return callb
+	}
+	return func(resp ResponseReader) bool {
+		return callb(&collatedResponse{ResponseReader: resp, collation: collation})
+	}
+}
+
+type collatedResponse struct {
+	ResponseReader
+	collation string
+}
+
+// GetEntries implements ResponseReader{} interface.
+func (r *collatedResponse) GetEntries() (
+	[]common.SecondaryKey, [][]byte, error) {
+
+	skeys, pkeys, err := r.ResponseReader.GetEntries()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, skey := range skeys {
+		if skey != nil {
+			values := common.UncollateValues(r.collation, skey)
+			skeys[i] = common.SecondaryKey(values)
+		}
+	}
+	return skeys, pkeys, nil
+}
+
+// GetGroups implements ResponseReader{} interface.
+func (r *collatedResponse) GetGroups() (
+	[]common.SecondaryKey, []common.SecondaryKey, error) {
+
+	gkeys, aggrs, err := r.ResponseReader.GetGroups()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range gkeys {
+		gkeys[i] = common.SecondaryKey(
+			common.UncollateValues(r.collation, gkeys[i]))
+		aggrs[i] = common.SecondaryKey(
+			common.UncollateValues(r.collation, aggrs[i]))
+	}
+	return gkeys, aggrs, nil
+}
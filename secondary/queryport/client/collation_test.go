@@ -0,0 +1,233 @@
+package client
+
+import "reflect"
+import "testing"
+
+import common "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+
+// scanCollated emulates a range scan by indexer over `entries` stored
+// with `collation`, followed by the filtering done on the client.
+func scanCollated(
+	t *testing.T, collation string, entries []common.SecondaryKey,
+	span collatedSpan, spans []keySpan,
+	predicates []Predicate) []common.SecondaryKey {
+
+	// indexer compares the stored encoding of entries.
+	stored := []keySpan{{low: span.low, high: span.high, inclusion: span.inclusion}}
+	indexer := newCollatedFilter(common.CollationBinary, stored, predicates)
+	filter := newCollatedFilter(collation, spans, nil)
+	out := make([]common.SecondaryKey, 0)
+	for _, entry := range entries {
+		collated := common.CollateValues(collation, entry)
+		ok, err := indexer.match(collated)
+		if err != nil {
+			t.Fatal(err)
+		} else if ok && filter != nil {
+			if ok, err = filter.match(entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ok {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+func scanRange(
+	t *testing.T, collation string, entries []common.SecondaryKey,
+	low, high common.SecondaryKey,
+	inclusion Inclusion) []common.SecondaryKey {
+
+	span := collateSpan(collation, low, high, inclusion)
+	var spans []keySpan
+	if !span.exact {
+		spans = []keySpan{{low: low, high: high, inclusion: inclusion}}
+	}
+	return scanCollated(t, collation, entries, span, spans, nil)
+}
+
+func scanLookup(
+	t *testing.T, collation string, entries []common.SecondaryKey,
+	values []common.SecondaryKey) []common.SecondaryKey {
+
+	spans, keys, exact := lookupSpans(collation, values)
+	out := make([]common.SecondaryKey, 0)
+	for _, span := range spans {
+		var filter []keySpan
+		if !exact || len(spans) > 1 {
+			filter = keys
+		}
+		out = append(out, scanCollated(t, collation, entries, span, filter, nil)...)
+	}
+	return out
+}
+
+func secKeys(values ...interface{}) []common.SecondaryKey {
+	out := make([]common.SecondaryKey, 0, len(values))
+	for _, value := range values {
+		if key, ok := value.([]interface{}); ok {
+			out = append(out, common.SecondaryKey(key))
+		} else {
+			out = append(out, common.SecondaryKey{value})
+		}
+	}
+	return out
+}
+
+func TestCollatedLookup(t *testing.T) {
+	collation := common.CollationCaseInsensitive
+	entries := secKeys("FOO", "Foo", "foo", "fo", "foob", "fop")
+	out := scanLookup(t, collation, entries, secKeys("FOO"))
+	if ref := secKeys("FOO", "Foo", "foo"); !reflect.DeepEqual(ref, out) {
+		t.Fatalf("expected %v, got %v", ref, out)
+	}
+	// values sharing the collation key are looked up once.
+	out = scanLookup(t, collation, entries, secKeys("foo", "fOO", "fop"))
+	if ref := secKeys("FOO", "Foo", "foo", "fop"); !reflect.DeepEqual(ref, out) {
+		t.Fatalf("expected %v, got %v", ref, out)
+	}
+
+	// composite keys.
+	entries = secKeys(
+		[]interface{}{"Foo", 1.0}, []interface{}{"Foo", 2.0},
+		[]interface{}{"foo", 1.0}, []interface{}{"foo", 3.0})
+	values := secKeys([]interface{}{"FOO", 1.0})
+	out = scanLookup(t, collation, entries, values)
+	ref := secKeys([]interface{}{"Foo", 1.0}, []interface{}{"foo", 1.0})
+	if !reflect.DeepEqual(ref, out) {
+		t.Fatalf("expected %v, got %v", ref, out)
+	}
+}
+
+func TestCollatedRange(t *testing.T) {
+	collation := common.CollationCaseInsensitive
+	entries := secKeys("A", "a", "ab", "B", "b", "ba", "C", "c")
+	testcases := []struct {
+		low, high string
+		inclusion Inclusion
+		ref       []common.SecondaryKey
+	}{
+		{"b", "c", Both, secKeys("B", "b", "ba", "C", "c")},
+		{"b", "c", Low, secKeys("B", "b", "ba")},
+		{"b", "c", High, secKeys("ba", "C", "c")},
+		{"b", "c", Neither, secKeys("ba")},
+		{"B", "B", Both, secKeys("B", "b")},
+		{"a", "B", Low, secKeys("A", "a", "ab")},
+	}
+	for _, tc := range testcases {
+		low, high := common.SecondaryKey{tc.low}, common.SecondaryKey{tc.high}
+		out := scanRange(t, collation, entries, low, high, tc.inclusion)
+		if !reflect.DeepEqual(tc.ref, out) {
+			t.Fatalf("%q..%q %v: expected %v, got %v",
+				tc.low, tc.high, tc.inclusion, tc.ref, out)
+		}
+	}
+
+	// bounds of composite keys.
+	entries = secKeys(
+		[]interface{}{"B", 4.0}, []interface{}{"B", 6.0},
+		[]interface{}{"b", 5.0}, []interface{}{"b", 7.0},
+		[]interface{}{"c", 1.0})
+	low := common.SecondaryKey{"b", 5.0}
+	high := common.SecondaryKey{"B", 6.0}
+	out := scanRange(t, collation, entries, low, high, Both)
+	ref := secKeys([]interface{}{"B", 6.0}, []interface{}{"b", 5.0})
+	if !reflect.DeepEqual(ref, out) {
+		t.Fatalf("expected %v, got %v", ref, out)
+	}
+	out = scanRange(t, collation, entries, low, nil, Neither)
+	ref = secKeys(
+		[]interface{}{"B", 6.0}, []interface{}{"b", 7.0},
+		[]interface{}{"c", 1.0})
+	if !reflect.DeepEqual(ref, out) {
+		t.Fatalf("expected %v, got %v", ref, out)
+	}
+
+	// unicode collation.
+	collation = common.CollationUnicode
+	entries = secKeys("a", "ab", "Ä", "b")
+	low, high = common.SecondaryKey{"a"}, common.SecondaryKey{"b"}
+	out = scanRange(t, collation, entries, low, high, Neither)
+	if ref := secKeys("ab", "Ä"); !reflect.DeepEqual(ref, out) {
+		t.Fatalf("expected %v, got %v", ref, out)
+	}
+}
+
+func TestCollatePredicates(t *testing.T) {
+	collation := common.CollationCaseInsensitive
+	entries := secKeys("A", "B", "b", "bb", "C")
+	all := collatedSpan{exact: true}
+	testcases := []struct {
+		op  Comparison
+		ref []common.SecondaryKey
+	}{
+		{Eq, secKeys("B", "b")},
+		{Ne, secKeys("A", "bb", "C")},
+		{Lt, secKeys("A")},
+		{Le, secKeys("A", "B", "b")},
+		{Gt, secKeys("bb", "C")},
+		{Ge, secKeys("B", "b", "bb", "C")},
+	}
+	for _, tc := range testcases {
+		filter := []Predicate{{Position: 0, Op: tc.op, Value: "b"}}
+		collated, residual := collatePredicates(collation, filter)
+		out := scanCollated(t, collation, entries, all, nil, collated)
+		if f := newCollatedFilter(collation, nil, residual); f != nil {
+			selected := make([]common.SecondaryKey, 0)
+			for _, entry := range out {
+				if ok, err := f.match(entry); err != nil {
+					t.Fatal(err)
+				} else if ok {
+					selected = append(selected, entry)
+				}
+			}
+			out = selected
+		}
+		if !reflect.DeepEqual(tc.ref, out) {
+			t.Fatalf("%v: expected %v, got %v", tc.op, tc.ref, out)
+		}
+	}
+}
+
+func TestCollatedHandlerLimit(t *testing.T) {
+	collation := common.CollationCaseInsensitive
+	resp := &protobuf.ResponseStream{}
+	for i, key := range []string{`["a\u0000A",1]`, `["b\u0000B",2]`,
+		`["b\u0000b",1]`, `["b\u0000b",3]`, `["c\u0000c",1]`} {
+
+		resp.IndexEntries = append(resp.IndexEntries, &protobuf.IndexEntry{
+			EntryKey:   []byte(key),
+			PrimaryKey: []byte{byte('0' + i)},
+		})
+	}
+
+	// entries within ["b", 1] and ["b", 2] are filtered on the client.
+	c := &GsiClient{}
+	low, high := common.SecondaryKey{"b", 1.0}, common.SecondaryKey{"b", 2.0}
+	span := collateSpan(collation, low, high, Both)
+	if span.exact {
+		t.Fatalf("expected composite bounds to be filtered by client")
+	}
+	var pkeys [][]byte
+	callb := func(resp ResponseReader) bool {
+		_, ps, err := resp.GetEntries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkeys = append(pkeys, ps...)
+		return true
+	}
+	handler, limit := c.collatedHandler(
+		collation, span, low, high, Both, nil, 1, callb)
+	if limit != 0 {
+		t.Fatalf("expected no limit on indexer, got %v", limit)
+	}
+	if handler(resp) {
+		t.Fatalf("expected scan to stop at limit")
+	}
+	if ref := [][]byte{[]byte("1")}; !reflect.DeepEqual(ref, pkeys) {
+		t.Fatalf("expected %q, got %q", ref, pkeys)
+	}
+}
@@ -1,6 +1,6 @@
 package client
 
-import "errors"
+import "crypto/tls"
 import "fmt"
 import "net"
 import "runtime/debug"
@@ -11,13 +11,19 @@ import "github.com/couchbase/indexing/secondary/transport"
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 
 // ErrorClosedPool
-var ErrorClosedPool = errors.New("queryport.closedPool")
+var ErrorClosedPool = c.NewError(211, "queryport.closedPool", false)
 
 // ErrorNoPool
-var ErrorNoPool = errors.New("queryport.errorNoPool")
+var ErrorNoPool = c.NewError(212, "queryport.errorNoPool", false)
 
 // ErrorPoolTimeout
-var ErrorPoolTimeout = errors.New("queryport.connPoolTimeout")
+var ErrorPoolTimeout = c.NewError(213, "queryport.connPoolTimeout", false)
+
+// ErrorReadTimeout
+var ErrorReadTimeout = c.NewError(214, "queryport.readTimeout", false)
+
+// ErrorInvalidTLSConfig
+var ErrorInvalidTLSConfig = c.NewError(216, "queryport.invalidTLSConfig", false)
 
 type connectionPool struct {
 	host        string
@@ -29,11 +35,59 @@ type connectionPool struct {
 	timeout      time.Duration
 	availTimeout time.Duration
 	logPrefix    string
+	// compression accepted for responses on native transport.
+	acceptCompression byte
+	// connect over TLS, if not nil.
+	tlsConfig *tls.Config
 }
 
+// connection to queryport, on native transport `conn` and `pkt` are set,
+// on gRPC transport `gstream` is set.
 type connection struct {
-	conn net.Conn
-	pkt  *transport.TransportPacket
+	conn    net.Conn
+	pkt     *transport.TransportPacket
+	gstream *grpcStream
+}
+
+// send request on connection, within timeout.
+func (connectn *connection) send(req interface{}, timeout time.Duration) error {
+	if connectn.gstream != nil {
+		return connectn.gstream.send(req)
+	}
+	connectn.conn.SetWriteDeadline(time.Now().Add(timeout))
+	return connectn.pkt.Send(connectn.conn, req)
+}
+
+// receive a response on connection, within timeout.
+func (connectn *connection) receive(timeout time.Duration) (interface{}, error) {
+	if connectn.gstream != nil {
+		return connectn.gstream.receive(timeout)
+	}
+	connectn.conn.SetReadDeadline(time.Now().Add(timeout))
+	return connectn.pkt.Receive(connectn.conn)
+}
+
+// secure returns whether the connection is over TLS.
+func (connectn *connection) secure() bool {
+	if connectn.gstream != nil {
+		return connectn.gstream.secure
+	}
+	_, ok := connectn.conn.(*tls.Conn)
+	return ok
+}
+
+func (connectn *connection) localAddr() string {
+	if connectn.gstream != nil {
+		return connectn.gstream.laddr
+	}
+	return connectn.conn.LocalAddr().String()
+}
+
+func (connectn *connection) close() error {
+	if connectn.gstream != nil {
+		return connectn.gstream.close()
+	}
+	return connectn.conn.Close()
 }
 
 func newConnectionPool(
@@ -60,15 +114,31 @@ var ConnPoolCallback func(host string, source string, start time.Time, err error
 
 func (cp *connectionPool) defaultMkConn(host string) (*connection, error) {
 	c.Infof("%v open new connection ...\n", cp.logPrefix)
-	conn, err := net.Dial("tcp", host)
+	var conn net.Conn
+	var err error
+	if cp.tlsConfig != nil {
+		conn, err = tls.Dial("tcp", host, cp.tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", host)
+	}
 	if err != nil {
 		return nil, err
 	}
 	flags := transport.TransportFlag(0).SetProtobuf()
+	flags = flags.SetAcceptCompression(cp.acceptCompression)
 	pkt := transport.NewTransportPacket(cp.maxPayload, flags)
-	pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	pkt.SetBufEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncodeInBuf)
 	pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
-	return &connection{conn, pkt}, nil
+	return &connection{conn: conn, pkt: pkt}, nil
+}
+
+func (cp *connectionPool) grpcMkConn(host string) (*connection, error) {
+	c.Infof("%v open new gRPC stream ...\n", cp.logPrefix)
+	gstream, err := dialGrpcStream(host, cp.maxPayload, cp.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &connection{gstream: gstream}, nil
 }
 
 func (cp *connectionPool) Close() (err error) {
@@ -80,7 +150,7 @@ func (cp *connectionPool) Close() (err error) {
 	}()
 	close(cp.connections)
 	for connectn := range cp.connections {
-		connectn.conn.Close()
+		connectn.close()
 	}
 	c.Infof("%v ... stopped\n", cp.logPrefix)
 	return
@@ -162,14 +232,14 @@ func (cp *connectionPool) Get() (*connection, error) {
 }
 
 func (cp *connectionPool) Return(connectn *connection, healthy bool) {
-	if connectn.conn == nil {
+	if connectn == nil {
 		return
 	}
 
-	laddr := connectn.conn.LocalAddr()
+	laddr := connectn.localAddr()
 	if cp == nil {
 		c.Infof("%v pool closed\n", cp.logPrefix, laddr)
-		connectn.conn.Close()
+		connectn.close()
 	}
 
 	if healthy {
@@ -179,7 +249,7 @@ func (cp *connectionPool) Return(connectn *connection, healthy bool) {
 				// closed and we're trying to return a
 				// connection to it anyway.  Just close the
 				// connection.
-				connectn.conn.Close()
+				connectn.close()
 			}
 		}()
 
@@ -189,12 +259,12 @@ func (cp *connectionPool) Return(connectn *connection, healthy bool) {
 		default:
 			c.Debugf("%v closing overflow connection %q\n", cp.logPrefix, laddr)
 			<-cp.createsem
-			connectn.conn.Close()
+			connectn.close()
 		}
 
 	} else {
 		c.Infof("%v closing unhealthy connection %q\n", cp.logPrefix, laddr)
 		<-cp.createsem
-		connectn.conn.Close()
+		connectn.close()
 	}
 }
@@ -0,0 +1,124 @@
+//go:build grpc
+// +build grpc
+
+package client
+
+import "context"
+import "crypto/tls"
+import "fmt"
+import "io"
+import "time"
+
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "google.golang.org/grpc"
+import "google.golang.org/grpc/credentials"
+import "google.golang.org/grpc/credentials/insecure"
+
+// grpcStream is a queryport stream on gRPC transport, pooled in place of
+// a native connection. Each stream has its own gRPC connection so that
+// closing an unhealthy stream does not affect others.
+type grpcStream struct {
+	cc     *grpc.ClientConn
+	stream protobuf.Queryport_StreamClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	recvch chan grpcRecv // responses read ahead by doReceive()
+	laddr  string        // for logging, gRPC does not expose local address
+	secure bool          // connected over TLS
+}
+
+type grpcRecv struct {
+	pl  *protobuf.QueryPayload
+	err error
+}
+
+// dialGrpcStream to host, over TLS if tlsConfig is not nil.
+func dialGrpcStream(
+	host string, maxPayload int, tlsConfig *tls.Config) (*grpcStream, error) {
+
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	cc, err := grpc.NewClient(
+		host,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(
+			grpc.CallContentSubtype(protobuf.GrpcCodecName),
+			grpc.MaxCallRecvMsgSize(maxPayload),
+			grpc.MaxCallSendMsgSize(maxPayload)))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := protobuf.NewQueryportStream(ctx, cc)
+	if err != nil {
+		cancel()
+		cc.Close()
+		return nil, err
+	}
+	gstream := &grpcStream{
+		cc:     cc,
+		stream: stream,
+		ctx:    ctx,
+		cancel: cancel,
+		recvch: make(chan grpcRecv, 1),
+		secure: tlsConfig != nil,
+	}
+	gstream.laddr = fmt.Sprintf("grpc-%p", gstream)
+	go gstream.doReceive()
+	return gstream, nil
+}
+
+func (s *grpcStream) send(req interface{}) error {
+	pl, err := protobuf.EncodePayload(req)
+	if err != nil {
+		return err
+	}
+	return s.stream.Send(pl)
+}
+
+// receive a response, if it does not arrive within timeout the stream
+// is cancelled and shall be closed.
+func (s *grpcStream) receive(timeout time.Duration) (interface{}, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r, ok := <-s.recvch:
+		if !ok { // failure is already returned by an earlier receive.
+			return nil, io.EOF
+		} else if r.err != nil {
+			return nil, r.err
+		}
+		return protobuf.DecodePayload(r.pl)
+
+	case <-timer.C:
+		s.cancel()
+		return nil, ErrorReadTimeout
+	}
+}
+
+// go-routine to read responses from the stream, reading at most one
+// response ahead of receive(). It is the only routine receiving on the
+// stream and exits once the stream fails or is cancelled.
+func (s *grpcStream) doReceive() {
+	defer close(s.recvch)
+
+	donech := s.ctx.Done()
+	for {
+		pl, err := s.stream.Recv()
+		select {
+		case s.recvch <- grpcRecv{pl: pl, err: err}:
+		case <-donech:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *grpcStream) close() error {
+	s.cancel()
+	return s.cc.Close()
+}
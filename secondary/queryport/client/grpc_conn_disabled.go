@@ -0,0 +1,33 @@
+//go:build !grpc
+// +build !grpc
+
+package client
+
+import "crypto/tls"
+import "time"
+
+// grpcStream stands in for a queryport stream on gRPC transport, client
+// is built without gRPC transport unless `grpc` build tag is set.
+type grpcStream struct {
+	laddr  string
+	secure bool
+}
+
+// dialGrpcStream always fails with ErrorGrpcUnavailable.
+func dialGrpcStream(
+	host string, maxPayload int, tlsConfig *tls.Config) (*grpcStream, error) {
+
+	return nil, ErrorGrpcUnavailable
+}
+
+func (s *grpcStream) send(req interface{}) error {
+	return ErrorGrpcUnavailable
+}
+
+func (s *grpcStream) receive(timeout time.Duration) (interface{}, error) {
+	return nil, ErrorGrpcUnavailable
+}
+
+func (s *grpcStream) close() error {
+	return nil
+}
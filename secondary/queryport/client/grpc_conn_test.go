@@ -0,0 +1,94 @@
+//go:build grpc
+// +build grpc
+
+package client
+
+import "testing"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/couchbase/indexing/secondary/queryport"
+import "github.com/couchbaselabs/goprotobuf/proto"
+
+func TestGrpcStream(t *testing.T) {
+	common.LogIgnore()
+
+	addr := "127.0.0.1:9121"
+	blockch := make(chan bool)
+	defer close(blockch)
+	handler := func(
+		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+
+		defer close(respch)
+		defnID := req.(*protobuf.StatisticsRequest).GetDefnID()
+		if defnID == 0 { // never responds
+			select {
+			case <-blockch:
+			case <-quitch:
+			}
+			return
+		}
+		for i := uint64(0); i < defnID; i++ {
+			resp := &protobuf.StatisticsResponse{
+				Stats: &protobuf.IndexStatistics{KeysCount: proto.Uint64(i)},
+			}
+			select {
+			case respch <- resp:
+			case <-quitch:
+				return
+			}
+		}
+	}
+	handlers := queryport.NewHandlers()
+	handlers.Register(&protobuf.StatisticsRequest{}, handler)
+	config := common.SystemConfig.SectionConfig("queryport.indexer.", true)
+	s, err := queryport.NewGrpcServer(addr, handlers, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	maxPayload := config["maxPayload"].Int()
+	stream, err := dialGrpcStream(addr, maxPayload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.close()
+
+	// responses are streamed in order and ended by StreamEndResponse.
+	req := &protobuf.StatisticsRequest{
+		DefnID: proto.Uint64(100), Span: &protobuf.Span{},
+	}
+	if err := stream.send(req); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < 100; i++ {
+		resp, err := stream.receive(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats := resp.(*protobuf.StatisticsResponse).GetStats()
+		if n := stats.GetKeysCount(); n != i {
+			t.Fatalf("expected response %v, got %v", i, n)
+		}
+	}
+	resp, err := stream.receive(time.Second)
+	if _, ok := resp.(*protobuf.StreamEndResponse); !ok || err != nil {
+		t.Fatalf("expected StreamEndResponse, got %v %v", resp, err)
+	}
+
+	// a response that does not arrive in time cancels the stream.
+	req = &protobuf.StatisticsRequest{
+		DefnID: proto.Uint64(0), Span: &protobuf.Span{},
+	}
+	if err := stream.send(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.receive(100 * time.Millisecond); err != ErrorReadTimeout {
+		t.Fatalf("expected %v, got %v", ErrorReadTimeout, err)
+	}
+	if _, err := stream.receive(time.Second); err == nil {
+		t.Fatalf("expected cancelled stream to fail")
+	}
+}
@@ -0,0 +1,76 @@
+package client
+
+// Hooks are called at the stages of every request made to a queryport,
+// so that external code can integrate tracing systems, like spans of
+// OpenTracing, without the client depending on any tracer. Hooks of a
+// request are called in order, hooks of different requests can be
+// called concurrently.
+type Hooks interface {
+	// OnRequestStart is called before `req` is sent to `queryport`,
+	// returns a context, like a span, that is passed to subsequent
+	// hooks of the request.
+	OnRequestStart(queryport string, req interface{}) interface{}
+
+	// OnBatch is called for every response received for the request,
+	// before it is passed to the caller. Responses of attempts that
+	// are retried are not passed, refer "retry.maxRetries".
+	OnBatch(ctx interface{}, resp interface{})
+
+	// OnComplete is called once the request is complete, with the
+	// error it failed with, if any.
+	OnComplete(ctx interface{}, err error)
+}
+
+// hooksRef holds Hooks in atomic.Value, which needs values of the same
+// concrete type.
+type hooksRef struct {
+	hooks Hooks
+}
+
+// requestTrace of a request, methods are no-op on nil trace.
+type requestTrace struct {
+	hooks  Hooks
+	ctx    interface{}
+	failed error // first error responded
+}
+
+// startTrace of `req`, nil if `hooks` is nil.
+func startTrace(hooks Hooks, queryport string, req interface{}) *requestTrace {
+	if hooks == nil {
+		return nil
+	}
+	ctx := hooks.OnRequestStart(queryport, req)
+	return &requestTrace{hooks: hooks, ctx: ctx}
+}
+
+func (t *requestTrace) batch(resp interface{}) {
+	if t == nil {
+		return
+	}
+	if r, ok := resp.(interface{ Error() error }); ok && t.failed == nil {
+		t.failed = r.Error()
+	}
+	t.hooks.OnBatch(t.ctx, resp)
+}
+
+func (t *requestTrace) complete(err error) {
+	if t == nil {
+		return
+	}
+	if err == nil {
+		err = t.failed
+	}
+	t.hooks.OnComplete(t.ctx, err)
+}
+
+// setHooks of client, nil stops tracing.
+func (c *gsiScanClient) setHooks(hooks Hooks) {
+	c.hooks.Store(hooksRef{hooks})
+}
+
+func (c *gsiScanClient) getHooks() Hooks {
+	if ref, ok := c.hooks.Load().(hooksRef); ok {
+		return ref.hooks
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package client
+
+import "errors"
+import "reflect"
+import "testing"
+
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+
+type testHooks struct {
+	events []interface{}
+	err    error
+}
+
+func (h *testHooks) OnRequestStart(queryport string, req interface{}) interface{} {
+	h.events = append(h.events, queryport)
+	return len(h.events)
+}
+
+func (h *testHooks) OnBatch(ctx interface{}, resp interface{}) {
+	h.events = append(h.events, ctx)
+}
+
+func (h *testHooks) OnComplete(ctx interface{}, err error) {
+	h.events = append(h.events, ctx)
+	h.err = err
+}
+
+func TestRequestTrace(t *testing.T) {
+	c := &gsiScanClient{queryport: "indexer:9101"}
+	if trace := startTrace(c.getHooks(), c.queryport, nil); trace != nil {
+		t.Fatalf("expected no trace without hooks")
+	}
+
+	hooks := &testHooks{}
+	c.setHooks(hooks)
+	trace := startTrace(c.getHooks(), c.queryport, &protobuf.ScanRequest{})
+	trace.batch(&protobuf.ResponseStream{})
+	trace.batch(&protobuf.StreamEndResponse{})
+	trace.complete(nil)
+	ref := []interface{}{"indexer:9101", 1, 1, 1}
+	if !reflect.DeepEqual(hooks.events, ref) {
+		t.Fatalf("expected %v, got %v", ref, hooks.events)
+	} else if hooks.err != nil {
+		t.Fatalf("unexpected error %v", hooks.err)
+	}
+
+	// first error responded is reported on completion
+	failed := errors.New("scan failed")
+	trace = startTrace(c.getHooks(), c.queryport, &protobuf.ScanRequest{})
+	trace.batch(&protobuf.ResponseStream{Err: protobuf.NewError(failed)})
+	trace.complete(nil)
+	if hooks.err == nil || hooks.err.Error() != failed.Error() {
+		t.Fatalf("expected %v, got %v", failed, hooks.err)
+	}
+
+	c.setHooks(nil)
+	if c.getHooks() != nil {
+		t.Fatalf("expected hooks to be reset")
+	}
+}
@@ -6,7 +6,6 @@ import "strings"
 import "time"
 import "errors"
 import "encoding/json"
-import "math/rand"
 
 import common "github.com/couchbase/indexing/secondary/common"
 import mclient "github.com/couchbase/indexing/secondary/manager/client"
@@ -25,7 +24,8 @@ type metadataClient struct {
 	loads map[common.IndexDefnId]*loadHeuristics // adminport -> loadHeuristics
 }
 
-func newMetaBridgeClient(cluster string) (c *metadataClient, err error) {
+func newMetaBridgeClient(
+	cluster string, config common.Config) (c *metadataClient, err error) {
 
 	cinfo, err := common.NewClusterInfoCache(common.ClusterUrl(cluster), "default" /*pooln*/)
 	if err != nil {
@@ -50,6 +50,16 @@ func newMetaBridgeClient(cluster string) (c *metadataClient, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if cv, ok := config["placementPolicy"]; ok {
+		policy, err := mclient.NewPlacementPolicy(cv.String())
+		if err != nil {
+			return nil, err
+		}
+		b.mdClient.SetPlacementPolicy(policy)
+	}
+	if cv, ok := config["requestWindow"]; ok {
+		b.mdClient.SetRequestWindow(cv.Int())
+	}
 	// populate indexers' adminport and queryport
 	if b.adminports, err = getIndexerAdminports(cinfo); err != nil {
 		return nil, err
@@ -61,6 +71,10 @@ func newMetaBridgeClient(cluster string) (c *metadataClient, err error) {
 	for _, adminport := range b.adminports {
 		b.mdClient.WatchMetadata(adminport)
 	}
+	// indexers' http port serve capacity stats for index placement
+	for adminport, httpport := range getIndexerHttpports(cinfo) {
+		b.mdClient.SetIndexerHttpAddr(adminport, httpport)
+	}
 	b.Refresh()
 	return b, nil
 }
@@ -112,13 +126,9 @@ func (b *metadataClient) CreateIndex(
 	planJSON []byte) (common.IndexDefnId, error) {
 
 	createPlan := make(map[string]interface{})
-	// plan may not be provided, pick a random indexer node
-	time.Sleep(1 * time.Millisecond) // sleep to make a random seed.
-	seed := time.Now().UTC().Second()
-	rnd := rand.New(rand.NewSource(int64(seed))).Intn(100000)
-	n := (rnd / (10000 / len(b.adminports))) % len(b.adminports)
+	// plan may not be provided, in which case metadata provider places
+	// the index as per its placement policy.
 	plan := map[string]interface{}{ // with default values
-		"nodes":       []interface{}{b.adminports[n]},
 		"defer_build": false,
 	}
 
@@ -132,6 +142,46 @@ func (b *metadataClient) CreateIndex(
 		}
 	}
 
+	// detect equivalent index and either reject or place it as replica.
+	b.Refresh()
+	defn := &common.IndexDefn{
+		Using:           common.IndexType(using),
+		Bucket:          bucket,
+		IsPrimary:       isPrimary,
+		SecExprs:        secExprs,
+		ExprType:        common.ExprType(exprType),
+		PartitionScheme: common.SINGLE,
+		PartitionKey:    partnExpr,
+		WhereExpr:       whereExpr,
+	}
+	// fields stored along with index entries and collation of string
+	// keys are part of the definition, so compare them as well.
+	include, err := planInclude(plan)
+	if err != nil {
+		return common.IndexDefnId(0), err
+	}
+	defn.Include = include
+	defn.Collation, _ = plan["collation"].(string)
+	if hosts := b.equivalentHosts(defn); len(hosts) > 0 {
+		replica, _ := plan["replica"].(bool)
+		if !replica {
+			return common.IndexDefnId(0), ErrorEquivalentIndex
+		}
+		if _, ok := createPlan["nodes"]; ok { // explicit deployment
+			if ns, ok := plan["nodes"].([]interface{}); ok && len(ns) == 1 {
+				if node, ok := ns[0].(string); ok && hosts[node] {
+					return common.IndexDefnId(0), ErrorEquivalentIndex
+				}
+			}
+		} else {
+			node, ok := b.pickReplicaNode(hosts)
+			if !ok {
+				return common.IndexDefnId(0), ErrorNoReplicaNode
+			}
+			plan["nodes"] = []interface{}{node}
+		}
+	}
+
 	defnID, err := b.mdClient.CreateIndexWithPlan(
 		indexName, bucket, using, exprType, partnExpr, whereExpr,
 		secExprs, isPrimary, plan)
@@ -181,6 +231,13 @@ func (b *metadataClient) DropIndex(defnID common.IndexDefnId) error {
 	return b.mdClient.DropIndex(defnID, adminport)
 }
 
+// DropIndexesByBucket implements BridgeAccessor{} interface.
+func (b *metadataClient) DropIndexesByBucket(bucket string) error {
+	err := b.mdClient.DropIndexesByBucket(bucket)
+	b.Refresh()
+	return err
+}
+
 // GetScanports implements BridgeAccessor{} interface.
 func (b *metadataClient) GetScanports() (queryports []string) {
 	b.rw.Lock()
@@ -256,12 +313,44 @@ func (b *metadataClient) IndexState(defnID uint64) (common.IndexState, error) {
 	return common.INDEX_STATE_ERROR, ErrorIndexNotFound
 }
 
+// WaitForIndexOnline implements BridgeAccessor{} interface.
+func (b *metadataClient) WaitForIndexOnline(
+	defnIDs []common.IndexDefnId, timeout time.Duration,
+	callback mclient.IndexProgressCallback) error {
+
+	err := b.mdClient.WaitForIndexOnline(defnIDs, timeout, callback)
+	b.Refresh() // refresh so that scans see the indexes online.
+	return err
+}
+
+// IndexCollation implements BridgeAccessor{} interface.
+func (b *metadataClient) IndexCollation(defnID uint64) string {
+	b.rw.RLock()
+	defer b.rw.RUnlock()
+
+	for _, indexes := range b.topology {
+		for _, index := range indexes {
+			if index.Definition.DefnId == common.IndexDefnId(defnID) {
+				return defnCollation(index.Definition)
+			}
+		}
+	}
+	return common.CollationBinary
+}
+
 // close this bridge, to be called when a new indexer is added or
 // an active indexer leaves the cluster or during system shutdown.
 func (b *metadataClient) Close() {
 	b.mdClient.Close()
 }
 
+func defnCollation(defn *common.IndexDefn) string {
+	if defn.Collation == "" {
+		return common.CollationBinary
+	}
+	return defn.Collation
+}
+
 //--------------------------------
 // local functions to map replicas
 //--------------------------------
@@ -293,22 +382,94 @@ func (b *metadataClient) computeReplicas() map[common.IndexDefnId][]common.Index
 func (b *metadataClient) equivalentIndex(
 	index1, index2 *mclient.IndexMetadata) bool {
 
-	d1, d2 := index1.Definition, index2.Definition
-	if d1.Using != d1.Using ||
+	return equivalentDefn(index1.Definition, index2.Definition)
+}
+
+// equivalentHosts return the set of nodes, by adminport, hosting an
+// index equivalent to `defn`.
+func (b *metadataClient) equivalentHosts(
+	defn *common.IndexDefn) map[string]bool {
+
+	b.rw.RLock()
+	defer b.rw.RUnlock()
+
+	hosts := make(map[string]bool)
+	for adminport, indexes := range b.topology {
+		for _, index := range indexes {
+			if equivalentDefn(defn, index.Definition) {
+				hosts[adminport] = true
+			}
+		}
+	}
+	return hosts
+}
+
+// pickReplicaNode picks an indexer node that is not one of `hosts`.
+func (b *metadataClient) pickReplicaNode(
+	hosts map[string]bool) (adminport string, ok bool) {
+
+	b.rw.RLock()
+	defer b.rw.RUnlock()
+
+	for _, adminport := range b.adminports {
+		if !hosts[adminport] {
+			return adminport, true
+		}
+	}
+	return "", false
+}
+
+// planInclude return the list of fields to be stored along with
+// index entries, as specified by `include` in create plan.
+func planInclude(plan map[string]interface{}) ([]string, error) {
+	fields, ok := plan["include"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	include := make([]string, 0, len(fields))
+	for _, field := range fields {
+		expr, ok := field.(string)
+		if !ok {
+			return nil, ErrorInvalidInclude
+		}
+		include = append(include, expr)
+	}
+	return include, nil
+}
+
+// compare whether two index definitions are equivalent, that is, same
+// bucket, keys, where clause, included fields and collation.
+func equivalentDefn(d1, d2 *common.IndexDefn) bool {
+	if d1 == nil || d2 == nil {
+		return false
+	}
+	if d1.Using != d2.Using ||
 		d1.Bucket != d2.Bucket ||
 		d1.IsPrimary != d2.IsPrimary ||
 		d1.ExprType != d2.ExprType ||
 		d1.PartitionScheme != d2.PartitionScheme ||
-		d1.PartitionKey != d2.PartitionKey {
+		d1.PartitionKey != d2.PartitionKey ||
+		d1.WhereExpr != d2.WhereExpr ||
+		defnCollation(d1) != defnCollation(d2) {
 
 		return false
 	}
 
-	for _, s1 := range d1.SecExprs {
-		for _, s2 := range d2.SecExprs {
-			if s1 != s2 {
-				return false
-			}
+	if len(d1.SecExprs) != len(d2.SecExprs) {
+		return false
+	}
+	for i, s1 := range d1.SecExprs {
+		if s1 != d2.SecExprs[i] {
+			return false
+		}
+	}
+
+	if len(d1.Include) != len(d2.Include) {
+		return false
+	}
+	for i, s1 := range d1.Include {
+		if s1 != d2.Include[i] {
+			return false
 		}
 	}
 	return true
@@ -423,6 +584,24 @@ func getIndexerAdminports(
 	return iAdminports, nil
 }
 
+// return http ports for all known indexers, nodes not publishing their
+// http port are skipped.
+func getIndexerHttpports(cinfo *common.ClusterInfoCache) map[string]string {
+	iHttpports := make(map[string]string)
+	for _, node := range cinfo.GetNodesByServiceType("indexAdmin") {
+		adminport, err := cinfo.GetServiceAddress(node, "indexAdmin")
+		if err != nil {
+			continue
+		}
+		httpport, err := cinfo.GetServiceAddress(node, "indexHttp")
+		if err != nil {
+			continue
+		}
+		iHttpports[adminport] = httpport
+	}
+	return iHttpports
+}
+
 // return queryports for all known indexers.
 func getIndexerQueryports(
 	cinfo *common.ClusterInfoCache) (map[string]string, error) {
@@ -0,0 +1,62 @@
+package client
+
+import "reflect"
+import "testing"
+
+import common "github.com/couchbase/indexing/secondary/common"
+import mclient "github.com/couchbase/indexing/secondary/manager/client"
+
+func TestEquivalentIndex(t *testing.T) {
+	defn := &common.IndexDefn{
+		Using:    common.IndexType("forestdb"),
+		Bucket:   "default",
+		SecExprs: []string{"city"},
+		Include:  []string{"name"},
+	}
+	b := &metadataClient{
+		topology: map[string][]*mclient.IndexMetadata{
+			"node1:9100": {{Definition: defn}},
+		},
+	}
+
+	other := *defn
+	if hosts := b.equivalentHosts(&other); !hosts["node1:9100"] {
+		t.Fatalf("expected equivalent index on node1:9100, got %v", hosts)
+	}
+	other.Collation = common.CollationBinary
+	if hosts := b.equivalentHosts(&other); !hosts["node1:9100"] {
+		t.Fatalf("expected binary collation by default, got %v", hosts)
+	}
+
+	// included fields and collation are part of the definition.
+	other.Include = []string{"zip"}
+	if hosts := b.equivalentHosts(&other); len(hosts) != 0 {
+		t.Fatalf("expected no equivalent index, got %v", hosts)
+	}
+	other.Include = nil
+	if hosts := b.equivalentHosts(&other); len(hosts) != 0 {
+		t.Fatalf("expected no equivalent index, got %v", hosts)
+	}
+	other.Include = defn.Include
+	other.Collation = common.CollationCaseInsensitive
+	if hosts := b.equivalentHosts(&other); len(hosts) != 0 {
+		t.Fatalf("expected no equivalent index, got %v", hosts)
+	}
+}
+
+func TestPlanInclude(t *testing.T) {
+	include, err := planInclude(map[string]interface{}{})
+	if err != nil || include != nil {
+		t.Fatalf("expected no include, got %v %v", include, err)
+	}
+	plan := map[string]interface{}{"include": []interface{}{"name", "zip"}}
+	if include, err = planInclude(plan); err != nil {
+		t.Fatal(err)
+	} else if ref := []string{"name", "zip"}; !reflect.DeepEqual(include, ref) {
+		t.Fatalf("expected %v, got %v", ref, include)
+	}
+	plan = map[string]interface{}{"include": []interface{}{"name", 10.0}}
+	if _, err = planInclude(plan); err != ErrorInvalidInclude {
+		t.Fatalf("expected %v, got %v", ErrorInvalidInclude, err)
+	}
+}
@@ -0,0 +1,30 @@
+package client
+
+import "encoding/json"
+
+// IndexPlan describes how an index shall be created and deployed, it is
+// the typed form of `with` argument to CreateIndex().
+type IndexPlan struct {
+	// DeferBuild creates the index without building it, use
+	// BuildIndexes() to build it.
+	DeferBuild bool `json:"defer_build,omitempty"`
+	// Nodes to host the index, only one node is allowed. If empty the
+	// index is placed as per placement policy.
+	Nodes []string `json:"nodes,omitempty"`
+	// Replica creates the index even if an equivalent index exists, on a
+	// node that does not host an equivalent.
+	Replica bool `json:"replica,omitempty"`
+	// Include lists document fields to be stored along with each entry.
+	Include []string `json:"include,omitempty"`
+	// Collation of string keys, "binary" by default, "caseinsensitive"
+	// or "unicode".
+	Collation string `json:"collation,omitempty"`
+}
+
+// with marshals the plan for CreateIndex(), nil for a nil plan.
+func (plan *IndexPlan) with() ([]byte, error) {
+	if plan == nil {
+		return nil, nil
+	}
+	return json.Marshal(plan)
+}
@@ -0,0 +1,35 @@
+package client
+
+import "encoding/json"
+import "reflect"
+import "testing"
+
+func TestIndexPlan(t *testing.T) {
+	var plan *IndexPlan
+	if with, err := plan.with(); err != nil || with != nil {
+		t.Fatalf("expected no plan, got %s %v", with, err)
+	}
+
+	plan = &IndexPlan{DeferBuild: true, Nodes: []string{"node1:9000"}}
+	with, err := plan.with()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// decoded as metadata client does, refer CreateIndex().
+	decoded := make(map[string]interface{})
+	if err := json.Unmarshal(with, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	ref := map[string]interface{}{
+		"defer_build": true,
+		"nodes":       []interface{}{"node1:9000"},
+	}
+	if !reflect.DeepEqual(decoded, ref) {
+		t.Fatalf("expected %v, got %v", ref, decoded)
+	}
+
+	with, _ = (&IndexPlan{Replica: true}).with()
+	if string(with) != `{"replica":true}` {
+		t.Fatalf("unexpected plan %s", with)
+	}
+}
@@ -0,0 +1,94 @@
+package client
+
+import "io"
+import "strings"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/common"
+
+// transportErrors are failures of a connection to queryport, returned as
+// is or wrapped by net package, after which the request can be re-issued
+// on a new connection.
+var transportErrors = []string{
+	io.EOF.Error(),
+	"connection reset by peer",
+	"broken pipe",
+}
+
+// topologyErrors are failures of a scan, returned by indexer, when the
+// index is not hosted by it, after which the scan can be re-issued to
+// the indexer hosting the index as per refreshed metadata.
+var topologyErrors = []string{
+	"Index not found",
+	"Not my index",
+}
+
+// retryPolicy for requests that fail on transient errors, like connection
+// resets, indexer being busy or no snapshot being ready for the scan.
+type retryPolicy struct {
+	maxRetries  int           // retries per request, 0 disables retry
+	interval    time.Duration // backoff before the first retry
+	maxInterval time.Duration // cap on backoff, doubled on every retry
+	// scans re-issued after refreshing metadata, 0 disables refresh
+	maxRefreshes int
+}
+
+func newRetryPolicy(config common.Config) *retryPolicy {
+	return &retryPolicy{
+		maxRetries:   config["retry.maxRetries"].Int(),
+		interval:     time.Duration(config["retry.interval"].Int()) * time.Millisecond,
+		maxInterval:  time.Duration(config["retry.maxInterval"].Int()) * time.Millisecond,
+		maxRefreshes: config["retry.maxRefreshes"].Int(),
+	}
+}
+
+// backoff returns the duration to wait before retry number `attempt`,
+// starting from 0.
+func (p *retryPolicy) backoff(attempt int) time.Duration {
+	d := p.interval
+	for i := 0; i < attempt && d < p.maxInterval; i++ {
+		d *= 2
+	}
+	if d > p.maxInterval {
+		d = p.maxInterval
+	}
+	return d
+}
+
+// isTransientError returns whether a request that failed with `err` can
+// be re-issued as is, either for being classified retryable or for being
+// a transport failure.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	} else if common.IsRetryable(err) {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range transportErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTopologyError returns whether a scan that failed with `err` can be
+// re-issued after refreshing index metadata, for the index having moved
+// to another indexer or its local metadata being stale.
+func isTopologyError(err error) bool {
+	if err == nil {
+		return false
+	} else if common.IsError(err, ErrorNoHost) ||
+		common.IsError(err, ErrorIndexNotFound) ||
+		common.IsError(err, ErrorInstanceNotFound) {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range topologyErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
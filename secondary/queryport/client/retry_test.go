@@ -0,0 +1,64 @@
+package client
+
+import "errors"
+import "io"
+import "testing"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/common"
+
+func TestRetryBackoff(t *testing.T) {
+	p := &retryPolicy{
+		maxRetries:  5,
+		interval:    100 * time.Millisecond,
+		maxInterval: 500 * time.Millisecond,
+	}
+	refs := []time.Duration{100, 200, 400, 500, 500}
+	for attempt, ref := range refs {
+		ref *= time.Millisecond
+		if d := p.backoff(attempt); d != ref {
+			t.Errorf("attempt %v expected %v, got %v", attempt, ref, d)
+		}
+	}
+}
+
+func TestTransientError(t *testing.T) {
+	transient := []error{
+		io.EOF,
+		common.ErrorSnapshotNotReady,
+		common.ErrorServerBusy,
+		errors.New("read tcp 127.0.0.1:9101: connection reset by peer"),
+	}
+	for _, err := range transient {
+		if !isTransientError(err) {
+			t.Errorf("expected %v to be transient", err)
+		}
+	}
+	permanent := []error{nil, ErrorScanKilled, errors.New("Index not found")}
+	for _, err := range permanent {
+		if isTransientError(err) {
+			t.Errorf("expected %v not to be transient", err)
+		}
+	}
+}
+
+func TestTopologyError(t *testing.T) {
+	topology := []error{
+		ErrorNoHost,
+		ErrorIndexNotFound,
+		common.WrapError(ErrorInstanceNotFound, "defnID", 10),
+		errors.New("Index not found"),
+		errors.New("Not my index"),
+	}
+	for _, err := range topology {
+		if !isTopologyError(err) {
+			t.Errorf("expected %v to be a topology error", err)
+		}
+	}
+	others := []error{nil, io.EOF, ErrorScanKilled, common.ErrorServerBusy}
+	for _, err := range others {
+		if isTopologyError(err) {
+			t.Errorf("expected %v not to be a topology error", err)
+		}
+	}
+}
@@ -10,10 +10,9 @@
 
 package client
 
-import "errors"
 import "fmt"
 import "io"
-import "net"
+import "sync/atomic"
 import "time"
 import "encoding/json"
 
@@ -24,6 +23,10 @@ import "github.com/couchbaselabs/goprotobuf/proto"
 
 // gsiScanClient for scan operations.
 type gsiScanClient struct {
+	// retry statistics, 64-bit aligned for atomic access
+	retries   uint64 // requests re-issued on transient errors
+	exhausted uint64 // requests failed after exhausting retries
+
 	queryport string
 	pool      *connectionPool
 	// config params
@@ -34,7 +37,13 @@ type gsiScanClient struct {
 	poolOverflow       int
 	cpTimeout          time.Duration
 	cpAvailWaitTimeout time.Duration
+	transport          string
+	compression        string
+	username           string
+	password           string
 	logPrefix          string
+	retry              *retryPolicy
+	hooks              atomic.Value // hooksRef, refer Hooks
 }
 
 func newGsiScanClient(queryport string, config common.Config) *gsiScanClient {
@@ -48,11 +57,50 @@ func newGsiScanClient(queryport string, config common.Config) *gsiScanClient {
 		poolOverflow:       config["poolOverflow"].Int(),
 		cpTimeout:          time.Duration(config["connPoolTimeout"].Int()),
 		cpAvailWaitTimeout: t,
+		transport:          config["transport"].String(),
+		compression:        config["compression"].String(),
+		username:           config["username"].String(),
+		password:           config["password"].Secret(),
 		logPrefix:          fmt.Sprintf("[GsiScanClient:%q]", queryport),
+		retry:              newRetryPolicy(config),
 	}
 	c.pool = newConnectionPool(
 		queryport, c.poolSize, c.poolOverflow, c.maxPayload, c.cpTimeout,
 		c.cpAvailWaitTimeout)
+	switch c.compression {
+	case "gzip":
+		c.pool.acceptCompression = transport.CompressionGzip
+	case "none":
+	default:
+		msg := "%v unknown compression %q, responses shall not be compressed\n"
+		common.Warnf(msg, c.logPrefix, c.compression)
+	}
+	if c.transport == "grpc" {
+		c.pool.mkConn = c.pool.grpcMkConn
+	}
+	if tlsConfig, err := common.NewClientTLSConfig(config); err != nil {
+		msg := "%v invalid TLS configuration `%v`, connections shall fail\n"
+		common.Errorf(msg, c.logPrefix, err)
+		c.pool.mkConn = func(host string) (*connection, error) {
+			return nil, ErrorInvalidTLSConfig
+		}
+	} else {
+		c.pool.tlsConfig = tlsConfig
+	}
+	if c.username != "" {
+		mkConn := c.pool.mkConn
+		c.pool.mkConn = func(host string) (*connection, error) {
+			connectn, err := mkConn(host)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.authenticate(connectn); err != nil {
+				connectn.close()
+				return nil, err
+			}
+			return connectn, nil
+		}
+	}
 	common.Infof("%v started ...\n", c.logPrefix)
 	return c
 }
@@ -75,8 +123,7 @@ func (c *gsiScanClient) LookupStatistics(
 		return nil, err
 	}
 	statResp := resp.(*protobuf.StatisticsResponse)
-	if statResp.GetErr() != nil {
-		err = errors.New(statResp.GetErr().GetError())
+	if err = statResp.Error(); err != nil {
 		return nil, err
 	}
 	return statResp.GetStats(), nil
@@ -110,8 +157,7 @@ func (c *gsiScanClient) RangeStatistics(
 		return nil, err
 	}
 	statResp := resp.(*protobuf.StatisticsResponse)
-	if statResp.GetErr() != nil {
-		err = errors.New(statResp.GetErr().GetError())
+	if err = statResp.Error(); err != nil {
 		return nil, err
 	}
 	return statResp.GetStats(), nil
@@ -132,15 +178,6 @@ func (c *gsiScanClient) Lookup(
 		equals = append(equals, val)
 	}
 
-	connectn, err := c.pool.Get()
-	if err != nil {
-		return err
-	}
-	healthy := true
-	defer c.pool.Return(connectn, healthy)
-
-	conn, pkt := connectn.conn, connectn.pkt
-
 	req := &protobuf.ScanRequest{
 		DefnID:   proto.Uint64(defnID),
 		Span:     &protobuf.Span{Equals: equals},
@@ -148,24 +185,7 @@ func (c *gsiScanClient) Lookup(
 		PageSize: proto.Int64(1),
 		Limit:    proto.Int64(limit),
 	}
-	// ---> protobuf.ScanRequest
-	if err := c.sendRequest(conn, pkt, req); err != nil {
-		msg := "%v Scan() request transport failed `%v`\n"
-		common.Errorf(msg, c.logPrefix, err)
-		healthy = false
-		return err
-	}
-
-	cont := true
-	for cont {
-		// <--- protobuf.ResponseStream
-		cont, healthy, err = c.streamResponse(conn, pkt, callb)
-		if err != nil {
-			msg := "%v Scan() response failed `%v`\n"
-			common.Errorf(msg, c.logPrefix, err)
-		}
-	}
-	return nil
+	return c.doStreamingRequest("Scan", req, callb)
 }
 
 // Range scan index between low and high.
@@ -173,6 +193,48 @@ func (c *gsiScanClient) Range(
 	defnID uint64, low, high common.SecondaryKey, inclusion Inclusion,
 	distinct bool, limit int64, callb ResponseHandler) error {
 
+	return c.doRange(
+		defnID, low, high, inclusion, distinct, limit, false, nil, 0, callb)
+}
+
+// RangeWithCursor scan index between low and high, if the scan stops at
+// limit the last response carries a cursor to resume the scan.
+func (c *gsiScanClient) RangeWithCursor(
+	defnID uint64, low, high common.SecondaryKey, inclusion Inclusion,
+	distinct bool, limit int64, callb ResponseHandler) error {
+
+	return c.doRange(
+		defnID, low, high, inclusion, distinct, limit, true, nil, 0, callb)
+}
+
+// RangeWithFilter scan index between low and high, returning only entries
+// that satisfy all predicates in filter.
+func (c *gsiScanClient) RangeWithFilter(
+	defnID uint64, low, high common.SecondaryKey, inclusion Inclusion,
+	distinct bool, limit int64, filter []Predicate,
+	callb ResponseHandler) error {
+
+	return c.doRange(
+		defnID, low, high, inclusion, distinct, limit, false, filter, 0, callb)
+}
+
+// RangeAtSnapshot scan index between low and high, on the older snapshot
+// retained by indexer with handle `snapshot`.
+func (c *gsiScanClient) RangeAtSnapshot(
+	defnID, snapshot uint64, low, high common.SecondaryKey,
+	inclusion Inclusion, distinct bool, limit int64,
+	callb ResponseHandler) error {
+
+	return c.doRange(
+		defnID, low, high, inclusion, distinct, limit, false, nil, snapshot,
+		callb)
+}
+
+func (c *gsiScanClient) doRange(
+	defnID uint64, low, high common.SecondaryKey, inclusion Inclusion,
+	distinct bool, limit int64, withCursor bool, filter []Predicate,
+	snapshot uint64, callb ResponseHandler) error {
+
 	// serialize low and high values.
 	l, err := json.Marshal(low)
 	if err != nil {
@@ -182,15 +244,10 @@ func (c *gsiScanClient) Range(
 	if err != nil {
 		return err
 	}
-
-	connectn, err := c.pool.Get()
+	protoFilter, err := makeProtoFilter(filter)
 	if err != nil {
 		return err
 	}
-	healthy := true
-	defer c.pool.Return(connectn, healthy)
-
-	conn, pkt := connectn.conn, connectn.pkt
 
 	req := &protobuf.ScanRequest{
 		DefnID: proto.Uint64(defnID),
@@ -199,65 +256,135 @@ func (c *gsiScanClient) Range(
 				Low: l, High: h, Inclusion: proto.Uint32(uint32(inclusion)),
 			},
 		},
-		Distinct: proto.Bool(distinct),
-		PageSize: proto.Int64(1),
-		Limit:    proto.Int64(limit),
+		Distinct:   proto.Bool(distinct),
+		PageSize:   proto.Int64(1),
+		Limit:      proto.Int64(limit),
+		WithCursor: proto.Bool(withCursor),
+		Filter:     protoFilter,
 	}
-	// ---> protobuf.ScanRequest
-	if err := c.sendRequest(conn, pkt, req); err != nil {
-		msg := "%v Scan() request transport failed `%v`\n"
-		common.Errorf(msg, c.logPrefix, err)
-		healthy = false
+	if snapshot > 0 {
+		req.Snapshot = proto.Uint64(snapshot)
+	}
+	return c.doStreamingRequest("Scan", req, callb)
+}
+
+// GroupAggregate scan index between low and high, returning one row of
+// aggregates for each group of entries sharing the leading groupLength
+// components.
+func (c *gsiScanClient) GroupAggregate(
+	defnID uint64, low, high common.SecondaryKey, inclusion Inclusion,
+	groupLength int, aggregates []Aggregate, filter []Predicate,
+	limit int64, callb ResponseHandler) error {
+
+	// serialize low and high values.
+	l, err := json.Marshal(low)
+	if err != nil {
 		return err
 	}
+	h, err := json.Marshal(high)
+	if err != nil {
+		return err
+	}
+	protoFilter, err := makeProtoFilter(filter)
+	if err != nil {
+		return err
+	}
+	protoAggrs := make([]*protobuf.Aggregate, 0, len(aggregates))
+	for _, aggr := range aggregates {
+		protoAggrs = append(protoAggrs, &protobuf.Aggregate{
+			Function: proto.Uint32(uint32(aggr.Function)),
+			Position: proto.Uint32(uint32(aggr.Position)),
+		})
+	}
 
-	cont := true
-	for cont {
-		// <--- protobuf.ResponseStream
-		cont, healthy, err = c.streamResponse(conn, pkt, callb)
-		if err != nil {
-			msg := "%v Scan() response failed `%v`\n"
-			common.Errorf(msg, c.logPrefix, err)
-		}
+	req := &protobuf.GroupAggregateRequest{
+		DefnID: proto.Uint64(defnID),
+		Span: &protobuf.Span{
+			Range: &protobuf.Range{
+				Low: l, High: h, Inclusion: proto.Uint32(uint32(inclusion)),
+			},
+		},
+		GroupLength: proto.Uint32(uint32(groupLength)),
+		Aggregates:  protoAggrs,
+		Filter:      protoFilter,
+		PageSize:    proto.Int64(1),
+		Limit:       proto.Int64(limit),
 	}
-	return nil
+	return c.doStreamingRequest("GroupAggregate", req, callb)
 }
 
 // ScanAll for full table scan.
 func (c *gsiScanClient) ScanAll(
 	defnID uint64, limit int64, callb ResponseHandler) error {
 
-	connectn, err := c.pool.Get()
-	if err != nil {
-		return err
-	}
-	healthy := true
-	defer c.pool.Return(connectn, healthy)
+	return c.doScanAll(defnID, limit, false, false, callb)
+}
+
+// ScanAllOrdered for full table scan, returning entries in index order
+// across all slices of the index.
+func (c *gsiScanClient) ScanAllOrdered(
+	defnID uint64, limit int64, callb ResponseHandler) error {
+
+	return c.doScanAll(defnID, limit, false, true, callb)
+}
+
+// ScanAllWithCursor for full table scan, if the scan stops at limit the
+// last response carries a cursor to resume the scan.
+func (c *gsiScanClient) ScanAllWithCursor(
+	defnID uint64, limit int64, callb ResponseHandler) error {
+
+	return c.doScanAll(defnID, limit, true, false, callb)
+}
 
-	conn, pkt := connectn.conn, connectn.pkt
+func (c *gsiScanClient) doScanAll(
+	defnID uint64, limit int64, withCursor, ordered bool,
+	callb ResponseHandler) error {
 
 	req := &protobuf.ScanAllRequest{
-		DefnID:   proto.Uint64(defnID),
-		PageSize: proto.Int64(1),
-		Limit:    proto.Int64(limit),
+		DefnID:     proto.Uint64(defnID),
+		PageSize:   proto.Int64(1),
+		Limit:      proto.Int64(limit),
+		WithCursor: proto.Bool(withCursor),
 	}
-	if err := c.sendRequest(conn, pkt, req); err != nil {
-		common.Errorf(
-			"%v ScanAll() request transport failed `%v`\n",
-			c.logPrefix, err)
-		healthy = false
-		return err
+	if ordered {
+		req.Ordered = proto.Bool(true)
 	}
+	return c.doStreamingRequest("ScanAll", req, callb)
+}
 
-	cont := true
-	for cont {
-		cont, healthy, err = c.streamResponse(conn, pkt, callb)
+// ScanCursor resume a paginated scan from its cursor, returning upto
+// limit more entries.
+func (c *gsiScanClient) ScanCursor(
+	cursor []byte, limit int64, callb ResponseHandler) error {
+
+	req := &protobuf.ScanCursorRequest{
+		Cursor:   cursor,
+		Limit:    proto.Int64(limit),
+		PageSize: proto.Int64(1),
+	}
+	return c.doStreamingRequest("ScanCursor", req, callb)
+}
+
+// LookupKeys fetch index entries whose secondary key equals one of keys.
+func (c *gsiScanClient) LookupKeys(
+	defnID uint64, keys []common.SecondaryKey, callb ResponseHandler) error {
+
+	// serialize lookup keys.
+	lookupKeys := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		val, err := json.Marshal(key)
 		if err != nil {
-			msg := "%v ScanAll() response failed `%v`\n"
-			common.Errorf(msg, c.logPrefix, err)
+			return err
 		}
+		lookupKeys = append(lookupKeys, val)
 	}
-	return nil
+
+	req := &protobuf.LookupRequest{
+		DefnID:   proto.Uint64(defnID),
+		Keys:     lookupKeys,
+		PageSize: proto.Int64(1),
+	}
+	return c.doStreamingRequest("LookupKeys", req, callb)
 }
 
 // CountLookup to count number entries for given set of keys.
@@ -283,8 +410,7 @@ func (c *gsiScanClient) CountLookup(
 		return 0, err
 	}
 	countResp := resp.(*protobuf.CountResponse)
-	if countResp.GetErr() != nil {
-		err = errors.New(countResp.GetErr().GetError())
+	if err = countResp.Error(); err != nil {
 		return 0, err
 	}
 	return countResp.GetCount(), nil
@@ -318,8 +444,7 @@ func (c *gsiScanClient) CountRange(
 		return 0, err
 	}
 	countResp := resp.(*protobuf.CountResponse)
-	if countResp.GetErr() != nil {
-		err = errors.New(countResp.GetErr().GetError())
+	if err = countResp.Error(); err != nil {
 		return 0, err
 	}
 	return countResp.GetCount(), nil
@@ -329,18 +454,142 @@ func (c *gsiScanClient) Close() error {
 	return c.pool.Close()
 }
 
-func (c *gsiScanClient) doRequestResponse(req interface{}) (interface{}, error) {
+// RetryStatistics returns the number of requests re-issued on transient
+// errors and the number of requests that failed after exhausting their
+// retries.
+func (c *gsiScanClient) RetryStatistics() (retries, exhausted uint64) {
+	return atomic.LoadUint64(&c.retries), atomic.LoadUint64(&c.exhausted)
+}
+
+// doStreamingRequest sends `req` and streams its responses to callb. On
+// transient errors the request is re-issued as is, against the same
+// snapshot, cursor and consistency, until retries are exhausted. Retry is
+// attempted only until the first response is passed to callb, so that
+// callb never sees an entry twice.
+func (c *gsiScanClient) doStreamingRequest(
+	name string, req interface{}, callb ResponseHandler) (err error) {
+
+	if trace := startTrace(c.getHooks(), c.queryport, req); trace != nil {
+		defer func() { trace.complete(err) }()
+		handler := callb
+		callb = func(resp ResponseReader) bool {
+			trace.batch(resp)
+			return handler(resp)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		canRetry := attempt < c.retry.maxRetries
+		transient, err := c.streamRequest(name, req, canRetry, callb)
+		if !transient {
+			return err
+		} else if !canRetry {
+			if attempt > 0 {
+				atomic.AddUint64(&c.exhausted, 1)
+			}
+			return err
+		}
+		c.backoff(name+"()", attempt)
+	}
+}
+
+// streamRequest returns transient as true if the request failed on a
+// transient error before any response was passed to callb, the error is
+// passed to callb only if the request cannot be retried.
+func (c *gsiScanClient) streamRequest(
+	name string, req interface{}, canRetry bool,
+	callb ResponseHandler) (transient bool, err error) {
+
 	connectn, err := c.pool.Get()
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 	healthy := true
-	defer c.pool.Return(connectn, healthy)
+	defer func() { c.pool.Return(connectn, healthy) }()
 
-	conn, pkt := connectn.conn, connectn.pkt
+	// ---> protobuf.*Request
+	if err := c.sendRequest(connectn, req); err != nil {
+		msg := "%v %v() request transport failed `%v`\n"
+		common.Errorf(msg, c.logPrefix, name, err)
+		healthy = false
+		return isTransientError(err), err
+	}
+
+	delivered := false
+	handler := func(resp ResponseReader) bool {
+		if !delivered && isTransientError(resp.Error()) {
+			transient = true
+			if canRetry {
+				return false
+			}
+		}
+		delivered = true
+		return callb(resp)
+	}
+
+	cont := true
+	for cont {
+		// <--- protobuf.ResponseStream
+		cont, healthy, err = c.streamResponse(connectn, handler)
+		if err != nil {
+			msg := "%v %v() response failed `%v`\n"
+			common.Errorf(msg, c.logPrefix, name, err)
+		}
+	}
+	return transient, nil
+}
+
+// doRequestResponse sends `req` and returns its response, re-issuing the
+// request on transient errors until retries are exhausted.
+func (c *gsiScanClient) doRequestResponse(
+	req interface{}) (resp interface{}, err error) {
+
+	if trace := startTrace(c.getHooks(), c.queryport, req); trace != nil {
+		defer func() {
+			if err == nil {
+				trace.batch(resp)
+			}
+			trace.complete(err)
+		}()
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.requestResponse(req)
+		reason := err
+		if r, ok := resp.(interface{ Error() error }); ok && err == nil {
+			reason = r.Error()
+		}
+		if !isTransientError(reason) {
+			return resp, err
+		} else if attempt >= c.retry.maxRetries {
+			if attempt > 0 {
+				atomic.AddUint64(&c.exhausted, 1)
+			}
+			return resp, err
+		}
+		c.backoff(fmt.Sprintf("%T", req), attempt)
+	}
+}
+
+// backoff before retry number `attempt` of request `name`.
+func (c *gsiScanClient) backoff(name string, attempt int) {
+	atomic.AddUint64(&c.retries, 1)
+	d := c.retry.backoff(attempt)
+	msg := "%v %v retry %v after %v\n"
+	common.Warnf(msg, c.logPrefix, name, attempt+1, d)
+	time.Sleep(d)
+}
+
+func (c *gsiScanClient) requestResponse(req interface{}) (interface{}, error) {
+	connectn, err := c.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	healthy := true
+	defer func() { c.pool.Return(connectn, healthy) }()
 
 	// ---> protobuf.*Request
-	if err := c.sendRequest(conn, pkt, req); err != nil {
+	if err := c.sendRequest(connectn, req); err != nil {
 		msg := "%v %T request transport failed `%v`\n"
 		common.Errorf(msg, c.logPrefix, req, err)
 		healthy = false
@@ -348,9 +597,8 @@ func (c *gsiScanClient) doRequestResponse(req interface{}) (interface{}, error)
 	}
 
 	timeoutMs := c.readDeadline * time.Millisecond
-	conn.SetReadDeadline(time.Now().Add(timeoutMs))
 	// <--- protobuf.*Response
-	resp, err := pkt.Receive(conn)
+	resp, err := connectn.receive(timeoutMs)
 	if err != nil {
 		msg := "%v %T response transport failed `%v`\n"
 		common.Errorf(msg, c.logPrefix, req, err)
@@ -358,38 +606,76 @@ func (c *gsiScanClient) doRequestResponse(req interface{}) (interface{}, error)
 		return nil, err
 	}
 
-	conn.SetReadDeadline(time.Now().Add(timeoutMs))
 	// <--- protobuf.StreamEndResponse (skipped) TODO: knock this off.
-	endResp, err := pkt.Receive(conn)
+	endResp, err := connectn.receive(timeoutMs)
 	if _, ok := endResp.(*protobuf.StreamEndResponse); !ok {
 		return nil, ErrorProtocol
 	}
 	return resp, nil
 }
 
+// authenticate a new connection, credentials apply to every request
+// made on the connection. Credentials are sent only over TLS.
+func (c *gsiScanClient) authenticate(connectn *connection) error {
+	if !connectn.secure() {
+		msg := "%v connection not over TLS, credentials of %q not sent\n"
+		common.Errorf(msg, c.logPrefix, c.username)
+		return common.ErrorInsecureAuth
+	}
+	req := &protobuf.AuthRequest{
+		User:     proto.String(c.username),
+		Password: proto.String(c.password),
+	}
+	// ---> protobuf.AuthRequest
+	if err := c.sendRequest(connectn, req); err != nil {
+		return err
+	}
+
+	timeoutMs := c.readDeadline * time.Millisecond
+	// <--- protobuf.AuthResponse
+	resp, err := connectn.receive(timeoutMs)
+	if err != nil {
+		return err
+	}
+	authResp, ok := resp.(*protobuf.AuthResponse)
+	if !ok {
+		return ErrorProtocol
+	}
+	// <--- protobuf.StreamEndResponse
+	endResp, err := connectn.receive(timeoutMs)
+	if err != nil {
+		return err
+	} else if _, ok := endResp.(*protobuf.StreamEndResponse); !ok {
+		return ErrorProtocol
+	}
+	if err := authResp.Error(); err != nil {
+		msg := "%v authentication of %q failed `%v`\n"
+		common.Errorf(msg, c.logPrefix, c.username, err)
+		return err
+	}
+	return nil
+}
+
 func (c *gsiScanClient) sendRequest(
-	conn net.Conn, pkt *transport.TransportPacket, req interface{}) (err error) {
+	connectn *connection, req interface{}) (err error) {
 
 	timeoutMs := c.writeDeadline * time.Millisecond
-	conn.SetWriteDeadline(time.Now().Add(timeoutMs))
-	return pkt.Send(conn, req)
+	return connectn.send(req, timeoutMs)
 }
 
 func (c *gsiScanClient) streamResponse(
-	conn net.Conn,
-	pkt *transport.TransportPacket,
+	connectn *connection,
 	callb ResponseHandler) (cont bool, healthy bool, err error) {
 
 	var resp interface{}
 	var endResp *protobuf.StreamEndResponse
 	var finish bool
 
-	laddr := conn.LocalAddr()
+	laddr := connectn.localAddr()
 	timeoutMs := c.readDeadline * time.Millisecond
-	conn.SetReadDeadline(time.Now().Add(timeoutMs))
-	if resp, err = pkt.Receive(conn); err != nil {
+	if resp, err = connectn.receive(timeoutMs); err != nil {
 		resp := &protobuf.ResponseStream{
-			Err: &protobuf.Error{Error: proto.String(err.Error())},
+			Err: protobuf.NewError(err),
 		}
 		callb(resp) // callback with error
 		cont, healthy = false, false
@@ -404,25 +690,33 @@ func (c *gsiScanClient) streamResponse(
 		callb(endResp) // callback most likely return true
 		cont, healthy = false, true
 
+	} else if streamResp := resp.(*protobuf.ResponseStream); !streamResp.VerifyChecksum() {
+		// corrupted on the wire or in buffers, don't reuse the connection.
+		err = ErrorChecksumMismatch
+		msg := "%v connection %q response checksum mismatch\n"
+		common.Errorf(msg, c.logPrefix, laddr)
+		resp := &protobuf.ResponseStream{
+			Err: protobuf.NewError(err),
+		}
+		callb(resp) // callback with error
+		cont, healthy = false, false
+
 	} else {
-		streamResp := resp.(*protobuf.ResponseStream)
 		cont = callb(streamResp)
 		healthy = true
 	}
 
 	if cont == false && healthy == true && finish == false {
-		err = c.closeStream(conn, pkt)
+		err = c.closeStream(connectn)
 	}
 	return
 }
 
-func (c *gsiScanClient) closeStream(
-	conn net.Conn, pkt *transport.TransportPacket) (err error) {
-
+func (c *gsiScanClient) closeStream(connectn *connection) (err error) {
 	var resp interface{}
-	laddr := conn.LocalAddr()
+	laddr := connectn.localAddr()
 	// request server to end the stream.
-	err = c.sendRequest(conn, pkt, &protobuf.EndStreamRequest{})
+	err = c.sendRequest(connectn, &protobuf.EndStreamRequest{})
 	if err != nil {
 		msg := "%v closeStream() request transport failed `%v`\n"
 		common.Errorf(msg, c.logPrefix, err)
@@ -434,8 +728,7 @@ func (c *gsiScanClient) closeStream(
 	timeoutMs := c.readDeadline * time.Millisecond
 	// flush the connection until stream has ended.
 	for true {
-		conn.SetReadDeadline(time.Now().Add(timeoutMs))
-		resp, err = pkt.Receive(conn)
+		resp, err = connectn.receive(timeoutMs)
 		if err == io.EOF {
 			common.Errorf("%v connection %q closed \n", c.logPrefix, laddr)
 			return
@@ -451,3 +744,24 @@ func (c *gsiScanClient) closeStream(
 	}
 	return
 }
+
+// makeProtoFilter serializes predicates for ScanRequest, nil if there is
+// nothing to filter.
+func makeProtoFilter(filter []Predicate) (*protobuf.Filter, error) {
+	if len(filter) == 0 {
+		return nil, nil
+	}
+	preds := make([]*protobuf.Predicate, 0, len(filter))
+	for _, p := range filter {
+		value, err := json.Marshal(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, &protobuf.Predicate{
+			Position: proto.Uint32(uint32(p.Position)),
+			Op:       proto.Uint32(uint32(p.Op)),
+			Value:    value,
+		})
+	}
+	return &protobuf.Filter{Predicates: preds}, nil
+}
@@ -0,0 +1,257 @@
+//go:build grpc
+// +build grpc
+
+package queryport
+
+import "fmt"
+import "io"
+import "net"
+import "runtime/debug"
+import "sync"
+import "sync/atomic"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "google.golang.org/grpc"
+import "google.golang.org/grpc/credentials"
+import "google.golang.org/grpc/peer"
+
+// GrpcServer handles queryport streams on gRPC transport, each stream
+// is served like a connection on native transport, using the same
+// Handlers.
+type GrpcServer struct {
+	laddr    string    // address to listen
+	handlers *Handlers // application handlers of incoming requests.
+	// local fields
+	mu     sync.Mutex
+	lis    net.Listener
+	srv    *grpc.Server
+	killch chan bool
+	// config params
+	maxPayload     int
+	streamChanSize int
+	logPrefix      string
+
+	nConnections int64 // active streams
+}
+
+// NewGrpcServer creates a new queryport daemon on gRPC transport.
+func NewGrpcServer(
+	laddr string, handlers *Handlers,
+	config c.Config) (s *GrpcServer, err error) {
+
+	s = &GrpcServer{
+		laddr:          laddr,
+		handlers:       handlers,
+		killch:         make(chan bool),
+		maxPayload:     config["maxPayload"].Int(),
+		streamChanSize: config["streamChanSize"].Int(),
+		logPrefix:      fmt.Sprintf("[Queryport-grpc %q]", laddr),
+	}
+	tlsConfig, err := c.NewServerTLSConfig(config)
+	if err != nil {
+		c.Errorf("%v invalid TLS configuration %v !!\n", s.logPrefix, err)
+		return nil, err
+	}
+	if s.lis, err = net.Listen("tcp", laddr); err != nil {
+		c.Errorf("%v failed starting %v !!\n", s.logPrefix, err)
+		return nil, err
+	}
+	// codec is picked by content-subtype of the stream, protobuf.GrpcCodecName.
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(s.maxPayload),
+		grpc.MaxSendMsgSize(s.maxPayload),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s.srv = grpc.NewServer(opts...)
+	protobuf.RegisterQueryportServer(s.srv, s)
+
+	go s.serve()
+	c.Infof("%v started ...\n", s.logPrefix)
+	return s, nil
+}
+
+func (s *GrpcServer) Statistics() ServerStats {
+	return ServerStats{
+		Connections: atomic.LoadInt64(&s.nConnections),
+	}
+}
+
+// Close queryport daemon.
+func (s *GrpcServer) Close() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Errorf("%v Close() crashed: %v\n", s.logPrefix, r)
+			err = fmt.Errorf("%v", r)
+			c.StackTrace(string(debug.Stack()))
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv != nil {
+		close(s.killch)
+		s.srv.Stop() // closes listener and all streams
+		s.srv = nil
+		c.Infof("%v ... stopped\n", s.logPrefix)
+	}
+	return
+}
+
+// go-routine to serve gRPC streams, if this routine goes down -
+// server is shutdown.
+func (s *GrpcServer) serve() {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Errorf("%v serve() crashed: %v\n", s.logPrefix, r)
+			c.StackTrace(string(debug.Stack()))
+		}
+		go s.Close()
+	}()
+
+	if err := s.srv.Serve(s.lis); err != nil {
+		c.Errorf("%v serve() exited %v\n", s.logPrefix, err)
+	}
+}
+
+// Stream implements protobuf.QueryportServer{} interface. Requests on the
+// stream are handled one after the other, like on a native connection.
+func (s *GrpcServer) Stream(stream protobuf.Queryport_StreamServer) error {
+	atomic.AddInt64(&s.nConnections, 1)
+	defer func() {
+		atomic.AddInt64(&s.nConnections, -1)
+	}()
+
+	raddr, secure := "unknown", false
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		raddr = p.Addr.String()
+		_, secure = p.AuthInfo.(credentials.TLSInfo)
+	}
+	defer func() {
+		c.Debugf("%v stream %v closed\n", s.logPrefix, raddr)
+	}()
+
+	// start a receive routine.
+	rcvch := make(chan interface{}, s.streamChanSize)
+	go s.doReceive(stream, raddr, rcvch)
+
+	from := Peer{Addr: raddr, Secure: secure} // with credentials, once authenticated
+
+loop:
+	for {
+		select {
+		case req, ok := <-rcvch:
+			if _, yes := req.(*protobuf.EndStreamRequest); yes { // skip
+				format := "%v stream %q skip protobuf.EndStreamRequest\n"
+				c.Debugf(format, s.logPrefix, raddr)
+				break
+			} else if !ok {
+				break loop
+			}
+			respch := make(chan interface{}, s.streamChanSize)
+			quitch := make(chan interface{}, s.streamChanSize)
+			donech := make(chan bool)
+			go func() {
+				s.handleRequest(stream, raddr, respch, rcvch, quitch)
+				close(donech)
+			}()
+			if auth, yes := req.(*protobuf.AuthRequest); yes {
+				from.Creds = s.handlers.Authenticate(from, auth, respch)
+			} else {
+				s.handlers.HandleFrom(from, req, respch, quitch) // blocking call
+			}
+			// gRPC streams are not safe for concurrent sends, wait for
+			// responses to be transmitted before the next request.
+			<-donech
+
+		case <-s.killch:
+			break loop
+		}
+	}
+	return nil
+}
+
+func (s *GrpcServer) handleRequest(
+	stream protobuf.Queryport_StreamServer, raddr string,
+	respch, rcvch <-chan interface{}, quitch chan<- interface{}) {
+
+	transmit := func(resp interface{}) error {
+		pl, err := protobuf.EncodePayload(resp)
+		if err == nil {
+			err = stream.Send(pl)
+		}
+		if err != nil {
+			format := "%v stream %v response transport failed `%v`\n"
+			c.Debugf(format, s.logPrefix, raddr, err)
+		}
+		return err
+	}
+
+	defer close(quitch)
+
+loop:
+	for { // response loop to stream query results back to client
+		select {
+		case resp, ok := <-respch:
+			if !ok {
+				if err := transmit(&protobuf.StreamEndResponse{}); err == nil {
+					format := "%v protobuf.StreamEndResponse -> %q\n"
+					c.Debugf(format, s.logPrefix, raddr)
+				}
+				break loop
+			}
+			if err := transmit(resp); err != nil {
+				break loop
+			}
+
+		case req, ok := <-rcvch:
+			if _, yes := req.(*protobuf.EndStreamRequest); ok && yes {
+				if err := transmit(&protobuf.StreamEndResponse{}); err == nil {
+					format := "%v protobuf.StreamEndResponse -> %q\n"
+					c.Debugf(format, s.logPrefix, raddr)
+				}
+				break loop
+
+			} else if !ok {
+				break loop
+			}
+
+		case <-s.killch:
+			break loop // close stream
+		}
+	}
+}
+
+// receive requests from remote, when this function returns
+// the stream is expected to be closed.
+func (s *GrpcServer) doReceive(
+	stream protobuf.Queryport_StreamServer, raddr string,
+	rcvch chan<- interface{}) {
+
+	c.Debugf("%v stream %q doReceive() ...\n", s.logPrefix, raddr)
+
+loop:
+	for {
+		pl, err := stream.Recv()
+		if err == nil {
+			var req interface{}
+			if req, err = protobuf.DecodePayload(pl); err == nil {
+				select {
+				case rcvch <- req:
+					continue
+				case <-s.killch:
+					break loop
+				}
+			}
+		}
+		if err == io.EOF {
+			c.Tracef("%v stream %q exited %v\n", s.logPrefix, raddr, err)
+		} else {
+			c.Errorf("%v stream %q exited %v\n", s.logPrefix, raddr, err)
+		}
+		break loop
+	}
+	close(rcvch)
+}
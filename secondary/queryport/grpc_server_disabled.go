@@ -0,0 +1,32 @@
+//go:build !grpc
+// +build !grpc
+
+package queryport
+
+import c "github.com/couchbase/indexing/secondary/common"
+
+// ErrorGrpcUnavailable is returned by NewGrpcServer() when queryport is
+// built without the `grpc` build tag.
+var ErrorGrpcUnavailable = c.NewError(220, "queryport.grpcUnavailable", false)
+
+// GrpcServer stands in for queryport daemon on gRPC transport, queryport
+// is built without gRPC transport unless `grpc` build tag is set.
+type GrpcServer struct{}
+
+// NewGrpcServer always fails with ErrorGrpcUnavailable.
+func NewGrpcServer(
+	laddr string, handlers *Handlers,
+	config c.Config) (s *GrpcServer, err error) {
+
+	c.Errorf("[Queryport-grpc %q] built without gRPC transport\n", laddr)
+	return nil, ErrorGrpcUnavailable
+}
+
+func (s *GrpcServer) Statistics() ServerStats {
+	return ServerStats{}
+}
+
+// Close queryport daemon.
+func (s *GrpcServer) Close() error {
+	return nil
+}
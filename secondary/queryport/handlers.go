@@ -0,0 +1,255 @@
+package queryport
+
+import "reflect"
+import "sync"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+
+// Middleware wraps a RequestHandler with behaviour common to requests,
+// like authentication, logging or metrics. A middleware can refuse a
+// request by posting its response and closing `respch` without calling
+// `next`.
+type Middleware func(next RequestHandler) RequestHandler
+
+// Peer that sent a request.
+type Peer struct {
+	Addr   string      // remote address of the connection
+	Secure bool        // connection is over TLS
+	Creds  Credentials // nil if the connection was not authenticated
+}
+
+// PeerRequestHandler is a RequestHandler that is also passed the peer
+// that sent the request.
+type PeerRequestHandler func(
+	peer Peer,
+	req interface{}, respch chan<- interface{}, quitch <-chan interface{})
+
+// Handlers is a registry of RequestHandler keyed by type of the request
+// message. Requests are dispatched to the handler registered for their
+// type, through middlewares in the order they were added.
+type Handlers struct {
+	mu          sync.RWMutex
+	handlers    map[reflect.Type]PeerRequestHandler
+	middlewares []Middleware
+	fallback    RequestHandler // for requests of types not registered
+	auth        *authorizer    // nil if requests are not authorized
+	hooks       Hooks          // nil if requests are not traced
+}
+
+// NewHandlers creates an empty registry, requests of types not
+// registered are responded with ErrorInvalidRequest.
+func NewHandlers() *Handlers {
+	return &Handlers{handlers: make(map[reflect.Type]PeerRequestHandler)}
+}
+
+// Register `handler` for requests of the same type as `req`, like:
+//
+//	handlers.Register(&protobuf.ScanRequest{}, handleScan)
+//
+// replaces the handler registered earlier for that type.
+func (h *Handlers) Register(req interface{}, handler RequestHandler) *Handlers {
+	return h.RegisterPeer(req, func(
+		peer Peer,
+		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+
+		handler(req, respch, quitch)
+	})
+}
+
+// RegisterPeer is like Register, for handlers that need the peer that
+// sent the request.
+func (h *Handlers) RegisterPeer(
+	req interface{}, handler PeerRequestHandler) *Handlers {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers[reflect.TypeOf(req)] = handler
+	return h
+}
+
+// Fallback handles requests of types not registered.
+func (h *Handlers) Fallback(handler RequestHandler) *Handlers {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fallback = handler
+	return h
+}
+
+// Use middlewares for every request, the first middleware added is the
+// outermost.
+func (h *Handlers) Use(middlewares ...Middleware) *Handlers {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.middlewares = append(h.middlewares, middlewares...)
+	return h
+}
+
+// Authorize every request by the bucket it reads, resolved with
+// `bucketOf`, using credentials that its connection was authenticated
+// with. Connections are authenticated with `authenticate`, requests on
+// connections not authenticated are denied.
+func (h *Handlers) Authorize(
+	authenticate Authenticator, bucketOf BucketResolver) *Handlers {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.auth = &authorizer{
+		authenticate: authenticate,
+		bucketOf:     bucketOf,
+		stats:        AuthStats{Denials: make(map[string]int64)},
+	}
+	return h
+}
+
+// AuthStatistics returns counts of requests denied by authorization.
+func (h *Handlers) AuthStatistics() AuthStats {
+	h.mu.RLock()
+	auth := h.auth
+	h.mu.RUnlock()
+
+	if auth == nil {
+		return AuthStats{Denials: make(map[string]int64)}
+	}
+	return auth.statistics()
+}
+
+// Authenticate a connection from `peer` with credentials in `req`, posts
+// the response on `respch` and closes it. Returns the credentials to handle
+// subsequent requests on the connection, nil if authentication failed or
+// requests are not authorized. Credentials are rejected unless the
+// connection is over TLS.
+func (h *Handlers) Authenticate(
+	peer Peer, req *protobuf.AuthRequest, respch chan<- interface{}) Credentials {
+
+	defer close(respch)
+
+	h.mu.RLock()
+	auth := h.auth
+	h.mu.RUnlock()
+
+	if auth == nil {
+		respch <- &protobuf.AuthResponse{}
+		return nil
+	}
+	if !peer.Secure {
+		auth.authFailed()
+		c.Errorf("[Queryport] authentication of %q from %v rejected, "+
+			"connection is not over TLS\n", req.GetUser(), peer.Addr)
+		err := c.CountError(c.ErrorInsecureAuth)
+		respch <- &protobuf.AuthResponse{Err: protobuf.NewError(err)}
+		return nil
+	}
+	creds, err := auth.authenticate(req.GetUser(), req.GetPassword())
+	if err != nil || creds == nil {
+		auth.authFailed()
+		c.Errorf("[Queryport] authentication failed for %q (%v)\n", req.GetUser(), err)
+		err = c.CountError(c.ErrorAuthFailed)
+		respch <- &protobuf.AuthResponse{Err: protobuf.NewError(err)}
+		return nil
+	}
+	respch <- &protobuf.AuthResponse{}
+	return creds
+}
+
+// IsRegistered returns whether requests of the same type as `req` have a
+// handler.
+func (h *Handlers) IsRegistered(req interface{}) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.handlers[reflect.TypeOf(req)]
+	return ok
+}
+
+// Handle dispatches `req` to its handler, it is the RequestHandler of
+// queryport servers.
+func (h *Handlers) Handle(
+	req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+
+	h.HandleFrom(Peer{}, req, respch, quitch)
+}
+
+// HandleFrom dispatches `req` received from `peer` to its handler.
+func (h *Handlers) HandleFrom(
+	peer Peer,
+	req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+
+	h.mu.RLock()
+	peerHandler, ok := h.handlers[reflect.TypeOf(req)]
+	handler := h.fallback
+	middlewares, auth, hooks := h.middlewares, h.auth, h.hooks
+	h.mu.RUnlock()
+
+	if ok {
+		handler = func(
+			req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+
+			peerHandler(peer, req, respch, quitch)
+		}
+	} else if handler == nil {
+		handler = unknownRequest
+	}
+	if auth != nil {
+		handler = authorized(auth, peer.Creds, handler)
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	if hooks != nil {
+		handler = traced(hooks, peer, handler)
+	}
+	handler(req, respch, quitch)
+}
+
+// authorized wraps `handler`, to respond with an error for requests
+// that `creds` do not permit.
+func authorized(
+	auth *authorizer, creds Credentials, handler RequestHandler) RequestHandler {
+
+	return func(
+		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+
+		if err := auth.authorize(creds, req); err != nil {
+			respch <- errorResponse(req, err)
+			close(respch)
+			return
+		}
+		handler(req, respch, quitch)
+	}
+}
+
+func unknownRequest(
+	req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+
+	c.Errorf("[Queryport] no handler for request %v\n", RequestName(req))
+	respch <- errorResponse(req, c.CountError(c.ErrorInvalidRequest))
+	close(respch)
+}
+
+// RequestName of request message, like "ScanRequest".
+func RequestName(req interface{}) string {
+	t := reflect.TypeOf(req)
+	if t == nil {
+		return "nil"
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// LogRequests is a middleware that logs every request along with the
+// time taken to respond, at debug level.
+func LogRequests(logPrefix string) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(
+			req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+
+			start := time.Now()
+			c.Debugf("%v %v ...\n", logPrefix, RequestName(req))
+			next(req, respch, quitch)
+			c.Debugf("%v %v took %v\n", logPrefix, RequestName(req), time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package queryport
+
+import "errors"
+import "reflect"
+import "testing"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/couchbaselabs/goprotobuf/proto"
+
+func TestHandlers(t *testing.T) {
+	var trace []string
+	tracer := func(name string) Middleware {
+		return func(next RequestHandler) RequestHandler {
+			return func(req interface{},
+				respch chan<- interface{}, quitch <-chan interface{}) {
+
+				trace = append(trace, name+":"+RequestName(req))
+				next(req, respch, quitch)
+			}
+		}
+	}
+	respond := func(name string) RequestHandler {
+		return func(req interface{},
+			respch chan<- interface{}, quitch <-chan interface{}) {
+
+			trace = append(trace, name)
+			close(respch)
+		}
+	}
+
+	handlers := NewHandlers().Use(tracer("auth"), tracer("log"))
+	handlers.Register(&protobuf.ScanRequest{}, respond("scan"))
+	handlers.Register(&protobuf.CountRequest{}, respond("count"))
+	if !handlers.IsRegistered(&protobuf.ScanRequest{}) ||
+		handlers.IsRegistered(&protobuf.LookupRequest{}) {
+		t.Fatalf("unexpected registrations")
+	}
+
+	handle := func(req interface{}) {
+		respch := make(chan interface{}, 1)
+		handlers.Handle(req, respch, make(chan interface{}))
+		if _, ok := <-respch; ok {
+			t.Fatalf("expected respch closed for %v", RequestName(req))
+		}
+	}
+
+	handle(&protobuf.ScanRequest{})
+	handle(&protobuf.CountRequest{})
+
+	// requests not registered are responded with an error.
+	respch := make(chan interface{}, 1)
+	handlers.Handle(&protobuf.LookupRequest{}, respch, make(chan interface{}))
+	if resp, ok := <-respch; !ok {
+		t.Fatalf("expected error response for LookupRequest")
+	} else if err := resp.(*protobuf.ResponseStream).GetErr(); err == nil ||
+		err.GetCode() != uint32(c.ErrorCodeOf(c.ErrorInvalidRequest)) {
+		t.Fatalf("expected %v, got %v", c.ErrorInvalidRequest, err)
+	}
+	if _, ok := <-respch; ok {
+		t.Fatalf("expected respch closed for LookupRequest")
+	}
+	ref := []string{
+		"auth:ScanRequest", "log:ScanRequest", "scan",
+		"auth:CountRequest", "log:CountRequest", "count",
+		"auth:LookupRequest", "log:LookupRequest",
+	}
+	if !reflect.DeepEqual(trace, ref) {
+		t.Fatalf("expected %v, got %v", ref, trace)
+	}
+
+	trace = nil
+	handlers.Fallback(respond("fallback"))
+	handle(&protobuf.LookupRequest{})
+	ref = []string{"auth:LookupRequest", "log:LookupRequest", "fallback"}
+	if !reflect.DeepEqual(trace, ref) {
+		t.Fatalf("expected %v, got %v", ref, trace)
+	}
+}
+
+type testCreds struct {
+	name    string
+	buckets []string
+}
+
+func (creds *testCreds) Name() string { return creds.name }
+
+func (creds *testCreds) CanReadBucket(bucket string) (bool, error) {
+	for _, b := range creds.buckets {
+		if b == bucket {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestHandlersAuthorize(t *testing.T) {
+	authenticate := func(user, password string) (Credentials, error) {
+		if user == "alice" && password == "secret" {
+			return &testCreds{name: user, buckets: []string{"beer"}}, nil
+		}
+		return nil, errors.New("invalid credentials")
+	}
+	bucketOf := func(req interface{}) (string, error) {
+		switch req.(*protobuf.ScanRequest).GetDefnID() {
+		case 1:
+			return "beer", nil
+		case 2:
+			return "travel", nil
+		}
+		return "", errors.New("index not found")
+	}
+	var handled int
+	handlers := NewHandlers().Authorize(authenticate, bucketOf)
+	handlers.Register(&protobuf.ScanRequest{}, func(req interface{},
+		respch chan<- interface{}, quitch <-chan interface{}) {
+
+		handled++
+		close(respch)
+	})
+
+	auth := func(secure bool, user, password string) Credentials {
+		respch := make(chan interface{}, 1)
+		req := &protobuf.AuthRequest{
+			User: proto.String(user), Password: proto.String(password),
+		}
+		creds := handlers.Authenticate(Peer{Secure: secure}, req, respch)
+		resp := (<-respch).(*protobuf.AuthResponse)
+		if (creds == nil) != (resp.Error() != nil) {
+			t.Fatalf("unexpected response %v for credentials %v", resp, creds)
+		}
+		return creds
+	}
+	scan := func(creds Credentials, defnID uint64) error {
+		respch := make(chan interface{}, 1)
+		req := &protobuf.ScanRequest{DefnID: proto.Uint64(defnID)}
+		handlers.HandleFrom(Peer{Creds: creds}, req, respch, make(chan interface{}))
+		if resp, ok := <-respch; ok {
+			return resp.(*protobuf.ResponseStream).Error()
+		}
+		return nil
+	}
+
+	if creds := auth(true, "alice", "guess"); creds != nil {
+		t.Fatalf("expected authentication to fail")
+	}
+	if creds := auth(false, "alice", "secret"); creds != nil {
+		t.Fatalf("expected authentication over plain text to fail")
+	}
+	creds := auth(true, "alice", "secret")
+	if err := scan(creds, 1); err != nil || handled != 1 {
+		t.Fatalf("expected scan to be handled, got %v", err)
+	}
+	if err := scan(creds, 2); !c.IsError(err, c.ErrorUnauthorized) {
+		t.Fatalf("expected %v, got %v", c.ErrorUnauthorized, err)
+	}
+	if err := scan(nil, 1); !c.IsError(err, c.ErrorAuthFailed) {
+		t.Fatalf("expected %v, got %v", c.ErrorAuthFailed, err)
+	}
+	// bucket of an unknown index cannot be resolved.
+	if err := scan(creds, 3); !c.IsError(err, c.ErrorUnauthorized) || handled != 1 {
+		t.Fatalf("expected %v, got %v", c.ErrorUnauthorized, err)
+	}
+
+	stats := handlers.AuthStatistics()
+	if stats.AuthFailures != 2 || stats.Unauthenticated != 1 ||
+		stats.Unresolved != 1 ||
+		len(stats.Denials) != 1 || stats.Denials["travel"] != 1 {
+		t.Fatalf("unexpected statistics %+v", stats)
+	}
+}
+
+type testHooks struct {
+	events []string
+	err    error
+}
+
+func (h *testHooks) OnRequestStart(peer Peer, req interface{}) interface{} {
+	h.events = append(h.events, "start:"+peer.Addr+":"+RequestName(req))
+	return RequestName(req)
+}
+
+func (h *testHooks) OnBatch(ctx interface{}, resp interface{}) {
+	h.events = append(h.events, "batch:"+ctx.(string))
+}
+
+func (h *testHooks) OnComplete(ctx interface{}, err error) {
+	h.events = append(h.events, "complete:"+ctx.(string))
+	h.err = err
+}
+
+func TestHandlersTrace(t *testing.T) {
+	hooks := &testHooks{}
+	handlers := NewHandlers().Trace(hooks)
+	handlers.Register(&protobuf.ScanRequest{}, func(req interface{},
+		respch chan<- interface{}, quitch <-chan interface{}) {
+
+		respch <- &protobuf.ResponseStream{}
+		respch <- &protobuf.ResponseStream{
+			Err: protobuf.NewError(c.ErrorSnapshotNotReady),
+		}
+		close(respch)
+	})
+
+	respch := make(chan interface{})
+	peer := Peer{Addr: "client"}
+	go handlers.HandleFrom(peer, &protobuf.ScanRequest{}, respch, nil)
+	n := 0
+	for _ = range respch {
+		n++
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 responses, got %v", n)
+	}
+	ref := []string{
+		"start:client:ScanRequest",
+		"batch:ScanRequest", "batch:ScanRequest", "complete:ScanRequest",
+	}
+	if !reflect.DeepEqual(hooks.events, ref) {
+		t.Fatalf("expected %v, got %v", ref, hooks.events)
+	}
+	if !c.IsError(hooks.err, c.ErrorSnapshotNotReady) {
+		t.Fatalf("expected %v, got %v", c.ErrorSnapshotNotReady, hooks.err)
+	}
+}
@@ -0,0 +1,58 @@
+package queryport
+
+// Hooks are called at the stages of every request handled by queryport,
+// so that external code can integrate tracing systems, like spans of
+// OpenTracing, without queryport depending on any tracer. Hooks of
+// a request are called in order, hooks of different requests can be
+// called concurrently.
+type Hooks interface {
+	// OnRequestStart is called when `req` is received from `peer`,
+	// before it is handled, returns a context, like a span, that is
+	// passed to subsequent hooks of the request.
+	OnRequestStart(peer Peer, req interface{}) interface{}
+
+	// OnBatch is called for every response posted for the request,
+	// before it is transmitted.
+	OnBatch(ctx interface{}, resp interface{})
+
+	// OnComplete is called after the last response is posted for the
+	// request, with the first error responded, if any.
+	OnComplete(ctx interface{}, err error)
+}
+
+// Trace every request with `hooks`, replaces hooks set earlier, nil
+// stops tracing.
+func (h *Handlers) Trace(hooks Hooks) *Handlers {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = hooks
+	return h
+}
+
+// traced wraps `handler`, to call `hooks` for requests from `peer`.
+// Responses of the handler are passed to hooks on their way to
+// `respch`, responses are dropped once `quitch` is closed.
+func traced(hooks Hooks, peer Peer, handler RequestHandler) RequestHandler {
+	return func(
+		req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+
+		ctx := hooks.OnRequestStart(peer, req)
+		tracech := make(chan interface{}, cap(respch))
+		go func() {
+			var failed error
+			for resp := range tracech {
+				if r, ok := resp.(interface{ Error() error }); ok && failed == nil {
+					failed = r.Error()
+				}
+				hooks.OnBatch(ctx, resp)
+				select {
+				case respch <- resp:
+				case <-quitch:
+				}
+			}
+			hooks.OnComplete(ctx, failed)
+			close(respch)
+		}()
+		handler(req, tracech, quitch)
+	}
+}
@@ -633,6 +633,7 @@ type statistics struct {
 	uniqueKeys int64
 	min        value.Values
 	max        value.Values
+	bins       []datastore.Statistics
 }
 
 // return an
@@ -645,6 +646,10 @@ func newStatistics(pstats c.IndexStatistics) datastore.Statistics {
 	stats.min = skey2Values(min)
 	max, _ := pstats.MaxKey()
 	stats.max = skey2Values(max)
+	bins, _ := pstats.Bins()
+	for _, bin := range bins {
+		stats.bins = append(stats.bins, newStatistics(bin))
+	}
 	return stats
 }
 
@@ -670,7 +675,7 @@ func (stats *statistics) Max() (value.Values, errors.Error) {
 
 // Bins implement Statistics{} interface.
 func (stats *statistics) Bins() ([]datastore.Statistics, errors.Error) {
-	return nil, nil
+	return stats.bins, nil
 }
 
 //------------------
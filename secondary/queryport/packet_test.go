@@ -0,0 +1,121 @@
+package queryport
+
+import "bytes"
+import "net"
+import "reflect"
+import "testing"
+
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/couchbase/indexing/secondary/transport"
+import "github.com/couchbaselabs/goprotobuf/proto"
+
+// loopConn loops back packets written to it.
+type loopConn struct {
+	bytes.Buffer
+}
+
+var loopAddr = &net.TCPAddr{}
+
+func (conn *loopConn) LocalAddr() net.Addr  { return loopAddr }
+func (conn *loopConn) RemoteAddr() net.Addr { return loopAddr }
+
+// response to a scan that matched few entries.
+func smallScanResponse() *protobuf.ResponseStream {
+	entries := make([]*protobuf.IndexEntry, 0, 4)
+	for _, key := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+		entries = append(entries, &protobuf.IndexEntry{
+			EntryKey: []byte(`["` + key + `"]`), PrimaryKey: []byte(key),
+		})
+	}
+	return &protobuf.ResponseStream{IndexEntries: entries}
+}
+
+func TestProtobufEncodeInBuf(t *testing.T) {
+	flags := transport.TransportFlag(0).SetProtobuf()
+	pkt := transport.NewTransportPacket(1024*1024, flags)
+	pkt.SetBufEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncodeInBuf)
+	pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+
+	conn := &loopConn{}
+	reqs := []interface{}{
+		smallScanResponse(),
+		&protobuf.StreamEndResponse{},
+		&protobuf.CountResponse{Count: proto.Int64(10)},
+	}
+	for _, req := range reqs {
+		if err := pkt.Send(conn, req); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := pkt.Receive(conn)
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(req, resp) {
+			t.Fatalf("expected %v, got %v", req, resp)
+		}
+	}
+
+	ref, _ := protobuf.ProtobufEncode(reqs[0])
+	data, err := protobuf.ProtobufEncodeInBuf(reqs[0], []byte("prefix"))
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, append([]byte("prefix"), ref...)) {
+		t.Fatalf("expected encoding to be appended to buffer")
+	}
+	if _, err := protobuf.ProtobufEncodeInBuf(10, nil); err != protobuf.ErrorMissingPayload {
+		t.Fatalf("expected %v, got %v", protobuf.ErrorMissingPayload, err)
+	}
+}
+
+func benchmarkSendScanResponse(
+	b *testing.B, flags transport.TransportFlag, buffered bool) {
+
+	resp := smallScanResponse()
+	pkt := transport.NewTransportPacket(1024*1024, flags.SetProtobuf())
+	if buffered {
+		pkt.SetBufEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncodeInBuf)
+	} else {
+		pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	}
+	conn := &loopConn{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.Reset()
+		if err := pkt.Send(conn, resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendScanResponse(b *testing.B) {
+	benchmarkSendScanResponse(b, 0, false)
+}
+
+func BenchmarkSendScanResponseInBuf(b *testing.B) {
+	benchmarkSendScanResponse(b, 0, true)
+}
+
+func BenchmarkSendScanResponseGzip(b *testing.B) {
+	benchmarkSendScanResponse(b, transport.TransportFlag(0).SetGzip(), true)
+}
+
+func BenchmarkReceiveScanResponseGzip(b *testing.B) {
+	flags := transport.TransportFlag(0).SetProtobuf().SetGzip()
+	pkt := transport.NewTransportPacket(1024*1024, flags)
+	pkt.SetBufEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncodeInBuf)
+	pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+	conn := &loopConn{}
+	if err := pkt.Send(conn, smallScanResponse()); err != nil {
+		b.Fatal(err)
+	}
+	data := append([]byte(nil), conn.Bytes()...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.Reset()
+		conn.Write(data)
+		if _, err := pkt.Receive(conn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
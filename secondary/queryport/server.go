@@ -1,5 +1,6 @@
 package queryport
 
+import "crypto/tls"
 import "fmt"
 import "net"
 import "runtime/debug"
@@ -12,17 +13,21 @@ import c "github.com/couchbase/indexing/secondary/common"
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 import "github.com/couchbase/indexing/secondary/transport"
 
+// ErrorUnknownTransport
+var ErrorUnknownTransport = c.NewError(215, "queryport.unknownTransport", false)
+
 // RequestHandler shall interpret the request message
 // from client and post response message(s) on `respch`
 // channel, until `quitch` is closed. When there are
 // no more response to post handler shall close `respch`.
+// Handlers are registered by request type, refer Handlers.
 type RequestHandler func(
 	req interface{}, respch chan<- interface{}, quitch <-chan interface{})
 
 // Server handles queryport connections.
 type Server struct {
-	laddr string         // address to listen
-	callb RequestHandler // callback to application on incoming request.
+	laddr    string    // address to listen
+	handlers *Handlers // application handlers of incoming requests.
 	// local fields
 	mu     sync.Mutex
 	lis    net.Listener
@@ -32,34 +37,91 @@ type Server struct {
 	readDeadline   time.Duration
 	writeDeadline  time.Duration
 	streamChanSize int
+	compression    bool
+	cthreshold     int
 	logPrefix      string
 
 	nConnections int64
+	cstats       transport.CompressionStats
 }
 
 type ServerStats struct {
 	Connections int64
+	// compression of response batches, on native transport.
+	CompressedBatches int64
+	RawBytes          int64 // size of compressed batches before compression
+	CompressedBytes   int64
+}
+
+// CompressionRatio of compressed batches, 1.0 when nothing was compressed.
+func (stats ServerStats) CompressionRatio() float64 {
+	if stats.RawBytes == 0 {
+		return 1.0
+	}
+	return float64(stats.CompressedBytes) / float64(stats.RawBytes)
+}
+
+// Queryport is a queryport daemon, on native or gRPC transport.
+type Queryport interface {
+	Statistics() ServerStats
+	Close() error
+}
+
+// NewQueryport creates a queryport daemon on the transport chosen by
+// config["transport"], `native` or `grpc`.
+func NewQueryport(
+	laddr string, handlers *Handlers, config c.Config) (Queryport, error) {
+
+	switch t := config["transport"].String(); t {
+	case "native":
+		s, err := NewServer(laddr, handlers, config)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+
+	case "grpc":
+		s, err := NewGrpcServer(laddr, handlers, config)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+
+	default:
+		c.Errorf("[Queryport %q] unknown transport %q\n", laddr, t)
+		return nil, ErrorUnknownTransport
+	}
 }
 
 // NewServer creates a new queryport daemon.
 func NewServer(
-	laddr string, callb RequestHandler,
+	laddr string, handlers *Handlers,
 	config c.Config) (s *Server, err error) {
 
 	s = &Server{
 		laddr:          laddr,
-		callb:          callb,
+		handlers:       handlers,
 		killch:         make(chan bool),
 		maxPayload:     config["maxPayload"].Int(),
 		readDeadline:   time.Duration(config["readDeadline"].Int()),
 		writeDeadline:  time.Duration(config["writeDeadline"].Int()),
 		streamChanSize: config["streamChanSize"].Int(),
+		compression:    config["compression"].Bool(),
+		cthreshold:     config["compressionThreshold"].Int(),
 		logPrefix:      fmt.Sprintf("[Queryport %q]", laddr),
 	}
+	tlsConfig, err := c.NewServerTLSConfig(config)
+	if err != nil {
+		c.Errorf("%v invalid TLS configuration %v !!\n", s.logPrefix, err)
+		return nil, err
+	}
 	if s.lis, err = net.Listen("tcp", laddr); err != nil {
 		c.Errorf("%v failed starting %v !!\n", s.logPrefix, err)
 		return nil, err
 	}
+	if tlsConfig != nil {
+		s.lis = tls.NewListener(s.lis, tlsConfig)
+	}
 
 	go s.listener()
 	c.Infof("%v started ...\n", s.logPrefix)
@@ -68,7 +130,10 @@ func NewServer(
 
 func (s *Server) Statistics() ServerStats {
 	return ServerStats{
-		Connections: atomic.LoadInt64(&s.nConnections),
+		Connections:       atomic.LoadInt64(&s.nConnections),
+		CompressedBatches: atomic.LoadInt64(&s.cstats.Packets),
+		RawBytes:          atomic.LoadInt64(&s.cstats.RawBytes),
+		CompressedBytes:   atomic.LoadInt64(&s.cstats.CompressedBytes),
 	}
 }
 
@@ -130,14 +195,20 @@ func (s *Server) handleConnection(conn net.Conn) {
 		c.Debugf("%v connection %v closed\n", s.logPrefix, raddr)
 	}()
 
-	// start a receive routine.
-	rcvch := make(chan interface{}, s.streamChanSize)
-	go s.doReceive(conn, rcvch)
-
 	// transport buffer for transmission
 	flags := transport.TransportFlag(0).SetProtobuf()
 	tpkt := transport.NewTransportPacket(s.maxPayload, flags)
-	tpkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	tpkt.SetBufEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncodeInBuf)
+	tpkt.SetCompressionThreshold(s.cthreshold)
+	tpkt.SetCompressionStats(&s.cstats)
+
+	// start a receive routine.
+	rcvch := make(chan interface{}, s.streamChanSize)
+	go s.doReceive(conn, tpkt, rcvch)
+
+	// with credentials, once authenticated
+	_, secure := conn.(*tls.Conn)
+	from := Peer{Addr: raddr.String(), Secure: secure}
 
 loop:
 	for {
@@ -153,7 +224,11 @@ loop:
 			respch := make(chan interface{}, s.streamChanSize)
 			quitch := make(chan interface{}, s.streamChanSize)
 			go s.handleRequest(conn, tpkt, respch, rcvch, quitch)
-			s.callb(req, respch, quitch) // blocking call
+			if auth, yes := req.(*protobuf.AuthRequest); yes {
+				from.Creds = s.handlers.Authenticate(from, auth, respch)
+			} else {
+				s.handlers.HandleFrom(from, req, respch, quitch) // blocking call
+			}
 
 		case <-s.killch:
 			break loop
@@ -215,9 +290,13 @@ loop:
 }
 
 // receive requests from remote, when this function returns
-// the connection is expected to be closed.
-func (s *Server) doReceive(conn net.Conn, rcvch chan<- interface{}) {
+// the connection is expected to be closed. Compression of responses
+// transmitted via `tpkt` is negotiated with the first request.
+func (s *Server) doReceive(
+	conn net.Conn, tpkt *transport.TransportPacket, rcvch chan<- interface{}) {
+
 	raddr := conn.RemoteAddr()
+	negotiated := false
 
 	// transport buffer for receiving
 	flags := transport.TransportFlag(0).SetProtobuf()
@@ -242,6 +321,10 @@ loop:
 			}
 			break loop
 		}
+		if !negotiated { // before the request is handed over for response
+			s.negotiateCompression(raddr, rpkt.Flags(), tpkt)
+			negotiated = true
+		}
 		select {
 		case rcvch <- req:
 		case <-s.killch:
@@ -250,3 +333,22 @@ loop:
 	}
 	close(rcvch)
 }
+
+// negotiateCompression of responses with client, response batches are
+// compressed if client accepts a compression we support and compression
+// is enabled on the server.
+func (s *Server) negotiateCompression(
+	raddr net.Addr, flags transport.TransportFlag,
+	tpkt *transport.TransportPacket) {
+
+	if !s.compression {
+		return
+	}
+	switch flags.GetAcceptCompression() {
+	case transport.CompressionGzip:
+		flags := transport.TransportFlag(0).SetProtobuf().SetGzip()
+		tpkt.SetFlags(flags)
+		format := "%v connection %q responses compressed with gzip\n"
+		c.Debugf(format, s.logPrefix, raddr)
+	}
+}
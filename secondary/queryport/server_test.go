@@ -354,7 +354,7 @@ func BenchmarkScanAll(b *testing.B) {
 
 func startServer(tb testing.TB, laddr string, callb RequestHandler) *Server {
 	config := c.SystemConfig.SectionConfig("queryport.indexer.", true)
-	s, err := NewServer(laddr, callb, config)
+	s, err := NewServer(laddr, NewHandlers().Fallback(callb), config)
 	if err != nil {
 		tb.Fatal(err)
 	}
@@ -0,0 +1,152 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ClusterConfiguration of the cluster under test, consumed by the
+// framework helpers. Addresses are host:port of ns_server, unless noted.
+type ClusterConfiguration struct {
+	KVAddress              string // cluster address, to load data in KV
+	Username               string // cluster administrator
+	Password               string
+	IndexManagementAddress string // to create, build and drop indexes
+	IndexScanAddress       string // to scan indexes
+	TLS                    TLSConfiguration
+}
+
+// TLSConfiguration of memcached connections to KV, and of queryport
+// connections made by the framework's index clients.
+type TLSConfiguration struct {
+	Enabled            bool
+	Port               int // TLS port of every node, 0 for advertised port
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+// Environment variables that override the configuration file, so that
+// the suite can be pointed to another cluster without editing it.
+const (
+	EnvKVAddress              = "CBTEST_KVADDRESS"
+	EnvUsername               = "CBTEST_USERNAME"
+	EnvPassword               = "CBTEST_PASSWORD"
+	EnvIndexManagementAddress = "CBTEST_INDEXMANAGEMENTADDRESS"
+	EnvIndexScanAddress       = "CBTEST_INDEXSCANADDRESS"
+	EnvTLSEnabled             = "CBTEST_TLS"
+	EnvTLSPort                = "CBTEST_TLS_PORT"
+	EnvTLSCAFile              = "CBTEST_TLS_CAFILE"
+	EnvTLSInsecureSkipVerify  = "CBTEST_TLS_INSECURESKIPVERIFY"
+)
+
+var clusterConf struct {
+	mu   sync.RWMutex
+	conf ClusterConfiguration
+}
+
+// LoadClusterConfiguration from JSON file `filepath`, refer tests/config,
+// overridden by environment variables. Index management and scan
+// addresses default to KVAddress. An empty `filepath` loads the
+// configuration only from the environment.
+func LoadClusterConfiguration(filepath string) (ClusterConfiguration, error) {
+	conf := ClusterConfiguration{}
+	if filepath != "" {
+		data, err := ioutil.ReadFile(filepath)
+		if err != nil {
+			return conf, err
+		}
+		if err := json.Unmarshal(data, &conf); err != nil {
+			return conf, err
+		}
+	}
+
+	override := func(field *string, name string) {
+		if value := os.Getenv(name); value != "" {
+			*field = value
+		}
+	}
+	override(&conf.KVAddress, EnvKVAddress)
+	override(&conf.Username, EnvUsername)
+	override(&conf.Password, EnvPassword)
+	override(&conf.IndexManagementAddress, EnvIndexManagementAddress)
+	override(&conf.IndexScanAddress, EnvIndexScanAddress)
+	override(&conf.TLS.CAFile, EnvTLSCAFile)
+
+	var err error
+	if value := os.Getenv(EnvTLSEnabled); value != "" {
+		if conf.TLS.Enabled, err = strconv.ParseBool(value); err != nil {
+			return conf, err
+		}
+	}
+	if value := os.Getenv(EnvTLSPort); value != "" {
+		if conf.TLS.Port, err = strconv.Atoi(value); err != nil {
+			return conf, err
+		}
+	}
+	if value := os.Getenv(EnvTLSInsecureSkipVerify); value != "" {
+		if conf.TLS.InsecureSkipVerify, err = strconv.ParseBool(value); err != nil {
+			return conf, err
+		}
+	}
+
+	if conf.KVAddress == "" {
+		return conf, errors.New("cluster address is not configured")
+	}
+	if conf.IndexManagementAddress == "" {
+		conf.IndexManagementAddress = conf.KVAddress
+	}
+	if conf.IndexScanAddress == "" {
+		conf.IndexScanAddress = conf.KVAddress
+	}
+	return conf, nil
+}
+
+// GetClusterConfFromFile loads configuration, refer
+// LoadClusterConfiguration, and sets it for the framework helpers.
+// Panics on error.
+func GetClusterConfFromFile(filepath string) ClusterConfiguration {
+	conf, err := LoadClusterConfiguration(filepath)
+	HandleError(err, "Error in loading cluster configuration")
+	SetClusterConfiguration(conf)
+	return conf
+}
+
+// SetClusterConfiguration for the framework helpers.
+func SetClusterConfiguration(conf ClusterConfiguration) {
+	clusterConf.mu.Lock()
+	defer clusterConf.mu.Unlock()
+	clusterConf.conf = conf
+}
+
+// GetClusterConfiguration set for the framework helpers.
+func GetClusterConfiguration() ClusterConfiguration {
+	clusterConf.mu.RLock()
+	defer clusterConf.mu.RUnlock()
+	return clusterConf.conf
+}
+
+// Config returns TLS configuration and port for memcached connections,
+// nil if TLS is not enabled.
+func (conf TLSConfiguration) Config() (*tls.Config, int, error) {
+	if !conf.Enabled {
+		return nil, 0, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify}
+	if conf.CAFile != "" {
+		pem, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, 0, errors.New("invalid CA certificate " + conf.CAFile)
+		}
+	}
+	return config, conf.Port, nil
+}
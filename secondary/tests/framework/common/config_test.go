@@ -0,0 +1,94 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setenv(t *testing.T, envs map[string]string) func() {
+	for name, value := range envs {
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for name := range envs {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestLoadClusterConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clusterconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "conf.json")
+	data := `{"KVAddress": "127.0.0.1:9000", "Username": "Administrator",
+		"Password": "asdasd", "TLS": {"Enabled": true, "Port": 11207}}`
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// index addresses default to KVAddress.
+	conf, err := LoadClusterConfiguration(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := ClusterConfiguration{
+		KVAddress:              "127.0.0.1:9000",
+		Username:               "Administrator",
+		Password:               "asdasd",
+		IndexManagementAddress: "127.0.0.1:9000",
+		IndexScanAddress:       "127.0.0.1:9000",
+		TLS:                    TLSConfiguration{Enabled: true, Port: 11207},
+	}
+	if conf != ref {
+		t.Fatalf("expected %+v, got %+v", ref, conf)
+	}
+
+	// environment overrides the file.
+	unset := setenv(t, map[string]string{
+		EnvPassword:         "password",
+		EnvIndexScanAddress: "127.0.0.1:9001",
+		EnvTLSEnabled:       "false",
+		EnvTLSPort:          "0",
+	})
+	conf, err = LoadClusterConfiguration(file)
+	unset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref.Password, ref.IndexScanAddress = "password", "127.0.0.1:9001"
+	ref.TLS = TLSConfiguration{}
+	if conf != ref {
+		t.Fatalf("expected %+v, got %+v", ref, conf)
+	}
+
+	// configuration only from the environment.
+	unset = setenv(t, map[string]string{EnvKVAddress: "10.0.0.1:8091"})
+	conf, err = LoadClusterConfiguration("")
+	unset()
+	if err != nil {
+		t.Fatal(err)
+	} else if conf.KVAddress != "10.0.0.1:8091" || conf.IndexManagementAddress != "10.0.0.1:8091" {
+		t.Fatalf("unexpected configuration %+v", conf)
+	}
+
+	if _, err := LoadClusterConfiguration(""); err == nil {
+		t.Fatalf("expected missing cluster address to fail")
+	}
+	if _, err := LoadClusterConfiguration(filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatalf("expected missing file to fail")
+	}
+	unset = setenv(t, map[string]string{EnvTLSPort: "tls"})
+	_, err = LoadClusterConfiguration(file)
+	unset()
+	if err == nil {
+		t.Fatalf("expected invalid %v to fail", EnvTLSPort)
+	}
+}
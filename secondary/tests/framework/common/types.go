@@ -9,9 +9,3 @@ type ScanResponse map[string][]interface{}
 // Key = string (doc key)
 // Value = any JSON object
 type KeyValues map[string]interface{}
-
-type ClusterConfiguration struct {
-	KVAddress string
-	Username  string
-	Password  string
-}
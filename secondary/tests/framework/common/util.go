@@ -2,7 +2,6 @@ package common
 
 import (
 	"compress/gzip"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -96,13 +95,3 @@ func DownloadDataFile(sourceDataFile, destinationFilePath string, skipIfFileExis
 	HandleError(err, "Error downloading datafile "+destinationFilePath)
 	fmt.Println(n, "Data file downloaded")
 }
-
-func GetClusterConfFromFile(filepath string) ClusterConfiguration {
-	file, e := os.Open(filepath)
-	HandleError(e, "Error in creating config file handle")
-	decoder := json.NewDecoder(file)
-	configuration := ClusterConfiguration{}
-	err := decoder.Decode(&configuration)
-	HandleError(err, "Error in decoding cluster configuration")
-	return configuration
-}
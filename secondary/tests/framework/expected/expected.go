@@ -0,0 +1,190 @@
+// Package expected computes expected scan responses of an index over the
+// in-memory document set of a test. Index key expressions and where clause
+// are evaluated with the same N1QL expression library as projector, and
+// ranges are matched in N1QL collation order like indexer.
+package expected
+
+import (
+	"encoding/json"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
+	"github.com/couchbaselabs/query/expression"
+	"github.com/couchbaselabs/query/parser/n1ql"
+	qvalue "github.com/couchbaselabs/query/value"
+)
+
+// Inclusion of range bounds, same as queryport client.
+const (
+	Neither uint32 = iota // exclude low, exclude high
+	Low                   // include low, exclude high
+	High                  // exclude low, include high
+	Both                  // include low, include high
+)
+
+// Index evaluates secondary keys of documents for an index.
+type Index struct {
+	cExprs []interface{}
+	where  interface{} // nil when index has no where clause
+}
+
+// NewIndex for index created on `indexFields`, as passed to
+// secondaryindex.CreateSecondaryIndex, with optional `whereExpr`.
+func NewIndex(indexFields []string, whereExpr string) (*Index, error) {
+	var secExprs []string
+	for _, indexField := range indexFields {
+		expr, err := n1ql.ParseExpression(indexField)
+		if err != nil {
+			return nil, err
+		}
+		secExprs = append(secExprs, expression.NewStringer().Visit(expr))
+	}
+	cExprs, err := protobuf.CompileN1QLExpression(secExprs)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{cExprs: cExprs}
+	if whereExpr != "" {
+		expr, err := n1ql.ParseExpression(whereExpr)
+		if err != nil {
+			return nil, err
+		}
+		where := expression.NewStringer().Visit(expr)
+		cWhere, err := protobuf.CompileN1QLExpression([]string{where})
+		if err != nil {
+			return nil, err
+		}
+		idx.where = cWhere[0]
+	}
+	return idx, nil
+}
+
+// Evaluate secondary key for a document, nil if the document is not
+// indexed.
+func (idx *Index) Evaluate(docid string, doc interface{}) ([]interface{}, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if idx.where != nil {
+		// missing, errors and anything but true skip the document
+		out, err := protobuf.N1QLTransform(nil, data, []interface{}{idx.where})
+		if err != nil || string(out) != "true" {
+			return nil, nil
+		}
+	}
+	secKey, err := protobuf.N1QLTransform([]byte(docid), data, idx.cExprs)
+	if err != nil || secKey == nil {
+		return nil, err
+	}
+	var key []interface{}
+	if err := json.Unmarshal(secKey, &key); err != nil {
+		return nil, err
+	}
+	// N1QLTransform appends docid to make the secondary key unique.
+	return key[:len(key)-1], nil
+}
+
+// Entries returns the secondary key of every indexed document, as
+// returned by a full scan of the index.
+func (idx *Index) Entries(docs tc.KeyValues) (tc.ScanResponse, error) {
+	results := make(tc.ScanResponse)
+	for docid, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		secKey, err := idx.Evaluate(docid, doc)
+		if err != nil {
+			return nil, err
+		} else if secKey != nil {
+			results[docid] = secKey
+		}
+	}
+	return results, nil
+}
+
+// Range returns entries between low and high, as returned by a range
+// scan of the index. Like indexer, bounds shorter than the secondary key
+// are compared with its leading components only, and a nil bound leaves
+// the range open on that side.
+func (idx *Index) Range(docs tc.KeyValues, low, high []interface{},
+	inclusion uint32) (tc.ScanResponse, error) {
+
+	entries, err := idx.Entries(docs)
+	if err != nil {
+		return nil, err
+	}
+	results := make(tc.ScanResponse)
+	for docid, secKey := range entries {
+		if low != nil {
+			cmp, err := collate(secKey, low)
+			if err != nil {
+				return nil, err
+			} else if cmp < 0 || (cmp == 0 && (inclusion == Neither || inclusion == High)) {
+				continue
+			}
+		}
+		if high != nil {
+			cmp, err := collate(secKey, high)
+			if err != nil {
+				return nil, err
+			} else if cmp > 0 || (cmp == 0 && (inclusion == Neither || inclusion == Low)) {
+				continue
+			}
+		}
+		results[docid] = secKey
+	}
+	return results, nil
+}
+
+// Lookup returns entries equal to `value`, as returned by a lookup scan
+// of the index.
+func (idx *Index) Lookup(docs tc.KeyValues, value []interface{}) (tc.ScanResponse, error) {
+	return idx.Range(docs, value, value, Both)
+}
+
+// ScanAll for an index on `field`. Like other framework helpers, panics
+// on errors.
+func ScanAll(docs tc.KeyValues, field string) tc.ScanResponse {
+	results, err := mustIndex(field).Entries(docs)
+	tc.HandleError(err, "expected.ScanAll")
+	return results
+}
+
+// Range for an index on `field` between low and high.
+func Range(docs tc.KeyValues, field string, low, high interface{},
+	inclusion uint32) tc.ScanResponse {
+
+	results, err := mustIndex(field).Range(
+		docs, []interface{}{low}, []interface{}{high}, inclusion)
+	tc.HandleError(err, "expected.Range")
+	return results
+}
+
+// Lookup for an index on `field` equal to value.
+func Lookup(docs tc.KeyValues, field string, value interface{}) tc.ScanResponse {
+	return Range(docs, field, value, value, Both)
+}
+
+func mustIndex(field string) *Index {
+	idx, err := NewIndex([]string{field}, "")
+	tc.HandleError(err, "expected.NewIndex")
+	return idx
+}
+
+// collate leading components of secondary key with bound in N1QL
+// collation order.
+func collate(secKey, bound []interface{}) (int, error) {
+	if len(secKey) > len(bound) {
+		secKey = secKey[:len(bound)]
+	}
+	k, err := json.Marshal(secKey)
+	if err != nil {
+		return 0, err
+	}
+	b, err := json.Marshal(bound)
+	if err != nil {
+		return 0, err
+	}
+	return qvalue.NewValue(k).Collate(qvalue.NewValue(b)), nil
+}
@@ -2,100 +2,71 @@ package kvutility
 
 import (
 	"fmt"
+	c "github.com/couchbase/indexing/secondary/common"
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
 	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
-	"github.com/couchbaselabs/go-couchbase"
 	"net/http"
 	"net/url"
 	"strings"
 )
 
-// ToDo: Refactor Code
-func Set(key string, v interface{}, bucketName string, password string, hostaddress string) {
-	url := "http://" + bucketName + ":" + password + "@" + hostaddress
+// connectBucket on cluster `hostaddress`, authenticating with `password`
+// of the bucket if supplied, else with cbauth. Memcached connections are
+// made over TLS if configured, refer tc.ClusterConfiguration.
+func connectBucket(bucketName string, password string, hostaddress string) *couchbase.Bucket {
+	username := ""
+	if password != "" {
+		username = bucketName
+	}
+	ah := c.NewAuthHandler(hostaddress, bucketName, username, password)
 
-	c, err := couchbase.Connect(url)
-	tc.HandleError(err, "connect - "+url)
+	tlsConfig, port, err := tc.GetClusterConfiguration().TLS.Config()
+	tc.HandleError(err, "tls")
 
-	p, err := c.GetPool("default")
-	tc.HandleError(err, "pool")
+	b, err := c.ConnectBucketWithTLS(hostaddress, "default", bucketName, ah, tlsConfig, port)
+	tc.HandleError(err, "bucket - "+hostaddress)
+	return b
+}
 
-	b, err := p.GetBucket(bucketName)
-	tc.HandleError(err, "bucket")
+func Set(key string, v interface{}, bucketName string, password string, hostaddress string) {
+	b := connectBucket(bucketName, password, hostaddress)
 
-	err = b.Set(key, 0, v)
+	err := b.Set(key, 0, v)
 	tc.HandleError(err, "set")
 	b.Close()
 }
 
 func SetKeyValues(keyValues tc.KeyValues, bucketName string, password string, hostaddress string) {
-	url := "http://" + bucketName + ":" + password + "@" + hostaddress
-	c, err := couchbase.Connect(url)
-	tc.HandleError(err, "connect - "+url)
-
-	p, err := c.GetPool("default")
-	tc.HandleError(err, "pool")
-
-	b, err := p.GetBucket(bucketName)
-	tc.HandleError(err, "bucket")
+	b := connectBucket(bucketName, password, hostaddress)
 
 	for key, value := range keyValues {
-		err = b.Set(key, 0, value)
+		err := b.Set(key, 0, value)
 		tc.HandleError(err, "set")
 	}
 	b.Close()
 }
 
 func Get(key string, rv interface{}, bucketName string, password string, hostaddress string) {
+	b := connectBucket(bucketName, password, hostaddress)
 
-	url := "http://" + bucketName + ":" + password + "@" + hostaddress
-
-	c, err := couchbase.Connect(url)
-	tc.HandleError(err, "connect - "+url)
-
-	p, err := c.GetPool("default")
-	tc.HandleError(err, "pool")
-
-	b, err := p.GetBucket("test")
-	tc.HandleError(err, "bucket")
-
-	err = b.Get(key, &rv)
+	err := b.Get(key, &rv)
 	tc.HandleError(err, "get")
+	b.Close()
 }
 
 func Delete(key string, bucketName string, password string, hostaddress string) {
+	b := connectBucket(bucketName, password, hostaddress)
 
-	url := "http://" + bucketName + ":" + password + "@" + hostaddress
-
-	c, err := couchbase.Connect(url)
-	tc.HandleError(err, "connect - "+url)
-
-	p, err := c.GetPool("default")
-	tc.HandleError(err, "pool")
-
-	b, err := p.GetBucket(bucketName)
-	tc.HandleError(err, "bucket")
-
-	err = b.Delete(key)
+	err := b.Delete(key)
 	tc.HandleError(err, "delete")
 	b.Close()
 }
 
 func DeleteKeys(keyValues tc.KeyValues, bucketName string, password string, hostaddress string) {
-
-	url := "http://" + bucketName + ":" + password + "@" + hostaddress
-
-	c, err := couchbase.Connect(url)
-	tc.HandleError(err, "connect - "+url)
-
-	p, err := c.GetPool("default")
-	tc.HandleError(err, "pool")
-
-	b, err := p.GetBucket(bucketName)
-	tc.HandleError(err, "bucket")
+	b := connectBucket(bucketName, password, hostaddress)
 
 	for key, _ := range keyValues {
-		err = b.Delete(key)
-		// tc.HandleError(err, "delete")
+		b.Delete(key) // keys may not exist
 	}
 	b.Close()
 }
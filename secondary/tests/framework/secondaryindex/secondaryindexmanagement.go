@@ -10,8 +10,22 @@ import (
 	"time"
 )
 
+// CreateClient for index management and scans on `server`, index
+// management address of the cluster configuration if empty. Connections
+// are authenticated as the cluster administrator when TLS is configured.
 func CreateClient(server, serviceAddr string) *qc.GsiClient {
+	conf := tc.GetClusterConfiguration()
+	if server == "" {
+		server = conf.IndexManagementAddress
+	}
 	config := c.SystemConfig.SectionConfig("queryport.client.", true)
+	if conf.TLS.Enabled {
+		config.SetValue("tls.enabled", true)
+		config.SetValue("tls.caFile", conf.TLS.CAFile)
+		config.SetValue("tls.insecureSkipVerify", conf.TLS.InsecureSkipVerify)
+		config.SetValue("username", conf.Username)
+		config.SetValue("password", c.Secret(conf.Password))
+	}
 	client, err := qc.NewGsiClient(server, config)
 	tc.HandleError(err, "Error while creating gsi client")
 	return client
@@ -90,6 +104,51 @@ func CreateSecondaryIndexWithClient(indexName, bucketName, server string, indexF
 	return err
 }
 
+// CreateDeferredIndexWithClient creates a secondary index without building
+// it, use BuildIndexesWithClient to build it.
+func CreateDeferredIndexWithClient(indexName, bucketName string, indexFields []string, client *qc.GsiClient) (uint64, error) {
+	return CreateSecondaryIndexWithPlan(indexName, bucketName, indexFields, &qc.IndexPlan{DeferBuild: true}, client)
+}
+
+// CreateSecondaryIndexWithPlan creates a secondary index deployed as per
+// `plan` and, unless its build is deferred, waits till it is active.
+func CreateSecondaryIndexWithPlan(indexName, bucketName string, indexFields []string, plan *qc.IndexPlan, client *qc.GsiClient) (uint64, error) {
+	var secExprs []string
+	for _, indexField := range indexFields {
+		expr, err := n1ql.ParseExpression(indexField)
+		if err != nil {
+			fmt.Printf("Creating index %v. Error while parsing the expression (%v) : %v\n", indexName, indexField, err)
+		}
+
+		secExprs = append(secExprs, expression.NewStringer().Visit(expr))
+	}
+
+	defnID, err := client.CreateIndexWithPlan(indexName, bucketName, "gsi", "N1QL", "", "", secExprs, false, plan)
+	if err != nil {
+		return defnID, err
+	}
+	if plan != nil && plan.DeferBuild {
+		fmt.Printf("Created the deferred secondary index %v\n", indexName)
+		return defnID, nil
+	}
+	fmt.Printf("Created the secondary index %v\n", indexName)
+	return defnID, WaitTillIndexActive(defnID, client)
+}
+
+// BuildIndexesWithClient builds deferred indexes and waits till they are
+// active.
+func BuildIndexesWithClient(defnIDs []uint64, client *qc.GsiClient) error {
+	if err := client.BuildIndexes(defnIDs); err != nil {
+		return err
+	}
+	for _, defnID := range defnIDs {
+		if err := WaitTillIndexActive(defnID, client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func WaitTillIndexActive(defnID uint64, client *qc.GsiClient) error {
 	for {
 		state, e := client.IndexState(defnID)
@@ -4,8 +4,69 @@ import (
 	c "github.com/couchbase/indexing/secondary/common"
 	qc "github.com/couchbase/indexing/secondary/queryport/client"
 	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
+	"strings"
 )
 
+// Errors a scan can fail with while indexes are created, built or dropped
+// concurrently, the scan can be retried. Error strings returned by indexer
+// are defined in indexer/scan_coordinator.go.
+var retryableScanErrors = []string{
+	qc.ErrorIndexNotFound.Error(),
+	qc.ErrorInstanceNotFound.Error(),
+	qc.ErrorIndexNotReady.Error(),
+	qc.ErrorNoHost.Error(),
+	qc.ErrorPoolTimeout.Error(),
+	c.ErrorSnapshotNotReady.Error(),
+	c.ErrorServerBusy.Error(),
+	"Index not found",
+	"Not my index",
+	"Index not ready",
+	"Index scan timed out",
+}
+
+// IsRetryableScanError returns whether a scan failed with a well-defined
+// error that can be retried.
+func IsRetryableScanError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, msg := range retryableScanErrors {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// RangeWithDefnID scans index by its definition id, so that an index can
+// be scanned after it is dropped. Unlike RangeWithClient, connection
+// errors are returned to the caller.
+func RangeWithDefnID(defnID uint64, low, high []interface{}, inclusion uint32,
+	distinct bool, limit int64, client *qc.GsiClient) (tc.ScanResponse, error) {
+	c.LogIgnore()
+	var scanErr error
+	scanResults := make(tc.ScanResponse)
+	connErr := client.Range(defnID, c.SecondaryKey(low), c.SecondaryKey(high), qc.Inclusion(inclusion), distinct, limit, func(response qc.ResponseReader) bool {
+		if err := response.Error(); err != nil {
+			scanErr = err
+			return false
+		} else if skeys, pkeys, err := response.GetEntries(); err != nil {
+			scanErr = err
+			return false
+		} else {
+			for i, skey := range skeys {
+				scanResults[string(pkeys[i])] = skey
+			}
+			return true
+		}
+	})
+
+	if connErr != nil {
+		return scanResults, connErr
+	}
+	return scanResults, scanErr
+}
+
 // var scanResults tc.ScanResponse
 
 func RangeWithClient(indexName, bucketName, server string, low, high []interface{}, inclusion uint32,
@@ -0,0 +1,157 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
+	"github.com/couchbase/indexing/secondary/tests/framework/expected"
+	kv "github.com/couchbase/indexing/secondary/tests/framework/kvutility"
+	"github.com/couchbase/indexing/secondary/tests/framework/secondaryindex"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// MutationChecker mutates a tracked set of documents in KV and verifies
+// the entries of an index, docid by docid, against entries computed
+// in-memory using the same expression evaluation as projector.
+type MutationChecker struct {
+	indexName   string
+	bucketName  string
+	password    string
+	kvaddress   string
+	scanAddress string
+
+	index *expected.Index
+	docs  tc.KeyValues // docid -> document, nil for deleted documents
+}
+
+// Discrepancy between expected and actual index entry for a docid.
+type Discrepancy struct {
+	Docid    string
+	Expected []interface{} // nil when docid is not expected in index
+	Actual   []interface{} // nil when docid is not found in index
+}
+
+func (d Discrepancy) String() string {
+	switch {
+	case d.Actual == nil:
+		return fmt.Sprintf("%v: missing in index, expected %v", d.Docid, d.Expected)
+	case d.Expected == nil:
+		return fmt.Sprintf("%v: unexpected in index, found %v", d.Docid, d.Actual)
+	}
+	return fmt.Sprintf("%v: expected %v, found %v", d.Docid, d.Expected, d.Actual)
+}
+
+// NewMutationChecker for index created on `indexFields`, as passed to
+// secondaryindex.CreateSecondaryIndex.
+func NewMutationChecker(indexName, bucketName, password, kvaddress, scanAddress string,
+	indexFields []string) (*MutationChecker, error) {
+
+	if len(indexFields) == 0 {
+		return nil, errors.New("Mutation checker does not support primary index")
+	}
+	index, err := expected.NewIndex(indexFields, "")
+	if err != nil {
+		return nil, err
+	}
+
+	mc := &MutationChecker{
+		indexName:   indexName,
+		bucketName:  bucketName,
+		password:    password,
+		kvaddress:   kvaddress,
+		scanAddress: scanAddress,
+		index:       index,
+		docs:        make(tc.KeyValues),
+	}
+	return mc, nil
+}
+
+// Track documents that are already loaded in KV.
+func (mc *MutationChecker) Track(docs tc.KeyValues) {
+	for key, value := range docs {
+		mc.docs[key] = value
+	}
+}
+
+// Set documents in KV, creating or updating them, and track them.
+func (mc *MutationChecker) Set(docs tc.KeyValues) {
+	kv.SetKeyValues(docs, mc.bucketName, mc.password, mc.kvaddress)
+	mc.Track(docs)
+}
+
+// Delete documents from KV, deleted documents are tracked to verify that
+// their entries are removed from index.
+func (mc *MutationChecker) Delete(docids []string) {
+	keyValues := make(tc.KeyValues)
+	for _, docid := range docids {
+		keyValues[docid] = mc.docs[docid]
+	}
+	kv.DeleteKeys(keyValues, mc.bucketName, mc.password, mc.kvaddress)
+	for _, docid := range docids {
+		mc.docs[docid] = nil
+	}
+}
+
+// ExpectedEntries computes the secondary key for each tracked document,
+// documents not expected in index are skipped.
+func (mc *MutationChecker) ExpectedEntries() (tc.ScanResponse, error) {
+	return mc.index.Entries(mc.docs)
+}
+
+// Check scans the index and returns discrepancies, sorted by docid, for
+// tracked documents. Entries for documents not tracked are ignored.
+func (mc *MutationChecker) Check(limit int64) ([]Discrepancy, error) {
+	expected, err := mc.ExpectedEntries()
+	if err != nil {
+		return nil, err
+	}
+	actual, err := secondaryindex.ScanAll(mc.indexName, mc.bucketName, mc.scanAddress, limit)
+	if err != nil {
+		return nil, err
+	}
+	return mc.discrepancies(expected, actual), nil
+}
+
+// WaitCheck repeats Check until index has caught up with the mutations,
+// that is there are no discrepancies, or until `timeout`. Discrepancies
+// of the last check are returned.
+func (mc *MutationChecker) WaitCheck(limit int64, timeout time.Duration) ([]Discrepancy, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		discrepancies, err := mc.Check(limit)
+		if err != nil || len(discrepancies) == 0 || time.Now().After(deadline) {
+			return discrepancies, err
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// discrepancies between expected and actual entries of tracked documents,
+// sorted by docid.
+func (mc *MutationChecker) discrepancies(expected, actual tc.ScanResponse) []Discrepancy {
+	docids := make([]string, 0, len(mc.docs))
+	for docid := range mc.docs {
+		docids = append(docids, docid)
+	}
+	sort.Strings(docids)
+
+	discrepancies := make([]Discrepancy, 0)
+	for _, docid := range docids {
+		e, eok := expected[docid]
+		a, aok := actual[docid]
+		if eok != aok || (eok && !reflect.DeepEqual(e, a)) {
+			discrepancies = append(discrepancies, Discrepancy{docid, e, a})
+		}
+	}
+	return discrepancies
+}
+
+// PrintDiscrepancies one per line, like PrintScanResults.
+func PrintDiscrepancies(discrepancies []Discrepancy) {
+	fmt.Printf("Count of discrepancies is %d\n", len(discrepancies))
+	for _, d := range discrepancies {
+		fmt.Println(d)
+	}
+}
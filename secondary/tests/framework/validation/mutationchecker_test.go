@@ -0,0 +1,75 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+
+	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
+)
+
+func TestMutationCheckerDiscrepancies(t *testing.T) {
+	mc, err := NewMutationChecker("index_age", "default", "", "", "", []string{"age"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc.Track(tc.KeyValues{
+		"User1": map[string]interface{}{"age": 30.0},
+		"User2": map[string]interface{}{"age": 40.0},
+		"User3": map[string]interface{}{"name": "noage"},
+		"User4": map[string]interface{}{"age": 50.0},
+		"User5": nil, // deleted
+	})
+
+	expected, err := mc.ExpectedEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := tc.ScanResponse{
+		"User1": []interface{}{30.0},
+		"User2": []interface{}{40.0},
+		"User4": []interface{}{50.0},
+	}
+	if !reflect.DeepEqual(expected, ref) {
+		t.Fatalf("expected %v, got %v", ref, expected)
+	}
+	if out := mc.discrepancies(expected, expected); len(out) != 0 {
+		t.Fatalf("unexpected discrepancies %v", out)
+	}
+
+	actual := tc.ScanResponse{
+		"User1": []interface{}{30.0},
+		"User2": []interface{}{41.0}, // stale update
+		"User3": []interface{}{nil},  // not expected in index
+		"User5": []interface{}{60.0}, // deletion not applied
+		"Other": []interface{}{70.0}, // not tracked
+	}
+	refd := []Discrepancy{
+		{"User2", []interface{}{40.0}, []interface{}{41.0}},
+		{"User3", nil, []interface{}{nil}},
+		{"User4", []interface{}{50.0}, nil},
+		{"User5", nil, []interface{}{60.0}},
+	}
+	if out := mc.discrepancies(expected, actual); !reflect.DeepEqual(out, refd) {
+		t.Fatalf("expected %v, got %v", refd, out)
+	}
+
+	if _, err := NewMutationChecker("index_primary", "default", "", "", "", nil); err == nil {
+		t.Fatalf("expected primary index to fail")
+	}
+}
+
+func TestDiscrepancyString(t *testing.T) {
+	tcs := []struct {
+		d   Discrepancy
+		ref string
+	}{
+		{Discrepancy{"User1", []interface{}{30}, nil}, "User1: missing in index, expected [30]"},
+		{Discrepancy{"User2", nil, []interface{}{40}}, "User2: unexpected in index, found [40]"},
+		{Discrepancy{"User3", []interface{}{30}, []interface{}{40}}, "User3: expected [30], found [40]"},
+	}
+	for _, tcase := range tcs {
+		if s := tcase.d.String(); s != tcase.ref {
+			t.Errorf("expected %q, got %q", tcase.ref, s)
+		}
+	}
+}
@@ -2,7 +2,7 @@ package functionaltests
 
 import (
 	"fmt"
-	"github.com/couchbase/indexing/secondary/tests/framework/datautility"
+	"github.com/couchbase/indexing/secondary/tests/framework/expected"
 	"github.com/couchbase/indexing/secondary/tests/framework/secondaryindex"
 	tv "github.com/couchbase/indexing/secondary/tests/framework/validation"
 	"testing"
@@ -24,7 +24,7 @@ func TestThreeIndexCreates(t *testing.T) {
 	CreateDocs(100)
 	time.Sleep(15 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "balance", "$1", "$2", 2)
+	docScanResults := expected.Range(docs, "balance", "$1", "$2", 2)
 	scanResults, err := secondaryindex.Range(i1, bucketName, indexScanAddress, []interface{}{"$1"}, []interface{}{"$2"}, 2, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -37,7 +37,7 @@ func TestThreeIndexCreates(t *testing.T) {
 	CreateDocs(100)
 	time.Sleep(15 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults = datautility.ExpectedScanResponse_string(docs, "email", "p", "w", 1)
+	docScanResults = expected.Range(docs, "email", "p", "w", 1)
 	scanResults, err = secondaryindex.Range(i2, bucketName, indexScanAddress, []interface{}{"p"}, []interface{}{"w"}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -50,7 +50,7 @@ func TestThreeIndexCreates(t *testing.T) {
 	DeleteDocs(150)
 	time.Sleep(15 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "address.pin", 2222, 5555, 3)
+	docScanResults = expected.Range(docs, "address.pin", 2222, 5555, 3)
 	scanResults, err = secondaryindex.Range(i3, bucketName, indexScanAddress, []interface{}{2222}, []interface{}{5555}, 3, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -79,7 +79,7 @@ func TestMultipleIndexCreatesDropsWithMutations(t *testing.T) {
 	CreateDocs(100)
 	time.Sleep(15 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "address.street", "F", "X", 2)
+	docScanResults := expected.Range(docs, "address.street", "F", "X", 2)
 	scanResults, err := secondaryindex.Range(i1, bucketName, indexScanAddress, []interface{}{"F"}, []interface{}{"X"}, 2, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -92,7 +92,7 @@ func TestMultipleIndexCreatesDropsWithMutations(t *testing.T) {
 	CreateDocs(100)
 	time.Sleep(15 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults = datautility.ExpectedScanResponse_string(docs, "registered", "2014-01", "2014-09", 1)
+	docScanResults = expected.Range(docs, "registered", "2014-01", "2014-09", 1)
 	scanResults, err = secondaryindex.Range(i2, bucketName, indexScanAddress, []interface{}{"2014-01"}, []interface{}{"2014-09"}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -105,7 +105,7 @@ func TestMultipleIndexCreatesDropsWithMutations(t *testing.T) {
 	CreateDocs(100)
 	time.Sleep(15 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults = datautility.ExpectedScanResponse_string(docs, "gender", "male", "male", 3)
+	docScanResults = expected.Range(docs, "gender", "male", "male", 3)
 	scanResults, err = secondaryindex.Lookup(i3, bucketName, indexScanAddress, []interface{}{"male"}, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -123,7 +123,7 @@ func TestMultipleIndexCreatesDropsWithMutations(t *testing.T) {
 	DeleteDocs(150)
 	time.Sleep(15 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults = datautility.ExpectedScanResponse_string(docs, "address.street", "F", "X", 2)
+	docScanResults = expected.Range(docs, "address.street", "F", "X", 2)
 	scanResults, err = secondaryindex.Range(i1, bucketName, indexScanAddress, []interface{}{"F"}, []interface{}{"X"}, 2, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -136,7 +136,7 @@ func TestMultipleIndexCreatesDropsWithMutations(t *testing.T) {
 	CreateDocs(100)
 	time.Sleep(15 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "longitude", -50, 200, 3)
+	docScanResults = expected.Range(docs, "longitude", -50, 200, 3)
 	scanResults, err = secondaryindex.Range(i4, bucketName, indexScanAddress, []interface{}{-50}, []interface{}{200}, 3, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -150,14 +150,14 @@ func TestCreateDropScan(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"company"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "company", "FI", "SR", 1)
+	docScanResults := expected.Range(docs, "company", "FI", "SR", 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"FI"}, []interface{}{"SR"}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan 1", t)
 	tv.Validate(docScanResults, scanResults)
 
 	err = secondaryindex.DropSecondaryIndex(indexName, bucketName, indexManagementAddress)
 
-	docScanResults = datautility.ExpectedScanResponse_string(docs, "company", "BIOSPAN", "ZILLANET", 1)
+	docScanResults = expected.Range(docs, "company", "BIOSPAN", "ZILLANET", 1)
 	scanResults, e := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"BIOSPAN"}, []interface{}{"ZILLANET"}, 1, true, defaultlimit)
 	if e == nil {
 		t.Fatal("Error excpected when scanning for dropped index but scan didnt fail \n")
@@ -174,14 +174,14 @@ func TestCreateDropCreate(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"company"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "company", "FI", "SR", 2)
+	docScanResults := expected.Range(docs, "company", "FI", "SR", 2)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"FI"}, []interface{}{"SR"}, 2, true, defaultlimit)
 	FailTestIfError(err, "Error in scan 1", t)
 	tv.Validate(docScanResults, scanResults)
 
 	err = secondaryindex.DropSecondaryIndex(indexName, bucketName, indexManagementAddress)
 
-	docScanResults = datautility.ExpectedScanResponse_string(docs, "company", "BIOSPAN", "ZILLANET", 0)
+	docScanResults = expected.Range(docs, "company", "BIOSPAN", "ZILLANET", 0)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"BIOSPAN"}, []interface{}{"ZILLANET"}, 0, true, defaultlimit)
 	if err == nil {
 		t.Fatal("Error excpected when scanning for dropped index but scan didnt fail \n")
@@ -192,7 +192,7 @@ func TestCreateDropCreate(t *testing.T) {
 	err = secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"company"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults = datautility.ExpectedScanResponse_string(docs, "company", "FI", "SR", 1)
+	docScanResults = expected.Range(docs, "company", "FI", "SR", 1)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"FI"}, []interface{}{"SR"}, 3, true, defaultlimit)
 	FailTestIfError(err, "Error in scan 2", t)
 	tv.Validate(docScanResults, scanResults)
@@ -209,19 +209,19 @@ func TestCreate2Drop1Scan2(t *testing.T) {
 	err = secondaryindex.CreateSecondaryIndex(index2, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "company", "FI", "SR", 1)
+	docScanResults := expected.Range(docs, "company", "FI", "SR", 1)
 	scanResults, err := secondaryindex.Range(index1, bucketName, indexScanAddress, []interface{}{"FI"}, []interface{}{"SR"}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan 1", t)
 	tv.Validate(docScanResults, scanResults)
 
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "age", 30, 50, 1)
+	docScanResults = expected.Range(docs, "age", 30, 50, 1)
 	scanResults, err = secondaryindex.Range(index2, bucketName, indexScanAddress, []interface{}{30}, []interface{}{50}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan 2", t)
 	tv.Validate(docScanResults, scanResults)
 
 	err = secondaryindex.DropSecondaryIndex(index1, bucketName, indexManagementAddress)
 
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "age", 0, 60, 1)
+	docScanResults = expected.Range(docs, "age", 0, 60, 1)
 	scanResults, err = secondaryindex.Range(index2, bucketName, indexScanAddress, []interface{}{0}, []interface{}{60}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan 2", t)
 	tv.Validate(docScanResults, scanResults)
@@ -235,7 +235,7 @@ func TestIndexNameCaseSensitivity(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "age", 35, 40, 1)
+	docScanResults := expected.Range(docs, "age", 35, 40, 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{35}, []interface{}{40}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -7,6 +7,7 @@ import (
 	"github.com/couchbase/cbauth"
 	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
 	"github.com/couchbase/indexing/secondary/tests/framework/datautility"
+	"github.com/couchbase/indexing/secondary/tests/framework/expected"
 	"github.com/couchbase/indexing/secondary/tests/framework/kvutility"
 	"github.com/couchbase/indexing/secondary/tests/framework/secondaryindex"
 	tv "github.com/couchbase/indexing/secondary/tests/framework/validation"
@@ -29,8 +30,8 @@ func init() {
 	flag.Parse()
 	clusterconfig = tc.GetClusterConfFromFile(configpath)
 	kvaddress = clusterconfig.KVAddress
-	indexManagementAddress = clusterconfig.KVAddress
-	indexScanAddress = clusterconfig.KVAddress
+	indexManagementAddress = clusterconfig.IndexManagementAddress
+	indexScanAddress = clusterconfig.IndexScanAddress
 
 	// setup cbauth
 	if _, err := cbauth.InternalRetryDefaultInit(kvaddress, clusterconfig.Username, clusterconfig.Password); err != nil {
@@ -63,7 +64,7 @@ func init() {
 	fmt.Println("Populating the default bucket")
 	kvutility.SetKeyValues(docs, "default", "", clusterconfig.KVAddress)
 	time.Sleep(10 * time.Second) // Sleep for mutations to catch up
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "eyeColor", "b", "c", 3)
+	docScanResults := expected.Range(docs, "eyeColor", "b", "c", 3)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"b"}, []interface{}{"c"}, 3, true, defaultlimit)
 	tc.HandleError(err, "Error in scan")
 	tv.Validate(docScanResults, scanResults)
@@ -78,7 +79,7 @@ func TestSimpleIndex_FloatDataType(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "age", 35, 40, 1)
+	docScanResults := expected.Range(docs, "age", 35, 40, 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{35}, []interface{}{40}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -93,12 +94,12 @@ func TestSimpleIndex_StringDataType(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"company"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "company", "G", "M", 1)
+	docScanResults := expected.Range(docs, "company", "G", "M", 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"G"}, []interface{}{"M"}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan 1", t)
 	tv.Validate(docScanResults, scanResults)
 
-	docScanResults = datautility.ExpectedScanResponse_string(docs, "company", "BIOSPAN", "ZILLANET", 1)
+	docScanResults = expected.Range(docs, "company", "BIOSPAN", "ZILLANET", 1)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"BIOSPAN"}, []interface{}{"ZILLANET"}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan 2", t)
 	tv.Validate(docScanResults, scanResults)
@@ -113,12 +114,12 @@ func TestSimpleIndex_FieldValueCaseSensitivity(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"company"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "company", "B", "C", 1)
+	docScanResults := expected.Range(docs, "company", "B", "C", 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"B"}, []interface{}{"C"}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan 1", t)
 	tv.Validate(docScanResults, scanResults)
 
-	docScanResults = datautility.ExpectedScanResponse_string(docs, "company", "B", "c", 1)
+	docScanResults = expected.Range(docs, "company", "B", "c", 1)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"B"}, []interface{}{"c"}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan 2", t)
 	tv.Validate(docScanResults, scanResults)
@@ -133,7 +134,7 @@ func TestSimpleIndex_BoolDataType(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"isActive"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_bool(docs, "isActive", true, 3)
+	docScanResults := expected.Lookup(docs, "isActive", true)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{true}, []interface{}{true}, 3, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -147,7 +148,7 @@ func TestBasicLookup(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"company"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "company", "BIOSPAN", "BIOSPAN", 3)
+	docScanResults := expected.Range(docs, "company", "BIOSPAN", "BIOSPAN", 3)
 	scanResults, err := secondaryindex.Lookup(indexName, bucketName, indexScanAddress, []interface{}{"BIOSPAN"}, true, 10000000)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -161,7 +162,7 @@ func TestIndexOnNonExistentField(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"height"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "height", 6.0, 6.5, 1)
+	docScanResults := expected.Range(docs, "height", 6.0, 6.5, 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{6.0}, []interface{}{6.5}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -175,7 +176,7 @@ func TestIndexPartiallyMissingField(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"nationality"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "nationality", "A", "z", 1)
+	docScanResults := expected.Range(docs, "nationality", "A", "z", 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"A"}, []interface{}{"z"}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -190,7 +191,7 @@ func TestScanNonMatchingDatatype(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "age", "35", "40", 1)
+	docScanResults := expected.Range(docs, "age", "35", "40", 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"35"}, []interface{}{"40"}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -207,7 +208,7 @@ func TestInclusionNeither(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "age", 32, 36, 0)
+	docScanResults := expected.Range(docs, "age", 32, 36, 0)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{32}, []interface{}{36}, 0, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -222,7 +223,7 @@ func TestInclusionLow(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "age", 32, 36, 1)
+	docScanResults := expected.Range(docs, "age", 32, 36, 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{32}, []interface{}{36}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -237,7 +238,7 @@ func TestInclusionHigh(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "age", 32, 36, 2)
+	docScanResults := expected.Range(docs, "age", 32, 36, 2)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{32}, []interface{}{36}, 2, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -252,7 +253,7 @@ func TestInclusionBoth(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "age", 32, 36, 3)
+	docScanResults := expected.Range(docs, "age", 32, 36, 3)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{32}, []interface{}{36}, 3, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -266,7 +267,7 @@ func TestNestedIndex_String(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"address.streetaddress.streetname"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "address.streetaddress.streetname", "A", "z", 3)
+	docScanResults := expected.Range(docs, "address.streetaddress.streetname", "A", "z", 3)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{"A"}, []interface{}{"z"}, 3, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -280,7 +281,7 @@ func TestNestedIndex_Float(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"address.streetaddress.floor"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "address.streetaddress.floor", 3, 6, 3)
+	docScanResults := expected.Range(docs, "address.streetaddress.floor", 3, 6, 3)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{3}, []interface{}{6}, 3, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -294,7 +295,7 @@ func TestNestedIndex_Bool(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"address.isresidential"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_bool(docs, "address.isresidential", false, 3)
+	docScanResults := expected.Lookup(docs, "address.isresidential", false)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{false}, []interface{}{false}, 3, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -313,7 +314,7 @@ func TestLookupJsonObject(t *testing.T) {
 		"floor":        5.0,
 		"buildingname": "Sterling Heights",
 		"streetname":   "Hill Street"}
-	docScanResults := datautility.ExpectedLookupResponse_json(docs, "address.streetaddress", value)
+	docScanResults := expected.Lookup(docs, "address.streetaddress", value)
 	scanResults, err := secondaryindex.Lookup(indexName, bucketName, indexScanAddress, []interface{}{value}, true, defaultlimit)
 	tc.PrintScanResults(docScanResults, "docScanResults")
 	tc.PrintScanResults(scanResults, "scanResults")
@@ -334,7 +335,7 @@ func TestLookupObjDifferentOrdering(t *testing.T) {
 		"streetname":   "Hill Street",
 		"buildingname": "Sterling Heights",
 		"doornumber":   "12B"}
-	docScanResults := datautility.ExpectedLookupResponse_json(docs, "address.streetaddress", value)
+	docScanResults := expected.Lookup(docs, "address.streetaddress", value)
 	scanResults, err := secondaryindex.Lookup(indexName, bucketName, indexScanAddress, []interface{}{value}, true, defaultlimit)
 	tc.PrintScanResults(docScanResults, "docScanResults")
 	tc.PrintScanResults(scanResults, "scanResults")
@@ -390,14 +391,14 @@ func TestLookupFloatDiffForms(t *testing.T) {
 
 	// Scan 1
 	fmt.Println("Scan 1")
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "latitude", -13, 70, 1)
+	docScanResults := expected.Range(docs, "latitude", -13, 70, 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{-13}, []interface{}{70}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
 
 	// Scan 2
 	fmt.Println("Scan 2")
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "latitude", 4.112783, 4.112783, 3)
+	docScanResults = expected.Range(docs, "latitude", 4.112783, 4.112783, 3)
 	scanResults, err = secondaryindex.Lookup(indexName, bucketName, indexScanAddress, []interface{}{4.112783}, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -416,14 +417,14 @@ func TestLookupFloatDiffForms(t *testing.T) {
 
 	// Scan 5
 	fmt.Println("Scan 5")
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "latitude", 4.112783000, 4.112783000, 3)
+	docScanResults = expected.Range(docs, "latitude", 4.112783000, 4.112783000, 3)
 	scanResults, err = secondaryindex.Lookup(indexName, bucketName, indexScanAddress, []interface{}{4.112783000}, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
 
 	// Scan 6
 	fmt.Println("Scan 6")
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "latitude", 4.112783333, 4.112783333, 3)
+	docScanResults = expected.Range(docs, "latitude", 4.112783333, 4.112783333, 3)
 	scanResults, err = secondaryindex.Lookup(indexName, bucketName, indexScanAddress, []interface{}{4.112783333}, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -439,42 +440,42 @@ func TestRangeFloatInclVariations(t *testing.T) {
 
 	// Scan 1. Value close to  -67.373265, Inclusion 0
 	fmt.Println("Scan 1")
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "latitude", -67.373365, -67.373165, 0)
+	docScanResults := expected.Range(docs, "latitude", -67.373365, -67.373165, 0)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{-67.373365}, []interface{}{-67.373165}, 0, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
 
 	// Scan 2. Value close to  -67.373265, Inclusion 1 ( >= low && < high) (val < low && val < high : Expected 0 result)
 	fmt.Println("Scan 2")
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "latitude", -67.3732649999, -67.373264, 1)
+	docScanResults = expected.Range(docs, "latitude", -67.3732649999, -67.373264, 1)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{-67.3732649999}, []interface{}{-67.373264}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
 
 	// Scan 3. Value close to  -67.373265, Inclusion 2 ( > low && <= high) (val > low && val > high: Expect 0 result)
 	fmt.Println("Scan 3")
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "latitude", -67.373265999, -67.37326500001, 2)
+	docScanResults = expected.Range(docs, "latitude", -67.373265999, -67.37326500001, 2)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{-67.373265999}, []interface{}{-67.37326500001}, 2, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
 
 	// Scan 4. Value close to  -67.373265, Inclusion 2 ( > low && <= high) ( val > low && val < high: Expect 1 result)
 	fmt.Println("Scan 4")
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "latitude", -67.37326500001, -67.3732649999, 2)
+	docScanResults = expected.Range(docs, "latitude", -67.37326500001, -67.3732649999, 2)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{-67.37326500001}, []interface{}{-67.3732649999}, 2, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
 
 	// Scan 5. Value close to  -67.373265, Inclusion 3 ( val == low && val < high : Expect 1 result)
 	fmt.Println("Scan 5")
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "latitude", -67.373265, -67.3732649999, 3)
+	docScanResults = expected.Range(docs, "latitude", -67.373265, -67.3732649999, 3)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{-67.373265}, []interface{}{-67.3732649999}, 3, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
 
 	// Scan 6. Value close to  -67.373265, Inclusion 3 ( val == low && val > high : Expect 0 results)
 	fmt.Println("Scan 6")
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "latitude", -67.373265, -67.37326500001, 3)
+	docScanResults = expected.Range(docs, "latitude", -67.373265, -67.37326500001, 3)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{-67.373265}, []interface{}{-67.37326500001}, 3, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -488,7 +489,7 @@ func TestScanAll(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(index1, bucketName, indexManagementAddress, []string{"name"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "name", "A", "z", 3)
+	docScanResults := expected.Range(docs, "name", "A", "z", 3)
 	fmt.Println("Length of docScanResults = ", len(docScanResults))
 	scanResults, err := secondaryindex.ScanAll(index1, bucketName, indexScanAddress, defaultlimit)
 	fmt.Println("Length of scanResults = ", len(scanResults))
@@ -504,7 +505,7 @@ func TestScanAllNestedField(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(index1, bucketName, indexManagementAddress, []string{"address.streetaddress.streetname"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_string(docs, "address.streetaddress.streetname", "A", "z", 3)
+	docScanResults := expected.Range(docs, "address.streetaddress.streetname", "A", "z", 3)
 	fmt.Println("Length of docScanResults = ", len(docScanResults))
 	scanResults, err := secondaryindex.ScanAll(index1, bucketName, indexScanAddress, defaultlimit)
 	fmt.Println("Length of scanResults = ", len(scanResults))
@@ -520,7 +521,7 @@ func TestBasicPrimaryIndex(t *testing.T) {
 	err := secondaryindex.CreatePrimaryIndex(indexName, bucketName, indexManagementAddress, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	// docScanResults := datautility.ExpectedScanResponse_float64(docs, "latitude", -67.373365, -67.373165, 0)
+	// docScanResults := expected.Range(docs, "latitude", -67.373365, -67.373165, 0)
 	scanResults, err := secondaryindex.ScanAll(indexName, bucketName, indexScanAddress, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	if len(scanResults) != len(docs) {
@@ -538,7 +539,7 @@ func TestBasicNullDataType(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"email"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedLookupResponse_nil(docs, "email")
+	docScanResults := expected.Lookup(docs, "email", nil)
 	scanResults, err := secondaryindex.Lookup(indexName, bucketName, indexScanAddress, []interface{}{nil}, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -554,7 +555,7 @@ func TestBasicArrayDataType_ScanAll(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"tags"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanAllResponse(docs, "tags")
+	docScanResults := expected.ScanAll(docs, "tags")
 	scanResults, err := secondaryindex.ScanAll(indexName, bucketName, indexScanAddress, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	tv.Validate(docScanResults, scanResults)
@@ -3,7 +3,7 @@ package functionaltests
 import (
 	"fmt"
 	tc "github.com/couchbase/indexing/secondary/tests/framework/common"
-	"github.com/couchbase/indexing/secondary/tests/framework/datautility"
+	"github.com/couchbase/indexing/secondary/tests/framework/expected"
 	kv "github.com/couchbase/indexing/secondary/tests/framework/kvutility"
 	"github.com/couchbase/indexing/secondary/tests/framework/secondaryindex"
 	tv "github.com/couchbase/indexing/secondary/tests/framework/validation"
@@ -56,7 +56,7 @@ func SkipTestCreateDocsMutation_LessDelay(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "age", 0, 90, 1)
+	docScanResults := expected.Range(docs, "age", 0, 90, 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{0}, []interface{}{90}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	fmt.Println("Len of expected and actual scan results are : ", len(docScanResults), len(scanResults))
@@ -66,7 +66,7 @@ func SkipTestCreateDocsMutation_LessDelay(t *testing.T) {
 	CreateDocs(100)
 	time.Sleep(5 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "age", 0, 90, 1)
+	docScanResults = expected.Range(docs, "age", 0, 90, 1)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{0}, []interface{}{90}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	fmt.Println("Len of expected and actual scan results are : ", len(docScanResults), len(scanResults))
@@ -82,7 +82,7 @@ func SkipTestDeleteDocsMutation_LessDelay(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "age", 0, 90, 1)
+	docScanResults := expected.Range(docs, "age", 0, 90, 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{0}, []interface{}{90}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	fmt.Println("Len of expected and actual scan results are : ", len(docScanResults), len(scanResults))
@@ -92,7 +92,7 @@ func SkipTestDeleteDocsMutation_LessDelay(t *testing.T) {
 	DeleteDocs(200)
 	time.Sleep(5 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "age", 0, 90, 1)
+	docScanResults = expected.Range(docs, "age", 0, 90, 1)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{0}, []interface{}{90}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	fmt.Println("Len of expected and actual scan results are : ", len(docScanResults), len(scanResults))
@@ -107,7 +107,7 @@ func TestCreateDocsMutation(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "age", 0, 90, 1)
+	docScanResults := expected.Range(docs, "age", 0, 90, 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{0}, []interface{}{90}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	fmt.Println("Len of expected and actual scan results are : ", len(docScanResults), len(scanResults))
@@ -117,7 +117,7 @@ func TestCreateDocsMutation(t *testing.T) {
 	CreateDocs(100)
 	time.Sleep(15 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "age", 0, 90, 1)
+	docScanResults = expected.Range(docs, "age", 0, 90, 1)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{0}, []interface{}{90}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	fmt.Println("Len of expected and actual scan results are : ", len(docScanResults), len(scanResults))
@@ -133,7 +133,7 @@ func TestDeleteDocsMutation(t *testing.T) {
 	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	docScanResults := datautility.ExpectedScanResponse_float64(docs, "age", 0, 90, 1)
+	docScanResults := expected.Range(docs, "age", 0, 90, 1)
 	scanResults, err := secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{0}, []interface{}{90}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	fmt.Println("Len of expected and actual scan results are : ", len(docScanResults), len(scanResults))
@@ -143,9 +143,82 @@ func TestDeleteDocsMutation(t *testing.T) {
 	DeleteDocs(200)
 	time.Sleep(15 * time.Second) // Wait for mutations to be updated in 2i
 	
-	docScanResults = datautility.ExpectedScanResponse_float64(docs, "age", 0, 90, 1)
+	docScanResults = expected.Range(docs, "age", 0, 90, 1)
 	scanResults, err = secondaryindex.Range(indexName, bucketName, indexScanAddress, []interface{}{0}, []interface{}{90}, 1, true, defaultlimit)
 	FailTestIfError(err, "Error in scan", t)
 	fmt.Println("Len of expected and actual scan results are : ", len(docScanResults), len(scanResults))
 	tv.Validate(docScanResults, scanResults)
-}
\ No newline at end of file
+}
+
+// Verify index entries of each mutated document against entries evaluated in-memory
+func TestDocsMutationWithChecker(t *testing.T) {
+	fmt.Println("In TestDocsMutationWithChecker()")
+	var indexName = "index_age"
+	var bucketName = "default"
+
+	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"age"}, true)
+	FailTestIfError(err, "Error in creating the index", t)
+
+	checker, err := tv.NewMutationChecker(indexName, bucketName, "", kvaddress, indexScanAddress, []string{"age"})
+	FailTestIfError(err, "Error in creating the mutation checker", t)
+	checker.Track(docs)
+
+	//Create docs mutations: Add new docs to KV
+	keysToBeSet := make(tc.KeyValues)
+	for key, value := range mut_docs {
+		if len(keysToBeSet) == 100 {
+			break
+		}
+		keysToBeSet[key] = value
+		docs[key] = value
+		delete(mut_docs, key)
+	}
+	checker.Set(keysToBeSet)
+
+	//Update docs mutations: Change the indexed field of existing docs,
+	//every tenth doc loses it and is expected to be removed from index
+	keysToBeUpdated := make(tc.KeyValues)
+	for key, value := range docs {
+		if _, ok := keysToBeSet[key]; ok {
+			continue
+		} else if len(keysToBeUpdated) == 100 {
+			break
+		}
+		doc := make(map[string]interface{})
+		for field, fieldValue := range value.(map[string]interface{}) {
+			doc[field] = fieldValue
+		}
+		if len(keysToBeUpdated)%10 == 0 {
+			delete(doc, "age")
+		} else if age, ok := doc["age"].(float64); ok {
+			doc["age"] = age + 1
+		}
+		keysToBeUpdated[key] = doc
+		docs[key] = doc
+	}
+	checker.Set(keysToBeUpdated)
+
+	//Delete docs mutations: Delete docs from KV
+	keysToBeDeleted := make([]string, 0)
+	for key, value := range docs {
+		if _, ok := keysToBeSet[key]; ok {
+			continue
+		} else if _, ok := keysToBeUpdated[key]; ok {
+			continue
+		} else if len(keysToBeDeleted) == 100 {
+			break
+		}
+		keysToBeDeleted = append(keysToBeDeleted, key)
+		delete(docs, key)
+		mut_docs[key] = value
+	}
+	checker.Delete(keysToBeDeleted)
+
+	// Wait for mutations to be updated in 2i
+	discrepancies, err := checker.WaitCheck(defaultlimit, 15*time.Second)
+	FailTestIfError(err, "Error in mutation checker", t)
+	if len(discrepancies) > 0 {
+		tv.PrintDiscrepancies(discrepancies)
+		t.Fatalf("Index %v has %d discrepancies", indexName, len(discrepancies))
+	}
+}
@@ -23,17 +23,19 @@ var defaultlimit int64 = 10000000
 var kvaddress, indexManagementAddress, indexScanAddress string
 var clusterconfig tc.ClusterConfiguration
 var proddir, bagdir string
+var scanWithDDL bool
 
 func init() {
 	fmt.Println("In init()")
 	var configpath string
 	seed = 1
 	flag.StringVar(&configpath, "cbconfig", "../config/clusterrun_conf.json", "Path of the configuration file with data about Couchbase Cluster")
+	flag.BoolVar(&scanWithDDL, "scanddl", false, "Run continuous scans while other indexes are created, built and dropped")
 	flag.Parse()
 	clusterconfig = tc.GetClusterConfFromFile(configpath)
 	kvaddress = clusterconfig.KVAddress
-	indexManagementAddress = clusterconfig.KVAddress
-	indexScanAddress = clusterconfig.KVAddress
+	indexManagementAddress = clusterconfig.IndexManagementAddress
+	indexScanAddress = clusterconfig.IndexScanAddress
 
 	// setup cbauth
 	if _, err := cbauth.InternalRetryDefaultInit(kvaddress, clusterconfig.Username, clusterconfig.Password); err != nil {
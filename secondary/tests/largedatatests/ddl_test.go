@@ -0,0 +1,159 @@
+package largedatatests
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	kv "github.com/couchbase/indexing/secondary/tests/framework/kvutility"
+	"github.com/couchbase/indexing/secondary/tests/framework/secondaryindex"
+)
+
+// droppedIndexes remembers definition ids of indexes dropped by the DDL
+// routine, to be scanned by the dropped-index scanner.
+type droppedIndexes struct {
+	mu      sync.Mutex
+	defnIDs map[uint64]string
+}
+
+func (d *droppedIndexes) add(defnID uint64, name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.defnIDs[defnID] = name
+}
+
+func (d *droppedIndexes) list() map[uint64]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defnIDs := make(map[uint64]string)
+	for defnID, name := range d.defnIDs {
+		defnIDs[defnID] = name
+	}
+	return defnIDs
+}
+
+// ScanStableIndexForDuration scans an index that is not touched by DDL,
+// failing on errors other than retryable ones and on entries that do not
+// belong to the index, index is on a string field.
+func ScanStableIndexForDuration(wg *sync.WaitGroup, seconds float64, t *testing.T, indexName, bucketName string) {
+	defer wg.Done()
+	client := secondaryindex.CreateClient(clusterconfig.KVAddress, "DDLScans")
+	defer client.Close()
+	defnID, ok := secondaryindex.GetDefnID(client, bucketName, indexName)
+	if !ok {
+		t.Errorf("Index %v not found", indexName)
+		return
+	}
+
+	start := time.Now()
+	for i := 1; time.Since(start).Seconds() < seconds; i++ {
+		scanResults, err := secondaryindex.RangeWithDefnID(uint64(defnID), []interface{}{"A"}, []interface{}{"Z"}, 3, true, defaultlimit, client)
+		if err != nil && !secondaryindex.IsRetryableScanError(err) {
+			t.Errorf("Scan on %v failed with non-retryable error: %v", indexName, err)
+			return
+		}
+		for docid, skey := range scanResults {
+			if len(skey) == 0 {
+				continue
+			} else if _, ok := skey[0].(string); !ok {
+				t.Errorf("Scan on %v returned entry %v:%v from another index", indexName, docid, skey)
+				return
+			}
+		}
+		log.Printf("%d ScanStableIndexForDuration:: Len of scanResults is: %d, err: %v\n", i, len(scanResults), err)
+	}
+}
+
+// ScanDroppedIndexesForDuration scans indexes after they are dropped,
+// failing if a scan returns entries or a non-retryable error.
+func ScanDroppedIndexesForDuration(wg *sync.WaitGroup, seconds float64, t *testing.T, dropped *droppedIndexes) {
+	defer wg.Done()
+	client := secondaryindex.CreateClient(clusterconfig.KVAddress, "DroppedScans")
+	defer client.Close()
+
+	start := time.Now()
+	for time.Since(start).Seconds() < seconds {
+		for defnID, name := range dropped.list() {
+			scanResults, err := secondaryindex.RangeWithDefnID(defnID, []interface{}{nil}, []interface{}{"~"}, 3, true, defaultlimit, client)
+			if err != nil && !secondaryindex.IsRetryableScanError(err) {
+				t.Errorf("Scan on dropped index %v (%v) failed with non-retryable error: %v", name, defnID, err)
+				return
+			} else if len(scanResults) > 0 {
+				t.Errorf("Scan on dropped index %v (%v) returned %d entries", name, defnID, len(scanResults))
+				return
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// CreateBuildDropIndexesForDuration creates an index, creates and builds a
+// deferred index, and drops both, in a loop.
+func CreateBuildDropIndexesForDuration(wg *sync.WaitGroup, seconds float64, t *testing.T, bucketName string, dropped *droppedIndexes) {
+	defer wg.Done()
+	client := secondaryindex.CreateClient(clusterconfig.KVAddress, "DDLOps")
+	defer client.Close()
+
+	var index1, index2 = "index_age", "index_firstname"
+	start := time.Now()
+	for time.Since(start).Seconds() < seconds {
+		err := secondaryindex.CreateSecondaryIndexWithClient(index1, bucketName, indexManagementAddress, []string{"age"}, false, client)
+		if err != nil {
+			t.Errorf("Error in creating the index %v: %v", index1, err)
+			return
+		}
+		defnID1, _ := secondaryindex.GetDefnID(client, bucketName, index1)
+
+		defnID2, err := secondaryindex.CreateDeferredIndexWithClient(index2, bucketName, []string{"`first-name`"}, client)
+		if err != nil {
+			t.Errorf("Error in creating the index %v: %v", index2, err)
+			return
+		}
+		if err = secondaryindex.BuildIndexesWithClient([]uint64{defnID2}, client); err != nil {
+			t.Errorf("Error in building the index %v: %v", index2, err)
+			return
+		}
+
+		for defnID, name := range map[uint64]string{uint64(defnID1): index1, defnID2: index2} {
+			if err = client.DropIndex(defnID); err != nil {
+				t.Errorf("Error in dropping the index %v: %v", name, err)
+				return
+			}
+			dropped.add(defnID, name)
+		}
+	}
+}
+
+func TestScanWithConcurrentDDL(t *testing.T) {
+	if !scanWithDDL {
+		t.Skip("Run with -scanddl to scan while indexes are created, built and dropped")
+	}
+	fmt.Println("In TestScanWithConcurrentDDL()")
+	var wg sync.WaitGroup
+	prodfile = filepath.Join(proddir, "test.prod")
+
+	fmt.Println("Generating JSON docs")
+	kvdocs = GenerateJsons(10000, seed, prodfile, bagdir)
+	seed++
+
+	fmt.Println("Setting initial JSON docs in KV")
+	kv.SetKeyValues(kvdocs, "default", "", clusterconfig.KVAddress)
+
+	var indexName = "index_company"
+	var bucketName = "default"
+
+	fmt.Println("Creating a 2i")
+	err := secondaryindex.CreateSecondaryIndex(indexName, bucketName, indexManagementAddress, []string{"company"}, true)
+	FailTestIfError(err, "Error in creating the index", t)
+
+	dropped := &droppedIndexes{defnIDs: make(map[uint64]string)}
+	wg.Add(4)
+	go CreateBuildDropIndexesForDuration(&wg, 120, t, bucketName, dropped)
+	go ScanStableIndexForDuration(&wg, 120, t, indexName, bucketName)
+	go ScanStableIndexForDuration(&wg, 120, t, indexName, bucketName)
+	go ScanDroppedIndexesForDuration(&wg, 120, t, dropped)
+	wg.Wait()
+}
@@ -161,10 +161,13 @@ func mf(err error, msg string) {
 func NewEndpointFactory(
 	cluster string, maxvbs int, econf c.Config) c.RouterEndpointFactory {
 
-	return func(topic, endpointType, addr string) (c.RouterEndpoint, error) {
+	return func(
+		topic, endpointType, addr, token string) (c.RouterEndpoint, error) {
+
 		switch endpointType {
 		case "dataport":
-			return dataport.NewRouterEndpoint(cluster, topic, addr, maxvbs, econf)
+			return dataport.NewRouterEndpoint(
+				cluster, topic, addr, token, maxvbs, econf)
 		default:
 			log.Fatal("Unknown endpoint type")
 		}
@@ -229,10 +229,13 @@ func getProjectorAdminport(cluster, pooln string) string {
 func NewEndpointFactory(
 	cluster string, maxvbs int, econf c.Config) c.RouterEndpointFactory {
 
-	return func(topic, endpointType, addr string) (c.RouterEndpoint, error) {
+	return func(
+		topic, endpointType, addr, token string) (c.RouterEndpoint, error) {
+
 		switch endpointType {
 		case "dataport":
-			return dataport.NewRouterEndpoint(cluster, topic, addr, maxvbs, econf)
+			return dataport.NewRouterEndpoint(
+				cluster, topic, addr, token, maxvbs, econf)
 		default:
 			log.Fatal("Unknown endpoint type")
 		}
@@ -254,7 +257,7 @@ func endpointCallback(addr string, msg interface{}) bool {
 					case c.Snapshot:
 						_, start, end := kv.Snapshot()
 						mutations.snapshots[bucket][vbno] = [2]uint64{start, end}
-					case c.Upsert, c.UpsertDeletion, c.Deletion:
+					case c.Upsert, c.UpsertDeletion, c.Deletion, c.Expiration:
 						mutations.seqnos[bucket][vbno] = kv.GetSeqno()
 					}
 				}
@@ -16,7 +16,11 @@ import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 
 func doBenchmark(cluster, addr string) {
 	qconf := c.SystemConfig.SectionConfig("queryport.indexer.", true)
-	s, err := queryport.NewServer(addr, serverCallb, qconf)
+	handlers := queryport.NewHandlers()
+	handlers.Register(&protobuf.StatisticsRequest{}, statisticsCallb)
+	handlers.Register(&protobuf.ScanRequest{}, scanCallb)
+	handlers.Register(&protobuf.ScanAllRequest{}, scanCallb)
+	s, err := queryport.NewServer(addr, handlers, qconf)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -81,30 +85,26 @@ loop:
 	}
 }
 
-func serverCallb(
+func statisticsCallb(
 	req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
 
-	switch req.(type) {
-	case *protobuf.StatisticsRequest:
-		resp := testStatisticsResponse
-		select {
-		case respch <- resp:
-			close(respch)
-
-		case <-quitch:
-			log.Fatal("unexpected quit", req)
-		}
-
-	case *protobuf.ScanRequest:
-		sendResponse(1, respch, quitch)
+	resp := testStatisticsResponse
+	select {
+	case respch <- resp:
 		close(respch)
 
-	case *protobuf.ScanAllRequest:
-		sendResponse(1, respch, quitch)
-		close(respch)
+	case <-quitch:
+		log.Fatal("unexpected quit", req)
 	}
 }
 
+func scanCallb(
+	req interface{}, respch chan<- interface{}, quitch <-chan interface{}) {
+
+	sendResponse(1, respch, quitch)
+	close(respch)
+}
+
 func sendResponse(
 	count int, respch chan<- interface{}, quitch <-chan interface{}) {
 
@@ -192,7 +192,13 @@ func appHandler(endpoint string, msg interface{}) bool {
 		endpoints = append(endpoints, options.endpoints...)
 		endpoints = append(endpoints, options.coordEndpoint)
 		for _, client := range projectors {
-			client.RepairEndpoints("backfill", endpoints)
+			if resp, err := client.RepairEndpoints("backfill", endpoints); err != nil {
+				fmt.Println("RepairEndpoints ....", err)
+			} else {
+				for raddr, reason := range resp.Failed() {
+					fmt.Println("RepairEndpoints ....", raddr, reason)
+				}
+			}
 			for _, ts := range tss {
 				fmt.Println("RestartVbuckets ....", endpoint, ts.Repr())
 			}
@@ -234,10 +240,13 @@ func getProjectorAdminport(cluster, pooln string) string {
 func NewEndpointFactory(
 	cluster string, maxvbs int, econf c.Config) c.RouterEndpointFactory {
 
-	return func(topic, endpointType, addr string) (c.RouterEndpoint, error) {
+	return func(
+		topic, endpointType, addr, token string) (c.RouterEndpoint, error) {
+
 		switch endpointType {
 		case "dataport":
-			return dataport.NewRouterEndpoint(cluster, topic, addr, maxvbs, econf)
+			return dataport.NewRouterEndpoint(
+				cluster, topic, addr, token, maxvbs, econf)
 		default:
 			log.Fatal("Unknown endpoint type")
 		}
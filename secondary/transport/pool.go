@@ -0,0 +1,73 @@
+// Pools of buffers, gzip writers and readers, to compress and decompress
+// packets without allocating them per packet.
+
+package transport
+
+import "bytes"
+import "compress/gzip"
+import "sync"
+
+// BufferPool of byte buffers in size classes, doubling from minSize upto
+// maxSize. Buffers that have grown larger than maxSize are not pooled.
+// Safe for concurrent use.
+type BufferPool struct {
+	minSize int
+	maxSize int
+	classes []sync.Pool
+}
+
+// NewBufferPool creates a pool of buffers in size classes from minSize
+// upto maxSize bytes.
+func NewBufferPool(minSize, maxSize int) *BufferPool {
+	p := &BufferPool{minSize: minSize, maxSize: maxSize}
+	for size := minSize; size <= maxSize; size *= 2 {
+		p.classes = append(p.classes, sync.Pool{})
+	}
+	return p
+}
+
+// Get an empty buffer with capacity for atleast `size` bytes.
+func (p *BufferPool) Get(size int) *bytes.Buffer {
+	i := p.class(size)
+	if i < 0 {
+		return bytes.NewBuffer(make([]byte, 0, size))
+	}
+	if buf, ok := p.classes[i].Get().(*bytes.Buffer); ok {
+		buf.Reset()
+		return buf
+	}
+	return bytes.NewBuffer(make([]byte, 0, p.minSize<<uint(i)))
+}
+
+// Put back a buffer obtained from Get, buffer shall not be used
+// thereafter.
+func (p *BufferPool) Put(buf *bytes.Buffer) {
+	// a buffer belongs to the largest class it can hold.
+	i := len(p.classes) - 1
+	for ; i >= 0 && buf.Cap() < p.minSize<<uint(i); i-- {
+	}
+	if i >= 0 && buf.Cap() <= p.maxSize {
+		p.classes[i].Put(buf)
+	}
+}
+
+// class of buffers that can hold `size` bytes, -1 if size is larger than
+// the largest class.
+func (p *BufferPool) class(size int) int {
+	for i, csize := 0, p.minSize; i < len(p.classes); i, csize = i+1, csize*2 {
+		if size <= csize {
+			return i
+		}
+	}
+	return -1
+}
+
+// buffers to compress and decompress packets, packets are limited by
+// maxPayload configured for the connection, which are typically ~1MB.
+var bufferPool = NewBufferPool(1024, 1024*1024)
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+var gzipReaders sync.Pool
@@ -8,10 +8,13 @@
 
 package transport
 
+import "bytes"
+import "compress/gzip"
 import "encoding/binary"
 import "errors"
 import "net"
 import "io"
+import "sync/atomic"
 
 import c "github.com/couchbase/indexing/secondary/common"
 
@@ -29,6 +32,9 @@ var ErrorEncoderUnknown = errors.New("transport.encoderUnknown")
 // ErrorDecoderUnknown for unknown decoder.
 var ErrorDecoderUnknown = errors.New("transport.decoderUnknown")
 
+// ErrorCompressionUnknown for unsupported compression.
+var ErrorCompressionUnknown = errors.New("transport.compressionUnknown")
+
 // packet field offset and size in bytes
 const (
 	pktLenOffset  int = 0
@@ -48,15 +54,41 @@ type transporter interface { // facilitates unit testing
 // TransportPacket to send and receive mutation packets between router
 // and downstream client.
 type TransportPacket struct {
-	flags    TransportFlag
-	buf      []byte
-	encoders map[byte]Encoder
-	decoders map[byte]Decoder
+	flags     TransportFlag // for sending packets
+	rflags    TransportFlag // of the last received packet
+	buf       []byte
+	encoders  map[byte]Encoder
+	bencoders map[byte]BufEncoder
+	decoders  map[byte]Decoder
+	threshold int // compress only payloads larger than this
+	cstats    *CompressionStats
+}
+
+// CompressionStats accumulate the effect of compression on packets sent,
+// it can be shared by several TransportPacket.
+type CompressionStats struct {
+	Packets         int64 // no. of packets sent compressed
+	RawBytes        int64 // size of those packets before compression
+	CompressedBytes int64 // size of those packets after compression
+}
+
+// Ratio of compressed size to raw size, 1.0 when nothing was compressed.
+func (stats *CompressionStats) Ratio() float64 {
+	raw := atomic.LoadInt64(&stats.RawBytes)
+	if raw == 0 {
+		return 1.0
+	}
+	return float64(atomic.LoadInt64(&stats.CompressedBytes)) / float64(raw)
 }
 
 // Encoder callback
 type Encoder func(payload interface{}) (data []byte, err error)
 
+// BufEncoder callback, encodes payload by appending to `buf` and returns
+// the appended slice. Packets are encoded in-place in the transport
+// buffer, without allocating per packet.
+type BufEncoder func(payload interface{}, buf []byte) (data []byte, err error)
+
 // Decoder callback
 type Decoder func(data []byte) (payload interface{}, err error)
 
@@ -70,10 +102,11 @@ type Decoder func(data []byte) (payload interface{}, err error)
 // flags,  specifying encoding and compression.
 func NewTransportPacket(maxlen int, flags TransportFlag) *TransportPacket {
 	pkt := &TransportPacket{
-		flags:    flags,
-		buf:      make([]byte, maxlen),
-		encoders: make(map[byte]Encoder),
-		decoders: make(map[byte]Decoder),
+		flags:     flags,
+		buf:       make([]byte, maxlen),
+		encoders:  make(map[byte]Encoder),
+		bencoders: make(map[byte]BufEncoder),
+		decoders:  make(map[byte]Decoder),
 	}
 	pkt.encoders[EncodingNone] = nil
 	pkt.decoders[EncodingNone] = nil
@@ -86,6 +119,38 @@ func (pkt *TransportPacket) SetEncoder(typ byte, callb Encoder) *TransportPacket
 	return pkt
 }
 
+// SetBufEncoder callback function for `type`, preferred over the
+// callback set by SetEncoder.
+func (pkt *TransportPacket) SetBufEncoder(typ byte, callb BufEncoder) *TransportPacket {
+	pkt.bencoders[typ] = callb
+	return pkt
+}
+
+// SetFlags for packets sent hereafter.
+func (pkt *TransportPacket) SetFlags(flags TransportFlag) *TransportPacket {
+	pkt.flags = flags
+	return pkt
+}
+
+// Flags of the last packet received.
+func (pkt *TransportPacket) Flags() TransportFlag {
+	return pkt.rflags
+}
+
+// SetCompressionThreshold, payloads upto `threshold` bytes are sent
+// uncompressed even when flags specify a compression.
+func (pkt *TransportPacket) SetCompressionThreshold(threshold int) *TransportPacket {
+	pkt.threshold = threshold
+	return pkt
+}
+
+// SetCompressionStats to accumulate compression statistics for packets
+// sent.
+func (pkt *TransportPacket) SetCompressionStats(stats *CompressionStats) *TransportPacket {
+	pkt.cstats = stats
+	return pkt
+}
+
 // SetDecoder callback function for `type`.
 func (pkt *TransportPacket) SetDecoder(typ byte, callb Decoder) *TransportPacket {
 	pkt.decoders[typ] = callb
@@ -102,8 +167,16 @@ func (pkt *TransportPacket) Send(conn transporter, payload interface{}) (err err
 		return
 	}
 	// compress
-	if data, err = pkt.compress(data); err != nil {
-		return
+	flags := pkt.flags
+	if flags.GetCompression() != CompressionNone && len(data) <= pkt.threshold {
+		flags = flags & TransportFlag(0xFFF0)
+	}
+	if flags.GetCompression() != CompressionNone {
+		out := bufferPool.Get(len(data))
+		defer bufferPool.Put(out)
+		if data, err = pkt.compress(flags, data, out); err != nil {
+			return
+		}
 	}
 	// transport framing
 	l := pktLenSize + pktFlagSize + len(data)
@@ -116,17 +189,15 @@ func (pkt *TransportPacket) Send(conn transporter, payload interface{}) (err err
 	a, b := pktLenOffset, pktLenOffset+pktLenSize
 	binary.BigEndian.PutUint32(pkt.buf[a:b], uint32(len(data)))
 	a, b = pktFlagOffset, pktFlagOffset+pktFlagSize
-	binary.BigEndian.PutUint16(pkt.buf[a:b], uint16(pkt.flags))
-	if n, err = conn.Write(pkt.buf[:pktDataOffset]); err == nil {
-		if n, err = conn.Write(data); err == nil && n != len(data) {
-			c.Errorf("transport wrote only %v bytes for data\n", n)
-			err = ErrorPacketWrite
-		}
+	binary.BigEndian.PutUint16(pkt.buf[a:b], uint16(flags))
+	// no-op if data was encoded in-place, and the frame is written once.
+	copy(pkt.buf[pktDataOffset:], data)
+	if n, err = conn.Write(pkt.buf[:l]); err == nil {
 		laddr, raddr := conn.LocalAddr(), conn.RemoteAddr()
 		c.Tracef("wrote %v bytes on connection %v->%v", len(data), laddr, raddr)
 
-	} else if n != pktDataOffset {
-		c.Errorf("transport wrote only %v bytes for header\n", n)
+	} else if n != l {
+		c.Errorf("transport wrote only %v bytes of %v\n", n, l)
 		err = ErrorPacketWrite
 	}
 	return
@@ -149,7 +220,7 @@ func (pkt *TransportPacket) Receive(conn transporter) (payload interface{}, err
 	a, b := pktLenOffset, pktLenOffset+pktLenSize
 	pktlen := binary.BigEndian.Uint32(pkt.buf[a:b])
 	a, b = pktFlagOffset, pktFlagOffset+pktFlagSize
-	pkt.flags = TransportFlag(binary.BigEndian.Uint16(pkt.buf[a:b]))
+	pkt.rflags = TransportFlag(binary.BigEndian.Uint16(pkt.buf[a:b]))
 	if maxLen := uint32(len(pkt.buf)); pktlen > maxLen {
 		c.Errorf("receiving packet length %v > %v\n", pktlen, maxLen)
 		err = ErrorPacketOverflow
@@ -168,9 +239,13 @@ func (pkt *TransportPacket) Receive(conn transporter) (payload interface{}, err
 	laddr, raddr := conn.LocalAddr(), conn.RemoteAddr()
 	c.Tracef("read %v bytes on connection %v<-%v", len(data), laddr, raddr)
 
-	// de-compression
-	if data, err = pkt.decompress(data); err != nil {
-		return
+	// de-compression, decoders shall copy what they retain of data.
+	if pkt.rflags.GetCompression() != CompressionNone {
+		out := bufferPool.Get(2 * len(data))
+		defer bufferPool.Put(out)
+		if data, err = pkt.decompress(data, out); err != nil {
+			return
+		}
 	}
 	// decoding
 	if payload, err = pkt.decode(data); err != nil {
@@ -183,9 +258,12 @@ func (pkt *TransportPacket) Receive(conn transporter) (payload interface{}, err
 // valid type then return `payload` as `data`.
 func (pkt *TransportPacket) encode(payload interface{}) (data []byte, err error) {
 	typ := pkt.flags.GetEncoding()
-	if callb, ok := pkt.encoders[typ]; ok {
+	if callb, ok := pkt.bencoders[typ]; ok && callb != nil {
+		return callb(payload, pkt.buf[pktDataOffset:pktDataOffset])
+	}
+	if callb, ok := pkt.encoders[typ]; ok && callb != nil {
 		return callb(payload)
-	} else if callb == nil {
+	} else if ok {
 		return payload.([]byte), nil
 	}
 	return nil, ErrorEncoderUnknown
@@ -194,31 +272,84 @@ func (pkt *TransportPacket) encode(payload interface{}) (data []byte, err error)
 // decode array of bytes back to payload, if callback was specified `nil` for
 // a valid type then return `data` as `payload`.
 func (pkt *TransportPacket) decode(data []byte) (payload interface{}, err error) {
-	typ := pkt.flags.GetEncoding()
-	if callb, ok := pkt.decoders[typ]; ok {
+	typ := pkt.rflags.GetEncoding()
+	if callb, ok := pkt.decoders[typ]; ok && callb != nil {
 		return callb(data)
-	} else if callb == nil {
+	} else if ok {
+		if pkt.rflags.GetCompression() != CompressionNone {
+			// decompressed data is in a pooled buffer.
+			data = append([]byte(nil), data...)
+		}
 		return data, nil
 	}
 	return nil, ErrorDecoderUnknown
 }
 
-// compress array of bytes.
-func (pkt *TransportPacket) compress(big []byte) (small []byte, err error) {
-	switch pkt.flags.GetCompression() {
+// compress array of bytes as specified by flags, into `out`.
+func (pkt *TransportPacket) compress(
+	flags TransportFlag, big []byte, out *bytes.Buffer) (small []byte, err error) {
+
+	switch flags.GetCompression() {
 	case CompressionNone:
-		small = big
+		return big, nil
+
+	case CompressionGzip:
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(out)
+		if _, err = w.Write(big); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+		small = out.Bytes()
+	default:
+		return nil, ErrorCompressionUnknown
 	}
-	return
+	if stats := pkt.cstats; stats != nil {
+		atomic.AddInt64(&stats.Packets, 1)
+		atomic.AddInt64(&stats.RawBytes, int64(len(big)))
+		atomic.AddInt64(&stats.CompressedBytes, int64(len(small)))
+	}
+	return small, nil
 }
 
-// decompress array of bytes.
-func (pkt *TransportPacket) decompress(small []byte) (big []byte, err error) {
-	switch pkt.flags.GetCompression() {
+// decompress array of bytes as specified by flags of received packet,
+// into `out`.
+// - return ErrorPacketOverflow if decompressed payload is larger than
+//   maximum packet size.
+func (pkt *TransportPacket) decompress(
+	small []byte, out *bytes.Buffer) (big []byte, err error) {
+
+	switch pkt.rflags.GetCompression() {
 	case CompressionNone:
-		big = small
+		return small, nil
+
+	case CompressionGzip:
+		var r *gzip.Reader
+		if r, _ = gzipReaders.Get().(*gzip.Reader); r == nil {
+			r, err = gzip.NewReader(bytes.NewReader(small))
+		} else {
+			err = r.Reset(bytes.NewReader(small))
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReaders.Put(r)
+		// decompressed payload is bound by maximum packet size, read one
+		// byte more to detect overflow.
+		maxLen := int64(len(pkt.buf))
+		if _, err = out.ReadFrom(io.LimitReader(r, maxLen+1)); err != nil {
+			return nil, err
+		}
+		if int64(out.Len()) > maxLen {
+			c.Errorf("decompressed packet length > %v\n", maxLen)
+			return nil, ErrorPacketOverflow
+		}
+		return out.Bytes(), nil
 	}
-	return
+	return nil, ErrorCompressionUnknown
 }
 
 // read len(buf) bytes from `conn`.
@@ -3,9 +3,12 @@
 //       byte|       0       |       1       |
 //           +---------------+---------------+
 //       bits|0 1 2 3 4 5 6 7|0 1 2 3 4 5 6 7|
-//           +-------+-------+---------------+  COMP. - Compression
-//          0| COMP. |  ENC. | undefined     |  ENC.  - Encoding
-//           +-------+-------+---------------+
+//           +-------+-------+-------+-------+  COMP. - Compression
+//          0| COMP. |  ENC. | ACC.  | undef.|  ENC.  - Encoding
+//           +-------+-------+-------+-------+  ACC.  - Accepted compression
+//
+// ACC. is advertised by the sender of a packet, telling the other end that
+// it can decompress packets using that compression.
 
 package transport
 
@@ -50,6 +53,17 @@ func (flags TransportFlag) SetBzip2() TransportFlag {
 	return (flags & TransportFlag(0xFFF0)) | TransportFlag(CompressionBzip2)
 }
 
+// GetAcceptCompression returns the compression accepted by the sender.
+func (flags TransportFlag) GetAcceptCompression() byte {
+	return byte((flags & TransportFlag(0x0F00)) >> 8)
+}
+
+// SetAcceptCompression will advertise that compression `typ` is accepted
+// for packets sent to us.
+func (flags TransportFlag) SetAcceptCompression(typ byte) TransportFlag {
+	return (flags & TransportFlag(0xF0FF)) | (TransportFlag(typ&0x0F) << 8)
+}
+
 // GetEncoding will get the encoding bits from flags
 func (flags TransportFlag) GetEncoding() byte {
 	return byte(flags & TransportFlag(0x00F0))
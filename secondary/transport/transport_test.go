@@ -0,0 +1,147 @@
+package transport
+
+import "bytes"
+import "net"
+import "testing"
+
+// testConn loops back packets written to it.
+type testConn struct {
+	bytes.Buffer
+	writes int
+}
+
+func (conn *testConn) Write(b []byte) (int, error) {
+	conn.writes++
+	return conn.Buffer.Write(b)
+}
+
+var testAddr = &net.TCPAddr{}
+
+func (conn *testConn) LocalAddr() net.Addr  { return testAddr }
+func (conn *testConn) RemoteAddr() net.Addr { return testAddr }
+
+func testBufEncode(payload interface{}, buf []byte) ([]byte, error) {
+	return append(buf, payload.([]byte)...), nil
+}
+
+func TestSendReceive(t *testing.T) {
+	small := []byte("small payload")
+	large := bytes.Repeat([]byte("large payload "), 1000)
+
+	for _, flags := range []TransportFlag{0, TransportFlag(0).SetGzip()} {
+		conn := &testConn{}
+		pkt := NewTransportPacket(64*1024, flags)
+		pkt.SetBufEncoder(EncodingNone, testBufEncode)
+		pkt.SetCompressionThreshold(100)
+		for _, payload := range [][]byte{small, large, small} {
+			if err := pkt.Send(conn, payload); err != nil {
+				t.Fatal(err)
+			}
+			out, err := pkt.Receive(conn)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(out.([]byte), payload) {
+				t.Fatalf("expected %q, got %q", payload, out)
+			}
+		}
+		if conn.writes != 3 {
+			t.Fatalf("expected a write per packet, got %v", conn.writes)
+		}
+	}
+
+	pkt := NewTransportPacket(100, 0)
+	pkt.SetBufEncoder(EncodingNone, testBufEncode)
+	if err := pkt.Send(&testConn{}, large); err != ErrorPacketOverflow {
+		t.Fatalf("expected %v, got %v", ErrorPacketOverflow, err)
+	}
+}
+
+func TestReceiveGzipOverflow(t *testing.T) {
+	large := bytes.Repeat([]byte("large payload "), 1000)
+
+	conn := &testConn{}
+	pkt := NewTransportPacket(64*1024, TransportFlag(0).SetGzip())
+	pkt.SetBufEncoder(EncodingNone, testBufEncode)
+	pkt.SetCompressionThreshold(100)
+	if err := pkt.Send(conn, large); err != nil {
+		t.Fatal(err)
+	}
+	// compressed packet fits, decompressed payload does not.
+	if conn.Len() >= 1024 {
+		t.Fatalf("expected payload to compress below 1024, got %v", conn.Len())
+	}
+	rpkt := NewTransportPacket(1024, TransportFlag(0).SetGzip())
+	rpkt.SetBufEncoder(EncodingNone, testBufEncode)
+	if _, err := rpkt.Receive(conn); err != ErrorPacketOverflow {
+		t.Fatalf("expected %v, got %v", ErrorPacketOverflow, err)
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	pool := NewBufferPool(1024, 8*1024)
+	if buf := pool.Get(1500); buf.Cap() < 1500 || buf.Len() != 0 {
+		t.Fatalf("unexpected buffer of cap %v len %v", buf.Cap(), buf.Len())
+	}
+	buf := pool.Get(100)
+	buf.Write(make([]byte, 3000)) // grows to a larger class
+	pool.Put(buf)
+	if buf := pool.Get(2048); buf.Cap() < 2048 || buf.Len() != 0 {
+		t.Fatalf("unexpected buffer of cap %v len %v", buf.Cap(), buf.Len())
+	}
+	if buf := pool.Get(10000); buf.Cap() < 10000 {
+		t.Fatalf("unexpected buffer of cap %v", buf.Cap())
+	}
+}
+
+// small scan response, sent on a pooled connection.
+func benchmarkSend(b *testing.B, flags TransportFlag, buffered bool) {
+	var payload interface{} = bytes.Repeat([]byte(`["aaaaa"]key`), 10)
+	conn := &testConn{}
+	pkt := NewTransportPacket(1024*1024, flags)
+	if buffered {
+		pkt.SetBufEncoder(EncodingNone, testBufEncode)
+	} else {
+		pkt.SetEncoder(EncodingNone, func(payload interface{}) ([]byte, error) {
+			return append([]byte(nil), payload.([]byte)...), nil
+		})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.Reset()
+		if err := pkt.Send(conn, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendEncoder(b *testing.B) {
+	benchmarkSend(b, 0, false)
+}
+
+func BenchmarkSendBufEncoder(b *testing.B) {
+	benchmarkSend(b, 0, true)
+}
+
+func BenchmarkSendGzip(b *testing.B) {
+	benchmarkSend(b, TransportFlag(0).SetGzip(), true)
+}
+
+func BenchmarkReceiveGzip(b *testing.B) {
+	conn := &testConn{}
+	pkt := NewTransportPacket(1024*1024, TransportFlag(0).SetGzip())
+	pkt.SetBufEncoder(EncodingNone, testBufEncode)
+	if err := pkt.Send(conn, bytes.Repeat([]byte(`["aaaaa"]key`), 10)); err != nil {
+		b.Fatal(err)
+	}
+	data := append([]byte(nil), conn.Bytes()...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.Reset()
+		conn.Buffer.Write(data)
+		if _, err := pkt.Receive(conn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}